@@ -19,6 +19,7 @@ import (
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 
+	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/gluetikv"
 	"github.com/pingcap/br/pkg/summary"
 	"github.com/pingcap/br/pkg/task"
@@ -36,6 +37,19 @@ func runBackupCommand(command *cobra.Command, cmdName string) error {
 		session.DisableStats4Test()
 	}
 
+	var fanOut task.FanOutConfig
+	if err := fanOut.ParseFromFlags(command.Flags()); err != nil {
+		command.SilenceUsage = false
+		return errors.Trace(err)
+	}
+	if fanOut.Enabled() {
+		if cfg.Cron != "" {
+			command.SilenceUsage = false
+			return errors.Annotate(berrors.ErrInvalidArgument, "the fan-out backup can't run in the cron mode")
+		}
+		return runFanOutBackup(command, cmdName, &cfg.Config, &fanOut)
+	}
+
 	if cfg.Cron != "" {
 		cr := cron.New(cron.WithSeconds())
 		_, err := cr.AddFunc(cfg.Cron, func() {
@@ -56,6 +70,7 @@ func runBackupCommand(command *cobra.Command, cmdName string) error {
 			prefix := time.Now().Format("20060102150405")
 			cfg.Storage = u.Scheme + "://" + u.Host + "/" + prefix
 			fmt.Println("Storage path:", cfg.Storage)
+			StartTask()
 			summary.InitCollector(HasLogFile())
 			if err := task.RunBackup(ctx, gluetidb.New(), cmdName, &cfg); err != nil {
 				log.Error("failed to backup", zap.Error(err))
@@ -74,6 +89,19 @@ func runBackupCommand(command *cobra.Command, cmdName string) error {
 		}
 	}
 
+	if cfg.Spec != "" {
+		spec, err := task.ReadBackupSpec(cfg.Spec)
+		if err != nil {
+			command.SilenceUsage = false
+			return errors.Trace(err)
+		}
+		if err := task.RunBackupSpec(GetDefaultContext(), tidbGlue, cmdName, &cfg, spec); err != nil {
+			log.Error("failed to backup by spec", zap.Error(err))
+			return errors.Trace(err)
+		}
+		return nil
+	}
+
 	fmt.Println("Common mode:", cfg.Cron)
 	if err := task.RunBackup(GetDefaultContext(), tidbGlue, cmdName, &cfg); err != nil {
 		log.Error("failed to backup", zap.Error(err))
@@ -88,6 +116,14 @@ func runBackupRawCommand(command *cobra.Command, cmdName string) error {
 		command.SilenceUsage = false
 		return errors.Trace(err)
 	}
+	var fanOut task.FanOutConfig
+	if err := fanOut.ParseFromFlags(command.Flags()); err != nil {
+		command.SilenceUsage = false
+		return errors.Trace(err)
+	}
+	if fanOut.Enabled() {
+		return runFanOutBackup(command, cmdName, &cfg.Config, &fanOut)
+	}
 	if err := task.RunBackupRaw(GetDefaultContext(), gluetikv.Glue{}, cmdName, &cfg); err != nil {
 		log.Error("failed to backup raw kv", zap.Error(err))
 		return errors.Trace(err)
@@ -122,9 +158,11 @@ func NewBackupCommand() *cobra.Command {
 		newDBBackupCommand(),
 		newTableBackupCommand(),
 		newRawBackupCommand(),
+		newBackupUnlockCommand(),
 	)
 
 	task.DefineBackupFlags(command.PersistentFlags())
+	task.DefineFanOutFlags(command.PersistentFlags())
 	return command
 }
 
@@ -142,6 +180,8 @@ func newFullBackupCommand() *cobra.Command {
 		},
 	}
 	task.DefineFilterFlags(command)
+	task.DefineBackupSpecFlags(command.Flags())
+	task.DefineTableTSFlags(command.Flags())
 	return command
 }
 
@@ -172,6 +212,7 @@ func newDBBackupCommand() *cobra.Command {
 		},
 	}
 	task.DefineDatabaseFlags(command)
+	task.DefineTableTSFlags(command.Flags())
 	return command
 }
 
@@ -204,3 +245,25 @@ func newRawBackupCommand() *cobra.Command {
 	task.DefineRawBackupFlags(command)
 	return command
 }
+
+// newBackupUnlockCommand return a subcommand removing the backup lock.
+func newBackupUnlockCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "unlock",
+		Short: "remove the lock of the backup destination, when the backup holding it has gone",
+		Args:  cobra.NoArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			var cfg task.Config
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				command.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			if err := task.RunBackupUnlock(GetDefaultContext(), &cfg); err != nil {
+				log.Error("failed to unlock backup", zap.Error(err))
+				return errors.Trace(err)
+			}
+			return nil
+		},
+	}
+	return command
+}
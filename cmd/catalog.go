@@ -0,0 +1,84 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/store/tikv/oracle"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+func catalogPreRun(c *cobra.Command, args []string) error {
+	if err := Init(c); err != nil {
+		return errors.Trace(err)
+	}
+	utils.LogBRInfo()
+	task.LogArguments(c)
+	return nil
+}
+
+// NewListCommand returns a list subcommand.
+func NewListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:               "list",
+		Short:             "list the backups in the catalog of the storage",
+		Args:              cobra.NoArgs,
+		SilenceUsage:      true,
+		PersistentPreRunE: catalogPreRun,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			var cfg task.Config
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				cmd.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			backups, err := task.ListBackups(ctx, &cfg)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			for _, b := range backups {
+				backupTime := oracle.GetTimeFromTS(b.EndVersion).UTC().Format(time.RFC3339)
+				// The topology of the source cluster, zeros are unknown.
+				cmd.Printf("%s\t%d\t%d\t%d\t%s\t%d\ttikv=%d,tiflash=%d,regions=%d\n",
+					b.Name, b.ClusterID, b.StartVersion, b.EndVersion, backupTime, b.Size,
+					b.TiKVStores, b.TiFlashStores, b.Regions)
+			}
+			return nil
+		},
+	}
+}
+
+// NewGCCommand returns a gc subcommand.
+func NewGCCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:               "gc",
+		Short:             "delete the backups in the catalog except the latest ones of every cluster",
+		Args:              cobra.NoArgs,
+		SilenceUsage:      true,
+		PersistentPreRunE: catalogPreRun,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg := task.GCConfig{Config: task.Config{LogProgress: HasLogFile()}}
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				cmd.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			if err := task.RunGC(GetDefaultContext(), "GC", &cfg); err != nil {
+				log.Error("failed to gc backups", zap.Error(err))
+				return errors.Trace(err)
+			}
+			return nil
+		},
+	}
+	task.DefineGCFlags(command.Flags())
+	return command
+}
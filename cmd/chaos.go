@@ -0,0 +1,174 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cmd
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/failpoint"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+const (
+	flagChaosFailpoint = "failpoint"
+	flagChaosInterval  = "chaos-interval"
+	flagChaosDuration  = "chaos-duration"
+)
+
+// defaultChaosFailpoints are the failpoints activated by `br chaos` if none is
+// specified, they cover the retry of split, external storage and ingest.
+var defaultChaosFailpoints = []string{
+	"github.com/pingcap/br/pkg/restore/not-leader-error=1*return(true)->1*return(false)",
+	"github.com/pingcap/br/pkg/storage/storage-server-error=2*return(true)",
+	"github.com/pingcap/br/pkg/restore/ingest-epoch-not-match=1*return(true)",
+}
+
+type chaosFailpoint struct {
+	path  string
+	terms string
+}
+
+func parseChaosFailpoints(specs []string) ([]chaosFailpoint, error) {
+	fps := make([]chaosFailpoint, 0, len(specs))
+	for _, spec := range specs {
+		eq := strings.IndexByte(spec, '=')
+		if eq <= 0 || eq == len(spec)-1 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"invalid failpoint %q, should be in the form of path=terms", spec)
+		}
+		fps = append(fps, chaosFailpoint{path: spec[:eq], terms: spec[eq+1:]})
+	}
+	return fps, nil
+}
+
+// runChaos activates the failpoints one after another every interval, each
+// one keeps active for the duration. It returns the activated count of each
+// failpoint after the context is done.
+func runChaos(
+	ctx context.Context,
+	fps []chaosFailpoint,
+	interval, duration time.Duration,
+) map[string]int {
+	activated := make(map[string]int, len(fps))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			return activated
+		case <-ticker.C:
+		}
+		fp := fps[i%len(fps)]
+		if err := failpoint.Enable(fp.path, fp.terms); err != nil {
+			log.Warn("failed to enable failpoint",
+				zap.String("failpoint", fp.path), zap.Error(err))
+			continue
+		}
+		activated[fp.path]++
+		log.Info("chaos failpoint enabled",
+			zap.String("failpoint", fp.path), zap.String("terms", fp.terms))
+		select {
+		case <-ctx.Done():
+		case <-time.After(duration):
+		}
+		if err := failpoint.Disable(fp.path); err != nil {
+			log.Warn("failed to disable failpoint",
+				zap.String("failpoint", fp.path), zap.Error(err))
+		}
+		log.Info("chaos failpoint disabled", zap.String("failpoint", fp.path))
+	}
+}
+
+// NewChaosCommand returns a hidden chaos command, which runs a backup or
+// restore command while activating failpoints on a schedule, to validate the
+// retry logic end-to-end.
+//
+// The failpoints only take effect if BR is built with failpoints enabled
+// (`make failpoint-enable`).
+func NewChaosCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "chaos [flags] -- <backup|restore> <subcommand> [flags]",
+		Short: "run backup or restore while activating failpoints",
+		Long: "Run backup or restore while activating the failpoints one after another.\n" +
+			"The failpoints only take effect if BR is built with `make failpoint-enable`.",
+		Hidden:       true,
+		SilenceUsage: true,
+		Args:         cobra.MinimumNArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			specs, err := c.Flags().GetStringArray(flagChaosFailpoint)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			fps, err := parseChaosFailpoints(specs)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			interval, err := c.Flags().GetDuration(flagChaosInterval)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			duration, err := c.Flags().GetDuration(flagChaosDuration)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if interval <= 0 || duration <= 0 {
+				return errors.Annotate(berrors.ErrInvalidArgument,
+					"--chaos-interval and --chaos-duration must be positive")
+			}
+			if len(fps) == 0 {
+				return errors.Annotate(berrors.ErrInvalidArgument, "no failpoint to activate")
+			}
+
+			// The storage failures are injected only for the chaos runs.
+			storage.EnableChaos()
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			// The backup or restore command runs in a standalone root command,
+			// so its flags are parsed just like `br <args>`.
+			root := &cobra.Command{
+				Use:              "br",
+				TraverseChildren: true,
+				SilenceUsage:     true,
+			}
+			AddFlags(root)
+			root.AddCommand(NewBackupCommand(), NewRestoreCommand())
+			root.SetOut(c.OutOrStdout())
+			root.SetArgs(args)
+
+			var (
+				wg        sync.WaitGroup
+				activated map[string]int
+			)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				activated = runChaos(ctx, fps, interval, duration)
+			}()
+			runErr := root.Execute()
+			cancel()
+			wg.Wait()
+
+			for _, fp := range fps {
+				c.Printf("failpoint %s activated %d time(s)\n", fp.path, activated[fp.path])
+			}
+			return errors.Trace(runErr)
+		},
+	}
+	command.Flags().StringArray(flagChaosFailpoint, defaultChaosFailpoints,
+		"the failpoints to activate in turn, in the form of path=terms")
+	command.Flags().Duration(flagChaosInterval, 30*time.Second,
+		"the interval between two activations of the failpoints")
+	command.Flags().Duration(flagChaosDuration, 10*time.Second,
+		"how long each failpoint keeps active")
+	return command
+}
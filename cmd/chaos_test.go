@@ -0,0 +1,48 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+func TestT(t *testing.T) {
+	TestingT(t)
+}
+
+type testChaosSuite struct{}
+
+var _ = Suite(&testChaosSuite{})
+
+func (s *testChaosSuite) TestParseChaosFailpoints(c *C) {
+	fps, err := parseChaosFailpoints([]string{"a/b=return(true)", "c=1*return(1)->return(2)"})
+	c.Assert(err, IsNil)
+	c.Assert(fps, DeepEquals, []chaosFailpoint{
+		{path: "a/b", terms: "return(true)"},
+		{path: "c", terms: "1*return(1)->return(2)"},
+	})
+
+	for _, spec := range []string{"a", "=return(true)", "a="} {
+		_, err = parseChaosFailpoints([]string{spec})
+		c.Assert(err, ErrorMatches, ".*invalid failpoint.*")
+	}
+}
+
+func (s *testChaosSuite) TestRunChaos(c *C) {
+	fps := []chaosFailpoint{
+		{path: "github.com/pingcap/br/cmd/chaos-test-1", terms: "return(true)"},
+		{path: "github.com/pingcap/br/cmd/chaos-test-2", terms: "return(true)"},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	activated := runChaos(ctx, fps, 10*time.Millisecond, 10*time.Millisecond)
+	// The failpoints are activated in turn.
+	c.Assert(activated[fps[0].path] > 0, IsTrue)
+	c.Assert(activated[fps[1].path] > 0, IsTrue)
+	diff := activated[fps[0].path] - activated[fps[1].path]
+	c.Assert(diff == 0 || diff == 1, IsTrue)
+}
@@ -5,8 +5,10 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,8 +18,11 @@ import (
 	"github.com/pingcap/tidb/util/logutil"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 
+	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/gluetidb"
+	brlogutil "github.com/pingcap/br/pkg/logutil"
 	"github.com/pingcap/br/pkg/redact"
 	"github.com/pingcap/br/pkg/summary"
 	"github.com/pingcap/br/pkg/task"
@@ -30,6 +35,11 @@ var (
 	hasLogFile      uint64
 	tidbGlue        = gluetidb.New()
 	envLogToTermKey = "BR_LOG_TO_TERM"
+
+	// baseLogger is the global logger without the task ID, which is renewed
+	// for every task.
+	baseLogger *zap.Logger
+	logProps   *log.ZapProperties
 )
 
 const (
@@ -41,6 +51,18 @@ const (
 	FlagLogFormat = "log-format"
 	// FlagStatusAddr is the name of status-addr flag.
 	FlagStatusAddr = "status-addr"
+	// FlagStatusCert and FlagStatusKey are the names of the flags enabling TLS
+	// of the status server.
+	FlagStatusCert = "status-cert"
+	FlagStatusKey  = "status-key"
+	// FlagStatusCA is the name of the flag enabling mTLS of the status server.
+	FlagStatusCA = "status-ca"
+	// FlagStatusTokenFile is the name of the flag enabling the bearer token
+	// authentication of the status server.
+	FlagStatusTokenFile = "status-token-file"
+	// FlagProgressFile is the name of the flag selecting the file of the
+	// progress lines printed when the stdout isn't a terminal.
+	FlagProgressFile = "progress-file"
 	// FlagSlowLogFile is the name of slow-log-file flag.
 	FlagSlowLogFile = "slow-log-file"
 	// FlagRedactLog is whether to redact sensitive information in log, already deprecated by FlagRedactInfoLog
@@ -66,14 +88,24 @@ func AddFlags(cmd *cobra.Command) {
 		"Set the log level")
 	cmd.PersistentFlags().String(FlagLogFile, timestampLogFileName(),
 		"Set the log file path. If not set, logs will output to temp file")
-	cmd.PersistentFlags().String(FlagLogFormat, "text",
-		"Set the log format")
+	cmd.PersistentFlags().String(FlagLogFormat, brlogutil.LogFormatText,
+		"Set the log format, text or json")
 	cmd.PersistentFlags().Bool(FlagRedactLog, false,
 		"Set whether to redact sensitive info in log, already deprecated by --redact-info-log")
 	cmd.PersistentFlags().Bool(FlagRedactInfoLog, false,
 		"Set whether to redact sensitive info in log")
 	cmd.PersistentFlags().String(FlagStatusAddr, "",
 		"Set the HTTP listening address for the status report service. Set to empty string to disable")
+	cmd.PersistentFlags().String(FlagStatusCert, "",
+		"Set the certificate path to serve the status report service over TLS")
+	cmd.PersistentFlags().String(FlagStatusKey, "",
+		"Set the private key path to serve the status report service over TLS")
+	cmd.PersistentFlags().String(FlagStatusCA, "",
+		"Set the CA path to require the clients of the status report service to present certificates signed by it")
+	cmd.PersistentFlags().String(FlagStatusTokenFile, "",
+		"Set the path of the file containing the bearer token required by the status report service")
+	cmd.PersistentFlags().String(FlagProgressFile, "",
+		"Set the file to append the JSON progress lines to when the stdout isn't a terminal, stderr if not set")
 	task.DefineCommonFlags(cmd.PersistentFlags())
 
 	cmd.PersistentFlags().StringP(FlagSlowLogFile, "", "",
@@ -104,18 +136,23 @@ func Init(cmd *cobra.Command) (err error) {
 			// Log to term if env `BR_LOG_TO_TERM` is set.
 			conf.File.Filename = ""
 		}
+		summary.SetLogFormat(conf.Format)
 		if len(conf.File.Filename) != 0 {
 			atomic.StoreUint64(&hasLogFile, 1)
 			summary.InitCollector(true)
 			// cmd.PrintErr prints to stderr, but PrintErrf prints to stdout.
 			cmd.PrintErr(fmt.Sprintf("Detail BR log in %s \n", conf.File.Filename))
 		}
-		lg, p, e := log.InitLogger(conf)
+		lg, p, e := brlogutil.InitLogger(conf)
 		if e != nil {
 			err = e
 			return
 		}
-		log.ReplaceGlobals(lg, p)
+		baseLogger, logProps = lg, p
+		log.ReplaceGlobals(lg.With(brlogutil.TaskIDField()), p)
+		log.Info("BR task started", brlogutil.TaskIDField())
+		// The RPCs carry the task ID and the command, e.g. `br backup full`.
+		utils.SetGRPCCommand(cmd.CommandPath())
 
 		redactLog, e := cmd.Flags().GetBool(FlagRedactLog)
 		if e != nil {
@@ -129,6 +166,20 @@ func Init(cmd *cobra.Command) (err error) {
 		}
 		redact.InitRedact(redactLog || redactInfoLog)
 
+		progressFile, e := cmd.Flags().GetString(FlagProgressFile)
+		if e != nil {
+			err = e
+			return
+		}
+		if len(progressFile) != 0 {
+			f, e := os.OpenFile(progressFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+			if e != nil {
+				err = errors.Annotatef(e, "failed to open the progress file %s", progressFile)
+				return
+			}
+			utils.SetProgressLineOutput(f)
+		}
+
 		slowLogFilename, e := cmd.Flags().GetString(FlagSlowLogFile)
 		if e != nil {
 			err = e
@@ -156,6 +207,15 @@ func Init(cmd *cobra.Command) (err error) {
 			err = e
 			return
 		}
+		statusCfg, e := parseStatusServerConfig(cmd)
+		if e != nil {
+			err = e
+			return
+		}
+		if e = utils.SetStatusServerConfig(statusCfg); e != nil {
+			err = e
+			return
+		}
 		if statusAddr != "" {
 			utils.StartPProfListener(statusAddr)
 		} else {
@@ -165,6 +225,45 @@ func Init(cmd *cobra.Command) (err error) {
 	return errors.Trace(err)
 }
 
+func parseStatusServerConfig(cmd *cobra.Command) (cfg utils.StatusServerConfig, err error) {
+	flags := cmd.Flags()
+	if cfg.CertPath, err = flags.GetString(FlagStatusCert); err != nil {
+		return cfg, errors.Trace(err)
+	}
+	if cfg.KeyPath, err = flags.GetString(FlagStatusKey); err != nil {
+		return cfg, errors.Trace(err)
+	}
+	if cfg.CAPath, err = flags.GetString(FlagStatusCA); err != nil {
+		return cfg, errors.Trace(err)
+	}
+	tokenFile, err := flags.GetString(FlagStatusTokenFile)
+	if err != nil {
+		return cfg, errors.Trace(err)
+	}
+	if tokenFile != "" {
+		// The token is read from a file, so it doesn't show in the process list.
+		token, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return cfg, errors.Annotate(err, "failed to read the token of the status server")
+		}
+		cfg.Token = strings.TrimSpace(string(token))
+		if cfg.Token == "" {
+			return cfg, errors.Annotatef(berrors.ErrInvalidArgument, "empty token in %s", tokenFile)
+		}
+	}
+	return cfg, nil
+}
+
+// StartTask renews the task ID for a task run by the same process again, e.g.
+// the cron backups, so its logs and metrics aren't mixed with the former ones.
+func StartTask() {
+	brlogutil.NewTaskID()
+	if baseLogger != nil {
+		log.ReplaceGlobals(baseLogger.With(brlogutil.TaskIDField()), logProps)
+	}
+	log.Info("BR task started", brlogutil.TaskIDField())
+}
+
 // HasLogFile returns whether we set a log file.
 func HasLogFile() bool {
 	return atomic.LoadUint64(&hasLogFile) != uint64(0)
@@ -175,7 +274,8 @@ func SetDefaultContext(ctx context.Context) {
 	defaultContext = ctx
 }
 
-// GetDefaultContext returns the default context for command line usage.
+// GetDefaultContext returns the default context for command line usage, it
+// carries the ID of the current task.
 func GetDefaultContext() context.Context {
-	return defaultContext
+	return brlogutil.ContextWithTaskID(defaultContext, brlogutil.TaskID())
 }
@@ -51,6 +51,7 @@ func NewDebugCommand() *cobra.Command {
 	meta.AddCommand(decodeBackupMetaCommand())
 	meta.AddCommand(encodeBackupMetaCommand())
 	meta.AddCommand(setPDConfigCommand())
+	meta.AddCommand(searchKeyCommand())
 	meta.Hidden = true
 
 	return meta
@@ -361,7 +362,7 @@ func setPDConfigCommand() *cobra.Command {
 				return errors.Trace(err)
 			}
 
-			mgr, err := task.NewMgr(ctx, tidbGlue, cfg.PD, cfg.TLS, task.GetKeepalive(&cfg), cfg.CheckRequirements)
+			mgr, err := task.NewMgr(ctx, tidbGlue, cfg.PD, cfg.TLS, task.GetKeepalive(&cfg), cfg.GRPCMaxMsgSize(), cfg.CheckRequirements)
 			if err != nil {
 				return errors.Trace(err)
 			}
@@ -376,3 +377,41 @@ func setPDConfigCommand() *cobra.Command {
 	}
 	return pdConfigCmd
 }
+
+func searchKeyCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "search-key",
+		Short: "locate the backup files containing a key",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			var cfg task.SearchKeyConfig
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				return errors.Trace(err)
+			}
+			found, err := task.SearchKey(ctx, &cfg)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			for _, f := range found {
+				file := f.File
+				cmd.Printf("key:        %X\n", f.Key)
+				cmd.Printf("file:       %s\n", file.GetName())
+				cmd.Printf("cf:         %s\n", file.GetCf())
+				cmd.Printf("range:      [%X, %X)\n", file.GetStartKey(), file.GetEndKey())
+				cmd.Printf("sha256:     %s\n", hex.EncodeToString(file.GetSha256()))
+				cmd.Printf("crc64xor:   %d\n", file.GetCrc64Xor())
+				cmd.Printf("total kvs:  %d\n", file.GetTotalKvs())
+				cmd.Printf("total size: %d\n\n", file.GetTotalBytes())
+			}
+			if len(found) == 0 {
+				cmd.Println("no backup file contains the key")
+			}
+			return nil
+		},
+	}
+	task.DefineSearchKeyFlags(command.Flags())
+	return command
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cmd
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+func runDeleteCommand(command *cobra.Command, cmdName string) error {
+	cfg := task.DeleteConfig{Config: task.Config{LogProgress: HasLogFile()}}
+	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+		command.SilenceUsage = false
+		return errors.Trace(err)
+	}
+	if err := task.RunDelete(GetDefaultContext(), cmdName, &cfg); err != nil {
+		log.Error("failed to delete backup", zap.Error(err))
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// NewDeleteCommand returns a delete subcommand.
+func NewDeleteCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "delete",
+		Short:        "delete a backup, or the backups older than --older-than, unless other backups are incremental to them",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			utils.LogBRInfo()
+			task.LogArguments(c)
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runDeleteCommand(cmd, "Delete")
+		},
+	}
+	task.DefineDeleteFlags(command.Flags())
+	return command
+}
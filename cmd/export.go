@@ -0,0 +1,51 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cmd
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/gluetikv"
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+func runExportCommand(command *cobra.Command, cmdName string) error {
+	cfg := task.ExportConfig{Config: task.Config{LogProgress: HasLogFile()}}
+	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+		command.SilenceUsage = false
+		return errors.Trace(err)
+	}
+	if err := task.RunExport(GetDefaultContext(), gluetikv.Glue{}, cmdName, &cfg); err != nil {
+		log.Error("failed to export backup", zap.Error(err))
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// NewExportCommand returns an export subcommand.
+func NewExportCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "export",
+		Short:        "export a backup into a directory in the format of Dumpling, i.e. schema and data SQL files",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			utils.LogBRInfo()
+			task.LogArguments(c)
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runExportCommand(cmd, "Export")
+		},
+	}
+	task.DefineExportFlags(command.Flags())
+	task.DefineFilterFlags(command)
+	return command
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cmd
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/pingcap/br/pkg/task"
+)
+
+// maxFanOutStderrSize is the max size of the stderr kept for the error of a
+// BR process, only the tail is kept, which contains the error.
+const maxFanOutStderrSize = 64 * 1024
+
+// tailBuffer keeps the last limit bytes written into it.
+type tailBuffer struct {
+	buf       []byte
+	limit     int
+	truncated bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.limit; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	if b.truncated {
+		return "..." + string(b.buf)
+	}
+	return string(b.buf)
+}
+
+// runFanOutBackup runs the backup command against every cluster of the
+// fan-out by another BR process, so the backups don't share the states of the
+// process, e.g. the summary and the TiDB domain.
+func runFanOutBackup(command *cobra.Command, cmdName string, cfg *task.Config, fanOut *task.FanOutConfig) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	logFile, err := command.Flags().GetString(FlagLogFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// The command path is like `br backup full`.
+	baseArgs := strings.Fields(command.CommandPath())[1:]
+	// Every BR logs into its own file and the status addresses would conflict.
+	baseArgs = append(baseArgs, task.FanOutArgs(command.Flags(), FlagLogFile, FlagStatusAddr)...)
+
+	run := func(ctx context.Context, cluster *task.FanOutCluster, storage string) error {
+		args := append([]string{}, baseArgs...)
+		args = append(args, "--pd="+strings.Join(cluster.PD, ","))
+		if logFile != "" {
+			args = append(args, "--"+FlagLogFile+"="+logFile+"."+cluster.Name)
+		}
+		br := exec.Command(exe, args...)
+		// The storage is passed by the env, so the credentials in it aren't
+		// visible in the process list.
+		br.Env = append(os.Environ(), task.EnvStorage+"="+storage)
+		stderr := &tailBuffer{limit: maxFanOutStderrSize}
+		br.Stderr = stderr
+		if err := br.Start(); err != nil {
+			return errors.Trace(err)
+		}
+		done := make(chan error, 1)
+		go func() { done <- br.Wait() }()
+		var err error
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			// Let the BR clean up, e.g. remove the backup lock.
+			_ = br.Process.Signal(os.Interrupt)
+			err = <-done
+		}
+		if err != nil {
+			return errors.Annotate(err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+
+	results, err := task.RunFanOut(GetDefaultContext(), cmdName, cfg, fanOut, run)
+	for _, result := range results {
+		state := "succeeded"
+		if result.Err != nil {
+			state = "failed"
+		}
+		// Hide the credentials in the query.
+		storage := result.Storage
+		if u, err := url.Parse(storage); err == nil {
+			u.RawQuery = ""
+			storage = u.String()
+		}
+		command.Printf("%s\t%s\t%s\tfiles: %d\tsize: %d\t%s\n",
+			result.Cluster, state, result.Duration, result.Files, result.Size, storage)
+	}
+	return errors.Trace(err)
+}
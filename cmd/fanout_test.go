@@ -0,0 +1,26 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/pingcap/check"
+)
+
+type testFanOutSuite struct{}
+
+var _ = Suite(&testFanOutSuite{})
+
+func (s *testFanOutSuite) TestTailBuffer(c *C) {
+	b := &tailBuffer{limit: 8}
+	fmt.Fprint(b, "abc")
+	c.Assert(b.String(), Equals, "abc")
+	fmt.Fprint(b, "defgh")
+	c.Assert(b.String(), Equals, "abcdefgh")
+	n, err := fmt.Fprint(b, strings.Repeat("x", 10)+"error")
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 15)
+	c.Assert(b.String(), Equals, "...xxxerror")
+}
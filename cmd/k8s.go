@@ -0,0 +1,67 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cmd
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/spf13/cobra"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/task"
+)
+
+// NewK8sCommand return a k8s subcommand.
+func NewK8sCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "k8s <subcommand>",
+		Short:        "commands to run BR tasks on Kubernetes",
+		SilenceUsage: true,
+	}
+	command.AddCommand(newRenderCronJobCommand())
+	return command
+}
+
+func newRenderCronJobCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "render-cronjob [flags] -- backup <subcommand> [backup flags]",
+		Short: "render the backup task scheduled by --cron into a Kubernetes CronJob manifest",
+		Example: "br k8s render-cronjob --secret backup-s3 -- " +
+			`backup full --pd pd:2379 -s s3://bucket/prefix --cron "0 0 2 * * *"`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var cfg task.CronJobConfig
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				cmd.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			// Parse the backup task by the same commands which run it.
+			root := &cobra.Command{Use: "br"}
+			AddFlags(root)
+			backupCmd := NewBackupCommand()
+			root.AddCommand(backupCmd)
+			taskCmd, flagArgs, err := root.Find(args)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if taskCmd.Parent() != backupCmd || taskCmd.Name() == "unlock" {
+				return errors.Annotatef(berrors.ErrInvalidArgument,
+					"only the backup tasks can be scheduled, got %q", taskCmd.CommandPath())
+			}
+			if err = taskCmd.ParseFlags(flagArgs); err != nil {
+				return errors.Trace(err)
+			}
+			if len(taskCmd.Flags().Args()) > 0 {
+				return errors.Annotatef(berrors.ErrInvalidArgument,
+					"unexpected arguments %v", taskCmd.Flags().Args())
+			}
+			manifest, err := task.RenderCronJob(&cfg, []string{backupCmd.Name(), taskCmd.Name()}, taskCmd.Flags())
+			if err != nil {
+				return errors.Trace(err)
+			}
+			cmd.Print(string(manifest))
+			return nil
+		},
+	}
+	task.DefineCronJobFlags(command.Flags())
+	return command
+}
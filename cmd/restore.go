@@ -23,19 +23,46 @@ func runRestoreCommand(command *cobra.Command, cmdName string) error {
 		command.SilenceUsage = false
 		return errors.Trace(err)
 	}
+	return runRestore(cmdName, &cfg)
+}
+
+func runSchemaRestoreCommand(command *cobra.Command) error {
+	cfg := task.RestoreConfig{Config: task.Config{LogProgress: HasLogFile()}}
+	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+		command.SilenceUsage = false
+		return errors.Trace(err)
+	}
+	cfg.SchemaOnly = true
+	return runRestore(task.CmdSchemaRestore, &cfg)
+}
+
+func runRestore(cmdName string, cfg *task.RestoreConfig) error {
 	var gl glue.Glue
 	if cmdName == "Txn restore" {
 		gl = gluetikv.Glue{}
 	} else {
 		gl = tidbGlue
 	}
-	if err := task.RunRestore(GetDefaultContext(), gl, cmdName, &cfg); err != nil {
+	if err := task.RunRestore(GetDefaultContext(), gl, cmdName, cfg); err != nil {
 		log.Error("failed to restore", zap.Error(err))
 		return errors.Trace(err)
 	}
 	return nil
 }
 
+func runRestoreAbortCommand(command *cobra.Command, cmdName string) error {
+	cfg := task.Config{LogProgress: HasLogFile()}
+	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+		command.SilenceUsage = false
+		return errors.Trace(err)
+	}
+	if err := task.RunRestoreAbort(GetDefaultContext(), tidbGlue, cmdName, &cfg); err != nil {
+		log.Error("failed to abort restore", zap.Error(err))
+		return errors.Trace(err)
+	}
+	return nil
+}
+
 func runLogRestoreCommand(command *cobra.Command) error {
 	cfg := task.LogRestoreConfig{Config: task.Config{LogProgress: HasLogFile()}}
 	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
@@ -99,9 +126,11 @@ func NewRestoreCommand() *cobra.Command {
 		newFullRestoreCommand(),
 		newDBRestoreCommand(),
 		newTableRestoreCommand(),
+		newSchemaRestoreCommand(),
 		newLogRestoreCommand(),
 		newRawRestoreCommand(),
 		newTxnRestoreCommand(),
+		newRestoreAbortCommand(),
 	)
 	task.DefineRestoreFlags(command.PersistentFlags())
 
@@ -147,6 +176,21 @@ func newTableRestoreCommand() *cobra.Command {
 	return command
 }
 
+func newSchemaRestoreCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "schema-only",
+		Short: "create the databases, tables and views without restoring any data",
+		Long: "create the databases, tables and views without restoring any data, so the target cluster " +
+			"can be prepared before the data is restored by another restore command",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runSchemaRestoreCommand(cmd)
+		},
+	}
+	task.DefineFilterFlags(command)
+	return command
+}
+
 func newLogRestoreCommand() *cobra.Command {
 	command := &cobra.Command{
 		Use:   "cdclog",
@@ -188,3 +232,15 @@ func newTxnRestoreCommand() *cobra.Command {
 	task.DefineRawRestoreFlags(command)
 	return command
 }
+
+func newRestoreAbortCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "abort",
+		Short: "clean up the cluster after a cancelled restore from the storage",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runRestoreAbortCommand(cmd, "Restore abort")
+		},
+	}
+	return command
+}
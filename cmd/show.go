@@ -0,0 +1,180 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv/oracle"
+	"github.com/spf13/cobra"
+
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// NewShowCommand return a show subcommand.
+func NewShowCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "show [subcommand]",
+		Short:        "list the backups under the storage, or show the content of backup data",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			utils.LogBRInfo()
+			task.LogArguments(c)
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			var cfg task.ShowConfig
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				cmd.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			backups, err := task.ShowBackups(ctx, &cfg.Config)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			return errors.Trace(printBackups(cmd, backups, cfg.Format))
+		},
+	}
+	task.DefineShowFlags(command.Flags())
+	command.AddCommand(
+		newShowBackupMetaCommand(),
+		newShowTopologyCommand(),
+	)
+	return command
+}
+
+// printBackups prints the backups under the storage in the format.
+func printBackups(cmd *cobra.Command, backups []task.BackupInfo, format string) error {
+	if format == task.ShowFormatJSON {
+		data, err := json.MarshalIndent(backups, "", "  ")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cmd.Println(string(data))
+		return nil
+	}
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATE\tCLUSTER ID\tBACKUP TS\tBACKUP TIME\tSIZE\tTABLES")
+	for _, b := range backups {
+		name := b.Name
+		if name == "" {
+			name = "."
+		}
+		backupTime := "-"
+		if b.EndVersion != 0 {
+			backupTime = oracle.GetTimeFromTS(b.EndVersion).UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%d\t%s\n",
+			name, b.State, b.ClusterID, b.EndVersion, backupTime, b.Size, strings.Join(b.Tables, ","))
+	}
+	return errors.Trace(w.Flush())
+}
+
+func newShowBackupMetaCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "backupmeta <subcommand>",
+		Short: "show the content of the backupmeta",
+	}
+	command.AddCommand(newShowSchemasCommand())
+	return command
+}
+
+func newShowSchemasCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "schemas",
+		Short: "print the CREATE TABLE statements of the tables in the backup",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			var cfg task.Config
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				cmd.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			return errors.Trace(showSchemas(ctx, cmd, &cfg))
+		},
+	}
+	task.DefineFilterFlags(command)
+	return command
+}
+
+func newShowTopologyCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "topology",
+		Short: "print the topology of the cluster at backup time",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			var cfg task.Config
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				cmd.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			return errors.Trace(showTopology(ctx, cmd, &cfg))
+		},
+	}
+}
+
+// showTopology prints the stores and the region count of the source cluster
+// of the backup.
+func showTopology(ctx context.Context, cmd *cobra.Command, cfg *task.Config) error {
+	_, s, err := task.GetStorage(ctx, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	topology, err := utils.ReadClusterTopology(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if topology == nil {
+		cmd.Println("the backup doesn't contain the cluster topology")
+		return nil
+	}
+	tikv, tiflash := topology.StoreCount()
+	cmd.Printf("TiKV stores: %d, TiFlash stores: %d, regions: %d\n", tikv, tiflash, topology.RegionCount)
+	for _, store := range topology.Stores {
+		labels := make([]string, 0, len(store.Labels))
+		for key, value := range store.Labels {
+			labels = append(labels, key+"="+value)
+		}
+		sort.Strings(labels)
+		cmd.Printf("%d\t%s\t%s\t%s\n", store.ID, store.Address, store.Version, strings.Join(labels, ","))
+	}
+	return nil
+}
+
+// showSchemas prints the CREATE TABLE statements of the tables matching the
+// table filter, ordered by the database and table names.
+func showSchemas(ctx context.Context, cmd *cobra.Command, cfg *task.Config) error {
+	_, _, backupMeta, err := task.ReadBackupMeta(ctx, utils.MetaFile, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	stmts, err := task.ShowCreateTables(backupMeta, cfg.TableFilter)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, stmt := range stmts {
+		cmd.Printf("-- %s.%s\n%s;\n\n", utils.EncloseName(stmt.DB), utils.EncloseName(stmt.Table), stmt.SQL)
+	}
+	return nil
+}
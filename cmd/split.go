@@ -0,0 +1,49 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cmd
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+func runSplitCommand(command *cobra.Command, cmdName string) error {
+	cfg := task.SplitConfig{Config: task.Config{LogProgress: HasLogFile()}}
+	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+		command.SilenceUsage = false
+		return errors.Trace(err)
+	}
+	if err := task.RunSplit(GetDefaultContext(), tidbGlue, cmdName, &cfg); err != nil {
+		log.Error("failed to split regions", zap.Error(err))
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// NewSplitCommand returns a split subcommand.
+func NewSplitCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "split",
+		Short:        "split the regions at the keys of --split-keys-file, e.g. ahead of a restore or an import",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			utils.LogBRInfo()
+			task.LogArguments(c)
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runSplitCommand(cmd, "Split")
+		},
+	}
+	task.DefineSplitKeysFlags(command.Flags())
+	return command
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cmd
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// NewStreamCommand returns a stream subcommand.
+func NewStreamCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "stream <subcommand>",
+		Short:        "commands to inspect the log backup written by TiCDC",
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			utils.LogBRInfo()
+			task.LogArguments(c)
+			return nil
+		},
+	}
+	command.AddCommand(newStreamStatusCommand())
+	return command
+}
+
+func newStreamStatusCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "status",
+		Short: "show the checkpoint lag of the log backup at the storage, and fail if it exceeds --max-lag",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			var cfg task.StreamStatusConfig
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				cmd.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			return errors.Trace(task.RunStreamStatus(GetDefaultContext(), &cfg, func(status *task.StreamStatus) {
+				printStreamStatus(cmd, status)
+			}))
+		},
+	}
+	task.DefineStreamStatusFlags(command.Flags())
+	return command
+}
+
+func printStreamStatus(cmd *cobra.Command, status *task.StreamStatus) {
+	cmd.Printf("Time:       %s\n", status.Time.Format("2006-01-02 15:04:05"))
+	if status.Err != nil {
+		cmd.Printf("Error:      %s\n\n", status.Err)
+		return
+	}
+	cmd.Printf("Checkpoint: %d\n", status.ResolvedTS)
+	cmd.Printf("Lag:        %s\n", status.Lag)
+	cmd.Printf("Files:      %d\n", status.Files)
+	cmd.Printf("Size:       %d\n", status.Size)
+	if status.WriteRate >= 0 {
+		cmd.Printf("Write rate: %.0f B/s\n", status.WriteRate)
+	}
+	for _, table := range status.Tables {
+		lag := "-"
+		if table.Lag >= 0 {
+			lag = table.Lag.String()
+		}
+		cmd.Printf("  %d\t%s\tlag: %s\tfiles: %d\n", table.ID, table.Name, lag, table.Files)
+	}
+	for _, store := range status.Stores {
+		lag := "-"
+		if store.Lag >= 0 {
+			lag = store.Lag.String()
+		}
+		cmd.Printf("  store %d\tlag: %s\ttables: %d\tlaggiest: %d\n", store.StoreID, lag, store.Tables, store.Laggiest)
+	}
+	cmd.Println()
+}
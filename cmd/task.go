@@ -0,0 +1,70 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pingcap/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// NewTaskCommand return a task subcommand.
+func NewTaskCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "task <subcommand>",
+		Short:        "commands to inspect backup tasks",
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			utils.LogBRInfo()
+			task.LogArguments(c)
+			return nil
+		},
+	}
+	command.AddCommand(newTaskStatusCommand())
+	return command
+}
+
+func newTaskStatusCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "status",
+		Short: "show whether the backup at the storage completed, is resumable and how much is done",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			var cfg task.Config
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				cmd.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			status, err := task.GetTaskStatus(ctx, &cfg)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			progress := "unknown"
+			if status.Progress >= 0 {
+				progress = fmt.Sprintf("%.2f%%", status.Progress*100)
+			}
+			cmd.Printf("Storage:   %s\n", status.Storage)
+			cmd.Printf("State:     %s\n", status.State)
+			cmd.Printf("Progress:  %s\n", progress)
+			cmd.Printf("Resumable: %t\n", status.Resumable)
+			cmd.Printf("Files:     %d\n", status.Files)
+			cmd.Printf("Size:      %d\n", status.Size)
+			if status.BackupTS != 0 {
+				cmd.Printf("BackupTS:  %d\n", status.BackupTS)
+			}
+			return nil
+		},
+	}
+	return command
+}
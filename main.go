@@ -50,6 +50,16 @@ func main() {
 		cmd.NewDebugCommand(),
 		cmd.NewBackupCommand(),
 		cmd.NewRestoreCommand(),
+		cmd.NewShowCommand(),
+		cmd.NewDeleteCommand(),
+		cmd.NewExportCommand(),
+		cmd.NewListCommand(),
+		cmd.NewGCCommand(),
+		cmd.NewSplitCommand(),
+		cmd.NewTaskCommand(),
+		cmd.NewStreamCommand(),
+		cmd.NewChaosCommand(),
+		cmd.NewK8sCommand(),
 	)
 	// Ouputs cmd.Print to stdout.
 	rootCmd.SetOut(os.Stdout)
@@ -0,0 +1,209 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	kvproto "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/rtree"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// CheckpointFile is the file of the backup checkpoint, which records the
+// ranges backed up so far, so a failed backup can be resumed.
+const CheckpointFile = "backup.checkpoint"
+
+// checkpointSaveInterval is the min interval of saving the checkpoint, so the
+// ranges are recorded without rewriting the checkpoint for every range.
+const checkpointSaveInterval = 30 * time.Second
+
+// CheckpointRange is a range backed up, with its files.
+type CheckpointRange struct {
+	StartKey []byte `json:"start-key"`
+	EndKey   []byte `json:"end-key"`
+	// EndVersion is the snapshot TS of the range, which differs from the
+	// backup TS for the tables with their own TS.
+	EndVersion uint64          `json:"end-version"`
+	Files      []*kvproto.File `json:"files"`
+}
+
+// Checkpoint records the ranges backed up by a backup.
+type Checkpoint struct {
+	// TaskID is the ID of the BR invocation which wrote the checkpoint.
+	TaskID       string             `json:"task-id,omitempty"`
+	BackupTS     uint64             `json:"backup-ts"`
+	LastBackupTS uint64             `json:"last-backup-ts"`
+	Ranges       []*CheckpointRange `json:"ranges"`
+}
+
+// ReadCheckpoint reads the checkpoint from the storage. It returns nil if
+// there is no checkpoint.
+func ReadCheckpoint(ctx context.Context, s storage.ExternalStorage) (*Checkpoint, error) {
+	exists, err := s.FileExists(ctx, CheckpointFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.Read(ctx, CheckpointFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cp := &Checkpoint{}
+	if err = json.Unmarshal(data, cp); err != nil {
+		return nil, errors.Annotatef(err, "failed to parse %s", CheckpointFile)
+	}
+	return cp, nil
+}
+
+// Checkpointer records the ranges backed up into the checkpoint, and skips
+// the ranges recorded by the previous runs.
+type Checkpointer struct {
+	mu         sync.Mutex
+	storage    storage.ExternalStorage
+	checkpoint *Checkpoint
+	// done is the ranges backed up by the previous runs, sorted by the start
+	// keys.
+	done []*CheckpointRange
+	// used is the ranges of done whose files have been returned.
+	used     map[*CheckpointRange]struct{}
+	lastSave time.Time
+}
+
+// NewCheckpointer creates a checkpointer of the backup at backupTS. The ranges
+// of the previous checkpoint are carried over, it should be nil unless the
+// backup is resumed, and must be of the same backup TS.
+func NewCheckpointer(
+	s storage.ExternalStorage, backupTS, lastBackupTS uint64, previous *Checkpoint,
+) (*Checkpointer, error) {
+	cp := &Checkpoint{BackupTS: backupTS, LastBackupTS: lastBackupTS}
+	var done []*CheckpointRange
+	if previous != nil {
+		if previous.BackupTS != backupTS || previous.LastBackupTS != lastBackupTS {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"the checkpoint is of the backup from %d to %d, which can't be resumed from %d to %d",
+				previous.LastBackupTS, previous.BackupTS, lastBackupTS, backupTS)
+		}
+		cp.Ranges = append(cp.Ranges, previous.Ranges...)
+		done = append(done, previous.Ranges...)
+		sort.Slice(done, func(i, j int) bool { return bytes.Compare(done[i].StartKey, done[j].StartKey) < 0 })
+	}
+	return &Checkpointer{
+		storage:    s,
+		checkpoint: cp,
+		done:       done,
+		used:       make(map[*CheckpointRange]struct{}),
+		lastSave:   time.Now(),
+	}, nil
+}
+
+// Len returns the count of the ranges backed up.
+func (c *Checkpointer) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.checkpoint.Ranges)
+}
+
+// finished returns the files of the ranges backed up by the previous runs at
+// the snapshot TS which overlap the range, and the sub-ranges of the range
+// not covered by them, which are still to be backed up. The ranges may be
+// partitioned differently between the runs, e.g. by the count of the regions
+// per request, so the files of a range backed up are returned only once, by
+// the first range overlapping it. The whole range is to be backed up if
+// there is no checkpoint.
+func (c *Checkpointer) finished(startKey, endKey []byte, endVersion uint64) ([]*kvproto.File, []rtree.Range) {
+	if c == nil {
+		return nil, []rtree.Range{{StartKey: startKey, EndKey: endKey}}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var files []*kvproto.File
+	gaps := make([]rtree.Range, 0, 1)
+	cursor := startKey
+	for _, r := range c.done {
+		if r.EndVersion != endVersion || !overlaps(r.StartKey, r.EndKey, cursor, endKey) {
+			continue
+		}
+		if bytes.Compare(r.StartKey, cursor) > 0 {
+			gaps = append(gaps, rtree.Range{StartKey: cursor, EndKey: r.StartKey})
+		}
+		if _, ok := c.used[r]; !ok {
+			c.used[r] = struct{}{}
+			files = append(files, r.Files...)
+		}
+		if len(r.EndKey) == 0 {
+			// The rest of the key space is covered.
+			return files, gaps
+		}
+		if bytes.Compare(r.EndKey, cursor) > 0 {
+			cursor = r.EndKey
+		}
+	}
+	if len(endKey) == 0 || bytes.Compare(cursor, endKey) < 0 {
+		gaps = append(gaps, rtree.Range{StartKey: cursor, EndKey: endKey})
+	}
+	return files, gaps
+}
+
+// overlaps returns whether the ranges [startKey1, endKey1) and [startKey2,
+// endKey2) overlap, an empty end key means the end of the key space.
+func overlaps(startKey1, endKey1, startKey2, endKey2 []byte) bool {
+	return (len(endKey1) == 0 || bytes.Compare(startKey2, endKey1) < 0) &&
+		(len(endKey2) == 0 || bytes.Compare(startKey1, endKey2) < 0)
+}
+
+// record records the range backed up, the checkpoint is saved at most once
+// per checkpointSaveInterval.
+func (c *Checkpointer) record(
+	ctx context.Context, startKey, endKey []byte, endVersion uint64, files []*kvproto.File,
+) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checkpoint.Ranges = append(c.checkpoint.Ranges, &CheckpointRange{
+		StartKey:   startKey,
+		EndKey:     endKey,
+		EndVersion: endVersion,
+		Files:      files,
+	})
+	if time.Since(c.lastSave) >= checkpointSaveInterval {
+		c.saveLocked(ctx)
+	}
+}
+
+// Flush saves the checkpoint with all the ranges backed up.
+func (c *Checkpointer) Flush(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.saveLocked(ctx)
+}
+
+func (c *Checkpointer) saveLocked(ctx context.Context) {
+	c.lastSave = time.Now()
+	c.checkpoint.TaskID = logutil.TaskIDFromContext(ctx)
+	data, err := json.Marshal(c.checkpoint)
+	if err == nil {
+		err = c.storage.Write(ctx, CheckpointFile, data)
+	}
+	if err != nil {
+		log.Warn("failed to save the backup checkpoint", zap.Error(err))
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	kvproto "github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/rtree"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+type testCheckpointSuite struct{}
+
+var _ = Suite(&testCheckpointSuite{})
+
+func (s *testCheckpointSuite) TestResumeCheckpoint(c *C) {
+	ctx := context.Background()
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	previous, err := ReadCheckpoint(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(previous, IsNil)
+	checkpointer, err := NewCheckpointer(store, 100, 0, nil)
+	c.Assert(err, IsNil)
+	files := []*kvproto.File{{Name: "1_2_3.sst", StartKey: []byte("a"), EndKey: []byte("b")}}
+	checkpointer.record(ctx, []byte("a"), []byte("b"), 100, files)
+	checkpointer.record(ctx, []byte("b"), []byte("c"), 90, nil)
+	checkpointer.Flush(ctx)
+
+	previous, err = ReadCheckpoint(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(previous.BackupTS, Equals, uint64(100))
+	c.Assert(previous.Ranges, HasLen, 2)
+
+	// The backup can only be resumed at the same TS.
+	_, err = NewCheckpointer(store, 101, 0, previous)
+	c.Assert(err, ErrorMatches, ".*can't be resumed from 0 to 101.*")
+	checkpointer, err = NewCheckpointer(store, 100, 0, previous)
+	c.Assert(err, IsNil)
+	c.Assert(checkpointer.Len(), Equals, 2)
+	finished, gaps := checkpointer.finished([]byte("a"), []byte("b"), 100)
+	c.Assert(gaps, HasLen, 0)
+	c.Assert(finished, HasLen, 1)
+	c.Assert(finished[0].Name, Equals, "1_2_3.sst")
+	// The range of another snapshot TS is backed up again.
+	finished, gaps = checkpointer.finished([]byte("b"), []byte("c"), 100)
+	c.Assert(finished, HasLen, 0)
+	c.Assert(gaps, DeepEquals, []rtree.Range{{StartKey: []byte("b"), EndKey: []byte("c")}})
+
+	var nilCheckpointer *Checkpointer
+	finished, gaps = nilCheckpointer.finished([]byte("a"), []byte("b"), 100)
+	c.Assert(finished, HasLen, 0)
+	c.Assert(gaps, DeepEquals, []rtree.Range{{StartKey: []byte("a"), EndKey: []byte("b")}})
+}
+
+func (s *testCheckpointSuite) TestCheckpointCoverage(c *C) {
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	file := func(name string) []*kvproto.File { return []*kvproto.File{{Name: name}} }
+	previous := &Checkpoint{BackupTS: 100, Ranges: []*CheckpointRange{
+		{StartKey: []byte("e"), EndKey: []byte("g"), EndVersion: 100, Files: file("eg")},
+		{StartKey: []byte("b"), EndKey: []byte("d"), EndVersion: 100, Files: file("bd")},
+		{StartKey: []byte("x"), EndKey: []byte(""), EndVersion: 100, Files: file("x")},
+	}}
+	checkpointer, err := NewCheckpointer(store, 100, 0, previous)
+	c.Assert(err, IsNil)
+
+	// The ranges are partitioned differently from the previous run.
+	finished, gaps := checkpointer.finished([]byte("a"), []byte("c"), 100)
+	c.Assert(finished, DeepEquals, file("bd"))
+	c.Assert(gaps, DeepEquals, []rtree.Range{{StartKey: []byte("a"), EndKey: []byte("b")}})
+	// The files of a range backed up are returned once.
+	finished, gaps = checkpointer.finished([]byte("c"), []byte("h"), 100)
+	c.Assert(finished, DeepEquals, file("eg"))
+	c.Assert(gaps, DeepEquals, []rtree.Range{
+		{StartKey: []byte("d"), EndKey: []byte("e")},
+		{StartKey: []byte("g"), EndKey: []byte("h")},
+	})
+	finished, gaps = checkpointer.finished([]byte("y"), []byte(""), 100)
+	c.Assert(finished, DeepEquals, file("x"))
+	c.Assert(gaps, HasLen, 0)
+	finished, gaps = checkpointer.finished([]byte("h"), []byte("x"), 100)
+	c.Assert(finished, HasLen, 0)
+	c.Assert(gaps, DeepEquals, []rtree.Range{{StartKey: []byte("h"), EndKey: []byte("x")}})
+}
@@ -11,6 +11,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -30,6 +31,7 @@ import (
 	"github.com/pingcap/tidb/meta/autoid"
 	"github.com/pingcap/tidb/store/tikv"
 	"github.com/pingcap/tidb/store/tikv/oracle"
+	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/util"
 	"github.com/pingcap/tidb/util/codec"
 	"github.com/pingcap/tidb/util/ranger"
@@ -40,6 +42,7 @@ import (
 	"google.golang.org/grpc/status"
 
 	"github.com/pingcap/br/pkg/conn"
+	"github.com/pingcap/br/pkg/encryption"
 	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/glue"
 	"github.com/pingcap/br/pkg/logutil"
@@ -79,8 +82,34 @@ type Client struct {
 
 	storage storage.ExternalStorage
 	backend *kvproto.StorageBackend
+	// tableBackends is the backends writing the files of the tables encrypted
+	// by the keys of their databases, indexed by the physical table ID.
+	tableBackends map[int64]*kvproto.StorageBackend
 
 	gcTTL int64
+
+	rateLimitSchedule *RateLimitSchedule
+	// tableTS is the snapshot TS of the tables backed up at a TS other than
+	// the backup TS, indexed by the physical table ID.
+	tableTS map[int64]uint64
+	// lastTableTS is the snapshot TS of the tables the last backup backed up
+	// at a TS other than its backup TS, indexed by the physical table ID.
+	lastTableTS map[int64]uint64
+	// limiter bounds the ranges in flight under the memory limit of BR.
+	limiter *utils.ResourceLimiter
+	// bandwidthBudget caps the rate limit by the share of the budget.
+	bandwidthBudget *utils.BandwidthBudget
+	// liveTuning overrides the rate limit and the concurrency of the ranges.
+	liveTuning *utils.LiveTuning
+	// dataKey encrypts the backupmeta, nil means plaintext.
+	dataKey *encryption.DataKey
+	// checkpointer records the ranges backed up, nil means no checkpoint.
+	checkpointer *Checkpointer
+	// schemaOnly is whether the data of the tables is skipped.
+	schemaOnly bool
+	// resume is whether the backup resumes the previous run in the same
+	// destination.
+	resume bool
 }
 
 // NewBackupClient returns a new backup client.
@@ -96,6 +125,11 @@ func NewBackupClient(ctx context.Context, mgr ClientMgr) (*Client, error) {
 
 // GetTS returns the latest timestamp.
 func (bc *Client) GetTS(ctx context.Context, duration time.Duration, ts uint64) (uint64, error) {
+	return GetTS(ctx, bc.mgr.GetPDClient(), duration, ts)
+}
+
+// GetTS returns the backup ts like Client.GetTS, with the given PD client.
+func GetTS(ctx context.Context, pdClient pd.Client, duration time.Duration, ts uint64) (uint64, error) {
 	var (
 		backupTS uint64
 		err      error
@@ -103,7 +137,7 @@ func (bc *Client) GetTS(ctx context.Context, duration time.Duration, ts uint64)
 	if ts > 0 {
 		backupTS = ts
 	} else {
-		p, l, err := bc.mgr.GetPDClient().GetTS(ctx)
+		p, l, err := pdClient.GetTS(ctx)
 		if err != nil {
 			return 0, errors.Trace(err)
 		}
@@ -124,20 +158,22 @@ func (bc *Client) GetTS(ctx context.Context, duration time.Duration, ts uint64)
 		}
 	}
 
-	// check backup time do not exceed GCSafePoint
-	err = utils.CheckGCSafePoint(ctx, bc.mgr.GetPDClient(), backupTS)
+	// check backup time is in the GC window
+	window, err := utils.GetGCWindow(ctx, pdClient)
 	if err != nil {
+		log.Warn("fail to get GC window", zap.Error(err))
+	} else if err = window.Check("backup TS", backupTS); err != nil {
 		return 0, errors.Trace(err)
 	}
 	log.Info("backup encode timestamp", zap.Uint64("BackupTS", backupTS))
 	return backupTS, nil
 }
 
-// SetLockFile set write lock file.
-func (bc *Client) SetLockFile(ctx context.Context) error {
-	return bc.storage.Write(ctx, utils.LockFile,
-		[]byte("DO NOT DELETE\n"+
-			"This file exists to remind other backup jobs won't use this path"))
+// LockStorage acquires the sentinel lock of the backup destination, it
+// should be called after SetStorage.
+func (bc *Client) LockStorage(ctx context.Context, ttl time.Duration) (*SentinelLock, error) {
+	lock, err := AcquireSentinelLock(ctx, bc.storage, ttl)
+	return lock, errors.Trace(err)
 }
 
 // SetGCTTL set gcTTL for client.
@@ -148,15 +184,100 @@ func (bc *Client) SetGCTTL(ttl int64) {
 	bc.gcTTL = ttl
 }
 
+// SetRateLimitSchedule sets the rate limits by time windows, the rate limit of
+// a range is decided when it starts, and the rate limit of the request is
+// used outside the windows.
+func (bc *Client) SetRateLimitSchedule(schedule *RateLimitSchedule) {
+	bc.rateLimitSchedule = schedule
+}
+
+// SetBandwidthBudget sets the bandwidth budget shared with the other tasks,
+// the rate limit of a range is capped by the share when it starts.
+func (bc *Client) SetBandwidthBudget(budget *utils.BandwidthBudget) {
+	bc.bandwidthBudget = budget
+}
+
+// SetLiveTuning sets the live tuning of the task, the rate limit and the
+// concurrency of a range are decided when it starts.
+func (bc *Client) SetLiveTuning(tuning *utils.LiveTuning) {
+	bc.liveTuning = tuning
+}
+
+// SetResourceLimiter sets the limiter of the resources used by BR, the ranges
+// wait for it before they start.
+func (bc *Client) SetResourceLimiter(limiter *utils.ResourceLimiter) {
+	bc.limiter = limiter
+}
+
+// SetTableTS sets the snapshot TS of the tables backed up at a TS other than
+// the backup TS, indexed by the physical table ID.
+func (bc *Client) SetTableTS(tableTS map[int64]uint64) {
+	bc.tableTS = tableTS
+}
+
+// SetLastTableTS sets the snapshot TS of the tables the last backup backed up
+// at a TS other than its backup TS, the incremental backup of these tables
+// starts from the TS instead of the last backup TS.
+func (bc *Client) SetLastTableTS(lastTableTS map[int64]uint64) {
+	bc.lastTableTS = lastTableTS
+}
+
+// SetTableBackends sets the backends writing the files of the tables other
+// than the backend of the storage, e.g. encrypting them by the keys of their
+// databases, indexed by the physical table ID.
+func (bc *Client) SetTableBackends(tableBackends map[int64]*kvproto.StorageBackend) {
+	bc.tableBackends = tableBackends
+}
+
+// backendOf returns the backend writing the files of the range starting at
+// the key.
+func (bc *Client) backendOf(startKey []byte) *kvproto.StorageBackend {
+	if backend, ok := bc.tableBackends[tablecodec.DecodeTableID(startKey)]; ok {
+		return backend
+	}
+	return bc.backend
+}
+
+// TableTSOf returns the snapshot TS of the tables backed up at a TS other than
+// the backup TS, recorded as the end version of their files, indexed by the
+// physical table ID.
+func TableTSOf(backupMeta *kvproto.BackupMeta) map[int64]uint64 {
+	tableTS := make(map[int64]uint64)
+	if backupMeta.IsRawKv {
+		return tableTS
+	}
+	for _, file := range backupMeta.Files {
+		if file.EndVersion != 0 && file.EndVersion < backupMeta.EndVersion {
+			tableTS[tablecodec.DecodeTableID(file.StartKey)] = file.EndVersion
+		}
+	}
+	return tableTS
+}
+
+// rangeRequest returns the request of the range starting at the key, with the
+// versions of the table it belongs to.
+func (bc *Client) rangeRequest(req kvproto.BackupRequest, startKey []byte) kvproto.BackupRequest {
+	tableID := tablecodec.DecodeTableID(startKey)
+	if ts, ok := bc.tableTS[tableID]; ok {
+		req.EndVersion = ts
+	}
+	// The changes after the TS the last backup backed up the table at aren't
+	// in any backup yet.
+	if ts, ok := bc.lastTableTS[tableID]; ok && ts < req.StartVersion {
+		req.StartVersion = ts
+	}
+	return req
+}
+
 // GetGCTTL get gcTTL for this backup.
 func (bc *Client) GetGCTTL() int64 {
 	return bc.gcTTL
 }
 
 // SetStorage set ExternalStorage for client.
-func (bc *Client) SetStorage(ctx context.Context, backend *kvproto.StorageBackend, sendCreds bool) error {
+func (bc *Client) SetStorage(ctx context.Context, backend *kvproto.StorageBackend, opts *storage.ExternalStorageOptions) error {
 	var err error
-	bc.storage, err = storage.Create(ctx, backend, sendCreds)
+	bc.storage, err = storage.New(ctx, backend, opts)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -168,17 +289,49 @@ func (bc *Client) SetStorage(ctx context.Context, backend *kvproto.StorageBacken
 	if exist {
 		return errors.Annotate(berrors.ErrInvalidArgument, "backup meta exists, may be some backup files in the path already")
 	}
-	exist, err = bc.storage.FileExists(ctx, utils.LockFile)
-	if err != nil {
-		return errors.Annotatef(err, "error occurred when checking %s file", utils.LockFile)
-	}
-	if exist {
-		return errors.Annotate(berrors.ErrInvalidArgument, "backup lock exists, may be some backup files in the path already")
+	// The backup to resume holds the lock file, which is checked when the
+	// sentinel is taken over.
+	if !bc.resume {
+		exist, err = bc.storage.FileExists(ctx, utils.LockFile)
+		if err != nil {
+			return errors.Annotatef(err, "error occurred when checking %s file", utils.LockFile)
+		}
+		if exist {
+			return errors.Annotate(berrors.ErrInvalidArgument, "backup lock exists, may be some backup files in the path already")
+		}
 	}
 	bc.backend = backend
 	return nil
 }
 
+// GetStorage returns the storage of the backup.
+func (bc *Client) GetStorage() storage.ExternalStorage {
+	return bc.storage
+}
+
+// EnableResume makes SetStorage accept the destination of the backup to
+// resume, which holds the lock file written by the previous run.
+func (bc *Client) EnableResume() {
+	bc.resume = true
+}
+
+// EnableSchemaOnly records the backup has the schemas without the data, so the
+// restore doesn't take the tables as empty.
+func (bc *Client) EnableSchemaOnly() {
+	bc.schemaOnly = true
+}
+
+// SetCheckpointer makes the ranges backed up recorded into the checkpoint,
+// and the ranges recorded by the previous runs skipped.
+func (bc *Client) SetCheckpointer(checkpointer *Checkpointer) {
+	bc.checkpointer = checkpointer
+}
+
+// SetDataKey sets the data key encrypting the backupmeta.
+func (bc *Client) SetDataKey(key *encryption.DataKey) {
+	bc.dataKey = key
+}
+
 // BuildBackupMeta constructs the backup meta file from its components.
 func BuildBackupMeta(
 	req *kvproto.BackupRequest,
@@ -208,7 +361,27 @@ func (bc *Client) SaveBackupMeta(ctx context.Context, backupMeta *kvproto.Backup
 	log.Debug("backup meta", zap.Reflect("meta", backupMeta))
 	backendURL := storage.FormatBackendURL(bc.backend)
 	log.Info("save backup meta", zap.Stringer("path", &backendURL), zap.Int("size", len(backupMetaData)))
-	return bc.storage.Write(ctx, utils.MetaFile, backupMetaData)
+	// Describe the format before the backupmeta, so the readers can check it
+	// before reading the backupmeta.
+	format := utils.NewBackupFormat(len(backupMetaData))
+	format.SchemaOnly = bc.schemaOnly
+	if utils.HasExternalSchemas(backupMeta) {
+		format.Require(utils.FormatExternalSchemas)
+	}
+	metaStorage := bc.storage
+	if bc.dataKey != nil {
+		format.Require(utils.FormatEncrypted)
+		if err = encryption.SaveDataKey(ctx, bc.storage, bc.dataKey.Info); err != nil {
+			return errors.Trace(err)
+		}
+		if metaStorage, err = bc.dataKey.Storage(bc.storage); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err = utils.SaveBackupFormat(ctx, bc.storage, format); err != nil {
+		return errors.Trace(err)
+	}
+	return utils.WriteMetaFile(ctx, metaStorage, utils.MetaFile, backupMetaData)
 }
 
 // BuildTableRanges returns the key ranges encompassing the entire table,
@@ -298,7 +471,15 @@ func BuildBackupRangeAndSchema(
 			var globalAutoID int64
 			switch {
 			case tableInfo.IsSequence():
+				// The value read here is never less than the one at backupTS,
+				// so the restored sequence won't produce duplicated values.
 				globalAutoID, err = seqAlloc.NextGlobalAutoID(tableInfo.ID)
+				if err == nil {
+					logger.Info("backup sequence value",
+						zap.Int64("value", globalAutoID),
+						zap.Int64("increment", tableInfo.Sequence.Increment),
+						zap.Bool("cycle", tableInfo.Sequence.Cycle))
+				}
 			case tableInfo.IsView() || !utils.NeedAutoID(tableInfo):
 				// no auto ID for views or table without either rowID nor auto_increment ID.
 			default:
@@ -420,12 +601,23 @@ func GetBackupDDLJobs(dom *domain.Domain, lastBackupTS, backupTS uint64) ([]*mod
 	allJobs = append(allJobs, historyJobs...)
 
 	completedJobs := make([]*model.Job, 0)
+	seen := make(map[int64]struct{}, len(allJobs))
 	for _, job := range allJobs {
+		// A finished job may be in both the queue and the history, while it's
+		// being moved.
+		if _, ok := seen[job.ID]; ok {
+			continue
+		}
 		if (job.State == model.JobStateDone || job.State == model.JobStateSynced) &&
 			(job.BinlogInfo != nil && job.BinlogInfo.SchemaVersion > lastSchemaVersion) {
+			seen[job.ID] = struct{}{}
 			completedJobs = append(completedJobs, job)
 		}
 	}
+	// Record the jobs in the order they're applied by restore.
+	sort.Slice(completedJobs, func(i, j int) bool {
+		return completedJobs[i].BinlogInfo.SchemaVersion < completedJobs[j].BinlogInfo.SchemaVersion
+	})
 	log.Debug("get completed jobs", zap.Int("jobs", len(completedJobs)))
 	return completedJobs, nil
 }
@@ -461,15 +653,43 @@ func (bc *Client) BackupRanges(
 		defer close(filesCh)
 		workerPool := utils.NewWorkerPool(concurrency, "Ranges")
 		eg, ectx := errgroup.WithContext(ctx)
+		skipped := 0
+		defer func() {
+			if skipped > 0 {
+				log.Info("skip the ranges backed up by the previous runs", zap.Int("ranges", skipped))
+				summary.CollectInt("backup resumed ranges", skipped)
+			}
+		}()
+	backupRanges:
 		for _, r := range ranges {
-			sk, ek := r.StartKey, r.EndKey
-			workerPool.ApplyOnErrorGroup(eg, func() error {
-				files, err := bc.BackupRange(ectx, sk, ek, req, updateCh)
-				if err == nil {
-					filesCh <- files
+			rangeReq := bc.rangeRequest(req, r.StartKey)
+			// Only the parts of the range not backed up by the previous runs
+			// are backed up.
+			files, gaps := bc.checkpointer.finished(r.StartKey, r.EndKey, rangeReq.EndVersion)
+			if len(files) > 0 {
+				filesCh <- files
+			}
+			if len(gaps) == 0 {
+				skipped++
+				continue
+			}
+			for _, gap := range gaps {
+				sk, ek := gap.StartKey, gap.EndKey
+				if err := bc.limiter.Acquire(ectx); err != nil {
+					// Fail the group, after the started ranges finish.
+					eg.Go(func() error { return errors.Trace(err) })
+					break backupRanges
 				}
-				return errors.Trace(err)
-			})
+				workerPool.ApplyOnErrorGroup(eg, func() error {
+					defer bc.limiter.Release()
+					files, err := bc.BackupRange(ectx, sk, ek, rangeReq, updateCh)
+					if err == nil {
+						bc.checkpointer.record(ectx, sk, ek, rangeReq.EndVersion, files)
+						filesCh <- files
+					}
+					return errors.Trace(err)
+				})
+			}
 		}
 		if err := eg.Wait(); err != nil {
 			errCh <- err
@@ -492,6 +712,15 @@ func (bc *Client) BackupRanges(
 	}
 }
 
+// filesBytes returns the total bytes of the files, before compression.
+func filesBytes(files []*kvproto.File) uint64 {
+	var size uint64
+	for _, file := range files {
+		size += file.GetTotalBytes()
+	}
+	return size
+}
+
 // BackupRange make a backup of the given key range.
 // Returns an array of files backed up.
 func (bc *Client) BackupRange(
@@ -509,6 +738,13 @@ func (bc *Client) BackupRange(
 			summary.CollectFailureUnit(key, err)
 		}
 	}()
+	if bc.rateLimitSchedule != nil {
+		req.RateLimit = bc.rateLimitSchedule.RateLimitAt(time.Now(), req.RateLimit)
+	}
+	// The tuned values win over the schedule.
+	req.RateLimit = bc.liveTuning.RateLimit(req.RateLimit)
+	req.Concurrency = bc.liveTuning.Concurrency(req.Concurrency)
+	req.RateLimit = bc.bandwidthBudget.Limit(req.RateLimit)
 	log.Info("backup started",
 		logutil.Key("startKey", startKey),
 		logutil.Key("endKey", endKey),
@@ -524,7 +760,7 @@ func (bc *Client) BackupRange(
 	req.ClusterId = bc.clusterID
 	req.StartKey = startKey
 	req.EndKey = endKey
-	req.StorageBackend = bc.backend
+	req.StorageBackend = bc.backendOf(startKey)
 
 	push := newPushDown(bc.mgr, len(allStores))
 
@@ -559,6 +795,12 @@ func (bc *Client) BackupRange(
 		files = append(files, r.Files...)
 		return true
 	})
+	// The versions of the files record the TS of the tables backed up at
+	// their own TS in the backupmeta.
+	for _, file := range files {
+		file.StartVersion = req.StartVersion
+		file.EndVersion = req.EndVersion
+	}
 
 	// Check if there are duplicated files.
 	checkDupFiles(&results)
@@ -679,6 +921,7 @@ func (bc *Client) fineGrainedBackup(
 
 				// Update progress
 				updateCh.Inc()
+				glue.AddBytes(updateCh, filesBytes(resp.Files))
 			}
 		}
 
@@ -784,7 +1027,7 @@ func (bc *Client) handleFineGrained(
 		EndKey:           rg.EndKey,
 		StartVersion:     lastBackupTS,
 		EndVersion:       backupTS,
-		StorageBackend:   bc.backend,
+		StorageBackend:   bc.backendOf(rg.StartKey),
 		RateLimit:        rateLimit,
 		Concurrency:      concurrency,
 		CompressionType:  compressType,
@@ -23,6 +23,8 @@ import (
 	"github.com/pingcap/br/pkg/backup"
 	"github.com/pingcap/br/pkg/conn"
 	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
 )
 
 type testBackup struct {
@@ -93,7 +95,7 @@ func (r *testBackup) TestGetTS(c *C) {
 	_, err = r.mockPDClient.UpdateGCSafePoint(r.ctx, now)
 	c.Assert(err, IsNil)
 	_, err = r.backupClient.GetTS(r.ctx, 10*time.Hour, 0)
-	c.Assert(err, ErrorMatches, ".*GC safepoint [0-9]+ exceed TS [0-9]+.*")
+	c.Assert(err, ErrorMatches, ".*backup TS [0-9]+ .* is out of the GC window, the safe TS range is .*")
 
 	// timeago and backupts both exists, use backupts
 	backupts := oracle.ComposeTS(p+10, l)
@@ -180,3 +182,22 @@ func (r *testBackup) TestOnBackupRegionErrorResponse(c *C) {
 		}
 	}
 }
+
+func (r *testBackup) TestLockExists(c *C) {
+	dir := c.MkDir()
+	backend, err := storage.ParseBackend("local://"+dir, nil)
+	c.Assert(err, IsNil)
+	stg, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	c.Assert(stg.Write(r.ctx, utils.LockFile, []byte("DO NOT DELETE")), IsNil)
+
+	mockMgr := &conn.Mgr{PdController: &pdutil.PdController{}}
+	mockMgr.SetPDClient(r.mockPDClient)
+	client, err := backup.NewBackupClient(r.ctx, mockMgr)
+	c.Assert(err, IsNil)
+	err = client.SetStorage(r.ctx, backend, &storage.ExternalStorageOptions{})
+	c.Assert(err, ErrorMatches, ".*backup lock exists.*")
+	// The backup to resume takes over its own lock file.
+	client.EnableResume()
+	c.Assert(client.SetStorage(r.ctx, backend, &storage.ExternalStorageOptions{}), IsNil)
+}
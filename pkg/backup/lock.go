@@ -0,0 +1,204 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.etcd.io/etcd/clientv3"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+const (
+	// backupLockPrefix is the prefix of the backup locks in the etcd of PD.
+	backupLockPrefix = "/tidb/br/backup-lock/"
+	// restoreLockPrefix is the prefix of the restore locks in the etcd of PD.
+	restoreLockPrefix = "/tidb/br/restore-lock/"
+	// BackupLockTTL is the TTL (in seconds) of the backup lock lease. The lock
+	// is released automatically once BR exits without refreshing the lease.
+	BackupLockTTL = 60
+)
+
+// LockInfo is the value of the backup lock.
+type LockInfo struct {
+	Owner      string    `json:"owner"`
+	Storage    string    `json:"storage"`
+	AcquiredAt time.Time `json:"acquired-at"`
+}
+
+// BackupLockKey returns the etcd key of the lock of the backup destination.
+func BackupLockKey(storageURL string) string {
+	return lockKey(backupLockPrefix, storageURL)
+}
+
+// RestoreLockKey returns the etcd key of the lock of restoring from the
+// backup.
+func RestoreLockKey(storageURL string) string {
+	return lockKey(restoreLockPrefix, storageURL)
+}
+
+func lockKey(prefix, storageURL string) string {
+	hash := sha256.Sum256([]byte(storageURL))
+	return prefix + hex.EncodeToString(hash[:])
+}
+
+func lockOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}
+
+// BackupLock is a cluster-scoped lock of a backup destination, which prevents
+// two backups of the same cluster from writing to the same destination, or
+// two restores of the same cluster from the same backup.
+type BackupLock struct {
+	cli     *clientv3.Client
+	key     string
+	leaseID clientv3.LeaseID
+	lost    chan struct{}
+	cancel  context.CancelFunc
+}
+
+// AcquireBackupLock acquires the lock of the backup destination, and keeps it
+// alive until Release is called.
+func AcquireBackupLock(ctx context.Context, cli *clientv3.Client, storageURL string) (*BackupLock, error) {
+	lock, err := acquireLock(ctx, cli, BackupLockKey(storageURL), storageURL)
+	if err != nil {
+		return nil, errors.Annotate(err, "use `br backup unlock` if the holder has gone")
+	}
+	return lock, nil
+}
+
+// AcquireRestoreLock acquires the lock of restoring from the backup, so only
+// one restore from the backup records the restore checkpoint at a time. It's
+// kept alive until Release is called.
+func AcquireRestoreLock(ctx context.Context, cli *clientv3.Client, storageURL string) (*BackupLock, error) {
+	lock, err := acquireLock(ctx, cli, RestoreLockKey(storageURL), storageURL)
+	if err != nil {
+		return nil, errors.Annotate(err, "another restore from the backup is running")
+	}
+	return lock, nil
+}
+
+// GetRestoreLock returns the holder of the restore lock of the backup, or nil
+// if no restore from the backup is running.
+func GetRestoreLock(ctx context.Context, cli *clientv3.Client, storageURL string) (*LockInfo, error) {
+	resp, err := cli.Get(ctx, RestoreLockKey(storageURL))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	info := new(LockInfo)
+	if err := json.Unmarshal(resp.Kvs[0].Value, info); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return info, nil
+}
+
+func acquireLock(ctx context.Context, cli *clientv3.Client, key, storageURL string) (*BackupLock, error) {
+	value, err := json.Marshal(LockInfo{
+		Owner:      lockOwner(),
+		Storage:    storageURL,
+		AcquiredAt: time.Now(),
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	lease, err := cli.Grant(ctx, BackupLockTTL)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	resp, err := cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(value), clientv3.WithLease(lease.ID))).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		_, _ = cli.Revoke(ctx, lease.ID)
+		return nil, errors.Trace(err)
+	}
+	if !resp.Succeeded {
+		_, _ = cli.Revoke(ctx, lease.ID)
+		holder := "unknown"
+		if kvs := resp.Responses[0].GetResponseRange().GetKvs(); len(kvs) > 0 {
+			holder = string(kvs[0].Value)
+		}
+		return nil, errors.Annotatef(berrors.ErrBackupLocked, "held by %s", holder)
+	}
+
+	keepCtx, cancel := context.WithCancel(context.Background())
+	ch, err := cli.KeepAlive(keepCtx, lease.ID)
+	if err != nil {
+		cancel()
+		_, _ = cli.Revoke(ctx, lease.ID)
+		return nil, errors.Trace(err)
+	}
+	lock := &BackupLock{
+		cli:     cli,
+		key:     key,
+		leaseID: lease.ID,
+		lost:    make(chan struct{}),
+		cancel:  cancel,
+	}
+	go func() {
+		for {
+			if _, ok := <-ch; !ok {
+				break
+			}
+		}
+		// The channel is closed when the lease can't be refreshed any more,
+		// or the lock is released.
+		if keepCtx.Err() == nil {
+			log.Warn("lock lost", zap.String("key", key))
+		}
+		close(lock.lost)
+	}()
+	log.Info("lock acquired", zap.String("key", key), zap.String("storage", storageURL))
+	return lock, nil
+}
+
+// Lost returns a channel which is closed once the lock is lost or released.
+func (l *BackupLock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Release releases the lock.
+func (l *BackupLock) Release(ctx context.Context) error {
+	l.cancel()
+	if _, err := l.cli.Revoke(ctx, l.leaseID); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("lock released", zap.String("key", l.key))
+	return nil
+}
+
+// ForceUnlockBackup removes the lock of the backup destination regardless of
+// the holder. It returns the removed lock, or nil if the destination isn't locked.
+func ForceUnlockBackup(ctx context.Context, cli *clientv3.Client, storageURL string) (*LockInfo, error) {
+	resp, err := cli.Delete(ctx, BackupLockKey(storageURL), clientv3.WithPrevKV())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(resp.PrevKvs) == 0 {
+		return nil, nil
+	}
+	info := new(LockInfo)
+	if err := json.Unmarshal(resp.PrevKvs[0].Value, info); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return info, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup_test
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/tikv/pd/pkg/tempurl"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/embed"
+
+	"github.com/pingcap/br/pkg/backup"
+)
+
+type testBackupLockSuite struct {
+	etcd *embed.Etcd
+	cli  *clientv3.Client
+}
+
+var _ = Suite(&testBackupLockSuite{})
+
+func (s *testBackupLockSuite) SetUpSuite(c *C) {
+	cfg := embed.NewConfig()
+	cfg.Dir = c.MkDir()
+	clientURL, err := url.Parse(tempurl.Alloc())
+	c.Assert(err, IsNil)
+	peerURL, err := url.Parse(tempurl.Alloc())
+	c.Assert(err, IsNil)
+	cfg.LCUrls = []url.URL{*clientURL}
+	cfg.ACUrls = []url.URL{*clientURL}
+	cfg.LPUrls = []url.URL{*peerURL}
+	cfg.APUrls = []url.URL{*peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	s.etcd, err = embed.StartEtcd(cfg)
+	c.Assert(err, IsNil)
+	select {
+	case <-s.etcd.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		c.Fatal("etcd isn't ready")
+	}
+	s.cli, err = clientv3.New(clientv3.Config{Endpoints: []string{clientURL.String()}})
+	c.Assert(err, IsNil)
+}
+
+func (s *testBackupLockSuite) TearDownSuite(c *C) {
+	s.cli.Close()
+	s.etcd.Close()
+}
+
+func (s *testBackupLockSuite) TestBackupLock(c *C) {
+	ctx := context.Background()
+	lock, err := backup.AcquireBackupLock(ctx, s.cli, "local:///tmp/backup1")
+	c.Assert(err, IsNil)
+
+	_, err = backup.AcquireBackupLock(ctx, s.cli, "local:///tmp/backup1")
+	c.Assert(err, ErrorMatches, ".*backup destination is locked.*")
+	// other destinations aren't affected.
+	other, err := backup.AcquireBackupLock(ctx, s.cli, "local:///tmp/backup2")
+	c.Assert(err, IsNil)
+	c.Assert(other.Release(ctx), IsNil)
+
+	c.Assert(lock.Release(ctx), IsNil)
+	<-lock.Lost()
+	lock, err = backup.AcquireBackupLock(ctx, s.cli, "local:///tmp/backup1")
+	c.Assert(err, IsNil)
+
+	info, err := backup.ForceUnlockBackup(ctx, s.cli, "local:///tmp/backup1")
+	c.Assert(err, IsNil)
+	c.Assert(info.Storage, Equals, "local:///tmp/backup1")
+	info, err = backup.ForceUnlockBackup(ctx, s.cli, "local:///tmp/backup1")
+	c.Assert(err, IsNil)
+	c.Assert(info, IsNil)
+	c.Assert(lock.Release(ctx), IsNil)
+}
+
+func (s *testBackupLockSuite) TestRestoreLock(c *C) {
+	ctx := context.Background()
+	info, err := backup.GetRestoreLock(ctx, s.cli, "local:///tmp/backup3")
+	c.Assert(err, IsNil)
+	c.Assert(info, IsNil)
+
+	lock, err := backup.AcquireRestoreLock(ctx, s.cli, "local:///tmp/backup3")
+	c.Assert(err, IsNil)
+	_, err = backup.AcquireRestoreLock(ctx, s.cli, "local:///tmp/backup3")
+	c.Assert(err, ErrorMatches, ".*another restore from the backup is running.*")
+	// The restore lock doesn't block the backups.
+	other, err := backup.AcquireBackupLock(ctx, s.cli, "local:///tmp/backup3")
+	c.Assert(err, IsNil)
+	c.Assert(other.Release(ctx), IsNil)
+
+	info, err = backup.GetRestoreLock(ctx, s.cli, "local:///tmp/backup3")
+	c.Assert(err, IsNil)
+	c.Assert(info.Storage, Equals, "local:///tmp/backup3")
+	c.Assert(lock.Release(ctx), IsNil)
+	info, err = backup.GetRestoreLock(ctx, s.cli, "local:///tmp/backup3")
+	c.Assert(err, IsNil)
+	c.Assert(info, IsNil)
+}
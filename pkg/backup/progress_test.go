@@ -0,0 +1,20 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	. "github.com/pingcap/check"
+	kvproto "github.com/pingcap/kvproto/pkg/backup"
+)
+
+type testProgressSuite struct{}
+
+var _ = Suite(&testProgressSuite{})
+
+func (s *testProgressSuite) TestFilesBytes(c *C) {
+	c.Assert(filesBytes(nil), Equals, uint64(0))
+	c.Assert(filesBytes([]*kvproto.File{
+		{Name: "1_write.sst", TotalBytes: 100},
+		{Name: "1_default.sst", TotalBytes: 20},
+	}), Equals, uint64(120))
+}
@@ -94,6 +94,7 @@ func (push *pushDown) pushBackup(
 					resp.GetStartKey(), resp.GetEndKey(), resp.GetFiles())
 				// Update progress
 				updateCh.Inc()
+				glue.AddBytes(updateCh, filesBytes(resp.GetFiles()))
 			} else {
 				errPb := resp.GetError()
 				switch v := errPb.Detail.(type) {
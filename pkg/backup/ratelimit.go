@@ -0,0 +1,146 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const minutesPerDay = 24 * 60
+
+// rateLimitUnits are the units of the rate limits in a schedule, the rate
+// limit without unit is in MB/s like `--ratelimit`. Like `--ratelimit`, an MB
+// is 1024*1024 bytes.
+var rateLimitUnits = []struct {
+	suffix string
+	unit   uint64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"B", 1},
+}
+
+type rateLimitWindow struct {
+	// start and end are the minutes of the day, end may be less than start
+	// for the window crossing the midnight.
+	start, end int
+	rateLimit  uint64
+}
+
+func (w rateLimitWindow) contains(minute int) bool {
+	if w.start < w.end {
+		return w.start <= minute && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// RateLimitSchedule is the rate limits of backup by time windows of the day,
+// e.g. `00:00-06:00=0,06:00-24:00=64MiB` runs at full speed overnight and
+// throttles to 64MiB/s during the day. The rate limit is in bytes per second,
+// 0 means unlimited.
+type RateLimitSchedule struct {
+	windows []rateLimitWindow
+}
+
+// ParseRateLimitSchedule parses the rate limit schedule, the windows are
+// separated by commas. The first window containing a time takes effect.
+func ParseRateLimitSchedule(s string) (*RateLimitSchedule, error) {
+	schedule := &RateLimitSchedule{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		window, err := parseRateLimitWindow(item)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		schedule.windows = append(schedule.windows, window)
+	}
+	if len(schedule.windows) == 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "empty rate limit schedule %q", s)
+	}
+	return schedule, nil
+}
+
+func parseRateLimitWindow(item string) (rateLimitWindow, error) {
+	eq := strings.IndexByte(item, '=')
+	dash := strings.IndexByte(item, '-')
+	if eq < 0 || dash < 0 || dash > eq {
+		return rateLimitWindow{}, errors.Annotatef(berrors.ErrInvalidArgument,
+			"invalid rate limit window %q, should be like 06:00-24:00=64MiB", item)
+	}
+	start, err := parseMinuteOfDay(item[:dash])
+	if err != nil {
+		return rateLimitWindow{}, errors.Trace(err)
+	}
+	end, err := parseMinuteOfDay(item[dash+1 : eq])
+	if err != nil {
+		return rateLimitWindow{}, errors.Trace(err)
+	}
+	if start == end || start == minutesPerDay {
+		return rateLimitWindow{}, errors.Annotatef(berrors.ErrInvalidArgument,
+			"invalid rate limit window %q", item)
+	}
+	rateLimit, err := parseRateLimit(item[eq+1:])
+	if err != nil {
+		return rateLimitWindow{}, errors.Trace(err)
+	}
+	return rateLimitWindow{start: start, end: end, rateLimit: rateLimit}, nil
+}
+
+// parseMinuteOfDay parses `HH:MM` to the minutes of the day, 24:00 is allowed
+// as the end of the day.
+func parseMinuteOfDay(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	colon := strings.IndexByte(s, ':')
+	if colon < 0 {
+		return 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid time %q, should be like 06:00", s)
+	}
+	hour, err1 := strconv.Atoi(s[:colon])
+	minute, err2 := strconv.Atoi(s[colon+1:])
+	if err1 != nil || err2 != nil || hour < 0 || minute < 0 || minute >= 60 ||
+		hour*60+minute > minutesPerDay {
+		return 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid time %q, should be like 06:00", s)
+	}
+	return hour*60 + minute, nil
+}
+
+func parseRateLimit(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	unit := uint64(utils.MB)
+	for _, u := range rateLimitUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.unit
+			break
+		}
+	}
+	value, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid rate limit %q", s)
+	}
+	return value * unit, nil
+}
+
+// RateLimitAt returns the rate limit at the time, or the fallback if no
+// window contains the time.
+func (s *RateLimitSchedule) RateLimitAt(t time.Time, fallback uint64) uint64 {
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range s.windows {
+		if w.contains(minute) {
+			return w.rateLimit
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,42 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup_test
+
+import (
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/backup"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+var _ = Suite(&testRateLimitSuite{})
+
+type testRateLimitSuite struct{}
+
+func (s *testRateLimitSuite) TestRateLimitSchedule(c *C) {
+	at := func(clock string) time.Time {
+		t, err := time.Parse("15:04", clock)
+		c.Assert(err, IsNil)
+		return t
+	}
+
+	schedule, err := backup.ParseRateLimitSchedule("00:00-06:00=0,06:00-24:00=64MiB")
+	c.Assert(err, IsNil)
+	c.Assert(schedule.RateLimitAt(at("03:00"), 1), Equals, uint64(0))
+	c.Assert(schedule.RateLimitAt(at("06:00"), 1), Equals, uint64(64*utils.MB))
+	c.Assert(schedule.RateLimitAt(at("23:59"), 1), Equals, uint64(64*utils.MB))
+
+	// The window crossing the midnight, and the rate limit without unit is in MB.
+	schedule, err = backup.ParseRateLimitSchedule("22:00-06:00=128")
+	c.Assert(err, IsNil)
+	c.Assert(schedule.RateLimitAt(at("23:00"), 1), Equals, uint64(128*utils.MB))
+	c.Assert(schedule.RateLimitAt(at("05:59"), 1), Equals, uint64(128*utils.MB))
+	c.Assert(schedule.RateLimitAt(at("12:00"), 1), Equals, uint64(1))
+
+	for _, invalid := range []string{"", "06:00=1", "06:00-06:00=1", "25:00-26:00=1", "06:00-08:00=fast"} {
+		_, err = backup.ParseRateLimitSchedule(invalid)
+		c.Assert(err, NotNil, Commentf("%s", invalid))
+	}
+}
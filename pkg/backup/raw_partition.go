@@ -0,0 +1,112 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/util/codec"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/rtree"
+)
+
+// partitionScanBatch is the count of regions scanned in a batch when
+// partitioning a range.
+const partitionScanBatch = 1024
+
+// RegionScanner scans the regions in a range, it's implemented by pd.Client.
+type RegionScanner interface {
+	ScanRegions(ctx context.Context, key, endKey []byte, limit int) ([]*metapb.Region, []*metapb.Peer, error)
+}
+
+// PartitionRawRange splits the raw kv range into sub-ranges at the region
+// boundaries, each of the sub-ranges covers regionsPerRange regions, except
+// the last one. So the sub-ranges can be backed up in parallel.
+func PartitionRawRange(
+	ctx context.Context,
+	scanner RegionScanner,
+	startKey, endKey []byte,
+	regionsPerRange int,
+) ([]rtree.Range, error) {
+	if regionsPerRange <= 0 {
+		return []rtree.Range{{StartKey: startKey, EndKey: endKey}}, nil
+	}
+	ranges := make([]rtree.Range, 0)
+	rangeStart, key := startKey, startKey
+	count := 0
+	for {
+		regions, _, err := scanner.ScanRegions(ctx, key, endKey, partitionScanBatch)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(regions) == 0 {
+			break
+		}
+		for _, region := range regions {
+			count++
+			regionEnd := region.GetEndKey()
+			if len(regionEnd) == 0 || (len(endKey) > 0 && bytes.Compare(regionEnd, endKey) >= 0) {
+				// It's the last region of the range.
+				return append(ranges, rtree.Range{StartKey: rangeStart, EndKey: endKey}), nil
+			}
+			if count%regionsPerRange == 0 {
+				ranges = append(ranges, rtree.Range{StartKey: rangeStart, EndKey: regionEnd})
+				rangeStart = regionEnd
+			}
+		}
+		key = regions[len(regions)-1].GetEndKey()
+	}
+	return append(ranges, rtree.Range{StartKey: rangeStart, EndKey: endKey}), nil
+}
+
+// PartitionTxnRanges splits the txn ranges covering more than maxRegions
+// regions at the region boundaries, so a request of a huge table doesn't
+// outlast its window in the stores, and a failed request retries fewer
+// regions. The keys of the regions are encoded, unlike the keys of the txn
+// ranges.
+func PartitionTxnRanges(
+	ctx context.Context,
+	scanner RegionScanner,
+	ranges []rtree.Range,
+	maxRegions int,
+) ([]rtree.Range, error) {
+	if maxRegions <= 0 {
+		return ranges, nil
+	}
+	partitioned := make([]rtree.Range, 0, len(ranges))
+	for _, r := range ranges {
+		startKey := codec.EncodeBytes(nil, r.StartKey)
+		endKey := []byte{}
+		if len(r.EndKey) > 0 {
+			endKey = codec.EncodeBytes(nil, r.EndKey)
+		}
+		parts, err := PartitionRawRange(ctx, scanner, startKey, endKey, maxRegions)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		rangeStart := r.StartKey
+		for _, part := range parts[:len(parts)-1] {
+			_, boundary, err := codec.DecodeBytes(part.EndKey, nil)
+			if err != nil || bytes.Compare(boundary, rangeStart) <= 0 ||
+				(len(r.EndKey) > 0 && bytes.Compare(boundary, r.EndKey) >= 0) {
+				// The region isn't split at a key of the range.
+				continue
+			}
+			partitioned = append(partitioned, rtree.Range{StartKey: rangeStart, EndKey: boundary})
+			rangeStart = boundary
+		}
+		partitioned = append(partitioned, rtree.Range{StartKey: rangeStart, EndKey: r.EndKey})
+	}
+	if len(partitioned) > len(ranges) {
+		log.Info("partition the ranges by regions",
+			zap.Int("ranges", len(ranges)),
+			zap.Int("partitioned", len(partitioned)),
+			zap.Int("max-regions", maxRegions))
+	}
+	return partitioned, nil
+}
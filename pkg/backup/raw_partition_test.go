@@ -0,0 +1,77 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup_test
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/store/mockstore/mocktikv"
+	"github.com/pingcap/tidb/util/codec"
+
+	"github.com/pingcap/br/pkg/backup"
+	"github.com/pingcap/br/pkg/rtree"
+)
+
+var _ = Suite(&testRawPartitionSuite{})
+
+type testRawPartitionSuite struct{}
+
+func (s *testRawPartitionSuite) TestPartitionRawRange(c *C) {
+	cluster := mocktikv.NewCluster()
+	mocktikv.BootstrapWithMultiRegions(cluster, []byte("b"), []byte("c"), []byte("d"), []byte("e"))
+	pdClient := mocktikv.NewPDClient(cluster)
+	ctx := context.Background()
+
+	ranges, err := backup.PartitionRawRange(ctx, pdClient, []byte("a"), []byte{}, 2)
+	c.Assert(err, IsNil)
+	c.Assert(ranges, DeepEquals, []rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("c")},
+		{StartKey: []byte("c"), EndKey: []byte("e")},
+		{StartKey: []byte("e"), EndKey: []byte{}},
+	})
+
+	ranges, err = backup.PartitionRawRange(ctx, pdClient, []byte("a"), []byte("cc"), 1)
+	c.Assert(err, IsNil)
+	c.Assert(ranges, DeepEquals, []rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("b")},
+		{StartKey: []byte("b"), EndKey: []byte("c")},
+		{StartKey: []byte("c"), EndKey: []byte("cc")},
+	})
+
+	ranges, err = backup.PartitionRawRange(ctx, pdClient, []byte("a"), []byte("cc"), 0)
+	c.Assert(err, IsNil)
+	c.Assert(ranges, DeepEquals, []rtree.Range{{StartKey: []byte("a"), EndKey: []byte("cc")}})
+}
+
+func (s *testRawPartitionSuite) TestPartitionTxnRanges(c *C) {
+	cluster := mocktikv.NewCluster()
+	mocktikv.BootstrapWithMultiRegions(cluster,
+		codec.EncodeBytes(nil, []byte("b")),
+		codec.EncodeBytes(nil, []byte("c")),
+		codec.EncodeBytes(nil, []byte("d")))
+	pdClient := mocktikv.NewPDClient(cluster)
+	ctx := context.Background()
+
+	ranges, err := backup.PartitionTxnRanges(ctx, pdClient, []rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("cc")},
+		{StartKey: []byte("cc"), EndKey: []byte{}},
+	}, 1)
+	c.Assert(err, IsNil)
+	c.Assert(ranges, DeepEquals, []rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("b")},
+		{StartKey: []byte("b"), EndKey: []byte("c")},
+		{StartKey: []byte("c"), EndKey: []byte("cc")},
+		{StartKey: []byte("cc"), EndKey: []byte("d")},
+		{StartKey: []byte("d"), EndKey: []byte{}},
+	})
+
+	ranges, err = backup.PartitionTxnRanges(ctx, pdClient, []rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("cc")},
+	}, 2)
+	c.Assert(err, IsNil)
+	c.Assert(ranges, DeepEquals, []rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("c")},
+		{StartKey: []byte("c"), EndKey: []byte("cc")},
+	})
+}
@@ -35,6 +35,9 @@ type Schemas struct {
 	schemas        map[string]backup.Schema
 	backupSchemaCh chan backup.Schema
 	errCh          chan error
+	// tableTS is the snapshot TS of the tables backed up at a TS other than
+	// the backup TS, indexed by the table ID.
+	tableTS map[int64]uint64
 }
 
 func newBackupSchemas() *Schemas {
@@ -54,6 +57,12 @@ func (pending *Schemas) pushPending(
 	pending.schemas[name] = schema
 }
 
+// SetTableTS sets the snapshot TS of the tables backed up at a TS other than
+// the backup TS, their checksums are calculated at the TS.
+func (pending *Schemas) SetTableTS(tableTS map[int64]uint64) {
+	pending.tableTS = tableTS
+}
+
 // Start backups schemas.
 func (pending *Schemas) Start(
 	ctx context.Context,
@@ -78,8 +87,12 @@ func (pending *Schemas) Start(
 				if err != nil {
 					return errors.Trace(err)
 				}
+				ts := backupTS
+				if tableTS, ok := pending.tableTS[table.ID]; ok {
+					ts = tableTS
+				}
 				checksumResp, err := calculateChecksum(
-					ectx, &table, store.GetClient(), backupTS, copConcurrency)
+					ectx, &table, store.GetClient(), ts, copConcurrency)
 				if err != nil {
 					return errors.Trace(err)
 				}
@@ -0,0 +1,233 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// DefaultSentinelTTL is the default TTL of the sentinel lock object, a
+// sentinel whose heartbeat is older than its TTL is considered abandoned.
+const DefaultSentinelTTL = 2 * time.Minute
+
+// SentinelInfo is the content of the sentinel lock object written to the
+// backup destination.
+type SentinelInfo struct {
+	Owner  string `json:"owner"`
+	TaskID string `json:"task-id"`
+	// Token identifies the holder, the sentinel may be overwritten by another
+	// host writing at the same time, and only the last writer holds it.
+	Token       string        `json:"token"`
+	AcquiredAt  time.Time     `json:"acquired-at"`
+	HeartbeatAt time.Time     `json:"heartbeat-at"`
+	TTL         time.Duration `json:"ttl"`
+	Released    bool          `json:"released"`
+}
+
+func (info *SentinelInfo) alive(now time.Time) bool {
+	return !info.Released && now.Sub(info.HeartbeatAt) < info.TTL
+}
+
+// SentinelLock is a lock of the backup destination held by a sentinel object
+// in the storage. Unlike BackupLock, it works across clusters and hosts, as
+// long as they write to the same storage.
+type SentinelLock struct {
+	storage storage.ExternalStorage
+	info    SentinelInfo
+
+	lost   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func readSentinel(ctx context.Context, s storage.ExternalStorage) (*SentinelInfo, error) {
+	exists, err := s.FileExists(ctx, utils.LockFile)
+	if err != nil {
+		return nil, errors.Annotatef(err, "error occurred when checking %s file", utils.LockFile)
+	}
+	if !exists {
+		return nil, nil
+	}
+	data, err := s.Read(ctx, utils.LockFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	info := new(SentinelInfo)
+	if err := json.Unmarshal(data, info); err != nil {
+		// The lock file written by the older versions has no heartbeat, it's
+		// never considered abandoned.
+		return nil, errors.Annotate(berrors.ErrBackupLocked,
+			"backup lock exists, may be some backup files in the path already")
+	}
+	return info, nil
+}
+
+// CheckNoRunningBackup returns ErrBackupLocked if a backup holding an alive
+// sentinel is writing to the storage. The lock files of the older versions
+// have no heartbeat, so they're ignored.
+func CheckNoRunningBackup(ctx context.Context, s storage.ExternalStorage) error {
+	info, err := readSentinel(ctx, s)
+	if err != nil {
+		if errors.Cause(err) == berrors.ErrBackupLocked { // nolint:errorlint
+			return nil
+		}
+		return errors.Trace(err)
+	}
+	if info != nil && info.alive(time.Now()) {
+		return errors.Annotatef(berrors.ErrBackupLocked,
+			"a backup is running by %s (task %s), last heartbeat at %s",
+			info.Owner, info.TaskID, info.HeartbeatAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (l *SentinelLock) write(ctx context.Context) error {
+	data, err := json.Marshal(l.info)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(l.storage.Write(ctx, utils.LockFile, data))
+}
+
+// AcquireSentinelLock writes the sentinel lock object to the backup
+// destination, and keeps its heartbeat until Release is called. It fails if
+// another backup holds a sentinel which is still alive, and takes over the
+// abandoned ones.
+func AcquireSentinelLock(ctx context.Context, s storage.ExternalStorage, ttl time.Duration) (*SentinelLock, error) {
+	if ttl <= 0 {
+		ttl = DefaultSentinelTTL
+	}
+	prev, err := readSentinel(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	now := time.Now()
+	if prev != nil {
+		if prev.alive(now) {
+			return nil, errors.Annotatef(berrors.ErrBackupLocked,
+				"the sentinel is held by %s (task %s), last heartbeat at %s",
+				prev.Owner, prev.TaskID, prev.HeartbeatAt.Format(time.RFC3339))
+		}
+		log.Warn("take over the abandoned backup sentinel",
+			zap.String("owner", prev.Owner),
+			zap.String("previous-task-id", prev.TaskID),
+			zap.Time("heartbeat-at", prev.HeartbeatAt),
+			zap.Bool("released", prev.Released))
+	}
+
+	l := &SentinelLock{
+		storage: s,
+		info: SentinelInfo{
+			Owner:       lockOwner(),
+			TaskID:      logutil.TaskIDFromContext(ctx),
+			Token:       uuid.New().String(),
+			AcquiredAt:  now,
+			HeartbeatAt: now,
+			TTL:         ttl,
+		},
+		lost: make(chan struct{}),
+	}
+	if err = l.write(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	// Object storages have no compare-and-swap, two hosts may both find no
+	// sentinel and write their own. Read it back after a while, so only the
+	// last writer goes on.
+	select {
+	case <-ctx.Done():
+		return nil, errors.Trace(ctx.Err())
+	case <-time.After(ttl / 30):
+	}
+	if err = l.check(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	hbCtx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	l.wg.Add(1)
+	go l.heartbeat(hbCtx)
+	log.Info("backup sentinel acquired", zap.String("token", l.info.Token), zap.Duration("ttl", ttl))
+	return l, nil
+}
+
+// check checks whether the sentinel is still held by this lock.
+func (l *SentinelLock) check(ctx context.Context) error {
+	current, err := readSentinel(ctx, l.storage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if current == nil {
+		return errors.Annotate(berrors.ErrBackupLocked, "the sentinel is removed by others")
+	}
+	if current.Token != l.info.Token {
+		return errors.Annotatef(berrors.ErrBackupLocked,
+			"the sentinel is taken by %s (task %s)", current.Owner, current.TaskID)
+	}
+	return nil
+}
+
+func (l *SentinelLock) heartbeat(ctx context.Context) {
+	defer l.wg.Done()
+	ticker := time.NewTicker(l.info.TTL / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := l.check(ctx)
+		if err == nil {
+			lastHeartbeat := l.info.HeartbeatAt
+			l.info.HeartbeatAt = time.Now()
+			if err = l.write(ctx); err == nil {
+				continue
+			}
+			l.info.HeartbeatAt = lastHeartbeat
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Cause(err) == berrors.ErrBackupLocked || time.Since(l.info.HeartbeatAt) >= l.info.TTL { // nolint:errorlint
+			// Another backup may take over the sentinel once it's abandoned.
+			log.Warn("backup sentinel lost", zap.Error(err))
+			close(l.lost)
+			return
+		}
+		log.Warn("failed to refresh the backup sentinel, will retry", zap.Error(err))
+	}
+}
+
+// Lost returns a channel which is closed once the sentinel is lost.
+func (l *SentinelLock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Release stops the heartbeat and marks the sentinel released, so the next
+// backup needn't wait for it to expire.
+func (l *SentinelLock) Release(ctx context.Context) error {
+	l.cancel()
+	l.wg.Wait()
+	if err := l.check(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	l.info.Released = true
+	if err := l.write(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("backup sentinel released", zap.String("token", l.info.Token))
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup_test
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/backup"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+type testSentinelSuite struct{}
+
+var _ = Suite(&testSentinelSuite{})
+
+func (s *testSentinelSuite) TestSentinelLock(c *C) {
+	ctx := context.Background()
+	stg, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	ttl := 300 * time.Millisecond
+	lock, err := backup.AcquireSentinelLock(ctx, stg, ttl)
+	c.Assert(err, IsNil)
+	// The heartbeat keeps the sentinel alive beyond its TTL.
+	time.Sleep(2 * ttl)
+	_, err = backup.AcquireSentinelLock(ctx, stg, ttl)
+	c.Assert(err, ErrorMatches, ".*the sentinel is held by.*")
+
+	c.Assert(lock.Release(ctx), IsNil)
+	lock, err = backup.AcquireSentinelLock(ctx, stg, ttl)
+	c.Assert(err, IsNil)
+	c.Assert(lock.Release(ctx), IsNil)
+}
+
+func (s *testSentinelSuite) TestTakeOverSentinel(c *C) {
+	ctx := context.Background()
+	stg, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	// An abandoned sentinel is taken over.
+	abandoned, err := json.Marshal(backup.SentinelInfo{
+		Owner:       "other:1",
+		Token:       "abandoned",
+		HeartbeatAt: time.Now().Add(-time.Hour),
+		TTL:         time.Minute,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(stg.Write(ctx, utils.LockFile, abandoned), IsNil)
+	lock, err := backup.AcquireSentinelLock(ctx, stg, 300*time.Millisecond)
+	c.Assert(err, IsNil)
+
+	// The lock is lost once another backup takes over the sentinel.
+	c.Assert(stg.Write(ctx, utils.LockFile, abandoned), IsNil)
+	select {
+	case <-lock.Lost():
+	case <-time.After(5 * time.Second):
+		c.Fatal("the lost sentinel isn't detected")
+	}
+	c.Assert(lock.Release(ctx), ErrorMatches, ".*the sentinel is taken by other:1.*")
+
+	// The lock file of the older versions is never taken over.
+	c.Assert(stg.Write(ctx, utils.LockFile, []byte("DO NOT DELETE")), IsNil)
+	_, err = backup.AcquireSentinelLock(ctx, stg, time.Millisecond)
+	c.Assert(err, ErrorMatches, ".*backup lock exists.*")
+}
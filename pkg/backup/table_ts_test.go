@@ -0,0 +1,76 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	. "github.com/pingcap/check"
+	kvproto "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+)
+
+type testTableTSSuite struct{}
+
+var _ = Suite(&testTableTSSuite{})
+
+func (s *testTableTSSuite) TestIncrementalFromTableTS(c *C) {
+	const (
+		tableTS   = 100
+		backupTS  = 200
+		writeTS   = 150
+		nextTS    = 300
+		tableID   = 42
+		otherID   = 43
+		rowHandle = 1
+	)
+	rowKey := tablecodec.EncodeRowKeyWithHandle(tableID, kv.IntHandle(rowHandle))
+	otherKey := tablecodec.EncodeRowKeyWithHandle(otherID, kv.IntHandle(rowHandle))
+
+	// The full backup backs up the table at its own TS, and a row of it is
+	// written in (tableTS, backupTS].
+	full := &Client{tableTS: map[int64]uint64{tableID: tableTS}}
+	req := kvproto.BackupRequest{StartVersion: 0, EndVersion: backupTS}
+	tableReq := full.rangeRequest(req, rowKey)
+	c.Assert(tableReq.EndVersion, Equals, uint64(tableTS))
+	c.Assert(writeTS > tableReq.EndVersion, IsTrue)
+	c.Assert(full.rangeRequest(req, otherKey).EndVersion, Equals, uint64(backupTS))
+
+	// The TS of the table is recorded in the backupmeta by its files.
+	meta := &kvproto.BackupMeta{
+		EndVersion: backupTS,
+		Files: []*kvproto.File{
+			{StartKey: rowKey, EndVersion: tableReq.EndVersion},
+			{StartKey: otherKey, EndVersion: backupTS},
+		},
+	}
+	lastTableTS := TableTSOf(meta)
+	c.Assert(lastTableTS, DeepEquals, map[int64]uint64{tableID: tableTS})
+
+	// The next incremental backup covers the write.
+	incr := &Client{}
+	incr.SetLastTableTS(lastTableTS)
+	req = kvproto.BackupRequest{StartVersion: backupTS, EndVersion: nextTS}
+	tableReq = incr.rangeRequest(req, rowKey)
+	c.Assert(tableReq.StartVersion, Equals, uint64(tableTS))
+	c.Assert(tableReq.StartVersion < writeTS && writeTS <= tableReq.EndVersion, IsTrue)
+	otherReq := incr.rangeRequest(req, otherKey)
+	c.Assert(otherReq.StartVersion, Equals, uint64(backupTS))
+
+	c.Assert(TableTSOf(&kvproto.BackupMeta{IsRawKv: true, Files: meta.Files}), HasLen, 0)
+}
+
+func (s *testTableTSSuite) TestTableBackends(c *C) {
+	backend := &kvproto.StorageBackend{Backend: &kvproto.StorageBackend_S3{S3: &kvproto.S3{Bucket: "b"}}}
+	tenant := &kvproto.StorageBackend{Backend: &kvproto.StorageBackend_S3{
+		S3: &kvproto.S3{Bucket: "b", Sse: "aws:kms", SseKmsKeyId: "alias/tenant"},
+	}}
+	bc := &Client{backend: backend}
+	rowKey := tablecodec.EncodeRowKeyWithHandle(42, kv.IntHandle(1))
+	c.Assert(bc.backendOf(rowKey), Equals, backend)
+
+	// The files of the table are written by the backend of its database.
+	bc.SetTableBackends(map[int64]*kvproto.StorageBackend{42: tenant})
+	c.Assert(bc.backendOf(rowKey), Equals, tenant)
+	c.Assert(bc.backendOf(tablecodec.GenTableIndexPrefix(42)), Equals, tenant)
+	c.Assert(bc.backendOf(tablecodec.EncodeRowKeyWithHandle(43, kv.IntHandle(1))), Equals, backend)
+}
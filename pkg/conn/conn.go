@@ -38,16 +38,22 @@ const (
 // Mgr manages connections to a TiDB cluster.
 type Mgr struct {
 	*pdutil.PdController
-	tlsConf  *tls.Config
-	dom      *domain.Domain
-	storage  tikv.Storage
-	grpcClis struct {
+	// tlsConf is used by the connections to TiKV.
+	tlsConf   *tls.Config
+	pdTLSConf *tls.Config
+	dom       *domain.Domain
+	storage   tikv.Storage
+	grpcClis  struct {
 		mu   sync.Mutex
 		clis map[uint64]*grpc.ClientConn
 	}
 	keepalive   keepalive.ClientParameters
 	ownsStorage bool
 	keepDomain  bool
+	// grpcDialOpts are the extra options used to connect TiKV.
+	grpcDialOpts []grpc.DialOption
+	// maxMsgSize is the max size of the gRPC messages to TiKV.
+	maxMsgSize utils.GRPCMaxMsgSize
 }
 
 // StoreBehavior is the action to do in GetAllTiKVStores when a non-TiKV
@@ -107,13 +113,15 @@ func NewMgr(
 	g glue.Glue,
 	pdAddrs string,
 	storage tikv.Storage,
-	tlsConf *tls.Config,
+	pdTLSConf *tls.Config,
+	tikvTLSConf *tls.Config,
 	securityOption pd.SecurityOption,
 	keepalive keepalive.ClientParameters,
+	maxMsgSize utils.GRPCMaxMsgSize,
 	storeBehavior StoreBehavior,
 	checkRequirements bool,
 ) (*Mgr, error) {
-	controller, err := pdutil.NewPdController(ctx, pdAddrs, tlsConf, securityOption)
+	controller, err := pdutil.NewPdController(ctx, pdAddrs, pdTLSConf, securityOption, maxMsgSize)
 	if err != nil {
 		log.Error("fail to create pd controller", zap.Error(err))
 		return nil, errors.Trace(err)
@@ -156,14 +164,27 @@ func NewMgr(
 		PdController: controller,
 		storage:      storage,
 		dom:          dom,
-		tlsConf:      tlsConf,
+		tlsConf:      tikvTLSConf,
+		pdTLSConf:    pdTLSConf,
 		ownsStorage:  g.OwnsStorage(),
 	}
 	mgr.grpcClis.clis = make(map[uint64]*grpc.ClientConn)
 	mgr.keepalive = keepalive
+	mgr.maxMsgSize = maxMsgSize
 	return mgr, nil
 }
 
+// GetGRPCMaxMsgSize returns the max size of the gRPC messages of the clients.
+func (mgr *Mgr) GetGRPCMaxMsgSize() utils.GRPCMaxMsgSize {
+	return mgr.maxMsgSize
+}
+
+// SetGRPCCompression sets the compression of the gRPC connections to TiKV, the
+// messages are counted by the wire stats.
+func (mgr *Mgr) SetGRPCCompression(name string, wireStats *utils.GRPCWireStats) {
+	mgr.grpcDialOpts = utils.GRPCCompressionDialOptions(name, wireStats)
+}
+
 func (mgr *Mgr) getGrpcConnLocked(ctx context.Context, storeID uint64) (*grpc.ClientConn, error) {
 	store, err := mgr.GetPDClient().GetStore(ctx, storeID)
 	if err != nil {
@@ -180,14 +201,16 @@ func (mgr *Mgr) getGrpcConnLocked(ctx context.Context, storeID uint64) (*grpc.Cl
 	if addr == "" {
 		addr = store.GetAddress()
 	}
-	conn, err := grpc.DialContext(
-		ctx,
-		addr,
+	addr = utils.MapStoreAddr(addr)
+	opts := append([]grpc.DialOption{
 		opt,
 		grpc.WithBlock(),
 		grpc.WithConnectParams(grpc.ConnectParams{Backoff: bfConf}),
 		grpc.WithKeepaliveParams(mgr.keepalive),
-	)
+	}, mgr.grpcDialOpts...)
+	opts = append(opts, mgr.maxMsgSize.DialOptions()...)
+	opts = append(opts, utils.GRPCTaskDialOptions()...)
+	conn, err := grpc.DialContext(ctx, addr, opts...)
 	cancel()
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -254,11 +277,16 @@ func (mgr *Mgr) GetTiKV() tikv.Storage {
 	return mgr.storage
 }
 
-// GetTLSConfig returns the tls config.
+// GetTLSConfig returns the tls config of the connections to TiKV.
 func (mgr *Mgr) GetTLSConfig() *tls.Config {
 	return mgr.tlsConf
 }
 
+// GetPDTLSConfig returns the tls config of the connections to PD.
+func (mgr *Mgr) GetPDTLSConfig() *tls.Config {
+	return mgr.pdTLSConf
+}
+
 // GetLockResolver gets the LockResolver.
 func (mgr *Mgr) GetLockResolver() *tikv.LockResolver {
 	return mgr.storage.GetLockResolver()
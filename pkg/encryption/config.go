@@ -0,0 +1,200 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package encryption
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/spf13/pflag"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// The encryption methods.
+const (
+	// MethodPlaintext means the backup isn't encrypted by BR.
+	MethodPlaintext = "plaintext"
+	// MethodAES256GCM encrypts the files with AES-256-GCM.
+	MethodAES256GCM = "aes256-gcm"
+)
+
+// The vendors of the KMS managing the master key.
+const (
+	KMSVendorAWS = "aws"
+	KMSVendorGCP = "gcp"
+)
+
+const (
+	flagMethod      = "crypter.method"
+	flagKeyFile     = "crypter.key-file"
+	flagKMSVendor   = "crypter.kms-vendor"
+	flagKMSKeyID    = "crypter.kms-key-id"
+	flagKMSRegion   = "crypter.kms-region"
+	flagKMSEndpoint = "crypter.kms-endpoint"
+	flagKeyMapping  = "crypter.key-mapping"
+)
+
+// KMSConfig is the configuration of the master key managed by a KMS.
+type KMSConfig struct {
+	// Vendor is the vendor of the KMS, aws or gcp.
+	Vendor string `json:"vendor" toml:"vendor"`
+	// KeyID is the ID of the key, the key ID or ARN of AWS KMS, or the
+	// resource name of GCP KMS, e.g.
+	// `projects/p/locations/l/keyRings/r/cryptoKeys/k`.
+	KeyID string `json:"key-id" toml:"key-id"`
+	// Region is the region of AWS KMS.
+	Region string `json:"region" toml:"region"`
+	// Endpoint overrides the endpoint of the KMS.
+	Endpoint string `json:"endpoint" toml:"endpoint"`
+}
+
+// Config is the configuration of the client-side encryption of the backup.
+type Config struct {
+	Method string `json:"method" toml:"method"`
+	// KeyFile is the file of the master key, a hex-encoded 256-bit key.
+	KeyFile string    `json:"key-file" toml:"key-file"`
+	KMS     KMSConfig `json:"kms" toml:"kms"`
+	// Databases is the master keys of the databases whose table schemas are
+	// encrypted by the data keys of their own, and whose SST files are
+	// encrypted by the master keys, indexed by the lower-case name of the
+	// database. It's loaded from the file of --crypter.key-mapping.
+	Databases map[string]*Config `json:"databases,omitempty" toml:"databases"`
+}
+
+// DefineFlags adds the flags of the encryption.
+func DefineFlags(flags *pflag.FlagSet) {
+	flags.String(flagMethod, MethodPlaintext,
+		"encrypt the backupmeta and the schemas written by BR on the client side, "+
+			"value can be one of 'plaintext|aes256-gcm', the restore must be given the same master key; "+
+			"the SST files are written by TiKV and encrypted by the server-side encryption of S3 with the master key, "+
+			"so the backup requires S3 storage and the master key on AWS KMS")
+	flags.String(flagKeyFile, "",
+		"the file of the master key encrypting the data key of the backup, a hex-encoded 256-bit key; "+
+			"it can't encrypt the SST files, so it's refused by backup")
+	flags.String(flagKMSVendor, "",
+		"the KMS managing the master key instead of --crypter.key-file, value can be one of 'aws|gcp'; "+
+			"only the key on AWS KMS encrypts the SST files, so gcp is refused by backup")
+	flags.String(flagKMSKeyID, "",
+		"the master key in the KMS, the key ID or ARN of AWS KMS, or the resource name of GCP KMS, "+
+			"e.g. 'projects/p/locations/l/keyRings/r/cryptoKeys/k'")
+	flags.String(flagKMSRegion, "", "the region of AWS KMS, e.g. us-east-1")
+	flags.String(flagKMSEndpoint, "", "override the endpoint of the KMS")
+	flags.String(flagKeyMapping, "",
+		"a JSON file mapping the databases to the master keys on AWS KMS encrypting their schemas and SST files, "+
+			`e.g. '{"tenant1": {"kms": {"vendor": "aws", "key-id": "alias/t1"}}}', `+
+			"a restore only needs the keys of the databases it restores, it requires --external-schemas in backup")
+}
+
+// ParseFromFlags parses the encryption config from the flag set.
+func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
+	if flags.Lookup(flagMethod) == nil {
+		return nil
+	}
+	var err error
+	cfg.Method, err = flags.GetString(flagMethod)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.KeyFile, err = flags.GetString(flagKeyFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.KMS.Vendor, err = flags.GetString(flagKMSVendor)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.KMS.KeyID, err = flags.GetString(flagKMSKeyID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.KMS.Region, err = flags.GetString(flagKMSRegion)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.KMS.Endpoint, err = flags.GetString(flagKMSEndpoint)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	keyMapping, err := flags.GetString(flagKeyMapping)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if keyMapping != "" {
+		if cfg.Databases, err = loadKeyMapping(keyMapping); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return errors.Trace(cfg.validate())
+}
+
+// loadKeyMapping reads the master keys of the databases from the file.
+func loadKeyMapping(path string) (map[string]*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read the key mapping file %s", path)
+	}
+	mapping := make(map[string]*Config)
+	if err = json.Unmarshal(data, &mapping); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to parse the key mapping file %s: %v", path, err)
+	}
+	databases := make(map[string]*Config, len(mapping))
+	for db, cfg := range mapping {
+		if cfg == nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "no master key of database %s", db)
+		}
+		databases[strings.ToLower(db)] = cfg
+	}
+	return databases, nil
+}
+
+func (cfg *Config) validate() error {
+	cfg.Method = strings.ToLower(cfg.Method)
+	cfg.KMS.Vendor = strings.ToLower(cfg.KMS.Vendor)
+	switch cfg.Method {
+	case "", MethodPlaintext:
+		if len(cfg.Databases) > 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s requires --%s %s", flagKeyMapping, flagMethod, MethodAES256GCM)
+		}
+		return nil
+	case MethodAES256GCM:
+	default:
+		return errors.Annotatef(berrors.ErrInvalidArgument, "unsupported --%s %s", flagMethod, cfg.Method)
+	}
+	if (cfg.KeyFile == "") == (cfg.KMS.Vendor == "") {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"exactly one of --%s and --%s must be set", flagKeyFile, flagKMSVendor)
+	}
+	switch cfg.KMS.Vendor {
+	case "":
+	case KMSVendorAWS, KMSVendorGCP:
+		if cfg.KMS.KeyID == "" {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "--%s is required by the KMS", flagKMSKeyID)
+		}
+	default:
+		return errors.Annotatef(berrors.ErrInvalidArgument, "unsupported --%s %s", flagKMSVendor, cfg.KMS.Vendor)
+	}
+	for db, dbCfg := range cfg.Databases {
+		if len(dbCfg.Databases) > 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "the master key of database %s can't be mapped", db)
+		}
+		if dbCfg.Method == "" {
+			dbCfg.Method = MethodAES256GCM
+		}
+		if err := dbCfg.validate(); err != nil {
+			return errors.Annotatef(err, "the master key of database %s", db)
+		}
+		if !dbCfg.Enabled() {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "the master key of database %s is required", db)
+		}
+	}
+	return nil
+}
+
+// Enabled returns whether the backup is encrypted.
+func (cfg *Config) Enabled() bool {
+	return cfg.Method != "" && cfg.Method != MethodPlaintext
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pingcap/errors"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// DataKeyFile is the file of the encrypted data key of the backup. It isn't
+// encrypted itself, so the restore can find which master key it requires.
+const DataKeyFile = "backup.encryption"
+
+// dataKeySize is the size of the data key of AES-256-GCM.
+const dataKeySize = 32
+
+// DataKeyInfo is the data key of the backup encrypted by the master key.
+type DataKeyInfo struct {
+	Method string `json:"method"`
+	// MasterKey describes the master key, e.g. `file` or `aws-kms:<key-id>`.
+	MasterKey    string `json:"master-key"`
+	EncryptedKey []byte `json:"encrypted-key"`
+	// Databases is the data keys encrypting the table schemas of the
+	// databases with keys of their own, indexed by the lower-case name.
+	Databases map[string]*DataKeyInfo `json:"databases,omitempty"`
+}
+
+// DataKey is the key encrypting the files of the backup.
+type DataKey struct {
+	Key  []byte
+	Info *DataKeyInfo
+	// Databases is the decrypted data keys of the databases, a database in
+	// Info.Databases but not here can't be read.
+	Databases map[string]*DataKey
+}
+
+// NewDataKey generates a data key, and encrypts it by the master key.
+func NewDataKey(ctx context.Context, masterKey MasterKey) (*DataKey, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, errors.Trace(err)
+	}
+	encrypted, err := masterKey.Encrypt(ctx, key)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &DataKey{
+		Key: key,
+		Info: &DataKeyInfo{
+			Method:       MethodAES256GCM,
+			MasterKey:    masterKey.String(),
+			EncryptedKey: encrypted,
+		},
+	}, nil
+}
+
+// NewDatabaseKeys generates the data keys of the databases mapped to the
+// master keys of their own by the config.
+func (k *DataKey) NewDatabaseKeys(ctx context.Context, cfg *Config) error {
+	for db, dbCfg := range cfg.Databases {
+		masterKey, err := NewMasterKey(ctx, dbCfg)
+		if err != nil {
+			return errors.Annotatef(err, "the master key of database %s", db)
+		}
+		key, err := NewDataKey(ctx, masterKey)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if k.Databases == nil {
+			k.Databases = make(map[string]*DataKey)
+			k.Info.Databases = make(map[string]*DataKeyInfo)
+		}
+		k.Databases[db] = key
+		k.Info.Databases[db] = key.Info
+	}
+	return nil
+}
+
+// DatabaseStorage returns the storage encrypting and decrypting the table
+// schemas of the database, by the data key of the database if it has one.
+func (k *DataKey) DatabaseStorage(s storage.ExternalStorage, db string) (storage.ExternalStorage, error) {
+	name := strings.ToLower(db)
+	if key, ok := k.Databases[name]; ok {
+		return key.Storage(s)
+	}
+	if info, ok := k.Info.Databases[name]; ok {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"database %s is encrypted by the master key %s, set it in --%s", db, info.MasterKey, flagKeyMapping)
+	}
+	return k.Storage(s)
+}
+
+// Storage returns the storage encrypting the files written through it by the
+// data key, and decrypting the files read through it.
+func (k *DataKey) Storage(s storage.ExternalStorage) (storage.ExternalStorage, error) {
+	return storage.WithSidecarEncryption(s, k.Key)
+}
+
+// SaveDataKey saves the encrypted data key into the backup.
+func SaveDataKey(ctx context.Context, s storage.ExternalStorage, info *DataKeyInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.Write(ctx, DataKeyFile, data))
+}
+
+// ReadDataKey reads the data key of the backup, and decrypts it by the master
+// key of the config.
+func ReadDataKey(ctx context.Context, s storage.ExternalStorage, cfg *Config) (*DataKey, error) {
+	data, err := s.Read(ctx, DataKeyFile)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read the data key of the backup")
+	}
+	info := &DataKeyInfo{}
+	if err = json.Unmarshal(data, info); err != nil {
+		return nil, errors.Annotatef(berrors.ErrRestoreInvalidBackup, "failed to parse %s: %v", DataKeyFile, err)
+	}
+	key, err := decryptDataKey(ctx, info, cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// Only the databases whose master keys are given can be read.
+	for db, dbInfo := range info.Databases {
+		dbCfg, ok := cfg.Databases[db]
+		if !ok {
+			continue
+		}
+		dbKey, err := decryptDataKey(ctx, dbInfo, dbCfg)
+		if err != nil {
+			return nil, errors.Annotatef(err, "database %s", db)
+		}
+		if key.Databases == nil {
+			key.Databases = make(map[string]*DataKey)
+		}
+		key.Databases[db] = dbKey
+	}
+	return key, nil
+}
+
+// decryptDataKey decrypts the data key by the master key of the config.
+func decryptDataKey(ctx context.Context, info *DataKeyInfo, cfg *Config) (*DataKey, error) {
+	if info.Method != MethodAES256GCM {
+		return nil, errors.Annotatef(berrors.ErrRestoreInvalidBackup,
+			"unsupported encryption method %s of the backup", info.Method)
+	}
+	if !cfg.Enabled() {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the backup is encrypted by the master key %s, set --%s and the master key", info.MasterKey, flagMethod)
+	}
+	masterKey, err := NewMasterKey(ctx, cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	key, err := masterKey.Decrypt(ctx, info.EncryptedKey)
+	if err != nil {
+		return nil, errors.Annotatef(err, "the backup is encrypted by the master key %s", info.MasterKey)
+	}
+	if len(key) != dataKeySize {
+		return nil, errors.Annotatef(berrors.ErrStorageDecrypt, "invalid data key length %d", len(key))
+	}
+	return &DataKey{Key: key, Info: info}, nil
+}
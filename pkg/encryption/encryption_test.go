@@ -0,0 +1,133 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package encryption
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func TestT(t *testing.T) {
+	TestingT(t)
+}
+
+type testEncryptionSuite struct{}
+
+var _ = Suite(&testEncryptionSuite{})
+
+func writeKeyFile(c *C, key string) string {
+	path := filepath.Join(c.MkDir(), "master.key")
+	c.Assert(ioutil.WriteFile(path, []byte(key), 0o600), IsNil)
+	return path
+}
+
+func (s *testEncryptionSuite) TestValidate(c *C) {
+	cfg := &Config{Method: "AES256-GCM", KeyFile: "master.key"}
+	c.Assert(cfg.validate(), IsNil)
+	c.Assert(cfg.Enabled(), IsTrue)
+
+	cfg = &Config{Method: "aes128-ctr"}
+	c.Assert(cfg.validate(), ErrorMatches, ".*unsupported --crypter.method aes128-ctr.*")
+	cfg = &Config{Method: MethodAES256GCM}
+	c.Assert(cfg.validate(), ErrorMatches, ".*exactly one of --crypter.key-file and --crypter.kms-vendor.*")
+	cfg = &Config{Method: MethodAES256GCM, KMS: KMSConfig{Vendor: "aws"}}
+	c.Assert(cfg.validate(), ErrorMatches, ".*--crypter.kms-key-id is required.*")
+	cfg = &Config{Method: MethodPlaintext}
+	c.Assert(cfg.validate(), IsNil)
+	c.Assert(cfg.Enabled(), IsFalse)
+}
+
+func (s *testEncryptionSuite) TestDataKey(c *C) {
+	ctx := context.Background()
+	local, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	_, err = newFileMasterKey(writeKeyFile(c, "0123"))
+	c.Assert(err, ErrorMatches, ".*must contain a hex-encoded 256-bit key.*")
+
+	cfg := &Config{
+		Method:  MethodAES256GCM,
+		KeyFile: writeKeyFile(c, "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\n"),
+	}
+	masterKey, err := NewMasterKey(ctx, cfg)
+	c.Assert(err, IsNil)
+	key, err := NewDataKey(ctx, masterKey)
+	c.Assert(err, IsNil)
+	c.Assert(key.Info.MasterKey, Equals, "file")
+	c.Assert(SaveDataKey(ctx, local, key.Info), IsNil)
+
+	read, err := ReadDataKey(ctx, local, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(read.Key, DeepEquals, key.Key)
+
+	// The data key encrypts the files written through its storage.
+	encrypted, err := key.Storage(local)
+	c.Assert(err, IsNil)
+	c.Assert(encrypted.Write(ctx, "backupmeta", []byte("meta")), IsNil)
+	decrypted, err := read.Storage(local)
+	c.Assert(err, IsNil)
+	data, err := decrypted.Read(ctx, "backupmeta")
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, []byte("meta"))
+
+	_, err = ReadDataKey(ctx, local, &Config{Method: MethodPlaintext})
+	c.Assert(err, ErrorMatches, ".*encrypted by the master key file, set --crypter.method.*")
+	other := &Config{
+		Method:  MethodAES256GCM,
+		KeyFile: writeKeyFile(c, "ff0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"),
+	}
+	_, err = ReadDataKey(ctx, local, other)
+	c.Assert(err, ErrorMatches, ".*the master key doesn't match.*")
+}
+
+func (s *testEncryptionSuite) TestDatabaseKeys(c *C) {
+	ctx := context.Background()
+	local, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	mainCfg := Config{
+		Method:  MethodAES256GCM,
+		KeyFile: writeKeyFile(c, "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"),
+	}
+	tenantCfg := &Config{
+		Method:  MethodAES256GCM,
+		KeyFile: writeKeyFile(c, "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"),
+	}
+	cfg := mainCfg
+	cfg.Databases = map[string]*Config{"tenant": tenantCfg}
+	c.Assert(cfg.validate(), IsNil)
+
+	masterKey, err := NewMasterKey(ctx, &cfg)
+	c.Assert(err, IsNil)
+	key, err := NewDataKey(ctx, masterKey)
+	c.Assert(err, IsNil)
+	c.Assert(key.NewDatabaseKeys(ctx, &cfg), IsNil)
+	c.Assert(key.Info.Databases, HasLen, 1)
+	c.Assert(SaveDataKey(ctx, local, key.Info), IsNil)
+	tenant, err := key.DatabaseStorage(local, "Tenant")
+	c.Assert(err, IsNil)
+	c.Assert(tenant.Write(ctx, "schema", []byte("schema")), IsNil)
+
+	// The database can be read with its own key only.
+	read, err := ReadDataKey(ctx, local, &cfg)
+	c.Assert(err, IsNil)
+	tenant, err = read.DatabaseStorage(local, "tenant")
+	c.Assert(err, IsNil)
+	data, err := tenant.Read(ctx, "schema")
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, []byte("schema"))
+	read, err = ReadDataKey(ctx, local, &mainCfg)
+	c.Assert(err, IsNil)
+	_, err = read.DatabaseStorage(local, "tenant")
+	c.Assert(err, ErrorMatches, ".*database tenant is encrypted by the master key file, set it in --crypter.key-mapping.*")
+	_, err = read.DatabaseStorage(local, "other")
+	c.Assert(err, IsNil)
+
+	cfg = Config{Method: MethodPlaintext, Databases: map[string]*Config{"tenant": tenantCfg}}
+	c.Assert(cfg.validate(), ErrorMatches, ".*--crypter.key-mapping requires --crypter.method aes256-gcm.*")
+}
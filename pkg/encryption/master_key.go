@@ -0,0 +1,199 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/pingcap/errors"
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/option"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// MasterKey encrypts the data key of the backup, the data key is stored in
+// the backup encrypted, so rotating the master key doesn't re-encrypt the
+// backup.
+type MasterKey interface {
+	// Encrypt encrypts the data key.
+	Encrypt(ctx context.Context, plaintext []byte) ([]byte, error)
+	// Decrypt decrypts the data key.
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+	// String describes the master key without the secret, e.g. `file`.
+	String() string
+}
+
+// NewMasterKey returns the master key of the config. Only the master key on
+// AWS KMS can encrypt a backup, which encrypts the SST files by the
+// server-side encryption of S3, the backup refuses the others.
+func NewMasterKey(ctx context.Context, cfg *Config) (MasterKey, error) {
+	switch cfg.KMS.Vendor {
+	case KMSVendorAWS:
+		return newAWSKMSMasterKey(&cfg.KMS)
+	case KMSVendorGCP:
+		return newGCPKMSMasterKey(ctx, &cfg.KMS)
+	}
+	if cfg.KeyFile == "" {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the master key is required, set --%s or --%s", flagKeyFile, flagKMSVendor)
+	}
+	return newFileMasterKey(cfg.KeyFile)
+}
+
+// fileMasterKey is the master key read from a local file.
+type fileMasterKey struct {
+	aead cipher.AEAD
+}
+
+func newFileMasterKey(path string) (*fileMasterKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read the master key file %s", path)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the master key file %s must contain a hex-encoded 256-bit key", path)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &fileMasterKey{aead: aead}, nil
+}
+
+// Encrypt implements MasterKey, the ciphertext is the nonce followed by the
+// sealed plaintext.
+func (k *fileMasterKey) Encrypt(_ context.Context, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(plaintext)+k.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return k.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt implements MasterKey.
+func (k *fileMasterKey) Decrypt(_ context.Context, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < k.aead.NonceSize() {
+		return nil, errors.Annotate(berrors.ErrStorageDecrypt, "the encrypted data key is truncated")
+	}
+	nonce := ciphertext[:k.aead.NonceSize()]
+	plaintext, err := k.aead.Open(nil, nonce, ciphertext[k.aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrStorageDecrypt, "the master key doesn't match: %v", err)
+	}
+	return plaintext, nil
+}
+
+func (k *fileMasterKey) String() string {
+	return "file"
+}
+
+// awsKMSMasterKey is the master key in AWS KMS, the credentials are from the
+// default chain of AWS SDK.
+type awsKMSMasterKey struct {
+	cli   *kms.KMS
+	keyID string
+}
+
+func newAWSKMSMasterKey(cfg *KMSConfig) (*awsKMSMasterKey, error) {
+	awsConfig := aws.NewConfig().WithRegion(cfg.Region)
+	if cfg.Endpoint != "" {
+		awsConfig.WithEndpoint(cfg.Endpoint)
+	}
+	ses, err := session.NewSessionWithOptions(session.Options{Config: *awsConfig})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &awsKMSMasterKey{cli: kms.New(ses), keyID: cfg.KeyID}, nil
+}
+
+// Encrypt implements MasterKey.
+func (k *awsKMSMasterKey) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	output, err := k.cli.EncryptWithContext(ctx, &kms.EncryptInput{
+		KeyId:     aws.String(k.keyID),
+		Plaintext: plaintext,
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to encrypt the data key by AWS KMS %s", k.keyID)
+	}
+	return output.CiphertextBlob, nil
+}
+
+// Decrypt implements MasterKey.
+func (k *awsKMSMasterKey) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	output, err := k.cli.DecryptWithContext(ctx, &kms.DecryptInput{CiphertextBlob: ciphertext})
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrStorageDecrypt,
+			"failed to decrypt the data key by AWS KMS %s: %v", k.keyID, err)
+	}
+	return output.Plaintext, nil
+}
+
+func (k *awsKMSMasterKey) String() string {
+	return "aws-kms:" + k.keyID
+}
+
+// gcpKMSMasterKey is the master key in GCP KMS, the credentials are the
+// application default credentials.
+type gcpKMSMasterKey struct {
+	keys *cloudkms.ProjectsLocationsKeyRingsCryptoKeysService
+	name string
+}
+
+func newGCPKMSMasterKey(ctx context.Context, cfg *KMSConfig) (*gcpKMSMasterKey, error) {
+	var opts []option.ClientOption
+	if cfg.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(cfg.Endpoint))
+	}
+	svc, err := cloudkms.NewService(ctx, opts...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &gcpKMSMasterKey{keys: svc.Projects.Locations.KeyRings.CryptoKeys, name: cfg.KeyID}, nil
+}
+
+// Encrypt implements MasterKey.
+func (k *gcpKMSMasterKey) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	resp, err := k.keys.Encrypt(k.name, &cloudkms.EncryptRequest{
+		Plaintext: base64.StdEncoding.EncodeToString(plaintext),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to encrypt the data key by GCP KMS %s", k.name)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(resp.Ciphertext)
+	return ciphertext, errors.Trace(err)
+}
+
+// Decrypt implements MasterKey.
+func (k *gcpKMSMasterKey) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	resp, err := k.keys.Decrypt(k.name, &cloudkms.DecryptRequest{
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrStorageDecrypt,
+			"failed to decrypt the data key by GCP KMS %s: %v", k.name, err)
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+	return plaintext, errors.Trace(err)
+}
+
+func (k *gcpKMSMasterKey) String() string {
+	return "gcp-kms:" + k.name
+}
@@ -11,15 +11,21 @@ var (
 	ErrUnknown         = errors.Normalize("internal error", errors.RFCCodeText("BR:Common:ErrUnknown"))
 	ErrInvalidArgument = errors.Normalize("invalid argument", errors.RFCCodeText("BR:Common:ErrInvalidArgument"))
 	ErrVersionMismatch = errors.Normalize("version mismatch", errors.RFCCodeText("BR:Common:ErrVersionMismatch"))
+	// ErrTempDirQuotaExceeded is the error raised when the files staged in the
+	// temporary directory exceed its quota.
+	ErrTempDirQuotaExceeded = errors.Normalize("temporary directory quota exceeded", errors.RFCCodeText("BR:Common:ErrTempDirQuotaExceeded"))
 
 	ErrPDUpdateFailed    = errors.Normalize("failed to update PD", errors.RFCCodeText("BR:PD:ErrPDUpdateFailed"))
 	ErrPDLeaderNotFound  = errors.Normalize("PD leader not found", errors.RFCCodeText("BR:PD:ErrPDLeaderNotFound"))
 	ErrPDInvalidResponse = errors.Normalize("PD invalid response", errors.RFCCodeText("BR:PD:ErrPDInvalidResponse"))
+	ErrPDBatchScanRegion = errors.Normalize("batch scan region", errors.RFCCodeText("BR:PD:ErrPDBatchScanRegion"))
 
 	ErrBackupChecksumMismatch    = errors.Normalize("backup checksum mismatch", errors.RFCCodeText("BR:Backup:ErrBackupChecksumMismatch"))
 	ErrBackupInvalidRange        = errors.Normalize("backup range invalid", errors.RFCCodeText("BR:Backup:ErrBackupInvalidRange"))
+	ErrBackupLocked              = errors.Normalize("backup destination is locked by another task", errors.RFCCodeText("BR:Backup:ErrBackupLocked"))
 	ErrBackupNoLeader            = errors.Normalize("backup no leader", errors.RFCCodeText("BR:Backup:ErrBackupNoLeader"))
 	ErrBackupGCSafepointExceeded = errors.Normalize("backup GC safepoint exceeded", errors.RFCCodeText("BR:Backup:ErrBackupGCSafepointExceeded"))
+	ErrBackupReferenced          = errors.Normalize("backup is referenced by other backups", errors.RFCCodeText("BR:Backup:ErrBackupReferenced"))
 
 	ErrRestoreModeMismatch     = errors.Normalize("restore mode mismatch", errors.RFCCodeText("BR:Restore:ErrRestoreModeMismatch"))
 	ErrRestoreRangeMismatch    = errors.Normalize("restore range mismatch", errors.RFCCodeText("BR:Restore:ErrRestoreRangeMismatch"))
@@ -33,14 +39,31 @@ var (
 	ErrRestoreInvalidRange     = errors.Normalize("invalid restore range", errors.RFCCodeText("BR:Restore:ErrRestoreInvalidRange"))
 	ErrRestoreWriteAndIngest   = errors.Normalize("failed to write and ingest", errors.RFCCodeText("BR:Restore:ErrRestoreWriteAndIngest"))
 	ErrRestoreSchemaNotExists  = errors.Normalize("schema not exists", errors.RFCCodeText("BR:Restore:ErrRestoreSchemaNotExists"))
+	ErrRestoreIncapableStore   = errors.Normalize("store can't restore files", errors.RFCCodeText("BR:Restore:ErrRestoreIncapableStore"))
+	// ErrRestoreFileRetryExhausted is the error raised when some files still
+	// failed to restore after retried by all workers.
+	ErrRestoreFileRetryExhausted = errors.Normalize("file retry budget exhausted", errors.RFCCodeText("BR:Restore:ErrRestoreFileRetryExhausted"))
+	// ErrRestoreTableRetryExhausted is the error raised when some tables still
+	// failed to restore after their table retries.
+	ErrRestoreTableRetryExhausted = errors.Normalize("table retry exhausted", errors.RFCCodeText("BR:Restore:ErrRestoreTableRetryExhausted"))
+	// ErrRestoreIncompatibleFormat is the error raised when the backup is in a
+	// format this BR can't read.
+	ErrRestoreIncompatibleFormat = errors.Normalize("incompatible backup format", errors.RFCCodeText("BR:Restore:ErrRestoreIncompatibleFormat"))
+	// ErrRestoreInvalidSST is the error raised when the logical restore can't
+	// read an SST file of the backup.
+	ErrRestoreInvalidSST = errors.Normalize("invalid sst file", errors.RFCCodeText("BR:Restore:ErrRestoreInvalidSST"))
 
 	// TODO maybe it belongs to PiTR.
 	ErrRestoreRTsConstrain = errors.Normalize("resolved ts constrain violation", errors.RFCCodeText("BR:Restore:ErrRestoreResolvedTsConstrain"))
 
 	ErrPiTRInvalidCDCLogFormat = errors.Normalize("invalid cdc log format", errors.RFCCodeText("BR:PiTR:ErrPiTRInvalidCDCLogFormat"))
+	// ErrPiTRCheckpointLag is returned when the checkpoint of the log backup
+	// lags behind more than the threshold.
+	ErrPiTRCheckpointLag = errors.Normalize("log backup checkpoint lags behind", errors.RFCCodeText("BR:PiTR:ErrPiTRCheckpointLag"))
 
 	ErrStorageUnknown       = errors.Normalize("unknown external storage error", errors.RFCCodeText("BR:ExternalStorage:ErrStorageUnknown"))
 	ErrStorageInvalidConfig = errors.Normalize("invalid external storage config", errors.RFCCodeText("BR:ExternalStorage:ErrStorageInvalidConfig"))
+	ErrStorageDecrypt       = errors.Normalize("failed to decrypt file", errors.RFCCodeText("BR:ExternalStorage:ErrStorageDecrypt"))
 
 	// Errors reported from TiKV.
 	ErrKVUnknown           = errors.Normalize("unknown tikv error", errors.RFCCodeText("BR:KV:ErrKVUnknown"))
@@ -35,6 +35,25 @@ type Session interface {
 	Close()
 }
 
+// GlobalVariableSession is a Session which can read the global variables.
+// It's optional, the features depending on it are skipped if the session
+// doesn't implement it.
+type GlobalVariableSession interface {
+	Session
+	// GetGlobalVariables returns all the global system variables.
+	GetGlobalVariables() (map[string]string, error)
+}
+
+// ShowCreateSession is a Session which can show the statements creating the
+// schemas, e.g. for auditing. It's optional like GlobalVariableSession.
+type ShowCreateSession interface {
+	Session
+	// ShowCreateDatabase returns the statement creating the database.
+	ShowCreateDatabase(schema *model.DBInfo) (string, error)
+	// ShowCreateTable returns the statement creating the table.
+	ShowCreateTable(table *model.TableInfo) (string, error)
+}
+
 // Progress is an interface recording the current execution progress.
 type Progress interface {
 	// Inc increases the progress. This method must be goroutine-safe, and can
@@ -44,3 +63,65 @@ type Progress interface {
 	// called.
 	Close()
 }
+
+// StagedProgress is a Progress which also shows the progress of each stage.
+type StagedProgress interface {
+	Progress
+	// AddStage adds a stage with the total count.
+	AddStage(name string, total int64) Progress
+}
+
+type stageProgress struct {
+	overall Progress
+	stage   Progress
+}
+
+func (p stageProgress) Inc() {
+	p.overall.Inc()
+	p.stage.Inc()
+}
+
+func (p stageProgress) Close() {
+	p.stage.Close()
+}
+
+func (p stageProgress) AddBytes(n uint64) {
+	AddBytes(p.stage, n)
+}
+
+// BytesProgress is a Progress which also counts the bytes processed.
+type BytesProgress interface {
+	Progress
+	// AddBytes increases the bytes processed.
+	AddBytes(n uint64)
+}
+
+// AddBytes adds the bytes processed to the progress, if it counts bytes.
+func AddBytes(p Progress, n uint64) {
+	if bp, ok := p.(BytesProgress); ok {
+		bp.AddBytes(n)
+	}
+}
+
+type nopProgress struct{}
+
+func (nopProgress) Inc()   {}
+func (nopProgress) Close() {}
+
+// NopProgress returns a Progress which shows nothing.
+func NopProgress() Progress {
+	return nopProgress{}
+}
+
+// AddStage adds a stage to the progress, the returned Progress increases both
+// the stage and the overall progress. If the progress doesn't show stages,
+// the returned Progress only increases the overall progress.
+//
+// Closing the returned Progress only completes the stage.
+func AddStage(p Progress, name string, total int64) Progress {
+	sp, ok := p.(StagedProgress)
+	if !ok {
+		return stageProgress{overall: p, stage: nopProgress{}}
+	}
+	return stageProgress{overall: p, stage: sp.AddStage(name, total)}
+}
@@ -156,6 +156,22 @@ func (gs *tidbSession) CreateTable(ctx context.Context, dbName model.CIStr, tabl
 	return d.CreateTableWithInfo(gs.se, dbName, table, ddl.OnExistIgnore, true)
 }
 
+// GetGlobalVariables implements glue.GlobalVariableSession.
+func (gs *tidbSession) GetGlobalVariables() (map[string]string, error) {
+	vars, err := gs.se.GetSessionVars().GlobalVarsAccessor.GetAllSysVars()
+	return vars, errors.Trace(err)
+}
+
+// ShowCreateDatabase implements glue.ShowCreateSession.
+func (gs *tidbSession) ShowCreateDatabase(schema *model.DBInfo) (string, error) {
+	return gs.showCreateDatabase(schema)
+}
+
+// ShowCreateTable implements glue.ShowCreateSession.
+func (gs *tidbSession) ShowCreateTable(table *model.TableInfo) (string, error) {
+	return gs.showCreateTable(table)
+}
+
 // Close implements glue.Session.
 func (gs *tidbSession) Close() {
 	gs.se.Close()
@@ -48,7 +48,17 @@ func (Glue) OwnsStorage() bool {
 
 // StartProgress implements glue.Glue.
 func (Glue) StartProgress(ctx context.Context, cmdName string, total int64, redirectLog bool) glue.Progress {
-	return utils.StartProgress(ctx, cmdName, total, redirectLog, nil)
+	return stagedProgress{utils.StartProgress(ctx, cmdName, total, redirectLog, nil)}
+}
+
+// stagedProgress implements glue.StagedProgress.
+type stagedProgress struct {
+	*utils.ProgressPrinter
+}
+
+// AddStage implements glue.StagedProgress.
+func (p stagedProgress) AddStage(name string, total int64) glue.Progress {
+	return p.ProgressPrinter.AddStage(name, total)
 }
 
 // Record implements glue.Glue.
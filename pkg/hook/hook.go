@@ -0,0 +1,114 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+// Package hook provides the fault-injection hooks of BR for the tests of the
+// applications embedding BR as a library. Unlike the failpoints, the hooks
+// work without building BR with the failpoint tags.
+package hook
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+// BeforeSplitFunc is called before BR splits the region at the keys, the
+// split fails with the returned error.
+type BeforeSplitFunc func(ctx context.Context, region *metapb.Region, keys [][]byte) error
+
+// BeforeIngestFunc is called before BR ingests the SST into the store, the
+// ingest fails with the returned error.
+type BeforeIngestFunc func(ctx context.Context, storeID uint64, sst *import_sstpb.SSTMeta) error
+
+// OnStorageWriteFunc is called before BR writes the file into the external
+// storage, the write fails with the returned error.
+type OnStorageWriteFunc func(ctx context.Context, name string, data []byte) error
+
+type hooks struct {
+	beforeSplit    BeforeSplitFunc
+	beforeIngest   BeforeIngestFunc
+	onStorageWrite OnStorageWriteFunc
+}
+
+// Option sets a hook.
+type Option func(*hooks)
+
+// WithBeforeSplit sets the hook called before splitting regions.
+func WithBeforeSplit(f BeforeSplitFunc) Option {
+	return func(h *hooks) {
+		h.beforeSplit = f
+	}
+}
+
+// WithBeforeIngest sets the hook called before ingesting SSTs.
+func WithBeforeIngest(f BeforeIngestFunc) Option {
+	return func(h *hooks) {
+		h.beforeIngest = f
+	}
+}
+
+// WithOnStorageWrite sets the hook called before writing files into the
+// external storage.
+func WithOnStorageWrite(f OnStorageWriteFunc) Option {
+	return func(h *hooks) {
+		h.onStorageWrite = f
+	}
+}
+
+// installed is the hooks of the whole process.
+var installed = struct {
+	mu sync.RWMutex
+	h  hooks
+}{}
+
+// Install installs the hooks for the whole process, replacing the hooks
+// installed before. The returned function uninstalls them, e.g.
+//
+//	defer hook.Install(hook.WithBeforeIngest(func(...) error {
+//		return errors.New("injected")
+//	}))()
+func Install(opts ...Option) (uninstall func()) {
+	var h hooks
+	for _, opt := range opts {
+		opt(&h)
+	}
+	installed.mu.Lock()
+	installed.h = h
+	installed.mu.Unlock()
+	return func() {
+		installed.mu.Lock()
+		installed.h = hooks{}
+		installed.mu.Unlock()
+	}
+}
+
+func current() hooks {
+	installed.mu.RLock()
+	defer installed.mu.RUnlock()
+	return installed.h
+}
+
+// BeforeSplit calls the installed BeforeSplitFunc, if any.
+func BeforeSplit(ctx context.Context, region *metapb.Region, keys [][]byte) error {
+	if f := current().beforeSplit; f != nil {
+		return f(ctx, region, keys)
+	}
+	return nil
+}
+
+// BeforeIngest calls the installed BeforeIngestFunc, if any.
+func BeforeIngest(ctx context.Context, storeID uint64, sst *import_sstpb.SSTMeta) error {
+	if f := current().beforeIngest; f != nil {
+		return f(ctx, storeID, sst)
+	}
+	return nil
+}
+
+// OnStorageWrite calls the installed OnStorageWriteFunc, if any.
+func OnStorageWrite(ctx context.Context, name string, data []byte) error {
+	if f := current().onStorageWrite; f != nil {
+		return f(ctx, name, data)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package hook_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+
+	"github.com/pingcap/br/pkg/hook"
+)
+
+func TestT(t *testing.T) {
+	TestingT(t)
+}
+
+type testHookSuite struct{}
+
+var _ = Suite(&testHookSuite{})
+
+func (s *testHookSuite) TestInstall(c *C) {
+	ctx := context.Background()
+	c.Assert(hook.BeforeSplit(ctx, &metapb.Region{}, nil), IsNil)
+	c.Assert(hook.BeforeIngest(ctx, 1, &import_sstpb.SSTMeta{}), IsNil)
+	c.Assert(hook.OnStorageWrite(ctx, "backupmeta", nil), IsNil)
+
+	var ingested []uint64
+	uninstall := hook.Install(
+		hook.WithBeforeIngest(func(ctx context.Context, storeID uint64, sst *import_sstpb.SSTMeta) error {
+			ingested = append(ingested, storeID)
+			return nil
+		}),
+		hook.WithOnStorageWrite(func(ctx context.Context, name string, data []byte) error {
+			return errors.Errorf("injected failure writing %s", name)
+		}),
+	)
+	c.Assert(hook.BeforeSplit(ctx, &metapb.Region{}, nil), IsNil)
+	c.Assert(hook.BeforeIngest(ctx, 2, &import_sstpb.SSTMeta{}), IsNil)
+	c.Assert(ingested, DeepEquals, []uint64{2})
+	c.Assert(hook.OnStorageWrite(ctx, "backupmeta", nil), ErrorMatches, "injected failure writing backupmeta")
+
+	uninstall()
+	c.Assert(hook.OnStorageWrite(ctx, "backupmeta", nil), IsNil)
+}
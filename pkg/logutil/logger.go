@@ -0,0 +1,123 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package logutil
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+const (
+	// LogFormatText is the human readable log format of pingcap/log.
+	LogFormatText = "text"
+	// LogFormatJSON is the structured log format, one JSON object per line.
+	LogFormatJSON = "json"
+)
+
+// The stable field names of the structured logs, the log ingestion pipelines
+// may depend on them, so they shouldn't be changed.
+const (
+	FieldTaskID = "task-id"
+	FieldRegion = "region"
+)
+
+// jsonEncoderConfig is the encoder config of the JSON logs.
+var jsonEncoderConfig = zapcore.EncoderConfig{
+	TimeKey:        "time",
+	LevelKey:       "level",
+	NameKey:        "name",
+	CallerKey:      "caller",
+	MessageKey:     "message",
+	StacktraceKey:  "stack",
+	LineEnding:     zapcore.DefaultLineEnding,
+	EncodeLevel:    zapcore.CapitalLevelEncoder,
+	EncodeTime:     zapcore.ISO8601TimeEncoder,
+	EncodeDuration: zapcore.StringDurationEncoder,
+	EncodeCaller:   zapcore.ShortCallerEncoder,
+}
+
+// InitLogger initializes a logger like log.InitLogger, but supports the JSON
+// format besides the text format.
+func InitLogger(conf *log.Config) (*zap.Logger, *log.ZapProperties, error) {
+	switch conf.Format {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		return nil, nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"unsupported log format %q, should be %s or %s", conf.Format, LogFormatText, LogFormatJSON)
+	}
+	// pingcap/log only knows the text format, the JSON logger reuses its
+	// output and level, and replaces the encoder.
+	textConf := *conf
+	textConf.Format = LogFormatText
+	lg, p, err := log.InitLogger(&textConf)
+	if err != nil || conf.Format != LogFormatJSON {
+		return lg, p, errors.Trace(err)
+	}
+	p.Core = zapcore.NewCore(zapcore.NewJSONEncoder(jsonEncoderConfig), p.Syncer, p.Level)
+	opts := []zap.Option{zap.AddCaller(), zap.AddStacktrace(zapcore.FatalLevel)}
+	if conf.Development {
+		opts = append(opts, zap.Development())
+	}
+	return zap.New(p.Core, opts...), p, nil
+}
+
+// taskID is the ID of the current task of the process, so all the logs and
+// artifacts of the same task can be correlated. It's renewed by NewTaskID for
+// every task run by the same process, e.g. the cron backups.
+var taskID atomic.Value
+
+func init() { // nolint:gochecknoinits
+	taskID.Store(uuid.New().String())
+}
+
+// NewTaskID starts a new task by renewing the task ID, and returns it.
+func NewTaskID() string {
+	id := uuid.New().String()
+	taskID.Store(id)
+	return id
+}
+
+// TaskID returns the unique ID of the current task of the process.
+func TaskID() string {
+	return taskID.Load().(string)
+}
+
+// TaskIDField makes the zap field of the task ID.
+func TaskIDField() zap.Field {
+	return zap.String(FieldTaskID, TaskID())
+}
+
+type taskIDKey struct{}
+
+// ContextWithTaskID returns a context carrying the ID of the task. The tasks
+// of the same process may run concurrently, e.g. the BRIE statements of TiDB,
+// so the ID of a task is carried by its context.
+func ContextWithTaskID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, taskIDKey{}, id)
+}
+
+// EnsureTaskID returns the context carrying a task ID, a new ID is given if
+// the context doesn't carry one.
+func EnsureTaskID(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(taskIDKey{}).(string); ok {
+		return ctx
+	}
+	return ContextWithTaskID(ctx, uuid.New().String())
+}
+
+// TaskIDFromContext returns the ID of the task carried by the context, or the
+// ID of the current task of the process.
+func TaskIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(taskIDKey{}).(string); ok {
+		return id
+	}
+	return TaskID()
+}
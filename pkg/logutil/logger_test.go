@@ -0,0 +1,74 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package logutil_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+
+	"github.com/pingcap/br/pkg/logutil"
+)
+
+var _ = Suite(&testLoggerSuite{})
+
+type testLoggerSuite struct{}
+
+func (s *testLoggerSuite) TestJSONLogger(c *C) {
+	dir, err := ioutil.TempDir("", "br-logger")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "br.log")
+
+	conf := &log.Config{Level: "info", Format: logutil.LogFormatJSON}
+	conf.File.Filename = file
+	lg, _, err := logutil.InitLogger(conf)
+	c.Assert(err, IsNil)
+	lg.Info("restore table", logutil.TaskIDField(), logutil.Region(&metapb.Region{Id: 42}))
+	c.Assert(lg.Sync(), IsNil)
+
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+	entry := make(map[string]interface{})
+	c.Assert(json.Unmarshal(data, &entry), IsNil)
+	c.Assert(entry["message"], Equals, "restore table")
+	c.Assert(entry["level"], Equals, "INFO")
+	c.Assert(entry[logutil.FieldTaskID], Equals, logutil.TaskID())
+	region, ok := entry[logutil.FieldRegion].(map[string]interface{})
+	c.Assert(ok, IsTrue)
+	c.Assert(region["ID"], Equals, float64(42))
+}
+
+func (s *testLoggerSuite) TestInvalidLogFormat(c *C) {
+	_, _, err := logutil.InitLogger(&log.Config{Format: "xml"})
+	c.Assert(err, ErrorMatches, ".*unsupported log format.*")
+}
+
+func (s *testLoggerSuite) TestNewTaskID(c *C) {
+	first := logutil.TaskID()
+	second := logutil.NewTaskID()
+	c.Assert(second, Not(Equals), first)
+	c.Assert(logutil.TaskID(), Equals, second)
+	c.Assert(logutil.TaskIDField().String, Equals, second)
+}
+
+func (s *testLoggerSuite) TestContextTaskID(c *C) {
+	ctx := context.Background()
+	c.Assert(logutil.TaskIDFromContext(ctx), Equals, logutil.TaskID())
+
+	// The concurrent tasks have their own IDs.
+	task1 := logutil.EnsureTaskID(ctx)
+	task2 := logutil.EnsureTaskID(ctx)
+	c.Assert(logutil.TaskIDFromContext(task1), Not(Equals), logutil.TaskID())
+	c.Assert(logutil.TaskIDFromContext(task1), Not(Equals), logutil.TaskIDFromContext(task2))
+	c.Assert(logutil.TaskIDFromContext(logutil.EnsureTaskID(task1)), Equals, logutil.TaskIDFromContext(task1))
+
+	ctx = logutil.ContextWithTaskID(ctx, "task")
+	c.Assert(logutil.TaskIDFromContext(ctx), Equals, "task")
+}
@@ -124,7 +124,7 @@ func (region zapMarshalRegionMarshaler) MarshalLogObject(enc zapcore.ObjectEncod
 
 // Region make the zap fields for a region.
 func Region(region *metapb.Region) zap.Field {
-	return zap.Object("region", zapMarshalRegionMarshaler{region})
+	return zap.Object(FieldRegion, zapMarshalRegionMarshaler{region})
 }
 
 // Leader make the zap fields for a peer.
@@ -0,0 +1,185 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package pdutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.etcd.io/etcd/clientv3"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/logutil"
+)
+
+const (
+	// configSnapshotPrefix is the prefix of the snapshots of the original
+	// schedule config in the etcd of PD.
+	configSnapshotPrefix = "/tidb/br/pd-config-snapshot/"
+	// configOwnerPrefix is the prefix of the leased keys of the running tasks
+	// owning the snapshots.
+	configOwnerPrefix = "/tidb/br/pd-config-owner/"
+	// ConfigSnapshotTTL is the TTL (in seconds) of the owner lease. The
+	// snapshot becomes stale once its owner exits without refreshing it.
+	ConfigSnapshotTTL = 60
+)
+
+// ConfigSnapshot is the original schedule config and the schedulers, which
+// are persisted before BR changes them, so they can be recovered even if BR
+// is killed before resetting them.
+type ConfigSnapshot struct {
+	Owner   string        `json:"owner"`
+	TakenAt time.Time     `json:"taken-at"`
+	Config  ClusterConfig `json:"config"`
+}
+
+func snapshotOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}
+
+// ConfigSnapshotGuard keeps the snapshot owned by the running task.
+type ConfigSnapshotGuard struct {
+	cli     *clientv3.Client
+	id      string
+	leaseID clientv3.LeaseID
+	cancel  context.CancelFunc
+}
+
+// SaveConfigSnapshot persists the original config in the etcd of PD, and
+// keeps it owned until the guard is released.
+func SaveConfigSnapshot(ctx context.Context, cli *clientv3.Client, config ClusterConfig) (*ConfigSnapshotGuard, error) {
+	snapshot := ConfigSnapshot{Owner: snapshotOwner(), TakenAt: time.Now(), Config: config}
+	value, err := json.Marshal(&snapshot)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	lease, err := cli.Grant(ctx, ConfigSnapshotTTL)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	id := logutil.TaskIDFromContext(ctx)
+	_, err = cli.Txn(ctx).Then(
+		clientv3.OpPut(configSnapshotPrefix+id, string(value)),
+		clientv3.OpPut(configOwnerPrefix+id, snapshot.Owner, clientv3.WithLease(lease.ID)),
+	).Commit()
+	if err != nil {
+		_, _ = cli.Revoke(ctx, lease.ID)
+		return nil, errors.Trace(err)
+	}
+	keepCtx, cancel := context.WithCancel(context.Background())
+	ch, err := cli.KeepAlive(keepCtx, lease.ID)
+	if err != nil {
+		cancel()
+		_, _ = cli.Revoke(ctx, lease.ID)
+		return nil, errors.Trace(err)
+	}
+	go func() {
+		// Drain the responses until the guard is released.
+		for range ch {
+		}
+	}()
+	log.Info("PD config snapshot saved", zap.String("id", id), zap.Any("config", config))
+	return &ConfigSnapshotGuard{cli: cli, id: id, leaseID: lease.ID, cancel: cancel}, nil
+}
+
+// Release gives the snapshot up. The snapshot is removed if the config has
+// been restored, otherwise it's left stale to be recovered by the next task.
+func (g *ConfigSnapshotGuard) Release(ctx context.Context, restored bool) error {
+	g.cancel()
+	if restored {
+		if _, err := g.cli.Delete(ctx, configSnapshotPrefix+g.id); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if _, err := g.cli.Revoke(ctx, g.leaseID); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// RecoverStaleConfigSnapshots restores the config of the snapshots whose
+// owners have gone without restoring it by restoreConfig, e.g.
+// PdController.RestoreSchedulers, and removes them. It returns the count of
+// the snapshots recovered.
+//
+// The stale snapshots taken before a running task are left to be recovered
+// after it exits, since restoring them would undo the changes of the running
+// task, whose own snapshot holds the config it has changed.
+func RecoverStaleConfigSnapshots(
+	ctx context.Context, cli *clientv3.Client, restoreConfig func(context.Context, ClusterConfig) error,
+) (int, error) {
+	resp, err := cli.Get(ctx, configSnapshotPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	owners, err := cli.Get(ctx, configOwnerPrefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	alive := make(map[string]struct{}, len(owners.Kvs))
+	for _, kv := range owners.Kvs {
+		alive[strings.TrimPrefix(string(kv.Key), configOwnerPrefix)] = struct{}{}
+	}
+
+	type staleSnapshot struct {
+		id string
+		ConfigSnapshot
+	}
+	stale := make([]staleSnapshot, 0)
+	// runningSince is when the latest running task took its snapshot.
+	var runningSince time.Time
+	for _, kv := range resp.Kvs {
+		s := staleSnapshot{id: strings.TrimPrefix(string(kv.Key), configSnapshotPrefix)}
+		if err = json.Unmarshal(kv.Value, &s.ConfigSnapshot); err != nil {
+			return 0, errors.Annotatef(err, "invalid PD config snapshot %s", s.id)
+		}
+		if _, ok := alive[s.id]; ok {
+			if s.TakenAt.After(runningSince) {
+				runningSince = s.TakenAt
+			}
+			continue
+		}
+		stale = append(stale, s)
+	}
+	recoverable := stale[:0]
+	for _, s := range stale {
+		if s.TakenAt.Before(runningSince) {
+			log.Info("leave the PD config snapshot taken before a running task",
+				zap.String("id", s.id), zap.String("owner", s.Owner), zap.Time("taken-at", s.TakenAt))
+			continue
+		}
+		recoverable = append(recoverable, s)
+	}
+	stale = recoverable
+	// The earliest snapshot holds the config before any task changed it, so
+	// it's restored last.
+	sort.Slice(stale, func(i, j int) bool { return stale[i].TakenAt.After(stale[j].TakenAt) })
+	for _, s := range stale {
+		log.Warn("recover the PD config left by a task gone",
+			zap.String("id", s.id), zap.String("owner", s.Owner),
+			zap.Time("taken-at", s.TakenAt), zap.Any("config", s.Config))
+		if err = restoreConfig(ctx, s.Config); err != nil {
+			return 0, errors.Annotatef(err, "failed to recover PD config snapshot %s", s.id)
+		}
+		// The owner may come back after a network partition.
+		_, err = cli.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(configOwnerPrefix+s.id), "=", 0)).
+			Then(clientv3.OpDelete(configSnapshotPrefix + s.id)).
+			Commit()
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	return len(stale), nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package pdutil
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/tikv/pd/pkg/tempurl"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/embed"
+)
+
+type testConfigSnapshotSuite struct {
+	etcd *embed.Etcd
+	cli  *clientv3.Client
+}
+
+var _ = Suite(&testConfigSnapshotSuite{})
+
+func (s *testConfigSnapshotSuite) SetUpSuite(c *C) {
+	cfg := embed.NewConfig()
+	cfg.Dir = c.MkDir()
+	clientURL, err := url.Parse(tempurl.Alloc())
+	c.Assert(err, IsNil)
+	peerURL, err := url.Parse(tempurl.Alloc())
+	c.Assert(err, IsNil)
+	cfg.LCUrls = []url.URL{*clientURL}
+	cfg.ACUrls = []url.URL{*clientURL}
+	cfg.LPUrls = []url.URL{*peerURL}
+	cfg.APUrls = []url.URL{*peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	s.etcd, err = embed.StartEtcd(cfg)
+	c.Assert(err, IsNil)
+	select {
+	case <-s.etcd.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		c.Fatal("etcd isn't ready")
+	}
+	s.cli, err = clientv3.New(clientv3.Config{Endpoints: []string{clientURL.String()}})
+	c.Assert(err, IsNil)
+}
+
+func (s *testConfigSnapshotSuite) TearDownSuite(c *C) {
+	s.cli.Close()
+	s.etcd.Close()
+}
+
+func (s *testConfigSnapshotSuite) TestRecoverStaleConfigSnapshots(c *C) {
+	ctx := context.Background()
+	var restored []ClusterConfig
+	restoreConfig := func(_ context.Context, config ClusterConfig) error {
+		restored = append(restored, config)
+		return nil
+	}
+	config := ClusterConfig{
+		Schedulers:  []string{"balance-region-scheduler"},
+		ScheduleCfg: map[string]interface{}{"region-schedule-limit": float64(2048)},
+	}
+
+	guard, err := SaveConfigSnapshot(ctx, s.cli, config)
+	c.Assert(err, IsNil)
+	// The snapshot owned by a running task isn't recovered.
+	n, err := RecoverStaleConfigSnapshots(ctx, s.cli, restoreConfig)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+
+	// The task exits without restoring the config, e.g. it's killed.
+	c.Assert(guard.Release(ctx, false), IsNil)
+	n, err = RecoverStaleConfigSnapshots(ctx, s.cli, restoreConfig)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	c.Assert(restored, DeepEquals, []ClusterConfig{config})
+	n, err = RecoverStaleConfigSnapshots(ctx, s.cli, restoreConfig)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+
+	// The snapshot is removed once the config is restored.
+	guard, err = SaveConfigSnapshot(ctx, s.cli, config)
+	c.Assert(err, IsNil)
+	c.Assert(guard.Release(ctx, true), IsNil)
+	n, err = RecoverStaleConfigSnapshots(ctx, s.cli, restoreConfig)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+	c.Assert(restored, HasLen, 1)
+
+	// The stale snapshot taken before a running task is left until it exits,
+	// otherwise the changes of the running task are undone.
+	guard, err = SaveConfigSnapshot(ctx, s.cli, config)
+	c.Assert(err, IsNil)
+	c.Assert(guard.Release(ctx, false), IsNil)
+	time.Sleep(time.Millisecond)
+	running, err := SaveConfigSnapshot(ctx, s.cli, ClusterConfig{})
+	c.Assert(err, IsNil)
+	n, err = RecoverStaleConfigSnapshots(ctx, s.cli, restoreConfig)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+	c.Assert(running.Release(ctx, true), IsNil)
+	n, err = RecoverStaleConfigSnapshots(ctx, s.cli, restoreConfig)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	c.Assert(restored, DeepEquals, []ClusterConfig{config, config})
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package pdutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/pingcap/errors"
+)
+
+const (
+	evictLeaderScheduler    = "evict-leader-scheduler"
+	evictLeaderConfigPrefix = "pd/api/v1/scheduler-config/evict-leader-scheduler"
+)
+
+type evictLeaderBody struct {
+	Name    string `json:"name"`
+	StoreID uint64 `json:"store_id"`
+}
+
+// EvictLeaderStores returns the stores whose leaders are evicted by the
+// evict-leader scheduler of PD.
+func (p *PdController) EvictLeaderStores(ctx context.Context) ([]uint64, error) {
+	return p.evictLeaderStoresWith(ctx, pdRequest)
+}
+
+func (p *PdController) evictLeaderStoresWith(ctx context.Context, get pdHTTPRequest) ([]uint64, error) {
+	schedulers, err := p.listSchedulersWith(ctx, get)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	found := false
+	for _, scheduler := range schedulers {
+		if scheduler == evictLeaderScheduler {
+			found = true
+			break
+		}
+	}
+	// The config of the scheduler is missing until it's added.
+	if !found {
+		return nil, nil
+	}
+	v, err := p.http.requestWith(ctx, evictLeaderConfigPrefix+"/list", http.MethodGet, nil, get)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cfg := struct {
+		StoreIDRanges map[string]json.RawMessage `json:"store-id-ranges"`
+	}{}
+	if err = json.Unmarshal(v, &cfg); err != nil {
+		return nil, errors.Trace(err)
+	}
+	stores := make([]uint64, 0, len(cfg.StoreIDRanges))
+	for id := range cfg.StoreIDRanges {
+		storeID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		stores = append(stores, storeID)
+	}
+	sort.Slice(stores, func(i, j int) bool { return stores[i] < stores[j] })
+	return stores, nil
+}
+
+// EvictLeaders makes PD move the leaders away from the store.
+func (p *PdController) EvictLeaders(ctx context.Context, storeID uint64) error {
+	return p.evictLeadersWith(ctx, storeID, pdRequest)
+}
+
+func (p *PdController) evictLeadersWith(ctx context.Context, storeID uint64, post pdHTTPRequest) error {
+	body, err := json.Marshal(evictLeaderBody{Name: evictLeaderScheduler, StoreID: storeID})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = p.http.requestWith(ctx, schedulerPrefix, http.MethodPost, body, post)
+	return errors.Trace(err)
+}
+
+// CancelEvictLeaders stops moving the leaders away from the store.
+func (p *PdController) CancelEvictLeaders(ctx context.Context, storeID uint64) error {
+	return p.cancelEvictLeadersWith(ctx, storeID, pdRequest)
+}
+
+func (p *PdController) cancelEvictLeadersWith(ctx context.Context, storeID uint64, del pdHTTPRequest) error {
+	prefix := fmt.Sprintf("%s/delete/%d", evictLeaderConfigPrefix, storeID)
+	_, err := p.http.requestWith(ctx, prefix, http.MethodDelete, nil, del)
+	return errors.Trace(err)
+}
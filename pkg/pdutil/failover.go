@@ -0,0 +1,212 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package pdutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+const (
+	membersPrefix = "pd/api/v1/members"
+
+	defaultFailoverRetry   = 3
+	defaultFailoverBackoff = time.Second
+)
+
+type pdMember struct {
+	ClientURLs []string `json:"client_urls"`
+}
+
+type pdMembers struct {
+	Members []pdMember `json:"members"`
+	Leader  *pdMember  `json:"leader"`
+}
+
+// HTTPFailover sends HTTP requests to the members of PD, the leader first.
+// If all the members fail, e.g. the leader is changing, it re-discovers the
+// members and retries with backoff. Only the transport errors, the server
+// errors and the redirections are retried, the other responses, e.g. a bad
+// request, are returned at once.
+type HTTPFailover struct {
+	mu    sync.RWMutex
+	addrs []string
+
+	cli    *http.Client
+	scheme string
+	// leader returns the address of the current PD leader, it's optional.
+	leader func() string
+
+	retry   int
+	backoff time.Duration
+}
+
+// NewHTTPFailover creates a HTTPFailover with the known PD addresses. leader
+// returns the address of the current PD leader, it can be nil.
+func NewHTTPFailover(addrs []string, cli *http.Client, tlsEnabled bool, leader func() string) *HTTPFailover {
+	f := &HTTPFailover{
+		cli:     cli,
+		scheme:  "http://",
+		leader:  leader,
+		retry:   defaultFailoverRetry,
+		backoff: defaultFailoverBackoff,
+	}
+	if tlsEnabled {
+		f.scheme = "https://"
+	}
+	f.addrs = f.merge(addrs)
+	return f
+}
+
+func (f *HTTPFailover) normalize(addr string) string {
+	addr = strings.TrimRight(addr, "/")
+	if addr == "" || strings.HasPrefix(addr, "http") {
+		return addr
+	}
+	if f.scheme == "" {
+		return "http://" + addr
+	}
+	return f.scheme + addr
+}
+
+// merge merges the addresses in order, and removes the duplicated ones.
+func (f *HTTPFailover) merge(addrLists ...[]string) []string {
+	merged := make([]string, 0)
+	seen := make(map[string]struct{})
+	for _, addrs := range addrLists {
+		for _, addr := range addrs {
+			addr = f.normalize(addr)
+			if _, ok := seen[addr]; ok || addr == "" {
+				continue
+			}
+			seen[addr] = struct{}{}
+			merged = append(merged, addr)
+		}
+	}
+	return merged
+}
+
+// Addrs returns the addresses of the PD members, the leader first.
+func (f *HTTPFailover) Addrs() []string {
+	f.mu.RLock()
+	addrs := f.addrs
+	f.mu.RUnlock()
+	if f.leader == nil {
+		return addrs
+	}
+	return f.merge([]string{f.leader()}, addrs)
+}
+
+// Request sends the request to the PD members until one of them succeeds.
+func (f *HTTPFailover) Request(ctx context.Context, prefix, method string, body []byte) ([]byte, error) {
+	return f.requestWith(ctx, prefix, method, body, pdRequest)
+}
+
+func (f *HTTPFailover) requestWith(
+	ctx context.Context, prefix, method string, body []byte, req pdHTTPRequest,
+) ([]byte, error) {
+	backoff := f.backoff
+	for round := 0; ; round++ {
+		addrs := f.Addrs()
+		if len(addrs) == 0 {
+			return nil, errors.Annotate(berrors.ErrPDLeaderNotFound, "no available PD address")
+		}
+		var err error
+		for _, addr := range addrs {
+			// The body must be rebuilt for every attempt, since the reader
+			// is consumed by the former one.
+			var reader io.Reader
+			if body != nil {
+				reader = bytes.NewReader(body)
+			}
+			v, e := req(ctx, addr, prefix, f.cli, method, reader)
+			if e == nil {
+				return v, nil
+			}
+			if ctx.Err() != nil || !isFailoverError(e) {
+				return nil, errors.Trace(e)
+			}
+			log.Debug("PD request failed, try the next member",
+				zap.String("pd", addr), zap.String("prefix", prefix), zap.Error(e))
+			err = e
+		}
+		if round >= f.retry || ctx.Err() != nil {
+			return nil, errors.Trace(err)
+		}
+		log.Warn("all PD members failed, re-discover the members and retry",
+			zap.String("prefix", prefix), zap.Int("round", round), zap.Duration("backoff", backoff), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return nil, errors.Trace(ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		f.refreshWith(ctx, req)
+	}
+}
+
+// isFailoverError checks whether another PD member may succeed after the
+// error.
+func isFailoverError(err error) bool {
+	for err != nil {
+		if e, ok := err.(*pdStatusError); ok {
+			// The member may not be the leader, or is shutting down. A PD
+			// member only redirects to the leader, which fails if the leader
+			// is changing.
+			return e.statusCode >= http.StatusInternalServerError ||
+				(e.statusCode >= http.StatusMultipleChoices && e.statusCode < http.StatusBadRequest)
+		}
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = causer.Cause()
+	}
+	// The transport errors.
+	return true
+}
+
+// Refresh re-discovers the PD members.
+func (f *HTTPFailover) Refresh(ctx context.Context) {
+	f.refreshWith(ctx, pdRequest)
+}
+
+func (f *HTTPFailover) refreshWith(ctx context.Context, get pdHTTPRequest) {
+	for _, addr := range f.Addrs() {
+		v, err := get(ctx, addr, membersPrefix, f.cli, http.MethodGet, nil)
+		if err != nil {
+			continue
+		}
+		members := pdMembers{}
+		if err = json.Unmarshal(v, &members); err != nil {
+			log.Warn("failed to parse PD members", zap.String("pd", addr), zap.Error(err))
+			continue
+		}
+		discovered := make([]string, 0, len(members.Members)+1)
+		if members.Leader != nil {
+			discovered = append(discovered, members.Leader.ClientURLs...)
+		}
+		for _, m := range members.Members {
+			discovered = append(discovered, m.ClientURLs...)
+		}
+		f.mu.Lock()
+		// Keep the known addresses, the member may be back later.
+		f.addrs = f.merge(discovered, f.addrs)
+		f.mu.Unlock()
+		log.Info("PD members discovered", zap.Strings("addrs", f.Addrs()))
+		return
+	}
+	log.Warn("failed to discover PD members from all known addresses")
+}
@@ -3,7 +3,6 @@
 package pdutil
 
 import (
-	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
@@ -22,20 +21,21 @@ import (
 	"github.com/pingcap/log"
 	"github.com/pingcap/tidb/util/codec"
 	pd "github.com/tikv/pd/client"
+	"github.com/tikv/pd/pkg/typeutil"
 	"go.uber.org/zap"
-	"google.golang.org/grpc"
 
 	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/utils"
 )
 
 const (
-	clusterVersionPrefix = "pd/api/v1/config/cluster-version"
-	regionCountPrefix    = "pd/api/v1/stats/region"
-	schedulerPrefix      = "pd/api/v1/schedulers"
-	maxMsgSize           = int(128 * utils.MB) // pd.ScanRegion may return a large response
-	scheduleConfigPrefix = "pd/api/v1/config/schedule"
-	pauseTimeout         = 5 * time.Minute
+	clusterVersionPrefix  = "pd/api/v1/config/cluster-version"
+	regionCountPrefix     = "pd/api/v1/stats/region"
+	storesPrefix          = "pd/api/v1/stores"
+	schedulerPrefix       = "pd/api/v1/schedulers"
+	scheduleConfigPrefix  = "pd/api/v1/config/schedule"
+	replicateConfigPrefix = "pd/api/v1/config/replicate"
+	pauseTimeout          = 5 * time.Minute
 )
 
 type pauseConfigExpectation uint8
@@ -51,13 +51,13 @@ const (
 	pauseConfigSetFalse
 )
 
-// clusterConfig represents a set of scheduler whose config have been modified
+// ClusterConfig represents a set of scheduler whose config have been modified
 // along with their original config.
-type clusterConfig struct {
+type ClusterConfig struct {
 	// Enable PD schedulers before restore
-	scheduler []string
+	Schedulers []string `json:"schedulers"`
 	// Original scheudle configuration
-	scheduleCfg map[string]interface{}
+	ScheduleCfg map[string]interface{} `json:"schedule-cfg"`
 }
 
 type pauseSchedulerBody struct {
@@ -103,6 +103,21 @@ var (
 	}
 )
 
+// pdStatusError is the error of a PD response whose status isn't OK.
+type pdStatusError struct {
+	statusCode int
+	err        error
+}
+
+func (e *pdStatusError) Error() string {
+	return e.err.Error()
+}
+
+// Cause implements the causer of errors.Cause.
+func (e *pdStatusError) Cause() error {
+	return e.err
+}
+
 // pdHTTPRequest defines the interface to send a request to pd and return the result in bytes.
 type pdHTTPRequest func(context.Context, string, string, *http.Client, string, io.Reader) ([]byte, error)
 
@@ -127,7 +142,10 @@ func pdRequest(
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		res, _ := ioutil.ReadAll(resp.Body)
-		return nil, errors.Annotatef(berrors.ErrPDInvalidResponse, "[%d] %s %s", resp.StatusCode, res, reqURL)
+		return nil, &pdStatusError{
+			statusCode: resp.StatusCode,
+			err:        errors.Annotatef(berrors.ErrPDInvalidResponse, "[%d] %s %s", resp.StatusCode, res, reqURL),
+		}
 	}
 
 	r, err := ioutil.ReadAll(resp.Body)
@@ -139,8 +157,7 @@ func pdRequest(
 
 // PdController manage get/update config from pd.
 type PdController struct {
-	addrs    []string
-	cli      *http.Client
+	http     *HTTPFailover
 	pdClient pd.Client
 	version  *semver.Version
 
@@ -154,6 +171,7 @@ func NewPdController(
 	pdAddrs string,
 	tlsConf *tls.Config,
 	securityOption pd.SecurityOption,
+	maxMsgSize utils.GRPCMaxMsgSize,
 ) (*PdController, error) {
 	cli := &http.Client{Timeout: 30 * time.Second}
 	if tlsConf != nil {
@@ -185,13 +203,10 @@ func NewPdController(
 	}
 
 	version := parseVersion(versionBytes)
-	maxCallMsgSize := []grpc.DialOption{
-		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMsgSize)),
-		grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(maxMsgSize)),
-	}
+	// pd.ScanRegion may return a large response.
 	pdClient, err := pd.NewClientWithContext(
 		ctx, addrs, securityOption,
-		pd.WithGRPCDialOptions(maxCallMsgSize...),
+		pd.WithGRPCDialOptions(append(maxMsgSize.DialOptions(), utils.GRPCTaskDialOptions()...)...),
 		pd.WithCustomTimeoutOption(10*time.Second),
 	)
 	if err != nil {
@@ -199,9 +214,13 @@ func NewPdController(
 		return nil, errors.Trace(err)
 	}
 
+	failover := NewHTTPFailover(processedAddrs, cli, tlsConf != nil, pdClient.GetLeaderAddr)
+	// Discover all the members, so the requests can fail over to the members
+	// which aren't specified by the user.
+	failover.Refresh(ctx)
+
 	return &PdController{
-		addrs:    processedAddrs,
-		cli:      cli,
+		http:     failover,
 		pdClient: pdClient,
 		version:  version,
 		// We should make a buffered channel here otherwise when context canceled,
@@ -236,8 +255,7 @@ func (p *PdController) isPauseConfigEnabled() bool {
 
 // SetHTTP set pd addrs and cli for test.
 func (p *PdController) SetHTTP(addrs []string, cli *http.Client) {
-	p.addrs = addrs
-	p.cli = cli
+	p.http = &HTTPFailover{addrs: addrs, cli: cli}
 }
 
 // SetPDClient set pd addrs and cli for test.
@@ -256,17 +274,22 @@ func (p *PdController) GetClusterVersion(ctx context.Context) (string, error) {
 }
 
 func (p *PdController) getClusterVersionWith(ctx context.Context, get pdHTTPRequest) (string, error) {
-	var err error
-	for _, addr := range p.addrs {
-		v, e := get(ctx, addr, clusterVersionPrefix, p.cli, http.MethodGet, nil)
-		if e != nil {
-			err = e
-			continue
-		}
-		return string(v), nil
+	v, err := p.http.requestWith(ctx, clusterVersionPrefix, http.MethodGet, nil, get)
+	if err != nil {
+		return "", errors.Trace(err)
 	}
+	return string(v), nil
+}
 
-	return "", errors.Trace(err)
+// RegionStats is the statistics of the regions in a range reported by PD.
+type RegionStats struct {
+	Count      int `json:"count"`
+	EmptyCount int `json:"empty_count"`
+	// StorageSize is the approximate size in MiB, and StorageKeys is the
+	// approximate count of the keys. They're reported by TiKV periodically,
+	// so they may lag behind the writes.
+	StorageSize int64 `json:"storage_size"`
+	StorageKeys int64 `json:"storage_keys"`
 }
 
 // GetRegionCount returns the region count in the specified range.
@@ -274,33 +297,79 @@ func (p *PdController) GetRegionCount(ctx context.Context, startKey, endKey []by
 	return p.getRegionCountWith(ctx, pdRequest, startKey, endKey)
 }
 
+// GetRegionStats returns the statistics of the regions in the specified range.
+func (p *PdController) GetRegionStats(ctx context.Context, startKey, endKey []byte) (*RegionStats, error) {
+	return p.getRegionStatsWith(ctx, pdRequest, startKey, endKey)
+}
+
 func (p *PdController) getRegionCountWith(
 	ctx context.Context, get pdHTTPRequest, startKey, endKey []byte,
 ) (int, error) {
+	stats, err := p.getRegionStatsWith(ctx, get, startKey, endKey)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return stats.Count, nil
+}
+
+func (p *PdController) getRegionStatsWith(
+	ctx context.Context, get pdHTTPRequest, startKey, endKey []byte,
+) (*RegionStats, error) {
 	// TiKV reports region start/end keys to PD in memcomparable-format.
 	var start, end string
 	start = url.QueryEscape(string(codec.EncodeBytes(nil, startKey)))
 	if len(endKey) != 0 { // Empty end key means the max.
 		end = url.QueryEscape(string(codec.EncodeBytes(nil, endKey)))
 	}
-	var err error
-	for _, addr := range p.addrs {
-		query := fmt.Sprintf(
-			"%s?start_key=%s&end_key=%s",
-			regionCountPrefix, start, end)
-		v, e := get(ctx, addr, query, p.cli, http.MethodGet, nil)
-		if e != nil {
-			err = e
-			continue
-		}
-		regionsMap := make(map[string]interface{})
-		err = json.Unmarshal(v, &regionsMap)
-		if err != nil {
-			return 0, errors.Trace(err)
+	query := fmt.Sprintf(
+		"%s?start_key=%s&end_key=%s",
+		regionCountPrefix, start, end)
+	v, err := p.http.requestWith(ctx, query, http.MethodGet, nil, get)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	stats := new(RegionStats)
+	if err = json.Unmarshal(v, stats); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return stats, nil
+}
+
+// storesInfo is the response of the stores API of PD, only the used fields
+// are decoded.
+type storesInfo struct {
+	Stores []struct {
+		Store struct {
+			ID uint64 `json:"id"`
+		} `json:"store"`
+		Status struct {
+			Capacity typeutil.ByteSize `json:"capacity"`
+		} `json:"status"`
+	} `json:"stores"`
+}
+
+// GetStoreCapacities returns the storage capacities in bytes of the stores
+// reported by their heartbeats, the stores which haven't reported are absent.
+func (p *PdController) GetStoreCapacities(ctx context.Context) (map[uint64]uint64, error) {
+	return p.getStoreCapacitiesWith(ctx, pdRequest)
+}
+
+func (p *PdController) getStoreCapacitiesWith(ctx context.Context, get pdHTTPRequest) (map[uint64]uint64, error) {
+	v, err := p.http.requestWith(ctx, storesPrefix, http.MethodGet, nil, get)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	info := new(storesInfo)
+	if err = json.Unmarshal(v, info); err != nil {
+		return nil, errors.Trace(err)
+	}
+	capacities := make(map[uint64]uint64, len(info.Stores))
+	for _, store := range info.Stores {
+		if store.Status.Capacity > 0 {
+			capacities[store.Store.ID] = uint64(store.Status.Capacity)
 		}
-		return int(regionsMap["count"].(float64)), nil
 	}
-	return 0, errors.Trace(err)
+	return capacities, nil
 }
 
 func (p *PdController) doPauseSchedulers(ctx context.Context, schedulers []string, post pdHTTPRequest) ([]string, error) {
@@ -313,16 +382,11 @@ func (p *PdController) doPauseSchedulers(ctx context.Context, schedulers []strin
 	removedSchedulers := make([]string, 0, len(schedulers))
 	for _, scheduler := range schedulers {
 		prefix := fmt.Sprintf("%s/%s", schedulerPrefix, scheduler)
-		for _, addr := range p.addrs {
-			_, err = post(ctx, addr, prefix, p.cli, http.MethodPost, bytes.NewBuffer(body))
-			if err == nil {
-				removedSchedulers = append(removedSchedulers, scheduler)
-				break
-			}
-		}
+		_, err = p.http.requestWith(ctx, prefix, http.MethodPost, body, post)
 		if err != nil {
 			return removedSchedulers, errors.Trace(err)
 		}
+		removedSchedulers = append(removedSchedulers, scheduler)
 	}
 	return removedSchedulers, nil
 }
@@ -396,12 +460,7 @@ func (p *PdController) resumeSchedulerWith(ctx context.Context, schedulers []str
 	}
 	for _, scheduler := range schedulers {
 		prefix := fmt.Sprintf("%s/%s", schedulerPrefix, scheduler)
-		for _, addr := range p.addrs {
-			_, err = post(ctx, addr, prefix, p.cli, http.MethodPost, bytes.NewBuffer(body))
-			if err == nil {
-				break
-			}
-		}
+		_, err = p.http.requestWith(ctx, prefix, http.MethodPost, body, post)
 		if err != nil {
 			log.Error("failed to resume scheduler after retry, you may reset this scheduler manually"+
 				"or just wait this scheduler pause timeout", zap.String("scheduler", scheduler))
@@ -419,21 +478,16 @@ func (p *PdController) ListSchedulers(ctx context.Context) ([]string, error) {
 }
 
 func (p *PdController) listSchedulersWith(ctx context.Context, get pdHTTPRequest) ([]string, error) {
-	var err error
-	for _, addr := range p.addrs {
-		v, e := get(ctx, addr, schedulerPrefix, p.cli, http.MethodGet, nil)
-		if e != nil {
-			err = e
-			continue
-		}
-		d := make([]string, 0)
-		err = json.Unmarshal(v, &d)
-		if err != nil {
-			return nil, errors.Trace(err)
-		}
-		return d, nil
+	v, err := p.http.requestWith(ctx, schedulerPrefix, http.MethodGet, nil, get)
+	if err != nil {
+		return nil, errors.Trace(err)
 	}
-	return nil, errors.Trace(err)
+	d := make([]string, 0)
+	err = json.Unmarshal(v, &d)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return d, nil
 }
 
 // GetPDScheduleConfig returns PD schedule config value associated with the key.
@@ -441,22 +495,35 @@ func (p *PdController) listSchedulersWith(ctx context.Context, get pdHTTPRequest
 func (p *PdController) GetPDScheduleConfig(
 	ctx context.Context,
 ) (map[string]interface{}, error) {
-	var err error
-	for _, addr := range p.addrs {
-		v, e := pdRequest(
-			ctx, addr, scheduleConfigPrefix, p.cli, http.MethodGet, nil)
-		if e != nil {
-			err = e
-			continue
-		}
-		cfg := make(map[string]interface{})
-		err = json.Unmarshal(v, &cfg)
-		if err != nil {
-			return nil, errors.Trace(err)
-		}
-		return cfg, nil
+	v, err := p.http.Request(ctx, scheduleConfigPrefix, http.MethodGet, nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cfg := make(map[string]interface{})
+	err = json.Unmarshal(v, &cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cfg, nil
+}
+
+// GetMaxReplicas returns the max-replicas of the replication config of PD.
+func (p *PdController) GetMaxReplicas(ctx context.Context) (int, error) {
+	return p.getMaxReplicasWith(ctx, pdRequest)
+}
+
+func (p *PdController) getMaxReplicasWith(ctx context.Context, get pdHTTPRequest) (int, error) {
+	v, err := p.http.requestWith(ctx, replicateConfigPrefix, http.MethodGet, nil, get)
+	if err != nil {
+		return 0, errors.Trace(err)
 	}
-	return nil, errors.Trace(err)
+	cfg := struct {
+		MaxReplicas int `json:"max-replicas"`
+	}{}
+	if err = json.Unmarshal(v, &cfg); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return cfg.MaxReplicas, nil
 }
 
 // UpdatePDScheduleConfig updates PD schedule config value associated with the key.
@@ -465,6 +532,11 @@ func (p *PdController) UpdatePDScheduleConfig(ctx context.Context) error {
 	return p.doUpdatePDScheduleConfig(ctx, defaultPDCfg, pdRequest)
 }
 
+// SetPDScheduleConfig updates the PD schedule config items in the map.
+func (p *PdController) SetPDScheduleConfig(ctx context.Context, cfg map[string]interface{}) error {
+	return p.doUpdatePDScheduleConfig(ctx, cfg, pdRequest)
+}
+
 func (p *PdController) doUpdatePDScheduleConfig(
 	ctx context.Context, cfg map[string]interface{}, post pdHTTPRequest, prefixs ...string,
 ) error {
@@ -472,19 +544,16 @@ func (p *PdController) doUpdatePDScheduleConfig(
 	if len(prefixs) != 0 {
 		prefix = prefixs[0]
 	}
-	for _, addr := range p.addrs {
-		reqData, err := json.Marshal(cfg)
-		if err != nil {
-			return errors.Trace(err)
-		}
-		_, e := post(ctx, addr, prefix,
-			p.cli, http.MethodPost, bytes.NewBuffer(reqData))
-		if e == nil {
-			return nil
-		}
-		log.Warn("failed to update PD config, will try next", zap.Error(e), zap.String("pd", addr))
+	reqData, err := json.Marshal(cfg)
+	if err != nil {
+		return errors.Trace(err)
 	}
-	return errors.Annotate(berrors.ErrPDUpdateFailed, "failed to update PD schedule config")
+	_, err = p.http.requestWith(ctx, prefix, http.MethodPost, reqData, post)
+	if err != nil {
+		log.Warn("failed to update PD config", zap.Error(err))
+		return errors.Annotate(berrors.ErrPDUpdateFailed, "failed to update PD schedule config")
+	}
+	return nil
 }
 
 func (p *PdController) doPauseConfigs(ctx context.Context, cfg map[string]interface{}, post pdHTTPRequest) error {
@@ -493,14 +562,14 @@ func (p *PdController) doPauseConfigs(ctx context.Context, cfg map[string]interf
 	return p.doUpdatePDScheduleConfig(ctx, cfg, post, prefix)
 }
 
-func restoreSchedulers(ctx context.Context, pd *PdController, clusterCfg clusterConfig) error {
-	if err := pd.ResumeSchedulers(ctx, clusterCfg.scheduler); err != nil {
+func restoreSchedulers(ctx context.Context, pd *PdController, clusterCfg ClusterConfig) error {
+	if err := pd.ResumeSchedulers(ctx, clusterCfg.Schedulers); err != nil {
 		return errors.Annotate(err, "fail to add PD schedulers")
 	}
-	log.Info("restoring config", zap.Any("config", clusterCfg.scheduleCfg))
+	log.Info("restoring config", zap.Any("config", clusterCfg.ScheduleCfg))
 	mergeCfg := make(map[string]interface{})
 	for cfgKey := range expectPDCfg {
-		value := clusterCfg.scheduleCfg[cfgKey]
+		value := clusterCfg.ScheduleCfg[cfgKey]
 		if value == nil {
 			// Ignore non-exist config.
 			continue
@@ -520,16 +589,43 @@ func restoreSchedulers(ctx context.Context, pd *PdController, clusterCfg cluster
 	return nil
 }
 
-func (p *PdController) makeUndoFunctionByConfig(config clusterConfig) UndoFunc {
+func (p *PdController) makeUndoFunctionByConfig(config ClusterConfig) UndoFunc {
 	restore := func(ctx context.Context) error {
 		return restoreSchedulers(ctx, p, config)
 	}
 	return restore
 }
 
+// RestoreSchedulers resumes the schedulers and resets the schedule config
+// removed by RemoveSchedulersWithOrigin.
+func (p *PdController) RestoreSchedulers(ctx context.Context, config ClusterConfig) error {
+	return restoreSchedulers(ctx, p, config)
+}
+
 // RemoveSchedulers removes the schedulers that may slow down BR speed.
 func (p *PdController) RemoveSchedulers(ctx context.Context) (undo UndoFunc, err error) {
-	undo = Nop
+	origin, err := p.RemoveSchedulersWithOrigin(ctx)
+	if origin.ScheduleCfg == nil {
+		return Nop, errors.Trace(err)
+	}
+	return p.makeUndoFunctionByConfig(origin), errors.Trace(err)
+}
+
+// RemoveSchedulersWithOrigin removes the schedulers that may slow down BR
+// speed, and returns the removed schedulers along with the original schedule
+// config, which can be persisted and restored by RestoreSchedulers later.
+// The ScheduleCfg of the origin is nil if nothing has been changed.
+func (p *PdController) RemoveSchedulersWithOrigin(ctx context.Context) (origin ClusterConfig, err error) {
+	return p.RemoveSchedulersWithSnapshot(ctx, nil)
+}
+
+// RemoveSchedulersWithSnapshot is like RemoveSchedulersWithOrigin, but calls
+// save with the original config before changing anything on PD before 4.0.8,
+// so the config can be recovered even if BR is killed. The later versions
+// pause the schedulers and the config with TTL, which expire by themselves.
+func (p *PdController) RemoveSchedulersWithSnapshot(
+	ctx context.Context, save func(context.Context, ClusterConfig),
+) (origin ClusterConfig, err error) {
 	stores, err := p.pdClient.GetAllStores(ctx)
 	if err != nil {
 		return
@@ -557,7 +653,7 @@ func (p *PdController) RemoveSchedulers(ctx context.Context) (undo UndoFunc, err
 			disablePDCfg[cfgKey] = math.Min(40, float64(limit*len(stores)))
 		}
 	}
-	undo = p.makeUndoFunctionByConfig(clusterConfig{scheduleCfg: scheduleCfg})
+	origin = ClusterConfig{ScheduleCfg: scheduleCfg}
 	log.Debug("saved PD config", zap.Any("config", scheduleCfg))
 
 	// Remove default PD scheduler that may affect restore process.
@@ -571,6 +667,9 @@ func (p *PdController) RemoveSchedulers(ctx context.Context) (undo UndoFunc, err
 			needRemoveSchedulers = append(needRemoveSchedulers, s)
 		}
 	}
+	if save != nil && !p.isPauseConfigEnabled() {
+		save(ctx, ClusterConfig{Schedulers: needRemoveSchedulers, ScheduleCfg: scheduleCfg})
+	}
 
 	var removedSchedulers []string
 	if p.isPauseConfigEnabled() {
@@ -585,8 +684,8 @@ func (p *PdController) RemoveSchedulers(ctx context.Context) (undo UndoFunc, err
 		}
 		removedSchedulers, err = p.pauseSchedulersAndConfigWith(ctx, needRemoveSchedulers, nil, pdRequest)
 	}
-	undo = p.makeUndoFunctionByConfig(clusterConfig{scheduler: removedSchedulers, scheduleCfg: scheduleCfg})
-	return undo, errors.Trace(err)
+	origin = ClusterConfig{Schedulers: removedSchedulers, ScheduleCfg: scheduleCfg}
+	return origin, errors.Trace(err)
 }
 
 // Close close the connection to pd.
@@ -9,9 +9,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/coreos/go-semver/semver"
 	. "github.com/pingcap/check"
@@ -19,6 +21,8 @@ import (
 	"github.com/pingcap/tidb/util/codec"
 	"github.com/tikv/pd/server/core"
 	"github.com/tikv/pd/server/statistics"
+
+	berrors "github.com/pingcap/br/pkg/errors"
 )
 
 func TestT(t *testing.T) {
@@ -38,7 +42,7 @@ func (s *testPDControllerSuite) TestScheduler(c *C) {
 		return nil, errors.New("failed")
 	}
 	schedulerPauseCh := make(chan struct{})
-	pdController := &PdController{addrs: []string{"", ""}, schedulerPauseCh: schedulerPauseCh}
+	pdController := &PdController{http: &HTTPFailover{addrs: []string{"", ""}}, schedulerPauseCh: schedulerPauseCh}
 
 	_, err := pdController.pauseSchedulersAndConfigWith(ctx, []string{scheduler}, nil, mock)
 	c.Assert(err, ErrorMatches, "failed")
@@ -83,7 +87,7 @@ func (s *testPDControllerSuite) TestScheduler(c *C) {
 }
 
 func (s *testPDControllerSuite) TestGetClusterVersion(c *C) {
-	pdController := &PdController{addrs: []string{"", ""}} // two endpoints
+	pdController := &PdController{http: &HTTPFailover{addrs: []string{"", ""}}} // two endpoints
 	counter := 0
 	mock := func(context.Context, string, string, *http.Client, string, io.Reader) ([]byte, error) {
 		counter++
@@ -144,7 +148,7 @@ func (s *testPDControllerSuite) TestRegionCount(c *C) {
 		return ret, nil
 	}
 
-	pdController := &PdController{addrs: []string{"http://mock"}}
+	pdController := &PdController{http: &HTTPFailover{addrs: []string{"http://mock"}}}
 	ctx := context.Background()
 	resp, err := pdController.getRegionCountWith(ctx, mock, []byte{}, []byte{})
 	c.Assert(err, IsNil)
@@ -159,6 +163,32 @@ func (s *testPDControllerSuite) TestRegionCount(c *C) {
 	c.Assert(resp, Equals, 2)
 }
 
+func (s *testPDControllerSuite) TestStoreCapacities(c *C) {
+	mock := func(_ context.Context, _ string, prefix string, _ *http.Client, _ string, _ io.Reader) ([]byte, error) {
+		c.Assert(prefix, Equals, storesPrefix)
+		return []byte(`{"count": 3, "stores": [
+			{"store": {"id": 1}, "status": {"capacity": "1TiB", "available": "512GiB"}},
+			{"store": {"id": 2}, "status": {"capacity": "500GiB"}},
+			{"store": {"id": 3}, "status": {}}
+		]}`), nil
+	}
+	pdController := &PdController{http: &HTTPFailover{addrs: []string{"http://mock"}}}
+	capacities, err := pdController.getStoreCapacitiesWith(context.Background(), mock)
+	c.Assert(err, IsNil)
+	c.Assert(capacities, DeepEquals, map[uint64]uint64{1: 1 << 40, 2: 500 << 30})
+}
+
+func (s *testPDControllerSuite) TestMaxReplicas(c *C) {
+	mock := func(_ context.Context, _ string, prefix string, _ *http.Client, _ string, _ io.Reader) ([]byte, error) {
+		c.Assert(prefix, Equals, replicateConfigPrefix)
+		return []byte(`{"max-replicas": 5, "location-labels": "region,zone"}`), nil
+	}
+	pdController := &PdController{http: &HTTPFailover{addrs: []string{"http://mock"}}}
+	maxReplicas, err := pdController.getMaxReplicasWith(context.Background(), mock)
+	c.Assert(err, IsNil)
+	c.Assert(maxReplicas, Equals, 5)
+}
+
 func (s *testPDControllerSuite) TestPDVersion(c *C) {
 	v := []byte("\"v4.1.0-alpha1\"\n")
 	r := parseVersion(v)
@@ -167,3 +197,66 @@ func (s *testPDControllerSuite) TestPDVersion(c *C) {
 	c.Assert(r.Minor, Equals, expectV.Minor)
 	c.Assert(r.PreRelease, Equals, expectV.PreRelease)
 }
+
+func (s *testPDControllerSuite) TestLeaderFailover(c *C) {
+	leader := "pd3:2379"
+	failover := NewHTTPFailover([]string{"pd1:2379"}, nil, false, func() string { return leader })
+	failover.backoff = time.Millisecond
+	c.Assert(failover.Addrs(), DeepEquals, []string{"http://pd3:2379", "http://pd1:2379"})
+
+	// pd3 has gone, pd1 is alive but the API fails until the new leader pd2
+	// is discovered.
+	var requested []string
+	mock := func(_ context.Context, addr string, prefix string, _ *http.Client, _ string, body io.Reader) ([]byte, error) {
+		requested = append(requested, addr)
+		switch {
+		case addr == "http://pd1:2379" && prefix == membersPrefix:
+			return []byte(`{"members": [{"client_urls": ["http://pd1:2379"]}, {"client_urls": ["http://pd2:2379"]}],
+				"leader": {"client_urls": ["http://pd2:2379"]}}`), nil
+		case addr == "http://pd2:2379":
+			b, err := ioutil.ReadAll(body)
+			c.Assert(err, IsNil)
+			return b, nil
+		default:
+			return nil, errors.New("not leader")
+		}
+	}
+	leader = ""
+	resp, err := failover.requestWith(context.Background(), schedulerPrefix, http.MethodPost, []byte("body"), mock)
+	c.Assert(err, IsNil)
+	// The body is sent again after the former attempts.
+	c.Assert(string(resp), Equals, "body")
+	c.Assert(requested, DeepEquals, []string{"http://pd1:2379", "http://pd1:2379", "http://pd2:2379"})
+	c.Assert(failover.Addrs(), DeepEquals, []string{"http://pd2:2379", "http://pd1:2379"})
+
+	// Give up after the retries are exhausted.
+	requested = nil
+	mock = func(_ context.Context, addr string, _ string, _ *http.Client, _ string, _ io.Reader) ([]byte, error) {
+		requested = append(requested, addr)
+		return nil, errors.New("failed")
+	}
+	_, err = failover.requestWith(context.Background(), schedulerPrefix, http.MethodGet, nil, mock)
+	c.Assert(err, ErrorMatches, "failed")
+	// 4 rounds, each one tries 2 members, and the refreshes try 2 members.
+	c.Assert(requested, HasLen, (defaultFailoverRetry+1)*2+defaultFailoverRetry*2)
+
+	// The server errors fail over, but the other responses return at once.
+	for _, tc := range []struct {
+		statusCode int
+		requests   int
+	}{
+		{http.StatusServiceUnavailable, (defaultFailoverRetry+1)*2 + defaultFailoverRetry*2},
+		{http.StatusTemporaryRedirect, (defaultFailoverRetry+1)*2 + defaultFailoverRetry*2},
+		{http.StatusBadRequest, 1},
+		{http.StatusNotFound, 1},
+	} {
+		requested = nil
+		mock = func(_ context.Context, addr string, _ string, _ *http.Client, _ string, _ io.Reader) ([]byte, error) {
+			requested = append(requested, addr)
+			return nil, &pdStatusError{statusCode: tc.statusCode, err: berrors.ErrPDInvalidResponse}
+		}
+		_, err = failover.requestWith(context.Background(), schedulerPrefix, http.MethodGet, nil, mock)
+		c.Assert(err, ErrorMatches, ".*PD invalid response.*")
+		c.Assert(requested, HasLen, tc.requests, Commentf("status %d", tc.statusCode))
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -24,9 +25,16 @@ const (
 	downloadSSTWaitInterval    = 10 * time.Millisecond
 	downloadSSTMaxWaitInterval = 1 * time.Second
 
+	fileRetryWaitInterval    = 100 * time.Millisecond
+	fileRetryMaxWaitInterval = 3 * time.Second
+
 	resetTSRetryTime       = 16
 	resetTSWaitInterval    = 50 * time.Millisecond
 	resetTSMaxWaitInterval = 500 * time.Millisecond
+
+	probeStoreRetryTimes      = 5
+	probeStoreWaitInterval    = 500 * time.Millisecond
+	probeStoreMaxWaitInterval = 5 * time.Second
 )
 
 type importerBackoffer struct {
@@ -52,26 +60,50 @@ func newDownloadSSTBackoffer() utils.Backoffer {
 	return NewBackoffer(downloadSSTRetryTimes, downloadSSTWaitInterval, downloadSSTMaxWaitInterval)
 }
 
-func (bo *importerBackoffer) NextBackoff(err error) time.Duration {
+// newFileBackoffer creates the backoffer deciding whether a file failed after
+// all the retries of the importer is put back to the scheduler.
+func newFileBackoffer(attempt int) utils.Backoffer {
+	return NewBackoffer(attempt, fileRetryWaitInterval, fileRetryMaxWaitInterval)
+}
+
+// isRetryableImportError checks whether the error of importing a file may
+// disappear by retrying. If err is a multierr collected by utils.WithRetry,
+// the last error is checked.
+func isRetryableImportError(err error) bool {
+	if errs := multierr.Errors(err); len(errs) > 0 {
+		err = errs[len(errs)-1]
+	}
 	switch errors.Cause(err) { // nolint:errorlint
-	case berrors.ErrKVEpochNotMatch, berrors.ErrKVDownloadFailed, berrors.ErrKVIngestFailed:
+	case berrors.ErrKVEpochNotMatch, berrors.ErrKVKeyNotInRegion, berrors.ErrKVDownloadFailed, berrors.ErrKVIngestFailed:
+		// The stale regions are removed from the region cache, so the retry
+		// scans the latest regions.
+		return true
+	}
+	switch status.Code(errors.Cause(err)) {
+	case codes.Unavailable, codes.Aborted:
+		return true
+	case codes.DeadlineExceeded:
+		// The RPC of the file exceeds its ingest timeout, the store may be
+		// slow for a while.
+		return true
+	}
+	return false
+}
+
+func (bo *importerBackoffer) NextBackoff(err error) time.Duration {
+	switch cause := errors.Cause(err); {
+	case isRetryableImportError(err):
 		bo.delayTime = 2 * bo.delayTime
 		bo.attempt--
-	case berrors.ErrKVRangeIsEmpty, berrors.ErrKVRewriteRuleNotFound:
+	case cause == berrors.ErrKVRangeIsEmpty, cause == berrors.ErrKVRewriteRuleNotFound: // nolint:errorlint
 		// Excepted error, finish the operation
 		bo.delayTime = 0
 		bo.attempt = 0
 	default:
-		switch status.Code(err) {
-		case codes.Unavailable, codes.Aborted:
-			bo.delayTime = 2 * bo.delayTime
-			bo.attempt--
-		default:
-			// Unexcepted error
-			bo.delayTime = 0
-			bo.attempt = 0
-			log.Warn("unexcepted error, stop to retry", zap.Error(err))
-		}
+		// Unexcepted error
+		bo.delayTime = 0
+		bo.attempt = 0
+		log.Warn("unexcepted error, stop to retry", zap.Error(err))
 	}
 	if bo.delayTime > bo.maxDelayTime {
 		return bo.maxDelayTime
@@ -97,6 +129,16 @@ func newPDReqBackoffer() utils.Backoffer {
 	}
 }
 
+// newProbeStoreBackoffer creates the backoffer of probing a store, which
+// retries any error, e.g. the store is restarting.
+func newProbeStoreBackoffer() utils.Backoffer {
+	return &pdReqBackoffer{
+		attempt:      probeStoreRetryTimes,
+		delayTime:    probeStoreWaitInterval,
+		maxDelayTime: probeStoreMaxWaitInterval,
+	}
+}
+
 func (bo *pdReqBackoffer) NextBackoff(err error) time.Duration {
 	bo.delayTime = 2 * bo.delayTime
 	bo.attempt--
@@ -0,0 +1,197 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	probeStoreTimeout = 10 * time.Second
+
+	importSSTDownloadMethod    = "/import_sstpb.ImportSST/Download"
+	importSSTMultiIngestMethod = "/import_sstpb.ImportSST/MultiIngest"
+)
+
+// StoreCapability is what the ImportSST service of a store supports, it's
+// probed before the ingest phase.
+type StoreCapability struct {
+	StoreID uint64
+	Address string
+	// Reachable is whether the ImportSST service of the store is reachable,
+	// Err is the reason if it isn't.
+	Reachable bool
+	Err       error
+	// Download is whether the store downloads SST files with the keys
+	// rewritten, which all the restores depend on.
+	Download bool
+	// MultiIngest is whether the store ingests multiple SST files at once.
+	MultiIngest bool
+}
+
+// StoreCapabilities is the capability matrix of the stores, indexed by the
+// store ID.
+type StoreCapabilities map[uint64]*StoreCapability
+
+// CheckStore checks whether the store can restore files. The stores which
+// aren't probed, e.g. added after the probe, are assumed capable.
+func (caps StoreCapabilities) CheckStore(storeID uint64) error {
+	c, ok := caps[storeID]
+	if !ok {
+		return nil
+	}
+	if !c.Reachable {
+		return errors.Annotatef(berrors.ErrRestoreIncapableStore,
+			"the ImportSST service of store %d (%s) is unreachable: %v", c.StoreID, c.Address, c.Err)
+	}
+	if !c.Download {
+		return errors.Annotatef(berrors.ErrRestoreIncapableStore,
+			"store %d (%s) doesn't support downloading SST files", c.StoreID, c.Address)
+	}
+	return nil
+}
+
+// SupportMultiIngest returns whether all the probed stores support ingesting
+// multiple SST files at once.
+func (caps StoreCapabilities) SupportMultiIngest() bool {
+	for _, c := range caps {
+		if !c.MultiIngest {
+			return false
+		}
+	}
+	return len(caps) > 0
+}
+
+// storeProber is an ImporterClient which can probe the capability of stores.
+type storeProber interface {
+	ProbeStore(ctx context.Context, storeID uint64) *StoreCapability
+}
+
+// ProbeStore pre-dials the store, checks its health, and probes what its
+// ImportSST service supports, retrying with backoff if the store isn't
+// reachable yet. The probes send empty requests, which are rejected by the
+// implemented methods without any side effect, and gRPC rejects the methods
+// which aren't implemented with codes.Unimplemented.
+func (ic *importClient) ProbeStore(ctx context.Context, storeID uint64) *StoreCapability {
+	c := &StoreCapability{StoreID: storeID}
+	c.Err = utils.WithRetry(ctx, func() error {
+		return ic.probeStore(ctx, c)
+	}, newProbeStoreBackoffer())
+	c.Reachable = c.Err == nil
+	return c
+}
+
+func (ic *importClient) probeStore(ctx context.Context, c *StoreCapability) error {
+	pctx, cancel := context.WithTimeout(ctx, probeStoreTimeout)
+	defer cancel()
+	if _, err := ic.GetImportClient(pctx, c.StoreID); err != nil {
+		return errors.Trace(err)
+	}
+	ic.mu.Lock()
+	conn := ic.conns[c.StoreID]
+	ic.mu.Unlock()
+	c.Address = conn.Target()
+
+	if err := checkHealth(pctx, conn); err != nil {
+		return errors.Trace(err)
+	}
+	var err error
+	c.Download, err = probeMethod(pctx, conn, importSSTDownloadMethod,
+		&import_sstpb.DownloadRequest{}, &import_sstpb.DownloadResponse{})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	c.MultiIngest, err = probeMethod(pctx, conn, importSSTMultiIngestMethod,
+		&import_sstpb.IngestRequest{}, &import_sstpb.IngestResponse{})
+	if err != nil {
+		log.Warn("failed to probe multi-ingest", zap.Uint64("store", c.StoreID), zap.Error(err))
+	}
+	return nil
+}
+
+// checkHealth checks the store by the gRPC health checking protocol. The
+// stores not serving it, e.g. the older TiKV, are checked by the probes of
+// the methods only.
+func checkHealth(ctx context.Context, conn *grpc.ClientConn) error {
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	if status.Code(err) == codes.Unimplemented {
+		return nil
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return errors.Annotatef(berrors.ErrRestoreIncapableStore, "the store is %s", resp.GetStatus())
+	}
+	return nil
+}
+
+// probeMethod returns whether the method is implemented, or an error if the
+// service is unreachable.
+func probeMethod(ctx context.Context, conn *grpc.ClientConn, method string, req, resp interface{}) (bool, error) {
+	err := conn.Invoke(ctx, method, req, resp, grpc.WaitForReady(true))
+	switch status.Code(err) {
+	case codes.OK:
+		return true, nil
+	case codes.Unimplemented:
+		return false, nil
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled:
+		return false, errors.Trace(err)
+	default:
+		// The method is implemented but rejects the empty request.
+		return true, nil
+	}
+}
+
+// probeStores probes the capability of the stores concurrently.
+func (importer *FileImporter) probeStores(ctx context.Context, stores []*metapb.Store) StoreCapabilities {
+	caps := make(StoreCapabilities, len(stores))
+	prober, ok := importer.importClient.(storeProber)
+	if !ok {
+		return caps
+	}
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for _, store := range stores {
+		storeID := store.GetId()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := prober.ProbeStore(ctx, storeID)
+			mu.Lock()
+			caps[storeID] = c
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	importer.capabilities = caps
+	return caps
+}
+
+// checkRegionStores checks whether all the stores of the region can restore
+// files, so the restore fails fast instead of retrying the download.
+func (importer *FileImporter) checkRegionStores(region *metapb.Region) error {
+	for _, peer := range region.GetPeers() {
+		if err := importer.capabilities.CheckStore(peer.GetStoreId()); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
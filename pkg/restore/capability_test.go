@@ -0,0 +1,37 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"errors"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/restore"
+)
+
+type testCapabilitySuite struct{}
+
+var _ = Suite(&testCapabilitySuite{})
+
+func (s *testCapabilitySuite) TestStoreCapabilities(c *C) {
+	caps := restore.StoreCapabilities{
+		1: {StoreID: 1, Address: "tikv-1:20160", Reachable: true, Download: true, MultiIngest: true},
+		2: {StoreID: 2, Address: "tikv-2:20160", Reachable: true, Download: true},
+		3: {StoreID: 3, Address: "tikv-3:20160", Err: errors.New("connection refused")},
+	}
+	c.Assert(caps.CheckStore(1), IsNil)
+	c.Assert(caps.CheckStore(2), IsNil)
+	c.Assert(caps.CheckStore(3), ErrorMatches, ".*store 3 \\(tikv-3:20160\\) is unreachable: connection refused.*")
+	// The stores which aren't probed are assumed capable.
+	c.Assert(caps.CheckStore(4), IsNil)
+	c.Assert(caps.SupportMultiIngest(), IsFalse)
+
+	delete(caps, 3)
+	caps[2].MultiIngest = true
+	c.Assert(caps.SupportMultiIngest(), IsTrue)
+	c.Assert(restore.StoreCapabilities{}.SupportMultiIngest(), IsFalse)
+
+	caps[2].Download = false
+	c.Assert(caps.CheckStore(2), ErrorMatches, ".*store 2 \\(tikv-2:20160\\) doesn't support downloading.*")
+}
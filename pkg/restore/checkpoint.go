@@ -0,0 +1,217 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// CheckpointFile represents the file name of the restore checkpoint, which is
+// written to the backup storage when a restore with --resume starts.
+const CheckpointFile = "restore.checkpoint"
+
+// CheckpointState is the state of a restore recorded in the checkpoint.
+type CheckpointState string
+
+const (
+	// CheckpointRunning means the restore has changed the cluster and hasn't
+	// finished, it's still running or has been cancelled.
+	CheckpointRunning CheckpointState = "running"
+	// CheckpointFinished means the restore has finished.
+	CheckpointFinished CheckpointState = "finished"
+	// CheckpointAborted means the restore has been cleaned up by
+	// `br restore abort`.
+	CheckpointAborted CheckpointState = "aborted"
+)
+
+// Checkpoint records what a restore has changed in the cluster, so it can be
+// cleaned up after the restore is cancelled.
+type Checkpoint struct {
+	State  CheckpointState `json:"state"`
+	Online bool            `json:"online"`
+	// TaskID is the ID of the BR invocation which wrote the checkpoint.
+	TaskID string `json:"task-id,omitempty"`
+	// SafePointID is the ID of the service safe point kept by the restore.
+	SafePointID string `json:"safe-point-id,omitempty"`
+	// PDConfig is the removed schedulers and the original schedule config,
+	// it's nil in online restore.
+	PDConfig *pdutil.ClusterConfig `json:"pd-config,omitempty"`
+	// VerifiedTables is the tables whose checksums have been verified, indexed
+	// by the quoted `db`.`table` name.
+	VerifiedTables map[string]*VerifiedTable `json:"verified-tables,omitempty"`
+	// StoreLabels is the original labels of the stores labeled by restore.
+	StoreLabels []StoreLabel `json:"store-labels,omitempty"`
+	// ClusterID and RestoreID identify the restore the progress below belongs
+	// to, a resumed run keeps the restore ID and the progress only in the
+	// same cluster, before the restore finishes.
+	ClusterID uint64 `json:"cluster-id,omitempty"`
+	RestoreID string `json:"restore-id,omitempty"`
+	// ScatteredRegions is the regions scattered, indexed by the region IDs.
+	ScatteredRegions map[uint64]*ScatteredRegion `json:"scattered-regions,omitempty"`
+	// IngestedFiles is the files ingested, see ingestedFileKey.
+	IngestedFiles []string `json:"ingested-files,omitempty"`
+}
+
+// ResumeCheckpoint returns the checkpoint of the restore into the cluster,
+// which resumes the progress of the previous checkpoint if it's of the same
+// cluster and hasn't finished. Otherwise a new restore starts without the
+// progress of the previous one.
+func ResumeCheckpoint(previous *Checkpoint, clusterID uint64, online bool) *Checkpoint {
+	cp := &Checkpoint{
+		State:            CheckpointRunning,
+		Online:           online,
+		ClusterID:        clusterID,
+		VerifiedTables:   make(map[string]*VerifiedTable),
+		ScatteredRegions: make(map[uint64]*ScatteredRegion),
+	}
+	if previous == nil || previous.ClusterID != clusterID || previous.State == CheckpointFinished ||
+		previous.RestoreID == "" {
+		cp.RestoreID = uuid.New().String()
+		return cp
+	}
+	cp.RestoreID = previous.RestoreID
+	for name, t := range previous.VerifiedTables {
+		cp.VerifiedTables[name] = t
+	}
+	for id, r := range previous.ScatteredRegions {
+		cp.ScatteredRegions[id] = r
+	}
+	cp.IngestedFiles = append(cp.IngestedFiles, previous.IngestedFiles...)
+	return cp
+}
+
+// CheckpointRecorder saves the checkpoint of the restore, into which the
+// verified tables, the scattered regions and the ingested files are recorded
+// by their caches, so a resumed restore skips them.
+type CheckpointRecorder struct {
+	mu         sync.Mutex
+	storage    storage.ExternalStorage
+	checkpoint *Checkpoint
+	lastSave   time.Time
+}
+
+// NewCheckpointRecorder creates a recorder saving the checkpoint into the
+// storage.
+func NewCheckpointRecorder(s storage.ExternalStorage, checkpoint *Checkpoint) *CheckpointRecorder {
+	return &CheckpointRecorder{
+		storage:    s,
+		checkpoint: checkpoint,
+		lastSave:   time.Now(),
+	}
+}
+
+// Update changes the checkpoint and saves it.
+func (r *CheckpointRecorder) Update(ctx context.Context, change func(cp *Checkpoint)) {
+	r.update(ctx, 0, change)
+}
+
+// update changes the checkpoint, which is saved if it hasn't been saved for
+// the interval.
+func (r *CheckpointRecorder) update(ctx context.Context, interval time.Duration, change func(cp *Checkpoint)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	change(r.checkpoint)
+	if time.Since(r.lastSave) >= interval {
+		r.saveLocked(ctx)
+	}
+}
+
+// view reads the checkpoint.
+func (r *CheckpointRecorder) view(read func(cp *Checkpoint)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	read(r.checkpoint)
+}
+
+// Save saves the checkpoint with all the progress recorded.
+func (r *CheckpointRecorder) Save(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.saveLocked(ctx)
+}
+
+func (r *CheckpointRecorder) saveLocked(ctx context.Context) {
+	r.lastSave = time.Now()
+	if err := SaveCheckpoint(ctx, r.storage, r.checkpoint); err != nil {
+		log.Warn("failed to save restore checkpoint, `br restore abort` may not fully clean up",
+			zap.String("state", string(r.checkpoint.State)), zap.Error(err))
+	}
+}
+
+// isTaskRestoreRule checks whether the placement rule is set by the restore
+// task.
+func isTaskRestoreRule(groupID, ruleID, taskID string) bool {
+	return groupID == "pd" && strings.HasPrefix(ruleID, restoreRulePrefix) && strings.HasSuffix(ruleID, "-"+taskID)
+}
+
+// SaveCheckpoint writes the checkpoint to the storage, with the ID of the
+// current task.
+func SaveCheckpoint(ctx context.Context, s storage.ExternalStorage, cp *Checkpoint) error {
+	cp.TaskID = logutil.TaskIDFromContext(ctx)
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.Write(ctx, CheckpointFile, data))
+}
+
+// ReadCheckpoint reads the checkpoint from the storage. It returns nil if
+// there is no checkpoint.
+func ReadCheckpoint(ctx context.Context, s storage.ExternalStorage) (*Checkpoint, error) {
+	exists, err := s.FileExists(ctx, CheckpointFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.Read(ctx, CheckpointFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cp := &Checkpoint{}
+	if err = json.Unmarshal(data, cp); err != nil {
+		return nil, errors.Annotatef(err, "failed to parse %s", CheckpointFile)
+	}
+	return cp, nil
+}
+
+// ResetTaskPlacementRules removes the placement rules set by the restore
+// task, including those of the tables which aren't known by this client.
+func (rc *Client) ResetTaskPlacementRules(ctx context.Context, pdAddrs []string, taskID string) error {
+	rules, err := rc.GetPlacementRules(ctx, pdAddrs)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var failedRules []string
+	for _, rule := range rules {
+		if !isTaskRestoreRule(rule.GroupID, rule.ID, taskID) {
+			continue
+		}
+		if err := rc.toolClient.DeletePlacementRule(ctx, rule.GroupID, rule.ID); err != nil {
+			log.Warn("failed to delete placement rule", zap.String("rule-id", rule.ID), zap.Error(err))
+			failedRules = append(failedRules, rule.ID)
+		}
+	}
+	if len(failedRules) > 0 {
+		return errors.Annotatef(berrors.ErrPDInvalidResponse, "failed to delete placement rules %v", failedRules)
+	}
+	return nil
+}
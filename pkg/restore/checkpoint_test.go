@@ -0,0 +1,51 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	. "github.com/pingcap/check"
+)
+
+type testCheckpointSuite struct{}
+
+var _ = Suite(&testCheckpointSuite{})
+
+func (s *testCheckpointSuite) TestIsTaskRestoreRule(c *C) {
+	rc := &Client{taskID: "task-1"}
+	c.Assert(isTaskRestoreRule("pd", rc.getRuleID(42), "task-1"), IsTrue)
+	c.Assert(isTaskRestoreRule("pd", rc.getLeaderRuleID(42), "task-1"), IsTrue)
+	c.Assert(isTaskRestoreRule("pd", rc.getRuleID(42), "task-2"), IsFalse)
+	c.Assert(isTaskRestoreRule("tiflash", rc.getRuleID(42), "task-1"), IsFalse)
+	c.Assert(isTaskRestoreRule("pd", "default", "task-1"), IsFalse)
+}
+
+func (s *testCheckpointSuite) TestResumeCheckpoint(c *C) {
+	previous := &Checkpoint{
+		State:            CheckpointAborted,
+		ClusterID:        1,
+		RestoreID:        "restore-1",
+		VerifiedTables:   map[string]*VerifiedTable{"`db`.`t`": {TableID: 42}},
+		ScatteredRegions: map[uint64]*ScatteredRegion{2: {StartKey: []byte("a")}},
+		IngestedFiles:    []string{"1.sst@61"},
+	}
+	cp := ResumeCheckpoint(previous, 1, true)
+	c.Assert(cp.State, Equals, CheckpointRunning)
+	c.Assert(cp.Online, IsTrue)
+	c.Assert(cp.RestoreID, Equals, "restore-1")
+	c.Assert(cp.VerifiedTables, DeepEquals, previous.VerifiedTables)
+	c.Assert(cp.ScatteredRegions, DeepEquals, previous.ScatteredRegions)
+	c.Assert(cp.IngestedFiles, DeepEquals, previous.IngestedFiles)
+
+	// The progress in another cluster, or of a finished restore, is dropped.
+	for _, cp := range []*Checkpoint{
+		ResumeCheckpoint(previous, 2, false),
+		ResumeCheckpoint(&Checkpoint{State: CheckpointFinished, ClusterID: 1, RestoreID: "restore-1"}, 1, false),
+		ResumeCheckpoint(nil, 1, false),
+	} {
+		c.Assert(cp.RestoreID, Not(Equals), "")
+		c.Assert(cp.RestoreID, Not(Equals), "restore-1")
+		c.Assert(cp.VerifiedTables, HasLen, 0)
+		c.Assert(cp.ScatteredRegions, HasLen, 0)
+		c.Assert(cp.IngestedFiles, HasLen, 0)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// checksumCacheSaveInterval is the min interval of saving the checkpoint when
+// the tables are verified, so the tables are recorded without rewriting the
+// checkpoint for every table.
+const checksumCacheSaveInterval = 10 * time.Second
+
+// VerifiedTable is a restored table whose checksum has been verified.
+type VerifiedTable struct {
+	// TableID and UpdateTS identify the restored table and its schema
+	// version, the table is verified again once they change.
+	TableID    int64  `json:"table-id"`
+	UpdateTS   uint64 `json:"update-ts"`
+	Crc64Xor   uint64 `json:"crc64xor"`
+	TotalKvs   uint64 `json:"total-kvs"`
+	TotalBytes uint64 `json:"total-bytes"`
+}
+
+func newVerifiedTable(tbl CreatedTable) *VerifiedTable {
+	return &VerifiedTable{
+		TableID:    tbl.Table.ID,
+		UpdateTS:   tbl.Table.UpdateTS,
+		Crc64Xor:   tbl.OldTable.Crc64Xor,
+		TotalKvs:   tbl.OldTable.TotalKvs,
+		TotalBytes: tbl.OldTable.TotalBytes,
+	}
+}
+
+func verifiedTableKey(tbl CreatedTable) string {
+	return utils.EncloseName(tbl.OldTable.DB.Name.O) + "." + utils.EncloseName(tbl.OldTable.Info.Name.O)
+}
+
+// ChecksumCache records the verified tables in the checkpoint, so a resumed
+// restore skips the checksums of the tables verified by the previous runs.
+type ChecksumCache struct {
+	recorder *CheckpointRecorder
+}
+
+// NewChecksumCache creates a checksum cache recording into the checkpoint of
+// the recorder, nil is returned without the recorder.
+func NewChecksumCache(recorder *CheckpointRecorder) *ChecksumCache {
+	if recorder == nil {
+		return nil
+	}
+	return &ChecksumCache{recorder: recorder}
+}
+
+// verified returns whether the table has been verified, with the same table
+// ID, schema version and checksum.
+func (c *ChecksumCache) verified(tbl CreatedTable) bool {
+	if c == nil {
+		return false
+	}
+	var t *VerifiedTable
+	c.recorder.view(func(cp *Checkpoint) {
+		t = cp.VerifiedTables[verifiedTableKey(tbl)]
+	})
+	return t != nil && *t == *newVerifiedTable(tbl)
+}
+
+// record records the table verified, the checkpoint is saved at most once
+// per checksumCacheSaveInterval.
+func (c *ChecksumCache) record(ctx context.Context, tbl CreatedTable) {
+	if c == nil {
+		return
+	}
+	c.recorder.update(ctx, checksumCacheSaveInterval, func(cp *Checkpoint) {
+		if cp.VerifiedTables == nil {
+			cp.VerifiedTables = make(map[string]*VerifiedTable)
+		}
+		cp.VerifiedTables[verifiedTableKey(tbl)] = newVerifiedTable(tbl)
+	})
+}
@@ -0,0 +1,46 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+type testChecksumCacheSuite struct{}
+
+var _ = Suite(&testChecksumCacheSuite{})
+
+func (s *testChecksumCacheSuite) TestCarryOverVerifiedTables(c *C) {
+	ctx := context.Background()
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	verified := &restore.VerifiedTable{TableID: 42, UpdateTS: 7, Crc64Xor: 1, TotalKvs: 2, TotalBytes: 3}
+	previous := &restore.Checkpoint{
+		State:          restore.CheckpointAborted,
+		ClusterID:      1,
+		RestoreID:      "restore-1",
+		VerifiedTables: map[string]*restore.VerifiedTable{"`db`.`t`": verified},
+	}
+	recorder := restore.NewCheckpointRecorder(store, restore.ResumeCheckpoint(previous, 1, false))
+	c.Assert(restore.NewChecksumCache(recorder), NotNil)
+	recorder.Save(ctx)
+
+	saved, err := restore.ReadCheckpoint(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(saved.State, Equals, restore.CheckpointRunning)
+	c.Assert(saved.RestoreID, Equals, "restore-1")
+	c.Assert(saved.VerifiedTables, DeepEquals, previous.VerifiedTables)
+
+	// Nothing is carried over unless the restore is resumed.
+	restore.NewCheckpointRecorder(store, restore.ResumeCheckpoint(nil, 1, false)).Save(ctx)
+	saved, err = restore.ReadCheckpoint(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(saved.VerifiedTables, HasLen, 0)
+	c.Assert(restore.NewChecksumCache(nil), IsNil)
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// ChecksumDeviation is a restored table whose checksum deviates from the one
+// recorded in the backup.
+type ChecksumDeviation struct {
+	DB    string
+	Table string
+
+	ExpectedCrc64Xor   uint64
+	ExpectedTotalKvs   uint64
+	ExpectedTotalBytes uint64
+	ActualCrc64Xor     uint64
+	ActualTotalKvs     uint64
+	ActualTotalBytes   uint64
+}
+
+// ChecksumReport collects the checksum results of the restored tables, so the
+// deviations are reported together at the end of the restore, whatever the
+// checksum mode is.
+type ChecksumReport struct {
+	mu         sync.Mutex
+	verified   int
+	unverified int
+	deviations []ChecksumDeviation
+}
+
+func (r *ChecksumReport) addVerified() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.verified++
+}
+
+// AddUnverified counts the tables restored without verifying their checksums.
+func (r *ChecksumReport) AddUnverified(tables int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unverified += tables
+}
+
+// AddBackupFiles compares the checksums of the files of the tables against the
+// ones recorded in the backup, for the restores only checking the backup
+// files. It returns the count of the deviated tables.
+func (r *ChecksumReport) AddBackupFiles(tables []*utils.Table) int {
+	deviated := 0
+	for _, table := range tables {
+		if table.NoChecksum() {
+			r.AddUnverified(1)
+			continue
+		}
+		var crc64Xor, totalKvs, totalBytes uint64
+		for _, file := range table.Files {
+			crc64Xor ^= file.Crc64Xor
+			totalKvs += file.TotalKvs
+			totalBytes += file.TotalBytes
+		}
+		if crc64Xor == table.Crc64Xor && totalKvs == table.TotalKvs && totalBytes == table.TotalBytes {
+			r.addVerified()
+			continue
+		}
+		deviated++
+		r.addDeviation(ChecksumDeviation{
+			DB:                 table.DB.Name.O,
+			Table:              table.Info.Name.O,
+			ExpectedCrc64Xor:   table.Crc64Xor,
+			ExpectedTotalKvs:   table.TotalKvs,
+			ExpectedTotalBytes: table.TotalBytes,
+			ActualCrc64Xor:     crc64Xor,
+			ActualTotalKvs:     totalKvs,
+			ActualTotalBytes:   totalBytes,
+		})
+	}
+	return deviated
+}
+
+func (r *ChecksumReport) addDeviation(d ChecksumDeviation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deviations = append(r.deviations, d)
+}
+
+// Deviations returns the deviated tables ordered by the database and table
+// names.
+func (r *ChecksumReport) Deviations() []ChecksumDeviation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deviations := append([]ChecksumDeviation{}, r.deviations...)
+	sort.Slice(deviations, func(i, j int) bool {
+		if deviations[i].DB != deviations[j].DB {
+			return deviations[i].DB < deviations[j].DB
+		}
+		return deviations[i].Table < deviations[j].Table
+	})
+	return deviations
+}
+
+// Counts returns the counts of the verified, deviated and unverified tables.
+func (r *ChecksumReport) Counts() (verified, deviated, unverified int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.verified, len(r.deviations), r.unverified
+}
+
+// Log logs the deviation report, and collects the counts of the verified,
+// deviated and unverified tables into the summary.
+func (r *ChecksumReport) Log() {
+	deviations := r.Deviations()
+	verified, _, unverified := r.Counts()
+	summary.CollectInt("checksum verified tables", verified)
+	summary.CollectInt("checksum deviated tables", len(deviations))
+	summary.CollectInt("checksum unverified tables", unverified)
+	if len(deviations) == 0 {
+		log.Info("restored tables match the backup",
+			zap.Int("verified-tables", verified),
+			zap.Int("unverified-tables", unverified))
+		return
+	}
+	for _, d := range deviations {
+		log.Warn("restored table deviates from the backup",
+			zap.String("db", d.DB),
+			zap.String("table", d.Table),
+			zap.Uint64("expected-crc64xor", d.ExpectedCrc64Xor),
+			zap.Uint64("actual-crc64xor", d.ActualCrc64Xor),
+			zap.Uint64("expected-total-kvs", d.ExpectedTotalKvs),
+			zap.Uint64("actual-total-kvs", d.ActualTotalKvs),
+			zap.Int64("kvs-deviation", int64(d.ActualTotalKvs-d.ExpectedTotalKvs)),
+			zap.Uint64("expected-total-bytes", d.ExpectedTotalBytes),
+			zap.Uint64("actual-total-bytes", d.ActualTotalBytes),
+			zap.Int64("bytes-deviation", int64(d.ActualTotalBytes-d.ExpectedTotalBytes)))
+	}
+	log.Warn("restored tables deviate from the backup",
+		zap.Int("deviated-tables", len(deviations)),
+		zap.Int("verified-tables", verified),
+		zap.Int("unverified-tables", unverified))
+	summary.CollectWarning("tables deviated from the checksums of the backup", len(deviations))
+}
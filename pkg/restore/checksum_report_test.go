@@ -0,0 +1,71 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+type testChecksumReportSuite struct{}
+
+var _ = Suite(&testChecksumReportSuite{})
+
+func reportTable(name string, crc64Xor, totalKvs, totalBytes uint64, files ...*backup.File) *utils.Table {
+	return &utils.Table{
+		DB:         &model.DBInfo{Name: model.NewCIStr("test")},
+		Info:       &model.TableInfo{Name: model.NewCIStr(name)},
+		Crc64Xor:   crc64Xor,
+		TotalKvs:   totalKvs,
+		TotalBytes: totalBytes,
+		Files:      files,
+	}
+}
+
+func (s *testChecksumReportSuite) TestBackupFiles(c *C) {
+	report := &ChecksumReport{}
+	deviated := report.AddBackupFiles([]*utils.Table{
+		reportTable("matched", 0x3, 3, 30,
+			&backup.File{Crc64Xor: 0x1, TotalKvs: 1, TotalBytes: 10},
+			&backup.File{Crc64Xor: 0x2, TotalKvs: 2, TotalBytes: 20}),
+		reportTable("missing", 0x3, 3, 30,
+			&backup.File{Crc64Xor: 0x1, TotalKvs: 1, TotalBytes: 10}),
+		reportTable("empty", 0, 0, 0),
+	})
+	c.Assert(deviated, Equals, 1)
+	verified, deviatedTables, unverified := report.Counts()
+	c.Assert(verified, Equals, 1)
+	c.Assert(deviatedTables, Equals, 1)
+	c.Assert(unverified, Equals, 1)
+	c.Assert(report.Deviations(), DeepEquals, []ChecksumDeviation{{
+		DB:                 "test",
+		Table:              "missing",
+		ExpectedCrc64Xor:   0x3,
+		ExpectedTotalKvs:   3,
+		ExpectedTotalBytes: 30,
+		ActualCrc64Xor:     0x1,
+		ActualTotalKvs:     1,
+		ActualTotalBytes:   10,
+	}})
+}
+
+func (s *testChecksumReportSuite) TestDeviationsOrder(c *C) {
+	report := &ChecksumReport{}
+	report.addDeviation(ChecksumDeviation{DB: "b", Table: "a"})
+	report.addDeviation(ChecksumDeviation{DB: "a", Table: "b"})
+	report.addDeviation(ChecksumDeviation{DB: "a", Table: "a"})
+	report.addVerified()
+	report.AddUnverified(2)
+	deviations := report.Deviations()
+	c.Assert(deviations, HasLen, 3)
+	c.Assert(deviations[0], Equals, ChecksumDeviation{DB: "a", Table: "a"})
+	c.Assert(deviations[1], Equals, ChecksumDeviation{DB: "a", Table: "b"})
+	c.Assert(deviations[2], Equals, ChecksumDeviation{DB: "b", Table: "a"})
+	verified, deviated, unverified := report.Counts()
+	c.Assert(verified, Equals, 1)
+	c.Assert(deviated, Equals, 3)
+	c.Assert(unverified, Equals, 2)
+}
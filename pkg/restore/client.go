@@ -6,11 +6,11 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"sort"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/pingcap/errors"
@@ -20,6 +20,7 @@ import (
 	"github.com/pingcap/log"
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/tidb/domain"
+	"github.com/pingcap/tidb/infoschema"
 	"github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/statistics/handle"
 	"github.com/pingcap/tidb/store/tikv/oracle"
@@ -49,6 +50,10 @@ import (
 // checksum tasks.
 const defaultChecksumConcurrency = 64
 
+// checksumProgressInterval is the interval of logging the progress of the
+// checksum of a table.
+const checksumProgressInterval = time.Minute
+
 // Client sends requests to restore files.
 type Client struct {
 	pdClient      pd.Client
@@ -56,7 +61,11 @@ type Client struct {
 	fileImporter  FileImporter
 	workerPool    *utils.WorkerPool
 	tlsConf       *tls.Config
+	pdTLSConf     *tls.Config
 	keepaliveConf keepalive.ClientParameters
+	grpcDialOpts  []grpc.DialOption
+	// maxMsgSize is the max size of the gRPC messages to TiKV.
+	maxMsgSize utils.GRPCMaxMsgSize
 
 	databases  map[string]*utils.Database
 	ddlJobs    []*model.Job
@@ -72,11 +81,66 @@ type Client struct {
 	// this probably isn't as easy as it seems like (however, not hard, too :D)
 	db              *DB
 	rateLimit       uint64
+	concurrency     uint
+	fileRetryBudget int
+	indexMode       IndexRestoreMode
 	isOnline        bool
+	learnerIngest   bool
 	noSchema        bool
 	hasSpeedLimited bool
+	// learnerCount is the learners of each region expected on the restore
+	// stores by the learner-first ingest.
+	learnerCount int
+	// taskID is the ID of the task, which the IDs of the placement rules end
+	// with.
+	taskID string
+	// speedLimit is the download speed limit set to the stores.
+	speedLimit uint64
+	// bandwidthBudget caps the rate limit by the share of the budget.
+	bandwidthBudget *utils.BandwidthBudget
+	// liveTuning overrides the rate limit and the concurrency.
+	liveTuning *utils.LiveTuning
+	// regionCacheCapacity is the max count of the regions cached for import.
+	regionCacheCapacity int
+	// ingestTimeout is the timeout of the download and ingest RPCs.
+	ingestTimeout IngestTimeout
+	// perStoreInflight is the max count of the download and ingest requests
+	// in flight to a store, 0 means no limit.
+	perStoreInflight uint
+	// ingestLoad tracks the bytes downloaded into the stores.
+	ingestLoad *StoreIngestLoad
+	// checksumCache records the verified tables, nil means no cache.
+	checksumCache *ChecksumCache
+	// keyTransforms are applied on the rewrite rules of the tables.
+	keyTransforms []KeyTransform
+	// checksumReport collects the checksum results, the checksum mismatches
+	// fail the restore unless nonStrictChecksum is set.
+	checksumReport    ChecksumReport
+	nonStrictChecksum bool
+	// checksumSampler picks the tables to verify, nil means all tables.
+	checksumSampler *ChecksumSampler
+	// regionDumper records the region layout of the split ranges.
+	regionDumper *RegionDumper
+	// scatterCache records the scattered regions, nil means no cache.
+	scatterCache *ScatterCache
+	// ingestCache records the ingested files, nil means no cache.
+	ingestCache *IngestCache
+	// ddlAudit records the statements executed by the glue.
+	ddlAudit *DDLAuditLog
+	// tableRetry is the count of the table retries of a table whose files
+	// exhausted their retry budget, the failed files are collected into
+	// failedTables.
+	tableRetry   int
+	failedTables failedTables
 
 	restoreStores []uint64
+	// placementMapping is applied on the tables as they are created.
+	placementMapping *PlacementMapping
+	scatterPriority  ScatterPriority
+	// regionTopology is applied on the tables as they are created, and the
+	// tables placed are collected into placedTables for the verification.
+	regionTopology *RegionTopology
+	placedTables   placedTables
 
 	storage            storage.ExternalStorage
 	backend            *backup.StorageBackend
@@ -95,8 +159,10 @@ func NewRestoreClient(
 	g glue.Glue,
 	pdClient pd.Client,
 	store kv.Storage,
-	tlsConf *tls.Config,
+	pdTLSConf *tls.Config,
+	tikvTLSConf *tls.Config,
 	keepaliveConf keepalive.ClientParameters,
+	maxMsgSize utils.GRPCMaxMsgSize,
 ) (*Client, error) {
 	db, err := NewDB(g, store)
 	if err != nil {
@@ -114,26 +180,52 @@ func NewRestoreClient(
 	}
 
 	return &Client{
-		pdClient:      pdClient,
-		toolClient:    NewSplitClient(pdClient, tlsConf),
-		db:            db,
-		tlsConf:       tlsConf,
-		keepaliveConf: keepaliveConf,
-		switchCh:      make(chan struct{}),
-		dom:           dom,
-		statsHandler:  statsHandle,
+		pdClient:        pdClient,
+		toolClient:      NewSplitClient(pdClient, pdTLSConf, tikvTLSConf, maxMsgSize),
+		db:              db,
+		tlsConf:         tikvTLSConf,
+		pdTLSConf:       pdTLSConf,
+		keepaliveConf:   keepaliveConf,
+		maxMsgSize:      maxMsgSize,
+		switchCh:        make(chan struct{}),
+		dom:             dom,
+		statsHandler:    statsHandle,
+		scatterPriority: ScatterPriorityNormal,
+		fileRetryBudget: defaultFileRetryBudget,
+		taskID:          logutil.TaskID(),
+
+		regionCacheCapacity: DefaultRegionCacheCapacity,
 	}, nil
 }
 
+// SetTaskID sets the ID of the task, which the IDs of the placement rules end
+// with.
+func (rc *Client) SetTaskID(id string) {
+	rc.taskID = id
+}
+
 // SetRateLimit to set rateLimit.
 func (rc *Client) SetRateLimit(rateLimit uint64) {
 	rc.rateLimit = rateLimit
 }
 
+// SetBandwidthBudget sets the bandwidth budget shared with the other tasks,
+// the download speed limit of the stores follows the share between batches.
+func (rc *Client) SetBandwidthBudget(budget *utils.BandwidthBudget) {
+	rc.bandwidthBudget = budget
+}
+
+// SetLiveTuning sets the live tuning of the task, the changes are applied on
+// the next batch of files. The concurrency may be raised above the one set
+// by SetConcurrency.
+func (rc *Client) SetLiveTuning(tuning *utils.LiveTuning) {
+	rc.liveTuning = tuning
+}
+
 // SetStorage set ExternalStorage for client.
-func (rc *Client) SetStorage(ctx context.Context, backend *backup.StorageBackend, sendCreds bool) error {
+func (rc *Client) SetStorage(ctx context.Context, backend *backup.StorageBackend, opts *storage.ExternalStorageOptions) error {
 	var err error
-	rc.storage, err = storage.Create(ctx, backend, sendCreds)
+	rc.storage, err = storage.New(ctx, backend, opts)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -156,12 +248,48 @@ func (rc *Client) SetSwitchModeInterval(interval time.Duration) {
 	rc.switchModeInterval = interval
 }
 
+// SetRegionCacheCapacity sets the max count of the regions cached for import,
+// 0 disables the region cache.
+func (rc *Client) SetRegionCacheCapacity(capacity int) {
+	rc.regionCacheCapacity = capacity
+}
+
+// SetIngestTimeout sets the timeout of the download and ingest RPCs.
+func (rc *Client) SetIngestTimeout(timeout IngestTimeout) {
+	rc.ingestTimeout = timeout
+}
+
+// SetPerStoreInflight sets the max count of the download and ingest requests
+// in flight to a store, 0 means no limit. It must be called before
+// InitBackupMeta.
+func (rc *Client) SetPerStoreInflight(limit uint) {
+	rc.perStoreInflight = limit
+}
+
+// SetStoreIngestLoad makes the bytes downloaded into the stores tracked by
+// the load, it must be called before InitBackupMeta.
+func (rc *Client) SetStoreIngestLoad(load *StoreIngestLoad) {
+	rc.ingestLoad = load
+}
+
+// SetChecksumCache sets the cache of the verified tables, the tables verified
+// by the previous runs are skipped.
+func (rc *Client) SetChecksumCache(cache *ChecksumCache) {
+	rc.checksumCache = cache
+}
+
 // Close a client.
 func (rc *Client) Close() {
 	// rc.db can be nil in raw kv mode.
 	if rc.db != nil {
 		rc.db.Close()
 	}
+	if hits, misses, size := rc.fileImporter.regionCache.stats(); hits+misses > 0 {
+		log.Info("region cache usage",
+			zap.Int("hits", hits), zap.Int("misses", misses), zap.Int("bytes", size))
+		summary.CollectInt("region cache hits", hits)
+		summary.CollectInt("region cache misses", misses)
+	}
 	log.Info("Restore client closed")
 }
 
@@ -184,9 +312,14 @@ func (rc *Client) InitBackupMeta(backupMeta *backup.BackupMeta, backend *backup.
 	rc.backupMeta = backupMeta
 	log.Info("load backupmeta", zap.Int("databases", len(rc.databases)), zap.Int("jobs", len(rc.ddlJobs)))
 
-	metaClient := NewSplitClient(rc.pdClient, rc.tlsConf)
-	importCli := NewImportClient(metaClient, rc.tlsConf, rc.keepaliveConf)
-	rc.fileImporter = NewFileImporter(metaClient, importCli, backend, rc.backupMeta.IsRawKv, rc.rateLimit)
+	metaClient := NewSplitClient(rc.pdClient, rc.pdTLSConf, rc.tlsConf, rc.maxMsgSize)
+	importCli := NewImportClient(metaClient, rc.tlsConf, rc.keepaliveConf,
+		append(rc.maxMsgSize.DialOptions(), rc.grpcDialOpts...)...)
+	rc.fileImporter = NewFileImporter(metaClient, importCli, backend, rc.backupMeta.IsRawKv)
+	rc.fileImporter.SetPerStoreInflight(rc.perStoreInflight)
+	rc.fileImporter.SetRegionCacheCapacity(rc.regionCacheCapacity)
+	rc.fileImporter.SetIngestTimeout(rc.ingestTimeout)
+	rc.fileImporter.ingestLoad = rc.ingestLoad
 
 	return nil
 }
@@ -196,6 +329,24 @@ func (rc *Client) IsRawKvMode() bool {
 	return rc.backupMeta.IsRawKv
 }
 
+// SetScatterPriority sets the priority of the scatter operators created by restore.
+func (rc *Client) SetScatterPriority(priority ScatterPriority) {
+	rc.scatterPriority = priority
+}
+
+// SetGRPCCompression sets the compression of the gRPC connections to TiKV, the
+// messages are counted by the wire stats. It must be called before
+// InitBackupMeta.
+func (rc *Client) SetGRPCCompression(name string, wireStats *utils.GRPCWireStats) {
+	rc.grpcDialOpts = utils.GRPCCompressionDialOptions(name, wireStats)
+}
+
+// SetRawTargetCF sets the column family on the target cluster that the raw kv
+// files are restored into. It must be called after InitBackupMeta.
+func (rc *Client) SetRawTargetCF(cf string) error {
+	return errors.Trace(rc.fileImporter.SetRawTargetCF(cf))
+}
+
 // GetFilesInRawRange gets all files that are in the given range or intersects with the given range.
 func (rc *Client) GetFilesInRawRange(startKey []byte, endKey []byte, cf string) ([]*backup.File, error) {
 	if !rc.IsRawKvMode() {
@@ -265,6 +416,17 @@ func (rc *Client) GetFilesInTxnRange() ([]*backup.File, error) {
 // SetConcurrency sets the concurrency of dbs tables files.
 func (rc *Client) SetConcurrency(c uint) {
 	rc.workerPool = utils.NewWorkerPool(c, "file")
+	rc.concurrency = c
+}
+
+// SetFileRetryBudget sets the times a file can be retried before giving up.
+func (rc *Client) SetFileRetryBudget(budget int) {
+	rc.fileRetryBudget = budget
+}
+
+// SetIndexRestoreMode sets whether the rows and the indexes are restored.
+func (rc *Client) SetIndexRestoreMode(mode IndexRestoreMode) {
+	rc.indexMode = mode
 }
 
 // EnableOnline sets the mode of restore to online.
@@ -272,11 +434,16 @@ func (rc *Client) EnableOnline() {
 	rc.isOnline = true
 }
 
-// GetTLSConfig returns the tls config.
+// GetTLSConfig returns the tls config of the connections to TiKV.
 func (rc *Client) GetTLSConfig() *tls.Config {
 	return rc.tlsConf
 }
 
+// GetPDTLSConfig returns the tls config of the HTTP requests to PD.
+func (rc *Client) GetPDTLSConfig() *tls.Config {
+	return rc.pdTLSConf
+}
+
 // GetTS gets a new timestamp from PD.
 func (rc *Client) GetTS(ctx context.Context) (uint64, error) {
 	p, l, err := rc.pdClient.GetTS(ctx)
@@ -295,7 +462,7 @@ func (rc *Client) ResetTS(ctx context.Context, pdAddrs []string) error {
 	return utils.WithRetry(ctx, func() error {
 		idx := i % len(pdAddrs)
 		i++
-		return pdutil.ResetTS(ctx, pdAddrs[idx], restoreTS, rc.tlsConf)
+		return pdutil.ResetTS(ctx, pdAddrs[idx], restoreTS, rc.pdTLSConf)
 	}, newPDReqBackoffer())
 }
 
@@ -307,7 +474,7 @@ func (rc *Client) GetPlacementRules(ctx context.Context, pdAddrs []string) ([]pl
 		var err error
 		idx := i % len(pdAddrs)
 		i++
-		placementRules, err = pdutil.GetPlacementRules(ctx, pdAddrs[idx], rc.tlsConf)
+		placementRules, err = pdutil.GetPlacementRules(ctx, pdAddrs[idx], rc.pdTLSConf)
 		return errors.Trace(err)
 	}, newPDReqBackoffer())
 	return placementRules, errors.Trace(errRetry)
@@ -400,13 +567,20 @@ func (rc *Client) createTable(
 	table *utils.Table,
 	newTS uint64,
 ) (CreatedTable, error) {
-	if rc.IsSkipCreateSQL() {
+	if rc.IsSkipCreateSQL() || rc.indexMode == RestoreIndexOnly {
 		log.Info("skip create table and alter autoIncID", zap.Stringer("table", table.Info.Name))
 	} else {
+		createTable := table
+		if rc.indexMode == RestoreSkipIndex {
+			// the skipped indexes would be added back by GoRebuildIndexes.
+			stripped := *table
+			stripped.Info = stripRebuildableIndexes(table.Info)
+			createTable = &stripped
+		}
 		// don't use rc.ctx here...
 		// remove the ctx field of Client would be a great work,
 		// we just take a small step here :<
-		err := db.CreateTable(ctx, table)
+		err := db.CreateTable(ctx, createTable)
 		if err != nil {
 			return CreatedTable{}, errors.Trace(err)
 		}
@@ -415,7 +589,11 @@ func (rc *Client) createTable(
 	if err != nil {
 		return CreatedTable{}, errors.Trace(err)
 	}
-	rules := GetRewriteRules(newTableInfo, table.Info, newTS)
+	rc.ddlAudit.recordTableIDs(table.DB.Name.O, table.Info.Name.O, newTableInfo)
+	rules, err := rc.transformRewriteRules(table, newTableInfo, GetRewriteRules(newTableInfo, table.Info, newTS))
+	if err != nil {
+		return CreatedTable{}, errors.Trace(err)
+	}
 	et := CreatedTable{
 		RewriteRule: rules,
 		Table:       newTableInfo,
@@ -452,6 +630,12 @@ func (rc *Client) GoCreateTables(
 				zap.Stringer("table", t.Info.Name))
 			return errors.Trace(err)
 		}
+		if err = rc.applyPlacementMapping(c, t.DB.Name.O, rt.Table); err != nil {
+			return errors.Trace(err)
+		}
+		if err = rc.applyRegionTopology(c, t.DB.Name.O, rt.Table); err != nil {
+			return errors.Trace(err)
+		}
 		log.Debug("table created and send to next",
 			zap.Int("output chan size", len(outCh)),
 			zap.Stringer("table", t.Info.Name),
@@ -462,6 +646,7 @@ func (rc *Client) GoCreateTables(
 	go func() {
 		defer close(outCh)
 		defer log.Debug("all tables are created")
+		defer summary.EndStage(summary.StageSchema)
 		var err error
 		if len(dbPool) > 0 {
 			err = rc.createTablesWithDBPool(ctx, createOneTable, tables, dbPool)
@@ -478,7 +663,7 @@ func (rc *Client) GoCreateTables(
 func (rc *Client) createTablesWithSoleDB(ctx context.Context,
 	createOneTable func(ctx context.Context, db *DB, t *utils.Table) error,
 	tables []*utils.Table) error {
-	for _, t := range tables {
+	for _, t := range OrderTablesByDependency(tables) {
 		if err := createOneTable(ctx, rc.db, t); err != nil {
 			return errors.Trace(err)
 		}
@@ -486,32 +671,77 @@ func (rc *Client) createTablesWithSoleDB(ctx context.Context,
 	return nil
 }
 
+// createTablesWithDBPool creates the tables concurrently, a table is created
+// as soon as all the tables it depends on (e.g. the tables of a view) have
+// been created.
 func (rc *Client) createTablesWithDBPool(ctx context.Context,
 	createOneTable func(ctx context.Context, db *DB, t *utils.Table) error,
 	tables []*utils.Table, dbPool []*DB) error {
 	eg, ectx := errgroup.WithContext(ctx)
 	workers := utils.NewWorkerPool(uint(len(dbPool)), "DDL workers")
-	for _, t := range tables {
-		table := t
-		workers.ApplyWithIDInErrorGroup(eg, func(id uint64) error {
-			db := dbPool[id%uint64(len(dbPool))]
-			return createOneTable(ectx, db, table)
-		})
+	scheduler := newTableDependencyGraph(tables).newScheduler()
+	finished := make(chan int, len(tables))
+	running := 0
+	for {
+		for _, i := range scheduler.next(running) {
+			index, table := i, tables[i]
+			running++
+			workers.ApplyWithIDInErrorGroup(eg, func(id uint64) error {
+				db := dbPool[id%uint64(len(dbPool))]
+				if err := createOneTable(ectx, db, table); err != nil {
+					return errors.Trace(err)
+				}
+				finished <- index
+				return nil
+			})
+		}
+		if running == 0 {
+			break
+		}
+		select {
+		case i := <-finished:
+			running--
+			scheduler.finish(i)
+		case <-ectx.Done():
+			if err := eg.Wait(); err != nil {
+				return errors.Trace(err)
+			}
+			return errors.Trace(ectx.Err())
+		}
 	}
 	return eg.Wait()
 }
 
-// ExecDDLs executes the queries of the ddl jobs.
+// ExecDDLs executes the queries of the ddl jobs in the order of their schema
+// versions. The jobs which have been applied are skipped, so an interrupted
+// incremental restore can be retried.
 func (rc *Client) ExecDDLs(ctx context.Context, ddlJobs []*model.Job) error {
 	// Sort the ddl jobs by schema version in ascending order.
 	sort.Slice(ddlJobs, func(i, j int) bool {
 		return ddlJobs[i].BinlogInfo.SchemaVersion < ddlJobs[j].BinlogInfo.SchemaVersion
 	})
 
-	for _, job := range ddlJobs {
+	executed := make(map[int64]struct{}, len(ddlJobs))
+	for i, job := range ddlJobs {
+		// A job may be related to several restored tables.
+		if _, ok := executed[job.ID]; ok {
+			continue
+		}
+		executed[job.ID] = struct{}{}
 		err := rc.db.ExecDDL(ctx, job)
 		if err != nil {
-			return errors.Trace(err)
+			var is infoschema.InfoSchema
+			if rc.dom != nil {
+				is = rc.dom.InfoSchema()
+			}
+			if !isDDLApplied(job, err, is, ddlJobs[i+1:]) {
+				return errors.Trace(err)
+			}
+			log.Info("ddl has been applied, skip it",
+				zap.String("db", job.SchemaName),
+				zap.String("query", job.Query),
+				zap.Error(err))
+			continue
 		}
 		log.Info("execute ddl query",
 			zap.String("db", job.SchemaName),
@@ -522,22 +752,76 @@ func (rc *Client) ExecDDLs(ctx context.Context, ddlJobs []*model.Job) error {
 }
 
 func (rc *Client) setSpeedLimit(ctx context.Context) error {
-	if !rc.hasSpeedLimited && rc.rateLimit != 0 {
-		stores, err := conn.GetAllTiKVStores(ctx, rc.pdClient, conn.SkipTiFlash)
+	limit := rc.bandwidthBudget.Limit(rc.liveTuning.RateLimit(rc.rateLimit))
+	// Zero lifts the limit set before.
+	if (limit == 0 && !rc.hasSpeedLimited) || (rc.hasSpeedLimited && limit == rc.speedLimit) {
+		return nil
+	}
+	stores, err := conn.GetAllTiKVStores(ctx, rc.pdClient, conn.SkipTiFlash)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, store := range stores {
+		err = rc.fileImporter.setDownloadSpeedLimit(ctx, store.GetId(), limit)
 		if err != nil {
 			return errors.Trace(err)
 		}
-		for _, store := range stores {
-			err = rc.fileImporter.setDownloadSpeedLimit(ctx, store.GetId())
-			if err != nil {
-				return errors.Trace(err)
-			}
-		}
-		rc.hasSpeedLimited = true
 	}
+	if rc.hasSpeedLimited {
+		log.Info("download speed limit changed", zap.Uint64("from", rc.speedLimit), zap.Uint64("to", limit))
+	}
+	rc.hasSpeedLimited = true
+	rc.speedLimit = limit
 	return nil
 }
 
+// ProbeStores pre-dials the TiKV stores the restore will use and probes the
+// capability of their ImportSST services before the ingest phase. It fails if
+// any of them can't restore files, instead of failing lazily in the middle of
+// restore.
+func (rc *Client) ProbeStores(ctx context.Context) (StoreCapabilities, error) {
+	stores, err := conn.GetAllTiKVStores(ctx, rc.pdClient, conn.SkipTiFlash)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !rc.learnerIngest {
+		// The learner-first ingest keeps the voters on the serving stores.
+		stores = usedStores(stores, rc.restoreStores)
+	}
+	caps := rc.fileImporter.probeStores(ctx, stores)
+	for _, store := range stores {
+		c, ok := caps[store.GetId()]
+		if !ok {
+			continue
+		}
+		log.Info("store capability",
+			zap.Uint64("store", c.StoreID),
+			zap.String("address", c.Address),
+			zap.Bool("reachable", c.Reachable),
+			zap.Bool("download", c.Download),
+			zap.Bool("multi-ingest", c.MultiIngest))
+		if err := caps.CheckStore(c.StoreID); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return caps, nil
+}
+
+// usedStores returns the stores the restore will use, which are the restore
+// stores if any, or all the stores.
+func usedStores(stores []*metapb.Store, restoreStores []uint64) []*metapb.Store {
+	if len(restoreStores) == 0 {
+		return stores
+	}
+	used := make([]*metapb.Store, 0, len(restoreStores))
+	for _, store := range stores {
+		if containsStore(restoreStores, store.GetId()) {
+			used = append(used, store)
+		}
+	}
+	return used
+}
+
 // RestoreFiles tries to restore the files.
 func (rc *Client) RestoreFiles(
 	ctx context.Context,
@@ -556,26 +840,15 @@ func (rc *Client) RestoreFiles(
 
 	log.Debug("start to restore files", zap.Int("files", len(files)))
 
-	eg, ectx := errgroup.WithContext(ctx)
 	err = rc.setSpeedLimit(ctx)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
-	for _, file := range files {
-		fileReplica := file
-		rc.workerPool.ApplyOnErrorGroup(eg,
-			func() error {
-				fileStart := time.Now()
-				defer func() {
-					log.Info("import file done", logutil.File(fileReplica),
-						zap.Duration("take", time.Since(fileStart)))
-					updateCh.Inc()
-				}()
-				return rc.fileImporter.Import(ectx, fileReplica, rewriteRules)
-			})
-	}
-	if err := eg.Wait(); err != nil {
+	if err := rc.importFiles(ctx, files, rewriteRules, func(file *backup.File) {
+		log.Info("import file done", logutil.File(file))
+		updateCh.Inc()
+	}); err != nil {
 		summary.CollectFailureUnit("file", err)
 		log.Error(
 			"restore files failed",
@@ -598,24 +871,14 @@ func (rc *Client) RestoreRaw(
 			logutil.Key("endKey", endKey),
 			zap.Duration("take", elapsed))
 	}()
-	errCh := make(chan error, len(files))
-	eg, ectx := errgroup.WithContext(ctx)
-	defer close(errCh)
-
 	err := rc.fileImporter.SetRawRange(startKey, endKey)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
-	for _, file := range files {
-		fileReplica := file
-		rc.workerPool.ApplyOnErrorGroup(eg,
-			func() error {
-				defer updateCh.Inc()
-				return rc.fileImporter.Import(ectx, fileReplica, EmptyRewriteRule())
-			})
-	}
-	if err := eg.Wait(); err != nil {
+	if err := rc.importFiles(ctx, files, EmptyRewriteRule(), func(*backup.File) {
+		updateCh.Inc()
+	}); err != nil {
 		log.Error(
 			"restore raw range failed",
 			logutil.Key("startKey", startKey),
@@ -642,19 +905,9 @@ func (rc *Client) RestoreTxn(
 		log.Info("Restore Txn",
 			zap.Duration("take", elapsed))
 	}()
-	errCh := make(chan error, len(files))
-	eg, ectx := errgroup.WithContext(ctx)
-	defer close(errCh)
-
-	for _, file := range files {
-		fileReplica := file
-		rc.workerPool.ApplyOnErrorGroup(eg,
-			func() error {
-				defer updateCh.Inc()
-				return rc.fileImporter.Import(ectx, fileReplica, nil)
-			})
-	}
-	if err := eg.Wait(); err != nil {
+	if err := rc.importFiles(ctx, files, nil, func(*backup.File) {
+		updateCh.Inc()
+	}); err != nil {
 		log.Error(
 			"restore txn range failed",
 			zap.Error(err),
@@ -718,15 +971,15 @@ func (rc *Client) switchTiKVMode(ctx context.Context, mode import_sstpb.SwitchMo
 		if rc.tlsConf != nil {
 			opt = grpc.WithTransportCredentials(credentials.NewTLS(rc.tlsConf))
 		}
-		gctx, cancel := context.WithTimeout(ctx, time.Second*5)
-		conn, err := grpc.DialContext(
-			gctx,
-			store.GetAddress(),
+		opts := append([]grpc.DialOption{
 			opt,
 			grpc.WithConnectParams(grpc.ConnectParams{Backoff: bfConf}),
 			// we don't need to set keepalive timeout here, because the connection lives
 			// at most 5s. (shorter than minimal value for keepalive time!)
-		)
+		}, rc.maxMsgSize.DialOptions()...)
+		opts = append(opts, utils.GRPCTaskDialOptions()...)
+		gctx, cancel := context.WithTimeout(ctx, time.Second*5)
+		conn, err := grpc.DialContext(gctx, utils.MapStoreAddr(store.GetAddress()), opts...)
 		cancel()
 		if err != nil {
 			return errors.Trace(err)
@@ -747,6 +1000,50 @@ func (rc *Client) switchTiKVMode(ctx context.Context, mode import_sstpb.SwitchMo
 	return nil
 }
 
+// SetNonStrictChecksum makes the checksum mismatches only reported by the
+// checksum report, instead of failing the restore.
+func (rc *Client) SetNonStrictChecksum(nonStrict bool) {
+	rc.nonStrictChecksum = nonStrict
+}
+
+// SetChecksumSampler makes only the tables picked by the sampler verified
+// by GoValidateChecksum.
+func (rc *Client) SetChecksumSampler(sampler *ChecksumSampler) {
+	rc.checksumSampler = sampler
+}
+
+// SetRegionDumper makes the region layout of the restored ranges recorded by
+// the dumper before split, after split and after scatter.
+func (rc *Client) SetRegionDumper(dumper *RegionDumper) {
+	rc.regionDumper = dumper
+}
+
+// SetScatterCache sets the cache of the scattered regions, the regions
+// scattered by the previous runs aren't scattered again.
+func (rc *Client) SetScatterCache(cache *ScatterCache) {
+	rc.scatterCache = cache
+}
+
+// SetIngestCache sets the cache of the ingested files, the files ingested by
+// the previous runs aren't imported again.
+func (rc *Client) SetIngestCache(cache *IngestCache) {
+	rc.ingestCache = cache
+}
+
+// SetDDLAuditLog makes the statements executed by the glue recorded into the
+// audit log, the sessions of the DB pool must be set separately.
+func (rc *Client) SetDDLAuditLog(l *DDLAuditLog) {
+	rc.ddlAudit = l
+	if rc.db != nil {
+		rc.db.SetDDLAuditLog(l)
+	}
+}
+
+// ChecksumReport returns the checksum results of the restored tables.
+func (rc *Client) ChecksumReport() *ChecksumReport {
+	return &rc.checksumReport
+}
+
 // GoValidateChecksum forks a goroutine to validate checksum after restore.
 // it returns a channel fires a struct{} when all things get done.
 func (rc *Client) GoValidateChecksum(
@@ -783,12 +1080,24 @@ func (rc *Client) GoValidateChecksum(
 				if !ok {
 					return
 				}
+				if !rc.checksumSampler.Sample(tbl.OldTable.DB.Name.O, tbl.OldTable.Info.Name.O) {
+					log.Info("table isn't sampled, skipping checksum",
+						zap.String("db", tbl.OldTable.DB.Name.O),
+						zap.String("table", tbl.OldTable.Info.Name.O))
+					rc.checksumReport.AddUnverified(1)
+					updateCh.Inc()
+					glue.AddBytes(updateCh, tbl.OldTable.TotalBytes)
+					continue
+				}
 				workers.ApplyOnErrorGroup(wg, func() error {
+					summary.RegisterStage(summary.StageChecksum)
 					err := rc.execChecksum(ectx, tbl, kvClient, concurrency)
+					summary.EndStage(summary.StageChecksum)
 					if err != nil {
 						return errors.Trace(err)
 					}
 					updateCh.Inc()
+					glue.AddBytes(updateCh, tbl.OldTable.TotalBytes)
 					return nil
 				})
 			}
@@ -805,6 +1114,12 @@ func (rc *Client) execChecksum(ctx context.Context, tbl CreatedTable, kvClient k
 
 	if tbl.OldTable.NoChecksum() {
 		logger.Warn("table has no checksum, skipping checksum")
+		rc.checksumReport.AddUnverified(1)
+		return nil
+	}
+	if rc.checksumCache.verified(tbl) {
+		logger.Info("table checksum has been verified by the previous run, skipping checksum")
+		rc.checksumReport.addVerified()
 		return nil
 	}
 
@@ -819,8 +1134,30 @@ func (rc *Client) execChecksum(ctx context.Context, tbl CreatedTable, kvClient k
 	if err != nil {
 		return errors.Trace(err)
 	}
+	// Log the progress of the large tables periodically, so a long checksum
+	// can be told from a hung one.
+	start := time.Now()
+	var finished int64
+	total := exe.Len()
+	progressDone := make(chan struct{})
+	defer close(progressDone)
+	go func() {
+		ticker := time.NewTicker(checksumProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-progressDone:
+				return
+			case <-ticker.C:
+				logger.Info("table checksum in progress",
+					zap.Int64("finished-requests", atomic.LoadInt64(&finished)),
+					zap.Int("total-requests", total),
+					zap.Duration("take", time.Since(start)))
+			}
+		}
+	}()
 	checksumResp, err := exe.Execute(ctx, kvClient, func() {
-		// TODO: update progress here.
+		atomic.AddInt64(&finished, 1)
 	})
 	if err != nil {
 		return errors.Trace(err)
@@ -838,8 +1175,27 @@ func (rc *Client) execChecksum(ctx context.Context, tbl CreatedTable, kvClient k
 			zap.Uint64("origin tidb total bytes", table.TotalBytes),
 			zap.Uint64("calculated total bytes", checksumResp.TotalBytes),
 		)
+		rc.checksumReport.addDeviation(ChecksumDeviation{
+			DB:                 table.DB.Name.O,
+			Table:              table.Info.Name.O,
+			ExpectedCrc64Xor:   table.Crc64Xor,
+			ExpectedTotalKvs:   table.TotalKvs,
+			ExpectedTotalBytes: table.TotalBytes,
+			ActualCrc64Xor:     checksumResp.Checksum,
+			ActualTotalKvs:     checksumResp.TotalKvs,
+			ActualTotalBytes:   checksumResp.TotalBytes,
+		})
+		if rc.nonStrictChecksum {
+			return nil
+		}
 		return errors.Annotate(berrors.ErrRestoreChecksumMismatch, "failed to validate checksum")
 	}
+	rc.checksumReport.addVerified()
+	logger.Info("table checksum passed",
+		zap.Uint64("total-kvs", checksumResp.TotalKvs),
+		zap.Uint64("total-bytes", checksumResp.TotalBytes),
+		zap.Duration("take", time.Since(start)))
+	rc.checksumCache.record(ctx, tbl)
 	if table.Stats != nil {
 		logger.Info("start loads analyze after validate checksum",
 			zap.Int64("old id", tbl.OldTable.Info.ID),
@@ -897,25 +1253,12 @@ func (rc *Client) SetupPlacementRules(ctx context.Context, tables []*model.Table
 		return nil
 	}
 	log.Info("start setting placement rules")
-	rule, err := rc.toolClient.GetPlacementRule(ctx, "pd", "default")
-	if err != nil {
-		return errors.Trace(err)
+	role := placement.Voter
+	if rc.learnerIngest {
+		role = placement.Learner
 	}
-	rule.Index = 100
-	rule.Override = true
-	rule.LabelConstraints = append(rule.LabelConstraints, placement.LabelConstraint{
-		Key:    restoreLabelKey,
-		Op:     "in",
-		Values: []string{restoreLabelValue},
-	})
-	for _, t := range tables {
-		rule.ID = rc.getRuleID(t.ID)
-		rule.StartKeyHex = hex.EncodeToString(codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(t.ID)))
-		rule.EndKeyHex = hex.EncodeToString(codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(t.ID+1)))
-		err = rc.toolClient.SetPlacementRule(ctx, rule)
-		if err != nil {
-			return errors.Trace(err)
-		}
+	if err := rc.setRestoreRules(ctx, tables, role); err != nil {
+		return errors.Trace(err)
 	}
 	log.Info("finish setting placement rules")
 	return nil
@@ -927,31 +1270,26 @@ func (rc *Client) WaitPlacementSchedule(ctx context.Context, tables []*model.Tab
 		return nil
 	}
 	log.Info("start waiting placement schedule")
-	ticker := time.NewTicker(time.Second * 10)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			ok, progress, err := rc.checkRegions(ctx, tables)
-			if err != nil {
-				return errors.Trace(err)
-			}
-			if ok {
-				log.Info("finish waiting placement schedule")
-				return nil
-			}
-			log.Info("placement schedule progress: " + progress)
-		case <-ctx.Done():
-			return ctx.Err()
-		}
+	check := rc.checkRange
+	if rc.learnerIngest {
+		check = rc.checkLearnerRange
+	}
+	if err := rc.waitRegions(ctx, tables, check); err != nil {
+		return errors.Trace(err)
 	}
+	log.Info("finish waiting placement schedule")
+	return nil
 }
 
-func (rc *Client) checkRegions(ctx context.Context, tables []*model.TableInfo) (bool, string, error) {
+func (rc *Client) checkRegions(
+	ctx context.Context,
+	tables []*model.TableInfo,
+	check func(ctx context.Context, start, end []byte) (bool, string, error),
+) (bool, string, error) {
 	for i, t := range tables {
 		start := codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(t.ID))
 		end := codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(t.ID+1))
-		ok, regionProgress, err := rc.checkRange(ctx, start, end)
+		ok, regionProgress, err := check(ctx, start, end)
 		if err != nil {
 			return false, "", errors.Trace(err)
 		}
@@ -963,22 +1301,29 @@ func (rc *Client) checkRegions(ctx context.Context, tables []*model.TableInfo) (
 }
 
 func (rc *Client) checkRange(ctx context.Context, start, end []byte) (bool, string, error) {
-	regions, err := rc.toolClient.ScanRegions(ctx, start, end, -1)
+	ok, progress := true, ""
+	err := WalkRegions(ctx, rc.toolClient, start, end, scanRegionPaginationLimit, func(i int, r *RegionInfo) bool {
+		for _, p := range r.Region.GetPeers() {
+			if !containsStore(rc.restoreStores, p.GetStoreId()) {
+				ok, progress = false, fmt.Sprintf("region %v", i)
+				return false
+			}
+		}
+		return true
+	})
 	if err != nil {
 		return false, "", errors.Trace(err)
 	}
-	for i, r := range regions {
-	NEXT_PEER:
-		for _, p := range r.Region.GetPeers() {
-			for _, storeID := range rc.restoreStores {
-				if p.GetStoreId() == storeID {
-					continue NEXT_PEER
-				}
-			}
-			return false, fmt.Sprintf("region %v/%v", i, len(regions)), nil
+	return ok, progress, nil
+}
+
+func containsStore(stores []uint64, storeID uint64) bool {
+	for _, id := range stores {
+		if id == storeID {
+			return true
 		}
 	}
-	return true, "", nil
+	return false
 }
 
 // ResetPlacementRules removes placement rules for tables.
@@ -990,6 +1335,10 @@ func (rc *Client) ResetPlacementRules(ctx context.Context, tables []*model.Table
 	var failedTables []int64
 	for _, t := range tables {
 		err := rc.toolClient.DeletePlacementRule(ctx, "pd", rc.getRuleID(t.ID))
+		if err == nil && rc.learnerIngest {
+			// The leader rule is left if the learners failed to be promoted.
+			err = rc.toolClient.DeletePlacementRule(ctx, "pd", rc.getLeaderRuleID(t.ID))
+		}
 		if err != nil {
 			log.Info("failed to delete placement rule for table", zap.Int64("table-id", t.ID))
 			failedTables = append(failedTables, t.ID)
@@ -1001,8 +1350,14 @@ func (rc *Client) ResetPlacementRules(ctx context.Context, tables []*model.Table
 	return nil
 }
 
+// restoreRulePrefix is the prefix of the IDs of the placement rules set by
+// restore.
+const restoreRulePrefix = "restore-t"
+
+// getRuleID returns the ID of the placement rule of the table, which ends
+// with the task ID, so `br restore abort` only removes the rules of the task.
 func (rc *Client) getRuleID(tableID int64) string {
-	return "restore-t" + strconv.FormatInt(tableID, 10)
+	return restoreRulePrefix + strconv.FormatInt(tableID, 10) + "-" + rc.taskID
 }
 
 // IsIncremental returns whether this backup is incremental.
@@ -9,11 +9,18 @@ import (
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
 	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/parser/terror"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+	"github.com/pingcap/tidb/infoschema"
 	"github.com/pingcap/tidb/kv"
 	"go.uber.org/zap"
 
 	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/summary"
 	"github.com/pingcap/br/pkg/utils"
 )
 
@@ -42,6 +49,12 @@ func NewDB(g glue.Glue, store kv.Storage) (*DB, error) {
 	}, nil
 }
 
+// SetDDLAuditLog makes the statements executed by the DB recorded into the
+// audit log.
+func (db *DB) SetDDLAuditLog(l *DDLAuditLog) {
+	db.se = l.wrap(db.se)
+}
+
 // ExecDDL executes the query of a ddl job.
 func (db *DB) ExecDDL(ctx context.Context, ddlJob *model.Job) error {
 	var err error
@@ -87,6 +100,137 @@ func (db *DB) ExecDDL(ctx context.Context, ddlJob *model.Job) error {
 	return errors.Trace(err)
 }
 
+// isDDLApplied returns whether the DDL job failed because it has been applied,
+// e.g. by the previous run of an interrupted incremental restore. The current
+// schemas in is tell whether the ambiguous failures of RENAME TABLE and
+// MODIFY COLUMN are applied, and the later jobs tell whether the table of the
+// job has been renamed or dropped since. A replayed TRUNCATE TABLE empties the
+// table again, which is fine since the files restored after it are ingested
+// again under the new table ID.
+func isDDLApplied(job *model.Job, err error, is infoschema.InfoSchema, later []*model.Job) bool {
+	if job.Type == model.ActionRenameTable &&
+		(infoschema.ErrTableExists.Equal(err) || isTableMissing(err)) && isTableRenamed(job, is) {
+		return true
+	}
+	if job.TableID != 0 && isTableMissing(err) && isTableRemovedLater(job, later) {
+		return true
+	}
+	var applied []*terror.Error
+	switch job.Type {
+	case model.ActionCreateSchema:
+		applied = []*terror.Error{infoschema.ErrDatabaseExists}
+	case model.ActionDropSchema:
+		applied = []*terror.Error{infoschema.ErrDatabaseDropExists}
+	case model.ActionCreateTable, model.ActionCreateView, model.ActionCreateSequence:
+		applied = []*terror.Error{infoschema.ErrTableExists}
+	case model.ActionDropTable, model.ActionDropView, model.ActionDropSequence:
+		applied = []*terror.Error{infoschema.ErrTableDropExists}
+	case model.ActionAddColumn, model.ActionAddColumns:
+		applied = []*terror.Error{infoschema.ErrColumnExists}
+	case model.ActionAddIndex, model.ActionAddPrimaryKey:
+		applied = []*terror.Error{infoschema.ErrIndexExists}
+	case model.ActionDropColumn, model.ActionDropColumns, model.ActionDropIndex, model.ActionDropPrimaryKey:
+		applied = []*terror.Error{infoschema.ErrCantDropFieldOrKey}
+	case model.ActionModifyColumn:
+		// The replayed CHANGE COLUMN and RENAME COLUMN can't find the original
+		// column, a replayed MODIFY COLUMN simply succeeds.
+		return infoschema.ErrColumnNotExists.Equal(err) && isColumnChanged(job, is)
+	}
+	for _, e := range applied {
+		if e.Equal(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTableMissing returns whether the error is about a missing table. RENAME
+// TABLE returns ErrFileNotFound if neither of the tables exists.
+func isTableMissing(err error) bool {
+	if infoschema.ErrTableNotExists.Equal(err) || infoschema.ErrDatabaseNotExists.Equal(err) {
+		return true
+	}
+	e, ok := errors.Cause(err).(*terror.Error)
+	return ok && uint16(e.Code()) == mysql.ErrFileNotFound
+}
+
+// isTableRenamed returns whether the renamed table of the RENAME TABLE job
+// exists and the original one doesn't.
+func isTableRenamed(job *model.Job, is infoschema.InfoSchema) bool {
+	if is == nil || job.BinlogInfo == nil || job.BinlogInfo.TableInfo == nil {
+		return false
+	}
+	stmt, err := parser.New().ParseOneStmt(job.Query, "", "")
+	if err != nil {
+		return false
+	}
+	var origin *ast.TableName
+	switch s := stmt.(type) {
+	case *ast.RenameTableStmt:
+		if len(s.TableToTables) > 0 {
+			origin = s.TableToTables[0].OldTable
+		}
+	case *ast.AlterTableStmt:
+		origin = s.Table
+	}
+	if origin == nil {
+		return false
+	}
+	// The statement is executed in the database of the job.
+	originSchema := origin.Schema
+	if originSchema.L == "" {
+		originSchema = model.NewCIStr(job.SchemaName)
+	}
+	return is.TableExists(model.NewCIStr(job.SchemaName), job.BinlogInfo.TableInfo.Name) &&
+		!is.TableExists(originSchema, origin.Name)
+}
+
+// isTableRemovedLater returns whether the table of the job is renamed or
+// dropped by the later jobs, following the new IDs of the truncated table.
+func isTableRemovedLater(job *model.Job, later []*model.Job) bool {
+	tableIDs := map[int64]struct{}{job.TableID: {}}
+	if job.Type == model.ActionTruncateTable && job.BinlogInfo != nil && job.BinlogInfo.TableInfo != nil {
+		tableIDs[job.BinlogInfo.TableInfo.ID] = struct{}{}
+	}
+	for _, j := range later {
+		if _, ok := tableIDs[j.TableID]; !ok {
+			continue
+		}
+		switch j.Type {
+		case model.ActionRenameTable, model.ActionDropTable, model.ActionDropView, model.ActionDropSequence:
+			return true
+		case model.ActionTruncateTable:
+			if j.BinlogInfo != nil && j.BinlogInfo.TableInfo != nil {
+				tableIDs[j.BinlogInfo.TableInfo.ID] = struct{}{}
+			}
+		}
+	}
+	return false
+}
+
+// isColumnChanged returns whether the column of the MODIFY COLUMN job has
+// the new name in the table.
+func isColumnChanged(job *model.Job, is infoschema.InfoSchema) bool {
+	if is == nil || job.BinlogInfo == nil || job.BinlogInfo.TableInfo == nil {
+		return false
+	}
+	var newCol model.ColumnInfo
+	var oldName model.CIStr
+	if err := job.DecodeArgs(&newCol, &oldName); err != nil {
+		return false
+	}
+	tbl, err := is.TableByName(model.NewCIStr(job.SchemaName), job.BinlogInfo.TableInfo.Name)
+	if err != nil {
+		return false
+	}
+	var hasNew, hasOld bool
+	for _, col := range tbl.Meta().Columns {
+		hasNew = hasNew || col.Name.L == newCol.Name.L
+		hasOld = hasOld || col.Name.L == oldName.L
+	}
+	return hasNew && !hasOld
+}
+
 // CreateDatabase executes a CREATE DATABASE SQL.
 func (db *DB) CreateDatabase(ctx context.Context, schema *model.DBInfo) error {
 	err := db.se.CreateDatabase(ctx, schema)
@@ -96,6 +240,49 @@ func (db *DB) CreateDatabase(ctx context.Context, schema *model.DBInfo) error {
 	return errors.Trace(err)
 }
 
+// SafeSequenceValue rounds the backed up sequence value up to the next value
+// which the sequence is able to produce, so the restored sequence never hands
+// out a value that may have been used before the backup.
+func SafeSequenceValue(seq *model.SequenceInfo, value int64) int64 {
+	if seq == nil || seq.Increment == 0 {
+		return value
+	}
+	if seq.Increment > 0 {
+		if value <= seq.Start {
+			return value
+		}
+		if value >= seq.MaxValue {
+			return seq.MaxValue
+		}
+		// The difference never overflows uint64 since both are int64.
+		rem := uint64(value-seq.Start) % uint64(seq.Increment)
+		if rem == 0 {
+			return value
+		}
+		add := uint64(seq.Increment) - rem
+		if uint64(seq.MaxValue-value) <= add {
+			return seq.MaxValue
+		}
+		return value + int64(add)
+	}
+	if value >= seq.Start {
+		return value
+	}
+	if value <= seq.MinValue {
+		return seq.MinValue
+	}
+	step := uint64(-(seq.Increment + 1)) + 1
+	rem := uint64(seq.Start-value) % step
+	if rem == 0 {
+		return value
+	}
+	sub := step - rem
+	if uint64(value-seq.MinValue) <= sub {
+		return seq.MinValue
+	}
+	return value - int64(sub)
+}
+
 // CreateTable executes a CREATE TABLE SQL.
 func (db *DB) CreateTable(ctx context.Context, table *utils.Table) error {
 	err := db.se.CreateTable(ctx, table.DB.Name, table.Info)
@@ -149,7 +336,7 @@ func (db *DB) CreateTable(ctx context.Context, table *utils.Table) error {
 				return errors.Trace(err)
 			}
 		}
-		restoreMetaSQL = fmt.Sprintf(setValFormat, table.Info.AutoIncID)
+		restoreMetaSQL = fmt.Sprintf(setValFormat, SafeSequenceValue(table.Info.Sequence, table.Info.AutoIncID))
 		err = db.se.Execute(ctx, restoreMetaSQL)
 	} else {
 		var alterAutoIncIDFormat string
@@ -263,6 +450,84 @@ func FilterDDLJobs(allDDLJobs []*model.Job, tables []*utils.Table) (ddlJobs []*m
 	return ddlJobs
 }
 
+// unsupportedDDLTypes are the DDL jobs can't be replayed by restore, mapped to
+// the reasons.
+var unsupportedDDLTypes = map[model.ActionType]string{
+	model.ActionRecoverTable:               "the recovered data isn't in the backup",
+	model.ActionRepairTable:                "repairing a table relies on the local schema of TiDB",
+	model.ActionLockTable:                  "table locks belong to sessions",
+	model.ActionUnlockTable:                "table locks belong to sessions",
+	model.ActionSetTiFlashReplica:          "TiFlash replicas are set after restore",
+	model.ActionUpdateTiFlashReplicaStatus: "TiFlash replicas are set after restore",
+}
+
+// FilterReplayDDLJobs filters the DDL jobs to replay by the table filter, and
+// skips the unsupported ones with warnings. A table job is kept if any name of
+// the table matches the filter, so the jobs before renaming a table into the
+// filter are kept too. A nil filter matches all the tables.
+func FilterReplayDDLJobs(ddlJobs []*model.Job, tableFilter filter.Filter) []*model.Job {
+	// The groups of the table IDs, keyed by the IDs, a table gets a new ID on
+	// truncate.
+	tableGroups := make(map[int64]int64)
+	matched := make(map[int64]bool)
+	groupOf := func(job *model.Job) int64 {
+		group, ok := tableGroups[job.TableID]
+		if !ok {
+			group = job.TableID
+			tableGroups[job.TableID] = group
+		}
+		// For truncate table, the id may be changed
+		tableGroups[job.BinlogInfo.TableInfo.ID] = group
+		return group
+	}
+	for _, job := range ddlJobs {
+		if job.BinlogInfo.TableInfo == nil {
+			continue
+		}
+		group := groupOf(job)
+		if tableFilter == nil || tableFilter.MatchTable(job.SchemaName, job.BinlogInfo.TableInfo.Name.O) {
+			matched[group] = true
+		}
+	}
+
+	filtered := make([]*model.Job, 0, len(ddlJobs))
+	unsupported := 0
+	for _, job := range ddlJobs {
+		if reason, ok := unsupportedDDLTypes[job.Type]; ok {
+			log.Warn("skip unsupported ddl job",
+				zap.Int64("id", job.ID),
+				zap.Stringer("type", job.Type),
+				zap.String("db", job.SchemaName),
+				zap.String("query", job.Query),
+				zap.String("reason", reason))
+			unsupported++
+			continue
+		}
+		switch {
+		case job.BinlogInfo.TableInfo != nil:
+			if !matched[tableGroups[job.TableID]] {
+				log.Info("skip ddl job of filtered out table",
+					zap.Int64("id", job.ID),
+					zap.String("db", job.SchemaName),
+					zap.String("table", job.BinlogInfo.TableInfo.Name.O))
+				continue
+			}
+		case job.BinlogInfo.DBInfo != nil:
+			if tableFilter != nil && !tableFilter.MatchSchema(job.BinlogInfo.DBInfo.Name.O) {
+				log.Info("skip ddl job of filtered out database",
+					zap.Int64("id", job.ID),
+					zap.String("db", job.BinlogInfo.DBInfo.Name.O))
+				continue
+			}
+		}
+		filtered = append(filtered, job)
+	}
+	if unsupported > 0 {
+		summary.CollectWarning("unsupported DDL jobs skipped", unsupported)
+	}
+	return filtered
+}
+
 func getDatabases(tables []*utils.Table) (dbs []*model.DBInfo) {
 	dbIDs := make(map[int64]bool)
 	for _, table := range tables {
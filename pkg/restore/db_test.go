@@ -10,9 +10,11 @@ import (
 
 	. "github.com/pingcap/check"
 	"github.com/pingcap/parser/model"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
 	"github.com/pingcap/tidb/meta/autoid"
 	"github.com/pingcap/tidb/util/testkit"
 	"github.com/pingcap/tidb/util/testleak"
+	"google.golang.org/grpc/keepalive"
 
 	"github.com/pingcap/br/pkg/backup"
 	"github.com/pingcap/br/pkg/gluetidb"
@@ -133,3 +135,116 @@ func (s *testRestoreSchemaSuite) TestFilterDDLJobs(c *C) {
 	}
 	c.Assert(len(ddlJobs), Equals, 7)
 }
+
+func (s *testRestoreSchemaSuite) TestBackupDDLJobsInOrder(c *C) {
+	tk := testkit.NewTestKit(c, s.mock.Storage)
+	tk.MustExec("CREATE DATABASE IF NOT EXISTS test_order;")
+	lastTS, err := s.mock.GetOracle().GetTimestamp(context.Background())
+	c.Assert(err, IsNil)
+	tk.MustExec("CREATE TABLE test_order.t (c1 INT);")
+	tk.MustExec("ALTER TABLE test_order.t ADD COLUMN c2 INT;")
+	tk.MustExec("ALTER TABLE test_order.t ADD INDEX i2 (c2);")
+	tk.MustExec("ALTER TABLE test_order.t DROP COLUMN c1;")
+	ts, err := s.mock.GetOracle().GetTimestamp(context.Background())
+	c.Assert(err, IsNil)
+
+	ddlJobs, err := backup.GetBackupDDLJobs(s.mock.Domain, lastTS, ts)
+	c.Assert(err, IsNil)
+	c.Assert(ddlJobs, HasLen, 4)
+	seen := make(map[int64]bool)
+	for i, job := range ddlJobs {
+		c.Assert(seen[job.ID], IsFalse)
+		seen[job.ID] = true
+		if i > 0 {
+			c.Assert(job.BinlogInfo.SchemaVersion, Greater, ddlJobs[i-1].BinlogInfo.SchemaVersion)
+		}
+	}
+	c.Assert(ddlJobs[0].Type, Equals, model.ActionCreateTable)
+	c.Assert(ddlJobs[3].Type, Equals, model.ActionDropColumn)
+}
+
+func (s *testRestoreSchemaSuite) TestReplayAppliedDDLJobs(c *C) {
+	tk := testkit.NewTestKit(c, s.mock.Storage)
+	tk.MustExec("CREATE DATABASE IF NOT EXISTS test_replay;")
+	tk.MustExec("CREATE TABLE test_replay.t (c1 INT);")
+	lastTS, err := s.mock.GetOracle().GetTimestamp(context.Background())
+	c.Assert(err, IsNil)
+	tk.MustExec("use test_replay")
+	tk.MustExec("TRUNCATE TABLE t;")
+	tk.MustExec("RENAME TABLE t TO t2;")
+	tk.MustExec("TRUNCATE TABLE t2;")
+	tk.MustExec("ALTER TABLE t2 CHANGE c1 c2 BIGINT;")
+	tk.MustExec("ALTER TABLE t2 RENAME TO t3;")
+	ts, err := s.mock.GetOracle().GetTimestamp(context.Background())
+	c.Assert(err, IsNil)
+	ddlJobs, err := backup.GetBackupDDLJobs(s.mock.Domain, lastTS, ts)
+	c.Assert(err, IsNil)
+	c.Assert(ddlJobs, HasLen, 5)
+
+	// All the jobs have been applied, like by an interrupted restore, so the
+	// failures of the replay are all skipped.
+	client, err := restore.NewRestoreClient(gluetidb.New(), s.mock.PDClient, s.mock.Storage, nil, nil,
+		keepalive.ClientParameters{}, utils.GRPCMaxMsgSize{})
+	c.Assert(err, IsNil)
+	defer client.Close()
+	c.Assert(client.ExecDDLs(context.Background(), ddlJobs), IsNil)
+	tk.MustQuery("SHOW TABLES IN test_replay").Check(testkit.Rows("t3"))
+	tk.MustQuery("SELECT COLUMN_NAME, DATA_TYPE FROM information_schema.columns WHERE TABLE_SCHEMA = 'test_replay'").
+		Check(testkit.Rows("c2 bigint"))
+
+	// The failure of the job whose table isn't renamed isn't skipped.
+	tk.MustExec("CREATE TABLE test_replay.t2 (c1 INT);")
+	c.Assert(client.ExecDDLs(context.Background(), ddlJobs[4:]), NotNil)
+}
+
+func (s *testRestoreSchemaSuite) TestFilterReplayDDLJobs(c *C) {
+	tableJob := func(id int64, tp model.ActionType, tableID int64, db, table string) *model.Job {
+		return &model.Job{
+			ID:         id,
+			Type:       tp,
+			TableID:    tableID,
+			SchemaName: db,
+			BinlogInfo: &model.HistoryInfo{TableInfo: &model.TableInfo{ID: tableID, Name: model.NewCIStr(table)}},
+		}
+	}
+	truncate := tableJob(3, model.ActionTruncateTable, 1, "test", "t")
+	truncate.BinlogInfo.TableInfo.ID = 5
+	jobs := []*model.Job{
+		{ID: 1, Type: model.ActionCreateSchema, BinlogInfo: &model.HistoryInfo{DBInfo: &model.DBInfo{Name: model.NewCIStr("test")}}},
+		{ID: 2, Type: model.ActionCreateSchema, BinlogInfo: &model.HistoryInfo{DBInfo: &model.DBInfo{Name: model.NewCIStr("other")}}},
+		// The table is created as an excluded name, and renamed into the filter.
+		tableJob(4, model.ActionCreateTable, 1, "test", "tmp"),
+		tableJob(6, model.ActionRenameTable, 1, "test", "t"),
+		truncate,
+		tableJob(7, model.ActionAddColumn, 5, "test", "t"),
+		tableJob(8, model.ActionCreateTable, 2, "test", "excluded"),
+		tableJob(9, model.ActionLockTable, 5, "test", "t"),
+	}
+	tableFilter, err := filter.Parse([]string{"test.t"})
+	c.Assert(err, IsNil)
+
+	ids := make([]int64, 0)
+	for _, job := range restore.FilterReplayDDLJobs(jobs, tableFilter) {
+		ids = append(ids, job.ID)
+	}
+	c.Assert(ids, DeepEquals, []int64{1, 4, 6, 3, 7})
+	c.Assert(restore.FilterReplayDDLJobs(jobs, nil), HasLen, len(jobs)-1)
+}
+
+func (s *testRestoreSchemaSuite) TestSafeSequenceValue(c *C) {
+	seq := &model.SequenceInfo{Start: 1, Increment: 5, MinValue: 1, MaxValue: 100}
+	c.Assert(restore.SafeSequenceValue(seq, 1), Equals, int64(1))
+	c.Assert(restore.SafeSequenceValue(seq, 11), Equals, int64(11))
+	c.Assert(restore.SafeSequenceValue(seq, 12), Equals, int64(16))
+	c.Assert(restore.SafeSequenceValue(seq, 98), Equals, int64(100))
+	c.Assert(restore.SafeSequenceValue(seq, 200), Equals, int64(100))
+
+	seq = &model.SequenceInfo{Start: -1, Increment: -3, MinValue: -20, MaxValue: -1}
+	c.Assert(restore.SafeSequenceValue(seq, -4), Equals, int64(-4))
+	c.Assert(restore.SafeSequenceValue(seq, -5), Equals, int64(-7))
+	c.Assert(restore.SafeSequenceValue(seq, -18), Equals, int64(-19))
+	c.Assert(restore.SafeSequenceValue(seq, -21), Equals, int64(-20))
+
+	seq = &model.SequenceInfo{Start: math.MinInt64 + 1, Increment: math.MaxInt64, MinValue: math.MinInt64 + 1, MaxValue: math.MaxInt64 - 1}
+	c.Assert(restore.SafeSequenceValue(seq, 1), Equals, int64(math.MaxInt64-1))
+}
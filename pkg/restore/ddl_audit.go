@@ -0,0 +1,167 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/glue"
+)
+
+// The kinds of the statements in the DDL audit log.
+const (
+	DDLAuditCreateDatabase = "create-database"
+	DDLAuditCreateTable    = "create-table"
+	DDLAuditExecute        = "execute"
+)
+
+// DDLAuditEntry is a statement executed by the glue.
+type DDLAuditEntry struct {
+	Kind  string `json:"kind"`
+	DB    string `json:"db,omitempty"`
+	Table string `json:"table,omitempty"`
+	// SQL is the statement, it's empty if the glue can't show the statement
+	// creating the schema.
+	SQL      string        `json:"sql,omitempty"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	// Attempt is the count of the executions of the same statement so far,
+	// greater than 1 means a retry.
+	Attempt int    `json:"attempt"`
+	Error   string `json:"error,omitempty"`
+	// TableID and PartitionIDs are the IDs of the created table.
+	TableID      int64   `json:"table-id,omitempty"`
+	PartitionIDs []int64 `json:"partition-ids,omitempty"`
+}
+
+// DDLAuditLog records every statement the glue executes during restore, so
+// the DBAs can audit exactly what BR did to their cluster.
+type DDLAuditLog struct {
+	mu       sync.Mutex
+	entries  []DDLAuditEntry
+	attempts map[DDLAuditEntry]int
+}
+
+// NewDDLAuditLog creates an empty DDL audit log.
+func NewDDLAuditLog() *DDLAuditLog {
+	return &DDLAuditLog{attempts: make(map[DDLAuditEntry]int)}
+}
+
+// wrap returns the session recording the statements into the log. A nil log
+// returns the session as is.
+func (l *DDLAuditLog) wrap(se glue.Session) glue.Session {
+	if l == nil {
+		return se
+	}
+	return &auditedSession{Session: se, log: l}
+}
+
+func (l *DDLAuditLog) record(entry DDLAuditEntry, start time.Time, err error) {
+	entry.Start = start
+	entry.Duration = time.Since(start)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := DDLAuditEntry{Kind: entry.Kind, DB: entry.DB, Table: entry.Table, SQL: entry.SQL}
+	l.attempts[key]++
+	entry.Attempt = l.attempts[key]
+	l.entries = append(l.entries, entry)
+}
+
+// recordTableIDs records the IDs of the created table into the latest
+// successful creation of it. A nil log records nothing.
+func (l *DDLAuditLog) recordTableIDs(db, table string, info *model.TableInfo) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		entry := &l.entries[i]
+		if entry.Kind != DDLAuditCreateTable || entry.DB != db || entry.Table != table || entry.Error != "" {
+			continue
+		}
+		entry.TableID = info.ID
+		entry.PartitionIDs = nil
+		if info.Partition != nil {
+			for _, def := range info.Partition.Definitions {
+				entry.PartitionIDs = append(entry.PartitionIDs, def.ID)
+			}
+		}
+		return
+	}
+}
+
+// Entries returns the statements recorded so far.
+func (l *DDLAuditLog) Entries() []DDLAuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]DDLAuditEntry{}, l.entries...)
+}
+
+// Save writes the statements into the file as JSON.
+func (l *DDLAuditLog) Save(path string) error {
+	data, err := json.MarshalIndent(struct {
+		Statements []DDLAuditEntry `json:"statements"`
+	}{Statements: l.Entries()}, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ioutil.WriteFile(path, data, 0o644))
+}
+
+// auditedSession records the statements executed by the session.
+type auditedSession struct {
+	glue.Session
+	log *DDLAuditLog
+}
+
+func (s *auditedSession) Execute(ctx context.Context, sql string) error {
+	start := time.Now()
+	err := s.Session.Execute(ctx, sql)
+	s.log.record(DDLAuditEntry{Kind: DDLAuditExecute, SQL: sql}, start, err)
+	return errors.Trace(err)
+}
+
+func (s *auditedSession) CreateDatabase(ctx context.Context, schema *model.DBInfo) error {
+	entry := DDLAuditEntry{Kind: DDLAuditCreateDatabase, DB: schema.Name.O}
+	if se, ok := s.Session.(glue.ShowCreateSession); ok {
+		sql, err := se.ShowCreateDatabase(schema)
+		if err != nil {
+			log.Warn("failed to show the statement creating the database for audit",
+				zap.Stringer("db", schema.Name), zap.Error(err))
+		}
+		entry.SQL = sql
+	}
+	start := time.Now()
+	err := s.Session.CreateDatabase(ctx, schema)
+	s.log.record(entry, start, err)
+	return errors.Trace(err)
+}
+
+func (s *auditedSession) CreateTable(ctx context.Context, dbName model.CIStr, table *model.TableInfo) error {
+	entry := DDLAuditEntry{Kind: DDLAuditCreateTable, DB: dbName.O, Table: table.Name.O}
+	if se, ok := s.Session.(glue.ShowCreateSession); ok {
+		sql, err := se.ShowCreateTable(table)
+		if err != nil {
+			log.Warn("failed to show the statement creating the table for audit",
+				zap.Stringer("db", dbName), zap.Stringer("table", table.Name), zap.Error(err))
+		}
+		entry.SQL = sql
+	}
+	start := time.Now()
+	err := s.Session.CreateTable(ctx, dbName, table)
+	s.log.record(entry, start, err)
+	return errors.Trace(err)
+}
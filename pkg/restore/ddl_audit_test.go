@@ -0,0 +1,95 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/model"
+)
+
+type testDDLAuditSuite struct{}
+
+var _ = Suite(&testDDLAuditSuite{})
+
+type fakeSession struct {
+	failures int
+}
+
+func (s *fakeSession) Execute(ctx context.Context, sql string) error {
+	return nil
+}
+
+func (s *fakeSession) CreateDatabase(ctx context.Context, schema *model.DBInfo) error {
+	return nil
+}
+
+func (s *fakeSession) CreateTable(ctx context.Context, dbName model.CIStr, table *model.TableInfo) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("injected failure")
+	}
+	return nil
+}
+
+func (s *fakeSession) ShowCreateDatabase(schema *model.DBInfo) (string, error) {
+	return "CREATE DATABASE `" + schema.Name.O + "`", nil
+}
+
+func (s *fakeSession) ShowCreateTable(table *model.TableInfo) (string, error) {
+	return "CREATE TABLE `" + table.Name.O + "` (`a` int)", nil
+}
+
+func (s *fakeSession) Close() {}
+
+func (*testDDLAuditSuite) TestDDLAuditLog(c *C) {
+	ctx := context.Background()
+	l := NewDDLAuditLog()
+	se := l.wrap(&fakeSession{failures: 1})
+
+	c.Assert(se.CreateDatabase(ctx, &model.DBInfo{Name: model.NewCIStr("test")}), IsNil)
+	table := &model.TableInfo{Name: model.NewCIStr("t")}
+	c.Assert(se.CreateTable(ctx, model.NewCIStr("test"), table), NotNil)
+	c.Assert(se.CreateTable(ctx, model.NewCIStr("test"), table), IsNil)
+	c.Assert(se.Execute(ctx, "alter table `test`.`t` auto_increment = 100;"), IsNil)
+	l.recordTableIDs("test", "t", &model.TableInfo{
+		ID: 100,
+		Partition: &model.PartitionInfo{
+			Definitions: []model.PartitionDefinition{{ID: 101}, {ID: 102}},
+		},
+	})
+
+	entries := l.Entries()
+	c.Assert(entries, HasLen, 4)
+	c.Assert(entries[0].Kind, Equals, DDLAuditCreateDatabase)
+	c.Assert(entries[0].SQL, Equals, "CREATE DATABASE `test`")
+	c.Assert(entries[1].Attempt, Equals, 1)
+	c.Assert(entries[1].Error, Equals, "injected failure")
+	c.Assert(entries[1].TableID, Equals, int64(0))
+	c.Assert(entries[2].Attempt, Equals, 2)
+	c.Assert(entries[2].Error, Equals, "")
+	c.Assert(entries[2].TableID, Equals, int64(100))
+	c.Assert(entries[2].PartitionIDs, DeepEquals, []int64{101, 102})
+	c.Assert(entries[3].Kind, Equals, DDLAuditExecute)
+
+	path := filepath.Join(c.MkDir(), "ddl-audit.json")
+	c.Assert(l.Save(path), IsNil)
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	var saved struct {
+		Statements []DDLAuditEntry `json:"statements"`
+	}
+	c.Assert(json.Unmarshal(data, &saved), IsNil)
+	c.Assert(saved.Statements, HasLen, 4)
+	c.Assert(saved.Statements[2].TableID, Equals, int64(100))
+
+	// A nil log doesn't wrap the session.
+	var nilLog *DDLAuditLog
+	raw := &fakeSession{}
+	c.Assert(nilLog.wrap(raw), Equals, raw)
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// tableDependencyGraph is the dependencies among the restored tables, a view
+// depends on the tables it selects from, and a table depends on the tables
+// its foreign keys refer to. The dependencies on the tables which aren't
+// restored are ignored, they're assumed to exist.
+type tableDependencyGraph struct {
+	tables []*utils.Table
+	// dependents[i] are the indexes of the tables depending on tables[i].
+	dependents [][]int
+	// dependencies[i] is the count of the tables tables[i] depends on.
+	dependencies []int
+}
+
+func qualifiedTableName(db, table string) string {
+	return db + "." + table
+}
+
+func newTableDependencyGraph(tables []*utils.Table) *tableDependencyGraph {
+	g := &tableDependencyGraph{
+		tables:       tables,
+		dependents:   make([][]int, len(tables)),
+		dependencies: make([]int, len(tables)),
+	}
+	index := make(map[string]int, len(tables))
+	for i, t := range tables {
+		index[qualifiedTableName(t.DB.Name.L, t.Info.Name.L)] = i
+	}
+	for i, t := range tables {
+		seen := make(map[int]struct{})
+		for _, name := range tableDependencies(t) {
+			j, ok := index[name]
+			if _, dup := seen[j]; !ok || dup || j == i {
+				continue
+			}
+			seen[j] = struct{}{}
+			g.dependents[j] = append(g.dependents[j], i)
+			g.dependencies[i]++
+		}
+	}
+	return g
+}
+
+// tableDependencies returns the qualified names of the tables the table
+// depends on, in lower case.
+func tableDependencies(t *utils.Table) []string {
+	var deps []string
+	for _, fk := range t.Info.ForeignKeys {
+		deps = append(deps, qualifiedTableName(t.DB.Name.L, fk.RefTable.L))
+	}
+	if t.Info.IsView() {
+		stmt, err := parser.New().ParseOneStmt(t.Info.View.SelectStmt, "", "")
+		if err != nil {
+			// The view is created after all the other tables then.
+			log.Warn("failed to parse the select statement of view",
+				zap.Stringer("db", t.DB.Name),
+				zap.Stringer("view", t.Info.Name),
+				zap.Error(err))
+			return nil
+		}
+		collector := &tableNameCollector{defaultDB: t.DB.Name.L}
+		stmt.Accept(collector)
+		deps = append(deps, collector.names...)
+	}
+	return deps
+}
+
+// tableNameCollector collects the names of the tables referred by a statement.
+type tableNameCollector struct {
+	defaultDB string
+	names     []string
+}
+
+// Enter implements ast.Visitor.
+func (c *tableNameCollector) Enter(n ast.Node) (ast.Node, bool) {
+	if name, ok := n.(*ast.TableName); ok {
+		db := name.Schema.L
+		if db == "" {
+			db = c.defaultDB
+		}
+		c.names = append(c.names, qualifiedTableName(db, name.Name.L))
+	}
+	return n, false
+}
+
+// Leave implements ast.Visitor.
+func (c *tableNameCollector) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// tableScheduler schedules the tables of a dependency graph, a table is ready
+// once all the tables it depends on have finished.
+type tableScheduler struct {
+	graph        *tableDependencyGraph
+	dependencies []int
+	scheduled    []bool
+	ready        []int
+}
+
+func (g *tableDependencyGraph) newScheduler() *tableScheduler {
+	s := &tableScheduler{
+		graph:        g,
+		dependencies: append([]int(nil), g.dependencies...),
+		scheduled:    make([]bool, len(g.tables)),
+	}
+	for i, deps := range s.dependencies {
+		if deps == 0 {
+			s.ready = append(s.ready, i)
+		}
+	}
+	return s
+}
+
+// next returns the indexes of the tables which become ready. If none is ready
+// and no table is running, the remaining tables are in dependency cycles, and
+// one of them is returned to break the cycle.
+func (s *tableScheduler) next(running int) []int {
+	if len(s.ready) == 0 && running == 0 {
+		for i, scheduled := range s.scheduled {
+			if !scheduled {
+				log.Warn("break the dependency cycle of tables",
+					zap.Stringer("db", s.graph.tables[i].DB.Name),
+					zap.Stringer("table", s.graph.tables[i].Info.Name))
+				s.ready = append(s.ready, i)
+				break
+			}
+		}
+	}
+	ready := s.ready
+	s.ready = nil
+	for _, i := range ready {
+		s.scheduled[i] = true
+	}
+	return ready
+}
+
+// finish marks the table finished, the tables depending on it may be ready.
+func (s *tableScheduler) finish(i int) {
+	for _, j := range s.graph.dependents[i] {
+		s.dependencies[j]--
+		if s.dependencies[j] == 0 && !s.scheduled[j] {
+			s.ready = append(s.ready, j)
+		}
+	}
+}
+
+// OrderTablesByDependency returns the tables in an order that every table is
+// after the tables it depends on, except those in dependency cycles.
+func OrderTablesByDependency(tables []*utils.Table) []*utils.Table {
+	s := newTableDependencyGraph(tables).newScheduler()
+	ordered := make([]*utils.Table, 0, len(tables))
+	for {
+		ready := s.next(0)
+		if len(ready) == 0 {
+			return ordered
+		}
+		for _, i := range ready {
+			ordered = append(ordered, tables[i])
+			s.finish(i)
+		}
+	}
+}
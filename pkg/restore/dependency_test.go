@@ -0,0 +1,53 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+var _ = Suite(&testDependencySuite{})
+
+type testDependencySuite struct{}
+
+func (s *testDependencySuite) TestOrderTablesByDependency(c *C) {
+	db := &model.DBInfo{Name: model.NewCIStr("test")}
+	table := func(name string) *utils.Table {
+		return &utils.Table{DB: db, Info: &model.TableInfo{Name: model.NewCIStr(name)}}
+	}
+	view := func(name, selectStmt string) *utils.Table {
+		t := table(name)
+		t.Info.View = &model.ViewInfo{SelectStmt: selectStmt}
+		return t
+	}
+	withFK := func(t *utils.Table, refs ...string) *utils.Table {
+		for _, ref := range refs {
+			t.Info.ForeignKeys = append(t.Info.ForeignKeys, &model.FKInfo{RefTable: model.NewCIStr(ref)})
+		}
+		return t
+	}
+
+	tables := []*utils.Table{
+		view("v2", "SELECT * FROM `test`.`v1` JOIN `other`.`t` USING (a)"),
+		view("v1", "SELECT a FROM t1 WHERE a IN (SELECT a FROM T2)"),
+		withFK(table("t2"), "t1"),
+		table("t1"),
+		// t3 and t4 refer to each other.
+		withFK(table("t3"), "t4"),
+		withFK(table("t4"), "t3"),
+	}
+	ordered := restore.OrderTablesByDependency(tables)
+	c.Assert(ordered, HasLen, len(tables))
+	position := make(map[string]int)
+	for i, t := range ordered {
+		position[t.Info.Name.L] = i
+	}
+	c.Assert(position, HasLen, len(tables))
+	c.Assert(position["t1"] < position["t2"], IsTrue)
+	c.Assert(position["t2"] < position["v1"], IsTrue)
+	c.Assert(position["v1"] < position["v2"], IsTrue)
+}
@@ -0,0 +1,377 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/executor"
+	"github.com/pingcap/tidb/meta/autoid"
+	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/mock"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	// DefaultDumplingFileSize is the max size of a data file exported.
+	DefaultDumplingFileSize = 256 * utils.MB
+	// DefaultDumplingStatementSize is the max size of an INSERT statement
+	// exported.
+	DefaultDumplingStatementSize = utils.MB
+
+	// DumplingMetadataFile is the name of the metadata file of the export.
+	DumplingMetadataFile = "metadata"
+
+	// dumplingHeader is the header of every SQL file written by Dumpling.
+	dumplingHeader = "/*!40101 SET NAMES binary*/;\n"
+	// dumplingTimeZone makes the rows decoded in UTC imported as they are.
+	dumplingTimeZone = "/*!40103 SET TIME_ZONE='+00:00' */;\n"
+	// dumplingChunkSize is the size of the parts uploaded.
+	dumplingChunkSize = 5 * utils.MB
+	// dumplingTimeFormat is the format of the time in the metadata file.
+	dumplingTimeFormat = "2006-01-02 15:04:05"
+)
+
+// DumplingExporter materializes the tables of a backup into a directory in
+// the format of Dumpling, i.e. the schema files and the INSERT statements of
+// the rows, so the tools only understanding the logical dumps can consume the
+// backup. The rows are decoded from the backup files client-side like
+// LogicalRestorer.
+type DumplingExporter struct {
+	storage       storage.ExternalStorage
+	output        storage.ExternalStorage
+	fileSize      uint64
+	statementSize uint64
+	sctx          sessionctx.Context
+	// tmpDir stages the data files before uploading them, nil means the data
+	// files are uploaded while written.
+	tmpDir *utils.TempDir
+}
+
+// NewDumplingExporter returns a DumplingExporter exporting the backup in the
+// storage into the output storage.
+func NewDumplingExporter(
+	s storage.ExternalStorage, output storage.ExternalStorage, fileSize, statementSize uint64,
+) *DumplingExporter {
+	if fileSize == 0 {
+		fileSize = DefaultDumplingFileSize
+	}
+	if statementSize == 0 {
+		statementSize = DefaultDumplingStatementSize
+	}
+	return &DumplingExporter{
+		storage:       s,
+		output:        output,
+		fileSize:      fileSize,
+		statementSize: statementSize,
+		sctx:          mock.NewContext(),
+	}
+}
+
+// SetTempDir makes the data files staged in the temporary directory, and
+// uploaded once they're complete, so the output only sees the complete files.
+func (e *DumplingExporter) SetTempDir(dir *utils.TempDir) {
+	e.tmpDir = dir
+}
+
+// ExportDatabase writes the schema file of the database, i.e.
+// `{db}-schema-create.sql`.
+func (e *DumplingExporter) ExportDatabase(ctx context.Context, db *model.DBInfo) error {
+	var buf bytes.Buffer
+	buf.WriteString(dumplingHeader)
+	if err := executor.ConstructResultOfShowCreateDatabase(e.sctx, db, false, &buf); err != nil {
+		return errors.Trace(err)
+	}
+	buf.WriteString(";\n")
+	name := fmt.Sprintf("%s-schema-create.sql", db.Name.O)
+	return errors.Annotatef(e.output.Write(ctx, name, buf.Bytes()), "failed to write %s", name)
+}
+
+// ExportTableSchema writes the schema file of the table, i.e.
+// `{db}.{table}-schema.sql`.
+func (e *DumplingExporter) ExportTableSchema(ctx context.Context, tbl *utils.Table) error {
+	var buf bytes.Buffer
+	buf.WriteString(dumplingHeader)
+	if err := executor.ConstructResultOfShowCreateTable(e.sctx, tbl.Info, autoid.Allocators{}, &buf); err != nil {
+		return errors.Trace(err)
+	}
+	buf.WriteString(";\n")
+	name := fmt.Sprintf("%s.%s-schema.sql", tbl.DB.Name.O, tbl.Info.Name.O)
+	return errors.Annotatef(e.output.Write(ctx, name, buf.Bytes()), "failed to write %s", name)
+}
+
+// ExportTable writes the rows of the table into the data files, i.e.
+// `{db}.{table}.{index}.sql`, it returns the count of the rows exported. The
+// progress increases by every write CF file exported.
+func (e *DumplingExporter) ExportTable(ctx context.Context, tbl *utils.Table, updateCh glue.Progress) (int, error) {
+	t := newLogicalTable(tbl)
+	if len(t.columns) == 0 {
+		return 0, nil
+	}
+	w := &dumplingWriter{
+		output:        e.output,
+		prefix:        fmt.Sprintf("%s.%s", tbl.DB.Name.O, tbl.Info.Name.O),
+		insert:        "INSERT INTO " + utils.EncloseName(tbl.Info.Name.O) + " " + t.names + " VALUES\n",
+		fileSize:      e.fileSize,
+		statementSize: e.statementSize,
+		tmpDir:        e.tmpDir,
+	}
+	rows := 0
+	var row []byte
+	err := iterateRecords(ctx, e.storage, t, func(key, value []byte) error {
+		datums, err := decodeDatums(e.sctx, t, key, value, t.datums[:0])
+		if err != nil {
+			return errors.Trace(err)
+		}
+		t.datums = datums
+		row = appendSQLRow(row[:0], datums)
+		rows++
+		return errors.Trace(w.writeRow(ctx, row))
+	}, func(*backup.File) error {
+		updateCh.Inc()
+		return nil
+	})
+	if err == nil {
+		err = w.close(ctx)
+	}
+	if err != nil {
+		return rows, errors.Annotatef(err, "failed to export %s.%s", tbl.DB.Name, tbl.Info.Name)
+	}
+	log.Info("table exported",
+		zap.Stringer("db", tbl.DB.Name),
+		zap.Stringer("table", tbl.Info.Name),
+		zap.Int("rows", rows),
+		zap.Int("files", w.files))
+	return rows, nil
+}
+
+// WriteMetadata writes the metadata file, the snapshot of the backup is
+// recorded as the position like Dumpling does for TiDB.
+func (e *DumplingExporter) WriteMetadata(
+	ctx context.Context, backupMeta *backup.BackupMeta, startedAt, finishedAt time.Time,
+) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Started dump at: %s\n", startedAt.Format(dumplingTimeFormat))
+	fmt.Fprintf(&buf, "SHOW MASTER STATUS:\n\tLog: tidb-binlog\n\tPos: %d\n\tGTID:\n\n", backupMeta.EndVersion)
+	fmt.Fprintf(&buf, "Finished dump at: %s\n", finishedAt.Format(dumplingTimeFormat))
+	return errors.Trace(e.output.Write(ctx, DumplingMetadataFile, buf.Bytes()))
+}
+
+// dumplingWriter writes the rows of a table into the data files, a new
+// statement is started once the statement size is exceeded, and a new file is
+// started once the file size is exceeded.
+type dumplingWriter struct {
+	output        storage.ExternalStorage
+	prefix        string
+	insert        string
+	fileSize      uint64
+	statementSize uint64
+	tmpDir        *utils.TempDir
+
+	w         storage.Writer
+	files     int
+	fileBytes uint64
+	stmtBytes uint64
+}
+
+func (w *dumplingWriter) write(ctx context.Context, data []byte) error {
+	_, err := w.w.Write(ctx, data)
+	w.fileBytes += uint64(len(data))
+	return errors.Trace(err)
+}
+
+func (w *dumplingWriter) writeRow(ctx context.Context, row []byte) error {
+	if w.w == nil {
+		name := fmt.Sprintf("%s.%09d.sql", w.prefix, w.files)
+		if w.tmpDir != nil {
+			file, err := w.tmpDir.Create(name)
+			if err != nil {
+				return errors.Annotatef(err, "failed to stage %s", name)
+			}
+			w.w = &stagedWriter{output: w.output, name: name, file: file}
+		} else {
+			uploader, err := w.output.CreateUploader(ctx, name)
+			if err != nil {
+				return errors.Annotatef(err, "failed to create %s", name)
+			}
+			w.w = storage.NewUploaderWriter(uploader, int(dumplingChunkSize), storage.NoCompression)
+		}
+		w.files++
+		w.fileBytes = 0
+		if err := w.write(ctx, []byte(dumplingHeader+dumplingTimeZone)); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if w.stmtBytes == 0 {
+		if err := w.write(ctx, []byte(w.insert)); err != nil {
+			return errors.Trace(err)
+		}
+	} else {
+		if err := w.write(ctx, []byte(",\n")); err != nil {
+			return errors.Trace(err)
+		}
+		w.stmtBytes += 2
+	}
+	if err := w.write(ctx, row); err != nil {
+		return errors.Trace(err)
+	}
+	w.stmtBytes += uint64(len(row))
+	if w.stmtBytes >= w.statementSize {
+		if err := w.endStatement(ctx); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if w.fileBytes >= w.fileSize {
+		return errors.Trace(w.close(ctx))
+	}
+	return nil
+}
+
+func (w *dumplingWriter) endStatement(ctx context.Context) error {
+	if w.stmtBytes == 0 {
+		return nil
+	}
+	err := w.write(ctx, []byte(";\n"))
+	w.stmtBytes = 0
+	return errors.Trace(err)
+}
+
+// close ends the current statement and file.
+func (w *dumplingWriter) close(ctx context.Context) error {
+	if w.w == nil {
+		return nil
+	}
+	if err := w.endStatement(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	err := w.w.Close(ctx)
+	w.w = nil
+	return errors.Trace(err)
+}
+
+// stagedWriter writes the data file into the temporary directory, and uploads
+// it on Close.
+type stagedWriter struct {
+	output storage.ExternalStorage
+	name   string
+	file   *utils.TempFile
+}
+
+func (w *stagedWriter) Write(_ context.Context, p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	return n, errors.Trace(err)
+}
+
+func (w *stagedWriter) Close(ctx context.Context) error {
+	defer func() {
+		if err := w.file.Remove(); err != nil {
+			log.Warn("failed to remove the staged file", zap.String("file", w.file.Name()), zap.Error(err))
+		}
+	}()
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return errors.Trace(err)
+	}
+	uploader, err := w.output.CreateUploader(ctx, w.name)
+	if err != nil {
+		return errors.Annotatef(err, "failed to create %s", w.name)
+	}
+	writer := storage.NewUploaderWriter(uploader, int(dumplingChunkSize), storage.NoCompression)
+	buf := make([]byte, dumplingChunkSize)
+	for {
+		n, err := w.file.Read(buf)
+		if n > 0 {
+			if _, err := writer.Write(ctx, buf[:n]); err != nil {
+				return errors.Annotatef(err, "failed to upload %s", w.name)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return errors.Annotatef(writer.Close(ctx), "failed to upload %s", w.name)
+}
+
+// appendSQLRow appends the datums as a row of the VALUES clause, e.g.
+// (1,'a',NULL).
+func appendSQLRow(b []byte, datums []types.Datum) []byte {
+	b = append(b, '(')
+	for i, d := range datums {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendSQLValue(b, d)
+	}
+	return append(b, ')')
+}
+
+// appendSQLValue appends the datum as a SQL literal.
+func appendSQLValue(b []byte, d types.Datum) []byte {
+	switch d.Kind() {
+	case types.KindNull:
+		return append(b, "NULL"...)
+	case types.KindInt64:
+		return strconv.AppendInt(b, d.GetInt64(), 10)
+	case types.KindUint64:
+		return strconv.AppendUint(b, d.GetUint64(), 10)
+	case types.KindFloat32:
+		return strconv.AppendFloat(b, d.GetFloat64(), 'g', -1, 32)
+	case types.KindFloat64:
+		return strconv.AppendFloat(b, d.GetFloat64(), 'g', -1, 64)
+	case types.KindMysqlDecimal:
+		return append(b, d.GetMysqlDecimal().String()...)
+	case types.KindString, types.KindBytes:
+		return appendSQLString(b, d.GetBytes())
+	case types.KindBinaryLiteral, types.KindMysqlBit:
+		b = append(b, "x'"...)
+		b = append(b, hex.EncodeToString(d.GetBinaryLiteral())...)
+		return append(b, '\'')
+	default:
+		// e.g. time, duration, enum, set and JSON.
+		s, err := d.ToString()
+		if err != nil {
+			return append(b, "NULL"...)
+		}
+		return appendSQLString(b, []byte(s))
+	}
+}
+
+// appendSQLString appends the quoted string with the special characters
+// escaped like Dumpling.
+func appendSQLString(b []byte, s []byte) []byte {
+	b = append(b, '\'')
+	for _, c := range s {
+		switch c {
+		case 0:
+			b = append(b, '\\', '0')
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\\', '\'', '"':
+			b = append(b, '\\', c)
+		case '\032':
+			b = append(b, '\\', 'Z')
+		default:
+			b = append(b, c)
+		}
+	}
+	return append(b, '\'')
+}
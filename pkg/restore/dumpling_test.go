@@ -0,0 +1,83 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+type testDumplingSuite struct{}
+
+var _ = Suite(&testDumplingSuite{})
+
+func (*testDumplingSuite) TestAppendSQLRow(c *C) {
+	datums := []types.Datum{
+		types.NewIntDatum(-1),
+		types.NewUintDatum(2),
+		types.NewFloat64Datum(1.5),
+		types.NewStringDatum("it's\n\"a\"\\"),
+		types.NewBytesDatum([]byte{0, 0x1a}),
+		types.NewBinaryLiteralDatum(types.BinaryLiteral{0xab, 0x01}),
+		types.NewDatum(nil),
+		types.NewDecimalDatum(types.NewDecFromStringForTest("3.14")),
+	}
+	c.Assert(string(appendSQLRow(nil, datums)), Equals,
+		`(-1,2,1.5,'it\'s\n\"a\"\\','\0\Z',x'ab01',NULL,3.14)`)
+}
+
+func (*testDumplingSuite) TestDumplingWriter(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	output, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	w := &dumplingWriter{
+		output:        output,
+		prefix:        "test.t",
+		insert:        "INSERT INTO `t` (`a`) VALUES\n",
+		fileSize:      140,
+		statementSize: 8,
+	}
+	for _, row := range []string{"(1)", "(2)", "(3)", "(4)", "(5)"} {
+		c.Assert(w.writeRow(ctx, []byte(row)), IsNil)
+	}
+	c.Assert(w.close(ctx), IsNil)
+	c.Assert(w.files, Equals, 2)
+
+	header := dumplingHeader + dumplingTimeZone
+	data, err := ioutil.ReadFile(filepath.Join(dir, "test.t.000000000.sql"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, header+
+		"INSERT INTO `t` (`a`) VALUES\n(1),\n(2);\n"+
+		"INSERT INTO `t` (`a`) VALUES\n(3),\n(4);\n")
+	data, err = ioutil.ReadFile(filepath.Join(dir, "test.t.000000001.sql"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, header+"INSERT INTO `t` (`a`) VALUES\n(5);\n")
+}
+
+func (*testDumplingSuite) TestExportTableOfSST(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage("testdata")
+	c.Assert(err, IsNil)
+	dir := c.MkDir()
+	output, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	e := NewDumplingExporter(s, output, 0, 0)
+	progress := &countProgress{}
+	// The fixtures are the SST files with the zstd data blocks, see
+	// TestRestoreRowsOfSST.
+	rows, err := e.ExportTable(ctx, logicalTestTable(), progress)
+	c.Assert(err, IsNil)
+	c.Assert(rows, Equals, 3)
+	c.Assert(progress.count, Equals, int64(1))
+	data, err := ioutil.ReadFile(filepath.Join(dir, "test.t.000000000.sql"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, dumplingHeader+dumplingTimeZone+
+		"INSERT INTO `t` (`id`,`name`,`score`) VALUES\n(1,'alice',90),\n(2,'bob',NULL),\n(3,'carol',70);\n")
+}
@@ -0,0 +1,257 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// defaultFileRetryBudget is the times a file can be dispatched to workers
+// before it's put into the dead letters.
+const defaultFileRetryBudget = 3
+
+// DeadLetter is a file which still failed with a retryable error after its
+// retry budget exhausted.
+type DeadLetter struct {
+	File     *backup.File
+	Attempts int
+	Err      error
+}
+
+type fileTask struct {
+	file     *backup.File
+	attempts int
+	bo       utils.Backoffer
+}
+
+// FileScheduler dispatches files to workers pulling from a shared queue.
+//
+// A file failed with a retryable error is put back to the queue after the
+// backoff, so any healthy worker can steal it instead of stalling the worker
+// it was assigned to. Once the backoffer of a file runs out of attempts, it's
+// recorded as a dead letter and the other files keep going. A file failed with
+// a permanent error fails the whole run at once.
+type FileScheduler struct {
+	pool         *utils.WorkerPool
+	concurrency  int
+	newBackoffer func() utils.Backoffer
+
+	mu          sync.Mutex
+	deadLetters []DeadLetter
+}
+
+// NewFileScheduler creates a FileScheduler which runs at most concurrency
+// workers on the pool, the retries of each file are regulated by a backoffer
+// created by newBackoffer.
+func NewFileScheduler(pool *utils.WorkerPool, concurrency int, newBackoffer func() utils.Backoffer) *FileScheduler {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &FileScheduler{
+		pool:         pool,
+		concurrency:  concurrency,
+		newBackoffer: newBackoffer,
+	}
+}
+
+// Run imports all the files by fn, onDone is called once for each file when
+// it's done or dead. It returns the dead letters, and error if the context is
+// canceled or any file failed with a permanent error.
+func (s *FileScheduler) Run(
+	ctx context.Context,
+	files []*backup.File,
+	fn func(context.Context, *backup.File) error,
+	onDone func(*backup.File),
+) ([]DeadLetter, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	// Each file occupies at most one slot of the queue at any time,
+	// so putting a file back never blocks. The queue isn't closed while a
+	// file is backing off, since the file is still pending.
+	queue := make(chan fileTask, len(files))
+	pending := int64(len(files))
+	for _, file := range files {
+		queue <- fileTask{file: file}
+	}
+	// finish closes the queue after the last file is done, so all workers quit.
+	finish := func() {
+		if atomic.AddInt64(&pending, -1) == 0 {
+			close(queue)
+		}
+	}
+
+	// timers put the files back after their backoff.
+	var (
+		timersMu sync.Mutex
+		timers   []*time.Timer
+	)
+	defer func() {
+		timersMu.Lock()
+		defer timersMu.Unlock()
+		for _, timer := range timers {
+			timer.Stop()
+		}
+	}()
+
+	workers := s.concurrency
+	if workers > len(files) {
+		workers = len(files)
+	}
+	eg, ectx := errgroup.WithContext(ctx)
+	for i := 0; i < workers; i++ {
+		s.pool.ApplyOnErrorGroup(eg, func() error {
+			for {
+				var task fileTask
+				var ok bool
+				select {
+				case <-ectx.Done():
+					return ectx.Err()
+				case task, ok = <-queue:
+					if !ok {
+						return nil
+					}
+				}
+				task.attempts++
+				err := fn(ectx, task.file)
+				if err == nil {
+					onDone(task.file)
+					finish()
+					continue
+				}
+				if ectx.Err() != nil {
+					return ectx.Err()
+				}
+				if !isRetryableImportError(err) {
+					return errors.Annotatef(err, "failed to import file %s", task.file.GetName())
+				}
+				if task.bo == nil {
+					task.bo = s.newBackoffer()
+				}
+				delay := task.bo.NextBackoff(err)
+				if task.bo.Attempt() > 0 {
+					log.Warn("import file failed, put it back to the queue",
+						logutil.File(task.file),
+						zap.Int("attempts", task.attempts),
+						zap.Duration("backoff", delay),
+						logutil.ShortError(err))
+					// The worker moves on to the other files during the
+					// backoff, instead of holding its slot of the pool.
+					task := task
+					timersMu.Lock()
+					timers = append(timers, time.AfterFunc(delay, func() { queue <- task }))
+					timersMu.Unlock()
+					continue
+				}
+				log.Error("import file failed, retry budget exhausted",
+					logutil.File(task.file),
+					zap.Int("attempts", task.attempts),
+					zap.Error(err))
+				s.mu.Lock()
+				s.deadLetters = append(s.deadLetters, DeadLetter{File: task.file, Attempts: task.attempts, Err: err})
+				s.mu.Unlock()
+				onDone(task.file)
+				finish()
+			}
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return s.deadLetters, nil
+}
+
+// importFiles imports the files with the work-stealing scheduler, the files
+// exhausted their retry budget are diverted to the table retry if it's
+// enabled.
+func (rc *Client) importFiles(
+	ctx context.Context,
+	files []*backup.File,
+	rewriteRules *RewriteRules,
+	onDone func(*backup.File),
+) error {
+	deadLetters, err := rc.scheduleFiles(ctx, files, rewriteRules, onDone)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(deadLetters) == 0 || rc.markFailedFiles(deadLetters) {
+		return nil
+	}
+	names := make([]string, 0, len(deadLetters))
+	for _, dl := range deadLetters {
+		summary.CollectFailureUnit(dl.File.GetName(), dl.Err)
+		names = append(names, dl.File.GetName())
+	}
+	return errors.Annotatef(berrors.ErrRestoreFileRetryExhausted,
+		"%d files failed: [%s], the first error: %v",
+		len(deadLetters), strings.Join(names, ", "), deadLetters[0].Err)
+}
+
+// scheduleFiles imports the files with the work-stealing scheduler, it
+// returns the files exhausted their retry budget.
+func (rc *Client) scheduleFiles(
+	ctx context.Context,
+	files []*backup.File,
+	rewriteRules *RewriteRules,
+	onDone func(*backup.File),
+) ([]DeadLetter, error) {
+	concurrency := uint(rc.liveTuning.Concurrency(uint32(rc.concurrency)))
+	pool := rc.workerPool
+	if concurrency > rc.concurrency {
+		// The pool of the client is sized by the concurrency it starts with,
+		// the raised concurrency takes a pool of its own for the batch.
+		pool = utils.NewWorkerPool(concurrency, "file")
+	}
+	files = rc.skipIngestedFiles(files, rewriteRules, onDone)
+	scheduler := NewFileScheduler(pool, int(concurrency), func() utils.Backoffer {
+		return newFileBackoffer(rc.fileRetryBudget)
+	})
+	deadLetters, err := scheduler.Run(ctx, files, func(c context.Context, file *backup.File) error {
+		err := rc.fileImporter.Import(c, file, rewriteRules)
+		if err == nil {
+			rc.ingestCache.record(c, ingestedFileKey(file, rewriteRules))
+		}
+		return err
+	}, onDone)
+	return deadLetters, errors.Trace(err)
+}
+
+// skipIngestedFiles returns the files not ingested by the previous runs, the
+// ingested ones are done at once.
+func (rc *Client) skipIngestedFiles(
+	files []*backup.File,
+	rewriteRules *RewriteRules,
+	onDone func(*backup.File),
+) []*backup.File {
+	if rc.ingestCache.Len() == 0 {
+		return files
+	}
+	remaining := make([]*backup.File, 0, len(files))
+	for _, file := range files {
+		if rc.ingestCache.ingested(ingestedFileKey(file, rewriteRules)) {
+			onDone(file)
+			continue
+		}
+		remaining = append(remaining, file)
+	}
+	if skipped := len(files) - len(remaining); skipped > 0 {
+		log.Info("skip the files ingested by the previous runs", zap.Int("files", skipped))
+		summary.CollectInt("skipped ingested files", skipped)
+	}
+	return remaining
+}
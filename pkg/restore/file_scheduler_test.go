@@ -0,0 +1,110 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+var _ = Suite(&testFileSchedulerSuite{})
+
+func newTestFileBackoffer() utils.Backoffer {
+	return restore.NewBackoffer(3, time.Millisecond, time.Millisecond)
+}
+
+type testFileSchedulerSuite struct{}
+
+func (s *testFileSchedulerSuite) TestDeadLetter(c *C) {
+	files := []*backup.File{{Name: "1.sst"}, {Name: "poisoned.sst"}, {Name: "2.sst"}, {Name: "3.sst"}}
+	scheduler := restore.NewFileScheduler(utils.NewWorkerPool(2, "test"), 2, newTestFileBackoffer)
+
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	done := make(map[string]int)
+	deadLetters, err := scheduler.Run(context.Background(), files, func(_ context.Context, f *backup.File) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[f.Name]++
+		if f.Name == "poisoned.sst" || (f.Name == "2.sst" && attempts[f.Name] == 1) {
+			return errors.Annotate(berrors.ErrKVIngestFailed, "injected")
+		}
+		return nil
+	}, func(f *backup.File) {
+		mu.Lock()
+		defer mu.Unlock()
+		done[f.Name]++
+	})
+	c.Assert(err, IsNil)
+	c.Assert(deadLetters, HasLen, 1)
+	c.Assert(deadLetters[0].File.Name, Equals, "poisoned.sst")
+	c.Assert(deadLetters[0].Attempts, Equals, 3)
+	c.Assert(attempts["poisoned.sst"], Equals, 3)
+	c.Assert(attempts["2.sst"], Equals, 2)
+	for _, f := range files {
+		c.Assert(done[f.Name], Equals, 1)
+	}
+}
+
+func (s *testFileSchedulerSuite) TestFailFast(c *C) {
+	files := []*backup.File{{Name: "1.sst"}, {Name: "corrupted.sst"}}
+	scheduler := restore.NewFileScheduler(utils.NewWorkerPool(1, "test"), 1, newTestFileBackoffer)
+
+	attempts := 0
+	_, err := scheduler.Run(context.Background(), files, func(_ context.Context, f *backup.File) error {
+		if f.Name == "corrupted.sst" {
+			attempts++
+			return errors.Annotate(berrors.ErrKVUnknown, "injected")
+		}
+		return nil
+	}, func(*backup.File) {})
+	c.Assert(errors.Cause(err), Equals, berrors.ErrKVUnknown)
+	c.Assert(attempts, Equals, 1)
+}
+
+func (s *testFileSchedulerSuite) TestCanceled(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	files := []*backup.File{{Name: "1.sst"}, {Name: "2.sst"}}
+	scheduler := restore.NewFileScheduler(utils.NewWorkerPool(1, "test"), 1, newTestFileBackoffer)
+	_, err := scheduler.Run(ctx, files, func(context.Context, *backup.File) error {
+		cancel()
+		return context.Canceled
+	}, func(*backup.File) {})
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+}
+
+func (s *testFileSchedulerSuite) TestBackoffReleasesWorker(c *C) {
+	const backoff = 500 * time.Millisecond
+	files := []*backup.File{{Name: "flaky.sst"}, {Name: "1.sst"}}
+	scheduler := restore.NewFileScheduler(utils.NewWorkerPool(1, "test"), 1, func() utils.Backoffer {
+		return restore.NewBackoffer(3, backoff, backoff)
+	})
+
+	start := time.Now()
+	var elapsed time.Duration
+	attempts := 0
+	_, err := scheduler.Run(context.Background(), files, func(_ context.Context, f *backup.File) error {
+		if f.Name == "flaky.sst" {
+			attempts++
+			if attempts == 1 {
+				return errors.Annotate(berrors.ErrKVIngestFailed, "injected")
+			}
+			return nil
+		}
+		elapsed = time.Since(start)
+		return nil
+	}, func(*backup.File) {})
+	c.Assert(err, IsNil)
+	c.Assert(attempts, Equals, 2)
+	// The only worker imports the other file during the backoff.
+	c.Assert(elapsed, Less, backoff)
+}
@@ -12,9 +12,12 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/pingcap/errors"
+	"github.com/pingcap/failpoint"
 	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/errorpb"
 	"github.com/pingcap/kvproto/pkg/import_sstpb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/log"
 	"github.com/tikv/pd/pkg/codec"
 	"go.uber.org/multierr"
@@ -25,6 +28,7 @@ import (
 	"google.golang.org/grpc/keepalive"
 
 	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/hook"
 	"github.com/pingcap/br/pkg/logutil"
 	"github.com/pingcap/br/pkg/summary"
 	"github.com/pingcap/br/pkg/utils"
@@ -36,6 +40,20 @@ const (
 	gRPCBackOffMaxDelay       = 3 * time.Second
 )
 
+// tikvColumnFamilies are the column families which TiKV accepts SST files for.
+var tikvColumnFamilies = []string{"default", "write", "lock"}
+
+// ValidateColumnFamily checks whether the column family exists in TiKV.
+func ValidateColumnFamily(cf string) error {
+	for _, name := range tikvColumnFamilies {
+		if cf == name {
+			return nil
+		}
+	}
+	return errors.Annotatef(berrors.ErrInvalidArgument,
+		"column family %s doesn't exist, must be one of %v", cf, tikvColumnFamilies)
+}
+
 // ImporterClient is used to import a file to TiKV.
 type ImporterClient interface {
 	DownloadSST(
@@ -66,18 +84,28 @@ type importClient struct {
 	mu         sync.Mutex
 	metaClient SplitClient
 	clients    map[uint64]import_sstpb.ImportSSTClient
+	conns      map[uint64]*grpc.ClientConn
 	tlsConf    *tls.Config
 
 	keepaliveConf keepalive.ClientParameters
+	dialOpts      []grpc.DialOption
 }
 
 // NewImportClient returns a new ImporterClient.
-func NewImportClient(metaClient SplitClient, tlsConf *tls.Config, keepaliveConf keepalive.ClientParameters) ImporterClient {
+// The extra dial options are used when connecting TiKV.
+func NewImportClient(
+	metaClient SplitClient,
+	tlsConf *tls.Config,
+	keepaliveConf keepalive.ClientParameters,
+	dialOpts ...grpc.DialOption,
+) ImporterClient {
 	return &importClient{
 		metaClient:    metaClient,
 		clients:       make(map[uint64]import_sstpb.ImportSSTClient),
+		conns:         make(map[uint64]*grpc.ClientConn),
 		tlsConf:       tlsConf,
 		keepaliveConf: keepaliveConf,
+		dialOpts:      dialOpts,
 	}
 }
 
@@ -139,20 +167,22 @@ func (ic *importClient) GetImportClient(
 	if addr == "" {
 		addr = store.GetAddress()
 	}
+	addr = utils.MapStoreAddr(addr)
 	bfConf := backoff.DefaultConfig
 	bfConf.MaxDelay = gRPCBackOffMaxDelay
-	conn, err := grpc.DialContext(
-		ctx,
-		addr,
+	opts := append([]grpc.DialOption{
 		opt,
 		grpc.WithConnectParams(grpc.ConnectParams{Backoff: bfConf}),
 		grpc.WithKeepaliveParams(ic.keepaliveConf),
-	)
+	}, ic.dialOpts...)
+	opts = append(opts, utils.GRPCTaskDialOptions()...)
+	conn, err := grpc.DialContext(ctx, addr, opts...)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 	client = import_sstpb.NewImportSSTClient(conn)
 	ic.clients[storeID] = client
+	ic.conns[storeID] = conn
 	return client, errors.Trace(err)
 }
 
@@ -161,11 +191,24 @@ type FileImporter struct {
 	metaClient   SplitClient
 	importClient ImporterClient
 	backend      *backup.StorageBackend
-	rateLimit    uint64
 
 	isRawKvMode bool
 	rawStartKey []byte
 	rawEndKey   []byte
+	// rawTargetCF is the column family the raw kv files are ingested into,
+	// empty means the same column family as the backup.
+	rawTargetCF string
+	// capabilities is the probed capability matrix of the stores.
+	capabilities StoreCapabilities
+	// regionCache caches the regions scanned for the files.
+	regionCache *regionCache
+	// ingestTimeout is the timeout of the download and ingest RPCs.
+	ingestTimeout IngestTimeout
+	// ingestLoad tracks the bytes downloaded into the stores, nil means no
+	// tracking.
+	ingestLoad *StoreIngestLoad
+	// inflight caps the download and ingest requests in flight to a store.
+	inflight *storeInflightLimiter
 }
 
 // NewFileImporter returns a new file importClient.
@@ -174,15 +217,46 @@ func NewFileImporter(
 	importClient ImporterClient,
 	backend *backup.StorageBackend,
 	isRawKvMode bool,
-	rateLimit uint64,
 ) FileImporter {
 	return FileImporter{
 		metaClient:   metaClient,
 		backend:      backend,
 		importClient: importClient,
 		isRawKvMode:  isRawKvMode,
-		rateLimit:    rateLimit,
+		regionCache:  newRegionCache(DefaultRegionCacheCapacity),
+		inflight:     newStoreInflightLimiter(0),
+	}
+}
+
+// SetPerStoreInflight sets the max count of the download and ingest requests
+// in flight to a store, 0 means no limit.
+func (importer *FileImporter) SetPerStoreInflight(limit uint) {
+	importer.inflight = newStoreInflightLimiter(limit)
+}
+
+// SetIngestTimeout sets the timeout of the download and ingest RPCs.
+func (importer *FileImporter) SetIngestTimeout(timeout IngestTimeout) {
+	importer.ingestTimeout = timeout
+}
+
+// SetRegionCacheCapacity sets the max count of the cached regions, 0 disables
+// the region cache.
+func (importer *FileImporter) SetRegionCacheCapacity(capacity int) {
+	importer.regionCache = newRegionCache(capacity)
+}
+
+// scanRegions returns the regions covering [startKey, endKey), from the region
+// cache if possible.
+func (importer *FileImporter) scanRegions(ctx context.Context, startKey, endKey []byte) ([]*RegionInfo, error) {
+	if regions := importer.regionCache.lookup(startKey, endKey); regions != nil {
+		return regions, nil
+	}
+	regions, err := PaginateScanRegion(ctx, importer.metaClient, startKey, endKey, scanRegionPaginationLimit)
+	if err != nil {
+		return nil, errors.Trace(err)
 	}
+	importer.regionCache.insert(regions)
+	return regions, nil
 }
 
 // SetRawRange sets the range to be restored in raw kv mode.
@@ -195,6 +269,18 @@ func (importer *FileImporter) SetRawRange(startKey, endKey []byte) error {
 	return nil
 }
 
+// SetRawTargetCF sets the column family that raw kv files are ingested into.
+func (importer *FileImporter) SetRawTargetCF(cf string) error {
+	if !importer.isRawKvMode {
+		return errors.Annotate(berrors.ErrRestoreModeMismatch, "file importer is not in raw kv mode")
+	}
+	if err := ValidateColumnFamily(cf); err != nil {
+		return errors.Trace(err)
+	}
+	importer.rawTargetCF = cf
+	return nil
+}
+
 // Import tries to import a file.
 // All rules must contain encoded keys.
 func (importer *FileImporter) Import(
@@ -220,12 +306,17 @@ func (importer *FileImporter) Import(
 		logutil.Key("startKey", startKey),
 		logutil.Key("endKey", endKey))
 
+	attempt := 0
 	err = utils.WithRetry(ctx, func() error {
+		if attempt > 0 {
+			// The cached regions may be stale since the last attempt failed.
+			importer.regionCache.invalidateRange(startKey, endKey)
+		}
+		attempt++
 		tctx, cancel := context.WithTimeout(ctx, importScanRegionTime)
 		defer cancel()
 		// Scan regions covered by the file range
-		regionInfos, errScanRegion := PaginateScanRegion(
-			tctx, importer.metaClient, startKey, endKey, scanRegionPaginationLimit)
+		regionInfos, errScanRegion := importer.scanRegions(tctx, startKey, endKey)
 		if errScanRegion != nil {
 			return errors.Trace(errScanRegion)
 		}
@@ -235,14 +326,22 @@ func (importer *FileImporter) Import(
 	regionLoop:
 		for _, regionInfo := range regionInfos {
 			info := regionInfo
+			if err := importer.checkRegionStores(info.Region); err != nil {
+				return errors.Trace(err)
+			}
 			// Try to download file.
 			var downloadMeta *import_sstpb.SSTMeta
+			failures := make(storeFailures)
 			errDownload := utils.WithRetry(ctx, func() error {
 				var e error
 				if importer.isRawKvMode || rewriteRules == nil {
-					downloadMeta, e = importer.downloadRawKVSST(ctx, info, file, rewriteRules)
+					downloadMeta, e = importer.downloadRawKVSST(ctx, info, file, rewriteRules, failures)
 				} else {
-					downloadMeta, e = importer.downloadSST(ctx, info, file, rewriteRules)
+					downloadMeta, e = importer.downloadSST(ctx, info, file, rewriteRules, failures)
+				}
+				if e != nil {
+					summary.CollectRetry(summary.RetryDownload, e)
+					info = importer.refreshFailedRegion(ctx, info, failures)
 				}
 				return e
 			}, newDownloadSSTBackoffer())
@@ -269,7 +368,14 @@ func (importer *FileImporter) Import(
 				return errors.Trace(errDownload)
 			}
 
-			ingestResp, errIngest := importer.ingestSST(ctx, downloadMeta, info)
+			ingestResp, errIngest := importer.ingestSST(ctx, file, downloadMeta, info)
+			failpoint.Inject("ingest-epoch-not-match", func() {
+				log.Debug("failpoint ingest-epoch-not-match injected.")
+				ingestResp = &import_sstpb.IngestResponse{
+					Error: &errorpb.Error{EpochNotMatch: &errorpb.EpochNotMatch{}},
+				}
+				errIngest = nil
+			})
 		ingestRetry:
 			for errIngest == nil {
 				errPb := ingestResp.GetError()
@@ -303,12 +409,14 @@ func (importer *FileImporter) Import(
 					log.Debug("ingest sst returns not leader error, retry it",
 						logutil.Region(info.Region),
 						zap.Stringer("newLeader", newInfo.Leader))
+					// The leader of the cached region is stale.
+					importer.invalidateStaleRegion(info, berrors.ErrKVNotLeader)
 
 					if !checkRegionEpoch(newInfo, info) {
 						errIngest = errors.Trace(berrors.ErrKVEpochNotMatch)
 						break ingestRetry
 					}
-					ingestResp, errIngest = importer.ingestSST(ctx, downloadMeta, newInfo)
+					ingestResp, errIngest = importer.ingestSST(ctx, file, downloadMeta, newInfo)
 				case errPb.EpochNotMatch != nil:
 					// TODO handle epoch not match error
 					//      1. retry download if needed
@@ -326,6 +434,8 @@ func (importer *FileImporter) Import(
 			}
 
 			if errIngest != nil {
+				importer.invalidateStaleRegion(info, errIngest)
+				summary.CollectRetry(summary.RetryIngest, errIngest)
 				log.Error("ingest file failed",
 					logutil.File(file),
 					logutil.SSTMeta(downloadMeta),
@@ -341,9 +451,19 @@ func (importer *FileImporter) Import(
 	return errors.Trace(err)
 }
 
-func (importer *FileImporter) setDownloadSpeedLimit(ctx context.Context, storeID uint64) error {
+// invalidateStaleRegion removes the region from the region cache if the error
+// tells it's stale, e.g. split, merged or its leader moved, so neither the
+// retry nor the other files use it again.
+func (importer *FileImporter) invalidateStaleRegion(info *RegionInfo, err error) {
+	switch errors.Cause(err) { // nolint:errorlint
+	case berrors.ErrKVEpochNotMatch, berrors.ErrKVKeyNotInRegion, berrors.ErrKVNotLeader:
+		importer.regionCache.invalidateRange(info.Region.GetStartKey(), info.Region.GetEndKey())
+	}
+}
+
+func (importer *FileImporter) setDownloadSpeedLimit(ctx context.Context, storeID, limit uint64) error {
 	req := &import_sstpb.SetDownloadSpeedLimitRequest{
-		SpeedLimit: importer.rateLimit,
+		SpeedLimit: limit,
 	}
 	_, err := importer.importClient.SetDownloadSpeedLimit(ctx, storeID, req)
 	return errors.Trace(err)
@@ -354,6 +474,7 @@ func (importer *FileImporter) downloadSST(
 	regionInfo *RegionInfo,
 	file *backup.File,
 	rewriteRules *RewriteRules,
+	failures storeFailures,
 ) (*import_sstpb.SSTMeta, error) {
 	uid := uuid.New()
 	id := uid[:]
@@ -388,7 +509,8 @@ func (importer *FileImporter) downloadSST(
 	)
 	var resp *import_sstpb.DownloadResponse
 	for _, peer := range regionInfo.Region.GetPeers() {
-		resp, err = importer.importClient.DownloadSST(ctx, peer.GetStoreId(), req)
+		resp, err = importer.downloadFromPeer(ctx, peer, file, req)
+		failures.record(peer.GetStoreId(), err == nil && resp.GetError() == nil)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
@@ -404,17 +526,115 @@ func (importer *FileImporter) downloadSST(
 	return &sstMeta, nil
 }
 
+func (importer *FileImporter) downloadFromPeer(
+	ctx context.Context,
+	peer *metapb.Peer,
+	file *backup.File,
+	req *import_sstpb.DownloadRequest,
+) (*import_sstpb.DownloadResponse, error) {
+	release, err := importer.inflight.acquire(ctx, peer.GetStoreId(), inflightDownload)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer release()
+	dctx, cancel := importer.ingestTimeout.forFile(ctx, file)
+	defer cancel()
+	resp, err := importer.importClient.DownloadSST(dctx, peer.GetStoreId(), req)
+	if err == nil && resp.GetError() == nil {
+		importer.ingestLoad.add(peer.GetStoreId(), file.GetTotalBytes())
+	}
+	return resp, errors.Trace(err)
+}
+
+// downloadRefreshThreshold is the consecutive download failures of a store
+// before the region is fetched again from PD.
+const downloadRefreshThreshold = 2
+
+// storeFailures counts the consecutive download failures of the stores of a
+// region.
+type storeFailures map[uint64]int
+
+func (f storeFailures) record(storeID uint64, success bool) {
+	if success {
+		delete(f, storeID)
+	} else {
+		f[storeID]++
+	}
+}
+
+// failingStore returns the store failed at least downloadRefreshThreshold
+// times in a row, 0 if there is none.
+func (f storeFailures) failingStore() uint64 {
+	for storeID, n := range f {
+		if n >= downloadRefreshThreshold {
+			return storeID
+		}
+	}
+	return 0
+}
+
+// refreshFailedRegion fetches the region again from PD when a store keeps
+// failing to download, since PD may have moved the peer away from the broken
+// store (e.g. disk full) or transferred the leader. Every peer must download
+// the file to ingest it, so the download can't skip the store, and retrying
+// the stale peers only exhausts the retries. The region is kept if it's split
+// or merged, whose files are reassigned once the ingest fails by the epoch.
+func (importer *FileImporter) refreshFailedRegion(
+	ctx context.Context,
+	info *RegionInfo,
+	failures storeFailures,
+) *RegionInfo {
+	storeID := failures.failingStore()
+	if storeID == 0 {
+		return info
+	}
+	newInfo, err := importer.metaClient.GetRegion(ctx, info.Region.GetStartKey())
+	if err != nil || newInfo == nil {
+		log.Warn("failed to refresh the region of the failing store",
+			logutil.Region(info.Region), zap.Uint64("store", storeID), zap.Error(err))
+		return info
+	}
+	if !peersChanged(info, newInfo) {
+		return info
+	}
+	log.Info("refresh the region of the failing store",
+		logutil.Region(info.Region),
+		zap.Uint64("store", storeID),
+		zap.Uint64("new-conf-ver", newInfo.Region.GetRegionEpoch().GetConfVer()),
+		logutil.Leader(newInfo.Leader))
+	importer.regionCache.invalidateRange(info.Region.GetStartKey(), info.Region.GetEndKey())
+	for id := range failures {
+		delete(failures, id)
+	}
+	return newInfo
+}
+
+// peersChanged returns whether the region is the same range with other peers
+// or another leader.
+func peersChanged(old, new *RegionInfo) bool {
+	if new.Region.GetId() != old.Region.GetId() ||
+		new.Region.GetRegionEpoch().GetVersion() != old.Region.GetRegionEpoch().GetVersion() {
+		return false
+	}
+	return new.Region.GetRegionEpoch().GetConfVer() != old.Region.GetRegionEpoch().GetConfVer() ||
+		new.Leader.GetStoreId() != old.Leader.GetStoreId()
+}
+
 func (importer *FileImporter) downloadRawKVSST(
 	ctx context.Context,
 	regionInfo *RegionInfo,
 	file *backup.File,
 	rewriteRules *RewriteRules,
+	failures storeFailures,
 ) (*import_sstpb.SSTMeta, error) {
 	uid := uuid.New()
 	id := uid[:]
 	// Empty rule
 	var rule import_sstpb.RewriteRule
 	sstMeta := GetSSTMetaFromFile(id, file, regionInfo.Region, &rule)
+	if importer.rawTargetCF != "" {
+		sstMeta.CfName = importer.rawTargetCF
+	}
 	// Cut the SST file's range to fit in the restoring range.
 	if bytes.Compare(importer.rawStartKey, sstMeta.Range.GetStart()) > 0 {
 		sstMeta.Range.Start = importer.rawStartKey
@@ -439,7 +659,8 @@ func (importer *FileImporter) downloadRawKVSST(
 	var err error
 	var resp *import_sstpb.DownloadResponse
 	for _, peer := range regionInfo.Region.GetPeers() {
-		resp, err = importer.importClient.DownloadSST(ctx, peer.GetStoreId(), req)
+		resp, err = importer.downloadFromPeer(ctx, peer, file, req)
+		failures.record(peer.GetStoreId(), err == nil && resp.GetError() == nil)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
@@ -457,6 +678,7 @@ func (importer *FileImporter) downloadRawKVSST(
 
 func (importer *FileImporter) ingestSST(
 	ctx context.Context,
+	file *backup.File,
 	sstMeta *import_sstpb.SSTMeta,
 	regionInfo *RegionInfo,
 ) (*import_sstpb.IngestResponse, error) {
@@ -474,7 +696,17 @@ func (importer *FileImporter) ingestSST(
 		Sst:     sstMeta,
 	}
 	log.Debug("ingest SST", logutil.SSTMeta(sstMeta), logutil.Leader(leader))
-	resp, err := importer.importClient.IngestSST(ctx, leader.GetStoreId(), req)
+	if err := hook.BeforeIngest(ctx, leader.GetStoreId(), sstMeta); err != nil {
+		return nil, errors.Trace(err)
+	}
+	release, err := importer.inflight.acquire(ctx, leader.GetStoreId(), inflightIngest)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer release()
+	ictx, cancel := importer.ingestTimeout.forFile(ctx, file)
+	defer cancel()
+	resp, err := importer.importClient.IngestSST(ictx, leader.GetStoreId(), req)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -0,0 +1,112 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	// DefaultImportBackend is the backend ingesting the files into TiKV
+	// directly.
+	DefaultImportBackend = "tikv"
+	// SQLImportBackend is the backend restoring the tables through the SQL
+	// interface only, see LogicalRestorer.
+	SQLImportBackend = "sql"
+)
+
+// ImportBackend restores the tables in the backup into the target cluster
+// without accessing its PD or TiKV, e.g. through the SQL interface or the
+// import API of a managed service, so the same backup serves both the
+// self-hosted and the managed clusters. The default backend isn't an
+// ImportBackend, it's the split, scatter and ingest of the restore client.
+type ImportBackend interface {
+	// CreateTable creates the database and the table if not exists.
+	CreateTable(ctx context.Context, tbl *utils.Table) error
+	// ImportTable imports the data of the table in the backup files, the
+	// progress increases by every write CF file imported. It must be
+	// goroutine-safe.
+	ImportTable(ctx context.Context, tbl *utils.Table, updateCh glue.Progress) error
+	// Close releases the resources of the backend after restore.
+	Close() error
+}
+
+// ImportBackendFactory creates an import backend, the backend reads the
+// files from the storage backend of the backup.
+type ImportBackendFactory func(ctx context.Context, storage *backup.StorageBackend) (ImportBackend, error)
+
+var importBackends = struct {
+	mu        sync.RWMutex
+	factories map[string]ImportBackendFactory
+}{factories: make(map[string]ImportBackendFactory)}
+
+// RegisterImportBackend registers the import backend by the name, e.g. in the
+// `init` of the applications embedding BR. It panics if the name is taken.
+func RegisterImportBackend(name string, factory ImportBackendFactory) {
+	importBackends.mu.Lock()
+	defer importBackends.mu.Unlock()
+	name = strings.ToLower(name)
+	if _, ok := importBackends.factories[name]; ok || isBuiltinImportBackend(name) {
+		panic("import backend " + name + " is registered twice")
+	}
+	importBackends.factories[name] = factory
+}
+
+// ImportBackendNames returns the names of all the import backends.
+func ImportBackendNames() []string {
+	importBackends.mu.RLock()
+	defer importBackends.mu.RUnlock()
+	names := make([]string, 0, len(importBackends.factories)+2)
+	names = append(names, DefaultImportBackend, SQLImportBackend)
+	for name := range importBackends.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names[2:])
+	return names
+}
+
+func isBuiltinImportBackend(name string) bool {
+	return name == DefaultImportBackend || name == SQLImportBackend
+}
+
+// CheckImportBackend checks whether the import backend is registered.
+func CheckImportBackend(name string) error {
+	name = strings.ToLower(name)
+	if isBuiltinImportBackend(name) {
+		return nil
+	}
+	importBackends.mu.RLock()
+	_, ok := importBackends.factories[name]
+	importBackends.mu.RUnlock()
+	if !ok {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"unknown import backend %s, must be one of %s", name, strings.Join(ImportBackendNames(), "|"))
+	}
+	return nil
+}
+
+// NewImportBackend creates the registered import backend of the name, the
+// built-in backends are created by their own constructors.
+func NewImportBackend(ctx context.Context, name string, storage *backup.StorageBackend) (ImportBackend, error) {
+	importBackends.mu.RLock()
+	factory, ok := importBackends.factories[strings.ToLower(name)]
+	importBackends.mu.RUnlock()
+	if !ok {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "import backend %s isn't registered", name)
+	}
+	backend, err := factory(ctx, storage)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to create the import backend %s", name)
+	}
+	return backend, nil
+}
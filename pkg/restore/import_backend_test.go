@@ -0,0 +1,66 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+type testImportBackendSuite struct{}
+
+var _ = Suite(&testImportBackendSuite{})
+
+type fakeImportBackend struct {
+	storage *backup.StorageBackend
+	closed  bool
+}
+
+func (b *fakeImportBackend) CreateTable(ctx context.Context, tbl *utils.Table) error {
+	return nil
+}
+
+func (b *fakeImportBackend) ImportTable(ctx context.Context, tbl *utils.Table, updateCh glue.Progress) error {
+	return nil
+}
+
+func (b *fakeImportBackend) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (*testImportBackendSuite) TestImportBackend(c *C) {
+	var created *fakeImportBackend
+	RegisterImportBackend("Fake-Cloud", func(ctx context.Context, storage *backup.StorageBackend) (ImportBackend, error) {
+		created = &fakeImportBackend{storage: storage}
+		return created, nil
+	})
+	c.Assert(func() {
+		RegisterImportBackend("fake-cloud", nil)
+	}, PanicMatches, ".*registered twice")
+	c.Assert(func() {
+		RegisterImportBackend(SQLImportBackend, nil)
+	}, PanicMatches, ".*registered twice")
+
+	c.Assert(ImportBackendNames(), DeepEquals, []string{DefaultImportBackend, SQLImportBackend, "fake-cloud"})
+	c.Assert(CheckImportBackend("TiKV"), IsNil)
+	c.Assert(CheckImportBackend("SQL"), IsNil)
+	c.Assert(CheckImportBackend("fake-cloud"), IsNil)
+	c.Assert(CheckImportBackend("unknown"), ErrorMatches, ".*unknown import backend unknown.*")
+
+	// The built-in backends are created by their own constructors.
+	_, err := NewImportBackend(context.Background(), SQLImportBackend, nil)
+	c.Assert(err, ErrorMatches, ".*import backend sql isn't registered.*")
+
+	storage := &backup.StorageBackend{}
+	backend, err := NewImportBackend(context.Background(), "FAKE-CLOUD", storage)
+	c.Assert(err, IsNil)
+	c.Assert(created.storage, Equals, storage)
+	c.Assert(backend.Close(), IsNil)
+	c.Assert(created.closed, IsTrue)
+}
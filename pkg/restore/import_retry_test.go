@@ -0,0 +1,84 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+type testImportRetrySuite struct{}
+
+var _ = Suite(&testImportRetrySuite{})
+
+// regionClient returns the region from GetRegion.
+type regionClient struct {
+	SplitClient
+	region *RegionInfo
+}
+
+func (c *regionClient) GetRegion(ctx context.Context, key []byte) (*RegionInfo, error) {
+	return c.region, nil
+}
+
+func (s *testImportRetrySuite) TestRefreshFailedRegion(c *C) {
+	region := func(confVer, version uint64, stores ...uint64) *RegionInfo {
+		peers := make([]*metapb.Peer, 0, len(stores))
+		for _, id := range stores {
+			peers = append(peers, &metapb.Peer{Id: id * 10, StoreId: id})
+		}
+		return &RegionInfo{
+			Region: &metapb.Region{
+				Id:          1,
+				RegionEpoch: &metapb.RegionEpoch{ConfVer: confVer, Version: version},
+				Peers:       peers,
+			},
+			Leader: peers[0],
+		}
+	}
+	old := region(1, 1, 1, 2, 3)
+	client := &regionClient{region: region(2, 1, 1, 2, 4)}
+	importer := NewFileImporter(client, nil, nil, false)
+	ctx := context.Background()
+
+	// The region isn't refreshed until a store fails repeatedly.
+	failures := make(storeFailures)
+	failures.record(3, false)
+	failures.record(1, true)
+	c.Assert(importer.refreshFailedRegion(ctx, old, failures), Equals, old)
+	failures.record(3, false)
+	c.Assert(importer.refreshFailedRegion(ctx, old, failures), Equals, client.region)
+	c.Assert(failures, HasLen, 0)
+
+	// The split or merged region is kept.
+	failures.record(3, false)
+	failures.record(3, false)
+	client.region = region(2, 2, 1, 2, 4)
+	c.Assert(importer.refreshFailedRegion(ctx, old, failures), Equals, old)
+	// So is the region without changes.
+	client.region = region(1, 1, 1, 2, 3)
+	c.Assert(importer.refreshFailedRegion(ctx, old, failures), Equals, old)
+	c.Assert(failures.failingStore(), Equals, uint64(3))
+}
+
+func (s *testImportRetrySuite) TestInvalidateStaleRegion(c *C) {
+	region := &RegionInfo{
+		Region: &metapb.Region{Id: 1, StartKey: []byte("a"), EndKey: []byte("c")},
+		Leader: &metapb.Peer{Id: 1, StoreId: 1},
+	}
+	importer := NewFileImporter(nil, nil, nil, false)
+	importer.regionCache.insert([]*RegionInfo{region})
+
+	// The region isn't stale for the other errors.
+	importer.invalidateStaleRegion(region, errors.Annotate(berrors.ErrKVIngestFailed, "server is busy"))
+	c.Assert(importer.regionCache.lookup([]byte("a"), []byte("b")), HasLen, 1)
+
+	importer.invalidateStaleRegion(region, errors.Trace(berrors.ErrKVEpochNotMatch))
+	c.Assert(importer.regionCache.lookup([]byte("a"), []byte("b")), IsNil)
+	c.Assert(isRetryableImportError(berrors.ErrKVKeyNotInRegion), IsTrue)
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/types"
+	"github.com/pingcap/tidb/domain"
+	"github.com/pingcap/tidb/tablecodec"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// IndexRestoreMode controls whether the rows and the indexes are restored.
+type IndexRestoreMode int
+
+const (
+	// RestoreRowsAndIndexes restores both the rows and the indexes.
+	RestoreRowsAndIndexes IndexRestoreMode = iota
+	// RestoreSkipIndex restores the rows only, and rebuilds the secondary
+	// indexes by ADD INDEX afterwards.
+	RestoreSkipIndex
+	// RestoreIndexOnly restores the indexes only, the rows should have been
+	// restored to the tables.
+	RestoreIndexOnly
+)
+
+// rebuildableIndex checks whether the index can be rebuilt by ADD INDEX.
+// The primary key and the expression indexes are always restored from the
+// backup files.
+func rebuildableIndex(table *model.TableInfo, index *model.IndexInfo) bool {
+	if index.Primary || index.State != model.StatePublic {
+		return false
+	}
+	for _, col := range index.Columns {
+		if col.Offset >= len(table.Columns) || table.Columns[col.Offset].Hidden {
+			return false
+		}
+	}
+	return true
+}
+
+func findIndexByID(table *model.TableInfo, indexID int64) *model.IndexInfo {
+	for _, index := range table.Indices {
+		if index.ID == indexID {
+			return index
+		}
+	}
+	return nil
+}
+
+// stripRebuildableIndexes returns a copy of the table without the indexes
+// which can be rebuilt by ADD INDEX.
+func stripRebuildableIndexes(table *model.TableInfo) *model.TableInfo {
+	stripped := table.Clone()
+	stripped.Indices = make([]*model.IndexInfo, 0, len(table.Indices))
+	for _, index := range table.Indices {
+		if !rebuildableIndex(table, index) {
+			stripped.Indices = append(stripped.Indices, index)
+		}
+	}
+	return stripped
+}
+
+// FilterFilesByIndexMode filters out the files which shouldn't be restored in
+// the mode.
+func FilterFilesByIndexMode(
+	files []*backup.File,
+	tables []*utils.Table,
+	mode IndexRestoreMode,
+) []*backup.File {
+	if mode == RestoreRowsAndIndexes {
+		return files
+	}
+	tableInfos := make(map[int64]*model.TableInfo)
+	for _, table := range tables {
+		tableInfos[table.Info.ID] = table.Info
+		if table.Info.Partition != nil {
+			for _, def := range table.Info.Partition.Definitions {
+				tableInfos[def.ID] = table.Info
+			}
+		}
+	}
+	result := make([]*backup.File, 0, len(files))
+	for _, file := range files {
+		tableID, indexID, isRecord, err := tablecodec.DecodeKeyHead(file.GetStartKey())
+		if err != nil {
+			log.Warn("cannot decode the start key of file, restore it anyway",
+				logutil.File(file), zap.Error(err))
+			result = append(result, file)
+			continue
+		}
+		switch mode {
+		case RestoreIndexOnly:
+			if isRecord {
+				continue
+			}
+		case RestoreSkipIndex:
+			if !isRecord {
+				table, ok := tableInfos[tableID]
+				if ok {
+					index := findIndexByID(table, indexID)
+					if index != nil && rebuildableIndex(table, index) {
+						continue
+					}
+				}
+			}
+		}
+		result = append(result, file)
+	}
+	return result
+}
+
+// buildAddIndexSQL builds the ADD INDEX statement of the index.
+func buildAddIndexSQL(dbName string, table *model.TableInfo, index *model.IndexInfo) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "ALTER TABLE %s.%s ADD ", utils.EncloseName(dbName), utils.EncloseName(table.Name.O))
+	if index.Unique {
+		sb.WriteString("UNIQUE ")
+	}
+	fmt.Fprintf(&sb, "INDEX %s(", utils.EncloseName(index.Name.O))
+	for i, col := range index.Columns {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(utils.EncloseName(table.Columns[col.Offset].Name.O))
+		if col.Length != types.UnspecifiedLength {
+			fmt.Fprintf(&sb, "(%d)", col.Length)
+		}
+	}
+	sb.WriteString(")")
+	if index.Comment != "" {
+		fmt.Fprintf(&sb, " COMMENT '%s'", strings.ReplaceAll(index.Comment, "'", "''"))
+	}
+	return sb.String()
+}
+
+// GoRebuildIndexes rebuilds the indexes skipped in RestoreSkipIndex mode by
+// ADD INDEX, after the rows of the tables are restored.
+func (rc *Client) GoRebuildIndexes(
+	ctx context.Context,
+	dom *domain.Domain,
+	inCh <-chan CreatedTable,
+	errCh chan<- error,
+) <-chan CreatedTable {
+	outCh := make(chan CreatedTable, defaultChannelSize)
+	go func() {
+		defer close(outCh)
+		for tbl := range inCh {
+			if err := rc.rebuildIndexes(ctx, dom, &tbl); err != nil {
+				errCh <- err
+				return
+			}
+			select {
+			case <-ctx.Done():
+				errCh <- ctx.Err()
+				return
+			case outCh <- tbl:
+			}
+		}
+	}()
+	return outCh
+}
+
+func (rc *Client) rebuildIndexes(ctx context.Context, dom *domain.Domain, tbl *CreatedTable) error {
+	oldTable := tbl.OldTable.Info
+	dbName := tbl.OldTable.DB.Name
+	rebuilt := false
+	for _, index := range oldTable.Indices {
+		if !rebuildableIndex(oldTable, index) || tbl.Table.FindIndexByName(index.Name.L) != nil {
+			continue
+		}
+		sql := buildAddIndexSQL(dbName.O, tbl.Table, index)
+		log.Info("rebuild index", zap.String("query", sql))
+		if err := rc.db.se.Execute(ctx, sql); err != nil {
+			return errors.Annotatef(err, "failed to rebuild index %s of %s.%s", index.Name, dbName, oldTable.Name)
+		}
+		rebuilt = true
+	}
+	if !rebuilt {
+		return nil
+	}
+	// Reload the table info with the new indexes, so the checksum covers them.
+	newTable, err := rc.GetTableSchema(dom, dbName, oldTable.Name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tbl.Table = newTable
+	return nil
+}
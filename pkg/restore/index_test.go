@@ -0,0 +1,50 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/tablecodec"
+
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+var _ = Suite(&testIndexSuite{})
+
+type testIndexSuite struct{}
+
+func (s *testIndexSuite) TestFilterFilesByIndexMode(c *C) {
+	table := &utils.Table{
+		DB: &model.DBInfo{Name: model.NewCIStr("test")},
+		Info: &model.TableInfo{
+			ID:      1,
+			Name:    model.NewCIStr("t"),
+			Columns: []*model.ColumnInfo{{Name: model.NewCIStr("a"), Offset: 0}},
+			Indices: []*model.IndexInfo{
+				{
+					ID: 1, Name: model.NewCIStr("primary"), Primary: true, State: model.StatePublic,
+					Columns: []*model.IndexColumn{{Name: model.NewCIStr("a"), Offset: 0}},
+				},
+				{
+					ID: 2, Name: model.NewCIStr("idx_a"), State: model.StatePublic,
+					Columns: []*model.IndexColumn{{Name: model.NewCIStr("a"), Offset: 0}},
+				},
+			},
+		},
+	}
+	rowFile := &backup.File{Name: "row.sst", StartKey: append(tablecodec.GenTableRecordPrefix(1), 'a')}
+	pkFile := &backup.File{Name: "pk.sst", StartKey: append(tablecodec.EncodeTableIndexPrefix(1, 1), 'a')}
+	idxFile := &backup.File{Name: "idx.sst", StartKey: append(tablecodec.EncodeTableIndexPrefix(1, 2), 'a')}
+	files := []*backup.File{rowFile, pkFile, idxFile}
+	tables := []*utils.Table{table}
+
+	c.Assert(restore.FilterFilesByIndexMode(files, tables, restore.RestoreRowsAndIndexes), DeepEquals, files)
+	// the primary key can't be rebuilt, so it's restored along with the rows.
+	c.Assert(restore.FilterFilesByIndexMode(files, tables, restore.RestoreSkipIndex),
+		DeepEquals, []*backup.File{rowFile, pkFile})
+	c.Assert(restore.FilterFilesByIndexMode(files, tables, restore.RestoreIndexOnly),
+		DeepEquals, []*backup.File{pkFile, idxFile})
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"encoding/hex"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/backup"
+)
+
+// ingestCacheSaveInterval is the min interval of saving the checkpoint when
+// the files are ingested. The files are many, so they are saved less often
+// than the regions.
+const ingestCacheSaveInterval = 30 * time.Second
+
+// ingestedFileKey identifies the file restored into the range rewritten by
+// the rules, the file is restored again once its table is recreated.
+func ingestedFileKey(file *backup.File, rewriteRules *RewriteRules) string {
+	startKey, _, err := rewriteFileKeys(file, rewriteRules)
+	if err != nil {
+		return file.GetName()
+	}
+	return file.GetName() + "@" + hex.EncodeToString(startKey)
+}
+
+// IngestCache records the files downloaded and ingested successfully in the
+// checkpoint, so a resumed restore doesn't import them again. The files are
+// only skipped by the runs of the same restore in the same cluster, see
+// ResumeCheckpoint.
+type IngestCache struct {
+	recorder *CheckpointRecorder
+	// files is the set of the ingested files in the checkpoint.
+	files map[string]struct{}
+}
+
+// NewIngestCache creates an ingest cache recording into the checkpoint of the
+// recorder, with the files ingested by the previous runs in it. nil is
+// returned without the recorder.
+func NewIngestCache(recorder *CheckpointRecorder) *IngestCache {
+	if recorder == nil {
+		return nil
+	}
+	cache := &IngestCache{recorder: recorder, files: make(map[string]struct{})}
+	recorder.view(func(cp *Checkpoint) {
+		for _, key := range cp.IngestedFiles {
+			cache.files[key] = struct{}{}
+		}
+	})
+	return cache
+}
+
+// Len returns the count of the ingested files.
+func (c *IngestCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	n := 0
+	c.recorder.view(func(*Checkpoint) { n = len(c.files) })
+	return n
+}
+
+// ingested returns whether the file has been ingested into the same range.
+func (c *IngestCache) ingested(key string) bool {
+	if c == nil {
+		return false
+	}
+	ok := false
+	c.recorder.view(func(*Checkpoint) { _, ok = c.files[key] })
+	return ok
+}
+
+// record records the file ingested, the checkpoint is saved at most once per
+// ingestCacheSaveInterval.
+func (c *IngestCache) record(ctx context.Context, key string) {
+	if c == nil {
+		return
+	}
+	c.recorder.update(ctx, ingestCacheSaveInterval, func(cp *Checkpoint) {
+		if _, ok := c.files[key]; !ok {
+			c.files[key] = struct{}{}
+			cp.IngestedFiles = append(cp.IngestedFiles, key)
+		}
+	})
+}
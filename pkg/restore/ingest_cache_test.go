@@ -0,0 +1,57 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+type testIngestCacheSuite struct{}
+
+var _ = Suite(&testIngestCacheSuite{})
+
+func (s *testIngestCacheSuite) TestSkipIngestedFiles(c *C) {
+	ctx := context.Background()
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	files := []*backup.File{
+		{Name: "1.sst", StartKey: []byte("a"), EndKey: []byte("b")},
+		{Name: "2.sst", StartKey: []byte("b"), EndKey: []byte("c")},
+		{Name: "3.sst", StartKey: []byte("c"), EndKey: []byte("d")},
+	}
+
+	recorder := NewCheckpointRecorder(store, ResumeCheckpoint(nil, 1, false))
+	cache := NewIngestCache(recorder)
+	c.Assert(cache.Len(), Equals, 0)
+	cache.record(ctx, ingestedFileKey(files[0], nil))
+	cache.record(ctx, ingestedFileKey(files[2], nil))
+	cache.record(ctx, ingestedFileKey(files[2], nil))
+	recorder.Save(ctx)
+
+	// The resumed restore skips the files ingested.
+	previous, err := ReadCheckpoint(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(previous.IngestedFiles, HasLen, 2)
+	cache = NewIngestCache(NewCheckpointRecorder(store, ResumeCheckpoint(previous, 1, false)))
+	c.Assert(cache.Len(), Equals, 2)
+	// The restore into another cluster doesn't.
+	c.Assert(NewIngestCache(NewCheckpointRecorder(store, ResumeCheckpoint(previous, 2, false))).Len(), Equals, 0)
+
+	rc := &Client{ingestCache: cache}
+	var done []string
+	remaining := rc.skipIngestedFiles(files, nil, func(file *backup.File) {
+		done = append(done, file.Name)
+	})
+	c.Assert(done, DeepEquals, []string{"1.sst", "3.sst"})
+	c.Assert(remaining, DeepEquals, files[1:2])
+
+	// Nothing is skipped without the checkpoint.
+	c.Assert(NewIngestCache(nil), IsNil)
+	rc = &Client{}
+	c.Assert(rc.skipIngestedFiles(files, nil, nil), DeepEquals, files)
+}
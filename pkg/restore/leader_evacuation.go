@@ -0,0 +1,191 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// DefaultLeaderEvacuationInterval is the interval of picking the stores to
+// evict the leaders from by the recent ingest load.
+const DefaultLeaderEvacuationInterval = time.Minute
+
+// StoreIngestLoad tracks the bytes downloaded into the stores by restore.
+type StoreIngestLoad struct {
+	mu    sync.Mutex
+	bytes map[uint64]uint64
+}
+
+// NewStoreIngestLoad creates an empty ingest load.
+func NewStoreIngestLoad() *StoreIngestLoad {
+	return &StoreIngestLoad{bytes: make(map[uint64]uint64)}
+}
+
+// add records the bytes downloaded into the store. A nil load records nothing.
+func (l *StoreIngestLoad) add(storeID uint64, n uint64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.bytes[storeID] += n
+	l.mu.Unlock()
+}
+
+// take returns the bytes recorded since the previous take.
+func (l *StoreIngestLoad) take() map[uint64]uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bytes := l.bytes
+	l.bytes = make(map[uint64]uint64, len(bytes))
+	return bytes
+}
+
+// LeaderEvictor evicts the leaders from the stores, it's implemented by
+// pdutil.PdController.
+type LeaderEvictor interface {
+	EvictLeaderStores(ctx context.Context) ([]uint64, error)
+	EvictLeaders(ctx context.Context, storeID uint64) error
+	CancelEvictLeaders(ctx context.Context, storeID uint64) error
+}
+
+// LeaderEvacuator moves the leaders of the serving regions away from the
+// stores receiving the heaviest ingest during online restore, so the
+// foreground requests aren't slowed down by the ingest, and moves them back
+// once the ingest shifts to other stores or the restore finishes.
+type LeaderEvacuator struct {
+	evictor   LeaderEvictor
+	load      *StoreIngestLoad
+	maxStores int
+
+	// preserved is the stores evicted before the restore, e.g. by the
+	// operators, they're never touched by the evacuator.
+	preserved map[uint64]struct{}
+	evicted   map[uint64]struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewLeaderEvacuator creates an evacuator evicting the leaders from at most
+// maxStores stores at the same time.
+func NewLeaderEvacuator(evictor LeaderEvictor, maxStores int) *LeaderEvacuator {
+	return &LeaderEvacuator{
+		evictor:   evictor,
+		load:      NewStoreIngestLoad(),
+		maxStores: maxStores,
+		preserved: make(map[uint64]struct{}),
+		evicted:   make(map[uint64]struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Load returns the ingest load the evacuator picks the stores by.
+func (e *LeaderEvacuator) Load() *StoreIngestLoad {
+	return e.load
+}
+
+// Start picks the stores and evicts their leaders at the interval, until
+// Stop is called.
+func (e *LeaderEvacuator) Start(ctx context.Context, interval time.Duration) error {
+	stores, err := e.evictor.EvictLeaderStores(ctx)
+	if err != nil {
+		return errors.Annotate(err, "failed to get the stores evicted before restore")
+	}
+	for _, storeID := range stores {
+		e.preserved[storeID] = struct{}{}
+	}
+	log.Info("start to evacuate leaders from the ingest-heavy stores",
+		zap.Int("max-stores", e.maxStores), zap.Uint64s("preserved", stores))
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-e.done:
+				return
+			case <-ticker.C:
+				e.evacuate(ctx)
+			}
+		}
+	}()
+	return nil
+}
+
+// heaviestStores returns at most n stores with the most bytes, heaviest first.
+func heaviestStores(bytes map[uint64]uint64, n int) []uint64 {
+	stores := make([]uint64, 0, len(bytes))
+	for storeID, b := range bytes {
+		if b > 0 {
+			stores = append(stores, storeID)
+		}
+	}
+	sort.Slice(stores, func(i, j int) bool {
+		if bytes[stores[i]] != bytes[stores[j]] {
+			return bytes[stores[i]] > bytes[stores[j]]
+		}
+		return stores[i] < stores[j]
+	})
+	if len(stores) > n {
+		stores = stores[:n]
+	}
+	return stores
+}
+
+// evacuate evicts the leaders from the heaviest stores since the previous
+// call, and moves the leaders back to the other stores.
+func (e *LeaderEvacuator) evacuate(ctx context.Context) {
+	heaviest := make(map[uint64]struct{})
+	for _, storeID := range heaviestStores(e.load.take(), e.maxStores) {
+		heaviest[storeID] = struct{}{}
+	}
+	for storeID := range e.evicted {
+		if _, ok := heaviest[storeID]; ok {
+			continue
+		}
+		if err := e.evictor.CancelEvictLeaders(ctx, storeID); err != nil {
+			log.Warn("failed to move the leaders back", zap.Uint64("store", storeID), zap.Error(err))
+			continue
+		}
+		log.Info("move the leaders back", zap.Uint64("store", storeID))
+		delete(e.evicted, storeID)
+	}
+	for storeID := range heaviest {
+		_, evicted := e.evicted[storeID]
+		_, preserved := e.preserved[storeID]
+		if evicted || preserved {
+			continue
+		}
+		if err := e.evictor.EvictLeaders(ctx, storeID); err != nil {
+			log.Warn("failed to evict the leaders", zap.Uint64("store", storeID), zap.Error(err))
+			continue
+		}
+		log.Info("evict the leaders from the ingest-heavy store", zap.Uint64("store", storeID))
+		e.evicted[storeID] = struct{}{}
+	}
+}
+
+// Stop stops the evacuation and moves the leaders back to all the evicted
+// stores.
+func (e *LeaderEvacuator) Stop(ctx context.Context) {
+	close(e.done)
+	e.wg.Wait()
+	for storeID := range e.evicted {
+		if err := e.evictor.CancelEvictLeaders(ctx, storeID); err != nil {
+			log.Error("failed to move the leaders back, please remove the store from "+
+				"the evict-leader-scheduler of PD manually", zap.Uint64("store", storeID), zap.Error(err))
+			continue
+		}
+		delete(e.evicted, storeID)
+	}
+	log.Info("leader evacuation stopped")
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"sort"
+
+	. "github.com/pingcap/check"
+)
+
+type testLeaderEvacuationSuite struct{}
+
+var _ = Suite(&testLeaderEvacuationSuite{})
+
+type fakeLeaderEvictor struct {
+	evicted map[uint64]struct{}
+}
+
+func (f *fakeLeaderEvictor) EvictLeaderStores(ctx context.Context) ([]uint64, error) {
+	stores := make([]uint64, 0, len(f.evicted))
+	for storeID := range f.evicted {
+		stores = append(stores, storeID)
+	}
+	sort.Slice(stores, func(i, j int) bool { return stores[i] < stores[j] })
+	return stores, nil
+}
+
+func (f *fakeLeaderEvictor) EvictLeaders(ctx context.Context, storeID uint64) error {
+	f.evicted[storeID] = struct{}{}
+	return nil
+}
+
+func (f *fakeLeaderEvictor) CancelEvictLeaders(ctx context.Context, storeID uint64) error {
+	delete(f.evicted, storeID)
+	return nil
+}
+
+func (*testLeaderEvacuationSuite) TestHeaviestStores(c *C) {
+	bytes := map[uint64]uint64{1: 10, 2: 30, 3: 30, 4: 0, 5: 20}
+	c.Assert(heaviestStores(bytes, 2), DeepEquals, []uint64{2, 3})
+	c.Assert(heaviestStores(bytes, 10), DeepEquals, []uint64{2, 3, 5, 1})
+	c.Assert(heaviestStores(nil, 2), HasLen, 0)
+}
+
+func (*testLeaderEvacuationSuite) TestEvacuate(c *C) {
+	ctx := context.Background()
+	// Store 9 is evicted by the operators before the restore.
+	evictor := &fakeLeaderEvictor{evicted: map[uint64]struct{}{9: {}}}
+	e := NewLeaderEvacuator(evictor, 1)
+	c.Assert(e.Start(ctx, DefaultLeaderEvacuationInterval), IsNil)
+
+	e.Load().add(1, 100)
+	e.Load().add(2, 50)
+	e.evacuate(ctx)
+	stores, _ := evictor.EvictLeaderStores(ctx)
+	c.Assert(stores, DeepEquals, []uint64{1, 9})
+
+	// The ingest shifts to store 2, the leaders are moved back to store 1.
+	e.Load().add(2, 100)
+	e.evacuate(ctx)
+	stores, _ = evictor.EvictLeaderStores(ctx)
+	c.Assert(stores, DeepEquals, []uint64{2, 9})
+
+	// The preserved store is never touched.
+	e.Load().add(9, 1000)
+	e.evacuate(ctx)
+	stores, _ = evictor.EvictLeaderStores(ctx)
+	c.Assert(stores, DeepEquals, []uint64{9})
+
+	e.Load().add(3, 100)
+	e.evacuate(ctx)
+	e.Stop(ctx)
+	stores, _ = evictor.EvictLeaderStores(ctx)
+	c.Assert(stores, DeepEquals, []uint64{9})
+}
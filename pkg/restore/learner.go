@@ -0,0 +1,192 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+	"github.com/tikv/pd/server/schedule/placement"
+	"go.uber.org/zap"
+)
+
+// EnableLearnerIngest makes the online restore ingest into the peers on the
+// restore stores without moving the serving voters away first: the leader of
+// each region is moved to the restore stores, and the rest of the peers there
+// are learners, so the ingest is driven by the restore stores. The learners
+// are promoted after the tables are restored.
+func (rc *Client) EnableLearnerIngest() {
+	rc.learnerIngest = true
+}
+
+// IsLearnerIngest tells if the online restore ingests into learners first.
+func (rc *Client) IsLearnerIngest() bool {
+	return rc.isOnline && rc.learnerIngest
+}
+
+func (rc *Client) getLeaderRuleID(tableID int64) string {
+	return restoreRulePrefix + strconv.FormatInt(tableID, 10) + "-leader-" + rc.taskID
+}
+
+// restoreRules builds the temporary placement rules of the restore stores
+// for a table from the default rule of PD, so the restore stores hold as many
+// peers as the default rule asks for.
+//
+// With the voter role, the voters of the table are moved to the restore
+// stores. With the learner role, the rules are applied alongside the default
+// rule: one peer on the restore stores is the leader, so the serving stores
+// don't drive the ingest, and the others are learners.
+func restoreRules(
+	base placement.Rule,
+	role placement.PeerRoleType,
+	ruleID, leaderRuleID string,
+	tableID int64,
+) []placement.Rule {
+	base.Index = 100
+	base.StartKeyHex = hex.EncodeToString(codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(tableID)))
+	base.EndKeyHex = hex.EncodeToString(codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(tableID+1)))
+	base.LabelConstraints = append(append([]placement.LabelConstraint{}, base.LabelConstraints...),
+		placement.LabelConstraint{
+			Key:    restoreLabelKey,
+			Op:     "in",
+			Values: []string{restoreLabelValue},
+		})
+	if role != placement.Learner {
+		base.ID = ruleID
+		base.Role = role
+		base.Override = true
+		return []placement.Rule{base}
+	}
+
+	base.Override = false
+	leader := base
+	leader.ID = leaderRuleID
+	leader.Role = placement.Leader
+	leader.Count = 1
+	rules := []placement.Rule{leader}
+	if base.Count > 1 {
+		learner := base
+		learner.ID = ruleID
+		learner.Role = placement.Learner
+		learner.Count = base.Count - 1
+		rules = append(rules, learner)
+	}
+	return rules
+}
+
+func (rc *Client) setRestoreRules(ctx context.Context, tables []*model.TableInfo, role placement.PeerRoleType) error {
+	base, err := rc.toolClient.GetPlacementRule(ctx, "pd", "default")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if role == placement.Learner && base.Count > 1 {
+		rc.learnerCount = base.Count - 1
+	}
+	for _, t := range tables {
+		for _, rule := range restoreRules(base, role, rc.getRuleID(t.ID), rc.getLeaderRuleID(t.ID), t.ID) {
+			if err = rc.toolClient.SetPlacementRule(ctx, rule); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	return nil
+}
+
+// deleteLeaderRules deletes the leader rules set by the learner-first ingest.
+func (rc *Client) deleteLeaderRules(ctx context.Context, tables []*model.TableInfo) error {
+	for _, t := range tables {
+		if err := rc.toolClient.DeletePlacementRule(ctx, "pd", rc.getLeaderRuleID(t.ID)); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// PromoteLearners promotes the learners created for the tables to voters, and
+// waits PD to finish the promotion. It does nothing unless the learner-first
+// ingest is enabled.
+func (rc *Client) PromoteLearners(ctx context.Context, tables []*model.TableInfo) error {
+	if !rc.IsLearnerIngest() || len(rc.restoreStores) == 0 {
+		return nil
+	}
+	log.Info("start promoting learners", zap.Int("tables", len(tables)))
+	// Overriding the default rule with a voter rule on the restore stores
+	// makes PD promote the learners in place, instead of adding new peers.
+	// The voter rule covers the leader on the restore stores as well.
+	if err := rc.setRestoreRules(ctx, tables, placement.Voter); err != nil {
+		return errors.Trace(err)
+	}
+	if err := rc.deleteLeaderRules(ctx, tables); err != nil {
+		return errors.Trace(err)
+	}
+	if err := rc.waitRegions(ctx, tables, rc.checkRange); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("finish promoting learners", zap.Int("tables", len(tables)))
+	return nil
+}
+
+func (rc *Client) waitRegions(
+	ctx context.Context,
+	tables []*model.TableInfo,
+	check func(ctx context.Context, start, end []byte) (bool, string, error),
+) error {
+	ticker := time.NewTicker(time.Second * 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ok, progress, err := rc.checkRegions(ctx, tables, check)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if ok {
+				return nil
+			}
+			log.Info("placement schedule progress: " + progress)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// checkLearnerRange checks whether every region in the range is led by a
+// peer on the restore stores and has a learner there.
+func (rc *Client) checkLearnerRange(ctx context.Context, start, end []byte) (bool, string, error) {
+	ok, progress := true, ""
+	err := WalkRegions(ctx, rc.toolClient, start, end, scanRegionPaginationLimit, func(i int, r *RegionInfo) bool {
+		if !learnerPlaced(r, rc.restoreStores, rc.learnerCount) {
+			ok, progress = false, fmt.Sprintf("region %v", i)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return false, "", errors.Trace(err)
+	}
+	return ok, progress, nil
+}
+
+// learnerPlaced checks whether the leader of the region is on the stores and
+// at least learners learners are on the stores.
+func learnerPlaced(r *RegionInfo, stores []uint64, learners int) bool {
+	if r.Leader == nil || !containsStore(stores, r.Leader.GetStoreId()) {
+		return false
+	}
+	n := 0
+	for _, p := range r.Region.GetPeers() {
+		if p.GetRole() == metapb.PeerRole_Learner && containsStore(stores, p.GetStoreId()) {
+			n++
+		}
+	}
+	return n >= learners
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/tikv/pd/server/schedule/placement"
+)
+
+type testLearnerSuite struct{}
+
+var _ = Suite(&testLearnerSuite{})
+
+func (s *testLearnerSuite) TestRestoreRules(c *C) {
+	base := placement.Rule{GroupID: "pd", ID: "default", Role: placement.Voter, Count: 3}
+
+	rules := restoreRules(base, placement.Voter, "restore-t42", "restore-t42-leader", 42)
+	c.Assert(rules, HasLen, 1)
+	c.Assert(rules[0].ID, Equals, "restore-t42")
+	c.Assert(rules[0].Role, Equals, placement.Voter)
+	c.Assert(rules[0].Count, Equals, 3)
+	c.Assert(rules[0].Override, IsTrue)
+	c.Assert(rules[0].Index, Equals, 100)
+	c.Assert(rules[0].LabelConstraints, HasLen, 1)
+	c.Assert(rules[0].LabelConstraints[0].Key, Equals, restoreLabelKey)
+
+	rules = restoreRules(base, placement.Learner, "restore-t42", "restore-t42-leader", 42)
+	c.Assert(rules, HasLen, 2)
+	// The leader is moved to the restore stores, so the serving stores don't
+	// drive the ingest.
+	c.Assert(rules[0].ID, Equals, "restore-t42-leader")
+	c.Assert(rules[0].Role, Equals, placement.Leader)
+	c.Assert(rules[0].Count, Equals, 1)
+	c.Assert(rules[0].Override, IsFalse)
+	c.Assert(rules[1].ID, Equals, "restore-t42")
+	c.Assert(rules[1].Role, Equals, placement.Learner)
+	c.Assert(rules[1].Count, Equals, 2)
+	c.Assert(rules[1].Override, IsFalse)
+	c.Assert(rules[0].StartKeyHex, Equals, rules[1].StartKeyHex)
+	c.Assert(rules[0].StartKeyHex < rules[0].EndKeyHex, IsTrue)
+	// The label constraints of the default rule aren't changed.
+	c.Assert(base.LabelConstraints, HasLen, 0)
+
+	base.Count = 1
+	rules = restoreRules(base, placement.Learner, "restore-t42", "restore-t42-leader", 42)
+	c.Assert(rules, HasLen, 1)
+	c.Assert(rules[0].Role, Equals, placement.Leader)
+}
+
+func (s *testLearnerSuite) TestLearnerPlaced(c *C) {
+	restoreStores := []uint64{4, 5}
+	region := &RegionInfo{
+		Region: &metapb.Region{Peers: []*metapb.Peer{
+			{Id: 1, StoreId: 1},
+			{Id: 2, StoreId: 2},
+			{Id: 4, StoreId: 4},
+			{Id: 5, StoreId: 5, Role: metapb.PeerRole_Learner},
+		}},
+		Leader: &metapb.Peer{Id: 1, StoreId: 1},
+	}
+	// The leader is still on a serving store.
+	c.Assert(learnerPlaced(region, restoreStores, 1), IsFalse)
+
+	region.Leader = &metapb.Peer{Id: 4, StoreId: 4}
+	c.Assert(learnerPlaced(region, restoreStores, 1), IsTrue)
+	c.Assert(learnerPlaced(region, restoreStores, 2), IsFalse)
+}
@@ -113,8 +113,10 @@ func NewLogRestoreClient(
 		}
 	}
 
-	splitClient := NewSplitClient(restoreClient.GetPDClient(), restoreClient.GetTLSConfig())
-	importClient := NewImportClient(splitClient, restoreClient.tlsConf, restoreClient.keepaliveConf)
+	splitClient := NewSplitClient(restoreClient.GetPDClient(), restoreClient.GetPDTLSConfig(), restoreClient.GetTLSConfig(),
+		restoreClient.maxMsgSize)
+	importClient := NewImportClient(splitClient, restoreClient.tlsConf, restoreClient.keepaliveConf,
+		restoreClient.maxMsgSize.DialOptions()...)
 
 	cfg := concurrencyCfg{
 		Concurrency:       concurrency,
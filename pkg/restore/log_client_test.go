@@ -13,6 +13,7 @@ import (
 	"github.com/pingcap/br/pkg/gluetidb"
 	"github.com/pingcap/br/pkg/mock"
 	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/utils"
 )
 
 type testLogRestoreSuite struct {
@@ -28,7 +29,7 @@ func (s *testLogRestoreSuite) SetUpSuite(c *C) {
 	s.mock, err = mock.NewCluster()
 	c.Assert(err, IsNil)
 	restoreClient, err := restore.NewRestoreClient(
-		gluetidb.New(), s.mock.PDClient, s.mock.Storage, nil, defaultKeepaliveCfg)
+		gluetidb.New(), s.mock.PDClient, s.mock.Storage, nil, nil, defaultKeepaliveCfg, utils.GRPCMaxMsgSize{})
 	c.Assert(err, IsNil)
 
 	s.client, err = restore.NewLogRestoreClient(
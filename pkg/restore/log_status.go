@@ -0,0 +1,156 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv/oracle"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// LogTableStatus is the status of the log backup of a table.
+type LogTableStatus struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// LastTS is the ts of the latest rotated file of the table, zero means
+	// no file has been rotated yet.
+	LastTS uint64 `json:"last-ts"`
+	Files  int    `json:"files"`
+	Size   int64  `json:"size"`
+}
+
+// logStatusScanLimit is the count of the regions scanned in a batch when
+// aggregating the status by the stores.
+const logStatusScanLimit = 128
+
+// LogStoreStatus is the status of the log backup of the tables whose region
+// leaders are on a store.
+type LogStoreStatus struct {
+	StoreID uint64 `json:"store-id"`
+	Tables  int    `json:"tables"`
+	// LastTS is the smallest ts of the latest rotated files of the tables,
+	// zero means none of the tables has rotated a file.
+	LastTS uint64 `json:"last-ts"`
+	// Laggiest is the ID of the table of the LastTS.
+	Laggiest int64 `json:"laggiest"`
+}
+
+// LogBackupStatus is the status of the log backup at a storage.
+type LogBackupStatus struct {
+	// ResolvedTS is the global resolved ts, i.e. the checkpoint of the log
+	// backup, all the changes before it have been written to the storage.
+	ResolvedTS uint64           `json:"resolved-ts"`
+	Tables     []LogTableStatus `json:"tables"`
+	Files      int              `json:"files"`
+	Size       int64            `json:"size"`
+}
+
+// TSLag returns how long the ts lags behind now.
+func TSLag(ts uint64, now time.Time) time.Duration {
+	physical := time.Unix(0, oracle.ExtractPhysical(ts)*int64(time.Millisecond))
+	return now.Sub(physical)
+}
+
+// GetLogBackupStatus inspects the log backup written by TiCDC at the storage.
+func GetLogBackupStatus(ctx context.Context, s storage.ExternalStorage) (*LogBackupStatus, error) {
+	data, err := s.Read(ctx, metaFile)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read %s of the log backup", metaFile)
+	}
+	meta := new(LogMeta)
+	if err = json.Unmarshal(data, meta); err != nil {
+		return nil, errors.Annotatef(berrors.ErrPiTRInvalidCDCLogFormat, "invalid %s: %s", metaFile, err)
+	}
+
+	status := &LogBackupStatus{ResolvedTS: meta.GlobalResolvedTS}
+	tables := make(map[int64]*LogTableStatus, len(meta.Names))
+	for id, name := range meta.Names {
+		tables[id] = &LogTableStatus{ID: id, Name: name}
+	}
+	err = s.WalkDir(ctx, &storage.WalkOption{ListCount: -1}, func(filePath string, size int64) error {
+		status.Files++
+		status.Size += size
+		dir, name := path.Split(filepath.ToSlash(filePath))
+		dir = path.Base(dir)
+		if !strings.HasPrefix(dir, tableLogPrefix) {
+			return nil
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(dir, tableLogPrefix), 10, 64)
+		if err != nil {
+			return nil
+		}
+		table, ok := tables[id]
+		if !ok {
+			table = &LogTableStatus{ID: id}
+			tables[id] = table
+		}
+		table.Files++
+		table.Size += size
+		// The file being written by the file sink has no ts.
+		if ts, err := strconv.ParseUint(strings.TrimPrefix(name, logPrefix+"."), 10, 64); err == nil && ts > table.LastTS {
+			table.LastTS = ts
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, table := range tables {
+		status.Tables = append(status.Tables, *table)
+	}
+	sort.Slice(status.Tables, func(i, j int) bool { return status.Tables[i].ID < status.Tables[j].ID })
+	return status, nil
+}
+
+// AggregateLogStatusByStore aggregates the status of the tables by the stores
+// leading their regions, a table is counted by every store leading any region
+// of it. So the lag of a store is the lag of the laggiest table it leads.
+func AggregateLogStatusByStore(
+	ctx context.Context, client SplitClient, tables []LogTableStatus,
+) ([]LogStoreStatus, error) {
+	stores := make(map[uint64]*LogStoreStatus)
+	for _, table := range tables {
+		startKey := codec.EncodeBytes(nil, tablecodec.EncodeTablePrefix(table.ID))
+		endKey := codec.EncodeBytes(nil, tablecodec.EncodeTablePrefix(table.ID+1))
+		leaders := make(map[uint64]struct{})
+		err := WalkRegions(ctx, client, startKey, endKey, logStatusScanLimit, func(_ int, region *RegionInfo) bool {
+			if region.Leader != nil {
+				leaders[region.Leader.GetStoreId()] = struct{}{}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to scan the regions of table %d", table.ID)
+		}
+		for storeID := range leaders {
+			store, ok := stores[storeID]
+			if !ok {
+				store = &LogStoreStatus{StoreID: storeID}
+				stores[storeID] = store
+			}
+			store.Tables++
+			if table.LastTS != 0 && (store.LastTS == 0 || table.LastTS < store.LastTS) {
+				store.LastTS, store.Laggiest = table.LastTS, table.ID
+			}
+		}
+	}
+	result := make([]LogStoreStatus, 0, len(stores))
+	for _, store := range stores {
+		result = append(result, *store)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].StoreID < result[j].StoreID })
+	return result, nil
+}
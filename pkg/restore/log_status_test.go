@@ -0,0 +1,63 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+
+	"github.com/pingcap/br/pkg/restore"
+)
+
+type testLogStatusSuite struct{}
+
+var _ = Suite(&testLogStatusSuite{})
+
+func (s *testLogStatusSuite) TestAggregateLogStatusByStore(c *C) {
+	encode := func(key []byte) []byte {
+		if len(key) == 0 {
+			return key
+		}
+		return codec.EncodeBytes(nil, key)
+	}
+	boundaries := [][]byte{
+		nil,
+		tablecodec.EncodeTablePrefix(45),
+		tablecodec.EncodeRowKeyWithHandle(45, kv.IntHandle(100)),
+		tablecodec.EncodeTablePrefix(46),
+		nil,
+	}
+	leaders := []uint64{3, 1, 2, 2}
+	regions := make(map[uint64]*restore.RegionInfo)
+	for i, storeID := range leaders {
+		id := uint64(i + 1)
+		peer := &metapb.Peer{Id: id, StoreId: storeID}
+		regions[id] = &restore.RegionInfo{
+			Region: &metapb.Region{
+				Id:       id,
+				StartKey: encode(boundaries[i]),
+				EndKey:   encode(boundaries[i+1]),
+				Peers:    []*metapb.Peer{peer},
+			},
+			Leader: peer,
+		}
+	}
+	client := newTestClient(nil, regions, 5)
+
+	stores, err := restore.AggregateLogStatusByStore(context.Background(), client, []restore.LogTableStatus{
+		{ID: 45, LastTS: 200},
+		{ID: 46, LastTS: 100},
+		// The table without the rotated files doesn't lag the store.
+		{ID: 47},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(stores, DeepEquals, []restore.LogStoreStatus{
+		{StoreID: 1, Tables: 1, LastTS: 200, Laggiest: 45},
+		{StoreID: 2, Tables: 3, LastTS: 100, Laggiest: 46},
+	})
+}
@@ -0,0 +1,469 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/executor"
+	"github.com/pingcap/tidb/meta/autoid"
+	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+	"github.com/pingcap/tidb/util/mock"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	// the prefix of the data keys in the SST files.
+	dataKeyPrefix = 'z'
+	// the write types and the flag of the short value of the TiKV write
+	// records.
+	writeTypePut     = 'P'
+	shortValuePrefix = 'v'
+	// maxPlaceholders is the max count of the placeholders of a statement.
+	maxPlaceholders = 65535
+)
+
+// LogicalRestorer restores the tables through the SQL interface only, without
+// accessing PD or TiKV. It decodes the rows from the SST files client-side and
+// inserts them in batches, which is much slower than ingesting the files. It's
+// the import backend of SQLImportBackend.
+type LogicalRestorer struct {
+	db        *sql.DB
+	storage   storage.ExternalStorage
+	batchSize int
+	sctx      sessionctx.Context
+	filter    *RowFilter
+	rows      int64
+}
+
+var _ ImportBackend = (*LogicalRestorer)(nil)
+
+// NewLogicalRestorer returns a LogicalRestorer inserting the rows through the
+// database, the batch size is the max count of the rows inserted by a
+// statement. The database is closed with the restorer.
+func NewLogicalRestorer(db *sql.DB, s storage.ExternalStorage, batchSize int) *LogicalRestorer {
+	return &LogicalRestorer{
+		db:        db,
+		storage:   s,
+		batchSize: batchSize,
+		sctx:      mock.NewContext(),
+	}
+}
+
+// SetRowFilter sets the filter of the rows restored.
+func (r *LogicalRestorer) SetRowFilter(filter *RowFilter) {
+	r.filter = filter
+}
+
+// CreateTable creates the database and the table if not exists.
+func (r *LogicalRestorer) CreateTable(ctx context.Context, tbl *utils.Table) error {
+	var buf bytes.Buffer
+	if err := executor.ConstructResultOfShowCreateDatabase(r.sctx, tbl.DB, true, &buf); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := r.db.ExecContext(ctx, buf.String()); err != nil {
+		return errors.Annotatef(err, "failed to create database %s", tbl.DB.Name)
+	}
+	buf.Reset()
+	if err := executor.ConstructResultOfShowCreateTable(r.sctx, tbl.Info, autoid.Allocators{}, &buf); err != nil {
+		return errors.Trace(err)
+	}
+	createSQL := qualifiedCreateSQL(buf.String(), tbl)
+	if _, err := r.db.ExecContext(ctx, createSQL); err != nil {
+		return errors.Annotatef(err, "failed to create table %s.%s", tbl.DB.Name, tbl.Info.Name)
+	}
+	return nil
+}
+
+// qualifiedCreateSQL qualifies the name in the statement creating the table,
+// the view or the sequence by the database, which isn't in the statement, and
+// makes the statement idempotent.
+func qualifiedCreateSQL(createSQL string, tbl *utils.Table) string {
+	db := utils.EncloseName(tbl.DB.Name.O) + "."
+	name := utils.EncloseName(tbl.Info.Name.O)
+	switch {
+	case tbl.Info.IsView():
+		// e.g. CREATE ALGORITHM=UNDEFINED DEFINER=... SQL SECURITY DEFINER VIEW `v` (...
+		createSQL = strings.Replace(createSQL, " VIEW "+name, " VIEW "+db+name, 1)
+		return strings.Replace(createSQL, "CREATE ", "CREATE OR REPLACE ", 1)
+	case tbl.Info.IsSequence():
+		return strings.Replace(createSQL, "CREATE SEQUENCE ", "CREATE SEQUENCE IF NOT EXISTS "+db, 1)
+	default:
+		return strings.Replace(createSQL, "CREATE TABLE ", "CREATE TABLE IF NOT EXISTS "+db, 1)
+	}
+}
+
+// logicalTable is the columns of a table inserted by the logical restore.
+type logicalTable struct {
+	tbl      *utils.Table
+	ids      map[int64]struct{}
+	columns  []*model.ColumnInfo
+	colTypes map[int64]*types.FieldType
+	// names is like (`a`,`b`).
+	names string
+	// prefix is like INSERT INTO `db`.`t` (`a`,`b`) VALUES
+	prefix string
+	// datums is reused to decode the rows.
+	datums []types.Datum
+	// handles is the range of the handles of the rows, nil means all.
+	handles *HandleRange
+}
+
+func newLogicalTable(tbl *utils.Table) *logicalTable {
+	t := &logicalTable{
+		tbl:      tbl,
+		ids:      map[int64]struct{}{tbl.Info.ID: {}},
+		colTypes: make(map[int64]*types.FieldType),
+	}
+	if partitions := tbl.Info.GetPartitionInfo(); partitions != nil {
+		for _, def := range partitions.Definitions {
+			t.ids[def.ID] = struct{}{}
+		}
+	}
+	names := make([]string, 0, len(tbl.Info.Columns))
+	for _, col := range tbl.Info.Columns {
+		// The generated columns can't be inserted.
+		if col.State != model.StatePublic || col.IsGenerated() {
+			continue
+		}
+		t.columns = append(t.columns, col)
+		t.colTypes[col.ID] = &col.FieldType
+		names = append(names, utils.EncloseName(col.Name.O))
+	}
+	t.names = "(" + strings.Join(names, ",") + ")"
+	t.prefix = "INSERT INTO " + utils.EncloseName(tbl.DB.Name.O) + "." + utils.EncloseName(tbl.Info.Name.O) +
+		" " + t.names + " VALUES "
+	return t
+}
+
+// decodeRow decodes the row into the arguments of the insert statement.
+func (r *LogicalRestorer) decodeRow(t *logicalTable, key, value []byte, args []interface{}) ([]interface{}, error) {
+	datums, err := decodeDatums(r.sctx, t, key, value, t.datums[:0])
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	t.datums = datums
+	for _, d := range datums {
+		args = append(args, datumToSQLArg(d))
+	}
+	return args, nil
+}
+
+// decodeDatums decodes the row into the datums of the inserted columns.
+func decodeDatums(
+	sctx sessionctx.Context, t *logicalTable, key, value []byte, datums []types.Datum,
+) ([]types.Datum, error) {
+	_, handle, err := tablecodec.DecodeRecordKey(key)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	row, err := tablecodec.DecodeRowToDatumMap(value, t.colTypes, time.UTC)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, col := range t.columns {
+		d, ok := row[col.ID]
+		switch {
+		case ok:
+		case t.tbl.Info.PKIsHandle && mysql.HasPriKeyFlag(col.Flag) && handle.IsInt():
+			if mysql.HasUnsignedFlag(col.Flag) {
+				d = types.NewUintDatum(uint64(handle.IntValue()))
+			} else {
+				d = types.NewIntDatum(handle.IntValue())
+			}
+		default:
+			// The column is added after the row is written.
+			if d, err = table.GetColOriginDefaultValue(sctx, col); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		datums = append(datums, d)
+	}
+	return datums, nil
+}
+
+// datumToSQLArg converts the datum into the argument of a statement.
+func datumToSQLArg(d types.Datum) interface{} {
+	switch d.Kind() {
+	case types.KindNull:
+		return nil
+	case types.KindInt64:
+		return d.GetInt64()
+	case types.KindUint64:
+		return d.GetUint64()
+	case types.KindFloat32, types.KindFloat64:
+		return d.GetFloat64()
+	case types.KindString:
+		return d.GetString()
+	case types.KindBytes:
+		return d.GetBytes()
+	case types.KindBinaryLiteral, types.KindMysqlBit:
+		return []byte(d.GetBinaryLiteral())
+	default:
+		// e.g. decimal, time, duration, enum, set and JSON.
+		s, err := d.ToString()
+		if err != nil {
+			return nil
+		}
+		return s
+	}
+}
+
+// decodeTxnKey decodes the key of the SST file into the user key and the TS.
+func decodeTxnKey(key []byte) ([]byte, uint64, error) {
+	if len(key) > 0 && key[0] == dataKeyPrefix {
+		key = key[1:]
+	}
+	rest, userKey, err := codec.DecodeBytes(key, nil)
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	if len(rest) != 8 {
+		return nil, 0, errors.Annotate(berrors.ErrRestoreInvalidSST, "invalid ts of the key")
+	}
+	return userKey, ^binary.BigEndian.Uint64(rest), nil
+}
+
+// decodeWriteRecord decodes the write record of TiKV, the short value is nil
+// if the value is in the default CF.
+func decodeWriteRecord(b []byte) (writeType byte, startTS uint64, shortValue []byte, err error) {
+	if len(b) == 0 {
+		return 0, 0, nil, errors.Annotate(berrors.ErrRestoreInvalidSST, "empty write record")
+	}
+	writeType = b[0]
+	startTS, n := binary.Uvarint(b[1:])
+	if n <= 0 {
+		return 0, 0, nil, errors.Annotate(berrors.ErrRestoreInvalidSST, "invalid start ts of the write record")
+	}
+	b = b[1+n:]
+	if len(b) >= 2 && b[0] == shortValuePrefix {
+		size := int(b[1])
+		if len(b) < 2+size {
+			return 0, 0, nil, errors.Annotate(berrors.ErrRestoreInvalidSST, "invalid short value of the write record")
+		}
+		shortValue = b[2 : 2+size]
+	}
+	return writeType, startTS, shortValue, nil
+}
+
+func defaultCFKey(userKey []byte, startTS uint64) string {
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], startTS)
+	return string(userKey) + string(ts[:])
+}
+
+// ImportTable implements ImportBackend, it inserts the rows of the table.
+func (r *LogicalRestorer) ImportTable(ctx context.Context, tbl *utils.Table, updateCh glue.Progress) error {
+	rows, err := r.RestoreTable(ctx, tbl, updateCh)
+	atomic.AddInt64(&r.rows, int64(rows))
+	return errors.Trace(err)
+}
+
+// Rows returns the count of the rows inserted by ImportTable.
+func (r *LogicalRestorer) Rows() int64 {
+	return atomic.LoadInt64(&r.rows)
+}
+
+// Close implements ImportBackend.
+func (r *LogicalRestorer) Close() error {
+	if r.db == nil {
+		return nil
+	}
+	return errors.Trace(r.db.Close())
+}
+
+// RestoreTable inserts the rows of the table in the backup files, it returns
+// the count of the rows inserted. The progress increases by every write CF
+// file restored.
+func (r *LogicalRestorer) RestoreTable(ctx context.Context, tbl *utils.Table, updateCh glue.Progress) (int, error) {
+	t := newLogicalTable(tbl)
+	if len(t.columns) == 0 {
+		return 0, nil
+	}
+	if r.filter != nil {
+		if err := r.filter.apply(t); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	batchSize := r.batchSize
+	if batchSize*len(t.columns) > maxPlaceholders {
+		batchSize = maxPlaceholders / len(t.columns)
+	}
+
+	rows := 0
+	args := make([]interface{}, 0, batchSize*len(t.columns))
+	batchRows := 0
+	flush := func() error {
+		if batchRows == 0 {
+			return nil
+		}
+		stmt := t.prefix + strings.Repeat(",("+strings.Repeat(",?", len(t.columns))[1:]+")", batchRows)[1:]
+		if _, err := r.db.ExecContext(ctx, stmt, args...); err != nil {
+			return errors.Annotatef(err, "failed to insert into %s.%s", tbl.DB.Name, tbl.Info.Name)
+		}
+		rows += batchRows
+		args, batchRows = args[:0], 0
+		return nil
+	}
+	err := iterateRecords(ctx, r.storage, t, func(key, value []byte) error {
+		var err error
+		if args, err = r.decodeRow(t, key, value, args); err != nil {
+			return errors.Trace(err)
+		}
+		batchRows++
+		if batchRows >= batchSize {
+			return errors.Trace(flush())
+		}
+		return nil
+	}, func(file *backup.File) error {
+		if err := flush(); err != nil {
+			return errors.Annotatef(err, "failed to restore file %s", file.GetName())
+		}
+		updateCh.Inc()
+		return nil
+	})
+	if err != nil {
+		return rows, errors.Trace(err)
+	}
+	log.Info("restore table logically",
+		zap.Stringer("db", tbl.DB.Name),
+		zap.Stringer("table", tbl.Info.Name),
+		zap.Int("rows", rows))
+	return rows, nil
+}
+
+// mayContain returns whether the write CF file may contain the rows of the
+// table in the handle range.
+func (t *logicalTable) mayContain(file *backup.File) bool {
+	if t.handles == nil {
+		return true
+	}
+	for id := range t.ids {
+		if t.handles.overlaps(file, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// iterateRecords calls fn with the record keys and the row values of the
+// table in the write CF files of the table, and done after every file.
+func iterateRecords(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	t *logicalTable,
+	fn func(key, value []byte) error,
+	done func(file *backup.File) error,
+) error {
+	defaultFiles := make(map[string]*backup.File)
+	for _, file := range t.tbl.Files {
+		if strings.HasSuffix(file.GetName(), "_default.sst") {
+			defaultFiles[strings.TrimSuffix(file.GetName(), "_default.sst")] = file
+		}
+	}
+	for _, file := range t.tbl.Files {
+		if !strings.HasSuffix(file.GetName(), "_write.sst") {
+			continue
+		}
+		if !t.mayContain(file) {
+			if err := done(file); err != nil {
+				return errors.Trace(err)
+			}
+			continue
+		}
+		values := make(map[string][]byte)
+		if defaultFile, ok := defaultFiles[strings.TrimSuffix(file.GetName(), "_write.sst")]; ok {
+			err := iterateFile(ctx, s, defaultFile, func(key []byte, ts uint64, value []byte) error {
+				values[defaultCFKey(key, ts)] = append([]byte{}, value...)
+				return nil
+			})
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+		err := iterateFile(ctx, s, file, func(key []byte, _ uint64, record []byte) error {
+			if !tablecodec.IsRecordKey(key) {
+				// The indexes are built by the inserts.
+				return nil
+			}
+			if _, ok := t.ids[tablecodec.DecodeTableID(key)]; !ok {
+				return nil
+			}
+			if t.handles != nil {
+				_, handle, err := tablecodec.DecodeRecordKey(key)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				if !handle.IsInt() || !t.handles.Contains(handle.IntValue()) {
+					return nil
+				}
+			}
+			writeType, startTS, value, err := decodeWriteRecord(record)
+			if err != nil || writeType != writeTypePut {
+				return errors.Trace(err)
+			}
+			if value == nil {
+				var ok bool
+				if value, ok = values[defaultCFKey(key, startTS)]; !ok {
+					return errors.Annotatef(berrors.ErrRestoreInvalidSST,
+						"the value of the key %X isn't in the default CF", key)
+				}
+			}
+			return fn(key, value)
+		})
+		if err != nil {
+			return errors.Annotatef(err, "failed to read file %s", file.GetName())
+		}
+		if err = done(file); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// iterateFile calls the function with the user keys, the TS and the values of
+// the SST file.
+func iterateFile(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	file *backup.File,
+	fn func(key []byte, ts uint64, value []byte) error,
+) error {
+	// The blocks are read one by one, instead of the whole file.
+	f, err := s.Open(ctx, file.GetName())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	reader, err := newSSTReader(f)
+	if err != nil {
+		return errors.Annotatef(err, "failed to read file %s", file.GetName())
+	}
+	return reader.Iterate(func(key, value []byte) error {
+		userKey, ts, err := decodeTxnKey(key)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return fn(userKey, ts, value)
+	})
+}
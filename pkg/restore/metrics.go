@@ -0,0 +1,57 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	restoreRegionCacheBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "br",
+			Subsystem: "restore",
+			Name:      "region_cache_bytes",
+			Help:      "Approximate memory usage of the region cache.",
+		})
+
+	restoreRegionCacheRegions = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "br",
+			Subsystem: "restore",
+			Name:      "region_cache_regions",
+			Help:      "Count of the regions in the region cache.",
+		})
+
+	restoreRegionCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "br",
+			Subsystem: "restore",
+			Name:      "region_cache_requests",
+			Help:      "Region cache lookups by the result, hit or miss.",
+		}, []string{"result"})
+
+	restoreStoreQueuedRequests = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "br",
+			Subsystem: "restore",
+			Name:      "store_queued_requests",
+			Help:      "Count of the download and ingest requests waiting for a slot of the store.",
+		}, []string{"store", "type"})
+
+	restoreStoreInflightRequests = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "br",
+			Subsystem: "restore",
+			Name:      "store_inflight_requests",
+			Help:      "Count of the download and ingest requests in flight to the store.",
+		}, []string{"store", "type"})
+)
+
+func init() { // nolint:gochecknoinits
+	prometheus.MustRegister(restoreRegionCacheBytes)
+	prometheus.MustRegister(restoreRegionCacheRegions)
+	prometheus.MustRegister(restoreRegionCacheRequests)
+	prometheus.MustRegister(restoreStoreQueuedRequests)
+	prometheus.MustRegister(restoreStoreInflightRequests)
+}
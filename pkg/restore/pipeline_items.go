@@ -13,6 +13,7 @@ import (
 
 	"github.com/pingcap/br/pkg/glue"
 	"github.com/pingcap/br/pkg/rtree"
+	"github.com/pingcap/br/pkg/summary"
 	"github.com/pingcap/br/pkg/utils"
 )
 
@@ -113,7 +114,11 @@ func (manager *brContextManager) Leave(ctx context.Context, tables []CreatedTabl
 }
 
 func splitPostWork(ctx context.Context, client *Client, tables []*model.TableInfo) {
-	err := client.ResetPlacementRules(ctx, tables)
+	err := client.PromoteLearners(ctx, tables)
+	if err != nil {
+		log.Warn("promote learners failed", zap.Error(err))
+	}
+	err = client.ResetPlacementRules(ctx, tables)
 	if err != nil {
 		log.Warn("reset placement rules failed", zap.Error(err))
 		return
@@ -176,8 +181,9 @@ type BatchSender interface {
 }
 
 type tikvSender struct {
-	client   *Client
-	updateCh glue.Progress
+	client    *Client
+	splitCh   glue.Progress
+	restoreCh glue.Progress
 
 	sink TableSink
 	inCh chan<- DrainResult
@@ -196,19 +202,22 @@ func (b *tikvSender) RestoreBatch(ranges DrainResult) {
 }
 
 // NewTiKVSender make a sender that send restore requests to TiKV.
+// The progress of split and restore are reported to splitCh and restoreCh.
 func NewTiKVSender(
 	ctx context.Context,
 	cli *Client,
-	updateCh glue.Progress,
+	splitCh glue.Progress,
+	restoreCh glue.Progress,
 ) (BatchSender, error) {
 	inCh := make(chan DrainResult, defaultChannelSize)
 	midCh := make(chan DrainResult, defaultChannelSize)
 
 	sender := &tikvSender{
-		client:   cli,
-		updateCh: updateCh,
-		inCh:     inCh,
-		wg:       new(sync.WaitGroup),
+		client:    cli,
+		splitCh:   splitCh,
+		restoreCh: restoreCh,
+		inCh:      inCh,
+		wg:        new(sync.WaitGroup),
 	}
 
 	sender.wg.Add(2)
@@ -231,7 +240,10 @@ func (b *tikvSender) splitWorker(ctx context.Context, ranges <-chan DrainResult,
 			if !ok {
 				return
 			}
-			if err := SplitRanges(ctx, b.client, result.Ranges, result.RewriteRules, b.updateCh); err != nil {
+			summary.RegisterStage(summary.StageSplit)
+			err := SplitRanges(ctx, b.client, result.Ranges, result.RewriteRules, b.splitCh)
+			summary.EndStage(summary.StageSplit)
+			if err != nil {
 				log.Error("failed on split range", rtree.ZapRanges(result.Ranges), zap.Error(err))
 				b.sink.EmitError(err)
 				return
@@ -256,7 +268,10 @@ func (b *tikvSender) restoreWorker(ctx context.Context, ranges <-chan DrainResul
 				return
 			}
 			files := result.Files()
-			if err := b.client.RestoreFiles(ctx, files, result.RewriteRules, b.updateCh); err != nil {
+			summary.RegisterStage(summary.StageDownloadIngest)
+			err := b.client.RestoreFiles(ctx, files, result.RewriteRules, b.restoreCh)
+			summary.EndStage(summary.StageDownloadIngest)
+			if err != nil {
 				b.sink.EmitError(err)
 				return
 			}
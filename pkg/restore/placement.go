@@ -0,0 +1,137 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+	"github.com/tikv/pd/server/schedule/placement"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+const (
+	placementMappingRuleGroup = "pd"
+	// placementMappingRuleIndex is lower than the index 100 of the rules set
+	// by online restore. PD applies the rules in the ascending order of the
+	// index, and an overriding rule of a higher index wins, so the restore
+	// rules win during the restore and the mapping takes effect once they're
+	// removed.
+	placementMappingRuleIndex = 50
+)
+
+// PlacementTemplate is the placement rule template applied to a restored table.
+type PlacementTemplate struct {
+	Role             placement.PeerRoleType      `json:"role"`
+	Count            int                         `json:"count"`
+	LabelConstraints []placement.LabelConstraint `json:"label_constraints"`
+}
+
+// PlacementMapping maps restored tables to placement rule templates.
+//
+// The keys are in the form of `db.table` or `db.*`, the template of
+// `db.table` takes precedence over `db.*`. Names are case insensitive.
+type PlacementMapping struct {
+	templates map[string]*PlacementTemplate
+}
+
+// ParsePlacementMapping parses the placement mapping from a JSON object, e.g.
+//
+//	{"test.t1": {"count": 3, "label_constraints": [{"key": "zone", "op": "in", "values": ["z1"]}]}}
+func ParsePlacementMapping(data []byte) (*PlacementMapping, error) {
+	raw := make(map[string]*PlacementTemplate)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, err.Error())
+	}
+	templates := make(map[string]*PlacementTemplate, len(raw))
+	for name, tmpl := range raw {
+		parts := strings.Split(name, ".")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"invalid placement mapping key %s, must be `db.table` or `db.*`", name)
+		}
+		if tmpl == nil || len(tmpl.LabelConstraints) == 0 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"placement mapping of %s has no label constraints", name)
+		}
+		if tmpl.Role == "" {
+			tmpl.Role = placement.Voter
+		}
+		if tmpl.Count <= 0 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"placement mapping of %s must have a positive count", name)
+		}
+		templates[strings.ToLower(name)] = tmpl
+	}
+	return &PlacementMapping{templates: templates}, nil
+}
+
+// Lookup returns the placement template of the table, or nil if not specified.
+func (m *PlacementMapping) Lookup(db, table string) *PlacementTemplate {
+	db, table = strings.ToLower(db), strings.ToLower(table)
+	if tmpl, ok := m.templates[db+"."+table]; ok {
+		return tmpl
+	}
+	return m.templates[db+".*"]
+}
+
+// Len returns the number of templates in the mapping.
+func (m *PlacementMapping) Len() int {
+	return len(m.templates)
+}
+
+// SetPlacementMapping sets the placement mapping applied as tables are restored.
+func (rc *Client) SetPlacementMapping(m *PlacementMapping) {
+	rc.placementMapping = m
+}
+
+// applyPlacementMapping sets the placement rules of the restored table
+// according to the placement mapping, so the data lands on the desired stores
+// directly instead of being rebalanced after restoration.
+func (rc *Client) applyPlacementMapping(ctx context.Context, dbName string, table *model.TableInfo) error {
+	if rc.placementMapping == nil {
+		return nil
+	}
+	tmpl := rc.placementMapping.Lookup(dbName, table.Name.O)
+	if tmpl == nil {
+		return nil
+	}
+	ids := []int64{table.ID}
+	if pi := table.GetPartitionInfo(); pi != nil {
+		for _, def := range pi.Definitions {
+			ids = append(ids, def.ID)
+		}
+	}
+	for _, id := range ids {
+		rule := placement.Rule{
+			GroupID:          placementMappingRuleGroup,
+			ID:               "br-placement-t" + strconv.FormatInt(id, 10),
+			Index:            placementMappingRuleIndex,
+			Override:         true,
+			StartKeyHex:      hex.EncodeToString(codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(id))),
+			EndKeyHex:        hex.EncodeToString(codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(id+1))),
+			Role:             tmpl.Role,
+			Count:            tmpl.Count,
+			LabelConstraints: tmpl.LabelConstraints,
+		}
+		if err := rc.toolClient.SetPlacementRule(ctx, rule); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	log.Info("placement rule applied",
+		zap.String("db", dbName),
+		zap.Stringer("table", table.Name),
+		zap.Int("count", tmpl.Count),
+		zap.Any("label-constraints", tmpl.LabelConstraints))
+	return nil
+}
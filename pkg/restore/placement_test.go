@@ -0,0 +1,41 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/tikv/pd/server/schedule/placement"
+
+	"github.com/pingcap/br/pkg/restore"
+)
+
+var _ = Suite(&testPlacementSuite{})
+
+type testPlacementSuite struct{}
+
+func (s *testPlacementSuite) TestParsePlacementMapping(c *C) {
+	m, err := restore.ParsePlacementMapping([]byte(`{
+		"test.t1": {"count": 3, "label_constraints": [{"key": "zone", "op": "in", "values": ["z1"]}]},
+		"Test.*": {"role": "follower", "count": 1, "label_constraints": [{"key": "zone", "op": "in", "values": ["z2"]}]}
+	}`))
+	c.Assert(err, IsNil)
+	c.Assert(m.Len(), Equals, 2)
+
+	tmpl := m.Lookup("TEST", "T1")
+	c.Assert(tmpl, NotNil)
+	c.Assert(tmpl.Role, Equals, placement.Voter)
+	c.Assert(tmpl.Count, Equals, 3)
+	c.Assert(tmpl.LabelConstraints[0].Values, DeepEquals, []string{"z1"})
+
+	tmpl = m.Lookup("test", "t2")
+	c.Assert(tmpl, NotNil)
+	c.Assert(tmpl.Role, Equals, placement.Follower)
+	c.Assert(m.Lookup("other", "t1"), IsNil)
+
+	_, err = restore.ParsePlacementMapping([]byte(`{"test": {"count": 1, "label_constraints": [{"key": "zone", "op": "in", "values": ["z1"]}]}}`))
+	c.Assert(err, ErrorMatches, ".*invalid placement mapping key.*")
+	_, err = restore.ParsePlacementMapping([]byte(`{"test.t1": {"count": 1}}`))
+	c.Assert(err, ErrorMatches, ".*no label constraints.*")
+	_, err = restore.ParsePlacementMapping([]byte(`{"test.t1": {"label_constraints": [{"key": "zone", "op": "in", "values": ["z1"]}]}}`))
+	c.Assert(err, ErrorMatches, ".*positive count.*")
+}
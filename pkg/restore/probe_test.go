@@ -0,0 +1,56 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"net"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type testProbeSuite struct{}
+
+var _ = Suite(&testProbeSuite{})
+
+func (s *testProbeSuite) TestCheckHealth(c *C) {
+	ctx := context.Background()
+	dial := func(server *grpc.Server) *grpc.ClientConn {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		c.Assert(err, IsNil)
+		go func() { _ = server.Serve(lis) }()
+		conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+		c.Assert(err, IsNil)
+		return conn
+	}
+
+	hs := health.NewServer()
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, hs)
+	defer server.Stop()
+	conn := dial(server)
+	defer conn.Close()
+	c.Assert(checkHealth(ctx, conn), IsNil)
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	c.Assert(checkHealth(ctx, conn), ErrorMatches, ".*the store is NOT_SERVING.*")
+
+	// The stores not serving the health checking are assumed healthy.
+	legacy := grpc.NewServer()
+	defer legacy.Stop()
+	legacyConn := dial(legacy)
+	defer legacyConn.Close()
+	c.Assert(checkHealth(ctx, legacyConn), IsNil)
+}
+
+func (s *testProbeSuite) TestUsedStores(c *C) {
+	stores := []*metapb.Store{{Id: 1}, {Id: 2}, {Id: 3}}
+	c.Assert(usedStores(stores, nil), HasLen, 3)
+	used := usedStores(stores, []uint64{3, 1})
+	c.Assert(used, HasLen, 2)
+	c.Assert(used[0].GetId(), Equals, uint64(1))
+	c.Assert(used[1].GetId(), Equals, uint64(3))
+}
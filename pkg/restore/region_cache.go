@@ -0,0 +1,228 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+
+	"github.com/google/btree"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+const (
+	// DefaultRegionCacheCapacity is the default max count of the regions
+	// cached by restore, it takes about 30MiB memory.
+	DefaultRegionCacheCapacity = 65536
+
+	// regionCacheItemOverhead is the approximate memory of a cached region
+	// besides its protobuf message.
+	regionCacheItemOverhead = 160
+)
+
+// cachedRegion is a region in the region cache, ordered by the start key.
+type cachedRegion struct {
+	info *RegionInfo
+	elem *list.Element
+	size int
+}
+
+// Less impls btree.Item.
+func (r *cachedRegion) Less(than btree.Item) bool {
+	return bytes.Compare(r.info.Region.GetStartKey(), than.(*cachedRegion).info.Region.GetStartKey()) < 0
+}
+
+func (r *cachedRegion) contains(key []byte) bool {
+	end := r.info.Region.GetEndKey()
+	return bytes.Compare(r.info.Region.GetStartKey(), key) <= 0 &&
+		(len(end) == 0 || bytes.Compare(key, end) < 0)
+}
+
+// overlaps returns whether the region overlaps [startKey, endKey), an empty
+// endKey means the end of the key space.
+func (r *cachedRegion) overlaps(startKey, endKey []byte) bool {
+	end := r.info.Region.GetEndKey()
+	return (len(endKey) == 0 || bytes.Compare(r.info.Region.GetStartKey(), endKey) < 0) &&
+		(len(end) == 0 || bytes.Compare(startKey, end) < 0)
+}
+
+func regionCachePivot(key []byte) *cachedRegion {
+	return &cachedRegion{info: &RegionInfo{Region: &metapb.Region{StartKey: key}}}
+}
+
+// regionCache is a bounded LRU cache of the regions, so the files in the same
+// regions needn't scan the regions from PD again. For the clusters with
+// millions of regions, the count of cached regions is bounded, and the least
+// recently used regions are evicted.
+//
+// The cached regions may be stale, the users should invalidate them once the
+// regions are known changed, e.g. split or epoch not match.
+type regionCache struct {
+	mu       sync.Mutex
+	capacity int
+	tree     *btree.BTree
+	// lru is the cached regions, the most recently used one at the front.
+	lru   *list.List
+	bytes int
+
+	hits, misses int
+}
+
+func newRegionCache(capacity int) *regionCache {
+	return &regionCache{
+		capacity: capacity,
+		tree:     btree.New(32),
+		lru:      list.New(),
+	}
+}
+
+// lookup returns the cached regions covering [startKey, endKey) in order, or
+// nil if any part of the range isn't cached.
+func (c *regionCache) lookup(startKey, endKey []byte) []*RegionInfo {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var regions []*cachedRegion
+	c.tree.DescendLessOrEqual(regionCachePivot(startKey), func(i btree.Item) bool {
+		if r := i.(*cachedRegion); r.contains(startKey) {
+			regions = append(regions, r)
+		}
+		return false
+	})
+	covered := len(regions) > 0
+	for covered {
+		last := regions[len(regions)-1].info.Region
+		end := last.GetEndKey()
+		if len(end) == 0 || (len(endKey) != 0 && bytes.Compare(end, endKey) >= 0) {
+			break
+		}
+		var next *cachedRegion
+		c.tree.AscendGreaterOrEqual(regionCachePivot(end), func(i btree.Item) bool {
+			next = i.(*cachedRegion)
+			return false
+		})
+		if next == nil || !bytes.Equal(next.info.Region.GetStartKey(), end) {
+			covered = false
+			break
+		}
+		regions = append(regions, next)
+	}
+	if !covered {
+		c.misses++
+		restoreRegionCacheRequests.WithLabelValues("miss").Inc()
+		return nil
+	}
+	c.hits++
+	restoreRegionCacheRequests.WithLabelValues("hit").Inc()
+	infos := make([]*RegionInfo, 0, len(regions))
+	for _, r := range regions {
+		c.lru.MoveToFront(r.elem)
+		infos = append(infos, r.info)
+	}
+	return infos
+}
+
+// insert caches the regions, the cached regions overlapping them are
+// replaced.
+func (c *regionCache) insert(regions []*RegionInfo) {
+	if c == nil || c.capacity <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, info := range regions {
+		c.removeRangeLocked(info.Region.GetStartKey(), info.Region.GetEndKey())
+		r := &cachedRegion{
+			info: info,
+			size: info.Region.Size() + info.Leader.Size() + regionCacheItemOverhead,
+		}
+		r.elem = c.lru.PushFront(r)
+		c.tree.ReplaceOrInsert(r)
+		c.bytes += r.size
+	}
+	for c.lru.Len() > c.capacity {
+		c.removeLocked(c.lru.Back().Value.(*cachedRegion))
+	}
+	c.updateMetricsLocked()
+}
+
+// invalidateKey removes the cached region containing the key.
+func (c *regionCache) invalidateKey(key []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var found *cachedRegion
+	c.tree.DescendLessOrEqual(regionCachePivot(key), func(i btree.Item) bool {
+		if r := i.(*cachedRegion); r.contains(key) {
+			found = r
+		}
+		return false
+	})
+	if found != nil {
+		c.removeLocked(found)
+		c.updateMetricsLocked()
+	}
+}
+
+// invalidateRange removes the cached regions overlapping [startKey, endKey).
+func (c *regionCache) invalidateRange(startKey, endKey []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeRangeLocked(startKey, endKey)
+	c.updateMetricsLocked()
+}
+
+func (c *regionCache) removeRangeLocked(startKey, endKey []byte) {
+	var overlapped []*cachedRegion
+	c.tree.DescendLessOrEqual(regionCachePivot(startKey), func(i btree.Item) bool {
+		if r := i.(*cachedRegion); r.overlaps(startKey, endKey) {
+			overlapped = append(overlapped, r)
+		}
+		return false
+	})
+	c.tree.AscendGreaterOrEqual(regionCachePivot(startKey), func(i btree.Item) bool {
+		r := i.(*cachedRegion)
+		if !r.overlaps(startKey, endKey) {
+			return false
+		}
+		overlapped = append(overlapped, r)
+		return true
+	})
+	for _, r := range overlapped {
+		c.removeLocked(r)
+	}
+}
+
+func (c *regionCache) removeLocked(r *cachedRegion) {
+	if c.tree.Delete(r) == nil {
+		// Already removed, the region is found twice if it starts at the key.
+		return
+	}
+	c.lru.Remove(r.elem)
+	c.bytes -= r.size
+}
+
+func (c *regionCache) updateMetricsLocked() {
+	restoreRegionCacheBytes.Set(float64(c.bytes))
+	restoreRegionCacheRegions.Set(float64(c.lru.Len()))
+}
+
+// stats returns the count of the hits and misses, and the memory usage of
+// the cache.
+func (c *regionCache) stats() (hits, misses, size int) {
+	if c == nil {
+		return 0, 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses, c.bytes
+}
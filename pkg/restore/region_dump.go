@@ -0,0 +1,126 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// The stages of the region layout dumped by RegionDumper.
+const (
+	RegionStageBeforeSplit  = "before-split"
+	RegionStageAfterSplit   = "after-split"
+	RegionStageAfterScatter = "after-scatter"
+)
+
+// RegionLayout is the layout of a region in a snapshot.
+type RegionLayout struct {
+	ID uint64 `json:"id"`
+	// StartKey and EndKey are the encoded keys in hex.
+	StartKey string `json:"start-key"`
+	EndKey   string `json:"end-key"`
+	// LeaderStore is the store of the leader, zero means no leader.
+	LeaderStore uint64   `json:"leader-store"`
+	Stores      []uint64 `json:"stores"`
+}
+
+// RegionSnapshot is the layout of the regions of a range at a stage.
+type RegionSnapshot struct {
+	Stage    string         `json:"stage"`
+	Time     time.Time      `json:"time"`
+	StartKey string         `json:"start-key"`
+	EndKey   string         `json:"end-key"`
+	Regions  []RegionLayout `json:"regions"`
+}
+
+// RegionDumper records the region layout of the restored ranges before split,
+// after split and after scatter, so the balance problems can be analyzed
+// offline without access to the cluster.
+type RegionDumper struct {
+	mu        sync.Mutex
+	snapshots []RegionSnapshot
+}
+
+// NewRegionDumper creates a region dumper.
+func NewRegionDumper() *RegionDumper {
+	return &RegionDumper{}
+}
+
+// snapshot records the layout of the regions in the encoded range. A nil
+// dumper records nothing. The snapshot is only for debugging, so the errors
+// are logged instead of failing the restore.
+func (d *RegionDumper) snapshot(ctx context.Context, client SplitClient, stage string, startKey, endKey []byte) {
+	if d == nil {
+		return
+	}
+	regions, err := PaginateScanRegion(ctx, client, startKey, endKey, scanRegionPaginationLimit)
+	if err != nil {
+		log.Warn("failed to scan regions for the region dump", zap.String("stage", stage), zap.Error(err))
+		return
+	}
+	d.record(stage, startKey, endKey, regions)
+}
+
+func (d *RegionDumper) record(stage string, startKey, endKey []byte, regions []*RegionInfo) {
+	snapshot := RegionSnapshot{
+		Stage:    stage,
+		Time:     time.Now(),
+		StartKey: hex.EncodeToString(startKey),
+		EndKey:   hex.EncodeToString(endKey),
+		Regions:  make([]RegionLayout, 0, len(regions)),
+	}
+	for _, region := range regions {
+		layout := RegionLayout{
+			ID:       region.Region.GetId(),
+			StartKey: hex.EncodeToString(region.Region.GetStartKey()),
+			EndKey:   hex.EncodeToString(region.Region.GetEndKey()),
+			Stores:   make([]uint64, 0, len(region.Region.GetPeers())),
+		}
+		if region.Leader != nil {
+			layout.LeaderStore = region.Leader.GetStoreId()
+		}
+		for _, peer := range region.Region.GetPeers() {
+			layout.Stores = append(layout.Stores, peer.GetStoreId())
+		}
+		snapshot.Regions = append(snapshot.Regions, layout)
+	}
+	d.mu.Lock()
+	d.snapshots = append(d.snapshots, snapshot)
+	d.mu.Unlock()
+}
+
+// Snapshots returns the snapshots recorded so far.
+func (d *RegionDumper) Snapshots() []RegionSnapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]RegionSnapshot{}, d.snapshots...)
+}
+
+// Save writes the snapshots into the file as gzipped JSON.
+func (d *RegionDumper) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	w := gzip.NewWriter(f)
+	encodeErr := json.NewEncoder(w).Encode(struct {
+		Snapshots []RegionSnapshot `json:"snapshots"`
+	}{Snapshots: d.Snapshots()})
+	if err = w.Close(); encodeErr == nil {
+		encodeErr = err
+	}
+	if err = f.Close(); encodeErr == nil {
+		encodeErr = err
+	}
+	return errors.Trace(encodeErr)
+}
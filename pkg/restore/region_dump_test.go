@@ -0,0 +1,56 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+type testRegionDumpSuite struct{}
+
+var _ = Suite(&testRegionDumpSuite{})
+
+func (*testRegionDumpSuite) TestSaveRegionDump(c *C) {
+	var dumper *RegionDumper
+	// A nil dumper records nothing.
+	dumper.snapshot(context.Background(), nil, RegionStageBeforeSplit, nil, nil)
+
+	dumper = NewRegionDumper()
+	dumper.record(RegionStageAfterSplit, []byte{'a'}, []byte{'c'}, []*RegionInfo{
+		{
+			Region: &metapb.Region{
+				Id: 1, StartKey: []byte{'a'}, EndKey: []byte{'b'},
+				Peers: []*metapb.Peer{{Id: 2, StoreId: 1}, {Id: 3, StoreId: 2}},
+			},
+			Leader: &metapb.Peer{Id: 3, StoreId: 2},
+		},
+		{Region: &metapb.Region{Id: 4, StartKey: []byte{'b'}, EndKey: []byte{'c'}}},
+	})
+	path := filepath.Join(c.MkDir(), "regions.json.gz")
+	c.Assert(dumper.Save(path), IsNil)
+
+	f, err := os.Open(path)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	c.Assert(err, IsNil)
+	var dump struct {
+		Snapshots []RegionSnapshot `json:"snapshots"`
+	}
+	c.Assert(json.NewDecoder(r).Decode(&dump), IsNil)
+	c.Assert(dump.Snapshots, HasLen, 1)
+	snapshot := dump.Snapshots[0]
+	c.Assert(snapshot.Stage, Equals, RegionStageAfterSplit)
+	c.Assert(snapshot.StartKey, Equals, "61")
+	c.Assert(snapshot.Regions, DeepEquals, []RegionLayout{
+		{ID: 1, StartKey: "61", EndKey: "62", LeaderStore: 2, Stores: []uint64{1, 2}},
+		{ID: 4, StartKey: "62", EndKey: "63", Stores: []uint64{}},
+	})
+}
@@ -0,0 +1,337 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+	"github.com/tikv/pd/server/schedule/placement"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/glue"
+)
+
+const (
+	// defaultRegionLabelKey is the store label of the regions of the cluster.
+	defaultRegionLabelKey = "region"
+	// regionTopologyRulePrefix is the prefix of the IDs of the placement rules
+	// set by the region topology.
+	regionTopologyRulePrefix = "br-region-t"
+	// DefaultRegionPlacementWaitInterval is the interval of polling the
+	// placement of the restored regions.
+	DefaultRegionPlacementWaitInterval = 10 * time.Second
+)
+
+// RegionPlacement is the regions of the replicas of a database, the leaders
+// are in the primary region, and every replica region has a voter.
+type RegionPlacement struct {
+	Primary  string   `json:"primary"`
+	Replicas []string `json:"replicas"`
+}
+
+func (p *RegionPlacement) validate(name string) error {
+	if p.Primary == "" {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "region topology of %s has no primary region", name)
+	}
+	seen := map[string]struct{}{p.Primary: {}}
+	for _, r := range p.Replicas {
+		if _, ok := seen[r]; ok || r == "" {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"region topology of %s has an empty or duplicated replica region %q", name, r)
+		}
+		seen[r] = struct{}{}
+	}
+	return nil
+}
+
+// RegionTopology is the multi-region topology the databases are restored in,
+// it's applied as placement rules of the restored tables, and the placement
+// of their regions is verified after restore.
+type RegionTopology struct {
+	// LabelKey is the store label of the regions, "region" by default.
+	LabelKey string `json:"label-key"`
+	// RegionPlacement is the placement of the databases not overridden.
+	RegionPlacement
+	// Databases overrides the placement of the databases, the names are case
+	// insensitive.
+	Databases map[string]*RegionPlacement `json:"databases"`
+}
+
+// ParseRegionTopology parses the region topology from a JSON object, e.g.
+//
+//	{"primary": "us-east", "replicas": ["us-west", "eu-central"],
+//	 "databases": {"tenant_eu": {"primary": "eu-central", "replicas": ["us-east"]}}}
+func ParseRegionTopology(data []byte) (*RegionTopology, error) {
+	t := &RegionTopology{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, err.Error())
+	}
+	if t.LabelKey == "" {
+		t.LabelKey = defaultRegionLabelKey
+	}
+	if err := t.RegionPlacement.validate("the cluster"); err != nil {
+		return nil, errors.Trace(err)
+	}
+	databases := make(map[string]*RegionPlacement, len(t.Databases))
+	for name, p := range t.Databases {
+		if p == nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "region topology of %s is empty", name)
+		}
+		if err := p.validate(name); err != nil {
+			return nil, errors.Trace(err)
+		}
+		databases[strings.ToLower(name)] = p
+	}
+	t.Databases = databases
+	return t, nil
+}
+
+// voters returns the count of the voters of the placement, i.e. the leader
+// and a voter in every replica region.
+func (p *RegionPlacement) voters() int {
+	return 1 + len(p.Replicas)
+}
+
+// CheckReplicas checks every placement of the topology places at least
+// max-replicas voters, the rules override the default rule of PD so the
+// regions are otherwise restored with less replicas than the cluster expects.
+func (t *RegionTopology) CheckReplicas(maxReplicas int) error {
+	check := func(name string, p *RegionPlacement) error {
+		if p.voters() < maxReplicas {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"region topology of %s places %d voters, less than the max-replicas %d of the cluster",
+				name, p.voters(), maxReplicas)
+		}
+		return nil
+	}
+	if err := check("the cluster", &t.RegionPlacement); err != nil {
+		return errors.Trace(err)
+	}
+	for name, p := range t.Databases {
+		if err := check(name, p); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// Lookup returns the placement of the database.
+func (t *RegionTopology) Lookup(db string) *RegionPlacement {
+	if p, ok := t.Databases[strings.ToLower(db)]; ok {
+		return p
+	}
+	return &t.RegionPlacement
+}
+
+// rules returns the placement rules of the physical table.
+func (t *RegionTopology) rules(p *RegionPlacement, physicalID int64) []placement.Rule {
+	id := regionTopologyRulePrefix + strconv.FormatInt(physicalID, 10)
+	rule := func(suffix string, role placement.PeerRoleType, region string) placement.Rule {
+		return placement.Rule{
+			GroupID:     placementMappingRuleGroup,
+			ID:          id + "-" + suffix,
+			Index:       placementMappingRuleIndex,
+			Override:    true,
+			StartKeyHex: hex.EncodeToString(codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(physicalID))),
+			EndKeyHex:   hex.EncodeToString(codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(physicalID+1))),
+			Role:        role,
+			Count:       1,
+			LabelConstraints: []placement.LabelConstraint{
+				{Key: t.LabelKey, Op: placement.In, Values: []string{region}},
+			},
+		}
+	}
+	rules := make([]placement.Rule, 0, len(p.Replicas)+1)
+	rules = append(rules, rule("leader", placement.Leader, p.Primary))
+	for i, region := range p.Replicas {
+		rules = append(rules, rule("voter"+strconv.Itoa(i), placement.Voter, region))
+	}
+	return rules
+}
+
+// placedTable is a restored table whose regions are placed by the topology.
+type placedTable struct {
+	db        string
+	table     string
+	placement *RegionPlacement
+	ids       []int64
+}
+
+// placedTables collects the tables placed as they are created.
+type placedTables struct {
+	mu     sync.Mutex
+	tables []placedTable
+}
+
+// SetRegionTopology sets the region topology applied as tables are restored.
+func (rc *Client) SetRegionTopology(t *RegionTopology) {
+	rc.regionTopology = t
+}
+
+// applyRegionTopology sets the placement rules of the restored table
+// according to the region topology of its database.
+func (rc *Client) applyRegionTopology(ctx context.Context, dbName string, table *model.TableInfo) error {
+	if rc.regionTopology == nil {
+		return nil
+	}
+	p := rc.regionTopology.Lookup(dbName)
+	ids := []int64{table.ID}
+	if pi := table.GetPartitionInfo(); pi != nil {
+		for _, def := range pi.Definitions {
+			ids = append(ids, def.ID)
+		}
+	}
+	for _, id := range ids {
+		for _, rule := range rc.regionTopology.rules(p, id) {
+			if err := rc.toolClient.SetPlacementRule(ctx, rule); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	rc.placedTables.mu.Lock()
+	rc.placedTables.tables = append(rc.placedTables.tables,
+		placedTable{db: dbName, table: table.Name.O, placement: p, ids: ids})
+	rc.placedTables.mu.Unlock()
+	log.Info("region topology applied",
+		zap.String("db", dbName),
+		zap.Stringer("table", table.Name),
+		zap.String("primary", p.Primary),
+		zap.Strings("replicas", p.Replicas))
+	return nil
+}
+
+// PlacedTableCount returns the count of the tables placed by the region
+// topology.
+func (rc *Client) PlacedTableCount() int {
+	rc.placedTables.mu.Lock()
+	defer rc.placedTables.mu.Unlock()
+	return len(rc.placedTables.tables)
+}
+
+// WaitRegionPlacement polls the regions of the tables placed by the region
+// topology until all of them are placed as the topology, i.e. the leaders are
+// in the primary region and every replica region has a peer. The progress
+// increases by every table placed.
+func (rc *Client) WaitRegionPlacement(ctx context.Context, interval time.Duration, updateCh glue.Progress) error {
+	rc.placedTables.mu.Lock()
+	pending := append([]placedTable(nil), rc.placedTables.tables...)
+	rc.placedTables.mu.Unlock()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// The stores are cached in every round, the regions of a table share
+		// a few stores.
+		stores := make(map[uint64]*metapb.Store)
+		remaining := pending[:0]
+		misplaced := 0
+		for _, t := range pending {
+			n, err := rc.misplacedRegions(ctx, t, stores)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if n == 0 {
+				updateCh.Inc()
+				continue
+			}
+			misplaced += n
+			remaining = append(remaining, t)
+		}
+		pending = remaining
+		if len(pending) == 0 {
+			return nil
+		}
+		log.Info("waiting for the regions placed by the region topology",
+			zap.Int("tables", len(pending)), zap.Int("misplaced-regions", misplaced))
+		select {
+		case <-ctx.Done():
+			return errors.Annotatef(ctx.Err(), "%d regions of %d tables are not placed as the region topology, e.g. %s.%s",
+				misplaced, len(pending), pending[0].db, pending[0].table)
+		case <-ticker.C:
+		}
+	}
+}
+
+// misplacedRegions returns the count of the regions of the table not placed
+// as the topology.
+func (rc *Client) misplacedRegions(ctx context.Context, t placedTable, stores map[uint64]*metapb.Store) (int, error) {
+	misplaced := 0
+	for _, id := range t.ids {
+		start := codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(id))
+		end := codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(id+1))
+		var walkErr error
+		err := WalkRegions(ctx, rc.toolClient, start, end, scanRegionPaginationLimit, func(_ int, r *RegionInfo) bool {
+			ok, err := rc.regionPlaced(ctx, r, t.placement, stores)
+			if err != nil {
+				walkErr = err
+				return false
+			}
+			if !ok {
+				misplaced++
+			}
+			return true
+		})
+		if err == nil {
+			err = walkErr
+		}
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	return misplaced, nil
+}
+
+// regionPlaced returns whether the leader of the region is in the primary
+// region, and every replica region has a peer of it. The stores of the peers
+// are cached in the stores.
+func (rc *Client) regionPlaced(
+	ctx context.Context, r *RegionInfo, p *RegionPlacement, stores map[uint64]*metapb.Store,
+) (bool, error) {
+	if r.Leader == nil {
+		return false, nil
+	}
+	regions := make(map[string]struct{}, len(r.Region.GetPeers()))
+	for _, peer := range r.Region.GetPeers() {
+		store, ok := stores[peer.GetStoreId()]
+		if !ok {
+			var err error
+			store, err = rc.toolClient.GetStore(ctx, peer.GetStoreId())
+			if err != nil {
+				return false, errors.Trace(err)
+			}
+			stores[peer.GetStoreId()] = store
+		}
+		region := storeLabel(store, rc.regionTopology.LabelKey)
+		if peer.GetStoreId() == r.Leader.GetStoreId() && region != p.Primary {
+			return false, nil
+		}
+		regions[region] = struct{}{}
+	}
+	for _, region := range p.Replicas {
+		if _, ok := regions[region]; !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func storeLabel(store *metapb.Store, key string) string {
+	for _, label := range store.GetLabels() {
+		if label.GetKey() == key {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
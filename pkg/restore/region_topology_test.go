@@ -0,0 +1,171 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+	"github.com/tikv/pd/server/schedule/placement"
+)
+
+var _ = Suite(&testRegionTopologySuite{})
+
+type testRegionTopologySuite struct{}
+
+// topologyClient keeps the rules set and the regions of a table, the leaders
+// of the regions are moved to the store of leaderTo after the first scan.
+type topologyClient struct {
+	SplitClient
+	mu        sync.Mutex
+	stores    map[uint64]*metapb.Store
+	regions   []*RegionInfo
+	rules     []placement.Rule
+	getStores int
+	scans     int
+	leaderTo  uint64
+}
+
+func (c *topologyClient) GetStore(ctx context.Context, storeID uint64) (*metapb.Store, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.getStores++
+	return c.stores[storeID], nil
+}
+
+func (c *topologyClient) SetPlacementRule(ctx context.Context, rule placement.Rule) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = append(c.rules, rule)
+	return nil
+}
+
+func (c *topologyClient) ScanRegions(ctx context.Context, key, endKey []byte, limit int) ([]*RegionInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	regions := make([]*RegionInfo, 0, len(c.regions))
+	for _, r := range c.regions {
+		regions = append(regions, &RegionInfo{Region: r.Region, Leader: r.Leader})
+	}
+	c.scans++
+	if c.leaderTo != 0 {
+		for _, r := range c.regions {
+			r.Leader = &metapb.Peer{StoreId: c.leaderTo}
+		}
+	}
+	return regions, nil
+}
+
+func regionStore(id uint64, region string) *metapb.Store {
+	return &metapb.Store{Id: id, Labels: []*metapb.StoreLabel{{Key: defaultRegionLabelKey, Value: region}}}
+}
+
+func topologyRegion(leader uint64, stores ...uint64) *RegionInfo {
+	peers := make([]*metapb.Peer, 0, len(stores))
+	for _, id := range stores {
+		peers = append(peers, &metapb.Peer{StoreId: id})
+	}
+	return &RegionInfo{Region: &metapb.Region{Peers: peers}, Leader: &metapb.Peer{StoreId: leader}}
+}
+
+func (s *testRegionTopologySuite) TestParseRegionTopology(c *C) {
+	t, err := ParseRegionTopology([]byte(`{
+		"primary": "us-east", "replicas": ["us-west", "eu-central"],
+		"databases": {"Tenant_EU": {"primary": "eu-central", "replicas": ["us-east"]}}
+	}`))
+	c.Assert(err, IsNil)
+	c.Assert(t.LabelKey, Equals, "region")
+	p := t.Lookup("test")
+	c.Assert(p.Primary, Equals, "us-east")
+	c.Assert(p.Replicas, DeepEquals, []string{"us-west", "eu-central"})
+	p = t.Lookup("tenant_eu")
+	c.Assert(p.Primary, Equals, "eu-central")
+	c.Assert(p.Replicas, DeepEquals, []string{"us-east"})
+
+	// The database tenant_eu places 2 voters only.
+	c.Assert(t.CheckReplicas(2), IsNil)
+	c.Assert(t.CheckReplicas(3), ErrorMatches, ".*tenant_eu places 2 voters, less than the max-replicas 3.*")
+
+	_, err = ParseRegionTopology([]byte(`{"replicas": ["us-west"]}`))
+	c.Assert(err, ErrorMatches, ".*the cluster has no primary region.*")
+	_, err = ParseRegionTopology([]byte(`{"primary": "us-east", "replicas": ["us-east"]}`))
+	c.Assert(err, ErrorMatches, ".*duplicated replica region \"us-east\".*")
+	_, err = ParseRegionTopology([]byte(`{"primary": "us-east", "databases": {"test": {}}}`))
+	c.Assert(err, ErrorMatches, ".*test has no primary region.*")
+}
+
+func (s *testRegionTopologySuite) TestRules(c *C) {
+	t, err := ParseRegionTopology([]byte(`{"label-key": "dc", "primary": "us-east", "replicas": ["us-west", "eu-central"]}`))
+	c.Assert(err, IsNil)
+	rules := t.rules(t.Lookup("test"), 42)
+	c.Assert(rules, HasLen, 3)
+	startKey := hex.EncodeToString(codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(42)))
+	endKey := hex.EncodeToString(codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(43)))
+	expected := []struct {
+		id     string
+		role   placement.PeerRoleType
+		region string
+	}{
+		{"br-region-t42-leader", placement.Leader, "us-east"},
+		{"br-region-t42-voter0", placement.Voter, "us-west"},
+		{"br-region-t42-voter1", placement.Voter, "eu-central"},
+	}
+	for i, rule := range rules {
+		c.Assert(rule.GroupID, Equals, placementMappingRuleGroup)
+		c.Assert(rule.ID, Equals, expected[i].id)
+		c.Assert(rule.Index, Equals, placementMappingRuleIndex)
+		c.Assert(rule.Override, IsTrue)
+		c.Assert(rule.StartKeyHex, Equals, startKey)
+		c.Assert(rule.EndKeyHex, Equals, endKey)
+		c.Assert(rule.Role, Equals, expected[i].role)
+		c.Assert(rule.Count, Equals, 1)
+		c.Assert(rule.LabelConstraints, DeepEquals, []placement.LabelConstraint{
+			{Key: "dc", Op: placement.In, Values: []string{expected[i].region}},
+		})
+	}
+}
+
+func (s *testRegionTopologySuite) TestWaitRegionPlacement(c *C) {
+	ctx := context.Background()
+	t, err := ParseRegionTopology([]byte(`{"primary": "us-east", "replicas": ["us-west"]}`))
+	c.Assert(err, IsNil)
+	// The leader of the second region is out of the primary region until the
+	// first scan.
+	client := &topologyClient{
+		stores: map[uint64]*metapb.Store{
+			1: regionStore(1, "us-east"),
+			2: regionStore(2, "us-west"),
+			3: regionStore(3, "eu-central"),
+		},
+		regions:  []*RegionInfo{topologyRegion(1, 1, 2, 3), topologyRegion(2, 1, 2)},
+		leaderTo: 1,
+	}
+	rc := &Client{toolClient: client}
+	rc.SetRegionTopology(t)
+	table := &model.TableInfo{ID: 42, Name: model.NewCIStr("t")}
+	c.Assert(rc.applyRegionTopology(ctx, "test", table), IsNil)
+	c.Assert(client.rules, HasLen, 2)
+	c.Assert(rc.PlacedTableCount(), Equals, 1)
+
+	progress := &countProgress{}
+	c.Assert(rc.WaitRegionPlacement(ctx, 10*time.Millisecond, progress), IsNil)
+	c.Assert(progress.count, Equals, int64(1))
+	c.Assert(client.scans, Equals, 2)
+	// Every store is got once in every round, not once for every peer.
+	c.Assert(client.getStores, Equals, 6)
+
+	// The leader never moves into the primary region.
+	client.regions = []*RegionInfo{topologyRegion(3, 1, 2, 3)}
+	client.leaderTo = 0
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err = rc.WaitRegionPlacement(ctx, 10*time.Millisecond, &countProgress{})
+	c.Assert(err, ErrorMatches, ".*1 regions of 1 tables are not placed as the region topology, e.g. test.t.*")
+}
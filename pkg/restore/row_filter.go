@@ -0,0 +1,115 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// HandleRange is the range [Low, High] of the int handles of the rows, i.e.
+// the int primary keys or the `_tidb_rowid`s.
+type HandleRange struct {
+	Low  int64
+	High int64
+}
+
+// ParseHandleRange parses the range like `100:200`, both ends are inclusive,
+// and the missing end is unbounded, e.g. `:200` or `100:`.
+func ParseHandleRange(s string) (*HandleRange, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid handle range %s, must be like 100:200", s)
+	}
+	r := &HandleRange{Low: math.MinInt64, High: math.MaxInt64}
+	for i, bound := range []*int64{&r.Low, &r.High} {
+		part := strings.TrimSpace(parts[i])
+		if part == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid handle range %s: %v", s, err)
+		}
+		*bound = v
+	}
+	if r.Low > r.High {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid handle range %s, the low is greater than the high", s)
+	}
+	return r, nil
+}
+
+// Contains returns whether the handle is in the range.
+func (r *HandleRange) Contains(handle int64) bool {
+	return r.Low <= handle && handle <= r.High
+}
+
+// overlaps returns whether the file may contain the records of the physical
+// table in the range, so the files out of the range aren't read at all.
+func (r *HandleRange) overlaps(file *backup.File, physicalID int64) bool {
+	startKey := tablecodec.EncodeRowKeyWithHandle(physicalID, kv.IntHandle(r.Low))
+	endKey := tablecodec.EncodeRowKeyWithHandle(physicalID, kv.IntHandle(r.High))
+	return bytes.Compare(file.GetStartKey(), endKey) <= 0 &&
+		(len(file.GetEndKey()) == 0 || bytes.Compare(file.GetEndKey(), startKey) > 0)
+}
+
+// RowFilter selects the rows restored by the logical restore, so the rows of
+// a tenant are recovered without restoring the whole table. The rows are
+// decoded and filtered client-side, the files out of the filter are skipped.
+type RowFilter struct {
+	// Partitions are the names of the partitions to restore, empty means all.
+	Partitions []string
+	// Handles is the range of the handles to restore, nil means all.
+	Handles *HandleRange
+}
+
+// apply restricts the physical tables and the handles of the table to the
+// filter.
+func (f *RowFilter) apply(t *logicalTable) error {
+	info := t.tbl.Info
+	if f.Handles != nil {
+		if info.IsCommonHandle {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"table %s.%s has no int handle to filter the rows by", t.tbl.DB.Name, info.Name)
+		}
+		t.handles = f.Handles
+	}
+	if len(f.Partitions) == 0 {
+		return nil
+	}
+	partitions := info.GetPartitionInfo()
+	if partitions == nil {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"table %s.%s isn't partitioned", t.tbl.DB.Name, info.Name)
+	}
+	ids := make(map[int64]struct{}, len(f.Partitions))
+	for _, name := range f.Partitions {
+		id := partitionID(partitions, name)
+		if id == 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"table %s.%s has no partition %s", t.tbl.DB.Name, info.Name, name)
+		}
+		ids[id] = struct{}{}
+	}
+	t.ids = ids
+	return nil
+}
+
+func partitionID(partitions *model.PartitionInfo, name string) int64 {
+	for _, def := range partitions.Definitions {
+		if def.Name.L == strings.ToLower(name) {
+			return def.ID
+		}
+	}
+	return 0
+}
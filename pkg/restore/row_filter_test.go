@@ -0,0 +1,97 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"math"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+type testRowFilterSuite struct{}
+
+var _ = Suite(&testRowFilterSuite{})
+
+func (s *testRowFilterSuite) TestParseHandleRange(c *C) {
+	r, err := ParseHandleRange("100:200")
+	c.Assert(err, IsNil)
+	c.Assert(*r, Equals, HandleRange{Low: 100, High: 200})
+	r, err = ParseHandleRange(":-1")
+	c.Assert(err, IsNil)
+	c.Assert(*r, Equals, HandleRange{Low: math.MinInt64, High: -1})
+	c.Assert(r.Contains(-1), IsTrue)
+	c.Assert(r.Contains(0), IsFalse)
+
+	_, err = ParseHandleRange("100")
+	c.Assert(err, ErrorMatches, ".*must be like 100:200.*")
+	_, err = ParseHandleRange("200:100")
+	c.Assert(err, ErrorMatches, ".*the low is greater than the high.*")
+	_, err = ParseHandleRange("a:")
+	c.Assert(err, ErrorMatches, ".*invalid handle range a:.*")
+}
+
+func (s *testRowFilterSuite) TestOverlaps(c *C) {
+	rowKey := func(id, handle int64) []byte {
+		return tablecodec.EncodeRowKeyWithHandle(id, kv.IntHandle(handle))
+	}
+	r := &HandleRange{Low: 100, High: 200}
+	c.Assert(r.overlaps(&backup.File{StartKey: rowKey(1, 0), EndKey: rowKey(1, 100)}, 1), IsFalse)
+	c.Assert(r.overlaps(&backup.File{StartKey: rowKey(1, 0), EndKey: rowKey(1, 101)}, 1), IsTrue)
+	c.Assert(r.overlaps(&backup.File{StartKey: rowKey(1, 200), EndKey: rowKey(1, 300)}, 1), IsTrue)
+	c.Assert(r.overlaps(&backup.File{StartKey: rowKey(1, 201)}, 1), IsFalse)
+	c.Assert(r.overlaps(&backup.File{StartKey: rowKey(1, 0), EndKey: rowKey(1, 300)}, 2), IsFalse)
+}
+
+func (s *testRowFilterSuite) TestApply(c *C) {
+	info := &model.TableInfo{
+		ID:   1,
+		Name: model.NewCIStr("t"),
+		Partition: &model.PartitionInfo{
+			Enable: true,
+			Definitions: []model.PartitionDefinition{
+				{ID: 2, Name: model.NewCIStr("p0")},
+				{ID: 3, Name: model.NewCIStr("p1")},
+			},
+		},
+	}
+	tbl := &utils.Table{DB: &model.DBInfo{Name: model.NewCIStr("test")}, Info: info}
+	t := newLogicalTable(tbl)
+	handles := &HandleRange{Low: 1, High: 10}
+	filter := &RowFilter{Partitions: []string{"P1"}, Handles: handles}
+	c.Assert(filter.apply(t), IsNil)
+	c.Assert(t.ids, DeepEquals, map[int64]struct{}{3: {}})
+	c.Assert(t.handles, Equals, handles)
+
+	filter.Partitions = []string{"p2"}
+	c.Assert(filter.apply(newLogicalTable(tbl)), ErrorMatches, ".*has no partition p2.*")
+	info.Partition = nil
+	c.Assert(filter.apply(newLogicalTable(tbl)), ErrorMatches, ".*isn't partitioned.*")
+	info.IsCommonHandle = true
+	c.Assert(filter.apply(newLogicalTable(tbl)), ErrorMatches, ".*has no int handle.*")
+}
+
+func (s *testRowFilterSuite) TestFilterRowsOfSST(c *C) {
+	// The fixtures are the SST files with the zstd data blocks, see
+	// TestRestoreRowsOfSST.
+	st, err := storage.NewLocalStorage("testdata")
+	c.Assert(err, IsNil)
+	t := newLogicalTable(logicalTestTable())
+	filter := &RowFilter{Handles: &HandleRange{Low: 2, High: 3}}
+	c.Assert(filter.apply(t), IsNil)
+	var handles []int64
+	err = iterateRecords(context.Background(), st, t, func(key, _ []byte) error {
+		_, handle, err := tablecodec.DecodeRecordKey(key)
+		handles = append(handles, handle.IntValue())
+		return err
+	}, func(*backup.File) error { return nil })
+	c.Assert(err, IsNil)
+	c.Assert(handles, DeepEquals, []int64{2, 3})
+}
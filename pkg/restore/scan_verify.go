@@ -0,0 +1,243 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	tidbkv "github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/store/tikv"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/kv"
+	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/rtree"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	// scanVerifyBatchSize is the max count of the pairs returned by a scan.
+	scanVerifyBatchSize = 1024
+	// DefaultScanVerifyConcurrency is the count of the ranges scanned
+	// concurrently.
+	DefaultScanVerifyConcurrency = 8
+)
+
+// KVScanner scans the key-value pairs in the cluster.
+type KVScanner interface {
+	// Scan returns at most limit pairs in [start, end) in order, an empty end
+	// means no upper bound.
+	Scan(ctx context.Context, start, end []byte, limit int) (keys, values [][]byte, err error)
+	Close() error
+}
+
+type rawKVScanner struct {
+	cli *tikv.RawKVClient
+}
+
+// NewRawKVScanner returns the scanner of the raw key-value pairs.
+func NewRawKVScanner(cli *tikv.RawKVClient) KVScanner {
+	return rawKVScanner{cli: cli}
+}
+
+func (s rawKVScanner) Scan(ctx context.Context, start, end []byte, limit int) ([][]byte, [][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	keys, values, err := s.cli.Scan(start, end, limit)
+	return keys, values, errors.Trace(err)
+}
+
+func (s rawKVScanner) Close() error {
+	return errors.Trace(s.cli.Close())
+}
+
+type txnKVScanner struct {
+	store tidbkv.Storage
+}
+
+// NewTxnKVScanner returns the scanner of the latest versions of the txn
+// key-value pairs. Every scan reads a fresh snapshot, so a long verification
+// doesn't hold a snapshot which the GC may have passed.
+func NewTxnKVScanner(store tidbkv.Storage) (KVScanner, error) {
+	return txnKVScanner{store: store}, nil
+}
+
+func (s txnKVScanner) Scan(ctx context.Context, start, end []byte, limit int) ([][]byte, [][]byte, error) {
+	txn, err := s.store.Begin()
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	// The txn only reads, rolling it back releases it.
+	defer func() { _ = txn.Rollback() }()
+	iter, err := txn.Iter(start, end)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	defer iter.Close()
+	var keys, values [][]byte
+	for iter.Valid() && len(keys) < limit {
+		if err = ctx.Err(); err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		keys = append(keys, append([]byte{}, iter.Key()...))
+		values = append(values, append([]byte{}, iter.Value()...))
+		if err = iter.Next(); err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+	}
+	return keys, values, nil
+}
+
+func (s txnKVScanner) Close() error {
+	return nil
+}
+
+// scanThrottle limits the bytes scanned per second by all the workers.
+type scanThrottle struct {
+	mu        sync.Mutex
+	rateLimit uint64
+	start     time.Time
+	scanned   uint64
+}
+
+// wait blocks until the scanned bytes are within the rate limit.
+func (t *scanThrottle) wait(ctx context.Context, n uint64) error {
+	if t.rateLimit == 0 {
+		return nil
+	}
+	t.mu.Lock()
+	t.scanned += n
+	due := t.start.Add(time.Duration(float64(t.scanned) / float64(t.rateLimit) * float64(time.Second)))
+	t.mu.Unlock()
+	d := time.Until(due)
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// ScanVerifier verifies the restored raw or txn ranges by scanning them and
+// comparing the checksums with the ones of the backup files, for the
+// restores where `ADMIN CHECKSUM` isn't available.
+type ScanVerifier struct {
+	scanner     KVScanner
+	throttle    *scanThrottle
+	concurrency uint
+}
+
+// NewScanVerifier returns a ScanVerifier scanning at most rateLimit bytes
+// per second, zero means no limit.
+func NewScanVerifier(scanner KVScanner, rateLimit uint64, concurrency uint) *ScanVerifier {
+	if concurrency == 0 {
+		concurrency = DefaultScanVerifyConcurrency
+	}
+	return &ScanVerifier{
+		scanner:     scanner,
+		throttle:    &scanThrottle{rateLimit: rateLimit},
+		concurrency: concurrency,
+	}
+}
+
+// isRangeCovered checks whether the range is within [start, end), i.e. its
+// files are restored entirely.
+func isRangeCovered(rg *rtree.Range, start, end []byte) bool {
+	return bytes.Compare(rg.StartKey, start) >= 0 && utils.CompareEndKey(rg.EndKey, end) <= 0
+}
+
+// Verify scans the ranges, and compares every range with the checksums of
+// its files. The ranges partially out of the restored range [start, end) are
+// skipped, since their files are restored partially.
+func (v *ScanVerifier) Verify(
+	ctx context.Context, ranges []rtree.Range, start, end []byte, updateCh glue.Progress,
+) error {
+	began := time.Now()
+	v.throttle.start = began
+	pool := utils.NewWorkerPool(v.concurrency, "scan verify")
+	eg, ectx := errgroup.WithContext(ctx)
+	var skipped, mismatched int64
+	var scannedKVs uint64
+	for i := range ranges {
+		rg := &ranges[i]
+		if !isRangeCovered(rg, start, end) {
+			log.Info("skip verifying the range partially restored",
+				logutil.Key("startKey", rg.StartKey), logutil.Key("endKey", rg.EndKey))
+			skipped++
+			updateCh.Inc()
+			continue
+		}
+		pool.ApplyOnErrorGroup(eg, func() error {
+			expected := kv.NewKVChecksum(0)
+			for _, file := range rg.Files {
+				fileChecksum := kv.MakeKVChecksum(file.TotalBytes, file.TotalKvs, file.Crc64Xor)
+				expected.Add(&fileChecksum)
+			}
+			actual, err := v.scanRange(ectx, rg.StartKey, rg.EndKey)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			atomic.AddUint64(&scannedKVs, actual.SumKVS())
+			if actual.Sum() != expected.Sum() || actual.SumKVS() != expected.SumKVS() ||
+				actual.SumSize() != expected.SumSize() {
+				log.Error("restored range mismatches the backup",
+					logutil.Key("startKey", rg.StartKey), logutil.Key("endKey", rg.EndKey),
+					zap.Object("expected", expected), zap.Object("actual", actual))
+				atomic.AddInt64(&mismatched, 1)
+			}
+			updateCh.Inc()
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+	summary.CollectInt("verified ranges", len(ranges)-int(skipped))
+	summary.CollectInt("skipped ranges", int(skipped))
+	log.Info("restored ranges verified by scan",
+		zap.Int("ranges", len(ranges)), zap.Int64("skipped", skipped),
+		zap.Uint64("kvs", scannedKVs), zap.Duration("take", time.Since(began)))
+	if mismatched > 0 {
+		return errors.Annotatef(berrors.ErrRestoreChecksumMismatch,
+			"%d of %d restored ranges mismatch the backup", mismatched, len(ranges))
+	}
+	return nil
+}
+
+// scanRange computes the checksum of the pairs in [start, end).
+func (v *ScanVerifier) scanRange(ctx context.Context, start, end []byte) (*kv.Checksum, error) {
+	checksum := kv.NewKVChecksum(0)
+	for {
+		keys, values, err := v.scanner.Scan(ctx, start, end, scanVerifyBatchSize)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		var size uint64
+		for i := range keys {
+			checksum.UpdateOne(kv.Pair{Key: keys[i], Val: values[i]})
+			size += uint64(len(keys[i]) + len(values[i]))
+		}
+		if err = v.throttle.wait(ctx, size); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(keys) < scanVerifyBatchSize {
+			return checksum, nil
+		}
+		// Continue from the successor of the last key.
+		start = append(append([]byte{}, keys[len(keys)-1]...), 0)
+	}
+}
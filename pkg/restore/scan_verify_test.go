@@ -0,0 +1,75 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/kv"
+	"github.com/pingcap/br/pkg/rtree"
+)
+
+type testScanVerifySuite struct{}
+
+var _ = Suite(&testScanVerifySuite{})
+
+// memKVScanner scans the sorted pairs in memory.
+type memKVScanner struct {
+	pairs []kv.Pair
+}
+
+func (s *memKVScanner) Scan(_ context.Context, start, end []byte, limit int) ([][]byte, [][]byte, error) {
+	i := sort.Search(len(s.pairs), func(i int) bool { return bytes.Compare(s.pairs[i].Key, start) >= 0 })
+	var keys, values [][]byte
+	for ; i < len(s.pairs) && len(keys) < limit; i++ {
+		if len(end) > 0 && bytes.Compare(s.pairs[i].Key, end) >= 0 {
+			break
+		}
+		keys = append(keys, s.pairs[i].Key)
+		values = append(values, s.pairs[i].Val)
+	}
+	return keys, values, nil
+}
+
+func (s *memKVScanner) Close() error {
+	return nil
+}
+
+func (*testScanVerifySuite) TestScanVerify(c *C) {
+	scanner := &memKVScanner{}
+	newRange := func(start, end string, n int) rtree.Range {
+		checksum := kv.NewKVChecksum(0)
+		for i := 0; i < n; i++ {
+			pair := kv.Pair{Key: []byte{start[0], byte(i / 256), byte(i % 256)}, Val: []byte("value")}
+			checksum.UpdateOne(pair)
+			scanner.pairs = append(scanner.pairs, pair)
+		}
+		return rtree.Range{StartKey: []byte(start), EndKey: []byte(end), Files: []*backup.File{{
+			Crc64Xor: checksum.Sum(), TotalKvs: checksum.SumKVS(), TotalBytes: checksum.SumSize(),
+		}}}
+	}
+	// The second range spans several scan batches.
+	ranges := []rtree.Range{newRange("a", "b", 10), newRange("b", "c", 2*scanVerifyBatchSize+1)}
+	ctx := context.Background()
+
+	verifier := NewScanVerifier(scanner, 0, 2)
+	c.Assert(verifier.Verify(ctx, ranges, nil, nil, glue.NopProgress()), IsNil)
+
+	// The value is corrupted.
+	scanner.pairs[3].Val = []byte("other")
+	c.Assert(verifier.Verify(ctx, ranges, nil, nil, glue.NopProgress()),
+		ErrorMatches, ".*1 of 2 restored ranges mismatch the backup.*")
+	// The range partially restored is skipped.
+	c.Assert(verifier.Verify(ctx, ranges, []byte("a1"), nil, glue.NopProgress()), IsNil)
+
+	// A missing key.
+	scanner.pairs = scanner.pairs[:len(scanner.pairs)-1]
+	c.Assert(verifier.Verify(ctx, ranges, []byte("b"), nil, glue.NopProgress()),
+		ErrorMatches, ".*1 of 2 restored ranges mismatch the backup.*")
+}
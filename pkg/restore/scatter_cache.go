@@ -0,0 +1,86 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+// scatterCacheSaveInterval is the min interval of saving the scattered
+// regions, so they are recorded without rewriting the checkpoint for every region.
+const scatterCacheSaveInterval = 10 * time.Second
+
+// ScatteredRegion is the range of a region when it was scattered.
+type ScatteredRegion struct {
+	StartKey []byte `json:"start-key"`
+	EndKey   []byte `json:"end-key"`
+}
+
+// contains returns whether the region is still in the scattered range. A
+// region split after the scatter keeps its peers, while a merged region may
+// have peers never scattered.
+func (r *ScatteredRegion) contains(region *RegionInfo) bool {
+	startKey, endKey := region.Region.GetStartKey(), region.Region.GetEndKey()
+	if bytes.Compare(startKey, r.StartKey) < 0 {
+		return false
+	}
+	if len(r.EndKey) == 0 {
+		return true
+	}
+	return len(endKey) != 0 && bytes.Compare(endKey, r.EndKey) <= 0
+}
+
+// ScatterCache records the regions scattered successfully in the checkpoint,
+// so a resumed restore doesn't scatter them again.
+type ScatterCache struct {
+	recorder *CheckpointRecorder
+}
+
+// NewScatterCache creates a scatter cache recording into the checkpoint of
+// the recorder, nil is returned without the recorder.
+func NewScatterCache(recorder *CheckpointRecorder) *ScatterCache {
+	if recorder == nil {
+		return nil
+	}
+	return &ScatterCache{recorder: recorder}
+}
+
+// Len returns the count of the scattered regions.
+func (c *ScatterCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	n := 0
+	c.recorder.view(func(cp *Checkpoint) { n = len(cp.ScatteredRegions) })
+	return n
+}
+
+// scattered returns whether the region has been scattered, and hasn't been
+// merged since then.
+func (c *ScatterCache) scattered(region *RegionInfo) bool {
+	if c == nil {
+		return false
+	}
+	var r *ScatteredRegion
+	c.recorder.view(func(cp *Checkpoint) { r = cp.ScatteredRegions[region.Region.GetId()] })
+	return r != nil && r.contains(region)
+}
+
+// record records the region scattered, the checkpoint is saved at most once
+// per scatterCacheSaveInterval.
+func (c *ScatterCache) record(ctx context.Context, region *RegionInfo) {
+	if c == nil {
+		return
+	}
+	c.recorder.update(ctx, scatterCacheSaveInterval, func(cp *Checkpoint) {
+		if cp.ScatteredRegions == nil {
+			cp.ScatteredRegions = make(map[uint64]*ScatteredRegion)
+		}
+		cp.ScatteredRegions[region.Region.GetId()] = &ScatteredRegion{
+			StartKey: region.Region.GetStartKey(),
+			EndKey:   region.Region.GetEndKey(),
+		}
+	})
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+type testScatterCacheSuite struct{}
+
+var _ = Suite(&testScatterCacheSuite{})
+
+func (s *testScatterCacheSuite) TestScatterCache(c *C) {
+	ctx := context.Background()
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	region := func(id uint64, start, end string) *RegionInfo {
+		return &RegionInfo{Region: &metapb.Region{Id: id, StartKey: []byte(start), EndKey: []byte(end)}}
+	}
+
+	recorder := NewCheckpointRecorder(store, ResumeCheckpoint(nil, 1, false))
+	cache := NewScatterCache(recorder)
+	c.Assert(cache.Len(), Equals, 0)
+	cache.record(ctx, region(1, "a", "c"))
+	cache.record(ctx, region(2, "c", ""))
+	recorder.Save(ctx)
+
+	previous, err := ReadCheckpoint(ctx, store)
+	c.Assert(err, IsNil)
+	cache = NewScatterCache(NewCheckpointRecorder(store, ResumeCheckpoint(previous, 1, false)))
+	c.Assert(cache.Len(), Equals, 2)
+	c.Assert(cache.scattered(region(1, "a", "c")), IsTrue)
+	// The split region keeps its peers.
+	c.Assert(cache.scattered(region(1, "a", "b")), IsTrue)
+	c.Assert(cache.scattered(region(2, "d", "")), IsTrue)
+	// The merged region doesn't.
+	c.Assert(cache.scattered(region(1, "a", "d")), IsFalse)
+	c.Assert(cache.scattered(region(3, "a", "b")), IsFalse)
+
+	nilCache := NewScatterCache(nil)
+	c.Assert(nilCache, IsNil)
+	c.Assert(nilCache.scattered(region(1, "a", "c")), IsFalse)
+	nilCache.record(ctx, region(1, "a", "c"))
+}
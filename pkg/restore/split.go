@@ -20,6 +20,7 @@ import (
 	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/logutil"
 	"github.com/pingcap/br/pkg/rtree"
+	"github.com/pingcap/br/pkg/summary"
 )
 
 // Constants for split retry machinery.
@@ -37,23 +38,87 @@ const (
 	ScatterMaxWaitInterval   = time.Second
 	ScatterWaitUpperInterval = 180 * time.Second
 
+	// ScatterRetryTimes is the count of the later passes retrying the regions
+	// failed to scatter, e.g. PD rejects them for the unhealthy or
+	// snapshotting stores, which may recover in seconds.
+	ScatterRetryTimes       = 3
+	ScatterRetryInterval    = time.Second
+	ScatterMaxRetryInterval = 8 * time.Second
+
 	RejectStoreCheckRetryTimes  = 64
 	RejectStoreCheckInterval    = 100 * time.Millisecond
 	RejectStoreMaxCheckInterval = 2 * time.Second
+
+	// LowPriorityScatterInterval is the interval between scatter requests in low priority.
+	LowPriorityScatterInterval = 100 * time.Millisecond
+)
+
+// ScatterPriority is the priority of the scatter operators created by restore.
+//
+// PD doesn't accept a priority for scatter operators yet, so the priority is
+// enforced by pacing the scatter requests on the BR side:
+//   - high: scatter all regions and wait longer for the operators to finish,
+//     so the data is ingested into a well balanced layout.
+//   - normal: the default behavior.
+//   - low: throttle the scatter requests and don't wait for the operators, so
+//     the routine balancing of PD is less affected.
+type ScatterPriority string
+
+// Scatter priorities.
+const (
+	ScatterPriorityHigh   ScatterPriority = "high"
+	ScatterPriorityNormal ScatterPriority = "normal"
+	ScatterPriorityLow    ScatterPriority = "low"
 )
 
+// ParseScatterPriority parses the scatter priority.
+func ParseScatterPriority(s string) (ScatterPriority, error) {
+	switch p := ScatterPriority(strings.ToLower(s)); p {
+	case ScatterPriorityHigh, ScatterPriorityNormal, ScatterPriorityLow:
+		return p, nil
+	case "":
+		return ScatterPriorityNormal, nil
+	default:
+		return "", errors.Annotatef(berrors.ErrInvalidArgument,
+			"invalid scatter priority %s, must be one of high|normal|low", s)
+	}
+}
+
 // RegionSplitter is a executor of region split by rules.
 type RegionSplitter struct {
-	client SplitClient
+	client   SplitClient
+	priority ScatterPriority
+	// dumper records the region layout of the stages, nil means no record.
+	dumper *RegionDumper
+	// scatterCache records the scattered regions, nil means no cache.
+	scatterCache *ScatterCache
 }
 
 // NewRegionSplitter returns a new RegionSplitter.
 func NewRegionSplitter(client SplitClient) *RegionSplitter {
 	return &RegionSplitter{
-		client: client,
+		client:   client,
+		priority: ScatterPriorityNormal,
 	}
 }
 
+// SetScatterPriority sets the priority of the scatter operators.
+func (rs *RegionSplitter) SetScatterPriority(priority ScatterPriority) {
+	rs.priority = priority
+}
+
+// SetRegionDumper makes the region layout of the split ranges recorded by the
+// dumper.
+func (rs *RegionSplitter) SetRegionDumper(dumper *RegionDumper) {
+	rs.dumper = dumper
+}
+
+// SetScatterCache makes the splitter skip the regions scattered by the
+// previous runs, and record the regions it scatters into the cache.
+func (rs *RegionSplitter) SetScatterCache(cache *ScatterCache) {
+	rs.scatterCache = cache
+}
+
 // OnSplitFunc is called before split a range.
 type OnSplitFunc func(key [][]byte)
 
@@ -105,8 +170,10 @@ func (rs *RegionSplitter) Split(
 			}
 		}
 	}
+	rs.dumper.snapshot(ctx, rs.client, RegionStageBeforeSplit, minKey, maxKey)
 	interval := SplitRetryInterval
 	scatterRegions := make([]*RegionInfo, 0)
+	var unscattered []*RegionInfo
 
 SplitRegions:
 	for i := 0; i < SplitRetryTimes; i++ {
@@ -124,11 +191,11 @@ SplitRegions:
 			regionMap[region.Region.GetId()] = region
 		}
 		for regionID, keys := range splitKeyMap {
-			var newRegions []*RegionInfo
+			var newRegions, failed []*RegionInfo
 			region := regionMap[regionID]
 			log.Info("split regions",
 				logutil.Region(region.Region), logutil.Keys(keys), rtree.ZapRanges(ranges))
-			newRegions, errSplit = rs.splitAndScatterRegions(ctx, region, keys)
+			newRegions, failed, errSplit = rs.splitAndScatterRegions(ctx, region, keys)
 			if errSplit != nil {
 				if strings.Contains(errSplit.Error(), "no valid key") {
 					for _, key := range keys {
@@ -147,6 +214,7 @@ SplitRegions:
 					interval = SplitMaxRetryInterval
 				}
 				time.Sleep(interval)
+				summary.CollectRetry(summary.RetrySplit, errSplit)
 				log.Warn("split regions failed, retry",
 					zap.Error(errSplit),
 					logutil.Region(region.Region),
@@ -160,6 +228,7 @@ SplitRegions:
 					zap.Int("split key count", len(keys)))
 			}
 			scatterRegions = append(scatterRegions, newRegions...)
+			unscattered = append(unscattered, failed...)
 			onSplit(keys)
 		}
 		break
@@ -167,13 +236,27 @@ SplitRegions:
 	if errSplit != nil {
 		return errors.Trace(errSplit)
 	}
+	rs.dumper.snapshot(ctx, rs.client, RegionStageAfterSplit, minKey, maxKey)
+	if len(unscattered) > 0 {
+		unscattered = rs.retryScatterRegions(ctx, unscattered)
+		scatterRegions = excludeRegions(scatterRegions, unscattered)
+	}
+	if rs.priority == ScatterPriorityLow {
+		log.Info("skip waiting for scattering regions in low priority",
+			zap.Int("regions", len(scatterRegions)), zap.Duration("take", time.Since(startTime)))
+		return nil
+	}
 	log.Info("start to wait for scattering regions",
 		zap.Int("regions", len(scatterRegions)), zap.Duration("take", time.Since(startTime)))
 	startTime = time.Now()
+	waitUpperInterval := ScatterWaitUpperInterval
+	if rs.priority == ScatterPriorityHigh {
+		waitUpperInterval *= 2
+	}
 	scatterCount := 0
 	for _, region := range scatterRegions {
 		rs.waitForScatterRegion(ctx, region)
-		if time.Since(startTime) > ScatterWaitUpperInterval {
+		if time.Since(startTime) > waitUpperInterval {
 			break
 		}
 		scatterCount++
@@ -187,6 +270,7 @@ SplitRegions:
 			zap.Int("regions", len(scatterRegions)),
 			zap.Duration("take", time.Since(startTime)))
 	}
+	rs.dumper.snapshot(ctx, rs.client, RegionStageAfterScatter, minKey, maxKey)
 	return nil
 }
 
@@ -265,21 +349,102 @@ func (rs *RegionSplitter) waitForScatterRegion(ctx context.Context, regionInfo *
 	}
 }
 
+// splitAndScatterRegions splits the region by the keys, and scatters the new
+// regions except the cached ones. The regions failed to scatter are returned
+// to be retried later.
 func (rs *RegionSplitter) splitAndScatterRegions(
 	ctx context.Context, regionInfo *RegionInfo, keys [][]byte,
-) ([]*RegionInfo, error) {
-	newRegions, err := rs.client.BatchSplitRegions(ctx, regionInfo, keys)
+) (newRegions []*RegionInfo, unscattered []*RegionInfo, err error) {
+	newRegions, err = rs.client.BatchSplitRegions(ctx, regionInfo, keys)
 	if err != nil {
-		return nil, errors.Trace(err)
+		return nil, nil, errors.Trace(err)
 	}
+	skipped := 0
 	for _, region := range newRegions {
 		// Wait for a while until the regions successfully split.
 		rs.waitForSplit(ctx, region.Region.Id)
+		if rs.scatterCache.scattered(region) {
+			skipped++
+			continue
+		}
+		if rs.priority == ScatterPriorityLow {
+			timer := time.NewTimer(LowPriorityScatterInterval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, nil, errors.Trace(ctx.Err())
+			case <-timer.C:
+			}
+		}
 		if err = rs.client.ScatterRegion(ctx, region); err != nil {
-			log.Warn("scatter region failed", logutil.Region(region.Region), zap.Error(err))
+			summary.CollectFailure(summary.FailScatter, err)
+			log.Warn("scatter region failed, retry later", logutil.Region(region.Region), zap.Error(err))
+			unscattered = append(unscattered, region)
+			continue
 		}
+		rs.scatterCache.record(ctx, region)
 	}
-	return newRegions, nil
+	if skipped > 0 {
+		log.Info("skip scattering the regions scattered before", zap.Int("regions", skipped))
+		summary.CollectInt("skipped scatter regions", skipped)
+	}
+	return newRegions, unscattered, nil
+}
+
+// retryScatterRegions retries scattering the regions in later passes, and
+// returns the regions never scattered, whose count is collected into the
+// warnings of the summary.
+func (rs *RegionSplitter) retryScatterRegions(ctx context.Context, regions []*RegionInfo) []*RegionInfo {
+	interval := ScatterRetryInterval
+	for i := 0; i < ScatterRetryTimes && len(regions) > 0; i++ {
+		log.Info("retry scattering regions", zap.Int("regions", len(regions)), zap.Int("pass", i+1))
+		select {
+		case <-ctx.Done():
+			return regions
+		case <-time.After(interval):
+		}
+		interval = 2 * interval
+		if interval > ScatterMaxRetryInterval {
+			interval = ScatterMaxRetryInterval
+		}
+
+		failed := regions[:0]
+		for _, region := range regions {
+			// The epoch may have changed since the split.
+			latest, err := rs.client.GetRegionByID(ctx, region.Region.GetId())
+			if err == nil && latest != nil {
+				region = latest
+			}
+			if err = rs.client.ScatterRegion(ctx, region); err != nil {
+				summary.CollectFailure(summary.FailScatter, err)
+				log.Warn("scatter region failed", logutil.Region(region.Region), zap.Error(err))
+				failed = append(failed, region)
+				continue
+			}
+			rs.scatterCache.record(ctx, region)
+		}
+		regions = failed
+	}
+	if len(regions) > 0 {
+		log.Warn("some regions are never scattered", zap.Int("regions", len(regions)))
+		summary.CollectWarning("regions never scattered", len(regions))
+	}
+	return regions
+}
+
+// excludeRegions returns the regions except the excluded ones.
+func excludeRegions(regions []*RegionInfo, excluded []*RegionInfo) []*RegionInfo {
+	excludedIDs := make(map[uint64]struct{}, len(excluded))
+	for _, region := range excluded {
+		excludedIDs[region.Region.GetId()] = struct{}{}
+	}
+	res := make([]*RegionInfo, 0, len(regions))
+	for _, region := range regions {
+		if _, ok := excludedIDs[region.Region.GetId()]; !ok {
+			res = append(res, region)
+		}
+	}
+	return res
 }
 
 // GetSplitKeys checks if the regions should be split by the new prefix of the rewrites rule and the end key of
@@ -350,16 +515,9 @@ func truncateRowKey(key []byte) []byte {
 }
 
 func replacePrefix(s []byte, rewriteRules *RewriteRules) ([]byte, *import_sstpb.RewriteRule) {
-	// We should search the dataRules firstly.
-	for _, rule := range rewriteRules.Data {
-		if bytes.HasPrefix(s, rule.GetOldKeyPrefix()) {
-			return append(append([]byte{}, rule.GetNewKeyPrefix()...), s[len(rule.GetOldKeyPrefix()):]...), rule
-		}
-	}
-	for _, rule := range rewriteRules.Table {
-		if bytes.HasPrefix(s, rule.GetOldKeyPrefix()) {
-			return append(append([]byte{}, rule.GetNewKeyPrefix()...), s[len(rule.GetOldKeyPrefix()):]...), rule
-		}
+	// The most specific rule rewrites the key.
+	if rule := matchOldPrefix(s, rewriteRules); rule != nil {
+		return append(append([]byte{}, rule.GetNewKeyPrefix()...), s[len(rule.GetOldKeyPrefix()):]...), rule
 	}
 
 	return s, nil
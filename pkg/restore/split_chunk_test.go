@@ -0,0 +1,43 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+type testSplitChunkSuite struct{}
+
+var _ = Suite(&testSplitChunkSuite{})
+
+func (s *testSplitChunkSuite) TestChunkSplitKeys(c *C) {
+	keys := make([][]byte, 0, 10)
+	for i := 0; i < 10; i++ {
+		keys = append(keys, bytes.Repeat([]byte{byte('a' + i)}, 1000))
+	}
+
+	// All keys fit in the default size.
+	chunks := chunkSplitKeys(keys, utils.GRPCMaxMsgSize{}.SendSize())
+	c.Assert(chunks, HasLen, 1)
+	c.Assert(chunks[0], HasLen, 10)
+
+	// Every chunk takes the overhead and at most 3 keys of 1008 bytes.
+	maxMsgSize := utils.GRPCMaxMsgSize{Send: splitRequestOverhead + 3*1008}
+	chunks = chunkSplitKeys(keys, maxMsgSize.SendSize())
+	c.Assert(chunks, HasLen, 4)
+	total := make([][]byte, 0, len(keys))
+	for _, chunk := range chunks {
+		c.Assert(len(chunk) <= 3, IsTrue)
+		total = append(total, chunk...)
+	}
+	c.Assert(total, DeepEquals, keys)
+
+	// A key larger than the size is sent alone.
+	chunks = chunkSplitKeys(keys[:2], 10)
+	c.Assert(chunks, HasLen, 2)
+	c.Assert(chunkSplitKeys(nil, 10), HasLen, 1)
+}
@@ -8,12 +8,11 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"path"
 	"strconv"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
@@ -31,11 +30,16 @@ import (
 	"google.golang.org/grpc/credentials"
 
 	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/hook"
 	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/redact"
+	"github.com/pingcap/br/pkg/utils"
 )
 
 const (
 	splitRegionMaxRetryTime = 4
+	pdHTTPTimeout           = 30 * time.Second
 )
 
 // SplitClient is an external client used by RegionSplitter.
@@ -78,14 +82,29 @@ type pdClient struct {
 	client     pd.Client
 	tlsConf    *tls.Config
 	storeCache map[uint64]*metapb.Store
+	// pdHTTP sends the HTTP requests to the PD leader, and fails over to the
+	// other members if the leader is changing.
+	pdHTTP *pdutil.HTTPFailover
+	// maxMsgSize is the max size of the gRPC messages to TiKV.
+	maxMsgSize utils.GRPCMaxMsgSize
 }
 
-// NewSplitClient returns a client used by RegionSplitter.
-func NewSplitClient(client pd.Client, tlsConf *tls.Config) SplitClient {
+// NewSplitClient returns a client used by RegionSplitter, pdTLSConf is used
+// by the HTTP requests to PD and tikvTLSConf by the connections to TiKV. The
+// split requests larger than the max send size of maxMsgSize are chunked.
+func NewSplitClient(client pd.Client, pdTLSConf, tikvTLSConf *tls.Config, maxMsgSize utils.GRPCMaxMsgSize) SplitClient {
+	cli := &http.Client{Timeout: pdHTTPTimeout}
+	if pdTLSConf != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = pdTLSConf
+		cli.Transport = transport
+	}
 	return &pdClient{
 		client:     client,
-		tlsConf:    tlsConf,
+		tlsConf:    tikvTLSConf,
 		storeCache: make(map[uint64]*metapb.Store),
+		pdHTTP:     pdutil.NewHTTPFailover(nil, cli, pdTLSConf != nil, client.GetLeaderAddr),
+		maxMsgSize: maxMsgSize,
 	}
 }
 
@@ -133,6 +152,9 @@ func (c *pdClient) GetRegionByID(ctx context.Context, regionID uint64) (*RegionI
 }
 
 func (c *pdClient) SplitRegion(ctx context.Context, regionInfo *RegionInfo, key []byte) (*RegionInfo, error) {
+	if err := hook.BeforeSplit(ctx, regionInfo.Region, [][]byte{key}); err != nil {
+		return nil, errors.Trace(err)
+	}
 	var peer *metapb.Peer
 	if regionInfo.Leader != nil {
 		peer = regionInfo.Leader
@@ -147,7 +169,9 @@ func (c *pdClient) SplitRegion(ctx context.Context, regionInfo *RegionInfo, key
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	conn, err := grpc.Dial(store.GetAddress(), grpc.WithInsecure())
+	opts := append([]grpc.DialOption{grpc.WithInsecure()}, c.maxMsgSize.DialOptions()...)
+	opts = append(opts, utils.GRPCTaskDialOptions()...)
+	conn, err := grpc.Dial(utils.MapStoreAddr(store.GetAddress()), opts...)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -246,6 +270,9 @@ func splitRegionWithFailpoint(
 func (c *pdClient) sendSplitRegionRequest(
 	ctx context.Context, regionInfo *RegionInfo, keys [][]byte,
 ) (*kvrpcpb.SplitRegionResponse, error) {
+	if err := hook.BeforeSplit(ctx, regionInfo.Region, keys); err != nil {
+		return nil, errors.Trace(err)
+	}
 	var splitErrors error
 	for i := 0; i < splitRegionMaxRetryTime; i++ {
 		var peer *metapb.Peer
@@ -269,7 +296,9 @@ func (c *pdClient) sendSplitRegionRequest(
 		if c.tlsConf != nil {
 			opt = grpc.WithTransportCredentials(credentials.NewTLS(c.tlsConf))
 		}
-		conn, err := grpc.Dial(store.GetAddress(), opt)
+		opts := append([]grpc.DialOption{opt}, c.maxMsgSize.DialOptions()...)
+		opts = append(opts, utils.GRPCTaskDialOptions()...)
+		conn, err := grpc.Dial(utils.MapStoreAddr(store.GetAddress()), opts...)
 		if err != nil {
 			return nil, multierr.Append(splitErrors, err)
 		}
@@ -326,8 +355,69 @@ func (c *pdClient) sendSplitRegionRequest(
 	return nil, errors.Trace(splitErrors)
 }
 
+// splitRequestOverhead is the estimated size of a split request except the
+// keys, e.g. the context of the region.
+const splitRequestOverhead = 4 * 1024
+
+// chunkSplitKeys chunks the split keys, so every split request fits in the
+// max size of gRPC messages.
+func chunkSplitKeys(keys [][]byte, maxMsgSize int) [][][]byte {
+	chunks := make([][][]byte, 0, 1)
+	start, size := 0, splitRequestOverhead
+	for i, key := range keys {
+		// The length and the tag of the key are at most 8 bytes.
+		keySize := len(key) + 8
+		if i > start && size+keySize > maxMsgSize {
+			chunks = append(chunks, keys[start:i])
+			start, size = i, splitRequestOverhead
+		}
+		size += keySize
+	}
+	return append(chunks, keys[start:])
+}
+
 func (c *pdClient) BatchSplitRegionsWithOrigin(
 	ctx context.Context, regionInfo *RegionInfo, keys [][]byte,
+) (*RegionInfo, []*RegionInfo, error) {
+	chunks := chunkSplitKeys(keys, c.maxMsgSize.SendSize())
+	if len(chunks) == 1 {
+		return c.batchSplitRegionsWithOrigin(ctx, regionInfo, keys)
+	}
+	log.Info("split keys exceed the max gRPC message size, split in chunks",
+		logutil.Region(regionInfo.Region),
+		zap.Int("keys", len(keys)),
+		zap.Int("chunks", len(chunks)))
+	originRegion := regionInfo
+	newRegions := make([]*RegionInfo, 0, len(keys))
+	current := regionInfo
+	for i, chunk := range chunks {
+		origin, regions, err := c.batchSplitRegionsWithOrigin(ctx, current, chunk)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		if origin != nil && origin.Region.GetId() == regionInfo.Region.GetId() {
+			originRegion = origin
+		}
+		newRegions = append(newRegions, regions...)
+		if i+1 == len(chunks) {
+			break
+		}
+		// The keys of the next chunk are in the region containing its first key.
+		if origin != nil {
+			regions = append(regions, origin)
+		}
+		current = NeedSplit(chunks[i+1][0], regions)
+		if current == nil {
+			return nil, nil, errors.Annotatef(berrors.ErrRestoreSplitFailed,
+				"region of the split key %s not found after splitting region %d",
+				redact.Key(chunks[i+1][0]), regionInfo.Region.GetId())
+		}
+	}
+	return originRegion, newRegions, nil
+}
+
+func (c *pdClient) batchSplitRegionsWithOrigin(
+	ctx context.Context, regionInfo *RegionInfo, keys [][]byte,
 ) (*RegionInfo, []*RegionInfo, error) {
 	resp, err := c.sendSplitRegionRequest(ctx, regionInfo, keys)
 	if err != nil {
@@ -398,20 +488,10 @@ func (c *pdClient) ScanRegions(ctx context.Context, key, endKey []byte, limit in
 
 func (c *pdClient) GetPlacementRule(ctx context.Context, groupID, ruleID string) (placement.Rule, error) {
 	var rule placement.Rule
-	addr := c.getPDAPIAddr()
-	if addr == "" {
-		return rule, errors.Annotate(berrors.ErrRestoreSplitFailed, "failed to add stores labels: no leader")
-	}
-	req, _ := http.NewRequestWithContext(ctx, "GET", addr+path.Join("/pd/api/v1/config/rule", groupID, ruleID), nil)
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return rule, errors.Trace(err)
-	}
-	b, err := ioutil.ReadAll(res.Body)
+	b, err := c.pdHTTP.Request(ctx, path.Join("pd/api/v1/config/rule", groupID, ruleID), http.MethodGet, nil)
 	if err != nil {
 		return rule, errors.Trace(err)
 	}
-	res.Body.Close()
 	err = json.Unmarshal(b, &rule)
 	if err != nil {
 		return rule, errors.Trace(err)
@@ -420,62 +500,26 @@ func (c *pdClient) GetPlacementRule(ctx context.Context, groupID, ruleID string)
 }
 
 func (c *pdClient) SetPlacementRule(ctx context.Context, rule placement.Rule) error {
-	addr := c.getPDAPIAddr()
-	if addr == "" {
-		return errors.Annotate(berrors.ErrPDLeaderNotFound, "failed to add stores labels")
-	}
 	m, _ := json.Marshal(rule)
-	req, _ := http.NewRequestWithContext(ctx, "POST", addr+path.Join("/pd/api/v1/config/rule"), bytes.NewReader(m))
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return errors.Trace(err)
-	}
-	return errors.Trace(res.Body.Close())
+	_, err := c.pdHTTP.Request(ctx, "pd/api/v1/config/rule", http.MethodPost, m)
+	return errors.Trace(err)
 }
 
 func (c *pdClient) DeletePlacementRule(ctx context.Context, groupID, ruleID string) error {
-	addr := c.getPDAPIAddr()
-	if addr == "" {
-		return errors.Annotate(berrors.ErrPDLeaderNotFound, "failed to add stores labels")
-	}
-	req, _ := http.NewRequestWithContext(ctx, "DELETE", addr+path.Join("/pd/api/v1/config/rule", groupID, ruleID), nil)
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return errors.Trace(err)
-	}
-	return errors.Trace(res.Body.Close())
+	_, err := c.pdHTTP.Request(ctx, path.Join("pd/api/v1/config/rule", groupID, ruleID), http.MethodDelete, nil)
+	return errors.Trace(err)
 }
 
 func (c *pdClient) SetStoresLabel(
 	ctx context.Context, stores []uint64, labelKey, labelValue string,
 ) error {
 	b := []byte(fmt.Sprintf(`{"%s": "%s"}`, labelKey, labelValue))
-	addr := c.getPDAPIAddr()
-	if addr == "" {
-		return errors.Annotate(berrors.ErrPDLeaderNotFound, "failed to add stores labels")
-	}
 	for _, id := range stores {
-		req, _ := http.NewRequestWithContext(
-			ctx, "POST",
-			addr+path.Join("/pd/api/v1/store", strconv.FormatUint(id, 10), "label"),
-			bytes.NewReader(b),
-		)
-		res, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return errors.Trace(err)
-		}
-		err = res.Body.Close()
+		_, err := c.pdHTTP.Request(ctx,
+			path.Join("pd/api/v1/store", strconv.FormatUint(id, 10), "label"), http.MethodPost, b)
 		if err != nil {
 			return errors.Trace(err)
 		}
 	}
 	return nil
 }
-
-func (c *pdClient) getPDAPIAddr() string {
-	addr := c.client.GetLeaderAddr()
-	if addr != "" && !strings.HasPrefix(addr, "http") {
-		addr = "http://" + addr
-	}
-	return strings.TrimRight(addr, "/")
-}
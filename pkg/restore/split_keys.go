@@ -0,0 +1,199 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/rtree"
+)
+
+// SplitKeys is the keys given by users to split the regions at, so the
+// regions of the known hot spots are shaped before the data is ingested.
+type SplitKeys struct {
+	// raw is the raw keys split at as they are.
+	raw [][]byte
+	// handles is the int handles of the tables keyed by `db.table`.
+	handles map[string][]int64
+}
+
+// ParseSplitKeys parses the split keys, one per line, in the form of
+//
+//	# the row of handle 1000000 of the table test.orders
+//	test.orders 1000000
+//	# a raw key in hex
+//	7480000000000000ff2d5f728000000000000064
+//
+// The table names are case insensitive. A handle of a partitioned table is
+// split at in every partition.
+func ParseSplitKeys(data []byte) (*SplitKeys, error) {
+	keys := &SplitKeys{handles: make(map[string][]int64)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		switch len(fields) {
+		case 1:
+			key, err := hex.DecodeString(fields[0])
+			if err != nil || len(key) == 0 {
+				return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+					"invalid raw key %s at line %d, must be in hex", fields[0], line)
+			}
+			keys.raw = append(keys.raw, key)
+		case 2:
+			parts := strings.Split(fields[0], ".")
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+					"invalid table %s at line %d, must be `db.table`", fields[0], line)
+			}
+			handle, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+					"invalid handle %s at line %d, must be an integer", fields[1], line)
+			}
+			name := strings.ToLower(fields[0])
+			keys.handles[name] = append(keys.handles[name], handle)
+		default:
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"invalid split key at line %d, must be `db.table handle` or a raw key in hex", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return keys, nil
+}
+
+// Len returns the count of the split keys.
+func (k *SplitKeys) Len() int {
+	n := len(k.raw)
+	for _, handles := range k.handles {
+		n += len(handles)
+	}
+	return n
+}
+
+// RawKeys returns the raw keys to split at.
+func (k *SplitKeys) RawKeys() [][]byte {
+	return k.raw
+}
+
+// Tables returns the `db.table` names of the tables with split handles.
+func (k *SplitKeys) Tables() []string {
+	tables := make([]string, 0, len(k.handles))
+	for name := range k.handles {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// TableKeys returns the row keys of the split handles of the table.
+func (k *SplitKeys) TableKeys(db, table string, info *model.TableInfo) [][]byte {
+	handles := k.handles[strings.ToLower(db)+"."+strings.ToLower(table)]
+	if len(handles) == 0 {
+		return nil
+	}
+	physicalIDs := []int64{info.ID}
+	if info.Partition != nil {
+		physicalIDs = physicalIDs[:0]
+		for _, def := range info.Partition.Definitions {
+			physicalIDs = append(physicalIDs, def.ID)
+		}
+	}
+	keys := make([][]byte, 0, len(handles)*len(physicalIDs))
+	for _, id := range physicalIDs {
+		for _, handle := range handles {
+			keys = append(keys, tablecodec.EncodeRowKeyWithHandle(id, kv.IntHandle(handle)))
+		}
+	}
+	return keys
+}
+
+// SplitKeys splits the regions at the raw keys.
+func (rs *RegionSplitter) SplitKeys(ctx context.Context, keys [][]byte, onSplit OnSplitFunc) error {
+	sorted := make([][]byte, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	// Every key is an empty range, so the regions are split at its end.
+	ranges := make([]rtree.Range, 0, len(sorted))
+	for i, key := range sorted {
+		if i > 0 && bytes.Equal(key, sorted[i-1]) {
+			continue
+		}
+		ranges = append(ranges, rtree.Range{StartKey: key, EndKey: key})
+	}
+	return errors.Trace(rs.Split(ctx, ranges, nil, onSplit))
+}
+
+// SplitKeys splits the regions at the raw keys, e.g. the split keys given by
+// users.
+func (rc *Client) SplitKeys(ctx context.Context, keys [][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	splitter := NewRegionSplitter(NewSplitClient(rc.GetPDClient(), rc.GetPDTLSConfig(), rc.GetTLSConfig(), rc.maxMsgSize))
+	splitter.SetScatterPriority(rc.scatterPriority)
+	splitter.SetRegionDumper(rc.regionDumper)
+	splitter.SetScatterCache(rc.scatterCache)
+	return splitter.SplitKeys(ctx, keys, func(keys [][]byte) {
+		for _, key := range keys {
+			// The cached regions containing the split keys are stale.
+			rc.fileImporter.regionCache.invalidateKey(key)
+			rc.fileImporter.regionCache.invalidateKey(codec.EncodeBytes([]byte{}, key))
+		}
+	})
+}
+
+// GoSplitTableKeys splits the regions of the created tables at their split
+// handles, before their ranges are restored.
+func (rc *Client) GoSplitTableKeys(
+	ctx context.Context,
+	splitKeys *SplitKeys,
+	inCh <-chan CreatedTable,
+	errCh chan<- error,
+) <-chan CreatedTable {
+	outCh := make(chan CreatedTable, defaultChannelSize)
+	go func() {
+		defer close(outCh)
+		for tbl := range inCh {
+			keys := splitKeys.TableKeys(tbl.OldTable.DB.Name.O, tbl.OldTable.Info.Name.O, tbl.Table)
+			if len(keys) > 0 {
+				log.Info("split the table at the given keys",
+					zap.Stringer("db", tbl.OldTable.DB.Name),
+					zap.Stringer("table", tbl.OldTable.Info.Name),
+					zap.Int("keys", len(keys)))
+				if err := rc.SplitKeys(ctx, keys); err != nil {
+					errCh <- errors.Annotatef(err, "failed to split the table %s.%s",
+						tbl.OldTable.DB.Name, tbl.OldTable.Info.Name)
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				errCh <- ctx.Err()
+				return
+			case outCh <- tbl:
+			}
+		}
+	}()
+	return outCh
+}
@@ -0,0 +1,46 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+
+	"github.com/pingcap/br/pkg/restore"
+)
+
+var _ = Suite(&testSplitKeysSuite{})
+
+type testSplitKeysSuite struct{}
+
+func (s *testSplitKeysSuite) TestParseSplitKeys(c *C) {
+	keys, err := restore.ParseSplitKeys([]byte(`
+# hot spots of the orders
+Test.Orders 1000000
+test.orders   2000000
+7480000000000000ff2d5f72
+`))
+	c.Assert(err, IsNil)
+	c.Assert(keys.Len(), Equals, 3)
+	c.Assert(keys.Tables(), DeepEquals, []string{"test.orders"})
+	c.Assert(keys.RawKeys(), DeepEquals, [][]byte{{0x74, 0x80, 0, 0, 0, 0, 0, 0, 0xff, 0x2d, 0x5f, 0x72}})
+
+	info := &model.TableInfo{ID: 42}
+	c.Assert(keys.TableKeys("TEST", "orders", info), DeepEquals, [][]byte{
+		tablecodec.EncodeRowKeyWithHandle(42, kv.IntHandle(1000000)),
+		tablecodec.EncodeRowKeyWithHandle(42, kv.IntHandle(2000000)),
+	})
+	c.Assert(keys.TableKeys("test", "t", info), HasLen, 0)
+	// The handles are split at in every partition.
+	info.Partition = &model.PartitionInfo{Definitions: []model.PartitionDefinition{{ID: 43}, {ID: 44}}}
+	partitionKeys := keys.TableKeys("test", "orders", info)
+	c.Assert(partitionKeys, HasLen, 4)
+	c.Assert(partitionKeys[2], DeepEquals, tablecodec.EncodeRowKeyWithHandle(44, kv.IntHandle(1000000)))
+
+	for _, invalid := range []string{"test.orders abc", "orders 1", "xyz", "test.orders 1 2"} {
+		_, err = restore.ParseSplitKeys([]byte(invalid))
+		c.Assert(err, ErrorMatches, ".*line 1.*", Commentf("%s", invalid))
+	}
+}
@@ -29,6 +29,9 @@ type testClient struct {
 	regions      map[uint64]*restore.RegionInfo
 	regionsInfo  *core.RegionsInfo // For now it's only used in ScanRegions
 	nextRegionID uint64
+	// scatterFailures is the count of the scatter requests to fail.
+	scatterFailures int
+	scattered       map[uint64]bool
 }
 
 func newTestClient(
@@ -45,6 +48,7 @@ func newTestClient(
 		regions:      regions,
 		regionsInfo:  regionsInfo,
 		nextRegionID: nextRegionID,
+		scattered:    make(map[uint64]bool),
 	}
 }
 
@@ -163,6 +167,13 @@ func (c *testClient) BatchSplitRegions(
 }
 
 func (c *testClient) ScatterRegion(ctx context.Context, regionInfo *restore.RegionInfo) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scatterFailures > 0 {
+		c.scatterFailures--
+		return errors.New("store 1 is unhealthy")
+	}
+	c.scattered[regionInfo.Region.GetId()] = true
 	return nil
 }
 
@@ -380,3 +391,37 @@ func (s *testRestoreUtilSuite) TestNeedSplit(c *C) {
 	// Out of region
 	c.Assert(restore.NeedSplit([]byte("e"), regions), IsNil)
 }
+
+func (s *testRestoreUtilSuite) TestSplitWithLowScatterPriority(c *C) {
+	client := initTestClient()
+	regionSplitter := restore.NewRegionSplitter(client)
+	regionSplitter.SetScatterPriority(restore.ScatterPriorityLow)
+
+	err := regionSplitter.Split(context.Background(), initRanges(), initRewriteRules(), func(key [][]byte) {})
+	c.Assert(err, IsNil)
+	c.Assert(validateRegions(client.GetAllRegions()), IsTrue)
+}
+
+func (s *testRestoreUtilSuite) TestSplitRetryScatter(c *C) {
+	client := initTestClient()
+	client.scatterFailures = 3
+	regionSplitter := restore.NewRegionSplitter(client)
+
+	err := regionSplitter.Split(context.Background(), initRanges(), initRewriteRules(), func(key [][]byte) {})
+	c.Assert(err, IsNil)
+	c.Assert(validateRegions(client.GetAllRegions()), IsTrue)
+	// All the new regions are scattered by the retry pass.
+	c.Assert(client.scatterFailures, Equals, 0)
+	c.Assert(client.scattered, HasLen, len(client.GetAllRegions())-len(initTestClient().GetAllRegions()))
+}
+
+func (s *testRestoreUtilSuite) TestParseScatterPriority(c *C) {
+	p, err := restore.ParseScatterPriority("")
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, restore.ScatterPriorityNormal)
+	p, err = restore.ParseScatterPriority("HIGH")
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, restore.ScatterPriorityHigh)
+	_, err = restore.ParseScatterPriority("urgent")
+	c.Assert(err, ErrorMatches, ".*invalid scatter priority.*")
+}
@@ -0,0 +1,372 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"github.com/OneOfOne/xxhash"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/pingcap/errors"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// The constants of the RocksDB block-based table format, which the SST files
+// of the backup are in.
+const (
+	sstMagicNumber       uint64 = 0x88e241b785f4cff7
+	sstLegacyMagicNumber uint64 = 0xdb4775248b80fb57
+	// the footer of format version 1+ is the checksum type, 2 padded block
+	// handles, the format version and the magic number.
+	sstFooterSize = 1 + 40 + 4 + 8
+	// the legacy footer is 2 padded block handles and the magic number.
+	sstLegacyFooterSize = 40 + 8
+	// every block is followed by the compression type and the checksum.
+	sstBlockTrailerSize = 5
+	// an internal key is the user key followed by the sequence and the type.
+	sstInternalKeySuffix = 8
+	sstValueTypeValue    = 1
+	sstNoChecksum        = 0
+	sstChecksumCRC32c    = 1
+	sstChecksumXXHash    = 2
+	sstChecksumXXHash64  = 3
+
+	sstNoCompression     = 0x0
+	sstSnappyCompression = 0x1
+	sstLZ4Compression    = 0x4
+	sstLZ4HCCompression  = 0x5
+	sstZstdCompression   = 0x7
+	// the zstd compression before RocksDB 5.4.
+	sstZstdNotFinalCompression = 0x40
+
+	// sstMaxBlockSize is the hard limit of the decompressed size of a block,
+	// the blocks of TiKV are 64KiB by default.
+	sstMaxBlockSize = 64 << 20
+	// sstMaxCompressionRatio limits the decompressed size by the size of the
+	// compressed block, no codec of RocksDB compresses a block better than
+	// the RLE block of zstd.
+	sstMaxCompressionRatio = 1 << 15
+)
+
+var (
+	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+	zstdDecoderOnce sync.Once
+	zstdDecoder     *zstd.Decoder
+	zstdDecoderErr  error
+)
+
+// sstReader reads the key-value pairs of an SST file block by block, it's used
+// by the logical restore which can't ingest the files into TiKV.
+type sstReader struct {
+	r             io.ReadSeeker
+	size          uint64
+	checksumType  byte
+	formatVersion uint32
+	// footerHandles is the encoded handles of the meta index and the index.
+	footerHandles []byte
+}
+
+type sstBlockHandle struct {
+	offset uint64
+	size   uint64
+}
+
+func decodeSSTBlockHandle(b []byte) (sstBlockHandle, []byte, error) {
+	offset, n := binary.Uvarint(b)
+	if n <= 0 {
+		return sstBlockHandle{}, nil, errors.Annotate(berrors.ErrRestoreInvalidSST, "invalid block handle")
+	}
+	size, m := binary.Uvarint(b[n:])
+	if m <= 0 {
+		return sstBlockHandle{}, nil, errors.Annotate(berrors.ErrRestoreInvalidSST, "invalid block handle")
+	}
+	return sstBlockHandle{offset: offset, size: size}, b[n+m:], nil
+}
+
+func newSSTReader(r io.ReadSeeker) (*sstReader, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	reader := &sstReader{r: r, size: uint64(size)}
+	if reader.size < sstLegacyFooterSize {
+		return nil, errors.Annotate(berrors.ErrRestoreInvalidSST, "the file is too short")
+	}
+	footerSize := uint64(sstFooterSize)
+	if reader.size < footerSize {
+		footerSize = sstLegacyFooterSize
+	}
+	footer, err := reader.readAt(reader.size-footerSize, footerSize)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	magic := binary.LittleEndian.Uint64(footer[len(footer)-8:])
+	switch {
+	case magic == sstMagicNumber && len(footer) == sstFooterSize:
+		reader.checksumType = footer[0]
+		reader.footerHandles = footer[1 : 1+40]
+		reader.formatVersion = binary.LittleEndian.Uint32(footer[1+40:])
+	case magic == sstLegacyMagicNumber:
+		reader.checksumType = sstChecksumCRC32c
+		reader.footerHandles = footer[len(footer)-sstLegacyFooterSize : len(footer)-8]
+	case magic == sstMagicNumber:
+		return nil, errors.Annotate(berrors.ErrRestoreInvalidSST, "the file is too short")
+	default:
+		return nil, errors.Annotatef(berrors.ErrRestoreInvalidSST, "unknown magic number %x", magic)
+	}
+	return reader, nil
+}
+
+// readAt reads n bytes at the offset of the file.
+func (r *sstReader) readAt(offset, n uint64) ([]byte, error) {
+	if n > r.size || offset > r.size-n {
+		return nil, errors.Annotatef(berrors.ErrRestoreInvalidSST, "[%d, %d) out of the file", offset, offset+n)
+	}
+	if _, err := r.r.Seek(int64(offset), io.SeekStart); err != nil {
+		return nil, errors.Trace(err)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return data, nil
+}
+
+// indexHandle returns the handle of the index block.
+func (r *sstReader) indexHandle() (sstBlockHandle, error) {
+	// The meta index comes first.
+	_, b, err := decodeSSTBlockHandle(r.footerHandles)
+	if err != nil {
+		return sstBlockHandle{}, errors.Trace(err)
+	}
+	index, _, err := decodeSSTBlockHandle(b)
+	return index, errors.Trace(err)
+}
+
+// verifyBlock verifies the checksum of the block and its compression type.
+func (r *sstReader) verifyBlock(handle sstBlockHandle, block []byte, compression byte, expected uint32) error {
+	var checksum uint32
+	switch r.checksumType {
+	case sstNoChecksum:
+		return nil
+	case sstChecksumCRC32c:
+		crc := crc32.Update(crc32.Checksum(block, crc32cTable), crc32cTable, []byte{compression})
+		// RocksDB masks the stored CRC.
+		checksum = ((crc >> 15) | (crc << 17)) + 0xa282ead8
+	case sstChecksumXXHash:
+		h := xxhash.New32()
+		_, _ = h.Write(block)
+		_, _ = h.Write([]byte{compression})
+		checksum = h.Sum32()
+	case sstChecksumXXHash64:
+		h := xxhash.New64()
+		_, _ = h.Write(block)
+		_, _ = h.Write([]byte{compression})
+		// Only the lower 32 bits are stored.
+		checksum = uint32(h.Sum64())
+	default:
+		return errors.Annotatef(berrors.ErrRestoreInvalidSST,
+			"the checksum type %d isn't supported by the logical restore", r.checksumType)
+	}
+	if checksum != expected {
+		return errors.Annotatef(berrors.ErrRestoreInvalidSST, "checksum mismatch of block at %d", handle.offset)
+	}
+	return nil
+}
+
+// maxDecompressedSize returns the limit of the decompressed size of the block,
+// since the sizes recorded in the block can't be trusted.
+func maxDecompressedSize(compressedSize uint64) uint64 {
+	if compressedSize >= sstMaxBlockSize/sstMaxCompressionRatio {
+		return sstMaxBlockSize
+	}
+	return compressedSize * sstMaxCompressionRatio
+}
+
+// readBlock reads, verifies and decompresses the block.
+func (r *sstReader) readBlock(handle sstBlockHandle) ([]byte, error) {
+	if handle.size > r.size {
+		return nil, errors.Annotatef(berrors.ErrRestoreInvalidSST, "the block at %d is out of the file", handle.offset)
+	}
+	data, err := r.readAt(handle.offset, handle.size+sstBlockTrailerSize)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read block at %d", handle.offset)
+	}
+	block := data[:handle.size]
+	compression := data[handle.size]
+	expected := binary.LittleEndian.Uint32(data[handle.size+1:])
+	if err := r.verifyBlock(handle, block, compression, expected); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	limit := maxDecompressedSize(handle.size)
+	switch compression {
+	case sstNoCompression:
+		return block, nil
+	case sstSnappyCompression:
+		size, err := snappy.DecodedLen(block)
+		if err != nil {
+			return nil, errors.Annotate(berrors.ErrRestoreInvalidSST, "invalid size of snappy block")
+		}
+		if uint64(size) > limit {
+			return nil, errors.Annotatef(berrors.ErrRestoreInvalidSST,
+				"the snappy block at %d is decompressed to %d bytes, exceeds %d", handle.offset, size, limit)
+		}
+		data, err := snappy.Decode(nil, block)
+		return data, errors.Annotate(err, "failed to decompress snappy block")
+	case sstLZ4Compression, sstLZ4HCCompression:
+		var size uint64
+		if r.formatVersion >= 2 {
+			// The decompressed size is prefixed since format version 2.
+			var n int
+			size, n = binary.Uvarint(block)
+			if n <= 0 {
+				return nil, errors.Annotate(berrors.ErrRestoreInvalidSST, "invalid size of lz4 block")
+			}
+			block = block[n:]
+		} else {
+			if len(block) < 8 {
+				return nil, errors.Annotate(berrors.ErrRestoreInvalidSST, "invalid size of lz4 block")
+			}
+			size, block = binary.LittleEndian.Uint64(block), block[8:]
+		}
+		if size > limit {
+			return nil, errors.Annotatef(berrors.ErrRestoreInvalidSST,
+				"the lz4 block at %d is decompressed to %d bytes, exceeds %d", handle.offset, size, limit)
+		}
+		data, err := decompressLZ4(block, int(size))
+		return data, errors.Trace(err)
+	case sstZstdCompression, sstZstdNotFinalCompression:
+		// The decompressed size is always prefixed.
+		size, n := binary.Uvarint(block)
+		if n <= 0 {
+			return nil, errors.Annotate(berrors.ErrRestoreInvalidSST, "invalid size of zstd block")
+		}
+		if size > limit {
+			return nil, errors.Annotatef(berrors.ErrRestoreInvalidSST,
+				"the zstd block at %d is decompressed to %d bytes, exceeds %d", handle.offset, size, limit)
+		}
+		data, err := decompressZstd(block[n:], int(size))
+		return data, errors.Trace(err)
+	default:
+		return nil, errors.Annotatef(berrors.ErrRestoreInvalidSST,
+			"the compression type %d isn't supported by the logical restore", compression)
+	}
+}
+
+// decompressLZ4 decompresses the raw LZ4 block, whose decompressed size is
+// known.
+func decompressLZ4(src []byte, size int) ([]byte, error) {
+	dst := make([]byte, size)
+	n, err := lz4.UncompressBlock(src, dst)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrRestoreInvalidSST, "failed to decompress lz4 block: %v", err)
+	}
+	if n != size {
+		return nil, errors.Annotatef(berrors.ErrRestoreInvalidSST,
+			"the lz4 block is decompressed to %d bytes, expect %d", n, size)
+	}
+	return dst, nil
+}
+
+// decompressZstd decompresses the zstd frame, whose decompressed size is
+// known.
+func decompressZstd(src []byte, size int) ([]byte, error) {
+	zstdDecoderOnce.Do(func() {
+		// The decoder is safe for concurrent DecodeAll, and never allocates
+		// more than a block may be decompressed to.
+		zstdDecoder, zstdDecoderErr = zstd.NewReader(nil,
+			zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(sstMaxBlockSize))
+	})
+	if zstdDecoderErr != nil {
+		return nil, errors.Trace(zstdDecoderErr)
+	}
+	dst, err := zstdDecoder.DecodeAll(src, make([]byte, 0, size))
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrRestoreInvalidSST, "failed to decompress zstd block: %v", err)
+	}
+	if len(dst) != size {
+		return nil, errors.Annotatef(berrors.ErrRestoreInvalidSST,
+			"the zstd block is decompressed to %d bytes, expect %d", len(dst), size)
+	}
+	return dst, nil
+}
+
+// iterateBlock calls the function with every entry of the block.
+func iterateBlock(block []byte, fn func(key, value []byte) error) error {
+	if len(block) < 4 {
+		return errors.Annotate(berrors.ErrRestoreInvalidSST, "the block is too short")
+	}
+	numRestarts := binary.LittleEndian.Uint32(block[len(block)-4:])
+	if numRestarts>>31 != 0 {
+		return errors.Annotate(berrors.ErrRestoreInvalidSST, "the data block hash index isn't supported")
+	}
+	restartsOffset := len(block) - 4 - int(numRestarts)*4
+	if restartsOffset < 0 {
+		return errors.Annotate(berrors.ErrRestoreInvalidSST, "invalid restarts of the block")
+	}
+	entries := block[:restartsOffset]
+	key := make([]byte, 0, 64)
+	for len(entries) > 0 {
+		var header [3]uint64
+		for i := range header {
+			v, n := binary.Uvarint(entries)
+			if n <= 0 {
+				return errors.Annotate(berrors.ErrRestoreInvalidSST, "invalid entry of the block")
+			}
+			header[i], entries = v, entries[n:]
+		}
+		shared, nonShared, valueLen := header[0], header[1], header[2]
+		if shared > uint64(len(key)) || nonShared+valueLen > uint64(len(entries)) {
+			return errors.Annotate(berrors.ErrRestoreInvalidSST, "invalid entry of the block")
+		}
+		key = append(key[:shared], entries[:nonShared]...)
+		value := entries[nonShared : nonShared+valueLen]
+		entries = entries[nonShared+valueLen:]
+		if err := fn(key, value); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// Iterate calls the function with the user keys and values of the file in
+// order. The deleted keys are skipped, the arguments are only valid in the
+// call.
+func (r *sstReader) Iterate(fn func(key, value []byte) error) error {
+	indexHandle, err := r.indexHandle()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	index, err := r.readBlock(indexHandle)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return iterateBlock(index, func(_, value []byte) error {
+		handle, _, err := decodeSSTBlockHandle(value)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		block, err := r.readBlock(handle)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return iterateBlock(block, func(key, value []byte) error {
+			if len(key) < sstInternalKeySuffix {
+				return errors.Annotate(berrors.ErrRestoreInvalidSST, "invalid internal key")
+			}
+			userKey, suffix := key[:len(key)-sstInternalKeySuffix], key[len(key)-sstInternalKeySuffix:]
+			if suffix[0] != sstValueTypeValue {
+				return nil
+			}
+			return fn(userKey, value)
+		})
+	})
+}
@@ -0,0 +1,276 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"strings"
+
+	"github.com/OneOfOne/xxhash"
+	"github.com/pierrec/lz4"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+type testSSTReaderSuite struct{}
+
+var _ = Suite(&testSSTReaderSuite{})
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// appendBlock appends the uncompressed block of the entries and its trailer.
+func appendBlock(data []byte, keys, values [][]byte) ([]byte, sstBlockHandle) {
+	handle := sstBlockHandle{offset: uint64(len(data))}
+	block := make([]byte, 0)
+	for i := range keys {
+		block = appendUvarint(block, 0)
+		block = appendUvarint(block, uint64(len(keys[i])))
+		block = appendUvarint(block, uint64(len(values[i])))
+		block = append(append(block, keys[i]...), values[i]...)
+	}
+	// A single restart point at the first entry.
+	block = append(block, 0, 0, 0, 0, 1, 0, 0, 0)
+	handle.size = uint64(len(block))
+	crc := crc32.Update(crc32.Checksum(block, crc32cTable), crc32cTable, []byte{sstNoCompression})
+	data = append(append(data, block...), sstNoCompression)
+	data = append(data, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(data[len(data)-4:], ((crc>>15)|(crc<<17))+0xa282ead8)
+	return data, handle
+}
+
+func encodeHandle(handle sstBlockHandle) []byte {
+	return appendUvarint(appendUvarint(nil, handle.offset), handle.size)
+}
+
+func internalKey(key string, valueType byte) []byte {
+	return append([]byte(key), valueType, 0, 0, 0, 0, 0, 0, 0)
+}
+
+func (*testSSTReaderSuite) TestIterateSST(c *C) {
+	data := make([]byte, 0)
+	data, block1 := appendBlock(data,
+		[][]byte{internalKey("a", sstValueTypeValue), internalKey("b", 0)},
+		[][]byte{[]byte("1"), nil})
+	data, block2 := appendBlock(data,
+		[][]byte{internalKey("c", sstValueTypeValue)},
+		[][]byte{[]byte("3")})
+	data, metaIndex := appendBlock(data, nil, nil)
+	data, index := appendBlock(data,
+		[][]byte{internalKey("b", sstValueTypeValue), internalKey("c", sstValueTypeValue)},
+		[][]byte{encodeHandle(block1), encodeHandle(block2)})
+	footer := []byte{sstChecksumCRC32c}
+	footer = append(footer, encodeHandle(metaIndex)...)
+	footer = append(footer, encodeHandle(index)...)
+	footer = append(footer, make([]byte, 1+40-len(footer))...)
+	footer = append(footer, 2, 0, 0, 0)
+	footer = append(footer, make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(footer[len(footer)-8:], sstMagicNumber)
+	data = append(data, footer...)
+
+	reader, err := newSSTReader(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	kvs := make([]string, 0)
+	c.Assert(reader.Iterate(func(key, value []byte) error {
+		kvs = append(kvs, fmt.Sprintf("%s=%s", key, value))
+		return nil
+	}), IsNil)
+	// The deleted key b is skipped.
+	c.Assert(kvs, DeepEquals, []string{"a=1", "c=3"})
+
+	data[block2.offset] ^= 0xff
+	c.Assert(reader.Iterate(func(key, value []byte) error { return nil }), ErrorMatches, ".*checksum mismatch.*")
+}
+
+func (*testSSTReaderSuite) TestDecompressLZ4(c *C) {
+	expected := []byte(strings.Repeat("abc", 100) + "d")
+	block := make([]byte, lz4.CompressBlockBound(len(expected)))
+	n, err := lz4.CompressBlock(expected, block, make([]int, 1<<16))
+	c.Assert(err, IsNil)
+	block = block[:n]
+	data, err := decompressLZ4(block, len(expected))
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, expected)
+
+	_, err = decompressLZ4(block, len(expected)-1)
+	c.Assert(err, NotNil)
+	_, err = decompressLZ4(block, len(expected)+1)
+	c.Assert(err, ErrorMatches, ".*decompressed to.*")
+}
+
+func (*testSSTReaderSuite) TestVerifyBlock(c *C) {
+	block := []byte("block")
+	handle := sstBlockHandle{size: uint64(len(block))}
+	withType := append(append([]byte{}, block...), sstNoCompression)
+
+	r := &sstReader{checksumType: sstChecksumXXHash}
+	c.Assert(r.verifyBlock(handle, block, sstNoCompression, xxhash.Checksum32(withType)), IsNil)
+	c.Assert(r.verifyBlock(handle, block, sstNoCompression, xxhash.Checksum32(block)),
+		ErrorMatches, ".*checksum mismatch.*")
+
+	r.checksumType = sstChecksumXXHash64
+	c.Assert(r.verifyBlock(handle, block, sstNoCompression, uint32(xxhash.Checksum64(withType))), IsNil)
+	c.Assert(r.verifyBlock(handle, block, sstSnappyCompression, uint32(xxhash.Checksum64(withType))),
+		ErrorMatches, ".*checksum mismatch.*")
+
+	r.checksumType = 4
+	c.Assert(r.verifyBlock(handle, block, sstNoCompression, 0), ErrorMatches, ".*checksum type 4 isn't supported.*")
+}
+
+func (*testSSTReaderSuite) TestReadBlockLimits(c *C) {
+	c.Assert(maxDecompressedSize(10), Equals, uint64(10*sstMaxCompressionRatio))
+	c.Assert(maxDecompressedSize(1<<30), Equals, uint64(sstMaxBlockSize))
+
+	// A zstd block claims to be decompressed to 1TiB.
+	block := appendUvarint(nil, 1<<40)
+	data := append(append([]byte{}, block...), sstZstdCompression, 0, 0, 0, 0)
+	r := &sstReader{r: bytes.NewReader(data), size: uint64(len(data)), checksumType: sstNoChecksum}
+	_, err := r.readBlock(sstBlockHandle{size: uint64(len(block))})
+	c.Assert(err, ErrorMatches, ".*exceeds.*")
+
+	_, err = r.readAt(1, ^uint64(0))
+	c.Assert(err, ErrorMatches, ".*out of the file.*")
+	_, err = r.readBlock(sstBlockHandle{offset: 1, size: ^uint64(0) - 2})
+	c.Assert(err, ErrorMatches, ".*out of the file.*")
+}
+
+func (*testSSTReaderSuite) TestDecodeTxnKV(c *C) {
+	key := append([]byte{dataKeyPrefix}, codec.EncodeBytes(nil, []byte("t\x80key"))...)
+	key = append(key, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(key[len(key)-8:], ^uint64(42))
+	userKey, ts, err := decodeTxnKey(key)
+	c.Assert(err, IsNil)
+	c.Assert(string(userKey), Equals, "t\x80key")
+	c.Assert(ts, Equals, uint64(42))
+
+	record := appendUvarint([]byte{writeTypePut}, 40)
+	writeType, startTS, value, err := decodeWriteRecord(record)
+	c.Assert(err, IsNil)
+	c.Assert(writeType, Equals, byte(writeTypePut))
+	c.Assert(startTS, Equals, uint64(40))
+	c.Assert(value, IsNil)
+
+	record = append(record, shortValuePrefix, 3, 'r', 'o', 'w')
+	_, _, value, err = decodeWriteRecord(record)
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "row")
+}
+
+func (*testSSTReaderSuite) TestDecompressZstd(c *C) {
+	// The fixture is compressed by `zstd -19`.
+	data, err := ioutil.ReadFile("testdata/rows.zst")
+	c.Assert(err, IsNil)
+	var expected strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&expected, "row %d: %s\n", i, strings.Repeat(string(rune('a'+i%26)), i%17))
+	}
+	decoded, err := decompressZstd(data, expected.Len())
+	c.Assert(err, IsNil)
+	c.Assert(string(decoded), Equals, expected.String())
+
+	_, err = decompressZstd(data, expected.Len()+1)
+	c.Assert(err, ErrorMatches, ".*decompressed to.*")
+	_, err = decompressZstd(data[:len(data)/2], 0)
+	c.Assert(err, NotNil)
+}
+
+func logicalTestTable() *utils.Table {
+	id := &model.ColumnInfo{ID: 1, Name: model.NewCIStr("id"), Offset: 0, State: model.StatePublic,
+		FieldType: *types.NewFieldType(mysql.TypeLonglong)}
+	id.Flag = mysql.PriKeyFlag | mysql.NotNullFlag
+	name := &model.ColumnInfo{ID: 2, Name: model.NewCIStr("name"), Offset: 1, State: model.StatePublic,
+		FieldType: *types.NewFieldType(mysql.TypeVarchar)}
+	name.Charset, name.Collate, name.Flen = mysql.DefaultCharset, mysql.DefaultCollationName, 20
+	score := &model.ColumnInfo{ID: 3, Name: model.NewCIStr("score"), Offset: 2, State: model.StatePublic,
+		FieldType: *types.NewFieldType(mysql.TypeLong)}
+	return &utils.Table{
+		DB: &model.DBInfo{Name: model.NewCIStr("test")},
+		Info: &model.TableInfo{
+			ID:         42,
+			Name:       model.NewCIStr("t"),
+			Columns:    []*model.ColumnInfo{id, name, score},
+			PKIsHandle: true,
+			State:      model.StatePublic,
+		},
+		Files: []*backup.File{{Name: "logical_write.sst"}, {Name: "logical_default.sst"}},
+	}
+}
+
+func (*testSSTReaderSuite) TestRestoreRowsOfSST(c *C) {
+	// The fixtures are in the format of the SST files of TiKV, with the data
+	// blocks compressed by zstd. The write CF has the rows 1 and 2 in the short
+	// values, the row 3 in the default CF and the deleted row 4.
+	s, err := storage.NewLocalStorage("testdata")
+	c.Assert(err, IsNil)
+	r := NewLogicalRestorer(nil, s, 10)
+	t := newLogicalTable(logicalTestTable())
+	var rows [][]interface{}
+	files := 0
+	err = iterateRecords(context.Background(), s, t, func(key, value []byte) error {
+		args, err := r.decodeRow(t, key, value, nil)
+		rows = append(rows, args)
+		return err
+	}, func(*backup.File) error {
+		files++
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(files, Equals, 1)
+	c.Assert(rows, DeepEquals, [][]interface{}{
+		{int64(1), "alice", int64(90)},
+		{int64(2), "bob", nil},
+		{int64(3), "carol", int64(70)},
+	})
+}
+
+func (*testSSTReaderSuite) TestDatumToSQLArg(c *C) {
+	dec := new(types.MyDecimal)
+	c.Assert(dec.FromString([]byte("1.50")), IsNil)
+	datetime := types.NewTime(types.FromDate(2020, 12, 14, 1, 2, 3, 0), mysql.TypeDatetime, 0)
+	for _, ca := range []struct {
+		datum    types.Datum
+		expected interface{}
+	}{
+		{types.NewDatum(nil), nil},
+		{types.NewIntDatum(-1), int64(-1)},
+		{types.NewUintDatum(1), uint64(1)},
+		{types.NewFloat64Datum(0.5), 0.5},
+		{types.NewStringDatum("a"), "a"},
+		{types.NewBytesDatum([]byte{0}), []byte{0}},
+		{types.NewMysqlBitDatum(types.NewBinaryLiteralFromUint(5, 1)), []byte{5}},
+		{types.NewDecimalDatum(dec), "1.50"},
+		{types.NewTimeDatum(datetime), "2020-12-14 01:02:03"},
+	} {
+		c.Assert(datumToSQLArg(ca.datum), DeepEquals, ca.expected, Commentf("%v", ca.datum))
+	}
+}
+
+func (*testSSTReaderSuite) TestQualifiedCreateSQL(c *C) {
+	tbl := &utils.Table{DB: &model.DBInfo{Name: model.NewCIStr("db")}, Info: &model.TableInfo{Name: model.NewCIStr("v")}}
+	tbl.Info.View = &model.ViewInfo{}
+	c.Assert(qualifiedCreateSQL("CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`%` SQL SECURITY DEFINER "+
+		"VIEW `v` (`a`) AS SELECT `db`.`t`.`a` FROM `db`.`t`", tbl), Equals,
+		"CREATE OR REPLACE ALGORITHM=UNDEFINED DEFINER=`root`@`%` SQL SECURITY DEFINER "+
+			"VIEW `db`.`v` (`a`) AS SELECT `db`.`t`.`a` FROM `db`.`t`")
+	tbl.Info.View = nil
+	tbl.Info.Sequence = &model.SequenceInfo{}
+	c.Assert(qualifiedCreateSQL("CREATE SEQUENCE `v` start with 1", tbl), Equals,
+		"CREATE SEQUENCE IF NOT EXISTS `db`.`v` start with 1")
+	tbl.Info.Sequence = nil
+	c.Assert(qualifiedCreateSQL("CREATE TABLE `v` (`a` int)", tbl), Equals,
+		"CREATE TABLE IF NOT EXISTS `db`.`v` (`a` int)")
+}
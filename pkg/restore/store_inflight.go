@@ -0,0 +1,79 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/pingcap/errors"
+)
+
+const (
+	inflightDownload = "download"
+	inflightIngest   = "ingest"
+)
+
+// storeInflightLimiter caps the download and ingest requests in flight to
+// every store individually, so an overloaded store can't hold an unfair share
+// of the restore pipeline while the others idle. The requests waiting for a
+// slot and in flight are exposed as the queue depth metrics of the stores.
+//
+// The slot is taken before the timeout of the request starts, so the time
+// waiting for the slot isn't counted in the timeout.
+type storeInflightLimiter struct {
+	// limit is the max count of the requests in flight to a store, 0 means
+	// no limit, i.e. the requests are only tracked.
+	limit uint
+
+	mu    sync.Mutex
+	slots map[uint64]chan struct{}
+}
+
+// newStoreInflightLimiter returns a limiter tracking the download and ingest
+// requests per store, and capping the ones in flight to a store by the limit,
+// 0 means no limit.
+func newStoreInflightLimiter(limit uint) *storeInflightLimiter {
+	return &storeInflightLimiter{
+		limit: limit,
+		slots: make(map[uint64]chan struct{}),
+	}
+}
+
+func (l *storeInflightLimiter) storeSlots(storeID uint64) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slots, ok := l.slots[storeID]
+	if !ok {
+		slots = make(chan struct{}, l.limit)
+		l.slots[storeID] = slots
+	}
+	return slots
+}
+
+// acquire waits for a slot of the store, the returned func releases it.
+func (l *storeInflightLimiter) acquire(ctx context.Context, storeID uint64, op string) (func(), error) {
+	store := strconv.FormatUint(storeID, 10)
+	queued := restoreStoreQueuedRequests.WithLabelValues(store, op)
+	queued.Inc()
+	var slots chan struct{}
+	if l.limit > 0 {
+		slots = l.storeSlots(storeID)
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			queued.Dec()
+			return nil, errors.Trace(ctx.Err())
+		}
+	}
+	queued.Dec()
+	inflight := restoreStoreInflightRequests.WithLabelValues(store, op)
+	inflight.Inc()
+	return func() {
+		inflight.Dec()
+		if slots != nil {
+			<-slots
+		}
+	}, nil
+}
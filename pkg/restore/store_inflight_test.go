@@ -0,0 +1,135 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+type testStoreInflightSuite struct{}
+
+var _ = Suite(&testStoreInflightSuite{})
+
+// blockingImporter blocks the downloads until unblocked or the context is
+// done, and records the max count of the downloads in flight to every store.
+type blockingImporter struct {
+	ImporterClient
+
+	unblock chan struct{}
+
+	mu          sync.Mutex
+	inflight    map[uint64]int
+	maxInflight map[uint64]int
+}
+
+func newBlockingImporter() *blockingImporter {
+	return &blockingImporter{
+		unblock:     make(chan struct{}),
+		inflight:    make(map[uint64]int),
+		maxInflight: make(map[uint64]int),
+	}
+}
+
+func (i *blockingImporter) DownloadSST(
+	ctx context.Context,
+	storeID uint64,
+	req *import_sstpb.DownloadRequest,
+) (*import_sstpb.DownloadResponse, error) {
+	i.mu.Lock()
+	i.inflight[storeID]++
+	if i.inflight[storeID] > i.maxInflight[storeID] {
+		i.maxInflight[storeID] = i.inflight[storeID]
+	}
+	i.mu.Unlock()
+	defer func() {
+		i.mu.Lock()
+		i.inflight[storeID]--
+		i.mu.Unlock()
+	}()
+	select {
+	case <-i.unblock:
+		return &import_sstpb.DownloadResponse{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// waitInflight waits until n downloads to the store are in flight.
+func (i *blockingImporter) waitInflight(storeID uint64, n int) {
+	for {
+		i.mu.Lock()
+		taken := i.inflight[storeID] == n
+		i.mu.Unlock()
+		if taken {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func downloadTo(ctx context.Context, importer *FileImporter, storeID uint64) error {
+	_, err := importer.downloadFromPeer(ctx, &metapb.Peer{StoreId: storeID}, &backup.File{},
+		&import_sstpb.DownloadRequest{})
+	return err
+}
+
+func (s *testStoreInflightSuite) TestLimitPerStore(c *C) {
+	blocking := newBlockingImporter()
+	importer := NewFileImporter(nil, blocking, nil, false)
+	importer.SetPerStoreInflight(2)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for _, storeID := range []uint64{1, 1, 1, 1, 2} {
+		wg.Add(1)
+		go func(storeID uint64) {
+			defer wg.Done()
+			c.Assert(downloadTo(ctx, &importer, storeID), IsNil)
+		}(storeID)
+	}
+	// Wait until the slots of the stores are taken.
+	blocking.waitInflight(1, 2)
+	blocking.waitInflight(2, 1)
+	close(blocking.unblock)
+	wg.Wait()
+	c.Assert(blocking.maxInflight, DeepEquals, map[uint64]int{1: 2, 2: 1})
+
+	// The waiting request gives up once the context is done.
+	blocking = newBlockingImporter()
+	importer = NewFileImporter(nil, blocking, nil, false)
+	importer.SetPerStoreInflight(1)
+	go func() {
+		_ = downloadTo(ctx, &importer, 1)
+	}()
+	blocking.waitInflight(1, 1)
+	cctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	c.Assert(downloadTo(cctx, &importer, 1), ErrorMatches, ".*context deadline exceeded.*")
+	close(blocking.unblock)
+}
+
+func (s *testStoreInflightSuite) TestTimeoutAfterSlot(c *C) {
+	blocking := newBlockingImporter()
+	importer := NewFileImporter(nil, blocking, nil, false)
+	importer.SetPerStoreInflight(1)
+	importer.SetIngestTimeout(IngestTimeout{Min: 300 * time.Millisecond})
+	ctx := context.Background()
+
+	// The first request times out in the store, then the second one takes the
+	// slot with the whole timeout of its own.
+	errs := make(chan error, 2)
+	go func() { errs <- downloadTo(ctx, &importer, 1) }()
+	blocking.waitInflight(1, 1)
+	go func() { errs <- downloadTo(ctx, &importer, 1) }()
+	time.Sleep(450 * time.Millisecond)
+	close(blocking.unblock)
+	c.Assert(<-errs, ErrorMatches, ".*context deadline exceeded.*")
+	c.Assert(<-errs, IsNil)
+}
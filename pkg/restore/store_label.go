@@ -0,0 +1,90 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// StoreLabel is a label of a store captured before restore changes it.
+type StoreLabel struct {
+	StoreID uint64 `json:"store-id"`
+	Key     string `json:"key"`
+	// Value is the original value, empty if the store had no such label.
+	Value string `json:"value"`
+}
+
+// StoreLabelChange is a label change of a store made by restore.
+type StoreLabelChange struct {
+	StoreLabel
+	NewValue string `json:"new-value"`
+}
+
+// PlanRestoreLabels captures the exclusive labels of the stores, and returns
+// the changes labeling them as the restore stores. The stores already labeled
+// are skipped.
+func (rc *Client) PlanRestoreLabels(ctx context.Context, stores []uint64) ([]StoreLabelChange, error) {
+	changes := make([]StoreLabelChange, 0, len(stores))
+	for _, id := range stores {
+		store, err := rc.pdClient.GetStore(ctx, id)
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to get store %d", id)
+		}
+		if store == nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "store %d not found", id)
+		}
+		origin := StoreLabel{StoreID: id, Key: restoreLabelKey}
+		for _, l := range store.GetLabels() {
+			if l.GetKey() == restoreLabelKey {
+				origin.Value = l.GetValue()
+			}
+		}
+		if origin.Value == restoreLabelValue {
+			continue
+		}
+		changes = append(changes, StoreLabelChange{StoreLabel: origin, NewValue: restoreLabelValue})
+	}
+	return changes, nil
+}
+
+// ApplyStoreLabels applies the label changes. The applied changes are returned
+// even on error, so they can be rolled back.
+func (rc *Client) ApplyStoreLabels(ctx context.Context, changes []StoreLabelChange) ([]StoreLabel, error) {
+	applied := make([]StoreLabel, 0, len(changes))
+	for _, c := range changes {
+		log.Info("set store label",
+			zap.Uint64("store-id", c.StoreID),
+			zap.String("key", c.Key),
+			zap.String("origin", c.Value),
+			zap.String("value", c.NewValue))
+		// Record it before the change, the change may be applied on a timeout.
+		applied = append(applied, c.StoreLabel)
+		if err := rc.toolClient.SetStoresLabel(ctx, []uint64{c.StoreID}, c.Key, c.NewValue); err != nil {
+			return applied, errors.Annotatef(err, "failed to set the label of store %d", c.StoreID)
+		}
+	}
+	return applied, nil
+}
+
+// RollbackStoreLabels sets the labels back to their captured values. It goes
+// on on error, and returns all the errors.
+func (rc *Client) RollbackStoreLabels(ctx context.Context, origins []StoreLabel) error {
+	var err error
+	for _, l := range origins {
+		log.Info("roll back store label",
+			zap.Uint64("store-id", l.StoreID),
+			zap.String("key", l.Key),
+			zap.String("value", l.Value))
+		if err1 := rc.toolClient.SetStoresLabel(ctx, []uint64{l.StoreID}, l.Key, l.Value); err1 != nil {
+			err = multierr.Append(err, errors.Annotatef(err1, "failed to roll back the label of store %d", l.StoreID))
+		}
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	pd "github.com/tikv/pd/client"
+)
+
+type testStoreLabelSuite struct{}
+
+var _ = Suite(&testStoreLabelSuite{})
+
+// labelClient keeps the labels of the stores, and fails to set the labels of
+// the failing store.
+type labelClient struct {
+	SplitClient
+	stores  map[uint64]*metapb.Store
+	failing uint64
+}
+
+// labelPDClient gets the stores from the labelClient, not cached.
+type labelPDClient struct {
+	pd.Client
+	labels *labelClient
+}
+
+func (c labelPDClient) GetStore(ctx context.Context, storeID uint64) (*metapb.Store, error) {
+	return c.labels.stores[storeID], nil
+}
+
+func (c *labelClient) SetStoresLabel(ctx context.Context, stores []uint64, key, value string) error {
+	for _, id := range stores {
+		if id == c.failing {
+			return errors.Errorf("store %d is unavailable", id)
+		}
+		labels := make([]*metapb.StoreLabel, 0)
+		for _, l := range c.stores[id].Labels {
+			if l.Key != key {
+				labels = append(labels, l)
+			}
+		}
+		if value != "" {
+			labels = append(labels, &metapb.StoreLabel{Key: key, Value: value})
+		}
+		c.stores[id].Labels = labels
+	}
+	return nil
+}
+
+func (c *labelClient) label(id uint64) string {
+	for _, l := range c.stores[id].Labels {
+		if l.Key == restoreLabelKey {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+func (s *testStoreLabelSuite) TestStoreLabels(c *C) {
+	ctx := context.Background()
+	client := &labelClient{stores: map[uint64]*metapb.Store{
+		1: {Id: 1},
+		2: {Id: 2, Labels: []*metapb.StoreLabel{{Key: restoreLabelKey, Value: "serving"}}},
+		3: {Id: 3, Labels: []*metapb.StoreLabel{{Key: restoreLabelKey, Value: restoreLabelValue}}},
+	}}
+	rc := &Client{pdClient: labelPDClient{labels: client}, toolClient: client}
+
+	_, err := rc.PlanRestoreLabels(ctx, []uint64{1, 4})
+	c.Assert(err, ErrorMatches, ".*store 4 not found.*")
+
+	// The store already labeled is skipped.
+	changes, err := rc.PlanRestoreLabels(ctx, []uint64{1, 2, 3})
+	c.Assert(err, IsNil)
+	c.Assert(changes, DeepEquals, []StoreLabelChange{
+		{StoreLabel: StoreLabel{StoreID: 1, Key: restoreLabelKey}, NewValue: restoreLabelValue},
+		{StoreLabel: StoreLabel{StoreID: 2, Key: restoreLabelKey, Value: "serving"}, NewValue: restoreLabelValue},
+	})
+
+	origins, err := rc.ApplyStoreLabels(ctx, changes)
+	c.Assert(err, IsNil)
+	c.Assert(origins, HasLen, 2)
+	c.Assert(client.label(1), Equals, restoreLabelValue)
+	c.Assert(client.label(2), Equals, restoreLabelValue)
+
+	c.Assert(rc.RollbackStoreLabels(ctx, origins), IsNil)
+	c.Assert(client.label(1), Equals, "")
+	c.Assert(client.label(2), Equals, "serving")
+	c.Assert(client.label(3), Equals, restoreLabelValue)
+}
+
+func (s *testStoreLabelSuite) TestStoreLabelsFailure(c *C) {
+	ctx := context.Background()
+	client := &labelClient{stores: map[uint64]*metapb.Store{
+		1: {Id: 1},
+		2: {Id: 2, Labels: []*metapb.StoreLabel{{Key: restoreLabelKey, Value: "serving"}}},
+	}}
+	rc := &Client{pdClient: labelPDClient{labels: client}, toolClient: client}
+	changes, err := rc.PlanRestoreLabels(ctx, []uint64{1, 2})
+	c.Assert(err, IsNil)
+
+	// The failed change is returned as well, it may be applied on a timeout.
+	client.failing = 2
+	origins, err := rc.ApplyStoreLabels(ctx, changes)
+	c.Assert(err, ErrorMatches, ".*failed to set the label of store 2.*")
+	c.Assert(origins, HasLen, 2)
+	c.Assert(client.label(1), Equals, restoreLabelValue)
+
+	// The rollback goes on after a failure.
+	client.failing = 1
+	err = rc.RollbackStoreLabels(ctx, origins)
+	c.Assert(err, ErrorMatches, ".*failed to roll back the label of store 1.*")
+	client.failing = 0
+	c.Assert(rc.RollbackStoreLabels(ctx, origins), IsNil)
+	c.Assert(client.label(1), Equals, "")
+	c.Assert(client.label(2), Equals, "serving")
+}
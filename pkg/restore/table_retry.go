@@ -0,0 +1,206 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/summary"
+)
+
+// FailedTable is a table still failed after its table retries.
+type FailedTable struct {
+	DB       string
+	Table    string
+	Attempts int
+	Err      error
+}
+
+// failedTables collects the files exhausted their retry budget, keyed by the
+// physical table IDs in the backup.
+type failedTables struct {
+	mu          sync.Mutex
+	deadLetters map[int64][]DeadLetter
+	// diverted is the tables taken off the pipeline by GoDivertFailedTables.
+	diverted []CreatedTable
+}
+
+func (f *failedTables) add(physicalID int64, dl DeadLetter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.deadLetters == nil {
+		f.deadLetters = make(map[int64][]DeadLetter)
+	}
+	f.deadLetters[physicalID] = append(f.deadLetters[physicalID], dl)
+}
+
+// deadLettersOf returns the failed files of the table and its partitions.
+func (f *failedTables) deadLettersOf(table *model.TableInfo) []DeadLetter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	deadLetters := append([]DeadLetter(nil), f.deadLetters[table.ID]...)
+	if table.Partition != nil {
+		for _, def := range table.Partition.Definitions {
+			deadLetters = append(deadLetters, f.deadLetters[def.ID]...)
+		}
+	}
+	return deadLetters
+}
+
+// errOf returns the first error of the table or any of its partitions, nil
+// means the table has no failed files.
+func (f *failedTables) errOf(table *model.TableInfo) error {
+	if deadLetters := f.deadLettersOf(table); len(deadLetters) > 0 {
+		return deadLetters[0].Err
+	}
+	return nil
+}
+
+func (f *failedTables) divert(table CreatedTable) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.diverted = append(f.diverted, table)
+}
+
+func (f *failedTables) takeDiverted() []CreatedTable {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	diverted := f.diverted
+	f.diverted = nil
+	return diverted
+}
+
+// SetTableRetry sets the count of the table retries of a table whose files
+// exhausted their retry budget. Zero means failing the restore at once.
+func (rc *Client) SetTableRetry(n int) {
+	rc.tableRetry = n
+}
+
+// markFailedFiles records the dead letters for the table retry, it returns
+// false if the table retry is disabled.
+func (rc *Client) markFailedFiles(deadLetters []DeadLetter) bool {
+	if rc.tableRetry <= 0 {
+		return false
+	}
+	for _, dl := range deadLetters {
+		physicalID := tablecodec.DecodeTableID(dl.File.GetStartKey())
+		rc.failedTables.add(physicalID, dl)
+		log.Warn("file failed, it will be imported again by the table retry",
+			zap.String("file", dl.File.GetName()),
+			zap.Int64("physical table", physicalID),
+			zap.Int("attempts", dl.Attempts),
+			zap.Error(dl.Err))
+	}
+	return true
+}
+
+// GoDivertFailedTables takes the tables with failed files off the pipeline,
+// so they are neither verified nor counted as restored until
+// RetryFailedTables imports the failed files of the diverted tables again.
+// Every attempt splits the ranges of the files still failed, whose regions may
+// have changed since, and imports them with a fresh retry budget. The files
+// imported before are kept, and ingesting a file again is idempotent, so the
+// tables are never dropped or replaced. A table is verified once all its
+// files are imported. It returns the tables still failed after all the
+// attempts.
+func (rc *Client) RetryFailedTables(
+	ctx context.Context,
+	kvClient kv.Client,
+	checksum bool,
+	concurrency uint,
+	updateCh glue.Progress,
+) []FailedTable {
+	var failed []FailedTable
+	for _, tbl := range rc.failedTables.takeDiverted() {
+		deadLetters := rc.failedTables.deadLettersOf(tbl.OldTable.Info)
+		err := deadLetters[0].Err
+		attempt := 0
+		for ; attempt < rc.tableRetry && err != nil && ctx.Err() == nil; attempt++ {
+			log.Info("retry the failed files of the table",
+				zap.Stringer("db", tbl.OldTable.DB.Name),
+				zap.Stringer("table", tbl.OldTable.Info.Name),
+				zap.Int("files", len(deadLetters)),
+				zap.Int("attempt", attempt+1))
+			start := time.Now()
+			deadLetters, err = rc.retryTable(ctx, tbl, deadLetters)
+			if err != nil {
+				log.Warn("failed to retry the table",
+					zap.Stringer("db", tbl.OldTable.DB.Name),
+					zap.Stringer("table", tbl.OldTable.Info.Name),
+					zap.Int("attempt", attempt+1),
+					zap.Int("failed files", len(deadLetters)),
+					zap.Error(err))
+				continue
+			}
+			summary.CollectSuccessUnit("retried table", 1, time.Since(start))
+		}
+		if err == nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if err == nil && checksum {
+			err = rc.execChecksum(ctx, tbl, kvClient, concurrency)
+		}
+		if err != nil {
+			summary.CollectFailureUnit(tbl.OldTable.DB.Name.O+"."+tbl.OldTable.Info.Name.O, err)
+			failed = append(failed, FailedTable{
+				DB:       tbl.OldTable.DB.Name.O,
+				Table:    tbl.OldTable.Info.Name.O,
+				Attempts: attempt,
+				Err:      err,
+			})
+		}
+		updateCh.Inc()
+	}
+	return failed
+}
+
+// retryTable imports the failed files of the table again, it returns the
+// files still failed and the first error of them.
+func (rc *Client) retryTable(
+	ctx context.Context,
+	tbl CreatedTable,
+	deadLetters []DeadLetter,
+) ([]DeadLetter, error) {
+	files := make([]*backup.File, 0, len(deadLetters))
+	for _, dl := range deadLetters {
+		files = append(files, dl.File)
+	}
+	ranges, err := ValidateFileRanges(files, tbl.RewriteRule)
+	if err != nil {
+		return deadLetters, errors.Trace(err)
+	}
+	if rc.isOnline {
+		tables := []*model.TableInfo{tbl.Table}
+		if err = splitPrepareWork(ctx, rc, tables); err != nil {
+			return deadLetters, errors.Trace(err)
+		}
+		defer splitPostWork(ctx, rc, tables)
+	}
+	if err = SplitRanges(ctx, rc, ranges, tbl.RewriteRule, glue.NopProgress()); err != nil {
+		return deadLetters, errors.Trace(err)
+	}
+	// The failures of this attempt are returned, rather than diverted again.
+	failed, err := rc.scheduleFiles(ctx, files, tbl.RewriteRule, func(*backup.File) {})
+	if err != nil {
+		return deadLetters, errors.Trace(err)
+	}
+	if len(failed) > 0 {
+		return failed, errors.Trace(failed[0].Err)
+	}
+	log.Info("failed files of the table imported again",
+		zap.Stringer("db", tbl.OldTable.DB.Name),
+		zap.Stringer("table", tbl.OldTable.Info.Name),
+		zap.Int("files", len(files)))
+	return nil, nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/tablecodec"
+
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+type testTableRetrySuite struct{}
+
+var _ = Suite(&testTableRetrySuite{})
+
+func (*testTableRetrySuite) TestDivertFailedTables(c *C) {
+	rc := &Client{}
+	deadLetters := []DeadLetter{{
+		File: &backup.File{Name: "1.sst", StartKey: tablecodec.EncodeTablePrefix(12)},
+		Err:  errors.New("injected failure"),
+	}, {
+		File: &backup.File{Name: "2.sst", StartKey: tablecodec.EncodeTablePrefix(13)},
+		Err:  errors.New("another failure"),
+	}}
+	// The table retry is disabled.
+	c.Assert(rc.markFailedFiles(deadLetters), IsFalse)
+
+	rc.SetTableRetry(2)
+	c.Assert(rc.markFailedFiles(deadLetters), IsTrue)
+
+	newTable := func(id int64, partitions ...int64) CreatedTable {
+		info := &model.TableInfo{ID: id, Name: model.NewCIStr("t")}
+		if len(partitions) > 0 {
+			info.Partition = &model.PartitionInfo{}
+			for _, p := range partitions {
+				info.Partition.Definitions = append(info.Partition.Definitions, model.PartitionDefinition{ID: p})
+			}
+		}
+		return CreatedTable{
+			Table:    info,
+			OldTable: &utils.Table{DB: &model.DBInfo{Name: model.NewCIStr("test")}, Info: info},
+		}
+	}
+	inCh := make(chan CreatedTable, 3)
+	inCh <- newTable(10)
+	inCh <- newTable(11, 12, 13)
+	inCh <- newTable(12)
+	close(inCh)
+
+	errCh := make(chan error, 1)
+	var passed []int64
+	for tbl := range rc.GoDivertFailedTables(context.Background(), inCh, errCh, glue.NopProgress()) {
+		passed = append(passed, tbl.Table.ID)
+	}
+	c.Assert(passed, DeepEquals, []int64{10})
+	c.Assert(rc.DivertedTableCount(), Equals, 2)
+	c.Assert(rc.failedTables.errOf(newTable(11, 12, 13).Table), ErrorMatches, "injected failure")
+	c.Assert(rc.failedTables.errOf(newTable(10).Table), IsNil)
+	// Only the failed files of the table are imported again.
+	c.Assert(rc.failedTables.deadLettersOf(newTable(11, 12, 13).Table), DeepEquals, deadLetters)
+	c.Assert(rc.failedTables.deadLettersOf(newTable(12).Table), DeepEquals, deadLetters[:1])
+	c.Assert(rc.failedTables.deadLettersOf(newTable(10).Table), HasLen, 0)
+
+	diverted := rc.failedTables.takeDiverted()
+	c.Assert(diverted, HasLen, 2)
+	c.Assert(diverted[0].Table.ID, Equals, int64(11))
+	c.Assert(rc.DivertedTableCount(), Equals, 0)
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/domain"
+	"github.com/pingcap/tidb/infoschema"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// DefaultTiFlashWaitInterval is the interval of polling the availability of
+// the TiFlash replicas.
+const DefaultTiFlashWaitInterval = 5 * time.Second
+
+// tiflashTable is a restored table waiting for its TiFlash replicas.
+type tiflashTable struct {
+	db   model.CIStr
+	name model.CIStr
+	// available is the count of the partitions (1 for the table without
+	// partitions) whose replicas are available.
+	available int
+}
+
+// TiFlashReplicaWaiter waits until the TiFlash replicas requested by the
+// restored tables are available, so the cluster is ready for the analytics
+// traffic when the restore returns.
+type TiFlashReplicaWaiter struct {
+	// schema returns the latest info schema.
+	schema   func() (infoschema.InfoSchema, error)
+	interval time.Duration
+	tables   []*tiflashTable
+}
+
+// NewTiFlashReplicaWaiter returns a TiFlashReplicaWaiter polling the info
+// schema of the domain.
+func NewTiFlashReplicaWaiter(dom *domain.Domain) *TiFlashReplicaWaiter {
+	return newTiFlashReplicaWaiter(func() (infoschema.InfoSchema, error) {
+		// The availability is updated by the DDL owner of TiDB.
+		if err := dom.Reload(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		return dom.InfoSchema(), nil
+	})
+}
+
+func newTiFlashReplicaWaiter(schema func() (infoschema.InfoSchema, error)) *TiFlashReplicaWaiter {
+	return &TiFlashReplicaWaiter{schema: schema, interval: DefaultTiFlashWaitInterval}
+}
+
+// SetInterval sets the interval of polling the availability.
+func (w *TiFlashReplicaWaiter) SetInterval(interval time.Duration) {
+	w.interval = interval
+}
+
+// AddTables adds the restored tables requesting TiFlash replicas to wait, it
+// returns the count of the tables added.
+func (w *TiFlashReplicaWaiter) AddTables(tables []*utils.Table) (int, error) {
+	is, err := w.schema()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	added := 0
+	for _, t := range tables {
+		tbl, err := is.TableByName(t.DB.Name, t.Info.Name)
+		if err != nil {
+			return added, errors.Annotatef(err, "failed to get table %s.%s", t.DB.Name, t.Info.Name)
+		}
+		replica := tbl.Meta().TiFlashReplica
+		if replica == nil || replica.Count == 0 {
+			if t.TiFlashReplicas > 0 {
+				log.Warn("the restored table doesn't request the TiFlash replicas in the backup",
+					zap.Stringer("db", t.DB.Name),
+					zap.Stringer("table", t.Info.Name),
+					zap.Int("replicas", t.TiFlashReplicas))
+			}
+			continue
+		}
+		w.tables = append(w.tables, &tiflashTable{db: t.DB.Name, name: t.Info.Name})
+		added++
+	}
+	return added, nil
+}
+
+// Wait polls the availability until the replicas of all the tables added are
+// available, the progress increases by every table available.
+func (w *TiFlashReplicaWaiter) Wait(ctx context.Context, updateCh glue.Progress) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	pending := append([]*tiflashTable(nil), w.tables...)
+	for len(pending) > 0 {
+		is, err := w.schema()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		remaining := pending[:0]
+		for _, t := range pending {
+			tbl, err := is.TableByName(t.db, t.name)
+			if err != nil {
+				return errors.Annotatef(err, "failed to get table %s.%s", t.db, t.name)
+			}
+			available, total := tiflashReplicaProgress(tbl.Meta())
+			if available == total {
+				log.Info("TiFlash replicas available", zap.Stringer("db", t.db), zap.Stringer("table", t.name))
+				updateCh.Inc()
+				continue
+			}
+			if available != t.available {
+				log.Info("waiting for TiFlash replicas",
+					zap.Stringer("db", t.db),
+					zap.Stringer("table", t.name),
+					zap.Int("available-partitions", available),
+					zap.Int("partitions", total))
+				t.available = available
+			}
+			remaining = append(remaining, t)
+		}
+		pending = remaining
+		if len(pending) == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Annotatef(ctx.Err(), "%d tables are still waiting for TiFlash replicas", len(pending))
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// tiflashReplicaProgress returns the count of the partitions whose TiFlash
+// replicas are available, the table without partitions is a partition. The
+// table whose replicas are removed is taken as available.
+func tiflashReplicaProgress(info *model.TableInfo) (available, total int) {
+	replica := info.TiFlashReplica
+	if replica == nil || replica.Count == 0 {
+		return 1, 1
+	}
+	if pi := info.GetPartitionInfo(); pi != nil {
+		for _, def := range pi.Definitions {
+			total++
+			if replica.IsPartitionAvailable(def.ID) {
+				available++
+			}
+		}
+		return available, total
+	}
+	if replica.Available {
+		return 1, 1
+	}
+	return 0, 1
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/infoschema"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+type testTiFlashSuite struct{}
+
+var _ = Suite(&testTiFlashSuite{})
+
+type countProgress struct {
+	count int64
+}
+
+func (p *countProgress) Inc()   { atomic.AddInt64(&p.count, 1) }
+func (p *countProgress) Close() {}
+
+func (s *testTiFlashSuite) TestReplicaProgress(c *C) {
+	info := &model.TableInfo{
+		TiFlashReplica: &model.TiFlashReplicaInfo{Count: 1, AvailablePartitionIDs: []int64{2}},
+		Partition: &model.PartitionInfo{
+			Enable:      true,
+			Definitions: []model.PartitionDefinition{{ID: 1}, {ID: 2}, {ID: 3}},
+		},
+	}
+	available, total := tiflashReplicaProgress(info)
+	c.Assert(available, Equals, 1)
+	c.Assert(total, Equals, 3)
+
+	info.Partition = nil
+	available, total = tiflashReplicaProgress(info)
+	c.Assert(available, Equals, 0)
+	c.Assert(total, Equals, 1)
+	info.TiFlashReplica.Available = true
+	available, _ = tiflashReplicaProgress(info)
+	c.Assert(available, Equals, 1)
+}
+
+func (s *testTiFlashSuite) TestWait(c *C) {
+	newTable := func(id int64, name string, replica *model.TiFlashReplicaInfo) *model.TableInfo {
+		return &model.TableInfo{ID: id, Name: model.NewCIStr(name), State: model.StatePublic, TiFlashReplica: replica}
+	}
+	// The replicas of t1 become available at the third poll.
+	polls := 0
+	waiter := newTiFlashReplicaWaiter(func() (infoschema.InfoSchema, error) {
+		polls++
+		return infoschema.MockInfoSchema([]*model.TableInfo{
+			newTable(1, "t1", &model.TiFlashReplicaInfo{Count: 1, Available: polls >= 3}),
+			newTable(2, "t2", &model.TiFlashReplicaInfo{Count: 1, Available: true}),
+			newTable(3, "t3", nil),
+		}), nil
+	})
+	waiter.SetInterval(time.Millisecond)
+	db := &model.DBInfo{Name: model.NewCIStr("test")}
+	tables := []*utils.Table{
+		{DB: db, Info: &model.TableInfo{Name: model.NewCIStr("t1")}},
+		{DB: db, Info: &model.TableInfo{Name: model.NewCIStr("t2")}},
+		{DB: db, Info: &model.TableInfo{Name: model.NewCIStr("t3")}},
+	}
+	count, err := waiter.AddTables(tables)
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 2)
+
+	progress := &countProgress{}
+	c.Assert(waiter.Wait(context.Background(), progress), IsNil)
+	c.Assert(progress.count, Equals, int64(2))
+	c.Assert(polls, Equals, 3)
+
+	// The wait is canceled by the context.
+	polls = 0
+	waiter.SetInterval(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Assert(waiter.Wait(ctx, &countProgress{}), ErrorMatches, ".*1 tables are still waiting for TiFlash replicas.*")
+}
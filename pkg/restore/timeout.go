@@ -0,0 +1,62 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// IngestTimeout decides the timeout of the download and ingest RPCs of a file
+// by its size, so the large files on slow disks aren't timed out while the
+// stuck RPCs of the small files are retried soon. The zero value means no
+// timeout, which is the default.
+type IngestTimeout struct {
+	// PerMB is the time budget of every MB of the file.
+	PerMB time.Duration `json:"per-mb" toml:"per-mb"`
+	// Min and Max are the floor and ceiling of the timeout, zero Max means no
+	// ceiling. Max alone is the timeout of every file.
+	Min time.Duration `json:"min" toml:"min"`
+	Max time.Duration `json:"max" toml:"max"`
+}
+
+// ForSize returns the timeout of the RPCs of a file in the size, zero means
+// no timeout.
+func (t IngestTimeout) ForSize(size uint64) time.Duration {
+	mb := size / utils.MB
+	if size%utils.MB != 0 {
+		mb++
+	}
+	timeout := t.PerMB * time.Duration(mb)
+	if t.PerMB > 0 && timeout/t.PerMB != time.Duration(mb) {
+		timeout = math.MaxInt64
+	}
+	if timeout < t.Min {
+		timeout = t.Min
+	}
+	// Zero is no timeout, which the ceiling applies to as well.
+	if t.Max > 0 && (timeout == 0 || timeout > t.Max) {
+		timeout = t.Max
+	}
+	return timeout
+}
+
+// forFile returns the context of the RPCs of the file.
+func (t IngestTimeout) forFile(ctx context.Context, file *backup.File) (context.Context, context.CancelFunc) {
+	// The download reads the compressed file and writes the rewritten KVs,
+	// so the larger one dominates.
+	size := file.GetSize_()
+	if file.GetTotalBytes() > size {
+		size = file.GetTotalBytes()
+	}
+	timeout := t.ForSize(size)
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
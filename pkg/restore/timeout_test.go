@@ -0,0 +1,43 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"math"
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+type testIngestTimeoutSuite struct{}
+
+var _ = Suite(&testIngestTimeoutSuite{})
+
+func (s *testIngestTimeoutSuite) TestForSize(c *C) {
+	timeout := restore.IngestTimeout{
+		PerMB: time.Second,
+		Min:   10 * time.Second,
+		Max:   time.Minute,
+	}
+	c.Assert(timeout.ForSize(0), Equals, 10*time.Second)
+	c.Assert(timeout.ForSize(utils.MB), Equals, 10*time.Second)
+	// The partial MB is rounded up.
+	c.Assert(timeout.ForSize(20*utils.MB+1), Equals, 21*time.Second)
+	c.Assert(timeout.ForSize(utils.GB), Equals, time.Minute)
+	c.Assert(timeout.ForSize(math.MaxUint64), Equals, time.Minute)
+
+	// No ceiling.
+	timeout.Max = 0
+	c.Assert(timeout.ForSize(utils.GB), Equals, 1024*time.Second)
+	// A fixed timeout.
+	timeout.PerMB = 0
+	c.Assert(timeout.ForSize(utils.GB), Equals, 10*time.Second)
+	// Only the ceiling.
+	c.Assert(restore.IngestTimeout{Max: time.Minute}.ForSize(utils.GB), Equals, time.Minute)
+	c.Assert(restore.IngestTimeout{PerMB: time.Second, Max: time.Minute}.ForSize(0), Equals, time.Minute)
+	// No timeout.
+	c.Assert(restore.IngestTimeout{}.ForSize(utils.GB), Equals, time.Duration(0))
+}
@@ -0,0 +1,153 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/parser/model"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// KeyTransform is a custom key transformation applied during download, e.g.
+// remapping the tenant ID in the row key prefix. TiKV rewrites the keys by
+// replacing their prefixes, so a transformation is expressed as the rewrite
+// rules of the table.
+type KeyTransform interface {
+	// Name identifies the transformation in logs and errors.
+	Name() string
+	// Transform returns the rewrite rules of the table, given the rules
+	// generated from the table and index IDs. The keys of the rules are raw
+	// keys, and the prefixes of the data rules mustn't overlap each other,
+	// TiKV rewrites a download by a single rule.
+	Transform(oldTable *utils.Table, newTable *model.TableInfo, rules *RewriteRules) (*RewriteRules, error)
+}
+
+// RegisterKeyTransform registers a custom key transformation, the
+// transformations are applied to the rewrite rules of every restored table in
+// the registered order.
+func (rc *Client) RegisterKeyTransform(t KeyTransform) {
+	rc.keyTransforms = append(rc.keyTransforms, t)
+}
+
+func (rc *Client) transformRewriteRules(
+	oldTable *utils.Table,
+	newTable *model.TableInfo,
+	rules *RewriteRules,
+) (*RewriteRules, error) {
+	for _, t := range rc.keyTransforms {
+		transformed, err := t.Transform(oldTable, newTable, rules)
+		if err != nil {
+			return nil, errors.Annotatef(err, "key transform %s of table %s.%s",
+				t.Name(), oldTable.DB.Name, oldTable.Info.Name)
+		}
+		if err = validateRewriteRules(transformed); err != nil {
+			return nil, errors.Annotatef(err, "key transform %s of table %s.%s",
+				t.Name(), oldTable.DB.Name, oldTable.Info.Name)
+		}
+		rules = transformed
+	}
+	return rules, nil
+}
+
+// validateRewriteRules checks the rules are valid prefix replacements, and the
+// data rules don't overlap.
+func validateRewriteRules(rules *RewriteRules) error {
+	if rules == nil {
+		return errors.Annotate(berrors.ErrRestoreInvalidRewrite, "no rewrite rules")
+	}
+	oldPrefixes := make(map[string]struct{}, len(rules.Table)+len(rules.Data))
+	for _, group := range [][]*import_sstpb.RewriteRule{rules.Table, rules.Data} {
+		for _, rule := range group {
+			if len(rule.GetOldKeyPrefix()) == 0 || len(rule.GetNewKeyPrefix()) == 0 {
+				return errors.Annotatef(berrors.ErrRestoreInvalidRewrite, "empty prefix of rule %s", rule)
+			}
+			if _, ok := oldPrefixes[string(rule.GetOldKeyPrefix())]; ok {
+				return errors.Annotatef(berrors.ErrRestoreInvalidRewrite, "duplicated rules of prefix %x", rule.GetOldKeyPrefix())
+			}
+			oldPrefixes[string(rule.GetOldKeyPrefix())] = struct{}{}
+		}
+	}
+	for i, a := range rules.Data {
+		for _, b := range rules.Data[i+1:] {
+			aOld, bOld := a.GetOldKeyPrefix(), b.GetOldKeyPrefix()
+			if bytes.HasPrefix(aOld, bOld) || bytes.HasPrefix(bOld, aOld) {
+				return errors.Annotatef(berrors.ErrRestoreInvalidRewrite, "overlapped rules of prefixes %x and %x", aOld, bOld)
+			}
+		}
+	}
+	return nil
+}
+
+// matchLongestPrefix returns the rule whose prefix is the longest prefix of
+// the key.
+func matchLongestPrefix(
+	key []byte,
+	rewriteRules *RewriteRules,
+	prefixOf func(*import_sstpb.RewriteRule) []byte,
+) *import_sstpb.RewriteRule {
+	var matched *import_sstpb.RewriteRule
+	for _, group := range [][]*import_sstpb.RewriteRule{rewriteRules.Data, rewriteRules.Table} {
+		for _, rule := range group {
+			prefix := prefixOf(rule)
+			if bytes.HasPrefix(key, prefix) && (matched == nil || len(prefix) > len(prefixOf(matched))) {
+				matched = rule
+			}
+		}
+	}
+	return matched
+}
+
+func oldKeyPrefix(rule *import_sstpb.RewriteRule) []byte {
+	return rule.GetOldKeyPrefix()
+}
+
+func newKeyPrefix(rule *import_sstpb.RewriteRule) []byte {
+	return rule.GetNewKeyPrefix()
+}
+
+// prefixOverlaps returns whether the range of the prefix overlaps [start, end].
+func prefixOverlaps(prefix, start, end []byte) bool {
+	return (bytes.HasPrefix(start, prefix) || bytes.Compare(start, prefix) < 0) && bytes.Compare(prefix, end) <= 0
+}
+
+// checkRewriteOrder checks the rewrite rules applied to the keys of the file
+// preserve their order, so the rewritten file range covers all the rewritten
+// keys, and the download of a region rewrites only the keys of its rule.
+//
+// The rules applied are the data rules overlapping the file. TiKV rewrites a
+// download by a single rule, so they mustn't overlap each other, and their
+// new prefixes must keep the order of the old ones without nesting.
+func checkRewriteOrder(file *backup.File, rewriteRules *RewriteRules) error {
+	start, end := file.GetStartKey(), file.GetEndKey()
+	rules := make([]*import_sstpb.RewriteRule, 0)
+	for _, rule := range rewriteRules.Data {
+		if prefixOverlaps(rule.GetOldKeyPrefix(), start, end) {
+			rules = append(rules, rule)
+		}
+	}
+
+	for i := range rules {
+		for j := i + 1; j < len(rules); j++ {
+			a, b := rules[i], rules[j]
+			if bytes.Compare(a.GetOldKeyPrefix(), b.GetOldKeyPrefix()) > 0 {
+				a, b = b, a
+			}
+			if bytes.HasPrefix(b.GetOldKeyPrefix(), a.GetOldKeyPrefix()) {
+				return errors.Annotatef(berrors.ErrRestoreInvalidRewrite,
+					"rewrite rules %s and %s overlap in file %s", a, b, file.GetName())
+			}
+			aNew, bNew := a.GetNewKeyPrefix(), b.GetNewKeyPrefix()
+			if bytes.Compare(aNew, bNew) >= 0 || bytes.HasPrefix(bNew, aNew) {
+				return errors.Annotatef(berrors.ErrRestoreInvalidRewrite,
+					"rewrite rules %s and %s don't preserve the order of the keys in file %s", a, b, file.GetName())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/tidb/tablecodec"
+
+	"github.com/pingcap/br/pkg/restore"
+)
+
+type testKeyTransformSuite struct{}
+
+var _ = Suite(&testKeyTransformSuite{})
+
+func recordPrefix(tableID int64, suffix string) []byte {
+	return append(tablecodec.GenTableRecordPrefix(tableID), suffix...)
+}
+
+func (s *testKeyTransformSuite) TestRewriteOrder(c *C) {
+	// Remap the tenants 1 and 2 of table 1 to the tenants 5 and 6 of table 2.
+	rules := &restore.RewriteRules{
+		Table: []*import_sstpb.RewriteRule{{
+			OldKeyPrefix: tablecodec.EncodeTablePrefix(1),
+			NewKeyPrefix: tablecodec.EncodeTablePrefix(2),
+		}},
+		Data: []*import_sstpb.RewriteRule{{
+			OldKeyPrefix: recordPrefix(1, "\x01"),
+			NewKeyPrefix: recordPrefix(2, "\x05"),
+		}, {
+			OldKeyPrefix: recordPrefix(1, "\x02"),
+			NewKeyPrefix: recordPrefix(2, "\x06"),
+		}},
+	}
+	_, err := restore.ValidateFileRanges([]*backup.File{{
+		Name:     "tenant_write.sst",
+		StartKey: recordPrefix(1, "\x01a"),
+		EndKey:   recordPrefix(1, "\x01z"),
+	}}, rules)
+	c.Assert(err, IsNil)
+	_, err = restore.ValidateFileRanges([]*backup.File{{
+		Name:     "tenants_write.sst",
+		StartKey: recordPrefix(1, "\x01a"),
+		EndKey:   recordPrefix(1, "\x02z"),
+	}}, rules)
+	c.Assert(err, IsNil)
+
+	// The tenant 2 is moved before the tenant 1.
+	rules.Data[1].NewKeyPrefix = recordPrefix(2, "\x04")
+	rules.Data = append(rules.Data, &import_sstpb.RewriteRule{
+		OldKeyPrefix: recordPrefix(1, "\x03"),
+		NewKeyPrefix: recordPrefix(2, "\x07"),
+	})
+	_, err = restore.ValidateFileRanges([]*backup.File{{
+		Name:     "swapped_write.sst",
+		StartKey: recordPrefix(1, "\x01a"),
+		EndKey:   recordPrefix(1, "\x03z"),
+	}}, rules)
+	c.Assert(err, ErrorMatches, ".*don't preserve the order of the keys in file swapped_write.sst.*")
+
+	// The tenant rule overlaps the rule of the table.
+	rules.Data = []*import_sstpb.RewriteRule{{
+		OldKeyPrefix: recordPrefix(1, ""),
+		NewKeyPrefix: recordPrefix(2, ""),
+	}, {
+		OldKeyPrefix: recordPrefix(1, "\x01"),
+		NewKeyPrefix: recordPrefix(2, "\x05"),
+	}}
+	_, err = restore.ValidateFileRanges([]*backup.File{{
+		Name:     "tenant_write.sst",
+		StartKey: recordPrefix(1, "\x01a"),
+		EndKey:   recordPrefix(1, "\x01z"),
+	}}, rules)
+	c.Assert(err, ErrorMatches, ".*overlap in file tenant_write.sst.*")
+}
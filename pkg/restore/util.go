@@ -25,11 +25,17 @@ import (
 	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/glue"
 	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/redact"
 	"github.com/pingcap/br/pkg/rtree"
 	"github.com/pingcap/br/pkg/summary"
 	"github.com/pingcap/br/pkg/utils"
 )
 
+const (
+	scanRegionRetryTimes   = 3
+	scanRegionWaitInterval = 100 * time.Millisecond
+)
+
 var (
 	recordPrefixSep = []byte("_r")
 	quoteRegexp     = regexp.MustCompile("`(?:[^`]|``)*`")
@@ -363,6 +369,9 @@ func ValidateFileRewriteRule(file *backup.File, rewriteRules *RewriteRules) erro
 		)
 		return errors.Annotate(berrors.ErrRestoreInvalidRewrite, "unexpected rewrite rules")
 	}
+	if rewriteRules != nil {
+		return errors.Trace(checkRewriteOrder(file, rewriteRules))
+	}
 	return nil
 }
 
@@ -380,31 +389,11 @@ func rewriteRawKey(key []byte, rewriteRules *RewriteRules) ([]byte, *import_sstp
 }
 
 func matchOldPrefix(key []byte, rewriteRules *RewriteRules) *import_sstpb.RewriteRule {
-	for _, rule := range rewriteRules.Data {
-		if bytes.HasPrefix(key, rule.GetOldKeyPrefix()) {
-			return rule
-		}
-	}
-	for _, rule := range rewriteRules.Table {
-		if bytes.HasPrefix(key, rule.GetOldKeyPrefix()) {
-			return rule
-		}
-	}
-	return nil
+	return matchLongestPrefix(key, rewriteRules, oldKeyPrefix)
 }
 
 func matchNewPrefix(key []byte, rewriteRules *RewriteRules) *import_sstpb.RewriteRule {
-	for _, rule := range rewriteRules.Data {
-		if bytes.HasPrefix(key, rule.GetNewKeyPrefix()) {
-			return rule
-		}
-	}
-	for _, rule := range rewriteRules.Table {
-		if bytes.HasPrefix(key, rule.GetNewKeyPrefix()) {
-			return rule
-		}
-	}
-	return nil
+	return matchLongestPrefix(key, rewriteRules, newKeyPrefix)
 }
 
 func truncateTS(key []byte) []byte {
@@ -429,10 +418,16 @@ func SplitRanges(
 		elapsed := time.Since(start)
 		summary.CollectDuration("split region", elapsed)
 	}()
-	splitter := NewRegionSplitter(NewSplitClient(client.GetPDClient(), client.GetTLSConfig()))
+	splitter := NewRegionSplitter(NewSplitClient(client.GetPDClient(), client.GetPDTLSConfig(), client.GetTLSConfig(), client.maxMsgSize))
+	splitter.SetScatterPriority(client.scatterPriority)
+	splitter.SetRegionDumper(client.regionDumper)
+	splitter.SetScatterCache(client.scatterCache)
 
 	return splitter.Split(ctx, ranges, rewriteRules, func(keys [][]byte) {
-		for range keys {
+		for _, key := range keys {
+			// The cached regions containing the split keys are stale.
+			client.fileImporter.regionCache.invalidateKey(key)
+			client.fileImporter.regionCache.invalidateKey(codec.EncodeBytes([]byte{}, key))
 			updateCh.Inc()
 		}
 	})
@@ -478,6 +473,10 @@ func encodeKeyPrefix(key []byte) []byte {
 // PaginateScanRegion scan regions with a limit pagination and
 // return all regions at once.
 // It reduces max gRPC message size.
+//
+// PD may momentarily return an inconsistent view of the regions, e.g. during
+// a split, so the regions are checked to cover the range without any hole or
+// overlap, and they are scanned again if not.
 func PaginateScanRegion(
 	ctx context.Context, client SplitClient, startKey, endKey []byte, limit int,
 ) ([]*RegionInfo, error) {
@@ -488,25 +487,102 @@ func PaginateScanRegion(
 		return nil, errors.Annotatef(berrors.ErrRestoreInvalidRange, "startKey >= endKey")
 	}
 
+	var lastErr error
+	for i := 0; i < scanRegionRetryTimes; i++ {
+		if i > 0 {
+			log.Warn("scanned regions are inconsistent, scan again",
+				logutil.Key("startKey", startKey),
+				logutil.Key("endKey", endKey),
+				zap.Int("attempt", i),
+				zap.Error(lastErr))
+			select {
+			case <-ctx.Done():
+				return nil, errors.Trace(ctx.Err())
+			case <-time.After(time.Duration(i) * scanRegionWaitInterval):
+			}
+		}
+		regions, err := paginateScanRegion(ctx, client, startKey, endKey, limit)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if lastErr = CheckRegionConsistency(startKey, endKey, regions); lastErr == nil {
+			return regions, nil
+		}
+	}
+	return nil, errors.Trace(lastErr)
+}
+
+func paginateScanRegion(
+	ctx context.Context, client SplitClient, startKey, endKey []byte, limit int,
+) ([]*RegionInfo, error) {
 	regions := []*RegionInfo{}
+	err := WalkRegions(ctx, client, startKey, endKey, limit, func(_ int, region *RegionInfo) bool {
+		regions = append(regions, region)
+		return true
+	})
+	return regions, errors.Trace(err)
+}
+
+// WalkRegions scans the regions in [startKey, endKey) page by page, and calls
+// fn on every region in order until it returns false. Only a page of regions
+// is held in memory, so it works on the ranges with millions of regions.
+func WalkRegions(
+	ctx context.Context, client SplitClient, startKey, endKey []byte, limit int,
+	fn func(i int, region *RegionInfo) bool,
+) error {
+	i := 0
 	for {
 		batch, err := client.ScanRegions(ctx, startKey, endKey, limit)
 		if err != nil {
-			return nil, errors.Trace(err)
+			return errors.Trace(err)
+		}
+		for _, region := range batch {
+			if !fn(i, region) {
+				return nil
+			}
+			i++
 		}
-		regions = append(regions, batch...)
 		if len(batch) < limit {
 			// No more region
-			break
+			return nil
 		}
 		startKey = batch[len(batch)-1].Region.GetEndKey()
 		if len(startKey) == 0 ||
 			(len(endKey) > 0 && bytes.Compare(startKey, endKey) >= 0) {
 			// All key space have scanned
-			break
+			return nil
 		}
 	}
-	return regions, nil
+}
+
+// CheckRegionConsistency checks whether the regions form a contiguous,
+// non-overlapping cover of the range [startKey, endKey), an empty endKey
+// means the end of the key space.
+func CheckRegionConsistency(startKey, endKey []byte, regions []*RegionInfo) error {
+	if len(regions) == 0 {
+		return errors.Annotatef(berrors.ErrPDBatchScanRegion,
+			"no region in range [%s, %s)", redact.Key(startKey), redact.Key(endKey))
+	}
+	if first := regions[0].Region; bytes.Compare(first.GetStartKey(), startKey) > 0 {
+		return errors.Annotatef(berrors.ErrPDBatchScanRegion,
+			"the first region %d starts at %s after the range start %s",
+			first.GetId(), redact.Key(first.GetStartKey()), redact.Key(startKey))
+	}
+	if last := regions[len(regions)-1].Region; len(last.GetEndKey()) != 0 &&
+		(len(endKey) == 0 || bytes.Compare(last.GetEndKey(), endKey) < 0) {
+		return errors.Annotatef(berrors.ErrPDBatchScanRegion,
+			"the last region %d ends at %s before the range end %s",
+			last.GetId(), redact.Key(last.GetEndKey()), redact.Key(endKey))
+	}
+	for i := 1; i < len(regions); i++ {
+		prev, cur := regions[i-1].Region, regions[i].Region
+		if !bytes.Equal(prev.GetEndKey(), cur.GetStartKey()) {
+			return errors.Annotatef(berrors.ErrPDBatchScanRegion,
+				"the region %d ends at %s, but the next region %d starts at %s",
+				prev.GetId(), redact.Key(prev.GetEndKey()), cur.GetId(), redact.Key(cur.GetStartKey()))
+		}
+	}
+	return nil
 }
 
 // ZapTables make zap field of table for debuging, including table names.
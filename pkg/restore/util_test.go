@@ -224,12 +224,14 @@ func (s *testRestoreUtilSuite) TestPaginateScanRegion(c *C) {
 
 	ctx := context.Background()
 	regionMap := make(map[uint64]*restore.RegionInfo)
-	regions := []*restore.RegionInfo{}
+	// No region covers the range.
+	_, err := restore.PaginateScanRegion(ctx, newTestClient(stores, regionMap, 0), []byte{}, []byte{}, 3)
+	c.Assert(err, ErrorMatches, ".*no region in range.*")
+
+	regionMap, regions := makeRegions(1)
 	batch, err := restore.PaginateScanRegion(ctx, newTestClient(stores, regionMap, 0), []byte{}, []byte{}, 3)
 	c.Assert(err, IsNil)
 	c.Assert(batch, DeepEquals, regions)
-
-	regionMap, regions = makeRegions(1)
 	batch, err = restore.PaginateScanRegion(ctx, newTestClient(stores, regionMap, 0), []byte{}, []byte{}, 3)
 	c.Assert(err, IsNil)
 	c.Assert(batch, DeepEquals, regions)
@@ -265,6 +267,54 @@ func (s *testRestoreUtilSuite) TestPaginateScanRegion(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(batch, DeepEquals, regions[1:2])
 
+	// Walking the regions stops once the callback returns false.
+	var walked []*restore.RegionInfo
+	err = restore.WalkRegions(ctx, newTestClient(stores, regionMap, 0), []byte{}, []byte{}, 3,
+		func(i int, region *restore.RegionInfo) bool {
+			c.Assert(i, Equals, len(walked))
+			walked = append(walked, region)
+			return i < 4
+		})
+	c.Assert(err, IsNil)
+	c.Assert(walked, DeepEquals, regions[:5])
+
 	_, err = restore.PaginateScanRegion(ctx, newTestClient(stores, regionMap, 0), []byte{2}, []byte{1}, 3)
 	c.Assert(err, ErrorMatches, ".*startKey >= endKey.*")
 }
+
+func (s *testRestoreUtilSuite) TestCheckRegionConsistency(c *C) {
+	newRegion := func(id uint64, start, end string) *restore.RegionInfo {
+		return &restore.RegionInfo{
+			Region: &metapb.Region{Id: id, StartKey: []byte(start), EndKey: []byte(end)},
+		}
+	}
+
+	regions := []*restore.RegionInfo{newRegion(1, "", "b"), newRegion(2, "b", "d"), newRegion(3, "d", "")}
+	c.Assert(restore.CheckRegionConsistency([]byte("a"), []byte("e"), regions), IsNil)
+	c.Assert(restore.CheckRegionConsistency(nil, nil, regions), IsNil)
+	c.Assert(restore.CheckRegionConsistency([]byte("c"), []byte("d"), regions[1:2]), IsNil)
+
+	err := restore.CheckRegionConsistency(nil, []byte("e"), regions[1:])
+	c.Assert(err, ErrorMatches, ".*the first region 2 starts at 62 after the range start.*")
+	err = restore.CheckRegionConsistency([]byte("a"), []byte("e"), regions[:2])
+	c.Assert(err, ErrorMatches, ".*the last region 2 ends at 64 before the range end 65.*")
+	err = restore.CheckRegionConsistency([]byte("a"), nil, regions[:2])
+	c.Assert(err, ErrorMatches, ".*the last region 2 ends at 64 before the range end.*")
+
+	// A hole between the regions.
+	hole := []*restore.RegionInfo{newRegion(1, "", "b"), newRegion(3, "c", "")}
+	err = restore.CheckRegionConsistency([]byte("a"), []byte("e"), hole)
+	c.Assert(err, ErrorMatches, ".*the region 1 ends at 62, but the next region 3 starts at 63.*")
+	// Overlapped regions.
+	overlap := []*restore.RegionInfo{newRegion(1, "", "c"), newRegion(2, "b", "")}
+	err = restore.CheckRegionConsistency([]byte("a"), []byte("e"), overlap)
+	c.Assert(err, ErrorMatches, ".*the region 1 ends at 63, but the next region 2 starts at 62.*")
+}
+
+func (s *testRestoreUtilSuite) TestValidateColumnFamily(c *C) {
+	for _, cf := range []string{"default", "write", "lock"} {
+		c.Assert(restore.ValidateColumnFamily(cf), IsNil)
+	}
+	c.Assert(restore.ValidateColumnFamily("raft"), ErrorMatches, ".*column family raft doesn't exist.*")
+	c.Assert(restore.ValidateColumnFamily(""), NotNil)
+}
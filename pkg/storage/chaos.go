@@ -0,0 +1,72 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pingcap/failpoint"
+	"github.com/pingcap/log"
+)
+
+// chaosEnabled is whether the HTTP requests to the storages go through
+// chaosTransport.
+var chaosEnabled int32
+
+// EnableChaos makes the HTTP clients of the storages created afterwards
+// inject the failures of the failpoint `storage-server-error`. It's used by
+// `br chaos`.
+func EnableChaos() {
+	atomic.StoreInt32(&chaosEnabled, 1)
+}
+
+// withChaos wraps the HTTP client by chaosTransport if chaos is enabled,
+// otherwise the client is returned as is. A nil client means the default one.
+func withChaos(client *http.Client) *http.Client {
+	if atomic.LoadInt32(&chaosEnabled) == 0 {
+		return client
+	}
+	if client == nil {
+		client = &http.Client{}
+	}
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &http.Client{
+		Transport:     chaosTransport{RoundTripper: transport},
+		CheckRedirect: client.CheckRedirect,
+		Jar:           client.Jar,
+		Timeout:       client.Timeout,
+	}
+}
+
+// chaosTransport is a http.RoundTripper which may fail the requests with
+// `503 Service Unavailable` by the failpoint `storage-server-error`,
+// so the retry on the server errors can be tested end-to-end.
+type chaosTransport struct {
+	http.RoundTripper
+}
+
+func (t chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	failpoint.Inject("storage-server-error", func() {
+		log.Debug("failpoint storage-server-error injected.")
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		failpoint.Return(&http.Response{
+			Status:     "503 Service Unavailable",
+			StatusCode: http.StatusServiceUnavailable,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil)
+	})
+	return t.RoundTripper.RoundTrip(req)
+}
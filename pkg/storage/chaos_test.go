@@ -0,0 +1,32 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+type testChaosSuite struct{}
+
+var _ = Suite(&testChaosSuite{})
+
+func (r *testChaosSuite) TestWithChaos(c *C) {
+	defer atomic.StoreInt32(&chaosEnabled, 0)
+
+	client := &http.Client{Timeout: time.Minute}
+	c.Assert(withChaos(client), Equals, client)
+	c.Assert(withChaos(nil), IsNil)
+
+	EnableChaos()
+	wrapped := withChaos(client)
+	c.Assert(wrapped.Timeout, Equals, time.Minute)
+	transport, ok := wrapped.Transport.(chaosTransport)
+	c.Assert(ok, IsTrue)
+	c.Assert(transport.RoundTripper, Equals, http.DefaultTransport)
+	_, ok = withChaos(nil).Transport.(chaosTransport)
+	c.Assert(ok, IsTrue)
+}
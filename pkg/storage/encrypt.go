@@ -0,0 +1,124 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"github.com/pingcap/errors"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// encryptedFileMagic is the header prepended to every file written by
+// encryptedStorage, used to detect plaintext or foreign files on read.
+var encryptedFileMagic = []byte("BRENC1")
+
+const sidecarKeyPurpose = "br-sidecar/"
+
+// encryptedStorage wraps an ExternalStorage and transparently encrypts the
+// sidecar files written by BR itself (checkpoints, summaries, catalogs, ...).
+//
+// Every file is sealed with AES-256-GCM using a key derived from the backup
+// data key and the file name, so sidecar files share the key hierarchy of the
+// backup data, and a file cannot be swapped with another one without being
+// detected.
+type encryptedStorage struct {
+	ExternalStorage
+	dataKey []byte
+}
+
+// WithSidecarEncryption returns an ExternalStorage which encrypts all files
+// written through Write, and decrypts them in Read and Open. The dataKey must
+// be the data key used for the backup files, and must be 16, 24 or 32 bytes.
+func WithSidecarEncryption(s ExternalStorage, dataKey []byte) (ExternalStorage, error) {
+	switch len(dataKey) {
+	case 16, 24, 32:
+	default:
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+			"invalid data key length %d, must be 16, 24 or 32 bytes", len(dataKey))
+	}
+	key := make([]byte, len(dataKey))
+	copy(key, dataKey)
+	return &encryptedStorage{ExternalStorage: s, dataKey: key}, nil
+}
+
+// fileCipher derives the per-file AEAD from the data key.
+func (s *encryptedStorage) fileCipher(name string) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, s.dataKey)
+	_, _ = mac.Write([]byte(sidecarKeyPurpose + name))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, errors.Trace(err)
+}
+
+// Write encrypts data and writes it to the underlying storage.
+func (s *encryptedStorage) Write(ctx context.Context, name string, data []byte) error {
+	aead, err := s.fileCipher(name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	buf := make([]byte, len(encryptedFileMagic)+aead.NonceSize(), len(encryptedFileMagic)+aead.NonceSize()+len(data)+aead.Overhead())
+	copy(buf, encryptedFileMagic)
+	nonce := buf[len(encryptedFileMagic):]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return errors.Trace(err)
+	}
+	buf = aead.Seal(buf, nonce, data, []byte(name))
+	return s.ExternalStorage.Write(ctx, name, buf)
+}
+
+// Read reads the file from the underlying storage and decrypts it.
+func (s *encryptedStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	data, err := s.ExternalStorage.Read(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	aead, err := s.fileCipher(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	headerLen := len(encryptedFileMagic) + aead.NonceSize()
+	if len(data) < headerLen+aead.Overhead() || !bytes.Equal(data[:len(encryptedFileMagic)], encryptedFileMagic) {
+		return nil, errors.Annotatef(berrors.ErrStorageDecrypt, "file %s is not encrypted by BR", name)
+	}
+	nonce := data[len(encryptedFileMagic):headerLen]
+	plain, err := aead.Open(nil, nonce, data[headerLen:], []byte(name))
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrStorageDecrypt, "file %s: %v", name, err)
+	}
+	return plain, nil
+}
+
+// Open reads and decrypts the whole file, and returns a reader over the plaintext.
+func (s *encryptedStorage) Open(ctx context.Context, path string) (ReadSeekCloser, error) {
+	data, err := s.Read(ctx, path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return bytesReadSeekCloser{Reader: bytes.NewReader(data)}, nil
+}
+
+// CreateUploader is not supported, since the whole file must be sealed at once.
+func (s *encryptedStorage) CreateUploader(ctx context.Context, name string) (Uploader, error) {
+	return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+		"multi-part upload of encrypted file %s is not supported", name)
+}
+
+type bytesReadSeekCloser struct {
+	*bytes.Reader
+}
+
+func (bytesReadSeekCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+
+	. "github.com/pingcap/check"
+)
+
+func (r *testStorageSuite) TestSidecarEncryption(c *C) {
+	ctx := context.Background()
+	local, err := NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	_, err = WithSidecarEncryption(local, []byte("short"))
+	c.Assert(err, ErrorMatches, ".*invalid data key length.*")
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	s, err := WithSidecarEncryption(local, key)
+	c.Assert(err, IsNil)
+
+	content := []byte("checkpoint content")
+	c.Assert(s.Write(ctx, "checkpoint", content), IsNil)
+
+	raw, err := local.Read(ctx, "checkpoint")
+	c.Assert(err, IsNil)
+	c.Assert(raw, Not(DeepEquals), content)
+
+	data, err := s.Read(ctx, "checkpoint")
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, content)
+
+	reader, err := s.Open(ctx, "checkpoint")
+	c.Assert(err, IsNil)
+	data, err = ioutil.ReadAll(reader)
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, content)
+	c.Assert(reader.Close(), IsNil)
+
+	// files encrypted for another name must not be accepted.
+	c.Assert(local.Write(ctx, "summary", raw), IsNil)
+	_, err = s.Read(ctx, "summary")
+	c.Assert(err, ErrorMatches, ".*message authentication failed.*")
+
+	// plaintext files are rejected.
+	c.Assert(local.Write(ctx, "plain", content), IsNil)
+	_, err = s.Read(ctx, "plain")
+	c.Assert(err, ErrorMatches, ".*not encrypted by BR.*")
+
+	// another data key cannot decrypt the file.
+	other, err := WithSidecarEncryption(local, []byte("fedcba9876543210fedcba9876543210"))
+	c.Assert(err, IsNil)
+	_, err = other.Read(ctx, "checkpoint")
+	c.Assert(err, ErrorMatches, ".*failed to decrypt file.*")
+}
@@ -87,6 +87,9 @@ func (options *GCSBackendOptions) parseFromFlags(flags *pflag.FlagSet) error {
 type gcsStorage struct {
 	gcs    *backup.GCS
 	bucket *storage.BucketHandle
+	// tags are set as the custom metadata of the objects written, GCS has no
+	// object tags.
+	tags map[string]string
 }
 
 func (s *gcsStorage) objectName(name string) string {
@@ -95,10 +98,14 @@ func (s *gcsStorage) objectName(name string) string {
 
 // Write file to storage.
 func (s *gcsStorage) Write(ctx context.Context, name string, data []byte) error {
+	if err := beforeWrite(ctx, name, data); err != nil {
+		return err
+	}
 	object := s.objectName(name)
 	wc := s.bucket.Object(object).NewWriter(ctx)
 	wc.StorageClass = s.gcs.StorageClass
 	wc.PredefinedACL = s.gcs.PredefinedAcl
+	wc.Metadata = s.tags
 	_, err := wc.Write(data)
 	if err != nil {
 		return errors.Trace(err)
@@ -140,6 +147,16 @@ func (s *gcsStorage) FileExists(ctx context.Context, name string) (bool, error)
 	return true, nil
 }
 
+// DeleteFile deletes the file.
+func (s *gcsStorage) DeleteFile(ctx context.Context, name string) error {
+	object := s.objectName(name)
+	err := s.bucket.Object(object).Delete(ctx)
+	if errors.Cause(err) == storage.ErrObjectNotExist { // nolint:errorlint
+		return nil
+	}
+	return errors.Trace(err)
+}
+
 // Open a Reader by file path.
 func (s *gcsStorage) Open(ctx context.Context, path string) (ReadSeekCloser, error) {
 	// TODO, implement this if needed
@@ -225,7 +242,7 @@ func newGCSStorage(ctx context.Context, gcs *backup.GCS, opts *ExternalStorageOp
 			return nil, errors.Trace(err)
 		}
 	}
-	return &gcsStorage{gcs: gcs, bucket: bucket}, nil
+	return &gcsStorage{gcs: gcs, bucket: bucket, tags: opts.Tags}, nil
 }
 
 func hasSSTFiles(ctx context.Context, bucket *storage.BucketHandle, prefix string) bool {
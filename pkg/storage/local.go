@@ -25,6 +25,9 @@ type LocalStorage struct {
 }
 
 func (l *LocalStorage) Write(ctx context.Context, name string, data []byte) error {
+	if err := beforeWrite(ctx, name, data); err != nil {
+		return err
+	}
 	path := filepath.Join(l.base, name)
 	return ioutil.WriteFile(path, data, localFilePerm)
 	// the backup meta file _is_ intended to be world-readable.
@@ -41,6 +44,15 @@ func (l *LocalStorage) FileExists(ctx context.Context, name string) (bool, error
 	return pathExists(filepath)
 }
 
+// DeleteFile implement ExternalStorage.DeleteFile.
+func (l *LocalStorage) DeleteFile(ctx context.Context, name string) error {
+	err := os.Remove(filepath.Join(l.base, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return errors.Trace(err)
+}
+
 // WalkDir traverse all the files in a dir.
 //
 // fn is the function called for each regular file visited by WalkDir.
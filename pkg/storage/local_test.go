@@ -9,6 +9,9 @@ import (
 	"path/filepath"
 
 	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+
+	"github.com/pingcap/br/pkg/hook"
 )
 
 type testLocalSuite struct{}
@@ -61,3 +64,20 @@ func (r *testStorageSuite) TestWalkDirWithSoftLinkFile(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(i, Equals, 2)
 }
+
+func (r *testStorageSuite) TestWriteHook(c *C) {
+	ctx := context.Background()
+	store, err := NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	uninstall := hook.Install(hook.WithOnStorageWrite(func(ctx context.Context, name string, data []byte) error {
+		return errors.New("injected")
+	}))
+	c.Assert(store.Write(ctx, "backupmeta", []byte("meta")), ErrorMatches, "failed to write backupmeta: injected")
+	exists, err := store.FileExists(ctx, "backupmeta")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsFalse)
+
+	uninstall()
+	c.Assert(store.Write(ctx, "backupmeta", []byte("meta")), IsNil)
+}
@@ -10,7 +10,7 @@ type noopStorage struct{}
 
 // Write file to storage.
 func (*noopStorage) Write(ctx context.Context, name string, data []byte) error {
-	return nil
+	return beforeWrite(ctx, name, data)
 }
 
 // Read storage file.
@@ -23,6 +23,11 @@ func (*noopStorage) FileExists(ctx context.Context, name string) (bool, error) {
 	return false, nil
 }
 
+// DeleteFile deletes the file.
+func (*noopStorage) DeleteFile(ctx context.Context, name string) error {
+	return nil
+}
+
 // Open a Reader by file path.
 func (*noopStorage) Open(ctx context.Context, path string) (ReadSeekCloser, error) {
 	return noopReader{}, nil
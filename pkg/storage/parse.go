@@ -20,6 +20,9 @@ import (
 type BackendOptions struct {
 	S3  S3BackendOptions  `json:"s3" toml:"s3"`
 	GCS GCSBackendOptions `json:"gcs" toml:"gcs"`
+	// Tags are attached to the backup objects written by BR, only S3 and GCS
+	// support them.
+	Tags map[string]string `json:"tags" toml:"tags"`
 }
 
 // ParseRawURL parse raw url to url object.
@@ -3,6 +3,7 @@
 package storage
 
 import (
+	"context"
 	"io/ioutil"
 	"net/url"
 	"os"
@@ -11,6 +12,7 @@ import (
 
 	. "github.com/pingcap/check"
 	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/spf13/pflag"
 )
 
 func Test(t *testing.T) {
@@ -170,3 +172,33 @@ func (r *testStorageSuite) TestFormatBackendURL(c *C) {
 	})
 	c.Assert(url.String(), Equals, "gcs://bucket/some%20prefix/")
 }
+
+func (r *testStorageSuite) TestStorageTags(c *C) {
+	parse := func(args ...string) (BackendOptions, error) {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		DefineFlags(flags)
+		c.Assert(flags.Parse(args), IsNil)
+		var options BackendOptions
+		err := options.ParseFromFlags(flags)
+		return options, err
+	}
+	options, err := parse("--storage.tags", "cost-center=db,retention=90d")
+	c.Assert(err, IsNil)
+	c.Assert(options.Tags, DeepEquals, map[string]string{"cost-center": "db", "retention": "90d"})
+	c.Assert(encodeS3Tagging(options.Tags), Equals, "cost-center=db&retention=90d")
+
+	options, err = parse()
+	c.Assert(err, IsNil)
+	c.Assert(options.Tags, HasLen, 0)
+
+	_, err = parse("--storage.tags", "aws:owner=me")
+	c.Assert(err, ErrorMatches, ".*reserved prefix.*")
+	_, err = parse("--storage.tags", "a=1,b=2,c=3,d=4,e=5,f=6,g=7,h=8,i=9,j=10,k=11")
+	c.Assert(err, ErrorMatches, ".*at most 10 tags.*")
+
+	// The local storage has no place for the tags.
+	_, err = New(context.Background(), &backup.StorageBackend{
+		Backend: &backup.StorageBackend_Local{Local: &backup.Local{Path: c.MkDir()}},
+	}, &ExternalStorageOptions{Tags: map[string]string{"retention": "90d"}})
+	c.Assert(err, ErrorMatches, ".*doesn't support the tags.*")
+}
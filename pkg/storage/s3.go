@@ -16,15 +16,20 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/spf13/pflag"
+	"go.uber.org/zap"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
 
 	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/summary"
 )
 
 const (
@@ -36,6 +41,10 @@ const (
 	s3ACLOption          = "s3.acl"
 	s3ProviderOption     = "s3.provider"
 	notFound             = "NotFound"
+	// s3ForcePathStyleOption is whether to address the bucket in the path,
+	// e.g. http://endpoint/bucket/key, instead of the virtual-hosted style,
+	// e.g. http://bucket.endpoint/key.
+	s3ForcePathStyleOption = "s3.force-path-style"
 	// number of retries to make of operations.
 	maxRetries = 6
 
@@ -48,6 +57,8 @@ type S3Storage struct {
 	session *session.Session
 	svc     s3iface.S3API
 	options *backup.S3
+	// tags are attached to the objects written.
+	tags map[string]string
 }
 
 // S3Uploader does multi-part upload to s3.
@@ -164,6 +175,9 @@ func defineS3Flags(flags *pflag.FlagSet) {
 		"Leave empty to use S3 owned key.")
 	flags.String(s3ACLOption, "", "(experimental) Set the S3 canned ACLs, e.g. authenticated-read")
 	flags.String(s3ProviderOption, "", "(experimental) Set the S3 provider, e.g. aws, alibaba, ceph")
+	flags.Bool(s3ForcePathStyleOption, true,
+		"Use the path-style addressing of S3, e.g. http://endpoint/bucket/key, most S3-compatible storages "+
+			"like MinIO and Ceph need it, set to false to use the virtual-hosted style, e.g. http://bucket.endpoint/key")
 }
 
 // parseFromFlags parse S3BackendOptions from command line flags.
@@ -193,7 +207,10 @@ func (options *S3BackendOptions) parseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
-	options.ForcePathStyle = true
+	options.ForcePathStyle, err = flags.GetBool(s3ForcePathStyleOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	options.Provider, err = flags.GetString(s3ProviderOption)
 	if err != nil {
 		return errors.Trace(err)
@@ -232,8 +249,8 @@ func newS3Storage(backend *backup.S3, opts *ExternalStorageOptions) (*S3Storage,
 	if qs.Endpoint != "" {
 		awsConfig.WithEndpoint(qs.Endpoint)
 	}
-	if opts.HTTPClient != nil {
-		awsConfig.WithHTTPClient(opts.HTTPClient)
+	if httpClient := withChaos(opts.HTTPClient); httpClient != nil {
+		awsConfig.WithHTTPClient(httpClient)
 	}
 	var cred *credentials.Credentials
 	if qs.AccessKey != "" && qs.SecretAccessKey != "" {
@@ -266,8 +283,22 @@ func newS3Storage(backend *backup.S3, opts *ExternalStorageOptions) (*S3Storage,
 		}
 	}
 
-	c := s3.New(ses)
+	c := newS3Client(ses)
 	if !opts.SkipCheckPath {
+		region, err := detectS3BucketRegion(c, qs.Bucket)
+		if err != nil {
+			log.Warn("failed to detect the region of the bucket, use the configured region",
+				zap.String("bucket", qs.Bucket), zap.String("region", qs.Region), zap.Error(err))
+		} else if region != qs.Region {
+			log.Info("use the detected region of the bucket",
+				zap.String("bucket", qs.Bucket),
+				zap.String("configured-region", qs.Region),
+				zap.String("detected-region", region))
+			qs.Region = region
+			// TiKV accesses the bucket by the region too.
+			backend.Region = region
+			c = newS3Client(ses, aws.NewConfig().WithRegion(region))
+		}
 		err = checkS3Bucket(c, qs.Bucket)
 		if err != nil {
 			return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "Bucket %s is not accessible: %v", qs.Bucket, err)
@@ -279,9 +310,35 @@ func newS3Storage(backend *backup.S3, opts *ExternalStorageOptions) (*S3Storage,
 		session: ses,
 		svc:     c,
 		options: &qs,
+		tags:    opts.Tags,
 	}, nil
 }
 
+func newS3Client(ses *session.Session, cfgs ...*aws.Config) *s3.S3 {
+	c := s3.New(ses, cfgs...)
+	// Count the failed attempts which are going to be retried by the SDK.
+	c.Handlers.Retry.PushBack(func(r *request.Request) {
+		if r.Error != nil && r.RetryCount < r.MaxRetries() {
+			summary.CollectRetry(summary.RetryStorage, r.Error)
+		}
+	})
+	return c
+}
+
+// detectS3BucketRegion detects the region of the bucket by the
+// X-Amz-Bucket-Region header of HeadBucket, which is returned even if the
+// bucket is in another region and the request is redirected.
+func detectS3BucketRegion(svc s3iface.S3API, bucket string) (string, error) {
+	region, err := s3manager.GetBucketRegionWithClient(context.Background(), svc, bucket)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if region == "" {
+		return "", errors.Annotate(berrors.ErrStorageInvalidConfig, "no region in the response of HeadBucket")
+	}
+	return region, nil
+}
+
 // checkBucket checks if a bucket exists.
 func checkS3Bucket(svc *s3.S3, bucket string) error {
 	input := &s3.HeadBucketInput{
@@ -293,6 +350,9 @@ func checkS3Bucket(svc *s3.S3, bucket string) error {
 
 // Write write to s3 storage.
 func (rs *S3Storage) Write(ctx context.Context, file string, data []byte) error {
+	if err := beforeWrite(ctx, file, data); err != nil {
+		return err
+	}
 	input := &s3.PutObjectInput{
 		Body:   aws.ReadSeekCloser(bytes.NewReader(data)),
 		Bucket: aws.String(rs.options.Bucket),
@@ -310,6 +370,9 @@ func (rs *S3Storage) Write(ctx context.Context, file string, data []byte) error
 	if rs.options.StorageClass != "" {
 		input = input.SetStorageClass(rs.options.StorageClass)
 	}
+	if len(rs.tags) > 0 {
+		input = input.SetTagging(encodeS3Tagging(rs.tags))
+	}
 
 	_, err := rs.svc.PutObjectWithContext(ctx, input)
 	if err != nil {
@@ -362,6 +425,51 @@ func (rs *S3Storage) FileExists(ctx context.Context, file string) (bool, error)
 	return true, nil
 }
 
+// DeleteFile deletes the file on s3 storage.
+func (rs *S3Storage) DeleteFile(ctx context.Context, file string) error {
+	input := &s3.DeleteObjectInput{
+		Bucket: aws.String(rs.options.Bucket),
+		Key:    aws.String(rs.options.Prefix + file),
+	}
+	_, err := rs.svc.DeleteObjectWithContext(ctx, input)
+	return errors.Trace(err)
+}
+
+// AbortMultipartUploads implements MultipartAborter.
+func (rs *S3Storage) AbortMultipartUploads(ctx context.Context, subDir string) (int, error) {
+	prefix := rs.options.Prefix + subDir
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	req := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(rs.options.Bucket),
+		Prefix: aws.String(prefix),
+	}
+	aborted := 0
+	for {
+		res, err := rs.svc.ListMultipartUploadsWithContext(ctx, req)
+		if err != nil {
+			return aborted, errors.Trace(err)
+		}
+		for _, upload := range res.Uploads {
+			_, err = rs.svc.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(rs.options.Bucket),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			})
+			if err != nil {
+				return aborted, errors.Annotatef(err, "failed to abort the multipart upload of %s", aws.StringValue(upload.Key))
+			}
+			aborted++
+		}
+		if !aws.BoolValue(res.IsTruncated) {
+			return aborted, nil
+		}
+		req.KeyMarker = res.NextKeyMarker
+		req.UploadIdMarker = res.NextUploadIdMarker
+	}
+}
+
 // WalkDir traverse all the files in a dir.
 //
 // fn is the function called for each regular file visited by WalkDir.
@@ -606,6 +714,9 @@ func (rs *S3Storage) CreateUploader(ctx context.Context, name string) (Uploader,
 		Bucket: aws.String(rs.options.Bucket),
 		Key:    aws.String(rs.options.Prefix + name),
 	}
+	if len(rs.tags) > 0 {
+		input = input.SetTagging(encodeS3Tagging(rs.tags))
+	}
 	resp, err := rs.svc.CreateMultipartUploadWithContext(ctx, input)
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -616,3 +727,13 @@ func (rs *S3Storage) CreateUploader(ctx context.Context, name string) (Uploader,
 		completeParts: make([]*s3.CompletedPart, 0, 128),
 	}, nil
 }
+
+// encodeS3Tagging encodes the tags as the URL query parameters, which is the
+// form of the tags in the x-amz-tagging header.
+func encodeS3Tagging(tags map[string]string) string {
+	values := make(url.Values, len(tags))
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
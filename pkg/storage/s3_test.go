@@ -9,7 +9,10 @@ import (
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -408,6 +411,36 @@ func (s *s3Suite) TestS3Storage(c *C) {
 	}
 }
 
+// TestS3DetectRegion checks the region of the bucket is detected from the
+// redirect of HeadBucket.
+func (s *s3Suite) TestS3DetectRegion(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, Equals, http.MethodHead)
+		c.Assert(r.URL.Path, Equals, "/bucket")
+		if strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/s3/") {
+			return
+		}
+		w.Header().Set("X-Amz-Bucket-Region", "eu-west-1")
+		w.WriteHeader(http.StatusMovedPermanently)
+	}))
+	defer server.Close()
+
+	s3 := &backup.S3{
+		Region:          "us-east-1",
+		Endpoint:        server.URL,
+		Bucket:          "bucket",
+		Prefix:          "prefix",
+		ForcePathStyle:  true,
+		AccessKey:       "ab",
+		SecretAccessKey: "cd",
+	}
+	_, err := New(aws.BackgroundContext(), &backup.StorageBackend{
+		Backend: &backup.StorageBackend_S3{S3: s3},
+	}, &ExternalStorageOptions{SendCredentials: true})
+	c.Assert(err, IsNil)
+	c.Assert(s3.Region, Equals, "eu-west-1")
+}
+
 func (s *s3Suite) TestS3URI(c *C) {
 	backend, err := ParseBackend("s3://bucket/prefix/", nil)
 	c.Assert(err, IsNil)
@@ -11,6 +11,7 @@ import (
 	"github.com/pingcap/kvproto/pkg/backup"
 
 	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/hook"
 )
 
 // WalkOption is the option of storage.WalkDir.
@@ -62,6 +63,8 @@ type ExternalStorage interface {
 	Read(ctx context.Context, name string) ([]byte, error)
 	// FileExists return true if file exists
 	FileExists(ctx context.Context, name string) (bool, error)
+	// DeleteFile deletes the file, it's not an error if the file doesn't exist.
+	DeleteFile(ctx context.Context, name string) error
 	// Open a Reader by file path. path is relative path to storage base path
 	Open(ctx context.Context, path string) (ReadSeekCloser, error)
 	// WalkDir traverse all the files in a dir.
@@ -81,6 +84,14 @@ type ExternalStorage interface {
 	CreateUploader(ctx context.Context, name string) (Uploader, error)
 }
 
+// MultipartAborter is implemented by the storages whose interrupted multipart
+// uploads leave the uploaded parts behind, which are invisible to WalkDir.
+type MultipartAborter interface {
+	// AbortMultipartUploads aborts the multipart uploads in the dir, and
+	// returns the number of the aborted uploads.
+	AbortMultipartUploads(ctx context.Context, subDir string) (int, error)
+}
+
 // ExternalStorageOptions are backend-independent options provided to New.
 type ExternalStorageOptions struct {
 	// SendCredentials marks whether to send credentials downstream.
@@ -102,6 +113,10 @@ type ExternalStorageOptions struct {
 	// HTTPClient to use. The created storage may ignore this field if it is not
 	// directly using HTTP (e.g. the local storage).
 	HTTPClient *http.Client
+
+	// Tags are attached to the objects written into the storage, if the
+	// storage supports tagging.
+	Tags map[string]string
 }
 
 // Create creates ExternalStorage.
@@ -117,6 +132,16 @@ func Create(ctx context.Context, backend *backup.StorageBackend, sendCreds bool)
 
 // New creates an ExternalStorage with options.
 func New(ctx context.Context, backend *backup.StorageBackend, opts *ExternalStorageOptions) (ExternalStorage, error) {
+	switch backend.Backend.(type) {
+	case *backup.StorageBackend_S3, *backup.StorageBackend_Gcs:
+	default:
+		// The other backends, e.g. the local disks, have no place for the
+		// tags.
+		if len(opts.Tags) > 0 {
+			return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+				"storage %T doesn't support the tags, only S3 and GCS do", backend.Backend)
+		}
+	}
 	switch backend := backend.Backend.(type) {
 	case *backup.StorageBackend_Local:
 		if backend.Local == nil {
@@ -142,3 +167,12 @@ func New(ctx context.Context, backend *backup.StorageBackend, opts *ExternalStor
 		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "storage %T is not supported yet", backend)
 	}
 }
+
+// beforeWrite calls the OnStorageWrite hook before a backend writes the file,
+// so the applications embedding BR can inject the write failures in tests.
+func beforeWrite(ctx context.Context, name string, data []byte) error {
+	if err := hook.OnStorageWrite(ctx, name, data); err != nil {
+		return errors.Annotatef(err, "failed to write %s", name)
+	}
+	return nil
+}
@@ -4,11 +4,16 @@ package summary
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/status"
+
+	"github.com/pingcap/br/pkg/logutil"
 )
 
 const (
@@ -21,6 +26,33 @@ const (
 	TotalKV = "total kv"
 	// TotalBytes is a field we collect during backup/restore
 	TotalBytes = "total bytes"
+
+	// RetrySplit is the kind of the retries of splitting regions.
+	RetrySplit = "split"
+	// RetryDownload is the kind of the retries of downloading SST files.
+	RetryDownload = "download"
+	// RetryIngest is the kind of the retries of ingesting SST files.
+	RetryIngest = "ingest"
+	// RetryStorage is the kind of the retries of requesting the external storage.
+	RetryStorage = "storage"
+
+	// FailScatter is the kind of the failures of scattering regions, which is
+	// best-effort, so the failures are counted apart from the retries.
+	FailScatter = "scatter"
+
+	// StageSchema is the stage of backing up or creating the schemas.
+	StageSchema = "schema"
+	// StageBackup is the stage of backing up the ranges.
+	StageBackup = "backup"
+	// StageSplit is the stage of splitting and scattering the regions.
+	StageSplit = "split"
+	// StageDownloadIngest is the stage of downloading and ingesting the files.
+	StageDownloadIngest = "download+ingest"
+	// StageChecksum is the stage of checksumming the tables.
+	StageChecksum = "checksum"
+
+	// maxTopRetryErrors is the count of the error categories shown in summary.
+	maxTopRetryErrors = 5
 )
 
 // LogCollector collects infos into summary log.
@@ -42,9 +74,48 @@ type LogCollector interface {
 	Summary(name string)
 }
 
+// retryCollector is a LogCollector which also counts the retries.
+type retryCollector interface {
+	CollectRetry(kind string, err error)
+}
+
+// failureCollector is a LogCollector which also counts the failures of the
+// best-effort operations.
+type failureCollector interface {
+	CollectFailure(kind string, err error)
+}
+
+// warningCollector is a LogCollector which also collects the warnings.
+type warningCollector interface {
+	CollectWarning(msg string, count int)
+}
+
+// stageCollector is a LogCollector which also times the stages.
+type stageCollector interface {
+	RegisterStage(name string)
+	EndStage(name string)
+}
+
+// stage is the time span of a named stage, from the first registration to the
+// last end.
+type stage struct {
+	name  string
+	start time.Time
+	end   time.Time
+}
+
 type logFunc func(msg string, fields ...zap.Field)
 
-var collector LogCollector = NewLogCollector(log.Info)
+var (
+	collector LogCollector = NewLogCollector(log.Info)
+	logFormat              = logutil.LogFormatText
+)
+
+// SetLogFormat sets the format of the summary duplicated to stdout, it should
+// be called before InitCollector.
+func SetLogFormat(format string) {
+	logFormat = format
+}
 
 // InitCollector initilize global collector instance.
 func InitCollector( // revive:disable-line:flag-parameter
@@ -52,10 +123,11 @@ func InitCollector( // revive:disable-line:flag-parameter
 ) {
 	logF := log.L().Info
 	if hasLogFile {
-		conf := new(log.Config)
+		conf := &log.Config{Format: logFormat}
 		// Always duplicate summary to stdout.
-		logger, _, err := log.InitLogger(conf)
+		logger, _, err := logutil.InitLogger(conf)
 		if err == nil {
+			logger = logger.With(logutil.TaskIDField())
 			logF = func(msg string, fields ...zap.Field) {
 				logger.Info(msg, fields...)
 				log.Info(msg, fields...)
@@ -76,6 +148,13 @@ type logCollector struct {
 	durations        map[string]time.Duration
 	ints             map[string]int
 	uints            map[string]uint64
+	retries          map[string]int
+	retryErrors      map[string]int
+	failures         map[string]int
+	failureErrors    map[string]int
+	stages           []*stage
+	warnings         map[string]int
+	warningOrder     []string
 	successStatus    bool
 	startTime        time.Time
 
@@ -93,6 +172,11 @@ func NewLogCollector(log logFunc) LogCollector {
 		durations:        make(map[string]time.Duration),
 		ints:             make(map[string]int),
 		uints:            make(map[string]uint64),
+		retries:          make(map[string]int),
+		retryErrors:      make(map[string]int),
+		failures:         make(map[string]int),
+		failureErrors:    make(map[string]int),
+		warnings:         make(map[string]int),
 		log:              log,
 		startTime:        time.Now(),
 	}
@@ -144,6 +228,124 @@ func (tc *logCollector) CollectUInt(name string, t uint64) {
 	tc.uints[name] += t
 }
 
+// CollectRetry counts a failed attempt of the kind of operation, which is
+// retried later, along with the category of the error.
+func (tc *logCollector) CollectRetry(kind string, err error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.retries[kind]++
+	if err != nil {
+		tc.retryErrors[errorCategory(err)]++
+	}
+}
+
+// CollectFailure counts a failed attempt of the kind of best-effort operation,
+// along with the category of the error, apart from the retries.
+func (tc *logCollector) CollectFailure(kind string, err error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.failures[kind]++
+	if err != nil {
+		tc.failureErrors[errorCategory(err)]++
+	}
+}
+
+// CollectWarning counts the things the task warns about, in the order they
+// are first warned.
+func (tc *logCollector) CollectWarning(msg string, count int) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if _, ok := tc.warnings[msg]; !ok {
+		tc.warningOrder = append(tc.warningOrder, msg)
+	}
+	tc.warnings[msg] += count
+}
+
+// formatWarnings formats the warnings like `12 regions never scattered`.
+func formatWarnings(warnings map[string]int, order []string) []string {
+	formatted := make([]string, 0, len(order))
+	for _, msg := range order {
+		formatted = append(formatted, fmt.Sprintf("%d %s", warnings[msg], msg))
+	}
+	return formatted
+}
+
+// errorCategory returns the RFC code of the BR error, the gRPC code of the
+// gRPC error, or "Unknown" for the other errors.
+func errorCategory(err error) string {
+	if e, ok := errors.Cause(err).(*errors.Error); ok { // nolint:errorlint
+		return string(e.RFCCode())
+	}
+	if s, ok := status.FromError(errors.Cause(err)); ok && s != nil {
+		return "gRPC:" + s.Code().String()
+	}
+	return "Unknown"
+}
+
+// topRetryErrors returns at most n error categories with the most retries,
+// formatted as `category=count`.
+func topRetryErrors(retryErrors map[string]int, n int) []string {
+	categories := make([]string, 0, len(retryErrors))
+	for category := range retryErrors {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		ci, cj := retryErrors[categories[i]], retryErrors[categories[j]]
+		return ci > cj || (ci == cj && categories[i] < categories[j])
+	})
+	if len(categories) > n {
+		categories = categories[:n]
+	}
+	for i, category := range categories {
+		categories[i] = fmt.Sprintf("%s=%d", category, retryErrors[category])
+	}
+	return categories
+}
+
+// RegisterStage starts the stage if it isn't started.
+func (tc *logCollector) RegisterStage(name string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.findStage(name) == nil {
+		tc.stages = append(tc.stages, &stage{name: name, start: time.Now()})
+	}
+}
+
+// EndStage ends the stage, a stage not registered is ignored.
+func (tc *logCollector) EndStage(name string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if s := tc.findStage(name); s != nil {
+		s.end = time.Now()
+	}
+}
+
+func (tc *logCollector) findStage(name string) *stage {
+	for _, s := range tc.stages {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// stageWaterfall formats the stages in the started order, like
+// `split: 12m0s (+3m0s)`, where the offset is the start of the stage since
+// the start of the task. The stages not ended are ended now.
+func stageWaterfall(stages []*stage, startTime time.Time) []string {
+	now := time.Now()
+	waterfall := make([]string, 0, len(stages))
+	for _, s := range stages {
+		end := s.end
+		if end.IsZero() {
+			end = now
+		}
+		waterfall = append(waterfall, fmt.Sprintf("%s: %s (+%s)",
+			s.name, end.Sub(s.start).Round(time.Millisecond), s.start.Sub(startTime).Round(time.Millisecond)))
+	}
+	return waterfall
+}
+
 func (tc *logCollector) SetSuccessStatus(success bool) {
 	tc.mu.Lock()
 	defer tc.mu.Unlock()
@@ -157,6 +359,13 @@ func (tc *logCollector) Summary(name string) {
 		tc.ints = make(map[string]int)
 		tc.successCosts = make(map[string]time.Duration)
 		tc.failureReasons = make(map[string]error)
+		tc.retries = make(map[string]int)
+		tc.retryErrors = make(map[string]int)
+		tc.failures = make(map[string]int)
+		tc.failureErrors = make(map[string]int)
+		tc.stages = nil
+		tc.warnings = make(map[string]int)
+		tc.warningOrder = nil
 		tc.mu.Unlock()
 	}()
 
@@ -180,6 +389,24 @@ func (tc *logCollector) Summary(name string) {
 	for key, val := range tc.uints {
 		logFields = append(logFields, zap.Uint64(key, val))
 	}
+	for kind, count := range tc.retries {
+		logFields = append(logFields, zap.Int("retry "+kind, count))
+	}
+	if len(tc.retryErrors) != 0 {
+		logFields = append(logFields, zap.Strings("top retry errors", topRetryErrors(tc.retryErrors, maxTopRetryErrors)))
+	}
+	for kind, count := range tc.failures {
+		logFields = append(logFields, zap.Int("failed "+kind, count))
+	}
+	if len(tc.failureErrors) != 0 {
+		logFields = append(logFields, zap.Strings("top failure errors", topRetryErrors(tc.failureErrors, maxTopRetryErrors)))
+	}
+	if len(tc.stages) != 0 {
+		logFields = append(logFields, zap.Strings("stages", stageWaterfall(tc.stages, tc.startTime)))
+	}
+	if len(tc.warningOrder) != 0 {
+		logFields = append(logFields, zap.Strings("warnings", formatWarnings(tc.warnings, tc.warningOrder)))
+	}
 
 	if len(tc.failureReasons) != 0 || !tc.successStatus {
 		for unitName, reason := range tc.failureReasons {
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
 	"go.uber.org/zap"
 )
 
@@ -47,3 +48,80 @@ func (suit *testCollectorSuite) TestSumDurationInt(c *C) {
 	assertContains(zap.Duration("b", 2*time.Second))
 	assertContains(zap.Int("c", 4))
 }
+
+func (suit *testCollectorSuite) TestCollectRetry(c *C) {
+	fields := []zap.Field{}
+	logger := func(msg string, fs ...zap.Field) {
+		fields = append(fields, fs...)
+	}
+	col := NewLogCollector(logger)
+	rc := col.(retryCollector)
+	errEpoch := errors.Normalize("epoch not match", errors.RFCCodeText("BR:KV:ErrKVEpochNotMatch"))
+	errBusy := errors.Normalize("server is busy", errors.RFCCodeText("BR:KV:ErrKVServerIsBusy"))
+	rc.CollectRetry(RetryIngest, errors.Annotate(errEpoch, "ingest"))
+	rc.CollectRetry(RetryIngest, errEpoch)
+	rc.CollectRetry(RetrySplit, errBusy)
+	rc.CollectRetry(RetryStorage, errors.New("connection reset"))
+	// The scatter failures don't mix with the retries.
+	col.(failureCollector).CollectFailure(FailScatter, errBusy)
+	col.SetSuccessStatus(true)
+	col.Summary("foo")
+
+	c.Assert(fields, HasLen, 6)
+	counts := make(map[string]int)
+	for _, f := range fields {
+		switch f.Key {
+		case "top retry errors":
+			c.Assert(f, DeepEquals, zap.Strings("top retry errors", []string{
+				"BR:KV:ErrKVEpochNotMatch=2", "BR:KV:ErrKVServerIsBusy=1", "Unknown=1",
+			}))
+		case "top failure errors":
+			c.Assert(f, DeepEquals, zap.Strings("top failure errors", []string{"BR:KV:ErrKVServerIsBusy=1"}))
+		default:
+			counts[f.Key] = int(f.Integer)
+		}
+	}
+	c.Assert(counts, DeepEquals, map[string]int{
+		"retry ingest": 2, "retry split": 1, "retry storage": 1, "failed scatter": 1,
+	})
+}
+
+func (suit *testCollectorSuite) TestStageWaterfall(c *C) {
+	col := NewLogCollector(func(msg string, fs ...zap.Field) {}).(*logCollector)
+	col.RegisterStage(StageSplit)
+	first := col.stages[0].start
+	col.RegisterStage(StageSplit)
+	col.EndStage(StageSplit)
+	col.EndStage(StageChecksum)
+	c.Assert(col.stages, HasLen, 1)
+	c.Assert(col.stages[0].start, Equals, first)
+	c.Assert(col.stages[0].end.IsZero(), IsFalse)
+
+	start := time.Unix(0, 0)
+	stages := []*stage{
+		{name: StageSchema, start: start, end: start.Add(3 * time.Minute)},
+		{name: StageSplit, start: start.Add(3 * time.Minute), end: start.Add(15 * time.Minute)},
+	}
+	c.Assert(stageWaterfall(stages, start), DeepEquals, []string{"schema: 3m0s (+0s)", "split: 12m0s (+3m0s)"})
+}
+
+func (suit *testCollectorSuite) TestCollectWarning(c *C) {
+	fields := []zap.Field{}
+	col := NewLogCollector(func(msg string, fs ...zap.Field) {
+		fields = append(fields, fs...)
+	})
+	wc := col.(warningCollector)
+	wc.CollectWarning("regions never scattered", 5)
+	wc.CollectWarning("tables skipped", 3)
+	wc.CollectWarning("regions never scattered", 7)
+	col.SetSuccessStatus(true)
+	col.Summary("foo")
+	c.Assert(fields, DeepEquals, []zap.Field{
+		zap.Strings("warnings", []string{"12 regions never scattered", "3 tables skipped"}),
+	})
+
+	// The warnings are reset after the summary.
+	fields = nil
+	col.Summary("foo")
+	c.Assert(fields, HasLen, 0)
+}
@@ -19,6 +19,50 @@ func CollectFailureUnit(name string, reason error) {
 	collector.CollectFailureUnit(name, reason)
 }
 
+// CollectRetry collects a failed attempt of the kind of operation which is
+// retried, e.g. RetrySplit, and the category of the error.
+func CollectRetry(kind string, err error) {
+	if rc, ok := collector.(retryCollector); ok {
+		rc.CollectRetry(kind, err)
+	}
+}
+
+// CollectFailure collects a failed attempt of the kind of best-effort
+// operation, e.g. FailScatter, and the category of the error, which are
+// reported apart from the retries.
+func CollectFailure(kind string, err error) {
+	if fc, ok := collector.(failureCollector); ok {
+		fc.CollectFailure(kind, err)
+	}
+}
+
+// CollectWarning collects a warning of the task, which is listed in the
+// warnings of the summary instead of only in the log, e.g.
+// CollectWarning("regions never scattered", 12) is listed as
+// `12 regions never scattered`. The counts of the same message are summed.
+func CollectWarning(msg string, count int) {
+	if wc, ok := collector.(warningCollector); ok {
+		wc.CollectWarning(msg, count)
+	}
+}
+
+// RegisterStage starts timing the named stage, e.g. StageSplit. The stage
+// started before is kept started, so a pipelined stage can be registered by
+// every batch.
+func RegisterStage(name string) {
+	if sc, ok := collector.(stageCollector); ok {
+		sc.RegisterStage(name)
+	}
+}
+
+// EndStage ends the named stage, a stage ended again is extended to the last
+// end.
+func EndStage(name string) {
+	if sc, ok := collector.(stageCollector); ok {
+		sc.EndStage(name)
+	}
+}
+
 // CollectDuration collects log time field.
 func CollectDuration(name string, t time.Duration) {
 	collector.CollectDuration(name, t)
@@ -19,15 +19,21 @@ import (
 	"github.com/pingcap/log"
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/sessionctx/stmtctx"
 	"github.com/pingcap/tidb/sessionctx/variable"
+	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/types"
 	"github.com/spf13/pflag"
+	pd "github.com/tikv/pd/client"
+	"go.etcd.io/etcd/clientv3"
 	"go.uber.org/zap"
 
 	"github.com/pingcap/br/pkg/backup"
 	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/pdutil"
 	"github.com/pingcap/br/pkg/storage"
 	"github.com/pingcap/br/pkg/summary"
 )
@@ -41,8 +47,26 @@ const (
 	flagCompressionLevel = "compression-level"
 	flagRemoveSchedulers = "remove-schedulers"
 	flagIgnoreStats      = "ignore-stats"
+	flagBackupLock       = "backup-lock"
+	flagLockTTL          = "lock-ttl"
+	flagSkipEmptyRanges  = "skip-empty-ranges"
+	flagExternalSchemas  = "external-schemas"
+	flagCatalog          = "catalog"
+	flagBackupID         = "backup-id"
+	flagMaxRegions       = "max-regions-per-request"
+	flagImpactReport     = "impact-report"
+	flagImpactInterval   = "impact-interval"
+	flagImpactTiDBStatus = "impact-tidb-status"
+	flagSchemaOnly       = "schema-only"
+
+	flagRateLimitSchedule = "ratelimit-schedule"
 
 	flagGCTTL = "gcttl"
+	// flagGCAutoAdjust pushes the service safe point before the backup starts.
+	flagGCAutoAdjust = "gc-auto-adjust"
+
+	flagMaxCPU      = "max-cpu"
+	flagMemoryLimit = "memory-limit"
 
 	defaultBackupConcurrency = 4
 	maxBackupConcurrency     = 256
@@ -54,6 +78,14 @@ type CompressionConfig struct {
 	CompressionLevel int32                   `json:"compression-level" toml:"compression-level"`
 }
 
+// ResourceLimitConfig is the configuration of the resources BR itself may use.
+type ResourceLimitConfig struct {
+	// MaxCPU is the max count of CPUs, zero means no limit.
+	MaxCPU int `json:"max-cpu" toml:"max-cpu"`
+	// MemoryLimit is the max bytes of heap, zero means no limit.
+	MemoryLimit uint64 `json:"memory-limit" toml:"memory-limit"`
+}
+
 // BackupConfig is the configuration specific for backup tasks.
 type BackupConfig struct {
 	Config
@@ -65,7 +97,59 @@ type BackupConfig struct {
 	GCTTL            int64         `json:"gc-ttl" toml:"gc-ttl"`
 	RemoveSchedulers bool          `json:"remove-schedulers" toml:"remove-schedulers"`
 	IgnoreStats      bool          `json:"ignore-stats" toml:"ignore-stats"`
+	// GCAutoAdjust pushes the service safe point holding the snapshots before
+	// the backup starts, and aborts if PD can't hold it.
+	GCAutoAdjust bool `json:"gc-auto-adjust" toml:"gc-auto-adjust"`
+	// BackupLock is whether to hold the lock of the backup destination in PD.
+	BackupLock bool `json:"backup-lock" toml:"backup-lock"`
+	// LockTTL is the TTL of the sentinel lock object written to the backup
+	// destination, zero means backup.DefaultSentinelTTL.
+	LockTTL time.Duration `json:"lock-ttl" toml:"lock-ttl"`
+	// BackupSettings is whether to save a snapshot of the global variables
+	// and the PD schedule config into the backup.
+	BackupSettings bool `json:"backup-settings" toml:"backup-settings"`
+	// RateLimitSchedule is the rate limits by time windows of the day, e.g.
+	// `00:00-06:00=0,06:00-24:00=64MiB`.
+	RateLimitSchedule string `json:"ratelimit-schedule" toml:"ratelimit-schedule"`
+	// SkipEmptyRanges skips the ranges whose regions PD reports no keys in and
+	// a scan of the snapshot confirms empty.
+	SkipEmptyRanges bool `json:"skip-empty-ranges" toml:"skip-empty-ranges"`
+	// ExternalSchemas stores the table schemas out of the backupmeta, see
+	// utils.ExternalSchema.
+	ExternalSchemas bool `json:"external-schemas" toml:"external-schemas"`
+	// Catalog stores the backup at `<storage>/<cluster-id>/<backup-id>` and
+	// indexes it in the catalog of the storage, see utils.Catalog.
+	Catalog bool `json:"catalog" toml:"catalog"`
+	// BackupID is the name of the backup in the catalog layout, empty means
+	// the UTC time the backup starts.
+	BackupID string `json:"backup-id" toml:"backup-id"`
+	// MaxRegionsPerRequest splits the ranges covering more regions at the
+	// region boundaries, zero means no split.
+	MaxRegionsPerRequest int `json:"max-regions-per-request" toml:"max-regions-per-request"`
+	// ImpactReport samples the load of the cluster before, during and after
+	// the backup, and saves the comparison into the backup, see
+	// utils.ImpactReport.
+	ImpactReport bool `json:"impact-report" toml:"impact-report"`
+	// ImpactInterval is the interval of the samples, and the windows sampled
+	// before and after the backup.
+	ImpactInterval time.Duration `json:"impact-interval" toml:"impact-interval"`
+	// ImpactTiDBStatus is the status addresses of the TiDB instances whose
+	// SQL P99 latency is reported.
+	ImpactTiDBStatus []string `json:"impact-tidb-status" toml:"impact-tidb-status"`
+	// Spec is the YAML file of a backup spec, see BackupSpec.
+	Spec string `json:"spec" toml:"spec"`
+	// TableTS is the snapshot TS overrides of the tables.
+	TableTS []TableTS `json:"table-ts" toml:"table-ts"`
+	// LastBackup is the storage of the last backup, whose tables backed up at
+	// their own TS are backed up from those TS.
+	LastBackup string `json:"last-backup" toml:"last-backup"`
+	// Resume records the checkpoint of the backup, and resumes the backup
+	// from the checkpoint in the storage.
+	Resume bool `json:"resume" toml:"resume"`
+	// SchemaOnly backs up the schemas of the tables without their data.
+	SchemaOnly bool `json:"schema-only" toml:"schema-only"`
 	CompressionConfig
+	ResourceLimitConfig
 }
 
 // DefineBackupFlags defines common flags for the backup command.
@@ -81,6 +165,9 @@ func DefineBackupFlags(flags *pflag.FlagSet) {
 		" e.g. '400036290571534337', '2018-05-11 01:42:23'")
 	flags.String(flagCron, "", "the backup can be run with cron job.")
 	flags.Int64(flagGCTTL, utils.DefaultBRGCSafePointTTL, "the TTL (in seconds) that PD holds for BR's GC safepoint")
+	flags.Bool(flagGCAutoAdjust, false,
+		"push BR's GC safepoint before the backup starts and abort if PD can't hold it, "+
+			"otherwise the backup TS and the last backup TS are only checked against the GC window")
 	flags.String(flagCompressionType, "zstd",
 		"backup sst file compression algorithm, value can be one of 'lz4|zstd|snappy'")
 	flags.Int32(flagCompressionLevel, 0, "compression level used for sst file compression")
@@ -98,6 +185,54 @@ func DefineBackupFlags(flags *pflag.FlagSet) {
 		"ignore backup stats, used for test")
 	// This flag is used for test. we should backup stats all the time.
 	_ = flags.MarkHidden(flagIgnoreStats)
+
+	flags.Bool(flagBackupLock, false,
+		"hold a lock of the backup destination in PD during backup, "+
+			"so that other backups of the cluster to the same destination are rejected")
+	flags.Duration(flagLockTTL, backup.DefaultSentinelTTL,
+		"the TTL of the sentinel lock object written to the backup destination, "+
+			"a sentinel without heartbeat for longer than it can be taken over by another backup")
+	_ = flags.MarkHidden(flagLockTTL)
+	flags.Bool(flagBackupSettings, false,
+		"save a snapshot of the global variables and the PD schedule config into the backup")
+	flags.String(flagRateLimitSchedule, "",
+		"the rate limits by time windows of the day, e.g. '00:00-06:00=0,06:00-24:00=64MiB', "+
+			"a range is limited by the window it starts in, 0 means unlimited, and --ratelimit "+
+			"is used outside the windows")
+	flags.Bool(flagSkipEmptyRanges, false,
+		"skip the ranges whose regions PD reports no keys in, which speeds up the backup of sparse tables, "+
+			"the statistics of PD may lag behind, so every such range is confirmed empty by a scan of the snapshot")
+	flags.Bool(flagExternalSchemas, false,
+		"store the schema of every table as an object of its own instead of embedding it in the backupmeta, "+
+			"which keeps reading the backupmeta fast for a huge count of tables, and the restore loads "+
+			"only the schemas of the tables it restores")
+	flags.Bool(flagCatalog, false,
+		"store the backup at '<storage>/<cluster-id>/<backup-id>/' and index it in '<storage>/catalog/', "+
+			"which is read by 'br list' and 'br gc'")
+	flags.String(flagBackupID, "",
+		"the ID of the backup in the catalog layout, defaults to the UTC time the backup starts, e.g. '20201201T020000Z'")
+	flags.Int(flagMaxRegions, 0,
+		"split the backup ranges covering more regions than it at the region boundaries before the backup, "+
+			"so a request of a huge table doesn't time out and fewer regions are retried, 0 means no split")
+	flags.Bool(flagImpactReport, false,
+		"sample the CPU and IO of TiKV before, during and after the backup, and save the comparison "+
+			"into 'impact.json' of the backup, which delays the backup by one interval before and after it")
+	flags.Duration(flagImpactInterval, 30*time.Second,
+		"the interval of sampling the load of the cluster for --impact-report")
+	flags.StringSlice(flagImpactTiDBStatus, nil,
+		"the status addresses of TiDB whose SQL P99 latency is reported by --impact-report, e.g. '127.0.0.1:10080'")
+	flags.Bool(flagResume, false,
+		"record the checkpoint of the ranges backed up, and resume the backup from the checkpoint in the storage "+
+			"at the backup TS of the checkpoint, the ranges backed up by the previous runs are skipped; "+
+			"only the backup with --resume can be resumed")
+	flags.Bool(flagSchemaOnly, false,
+		"back up the schemas of the tables without their data, and the statistics unless --ignore-stats, "+
+			"which completes in seconds, the tables are created without data by restoring the backup")
+	flags.Int(flagMaxCPU, 0,
+		"the max count of CPUs BR itself uses, 0 means no limit")
+	flags.String(flagMemoryLimit, "",
+		"the max heap BR itself uses, e.g. '2GiB', BR collects garbage more often and "+
+			"starts no more ranges while the heap in use exceeds it, empty means no limit")
 }
 
 // ParseFromFlags parses the backup-related flags from the flag set.
@@ -131,12 +266,23 @@ func (cfg *BackupConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 		return errors.Trace(err)
 	}
 	cfg.GCTTL = gcTTL
+	if flags.Lookup(flagGCAutoAdjust) != nil {
+		cfg.GCAutoAdjust, err = flags.GetBool(flagGCAutoAdjust)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
 
 	compressionCfg, err := parseCompressionFlags(flags)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	cfg.CompressionConfig = *compressionCfg
+	resourceCfg, err := parseResourceLimitFlags(flags)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.ResourceLimitConfig = *resourceCfg
 
 	if err = cfg.Config.ParseFromFlags(flags); err != nil {
 		return errors.Trace(err)
@@ -146,7 +292,264 @@ func (cfg *BackupConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 		return errors.Trace(err)
 	}
 	cfg.IgnoreStats, err = flags.GetBool(flagIgnoreStats)
-	return errors.Trace(err)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.BackupLock, err = flags.GetBool(flagBackupLock)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.LockTTL, err = flags.GetDuration(flagLockTTL)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.BackupSettings, err = flags.GetBool(flagBackupSettings)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.SkipEmptyRanges, err = flags.GetBool(flagSkipEmptyRanges)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.ExternalSchemas, err = flags.GetBool(flagExternalSchemas)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// The schemas of the databases with keys of their own are encrypted out
+	// of the backupmeta.
+	if len(cfg.Crypter.Databases) > 0 && !cfg.ExternalSchemas {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--crypter.key-mapping requires --%s", flagExternalSchemas)
+	}
+	cfg.Catalog, err = flags.GetBool(flagCatalog)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.BackupID, err = flags.GetString(flagBackupID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = checkBackupID(cfg.BackupID); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.MaxRegionsPerRequest, err = flags.GetInt(flagMaxRegions)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.MaxRegionsPerRequest < 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must not be negative", flagMaxRegions)
+	}
+	cfg.ImpactReport, err = flags.GetBool(flagImpactReport)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.ImpactInterval, err = flags.GetDuration(flagImpactInterval)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ImpactReport && cfg.ImpactInterval <= 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must be positive", flagImpactInterval)
+	}
+	cfg.ImpactTiDBStatus, err = flags.GetStringSlice(flagImpactTiDBStatus)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.RateLimitSchedule, err = flags.GetString(flagRateLimitSchedule)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.RateLimitSchedule != "" {
+		if _, err = backup.ParseRateLimitSchedule(cfg.RateLimitSchedule); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if flags.Lookup(flagResume) != nil {
+		cfg.Resume, err = flags.GetBool(flagResume)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if cfg.Resume && cfg.Catalog && cfg.BackupID == "" {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s requires --%s of the backup to resume in the catalog layout", flagResume, flagBackupID)
+		}
+	}
+	if flags.Lookup(flagSchemaOnly) != nil {
+		cfg.SchemaOnly, err = flags.GetBool(flagSchemaOnly)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if cfg.SchemaOnly && (cfg.LastBackupTS > 0 || cfg.ImpactReport) {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s can't be used with --%s or --%s", flagSchemaOnly, flagLastBackupTS, flagImpactReport)
+		}
+	}
+	if flags.Lookup(flagBackupSpec) != nil {
+		cfg.Spec, err = flags.GetString(flagBackupSpec)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if flags.Lookup(flagTableTS) != nil {
+		tableTSFile, err := flags.GetString(flagTableTS)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if tableTSFile != "" {
+			if cfg.TableTS, err = ReadTableTS(tableTSFile); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	if flags.Lookup(flagLastBackup) != nil {
+		cfg.LastBackup, err = flags.GetString(flagLastBackup)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if cfg.LastBackup != "" && cfg.LastBackupTS == 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s requires --%s", flagLastBackup, flagLastBackupTS)
+		}
+	}
+	return nil
+}
+
+// filterEmptyRanges counts the regions of the ranges, and drops the ranges
+// whose regions PD reports no keys in if skipEmpty is set. The statistics of
+// PD may lag behind, so a range is only dropped if confirmEmpty finds no key
+// in it either.
+func filterEmptyRanges(
+	ctx context.Context,
+	getStats func(ctx context.Context, startKey, endKey []byte) (*pdutil.RegionStats, error),
+	confirmEmpty func(ctx context.Context, startKey, endKey []byte) (bool, error),
+	ranges []rtree.Range,
+	skipEmpty bool,
+) ([]rtree.Range, int, error) {
+	regions := 0
+	filtered := make([]rtree.Range, 0, len(ranges))
+	for _, r := range ranges {
+		stats, err := getStats(ctx, r.StartKey, r.EndKey)
+		if err != nil {
+			return nil, 0, errors.Trace(err)
+		}
+		if skipEmpty && stats.Count > 0 && stats.StorageKeys == 0 && stats.StorageSize == 0 {
+			empty, err := confirmEmpty(ctx, r.StartKey, r.EndKey)
+			if err != nil {
+				return nil, 0, errors.Trace(err)
+			}
+			if empty {
+				log.Debug("skip empty range", logutil.Key("startKey", r.StartKey), logutil.Key("endKey", r.EndKey))
+				continue
+			}
+			log.Info("the statistics of PD lag behind, range isn't empty",
+				logutil.Key("startKey", r.StartKey), logutil.Key("endKey", r.EndKey))
+		}
+		regions += stats.Count
+		filtered = append(filtered, r)
+	}
+	if skipped := len(ranges) - len(filtered); skipped > 0 {
+		log.Info("skip empty ranges", zap.Int("skipped", skipped), zap.Int("ranges", len(filtered)))
+		summary.CollectInt("skipped empty ranges", skipped)
+	}
+	return filtered, regions, nil
+}
+
+// scanEmptyRange returns the function telling whether a range has no key in
+// the snapshot it is backed up at, i.e. the TS of its table or the backupTS.
+func scanEmptyRange(
+	store kv.Storage,
+	tableTS map[int64]uint64,
+	backupTS uint64,
+) func(ctx context.Context, startKey, endKey []byte) (bool, error) {
+	return func(ctx context.Context, startKey, endKey []byte) (bool, error) {
+		ts := backupTS
+		if t, ok := tableTS[tablecodec.DecodeTableID(startKey)]; ok {
+			ts = t
+		}
+		snap, err := store.GetSnapshot(kv.NewVersion(ts))
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		iter, err := snap.Iter(startKey, endKey)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		defer iter.Close()
+		return !iter.Valid(), nil
+	}
+}
+
+// RunBackupUnlock removes the lock of the backup destination, for the backup
+// which holds it has gone without releasing it.
+func RunBackupUnlock(c context.Context, cfg *Config) error {
+	u, err := storage.ParseBackend(cfg.Storage, &cfg.BackendOptions)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cli, err := newEtcdClient(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer cli.Close()
+
+	storageURL := storage.FormatBackendURL(u)
+	info, err := backup.ForceUnlockBackup(c, cli, storageURL.String())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if info == nil {
+		log.Info("backup destination isn't locked", zap.Stringer("storage", &storageURL))
+		return nil
+	}
+	log.Info("backup lock removed",
+		zap.String("storage", info.Storage),
+		zap.String("owner", info.Owner),
+		zap.Time("acquired-at", info.AcquiredAt))
+	return nil
+}
+
+// lockBackupDestination acquires the lock of the backup destination in PD.
+// The task is canceled once the lock is lost.
+func lockBackupDestination(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	cfg *Config,
+	u *kvproto.StorageBackend,
+) (release func(), err error) {
+	return holdStorageLock(ctx, cancel, cfg, u, backup.AcquireBackupLock)
+}
+
+// holdStorageLock acquires a lock of the storage in PD by acquire, the task is
+// canceled once the lock is lost.
+func holdStorageLock(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	cfg *Config,
+	u *kvproto.StorageBackend,
+	acquire func(context.Context, *clientv3.Client, string) (*backup.BackupLock, error),
+) (release func(), err error) {
+	cli, err := newEtcdClient(cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	storageURL := storage.FormatBackendURL(u)
+	lock, err := acquire(ctx, cli, storageURL.String())
+	if err != nil {
+		_ = cli.Close()
+		return nil, errors.Trace(err)
+	}
+	go func() {
+		select {
+		case <-lock.Lost():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return func() {
+		if err := lock.Release(context.Background()); err != nil {
+			log.Warn("failed to release the lock, it will expire soon", zap.Error(err))
+		}
+		_ = cli.Close()
+	}, nil
 }
 
 // ParseFromFlags parses the backup-related flags from the flag set.
@@ -169,6 +572,35 @@ func parseCompressionFlags(flags *pflag.FlagSet) (*CompressionConfig, error) {
 	}, nil
 }
 
+func parseResourceLimitFlags(flags *pflag.FlagSet) (*ResourceLimitConfig, error) {
+	maxCPU, err := flags.GetInt(flagMaxCPU)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if maxCPU < 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "negative --%s is not allowed", flagMaxCPU)
+	}
+	memoryLimit, err := flags.GetString(flagMemoryLimit)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cfg := &ResourceLimitConfig{MaxCPU: maxCPU}
+	if memoryLimit != "" {
+		if cfg.MemoryLimit, err = utils.ParseByteSize(memoryLimit); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return cfg, nil
+}
+
+// startResourceLimiter starts limiting the resources of BR, the returned
+// function stops it and collects the usage into the summary.
+func startResourceLimiter(ctx context.Context, cfg ResourceLimitConfig) (*utils.ResourceLimiter, func()) {
+	limiter := utils.NewResourceLimiter(cfg.MaxCPU, cfg.MemoryLimit)
+	limiter.Start(ctx)
+	return limiter, limiter.Stop
+}
+
 // adjustBackupConfig is use for BR(binary) and BR in TiDB.
 // When new config was add and not included in parser.
 // we should set proper value in this function.
@@ -207,36 +639,145 @@ const (
 // RunBackup starts a backup task inside the current goroutine.
 func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig) error {
 	cfg.adjustBackupConfig()
+	if cfg.SchemaOnly && cmdName == CmdTxnBackup {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s isn't supported by txn backup", flagSchemaOnly)
+	}
+	if len(cfg.Crypter.Databases) > 0 && cmdName == CmdTxnBackup {
+		return errors.Annotate(berrors.ErrInvalidArgument, "--crypter.key-mapping isn't supported by txn backup")
+	}
 
 	defer summary.Summary(cmdName)
-	ctx, cancel := context.WithCancel(c)
+	// The gRPC messages are counted per task.
+	wireStats := &utils.GRPCWireStats{}
+	defer collectGRPCCompression(&cfg.Config, wireStats)
+	// The BRIE statements of TiDB may back up concurrently, each of them has
+	// its own task ID.
+	ctx, cancel := context.WithCancel(logutil.EnsureTaskID(c))
 	defer cancel()
 	// backend data location
 	u, err := storage.ParseBackend(cfg.Storage, &cfg.BackendOptions)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if err = encryptDataFiles(&cfg.Config, u); err != nil {
+		return errors.Trace(err)
+	}
 
-	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements)
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.GRPCMaxMsgSize(), cfg.CheckRequirements)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	if cmdName == CmdTxnBackup {
 		mgr.DisableCloseDomain()
 	}
+	defer mgr.Close()
+	if err = applyProfileOfCluster(ctx, mgr, &cfg.Config, false); err != nil {
+		return errors.Trace(err)
+	}
+	mgr.SetGRPCCompression(cfg.GRPCCompression, wireStats)
+
+	if cfg.BackupLock {
+		release, err := lockBackupDestination(ctx, cancel, &cfg.Config, u)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer release()
+	}
+	// The lock is held on the root of the catalog, every backup writes its own
+	// entry of the catalog.
+	root := u
+	backupName := ""
+	if cfg.Catalog {
+		if cfg.BackupID == "" {
+			cfg.BackupID = time.Now().UTC().Format(backupIDLayout)
+		}
+		backupName = utils.CatalogBackupName(mgr.GetPDClient().GetClusterID(ctx), cfg.BackupID)
+		if u, err = subBackend(root, backupName); err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("backup to the catalog layout", zap.String("backup", backupName))
+	}
 
 	client, err := backup.NewBackupClient(ctx, mgr)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	if err = client.SetStorage(ctx, u, cfg.SendCreds); err != nil {
+	limiter, stopLimiter := startResourceLimiter(ctx, cfg.ResourceLimitConfig)
+	defer stopLimiter()
+	client.SetResourceLimiter(limiter)
+	budget, leaveBudget, err := joinBandwidthBudget(ctx, &cfg.Config)
+	if err != nil {
 		return errors.Trace(err)
 	}
-	err = client.SetLockFile(ctx)
+	defer leaveBudget()
+	client.SetBandwidthBudget(budget)
+	tuning, unsetTuning := exposeLiveTuning(&cfg.Config)
+	defer unsetTuning()
+	client.SetLiveTuning(tuning)
+	opts, err := cfg.StorageOptions()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Resume {
+		client.EnableResume()
+	}
+	if err = client.SetStorage(ctx, u, opts); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.RateLimitSchedule != "" {
+		schedule, err := backup.ParseRateLimitSchedule(cfg.RateLimitSchedule)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		client.SetRateLimitSchedule(schedule)
+	}
+	sentinel, err := client.LockStorage(ctx, cfg.LockTTL)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	go func() {
+		select {
+		case <-sentinel.Lost():
+			log.Error("the backup sentinel is lost, another backup may write to the destination")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	defer func() {
+		if err := sentinel.Release(context.Background()); err != nil {
+			log.Warn("failed to release the backup sentinel, it will expire soon", zap.Error(err))
+		}
+	}()
+	// The data key is saved before the sidecar files it encrypts, so the
+	// resumed backup can read the checkpoint.
+	dataKey, err := prepareDataKey(ctx, &cfg.Config, client.GetStorage(), cfg.Resume)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	client.SetDataKey(dataKey)
+	sidecar, err := sidecarStorage(dataKey, client.GetStorage())
 	if err != nil {
 		return errors.Trace(err)
 	}
 	client.SetGCTTL(cfg.GCTTL)
+	var previous *backup.Checkpoint
+	if cfg.Resume {
+		previous, err = backup.ReadCheckpoint(ctx, sidecar)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if previous == nil {
+			log.Warn("backup checkpoint not found, nothing to resume")
+		} else {
+			log.Info("resume the backup",
+				zap.String("previous-task-id", previous.TaskID),
+				zap.Uint64("backup-ts", previous.BackupTS),
+				zap.Int("ranges", len(previous.Ranges)))
+			if cfg.BackupTS == 0 {
+				cfg.BackupTS = previous.BackupTS
+			}
+		}
+	}
 
 	// Get Backup ts
 	backupTS, err := client.GetTS(ctx, cfg.TimeAgo, cfg.BackupTS)
@@ -250,13 +791,62 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 		ID:       utils.MakeSafePointID(),
 	}
 
+	var tableTS map[int64]uint64
+	if len(cfg.TableTS) > 0 {
+		if cmdName == CmdTxnBackup {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "--%s isn't supported by txn backup", flagTableTS)
+		}
+		info, err := mgr.GetDomain().GetSnapshotInfoSchema(backupTS)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		tableTS, err = resolveTableTS(ctx, mgr.GetPDClient(), info, cfg.TableFilter, cfg.TableTS, backupTS, cfg.LastBackupTS)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		client.SetTableTS(tableTS)
+		// The safe point keeps the oldest snapshot.
+		sp.BackupTS = minTableTS(tableTS, backupTS)
+	}
+
+	// The SST files of the databases with keys of their own are encrypted by
+	// their keys.
+	if len(cfg.Crypter.Databases) > 0 {
+		info, err := mgr.GetDomain().GetSnapshotInfoSchema(backupTS)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		client.SetTableBackends(tableBackends(info.AllSchemas(), cfg.Crypter.Databases, u))
+	}
+
 	// use lastBackupTS as safePoint if exists
 	if cfg.LastBackupTS > 0 {
 		sp.BackupTS = cfg.LastBackupTS
 	}
+	if cfg.LastBackup != "" {
+		lastTableTS, err := readLastTableTS(ctx, cfg)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		client.SetLastTableTS(lastTableTS)
+		sp.BackupTS = minTableTS(lastTableTS, cfg.LastBackupTS)
+	}
+	// Only the backup with --resume records the checkpoint, so the next run
+	// with --resume resumes it.
+	var checkpointer *backup.Checkpointer
+	if cfg.Resume {
+		checkpointer, err = backup.NewCheckpointer(sidecar, backupTS, cfg.LastBackupTS, previous)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		client.SetCheckpointer(checkpointer)
+	}
 
 	log.Info("current backup safePoint job",
 		zap.Object("safePoint", sp))
+	if err = checkGCWindow(ctx, mgr.GetPDClient(), cfg, sp); err != nil {
+		return errors.Trace(err)
+	}
 
 	// update gc and safe point in daemon
 	utils.StartServiceSafePointKeeper(ctx, mgr.GetPDClient(), sp)
@@ -318,8 +908,10 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 		}
 	} else {
 		// get all tables ranges
+		summary.RegisterStage(summary.StageSchema)
 		ranges, backupSchemas, err = backup.BuildBackupRangeAndSchema(
 			mgr.GetDomain(), mgr.GetTiKV(), cfg.TableFilter, backupTS, cfg.IgnoreStats)
+		summary.EndStage(summary.StageSchema)
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -353,25 +945,49 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 		}
 	}
 
+	if cfg.SchemaOnly {
+		log.Info("skip the data of the tables in schema-only mode", zap.Int("ranges", len(ranges)))
+		ranges = nil
+		client.EnableSchemaOnly()
+	}
+
+	skipEmpty := cfg.SkipEmptyRanges
+	if skipEmpty && (isIncrementalBackup || backupSchemas == nil) {
+		// The statistics and the snapshot don't tell the changes of an
+		// incremental backup.
+		log.Warn("empty ranges are only skipped in full backup of tables")
+		skipEmpty = false
+	}
 	// The number of regions need to backup
-	approximateRegions := 0
-	for _, r := range ranges {
-		var regionCount int
-		regionCount, err = mgr.GetRegionCount(ctx, r.StartKey, r.EndKey)
+	ranges, approximateRegions, err := filterEmptyRanges(ctx, mgr.GetRegionStats,
+		scanEmptyRange(mgr.GetTiKV(), tableTS, backupTS), ranges, skipEmpty)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	summary.CollectInt("backup total regions", approximateRegions)
+	if cfg.MaxRegionsPerRequest > 0 {
+		ranges, err = backup.PartitionTxnRanges(ctx, mgr.GetPDClient(), ranges, cfg.MaxRegionsPerRequest)
 		if err != nil {
 			return errors.Trace(err)
 		}
-		approximateRegions += regionCount
+		summary.CollectInt("backup sub-ranges", len(ranges))
+	}
+
+	var impact *utils.ImpactCollector
+	if cfg.ImpactReport {
+		impact = startImpactCollector(ctx, mgr, cfg)
 	}
-	summary.CollectInt("backup total regions", approximateRegions)
 
 	// Backup
-	// Redirect to log if there is no log file to avoid unreadable output.
-	updateCh := g.StartProgress(
-		ctx, cmdName, int64(approximateRegions), !cfg.LogProgress)
+	updateCh, regionCh := startBackupProgress(ctx, g, cmdName, approximateRegions, cfg.LogProgress)
 
 	// begin backup
-	files, err := client.BackupRanges(ctx, ranges, req, uint(cfg.Concurrency), updateCh)
+	summary.RegisterStage(summary.StageBackup)
+	files, err := client.BackupRanges(ctx, ranges, req, uint(cfg.Concurrency), regionCh)
+	summary.EndStage(summary.StageBackup)
+	// Record the ranges backed up, so the resumed backup skips them even if
+	// the later stages fail. The context may have been canceled.
+	checkpointer.Flush(context.Background())
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -384,10 +1000,12 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 	}
 
 	// Checksum from server, and then fulfill the backup metadata.
-	if cfg.Checksum && !isIncrementalBackup && backupSchemas != nil {
+	if cfg.Checksum && !isIncrementalBackup && !cfg.SchemaOnly && backupSchemas != nil {
+		summary.RegisterStage(summary.StageChecksum)
 		backupSchemasConcurrency := utils.MinInt(backup.DefaultSchemaConcurrency, backupSchemas.Len())
 		updateCh = g.StartProgress(
 			ctx, "Checksum", int64(backupSchemas.Len()), !cfg.LogProgress)
+		backupSchemas.SetTableTS(tableTS)
 		backupSchemas.Start(
 			ctx, mgr.GetTiKV(), backupTS, uint(backupSchemasConcurrency), cfg.ChecksumConcurrency, updateCh)
 		backupMeta.Schemas, err = backupSchemas.FinishTableChecksum()
@@ -396,6 +1014,7 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 		}
 		// Checksum has finished
 		updateCh.Close()
+		summary.EndStage(summary.StageChecksum)
 		// collect file information.
 		err = checkChecksums(&backupMeta)
 		if err != nil {
@@ -413,24 +1032,128 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 			if err != nil {
 				return errors.Trace(err)
 			}
+		} else if cfg.SchemaOnly {
+			log.Info("Skip checksum in schema-only backup")
 		} else {
 			// When user specified not to calculate checksum, don't calculate checksum.
 			log.Info("Skip fast checksum because user requirement.")
 		}
 	}
 
+	// Save the snapshots before the backupmeta, which marks the backup complete.
+	topology := backupClusterTopology(ctx, mgr, sidecar)
+	if impact != nil {
+		finishImpactReport(ctx, impact, sidecar, cfg.ImpactInterval)
+	}
+	if cfg.BackupSettings {
+		backupClusterSettings(ctx, g, mgr, sidecar)
+	}
+
+	if cfg.ExternalSchemas {
+		if err = utils.ExternalizeSchemasBy(ctx, schemaStorageOf(dataKey, client.GetStorage()), &backupMeta); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	err = client.SaveBackupMeta(ctx, &backupMeta)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	// The backup is complete, nothing to resume.
+	if checkpointer != nil {
+		if err = client.GetStorage().DeleteFile(ctx, backup.CheckpointFile); err != nil {
+			log.Warn("failed to delete the backup checkpoint", zap.Error(err))
+		}
+	}
 
 	g.Record("Size", utils.ArchiveSize(&backupMeta))
+	if cfg.Catalog {
+		if err = addToCatalog(ctx, root, opts, backupName, &backupMeta, topology); err != nil {
+			return errors.Trace(err)
+		}
+	}
 
 	// Set task summary to success status.
 	summary.SetSuccessStatus(true)
 	return nil
 }
 
+// backupIDLayout is the layout of the default backup IDs, which are ordered
+// by the time.
+const backupIDLayout = "20060102T150405Z"
+
+// checkBackupID checks the backup ID is a single path element.
+func checkBackupID(id string) error {
+	if id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s %s", flagBackupID, id)
+	}
+	return nil
+}
+
+// addToCatalog indexes the backup saved in the catalog of the root storage.
+func addToCatalog(
+	ctx context.Context,
+	root *kvproto.StorageBackend,
+	opts *storage.ExternalStorageOptions,
+	name string,
+	backupMeta *kvproto.BackupMeta,
+	topology *utils.ClusterTopology,
+) error {
+	s, err := storage.New(ctx, root, opts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	entry := utils.CatalogEntry{
+		Name:         name,
+		ClusterID:    backupMeta.ClusterId,
+		StartVersion: backupMeta.StartVersion,
+		EndVersion:   backupMeta.EndVersion,
+		Size:         utils.ArchiveSize(backupMeta),
+	}
+	entry.SetTopology(topology)
+	if err = utils.AddToCatalog(ctx, s, entry); err != nil {
+		return errors.Annotate(err, "failed to update the catalog")
+	}
+	log.Info("backup added to the catalog", zap.String("backup", name))
+	return nil
+}
+
+// checkGCWindow verifies that the snapshots read by the backup, i.e. the
+// oldest of the backup TS, the table TS and the last backup TS, are in the GC
+// window before the backup starts, and pushes the service safe point holding
+// them with --gc-auto-adjust.
+func checkGCWindow(ctx context.Context, pdClient pd.Client, cfg *BackupConfig, sp utils.BRServiceSafePoint) error {
+	window, err := utils.GetGCWindow(ctx, pdClient)
+	if err != nil {
+		return errors.Annotate(err, "failed to get the GC window")
+	}
+	name := "backup TS"
+	if cfg.LastBackupTS > 0 {
+		name = "last backup TS"
+	}
+	if err = window.Check(name, sp.BackupTS); err != nil {
+		return errors.Trace(err)
+	}
+	if !cfg.GCAutoAdjust {
+		return nil
+	}
+	return errors.Trace(utils.PushServiceSafePoint(ctx, pdClient, sp))
+}
+
+// startBackupProgress starts the progress of backing up the regions. The
+// returned stage counts the regions and bytes backed up, so all the kinds of
+// backup report the same detail.
+func startBackupProgress(
+	ctx context.Context,
+	g glue.Glue,
+	cmdName string,
+	regions int,
+	logProgress bool,
+) (updateCh glue.Progress, regionCh glue.Progress) {
+	// Redirect to log if there is no log file to avoid unreadable output.
+	updateCh = g.StartProgress(ctx, cmdName, int64(regions), !logProgress)
+	return updateCh, glue.AddStage(updateCh, "regions", int64(regions))
+}
+
 // checkChecksums checks the checksum of the client, once failed,
 // returning a error with message: "mismatched checksum".
 func checkChecksums(backupMeta *kvproto.BackupMeta) error {
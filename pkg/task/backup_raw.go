@@ -27,6 +27,7 @@ const (
 	flagTiKVColumnFamily = "cf"
 	flagStartKey         = "start"
 	flagEndKey           = "end"
+	flagPartitionRegions = "partition-regions"
 )
 
 // RawKvConfig is the common config for rawkv backup and restore.
@@ -38,6 +39,11 @@ type RawKvConfig struct {
 	CF       string `json:"cf" toml:"cf"`
 	CompressionConfig
 	RemoveSchedulers bool `json:"remove-schedulers" toml:"remove-schedulers"`
+	BackupLock       bool `json:"backup-lock" toml:"backup-lock"`
+	// PartitionRegions is the count of regions in a sub-range, the raw range
+	// is partitioned into sub-ranges backed up in parallel if it's positive.
+	PartitionRegions int `json:"partition-regions" toml:"partition-regions"`
+	ResourceLimitConfig
 }
 
 // DefineRawBackupFlags defines common flags for the backup command.
@@ -48,6 +54,9 @@ func DefineRawBackupFlags(command *cobra.Command) {
 	command.Flags().StringP(flagEndKey, "", "", "backup raw kv end key, key is exclusive")
 	command.Flags().String(flagCompressionType, "zstd",
 		"backup sst file compression algorithm, value can be one of 'lz4|zstd|snappy'")
+	command.Flags().Int(flagPartitionRegions, 0,
+		"partition the range into sub-ranges of the count of regions at the region boundaries, "+
+			"and backup them in parallel, 0 means no partition")
 	command.Flags().Bool(flagRemoveSchedulers, false,
 		"disable the balance, shuffle and region-merge schedulers in PD to speed up backup")
 	// This flag can impact the online cluster, so hide it in case of abuse.
@@ -112,6 +121,22 @@ func (cfg *RawKvConfig) ParseBackupConfigFromFlags(flags *pflag.FlagSet) error {
 		return errors.Trace(err)
 	}
 	cfg.CompressionLevel = level
+	cfg.BackupLock, err = flags.GetBool(flagBackupLock)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.PartitionRegions, err = flags.GetInt(flagPartitionRegions)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.PartitionRegions < 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "negative --%s is not allowed", flagPartitionRegions)
+	}
+	resourceCfg, err := parseResourceLimitFlags(flags)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.ResourceLimitConfig = *resourceCfg
 
 	return nil
 }
@@ -121,6 +146,9 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 	cfg.adjust()
 
 	defer summary.Summary(cmdName)
+	// The gRPC messages are counted per task.
+	wireStats := &utils.GRPCWireStats{}
+	defer collectGRPCCompression(&cfg.Config, wireStats)
 	ctx, cancel := context.WithCancel(c)
 	defer cancel()
 
@@ -128,19 +156,58 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 	if err != nil {
 		return errors.Trace(err)
 	}
-	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements)
+	if len(cfg.Crypter.Databases) > 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "--crypter.key-mapping isn't supported by raw backup")
+	}
+	if err = encryptDataFiles(&cfg.Config, u); err != nil {
+		return errors.Trace(err)
+	}
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.GRPCMaxMsgSize(), cfg.CheckRequirements)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	defer mgr.Close()
+	if err = applyProfileOfCluster(ctx, mgr, &cfg.Config, false); err != nil {
+		return errors.Trace(err)
+	}
+	mgr.SetGRPCCompression(cfg.GRPCCompression, wireStats)
+
+	if cfg.BackupLock {
+		release, err := lockBackupDestination(ctx, cancel, &cfg.Config, u)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer release()
+	}
 
 	client, err := backup.NewBackupClient(ctx, mgr)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	if err = client.SetStorage(ctx, u, cfg.SendCreds); err != nil {
+	limiter, stopLimiter := startResourceLimiter(ctx, cfg.ResourceLimitConfig)
+	defer stopLimiter()
+	client.SetResourceLimiter(limiter)
+	budget, leaveBudget, err := joinBandwidthBudget(ctx, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer leaveBudget()
+	client.SetBandwidthBudget(budget)
+	tuning, unsetTuning := exposeLiveTuning(&cfg.Config)
+	defer unsetTuning()
+	client.SetLiveTuning(tuning)
+	opts, err := cfg.StorageOptions()
+	if err != nil {
 		return errors.Trace(err)
 	}
+	if err = client.SetStorage(ctx, u, opts); err != nil {
+		return errors.Trace(err)
+	}
+	dataKey, err := newDataKey(ctx, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	client.SetDataKey(dataKey)
 
 	backupRange := rtree.Range{StartKey: cfg.StartKey, EndKey: cfg.EndKey}
 
@@ -169,9 +236,7 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 	summary.CollectInt("backup total regions", approximateRegions)
 
 	// Backup
-	// Redirect to log if there is no log file to avoid unreadable output.
-	updateCh := g.StartProgress(
-		ctx, cmdName, int64(approximateRegions), !cfg.LogProgress)
+	updateCh, regionCh := startBackupProgress(ctx, g, cmdName, approximateRegions, cfg.LogProgress)
 
 	req := kvproto.BackupRequest{
 		StartVersion:     0,
@@ -183,7 +248,17 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 		CompressionType:  cfg.CompressionType,
 		CompressionLevel: cfg.CompressionLevel,
 	}
-	files, err := client.BackupRange(ctx, backupRange.StartKey, backupRange.EndKey, req, updateCh)
+	// The sub-ranges are recorded as one raw range in the backup meta, so the
+	// restore of the partitioned backup is the same as the others.
+	ranges, err := backup.PartitionRawRange(
+		ctx, mgr.GetPDClient(), backupRange.StartKey, backupRange.EndKey, cfg.PartitionRegions)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	summary.CollectInt("backup sub-ranges", len(ranges))
+	summary.RegisterStage(summary.StageBackup)
+	files, err := client.BackupRanges(ctx, ranges, req, uint(cfg.Concurrency), regionCh)
+	summary.EndStage(summary.StageBackup)
 	if err != nil {
 		return errors.Trace(err)
 	}
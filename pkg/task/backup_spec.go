@@ -0,0 +1,223 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+
+	"github.com/pingcap/br/pkg/backup"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const flagBackupSpec = "spec"
+
+// BackupSpec is a declarative backup of multiple filter groups, all the
+// groups are backed up at the same snapshot, each to its own sub-prefix of
+// the storage. e.g.
+//
+//	groups:
+//	- name: hot
+//	  filter: ["orders.*", "users.*"]
+//	  prefix: hot
+//	  compression: lz4
+//	- name: archive
+//	  filter: ["archive_*.*"]
+//	  prefix: archive
+//	  compression: zstd
+//	  compression-level: 19
+type BackupSpec struct {
+	Groups []BackupSpecGroup `yaml:"groups"`
+}
+
+// BackupSpecGroup is a group of tables in a backup spec. The options which
+// are not set inherit the command line flags.
+type BackupSpecGroup struct {
+	Name string `yaml:"name"`
+	// Filter is the table filter rules, like `--filter`.
+	Filter        []string `yaml:"filter"`
+	CaseSensitive bool     `yaml:"case-sensitive"`
+	// Prefix is the sub-prefix of the storage the group is backed up to.
+	Prefix           string `yaml:"prefix"`
+	Compression      string `yaml:"compression"`
+	CompressionLevel *int32 `yaml:"compression-level"`
+	// RateLimit is in MB/s like `--ratelimit`.
+	RateLimit   *uint64 `yaml:"ratelimit"`
+	Concurrency *uint32 `yaml:"concurrency"`
+	Checksum    *bool   `yaml:"checksum"`
+}
+
+// DefineBackupSpecFlags defines the --spec flag for `full` subcommand.
+func DefineBackupSpecFlags(flags *pflag.FlagSet) {
+	flags.String(flagBackupSpec, "",
+		"the YAML file of a backup spec, which backs up multiple filter groups "+
+			"to their own sub-prefixes of --storage at the same snapshot")
+}
+
+// ReadBackupSpec reads and validates the backup spec file.
+func ReadBackupSpec(file string) (*BackupSpec, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read backup spec %s", file)
+	}
+	return ParseBackupSpec(data)
+}
+
+// ParseBackupSpec parses and validates the backup spec.
+func ParseBackupSpec(data []byte) (*BackupSpec, error) {
+	spec := &BackupSpec{}
+	if err := yaml.UnmarshalStrict(data, spec); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid backup spec: %s", err)
+	}
+	if len(spec.Groups) == 0 {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, "no group in the backup spec")
+	}
+	prefixes := make(map[string]string, len(spec.Groups))
+	for i := range spec.Groups {
+		group := &spec.Groups[i]
+		if group.Name == "" {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "the name of group #%d is empty", i+1)
+		}
+		if len(group.Filter) == 0 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "the filter of group %s is empty", group.Name)
+		}
+		if _, err := filter.Parse(group.Filter); err != nil {
+			return nil, errors.Annotatef(err, "invalid filter of group %s", group.Name)
+		}
+		if group.Compression != "" {
+			if _, err := parseCompressionType(group.Compression); err != nil {
+				return nil, errors.Annotatef(err, "invalid compression of group %s", group.Name)
+			}
+		}
+		prefix := path.Clean("/" + group.Prefix)
+		if prefix == "/" || strings.Contains(group.Prefix, "..") {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"invalid prefix %q of group %s", group.Prefix, group.Name)
+		}
+		// Otherwise, a group would overwrite the backupmeta of another.
+		if other, ok := prefixes[prefix]; ok {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"the groups %s and %s have the same prefix %q", other, group.Name, group.Prefix)
+		}
+		prefixes[prefix] = group.Name
+		group.Prefix = strings.TrimPrefix(prefix, "/")
+	}
+	return spec, nil
+}
+
+// backupConfig returns the backup config of the group, the base config is
+// from the command line flags.
+func (group *BackupSpecGroup) backupConfig(base *BackupConfig, backupTS uint64) (*BackupConfig, error) {
+	cfg := *base
+	cfg.BackupTS = backupTS
+	cfg.TimeAgo = 0
+	cfg.Cron = ""
+
+	u, err := storage.ParseRawURL(base.Storage)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	u.Path = path.Join(u.Path, group.Prefix)
+	cfg.Storage = u.String()
+
+	cfg.TableFilter, err = filter.Parse(group.Filter)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !group.CaseSensitive {
+		cfg.TableFilter = filter.CaseInsensitive(cfg.TableFilter)
+	}
+	// Only the table TS overrides of the tables in the group apply.
+	cfg.TableTS = nil
+	for _, t := range base.TableTS {
+		if cfg.TableFilter.MatchTable(t.DB, t.Table) {
+			cfg.TableTS = append(cfg.TableTS, t)
+		}
+	}
+	if group.Compression != "" {
+		cfg.CompressionType, err = parseCompressionType(group.Compression)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		// The level of another algorithm doesn't make sense.
+		cfg.CompressionLevel = 0
+	}
+	if group.CompressionLevel != nil {
+		cfg.CompressionLevel = *group.CompressionLevel
+	}
+	if group.RateLimit != nil {
+		cfg.RateLimit = *group.RateLimit * utils.MB
+	}
+	if group.Concurrency != nil {
+		cfg.Concurrency = *group.Concurrency
+	}
+	if group.Checksum != nil {
+		cfg.Checksum = *group.Checksum
+	}
+	return &cfg, nil
+}
+
+// RunBackupSpec backs up the groups of the spec one after another, at the
+// same snapshot.
+func RunBackupSpec(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig, spec *BackupSpec) error {
+	cfg.adjustBackupConfig()
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	// Only a PD client is needed for the snapshot, each group connects to the
+	// cluster by itself.
+	securityOption, pdTLSConf, err := pdSecurityOption(cfg.TLS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	pdCtl, err := pdutil.NewPdController(ctx, strings.Join(cfg.PD, ","), pdTLSConf, securityOption, cfg.GRPCMaxMsgSize())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer pdCtl.Close()
+	backupTS, err := backup.GetTS(ctx, pdCtl.GetPDClient(), cfg.TimeAgo, cfg.BackupTS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// Each group keeps its own safe point only while it runs, so the snapshot
+	// must be kept for all the groups.
+	sp := utils.BRServiceSafePoint{
+		BackupTS: backupTS,
+		TTL:      cfg.GCTTL,
+		ID:       utils.MakeSafePointID(),
+	}
+	if cfg.LastBackupTS > 0 {
+		sp.BackupTS = cfg.LastBackupTS
+	}
+	log.Info("backup spec safePoint job", zap.Object("safePoint", sp))
+	utils.StartServiceSafePointKeeper(ctx, pdCtl.GetPDClient(), sp)
+
+	for i := range spec.Groups {
+		group := &spec.Groups[i]
+		groupCfg, err := group.backupConfig(cfg, backupTS)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("start to backup the group of spec",
+			zap.String("group", group.Name),
+			zap.String("prefix", group.Prefix),
+			zap.Uint64("backup-ts", backupTS))
+		if err = RunBackup(ctx, g, cmdName, groupCfg); err != nil {
+			return errors.Annotatef(err, "failed to backup group %s", group.Name)
+		}
+	}
+	return nil
+}
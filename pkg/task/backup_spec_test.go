@@ -0,0 +1,70 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	. "github.com/pingcap/check"
+	kvproto "github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+var _ = Suite(&testBackupSpecSuite{})
+
+type testBackupSpecSuite struct{}
+
+func (s *testBackupSpecSuite) TestParseBackupSpec(c *C) {
+	spec, err := ParseBackupSpec([]byte(`
+groups:
+- name: hot
+  filter: ["orders.*", "users.*"]
+  prefix: /hot/
+  compression: lz4
+  ratelimit: 128
+- name: archive
+  filter: ["archive_*.*"]
+  prefix: archive
+  compression: zstd
+  compression-level: 19
+`))
+	c.Assert(err, IsNil)
+	c.Assert(spec.Groups, HasLen, 2)
+	c.Assert(spec.Groups[0].Prefix, Equals, "hot")
+
+	base := &BackupConfig{Config: Config{Storage: "s3://bucket/backup?endpoint=http://minio:9000"}}
+	base.CompressionType = kvproto.CompressionType_ZSTD
+	base.CompressionLevel = 3
+	cfg, err := spec.Groups[0].backupConfig(base, 42)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.BackupTS, Equals, uint64(42))
+	c.Assert(cfg.Storage, Equals, "s3://bucket/backup/hot?endpoint=http://minio:9000")
+	c.Assert(cfg.CompressionType, Equals, kvproto.CompressionType_LZ4)
+	c.Assert(cfg.CompressionLevel, Equals, int32(0))
+	c.Assert(cfg.RateLimit, Equals, 128*utils.MB)
+	c.Assert(cfg.TableFilter.MatchTable("Orders", "t"), IsTrue)
+	c.Assert(cfg.TableFilter.MatchTable("archive_2020", "t"), IsFalse)
+
+	cfg, err = spec.Groups[1].backupConfig(base, 42)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Storage, Equals, "s3://bucket/backup/archive?endpoint=http://minio:9000")
+	c.Assert(cfg.CompressionLevel, Equals, int32(19))
+	// The base config isn't changed.
+	c.Assert(base.CompressionType, Equals, kvproto.CompressionType_ZSTD)
+}
+
+func (s *testBackupSpecSuite) TestParseInvalidBackupSpec(c *C) {
+	cases := []string{
+		``,
+		`groups: []`,
+		`groups: [{name: a, filter: ["*.*"]}]`,
+		`groups: [{name: a, filter: ["*.*"], prefix: ../a}]`,
+		`groups: [{name: a, prefix: a}]`,
+		`groups: [{name: a, filter: ["*.*"], prefix: a, compression: gzip}]`,
+		`groups: [{name: a, filter: ["*.*"], prefix: a}, {name: b, filter: ["*.*"], prefix: a/}]`,
+		`groups: [{name: a, filter: ["*.*"], prefix: a, unknown: 1}]`,
+	}
+	for _, ca := range cases {
+		_, err := ParseBackupSpec([]byte(ca))
+		c.Assert(err, NotNil, Commentf("spec: %s", ca))
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+	"github.com/pingcap/tidb/infoschema"
+	"github.com/spf13/pflag"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+
+	"github.com/pingcap/br/pkg/backup"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	flagTableTS    = "table-ts"
+	flagLastBackup = "lastbackup"
+)
+
+// TableTS is the snapshot TS override of a table, the table is backed up at
+// the TS instead of the backup TS. It's for the huge append-only tables whose
+// data at a slightly older TS is already covered by other means, e.g. storage
+// snapshots.
+type TableTS struct {
+	DB    string `json:"db" toml:"db"`
+	Table string `json:"table" toml:"table"`
+	TS    uint64 `json:"ts" toml:"ts"`
+}
+
+// DefineTableTSFlags defines the --table-ts and --lastbackup flags for the
+// backup commands.
+func DefineTableTSFlags(flags *pflag.FlagSet) {
+	flags.String(flagTableTS, "",
+		"(advanced) the YAML file mapping `db.table` to the TS the table is backed up at instead of the backup TS, "+
+			"only for the append-only tables whose data at the older TS is enough")
+	flags.String(flagLastBackup, "",
+		"(experimental) the storage of the last backup, for incremental backup, "+
+			"the tables it backed up by --table-ts are backed up from their own TS instead of --lastbackupts")
+}
+
+// ReadTableTS reads the per-table snapshot overrides file.
+func ReadTableTS(file string) ([]TableTS, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read the table TS file %s", file)
+	}
+	return ParseTableTS(data)
+}
+
+// ParseTableTS parses the per-table snapshot overrides, a YAML map from
+// `db.table` to the TS, in TSO or datetime like `--backupts`. e.g.
+//
+//	logs.events: 421234567890123456
+//	logs.clicks: "2020-12-01 00:00:00 +0800"
+func ParseTableTS(data []byte) ([]TableTS, error) {
+	overrides := make(yaml.MapSlice, 0)
+	if err := yaml.UnmarshalStrict(data, &overrides); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid table TS: %s", err)
+	}
+	tables := make([]TableTS, 0, len(overrides))
+	seen := make(map[string]struct{}, len(overrides))
+	for _, item := range overrides {
+		name, ok := item.Key.(string)
+		parts := strings.SplitN(name, ".", 2)
+		if !ok || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"invalid table name %v of table TS, should be `db.table`", item.Key)
+		}
+		lowerName := strings.ToLower(name)
+		if _, ok := seen[lowerName]; ok {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "duplicated table TS of %s", name)
+		}
+		seen[lowerName] = struct{}{}
+		ts, err := parseTSString(strings.TrimSpace(tsValueString(item.Value)))
+		if err != nil || ts == 0 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid TS %v of table %s", item.Value, name)
+		}
+		tables = append(tables, TableTS{DB: parts[0], Table: parts[1], TS: ts})
+	}
+	return tables, nil
+}
+
+func tsValueString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.FormatInt(int64(v), 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	default:
+		return ""
+	}
+}
+
+// resolveTableTS checks the snapshot TS overrides against the schemas at the
+// backup TS, and returns the TS of the physical tables indexed by their IDs.
+func resolveTableTS(
+	ctx context.Context,
+	pdClient pd.Client,
+	info infoschema.InfoSchema,
+	tableFilter filter.Filter,
+	overrides []TableTS,
+	backupTS, lastBackupTS uint64,
+) (map[int64]uint64, error) {
+	tableTS := make(map[int64]uint64, len(overrides))
+	for _, o := range overrides {
+		name := utils.EncloseName(o.DB) + "." + utils.EncloseName(o.Table)
+		tbl, err := info.TableByName(model.NewCIStr(o.DB), model.NewCIStr(o.Table))
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "table %s of table TS doesn't exist", name)
+		}
+		tableInfo := tbl.Meta()
+		if !tableFilter.MatchTable(o.DB, o.Table) {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "table %s of table TS isn't backed up", name)
+		}
+		if tableInfo.IsView() || tableInfo.IsSequence() {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "table %s of table TS has no data", name)
+		}
+		switch {
+		case o.TS > backupTS:
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"the TS %d of table %s is newer than the backup TS %d", o.TS, name, backupTS)
+		case o.TS <= lastBackupTS:
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"the TS %d of table %s isn't newer than the last backup TS %d", o.TS, name, lastBackupTS)
+		case tableInfo.UpdateTS > o.TS:
+			// The schema in the backup is at the backup TS, it must match the data.
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"the schema of table %s is changed at %d, after its TS %d", name, tableInfo.UpdateTS, o.TS)
+		}
+		if err = utils.CheckGCSafePoint(ctx, pdClient, o.TS); err != nil {
+			return nil, errors.Annotatef(err, "the TS of table %s", name)
+		}
+		tableTS[tableInfo.ID] = o.TS
+		if pi := tableInfo.GetPartitionInfo(); pi != nil {
+			for _, def := range pi.Definitions {
+				tableTS[def.ID] = o.TS
+			}
+		}
+		log.Info("backup table at its own TS",
+			zap.String("table", name),
+			zap.Uint64("ts", o.TS),
+			zap.Uint64("backup-ts", backupTS))
+	}
+	return tableTS, nil
+}
+
+// readLastTableTS reads the TS of the tables the last backup backed up at their
+// own TS from its backupmeta.
+func readLastTableTS(ctx context.Context, cfg *BackupConfig) (map[int64]uint64, error) {
+	lastCfg := cfg.Config
+	lastCfg.Storage = cfg.LastBackup
+	_, _, lastMeta, err := ReadBackupMeta(ctx, utils.MetaFile, &lastCfg)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read the last backup %s", cfg.LastBackup)
+	}
+	if lastMeta.EndVersion != cfg.LastBackupTS {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the last backup %s is at %d, not --%s %d", cfg.LastBackup, lastMeta.EndVersion, flagLastBackupTS, cfg.LastBackupTS)
+	}
+	lastTableTS := backup.TableTSOf(lastMeta)
+	for id, ts := range lastTableTS {
+		log.Info("incremental backup table from its own TS",
+			zap.Int64("table-id", id),
+			zap.Uint64("ts", ts),
+			zap.Uint64("last-backup-ts", cfg.LastBackupTS))
+	}
+	return lastTableTS, nil
+}
+
+// minTableTS returns the min TS of the tables, or the backup TS if it's less.
+func minTableTS(tableTS map[int64]uint64, backupTS uint64) uint64 {
+	minTS := backupTS
+	for _, ts := range tableTS {
+		if ts < minTS {
+			minTS = ts
+		}
+	}
+	return minTS
+}
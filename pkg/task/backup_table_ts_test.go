@@ -0,0 +1,35 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testTableTSSuite{})
+
+type testTableTSSuite struct{}
+
+func (s *testTableTSSuite) TestParseTableTS(c *C) {
+	tables, err := ParseTableTS([]byte(`
+logs.events: 421234567890123456
+Logs.Clicks: "421234567890123457"
+`))
+	c.Assert(err, IsNil)
+	c.Assert(tables, DeepEquals, []TableTS{
+		{DB: "logs", Table: "events", TS: 421234567890123456},
+		{DB: "Logs", Table: "Clicks", TS: 421234567890123457},
+	})
+
+	_, err = ParseTableTS([]byte(`events: 421234567890123456`))
+	c.Assert(err, ErrorMatches, ".*invalid table name events.*")
+	_, err = ParseTableTS([]byte(`logs.events: yesterday`))
+	c.Assert(err, ErrorMatches, ".*invalid TS yesterday of table logs.events.*")
+	_, err = ParseTableTS([]byte("logs.events: 1\nLOGS.EVENTS: 2"))
+	c.Assert(err, ErrorMatches, ".*duplicated table TS of LOGS.EVENTS.*")
+}
+
+func (s *testTableTSSuite) TestMinTableTS(c *C) {
+	c.Assert(minTableTS(nil, 42), Equals, uint64(42))
+	c.Assert(minTableTS(map[int64]uint64{1: 40, 2: 41}, 42), Equals, uint64(40))
+}
@@ -1,10 +1,16 @@
 package task
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	. "github.com/pingcap/check"
+	"github.com/spf13/pflag"
+
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/rtree"
 )
 
 var _ = Suite(&testBackupSuite{})
@@ -34,3 +40,121 @@ func (s *testBackupSuite) TestParseTSString(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(int(ts), Equals, 400032515489792000-(offset*1000)<<18)
 }
+
+func (s *testBackupSuite) TestFilterEmptyRanges(c *C) {
+	ranges := []rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("b")},
+		{StartKey: []byte("b"), EndKey: []byte("c")},
+		{StartKey: []byte("c"), EndKey: []byte("d")},
+		{StartKey: []byte("d"), EndKey: []byte("e")},
+	}
+	stats := map[string]*pdutil.RegionStats{
+		"a": {Count: 2, StorageSize: 96, StorageKeys: 1000},
+		// The regions of b are empty.
+		"b": {Count: 1},
+		// The size of c is reported but its keys aren't yet.
+		"c": {Count: 1, StorageSize: 1},
+		// The statistics of d lag behind the keys written.
+		"d": {Count: 1},
+	}
+	getStats := func(_ context.Context, startKey, _ []byte) (*pdutil.RegionStats, error) {
+		return stats[string(startKey)], nil
+	}
+	scanned := make([]string, 0, 2)
+	confirmEmpty := func(_ context.Context, startKey, _ []byte) (bool, error) {
+		scanned = append(scanned, string(startKey))
+		return string(startKey) != "d", nil
+	}
+
+	filtered, regions, err := filterEmptyRanges(context.Background(), getStats, confirmEmpty, ranges, false)
+	c.Assert(err, IsNil)
+	c.Assert(filtered, HasLen, 4)
+	c.Assert(regions, Equals, 5)
+	c.Assert(scanned, HasLen, 0)
+
+	filtered, regions, err = filterEmptyRanges(context.Background(), getStats, confirmEmpty, ranges, true)
+	c.Assert(err, IsNil)
+	c.Assert(filtered, DeepEquals, []rtree.Range{ranges[0], ranges[2], ranges[3]})
+	c.Assert(regions, Equals, 4)
+	c.Assert(scanned, DeepEquals, []string{"b", "d"})
+}
+
+func (s *testBackupSuite) TestParseSchemaOnly(c *C) {
+	parse := func(args ...string) (*BackupConfig, error) {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		DefineCommonFlags(flags)
+		DefineBackupFlags(flags)
+		flags.StringArrayP(flagFilter, "f", nil, "")
+		flags.Bool(flagCaseSensitive, false, "")
+		c.Assert(flags.Parse(append([]string{"--pd", "pd:2379", "-s", "local:///tmp/backup"}, args...)), IsNil)
+		cfg := &BackupConfig{}
+		return cfg, cfg.ParseFromFlags(flags)
+	}
+
+	cfg, err := parse("--schema-only")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.SchemaOnly, IsTrue)
+	_, err = parse("--schema-only", "--lastbackupts", "1")
+	c.Assert(err, ErrorMatches, ".*--schema-only can't be used with --lastbackupts or --impact-report.*")
+}
+
+// testProgress is a glue.StagedProgress counting the progress and the bytes.
+type testProgress struct {
+	total  int64
+	incs   int64
+	bytes  uint64
+	stages map[string]*testProgress
+}
+
+func (p *testProgress) Inc()              { p.incs++ }
+func (p *testProgress) Close()            { p.incs = p.total }
+func (p *testProgress) AddBytes(n uint64) { p.bytes += n }
+
+func (p *testProgress) AddStage(name string, total int64) glue.Progress {
+	stage := &testProgress{total: total}
+	if p.stages == nil {
+		p.stages = make(map[string]*testProgress)
+	}
+	p.stages[name] = stage
+	return stage
+}
+
+type progressGlue struct {
+	glue.Glue
+	progress glue.Progress
+}
+
+func (g progressGlue) StartProgress(context.Context, string, int64, bool) glue.Progress {
+	return g.progress
+}
+
+func (s *testBackupSuite) TestStartBackupProgress(c *C) {
+	ctx := context.Background()
+	overall := &testProgress{total: 4}
+	updateCh, regionCh := startBackupProgress(ctx, progressGlue{progress: overall}, "Raw backup", 4, true)
+	c.Assert(updateCh, Equals, glue.Progress(overall))
+	// The backed up regions count in both the overall progress and the
+	// stage, which also counts the bytes.
+	regionCh.Inc()
+	glue.AddBytes(regionCh, 100)
+	regionCh.Inc()
+	glue.AddBytes(regionCh, 20)
+	c.Assert(overall.incs, Equals, int64(2))
+	c.Assert(overall.stages["regions"], DeepEquals, &testProgress{total: 4, incs: 2, bytes: 120})
+	regionCh.Close()
+	c.Assert(overall.stages["regions"].incs, Equals, int64(4))
+
+	// The progress without stages still counts the regions.
+	plain := &plainProgress{}
+	_, regionCh = startBackupProgress(ctx, progressGlue{progress: plain}, "Txn backup", 4, true)
+	regionCh.Inc()
+	glue.AddBytes(regionCh, 100)
+	c.Assert(plain.incs, Equals, 1)
+}
+
+type plainProgress struct {
+	incs int
+}
+
+func (p *plainProgress) Inc()   { p.incs++ }
+func (p *plainProgress) Close() {}
@@ -0,0 +1,138 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	flagKeepLast = "keep-last"
+	flagDryRun   = "dry-run"
+)
+
+// GCConfig is the configuration specific for the gc tasks.
+type GCConfig struct {
+	Config
+
+	// KeepLast is the count of the latest backups of every cluster to keep.
+	KeepLast uint `json:"keep-last" toml:"keep-last"`
+	// DryRun only prints the backups to delete.
+	DryRun bool `json:"dry-run" toml:"dry-run"`
+}
+
+// DefineGCFlags defines the flags of the gc command.
+func DefineGCFlags(flags *pflag.FlagSet) {
+	flags.Uint(flagKeepLast, 0,
+		"the count of the latest backups of every cluster to keep, "+
+			"the backups they are incremental to are kept as well")
+	flags.Bool(flagDryRun, false, "only print the backups to delete")
+}
+
+// ParseFromFlags parses the gc-related flags from the flag set.
+func (cfg *GCConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	if err := cfg.Config.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	var err error
+	cfg.KeepLast, err = flags.GetUint(flagKeepLast)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.KeepLast == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must be positive", flagKeepLast)
+	}
+	cfg.DryRun, err = flags.GetBool(flagDryRun)
+	return errors.Trace(err)
+}
+
+// ListBackups returns the backups in the catalog of the storage. The storage
+// without a catalog is scanned for the backupmeta files instead.
+func ListBackups(ctx context.Context, cfg *Config) ([]utils.CatalogEntry, error) {
+	u, s, err := GetStorage(ctx, cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return listCatalogBackups(ctx, cfg, u, s)
+}
+
+// listCatalogBackups is ListBackups on the opened storage.
+func listCatalogBackups(
+	ctx context.Context,
+	cfg *Config,
+	u *backuppb.StorageBackend,
+	s storage.ExternalStorage,
+) ([]utils.CatalogEntry, error) {
+	catalog, err := utils.ReadCatalog(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if catalog != nil {
+		return catalog.Backups, nil
+	}
+	log.Warn("the storage has no catalog, scan it for the backups", zap.String("storage", s.URI()))
+	backups, err := listBackups(ctx, cfg, u, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name < backups[j].Name })
+	return backups, nil
+}
+
+// expiredBackups returns the backups out of the latest keepLast backups of
+// their clusters, see deletableBackups. The backups are ordered by the end
+// versions descending, so an incremental backup is deleted before the one
+// it's based on.
+func expiredBackups(
+	backups []utils.CatalogEntry, keepLast uint,
+) (deletable []utils.CatalogEntry, kept []utils.CatalogEntry) {
+	sorted := append([]utils.CatalogEntry(nil), backups...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].EndVersion > sorted[j].EndVersion })
+	latest := make(map[string]struct{})
+	counts := make(map[uint64]uint)
+	for _, b := range sorted {
+		if counts[b.ClusterID] < keepLast {
+			counts[b.ClusterID]++
+			latest[b.Name] = struct{}{}
+		}
+	}
+	return deletableBackups(sorted, func(b utils.CatalogEntry) bool {
+		_, ok := latest[b.Name]
+		return !ok
+	})
+}
+
+// RunGC deletes the backups in the catalog except the latest --keep-last ones
+// of every cluster, the backups are found by the catalog without scanning the
+// storage. The backups the retained ones are incremental to are kept as well.
+func RunGC(c context.Context, cmdName string, cfg *GCConfig) error {
+	defer summary.Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	u, s, err := GetStorage(ctx, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	catalog, err := utils.ReadCatalog(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if catalog == nil {
+		return errors.Annotate(berrors.ErrInvalidArgument, "no catalog in the storage")
+	}
+	deletable, kept := expiredBackups(catalog.Backups, cfg.KeepLast)
+	return errors.Trace(deleteExpiredBackups(ctx, &cfg.Config, u, s, deletable, kept, cfg.DryRun))
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+var _ = Suite(&testCatalogSuite{})
+
+type testCatalogSuite struct{}
+
+func (*testCatalogSuite) TestExpiredBackups(c *C) {
+	backups := []utils.CatalogEntry{
+		{Name: "1/full1", ClusterID: 1, EndVersion: 10},
+		{Name: "1/inc1", ClusterID: 1, StartVersion: 10, EndVersion: 20},
+		{Name: "1/full2", ClusterID: 1, EndVersion: 30},
+		{Name: "1/inc2", ClusterID: 1, StartVersion: 20, EndVersion: 40},
+		{Name: "2/full", ClusterID: 2, EndVersion: 5},
+	}
+	// inc2 is based on inc1 and full1.
+	deletable, kept := expiredBackups(backups, 1)
+	c.Assert(deletable, DeepEquals, []utils.CatalogEntry{
+		{Name: "1/full2", ClusterID: 1, EndVersion: 30},
+	})
+	c.Assert(kept, DeepEquals, []utils.CatalogEntry{
+		{Name: "1/inc1", ClusterID: 1, StartVersion: 10, EndVersion: 20},
+		{Name: "1/full1", ClusterID: 1, EndVersion: 10},
+	})
+	deletable, kept = expiredBackups(backups[:3], 1)
+	c.Assert(deletable, DeepEquals, []utils.CatalogEntry{
+		{Name: "1/inc1", ClusterID: 1, StartVersion: 10, EndVersion: 20},
+		{Name: "1/full1", ClusterID: 1, EndVersion: 10},
+	})
+	c.Assert(kept, HasLen, 0)
+	deletable, _ = expiredBackups(backups, 3)
+	c.Assert(deletable, HasLen, 0)
+}
+
+func (*testCatalogSuite) TestCheckBackupID(c *C) {
+	c.Assert(checkBackupID(""), IsNil)
+	c.Assert(checkBackupID("20201201T020000Z"), IsNil)
+	c.Assert(checkBackupID("a/b"), ErrorMatches, ".*invalid --backup-id.*")
+	c.Assert(checkBackupID(".."), ErrorMatches, ".*invalid --backup-id.*")
+}
+
+func (*testCatalogSuite) TestRunGC(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	writeTestBackup(c, filepath.Join(dir, "1", "full1"), &backup.BackupMeta{ClusterId: 1, EndVersion: 10})
+	writeTestBackup(c, filepath.Join(dir, "1", "full2"), &backup.BackupMeta{ClusterId: 1, EndVersion: 20})
+	s, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	for _, entry := range []utils.CatalogEntry{
+		{Name: "1/full2", ClusterID: 1, EndVersion: 20},
+		{Name: "1/full1", ClusterID: 1, EndVersion: 10},
+	} {
+		c.Assert(utils.AddToCatalog(ctx, s, entry), IsNil)
+	}
+	// Every backup has its own entry in the catalog.
+	_, err = os.Stat(filepath.Join(dir, utils.CatalogDir, "1", "full2.json"))
+	c.Assert(err, IsNil)
+
+	cfg := &Config{Storage: "local://" + dir}
+	backups, err := ListBackups(ctx, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(backups, HasLen, 2)
+	c.Assert(backups[0].Name, Equals, "1/full1")
+
+	gcCfg := &GCConfig{Config: *cfg, KeepLast: 1, DryRun: true}
+	c.Assert(RunGC(ctx, "GC", gcCfg), IsNil)
+	_, err = os.Stat(filepath.Join(dir, "1", "full1", utils.MetaFile))
+	c.Assert(err, IsNil)
+
+	gcCfg.DryRun = false
+	c.Assert(RunGC(ctx, "GC", gcCfg), IsNil)
+	_, err = os.Stat(filepath.Join(dir, "1", "full1", utils.MetaFile))
+	c.Assert(os.IsNotExist(err), IsTrue)
+	backups, err = ListBackups(ctx, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(backups, DeepEquals, []utils.CatalogEntry{{Name: "1/full2", ClusterID: 1, EndVersion: 20}})
+	_, err = os.Stat(filepath.Join(dir, utils.CatalogDir, "1", "full1.json"))
+	c.Assert(os.IsNotExist(err), IsTrue)
+}
+
+func (*testCatalogSuite) TestListBackupsTopology(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	writeTestBackup(c, filepath.Join(dir, "full"), &backup.BackupMeta{ClusterId: 1, EndVersion: 10})
+	writeTestBackup(c, filepath.Join(dir, "old"), &backup.BackupMeta{ClusterId: 1, EndVersion: 5})
+	s, err := storage.NewLocalStorage(filepath.Join(dir, "full"))
+	c.Assert(err, IsNil)
+	c.Assert(utils.SaveClusterTopology(ctx, s, &utils.ClusterTopology{
+		Stores: []utils.StoreTopology{
+			{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4, TiFlash: true},
+		},
+		RegionCount: 42,
+	}), IsNil)
+
+	// The storage has no catalog, the topology is read with the backupmeta.
+	backups, err := ListBackups(ctx, &Config{Storage: "local://" + dir})
+	c.Assert(err, IsNil)
+	c.Assert(backups, DeepEquals, []utils.CatalogEntry{
+		{Name: "full", ClusterID: 1, EndVersion: 10, TiKVStores: 3, TiFlashStores: 1, Regions: 42},
+		// The backup without the topology is listed as unknown.
+		{Name: "old", ClusterID: 1, EndVersion: 5},
+	})
+}
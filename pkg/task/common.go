@@ -6,7 +6,9 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"strings"
 	"time"
@@ -22,14 +24,17 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	pd "github.com/tikv/pd/client"
+	"go.etcd.io/etcd/clientv3"
 	"go.etcd.io/etcd/pkg/transport"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/keepalive"
 
 	"github.com/pingcap/br/pkg/conn"
+	"github.com/pingcap/br/pkg/encryption"
 	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/glue"
 	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
 	"github.com/pingcap/br/pkg/utils"
 )
 
@@ -38,6 +43,9 @@ const (
 	flagSendCreds = "send-credentials-to-tikv"
 	// flagStorage is the name of storage flag.
 	flagStorage = "storage"
+	// EnvStorage is the environment variable of the storage URL, which is
+	// used when --storage isn't set.
+	EnvStorage = "BR_STORAGE"
 	// flagPD is the name of PD url flag.
 	flagPD = "pd"
 	// flagCA is the name of TLS CA flag.
@@ -46,6 +54,11 @@ const (
 	flagCert = "cert"
 	// flagKey is the name of TLS key flag.
 	flagKey = "key"
+	// flagPDTLSPrefix, flagTiKVTLSPrefix and flagStorageTLSPrefix are the
+	// prefixes of the TLS flags which only apply to one component, e.g. `--pd.ca`.
+	flagPDTLSPrefix      = "pd."
+	flagTiKVTLSPrefix    = "tikv."
+	flagStorageTLSPrefix = "storage."
 
 	flagDatabase = "db"
 	flagTable    = "table"
@@ -64,10 +77,28 @@ const (
 	flagGrpcKeepaliveTime = "grpc-keepalive-time"
 	// flagGrpcKeepaliveTimeout is the max time a grpc conn can keep idel before killed.
 	flagGrpcKeepaliveTimeout = "grpc-keepalive-timeout"
+	// flagGrpcCompression is the compression algorithm of gRPC messages between BR and TiKV.
+	flagGrpcCompression = "grpc-compression"
+
+	// flagStoreAddrMap is the rules mapping the store addresses reported by PD
+	// to the addresses reachable from BR.
+	flagStoreAddrMap = "store-addr-map"
+	// flagBandwidthBudget is the aggregate rate limit shared by the tasks of
+	// the cluster.
+	flagBandwidthBudget = "bandwidth-budget"
+
+	flagGrpcMaxRecvMsgSize = "grpc-max-recv-msg-size"
+	flagGrpcMaxSendMsgSize = "grpc-max-send-msg-size"
+
+	// flagTmpDir is the root of the temporary directory staging the data on
+	// the local disk.
+	flagTmpDir      = "tmp-dir"
+	flagTmpDirQuota = "tmp-dir-quota"
 
 	defaultSwitchInterval       = 5 * time.Minute
 	defaultGRPCKeepaliveTime    = 10 * time.Second
 	defaultGRPCKeepaliveTimeout = 3 * time.Second
+	etcdDialTimeout             = 5 * time.Second
 )
 
 // TLSConfig is the common configuration for TLS connection.
@@ -75,6 +106,51 @@ type TLSConfig struct {
 	CA   string `json:"ca" toml:"ca"`
 	Cert string `json:"cert" toml:"cert"`
 	Key  string `json:"key" toml:"key"`
+
+	// PD and TiKV override the common TLS materials field by field for the
+	// connections to the component.
+	PD   ComponentTLSConfig `json:"pd" toml:"pd"`
+	TiKV ComponentTLSConfig `json:"tikv" toml:"tikv"`
+	// Storage is the TLS materials for the connections to the external storage.
+	// It doesn't inherit the common ones, since the storage is seldom signed by
+	// the CA of the cluster.
+	Storage ComponentTLSConfig `json:"storage" toml:"storage"`
+}
+
+// ComponentTLSConfig is the TLS materials for connections to one component.
+type ComponentTLSConfig struct {
+	CA   string `json:"ca" toml:"ca"`
+	Cert string `json:"cert" toml:"cert"`
+	Key  string `json:"key" toml:"key"`
+}
+
+func (tls *TLSConfig) inherit(c ComponentTLSConfig) TLSConfig {
+	res := TLSConfig{CA: c.CA, Cert: c.Cert, Key: c.Key}
+	if res.CA == "" {
+		res.CA = tls.CA
+	}
+	if res.Cert == "" {
+		res.Cert = tls.Cert
+	}
+	if res.Key == "" {
+		res.Key = tls.Key
+	}
+	return res
+}
+
+// ForPD returns the TLS config used to connect to PD.
+func (tls *TLSConfig) ForPD() TLSConfig {
+	return tls.inherit(tls.PD)
+}
+
+// ForTiKV returns the TLS config used to connect to TiKV.
+func (tls *TLSConfig) ForTiKV() TLSConfig {
+	return tls.inherit(tls.TiKV)
+}
+
+// ForStorage returns the TLS config used to connect to the external storage.
+func (tls *TLSConfig) ForStorage() TLSConfig {
+	return TLSConfig{CA: tls.Storage.CA, Cert: tls.Storage.Cert, Key: tls.Storage.Key}
 }
 
 // IsEnabled checks if TLS open or not.
@@ -83,6 +159,10 @@ func (tls *TLSConfig) IsEnabled() bool {
 }
 
 // ToTLSConfig generate tls.Config.
+//
+// The client certificate is reloaded once the cert or key file is modified,
+// so the long-running tasks survive the certificate rotation. The CA is only
+// loaded once.
 func (tls *TLSConfig) ToTLSConfig() (*tls.Config, error) {
 	tlsInfo := transport.TLSInfo{
 		CertFile:      tls.Cert,
@@ -93,6 +173,13 @@ func (tls *TLSConfig) ToTLSConfig() (*tls.Config, error) {
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	if tls.Cert != "" && tls.Key != "" {
+		reloader, err := utils.NewCertReloader(tls.Cert, tls.Key)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		reloader.Apply(tlsConfig)
+	}
 	return tlsConfig, nil
 }
 
@@ -130,20 +217,52 @@ type Config struct {
 	GRPCKeepaliveTime time.Duration `json:"grpc-keepalive-time" toml:"grpc-keepalive-time"`
 	// GrpcKeepaliveTimeout is the max time a grpc conn can keep idel before killed.
 	GRPCKeepaliveTimeout time.Duration `json:"grpc-keepalive-timeout" toml:"grpc-keepalive-timeout"`
+	// GRPCCompression is the compression algorithm of gRPC messages between BR and TiKV.
+	GRPCCompression string `json:"grpc-compression" toml:"grpc-compression"`
+	// GRPCMaxRecvMsgSize and GRPCMaxSendMsgSize are the max size of the gRPC
+	// messages of all BR clients, zero means utils.DefaultGRPCMaxMsgSize.
+	GRPCMaxRecvMsgSize uint64 `json:"grpc-max-recv-msg-size" toml:"grpc-max-recv-msg-size"`
+	GRPCMaxSendMsgSize uint64 `json:"grpc-max-send-msg-size" toml:"grpc-max-send-msg-size"`
+	// StoreAddrMap is the rules mapping the store addresses reported by PD to
+	// the addresses dialed by BR, see utils.ParseStoreAddrMap.
+	StoreAddrMap []string `json:"store-addr-map" toml:"store-addr-map"`
+	// BandwidthBudget is the aggregate rate limit (in bytes/s per TiKV node)
+	// leased among the running tasks of the cluster, zero means no budget.
+	BandwidthBudget uint64 `json:"bandwidth-budget" toml:"bandwidth-budget"`
+	// TmpDir is the root of the temporary directory of the features staging
+	// the data on the local disk, empty means the temporary directory of the
+	// OS. TmpDirQuota is the max bytes staged in it, zero means no limit.
+	TmpDir      string `json:"tmp-dir" toml:"tmp-dir"`
+	TmpDirQuota uint64 `json:"tmp-dir-quota" toml:"tmp-dir-quota"`
+	// Crypter is the client-side encryption of the meta files of the backup.
+	Crypter encryption.Config `json:"crypter" toml:"crypter"`
+
+	// Profile is the preset of the performance fields, see profiles.
+	Profile string `json:"profile" toml:"profile"`
+	// explicitFlags are the performance flags set explicitly, which override
+	// the profile.
+	explicitFlags map[string]bool
 }
 
 // DefineCommonFlags defines the flags common to all BRIE commands.
 func DefineCommonFlags(flags *pflag.FlagSet) {
 	flags.BoolP(flagSendCreds, "c", true, "Whether send credentials to tikv")
-	flags.StringP(flagStorage, "s", "", `specify the url where backup storage, eg, "s3://bucket/path/prefix"`)
+	flags.StringP(flagStorage, "s", "",
+		`specify the url where backup storage, eg, "s3://bucket/path/prefix", it can also be set by the env `+EnvStorage)
 	flags.StringSliceP(flagPD, "u", []string{"127.0.0.1:2379"}, "PD address")
 	flags.String(flagCA, "", "CA certificate path for TLS connection")
 	flags.String(flagCert, "", "Certificate path for TLS connection")
 	flags.String(flagKey, "", "Private key path for TLS connection")
+	defineComponentTLSFlags(flags, flagPDTLSPrefix, "PD, override --ca/--cert/--key")
+	defineComponentTLSFlags(flags, flagTiKVTLSPrefix, "TiKV, override --ca/--cert/--key")
+	defineComponentTLSFlags(flags, flagStorageTLSPrefix, "the external storage")
 	flags.Uint(flagChecksumConcurrency, variable.DefChecksumTableConcurrency, "The concurrency of table checksumming")
 	_ = flags.MarkHidden(flagChecksumConcurrency)
 
 	flags.Uint64(flagRateLimit, 0, "The rate limit of the task, MB/s per node")
+	flags.Uint64(flagBandwidthBudget, 0,
+		"the aggregate rate limit of all the tasks with this flag on the cluster, MB/s per node, "+
+			"shared equally among the running tasks through PD, 0 means no budget")
 	flags.Bool(flagChecksum, true, "Run checksum at end of task")
 	flags.Bool(flagRemoveTiFlash, true,
 		"Remove TiFlash replicas before backup or restore, for unsupported versions of TiFlash")
@@ -154,6 +273,9 @@ func DefineCommonFlags(flags *pflag.FlagSet) {
 	// It may confuse users , so just hide it.
 	_ = flags.MarkHidden(flagConcurrency)
 
+	flags.String(flagProfile, "",
+		"the preset of concurrency, checksum concurrency and rate limit scaled by the TiKV stores, "+
+			"value can be one of 'small|medium|large|max', the flags set explicitly override it")
 	flags.Uint64(flagRateLimitUnit, utils.MB, "The unit of rate limit")
 	_ = flags.MarkHidden(flagRateLimitUnit)
 	_ = flags.MarkDeprecated(flagRemoveTiFlash,
@@ -168,19 +290,45 @@ func DefineCommonFlags(flags *pflag.FlagSet) {
 		"the max time a gRPC connection can keep idle before killed, must keep the same value with TiKV and PD")
 	_ = flags.MarkHidden(flagGrpcKeepaliveTime)
 	_ = flags.MarkHidden(flagGrpcKeepaliveTimeout)
+	flags.String(flagGrpcCompression, utils.GRPCCompressionNone,
+		"the compression algorithm of gRPC messages between BR and TiKV, useful across slow networks, "+
+			"value can be one of 'none|gzip'")
+	flags.String(flagGrpcMaxRecvMsgSize, "128MiB",
+		"the max size of the gRPC messages BR receives, e.g. the region batches of huge clusters")
+	flags.String(flagGrpcMaxSendMsgSize, "128MiB",
+		"the max size of the gRPC messages BR sends, the larger batch requests are sent in chunks")
+	flags.StringArray(flagStoreAddrMap, nil,
+		"map the store address reported by PD to the address reachable from BR, e.g. in NAT'd or Kubernetes "+
+			"environments, in the form of `from=to` or `~regexp=replacement`, can be repeated")
+	flags.String(flagTmpDir, "",
+		"the root of the temporary directory staging the data on the local disk, e.g. export stages the data files "+
+			"in it before uploading them if it's set, the directories left by the crashed tasks are removed")
+	flags.String(flagTmpDirQuota, "0",
+		"the max size of the data staged in the temporary directory, the task fails if it's exceeded, 0 means no limit")
 
 	storage.DefineFlags(flags)
+	encryption.DefineFlags(flags)
+}
+
+func defineComponentTLSFlags(flags *pflag.FlagSet, prefix string, component string) {
+	flags.String(prefix+flagCA, "", "CA certificate path for TLS connection to "+component)
+	flags.String(prefix+flagCert, "", "Certificate path for TLS connection to "+component)
+	flags.String(prefix+flagKey, "", "Private key path for TLS connection to "+component)
 }
 
 // DefineDatabaseFlags defines the required --db flag for `db` subcommand.
+// The flag can be repeated or comma-separated to specify multiple databases,
+// which are processed at the same snapshot.
 func DefineDatabaseFlags(command *cobra.Command) {
-	command.Flags().String(flagDatabase, "", "database name")
+	command.Flags().StringSlice(flagDatabase, nil,
+		"database name, can be repeated or comma-separated to specify multiple databases")
 	_ = command.MarkFlagRequired(flagDatabase)
 }
 
 // DefineTableFlags defines the required --db and --table flags for `table` subcommand.
 func DefineTableFlags(command *cobra.Command) {
-	DefineDatabaseFlags(command)
+	command.Flags().String(flagDatabase, "", "database name")
+	_ = command.MarkFlagRequired(flagDatabase)
 	command.Flags().StringP(flagTable, "t", "", "table name")
 	_ = command.MarkFlagRequired(flagTable)
 }
@@ -207,13 +355,44 @@ func (tls *TLSConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if err = tls.PD.parseFromFlags(flags, flagPDTLSPrefix); err != nil {
+		return errors.Trace(err)
+	}
+	if err = tls.TiKV.parseFromFlags(flags, flagTiKVTLSPrefix); err != nil {
+		return errors.Trace(err)
+	}
+	if err = tls.Storage.parseFromFlags(flags, flagStorageTLSPrefix); err != nil {
+		return errors.Trace(err)
+	}
+	if tls.Storage.CA == "" && (tls.Storage.Cert != "" || tls.Storage.Key != "") {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s%s must be provided with --%s%s and --%s%s",
+			flagStorageTLSPrefix, flagCA, flagStorageTLSPrefix, flagCert, flagStorageTLSPrefix, flagKey)
+	}
+	return nil
+}
+
+func (c *ComponentTLSConfig) parseFromFlags(flags *pflag.FlagSet, prefix string) error {
+	var err error
+	c.CA, err = flags.GetString(prefix + flagCA)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	c.Cert, err = flags.GetString(prefix + flagCert)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	c.Key, err = flags.GetString(prefix + flagKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	return nil
 }
 
 func (cfg *Config) normalizePDURLs() error {
 	for i := range cfg.PD {
 		var err error
-		cfg.PD[i], err = normalizePDURL(cfg.PD[i], cfg.TLS.IsEnabled())
+		cfg.PD[i], err = normalizePDURL(cfg.PD[i], cfg.TLS.ForPD().IsEnabled())
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -228,6 +407,10 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if storage, ok := os.LookupEnv(EnvStorage); ok && !flags.Changed(flagStorage) {
+		// The credentials in the URL aren't visible in the process list.
+		cfg.Storage = storage
+	}
 	cfg.SendCreds, err = flags.GetBool(flagSendCreds)
 	if err != nil {
 		return errors.Trace(err)
@@ -255,6 +438,14 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 		return errors.Trace(err)
 	}
 	cfg.RateLimit = rateLimit * rateLimitUnit
+	bandwidthBudget, err := flags.GetUint64(flagBandwidthBudget)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.BandwidthBudget = bandwidthBudget * rateLimitUnit
+	if err = cfg.parseProfileFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
 
 	var caseSensitive bool
 	if filterFlag := flags.Lookup(flagFilter); filterFlag != nil {
@@ -268,11 +459,20 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 			return errors.Trace(err)
 		}
 	} else if dbFlag := flags.Lookup(flagDatabase); dbFlag != nil {
-		db := dbFlag.Value.String()
-		if len(db) == 0 {
+		dbs := []string{dbFlag.Value.String()}
+		if sv, ok := dbFlag.Value.(pflag.SliceValue); ok {
+			dbs = sv.GetSlice()
+		}
+		if len(dbs) == 0 {
 			return errors.Annotate(berrors.ErrInvalidArgument, "empty database name is not allowed")
 		}
+		for _, db := range dbs {
+			if len(db) == 0 {
+				return errors.Annotate(berrors.ErrInvalidArgument, "empty database name is not allowed")
+			}
+		}
 		if tblFlag := flags.Lookup(flagTable); tblFlag != nil {
+			db := dbs[0]
 			tbl := tblFlag.Value.String()
 			if len(tbl) == 0 {
 				return errors.Annotate(berrors.ErrInvalidArgument, "empty table name is not allowed")
@@ -282,7 +482,7 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 				Name:   tbl,
 			})
 		} else {
-			cfg.TableFilter = filter.NewSchemasFilter(db)
+			cfg.TableFilter = filter.NewSchemasFilter(dbs...)
 		}
 	} else {
 		cfg.TableFilter, _ = filter.Parse([]string{"*.*"})
@@ -308,6 +508,32 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	grpcCompression, err := flags.GetString(flagGrpcCompression)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.GRPCCompression, err = utils.ParseGRPCCompression(grpcCompression)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.GRPCMaxRecvMsgSize, err = parseGRPCMsgSize(flags, flagGrpcMaxRecvMsgSize)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.GRPCMaxSendMsgSize, err = parseGRPCMsgSize(flags, flagGrpcMaxSendMsgSize)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.StoreAddrMap, err = flags.GetStringArray(flagStoreAddrMap)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = utils.ParseStoreAddrMap(cfg.StoreAddrMap); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.parseTmpDirFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
 
 	if cfg.SwitchModeInterval <= 0 {
 		return errors.Annotatef(berrors.ErrInvalidArgument, "--switch-mode-interval must be positive, %s is not allowed", cfg.SwitchModeInterval)
@@ -316,6 +542,9 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err = cfg.BackendOptions.ParseFromFlags(flags); err != nil {
 		return errors.Trace(err)
 	}
+	if err = cfg.Crypter.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
 	if err = cfg.TLS.ParseFromFlags(flags); err != nil {
 		return errors.Trace(err)
 	}
@@ -329,27 +558,94 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 	return cfg.normalizePDURLs()
 }
 
+// parseTmpDirFromFlags parses the temporary directory flags.
+func (cfg *Config) parseTmpDirFromFlags(flags *pflag.FlagSet) error {
+	if flags.Lookup(flagTmpDir) == nil {
+		return nil
+	}
+	var err error
+	cfg.TmpDir, err = flags.GetString(flagTmpDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	quota, err := flags.GetString(flagTmpDirQuota)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.TmpDirQuota, err = utils.ParseByteSize(quota)
+	if err != nil {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s: %v", flagTmpDirQuota, err)
+	}
+	return nil
+}
+
+// openTempDir creates the temporary directory of the task, the caller must
+// close it to remove the directory.
+func (cfg *Config) openTempDir(ctx context.Context) (*utils.TempDir, error) {
+	dir, err := utils.NewTempDir(ctx, cfg.TmpDir, cfg.TmpDirQuota)
+	return dir, errors.Trace(err)
+}
+
+// parseGRPCMsgSize parses the max size of the gRPC messages.
+func parseGRPCMsgSize(flags *pflag.FlagSet, name string) (uint64, error) {
+	s, err := flags.GetString(name)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	size, err := utils.ParseByteSize(s)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if size < utils.MB {
+		return 0, errors.Annotatef(berrors.ErrInvalidArgument, "--%s %s is less than 1MiB", name, s)
+	}
+	return size, nil
+}
+
+// pdSecurityOption returns the security option and the TLS config to connect
+// to PD.
+func pdSecurityOption(tlsConfig TLSConfig) (pd.SecurityOption, *tls.Config, error) {
+	securityOption := pd.SecurityOption{}
+	pdTLS := tlsConfig.ForPD()
+	if !pdTLS.IsEnabled() {
+		return securityOption, nil, nil
+	}
+	securityOption.CAPath = pdTLS.CA
+	securityOption.CertPath = pdTLS.Cert
+	securityOption.KeyPath = pdTLS.Key
+	pdTLSConf, err := pdTLS.ToTLSConfig()
+	if err != nil {
+		return securityOption, nil, errors.Trace(err)
+	}
+	return securityOption, pdTLSConf, nil
+}
+
+// GRPCMaxMsgSize returns the max size of the gRPC messages of all BR clients.
+func (cfg *Config) GRPCMaxMsgSize() utils.GRPCMaxMsgSize {
+	return utils.GRPCMaxMsgSize{Recv: cfg.GRPCMaxRecvMsgSize, Send: cfg.GRPCMaxSendMsgSize}
+}
+
 // NewMgr creates a new mgr at the given PD address.
 func NewMgr(ctx context.Context,
 	g glue.Glue, pds []string,
 	tlsConfig TLSConfig,
 	keepalive keepalive.ClientParameters,
+	maxMsgSize utils.GRPCMaxMsgSize,
 	checkRequirements bool) (*conn.Mgr, error) {
-	var (
-		tlsConf *tls.Config
-		err     error
-	)
+	var tikvTLSConf *tls.Config
 	pdAddress := strings.Join(pds, ",")
 	if len(pdAddress) == 0 {
 		return nil, errors.Annotate(berrors.ErrInvalidArgument, "pd address can not be empty")
 	}
 
-	securityOption := pd.SecurityOption{}
-	if tlsConfig.IsEnabled() {
-		securityOption.CAPath = tlsConfig.CA
-		securityOption.CertPath = tlsConfig.Cert
-		securityOption.KeyPath = tlsConfig.Key
-		tlsConf, err = tlsConfig.ToTLSConfig()
+	// The TiDB storage connects to both PD and TiKV with the security option,
+	// so the TiKV materials only apply to the connections opened by BR itself.
+	securityOption, pdTLSConf, err := pdSecurityOption(tlsConfig)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if tikvTLS := tlsConfig.ForTiKV(); tikvTLS.IsEnabled() {
+		tikvTLSConf, err = tikvTLS.ToTLSConfig()
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
@@ -363,10 +659,82 @@ func NewMgr(ctx context.Context,
 	// Is it necessary to remove `StoreBehavior`?
 	return conn.NewMgr(ctx, g,
 		pdAddress, store.(tikv.Storage),
-		tlsConf, securityOption, keepalive,
+		pdTLSConf, tikvTLSConf, securityOption, keepalive, maxMsgSize,
 		conn.SkipTiFlash, checkRequirements)
 }
 
+// StorageOptions returns the options to create the external storage.
+func (cfg *Config) StorageOptions() (*storage.ExternalStorageOptions, error) {
+	opts := &storage.ExternalStorageOptions{
+		SendCredentials: cfg.SendCreds,
+		Tags:            cfg.BackendOptions.Tags,
+	}
+	if storageTLS := cfg.TLS.ForStorage(); storageTLS.IsEnabled() {
+		tlsConf, err := storageTLS.ToTLSConfig()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConf
+		opts.HTTPClient = &http.Client{Transport: transport}
+	}
+	return opts, nil
+}
+
+// newEtcdClient creates a client of the etcd embedded in PD.
+func newEtcdClient(cfg *Config) (*clientv3.Client, error) {
+	var tlsConf *tls.Config
+	if pdTLS := cfg.TLS.ForPD(); pdTLS.IsEnabled() {
+		var err error
+		tlsConf, err = pdTLS.ToTLSConfig()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:            cfg.PD,
+		TLS:                  tlsConf,
+		DialTimeout:          etcdDialTimeout,
+		DialKeepAliveTime:    cfg.GRPCKeepaliveTime,
+		DialKeepAliveTimeout: cfg.GRPCKeepaliveTimeout,
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cli, nil
+}
+
+// joinBandwidthBudget leases a share of the bandwidth budget of the cluster,
+// a nil budget means no budget. The release function must be called on exit.
+func joinBandwidthBudget(ctx context.Context, cfg *Config) (*utils.BandwidthBudget, func(), error) {
+	if cfg.BandwidthBudget == 0 {
+		return nil, func() {}, nil
+	}
+	cli, err := newEtcdClient(cfg)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	budget, err := utils.JoinBandwidthBudget(ctx, cli, cfg.BandwidthBudget)
+	if err != nil {
+		_ = cli.Close()
+		return nil, nil, errors.Annotate(err, "failed to join the bandwidth budget")
+	}
+	return budget, func() {
+		if err := budget.Leave(context.Background()); err != nil {
+			log.Warn("failed to leave the bandwidth budget, the share will expire soon", zap.Error(err))
+		}
+		_ = cli.Close()
+	}, nil
+}
+
+// exposeLiveTuning exposes the rate limit and the concurrency of the task
+// through the status server, so they can be changed while the task runs. The
+// returned function withdraws them, and must be called on exit.
+func exposeLiveTuning(cfg *Config) (*utils.LiveTuning, func()) {
+	tuning := utils.NewLiveTuning(cfg.RateLimit, cfg.Concurrency)
+	return tuning, utils.SetLiveTuning(tuning)
+}
+
 // GetStorage gets the storage backend from the config.
 func GetStorage(
 	ctx context.Context,
@@ -376,7 +744,11 @@ func GetStorage(
 	if err != nil {
 		return nil, nil, errors.Trace(err)
 	}
-	s, err := storage.Create(ctx, u, cfg.SendCreds)
+	opts, err := cfg.StorageOptions()
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	s, err := storage.New(ctx, u, opts)
 	if err != nil {
 		return nil, nil, errors.Annotate(err, "create storage failed")
 	}
@@ -393,7 +765,17 @@ func ReadBackupMeta(
 	if err != nil {
 		return nil, nil, nil, errors.Trace(err)
 	}
-	metaData, err := s.Read(ctx, fileName)
+	dataKey, err := readDataKey(ctx, cfg, s)
+	if err != nil {
+		return nil, nil, nil, errors.Trace(err)
+	}
+	metaStorage := s
+	if dataKey != nil {
+		if metaStorage, err = dataKey.Storage(s); err != nil {
+			return nil, nil, nil, errors.Trace(err)
+		}
+	}
+	metaData, err := utils.ReadMetaFile(ctx, metaStorage, fileName)
 	if err != nil {
 		if gcsObjectNotFound(err) {
 			// change gcs://bucket/abc/def to gcs://bucket/abc and read defbackupmeta
@@ -401,12 +783,24 @@ func ReadBackupMeta(
 			newPrefix, file := path.Split(oldPrefix)
 			newFileName := file + fileName
 			u.GetGcs().Prefix = newPrefix
-			s, err = storage.Create(ctx, u, cfg.SendCreds)
+			opts, err := cfg.StorageOptions()
+			if err != nil {
+				return nil, nil, nil, errors.Trace(err)
+			}
+			s, err = storage.New(ctx, u, opts)
 			if err != nil {
 				return nil, nil, nil, errors.Trace(err)
 			}
+			// The files of the backup are encrypted by the data key read
+			// from the original prefix.
+			metaStorage = s
+			if dataKey != nil {
+				if metaStorage, err = dataKey.Storage(s); err != nil {
+					return nil, nil, nil, errors.Trace(err)
+				}
+			}
 			log.Info("retry load metadata in gcs", zap.String("newPrefix", newPrefix), zap.String("newFileName", newFileName))
-			metaData, err = s.Read(ctx, newFileName)
+			metaData, err = utils.ReadMetaFile(ctx, metaStorage, newFileName)
 			if err != nil {
 				return nil, nil, nil, errors.Trace(err)
 			}
@@ -420,6 +814,14 @@ func ReadBackupMeta(
 	if err = proto.Unmarshal(metaData, backupMeta); err != nil {
 		return nil, nil, nil, errors.Annotate(err, "parse backupmeta failed")
 	}
+	// Only the schemas of the tables matched by the filter are loaded.
+	var match func(db, table string) bool
+	if cfg.TableFilter != nil {
+		match = cfg.TableFilter.MatchTable
+	}
+	if err = utils.LoadExternalSchemasBy(ctx, schemaStorageOf(dataKey, s), backupMeta, match); err != nil {
+		return nil, nil, nil, errors.Annotate(err, "load external schemas failed")
+	}
 	return u, s, backupMeta, nil
 }
 
@@ -435,6 +837,9 @@ func flagToZapField(f *pflag.Flag) zap.Field {
 		hiddenQuery.RawQuery = ""
 		return zap.Stringer(f.Name, hiddenQuery)
 	}
+	if f.Name == flagSQLDSN {
+		return zap.String(f.Name, redactDSN(f.Value.String()))
+	}
 	return zap.Stringer(f.Name, f.Value)
 }
 
@@ -449,6 +854,25 @@ func LogArguments(cmd *cobra.Command) {
 	log.Info("arguments", fields...)
 }
 
+// changedFlagArgs returns the arguments setting the changed flags, except the
+// omitted ones, so the command can be run again by another BR.
+func changedFlagArgs(flags *pflag.FlagSet, omitted map[string]struct{}) []string {
+	args := make([]string, 0, flags.NFlag())
+	flags.Visit(func(flag *pflag.Flag) {
+		if _, ok := omitted[flag.Name]; ok {
+			return
+		}
+		values := []string{flag.Value.String()}
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			values = slice.GetSlice()
+		}
+		for _, value := range values {
+			args = append(args, fmt.Sprintf("--%s=%s", flag.Name, value))
+		}
+	})
+	return args
+}
+
 // GetKeepalive get the keepalive info from the config.
 func GetKeepalive(cfg *Config) keepalive.ClientParameters {
 	return keepalive.ClientParameters{
@@ -457,6 +881,21 @@ func GetKeepalive(cfg *Config) keepalive.ClientParameters {
 	}
 }
 
+// collectGRPCCompression reports the effect of the gRPC compression of the task
+// to the summary.
+func collectGRPCCompression(cfg *Config, wireStats *utils.GRPCWireStats) {
+	if cfg.GRPCCompression == "" || cfg.GRPCCompression == utils.GRPCCompressionNone {
+		return
+	}
+	payloadBytes, wireBytes := wireStats.Bytes()
+	summary.CollectUInt("grpc payload bytes", payloadBytes)
+	summary.CollectUInt("grpc wire bytes", wireBytes)
+	log.Info("gRPC compression",
+		zap.String("algorithm", cfg.GRPCCompression),
+		zap.Uint64("payload", payloadBytes),
+		zap.Uint64("wire", wireBytes))
+}
+
 // adjust adjusts the abnormal config value in the current config.
 // useful when not starting BR from CLI (e.g. from BRIE in SQL).
 func (cfg *Config) adjust() {
@@ -469,6 +908,12 @@ func (cfg *Config) adjust() {
 	if cfg.ChecksumConcurrency == 0 {
 		cfg.ChecksumConcurrency = variable.DefChecksumTableConcurrency
 	}
+	// The rules are validated when parsed from the flags.
+	addrMap, err := utils.ParseStoreAddrMap(cfg.StoreAddrMap)
+	if err != nil {
+		log.Warn("invalid store address map is ignored", zap.Strings("rules", cfg.StoreAddrMap), zap.Error(err))
+	}
+	utils.SetStoreAddrMap(addrMap)
 }
 
 func normalizePDURL(pd string, useTLS bool) (string, error) {
@@ -8,7 +8,10 @@ import (
 	"github.com/pingcap/tidb/config"
 
 	. "github.com/pingcap/check"
+	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+
+	"github.com/pingcap/br/pkg/utils"
 )
 
 var _ = Suite(&testCommonSuite{})
@@ -63,3 +66,76 @@ func (s *testCommonSuite) TestStripingPDURL(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(noChange, Equals, "127.0.0.1:2379")
 }
+
+func (*testCommonSuite) TestComponentTLSConfig(c *C) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	DefineCommonFlags(flags)
+	c.Assert(flags.Parse([]string{
+		"--ca", "ca.pem", "--cert", "cert.pem", "--key", "key.pem",
+		"--tikv.ca", "tikv-ca.pem",
+		"--storage.ca", "s3-ca.pem",
+	}), IsNil)
+	var tls TLSConfig
+	c.Assert(tls.ParseFromFlags(flags), IsNil)
+
+	c.Assert(tls.ForPD(), DeepEquals, TLSConfig{CA: "ca.pem", Cert: "cert.pem", Key: "key.pem"})
+	c.Assert(tls.ForTiKV(), DeepEquals, TLSConfig{CA: "tikv-ca.pem", Cert: "cert.pem", Key: "key.pem"})
+	c.Assert(tls.ForStorage(), DeepEquals, TLSConfig{CA: "s3-ca.pem"})
+
+	c.Assert(flags.Set("storage.ca", ""), IsNil)
+	c.Assert(flags.Set("storage.cert", "s3-cert.pem"), IsNil)
+	c.Assert(tls.ParseFromFlags(flags), ErrorMatches, ".*--storage.ca must be provided.*")
+}
+
+func (*testCommonSuite) TestMultipleDatabases(c *C) {
+	command := &cobra.Command{}
+	DefineCommonFlags(command.Flags())
+	DefineDatabaseFlags(command)
+	c.Assert(command.Flags().Parse([]string{"--db", "db1,db2", "--db", "db3"}), IsNil)
+	var cfg Config
+	c.Assert(cfg.ParseFromFlags(command.Flags()), IsNil)
+	for _, db := range []string{"db1", "db2", "db3"} {
+		c.Assert(cfg.TableFilter.MatchTable(db, "t"), IsTrue)
+	}
+	c.Assert(cfg.TableFilter.MatchTable("db4", "t"), IsFalse)
+
+	command = &cobra.Command{}
+	DefineCommonFlags(command.Flags())
+	DefineDatabaseFlags(command)
+	c.Assert(command.Flags().Parse([]string{"--db", "db1,"}), IsNil)
+	c.Assert(cfg.ParseFromFlags(command.Flags()), ErrorMatches, ".*empty database name.*")
+}
+
+func (*testCommonSuite) TestProfile(c *C) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	DefineCommonFlags(flags)
+	c.Assert(flags.Parse([]string{"--profile", "medium", "--ratelimit", "10"}), IsNil)
+	var cfg Config
+	c.Assert(cfg.parseProfileFromFlags(flags), IsNil)
+	cfg.RateLimit = 10 * utils.MB
+	cfg.Concurrency = defaultRestoreConcurrency
+
+	cfg.applyProfile(3, 0, true)
+	c.Assert(cfg.Concurrency, Equals, uint32(48))
+	c.Assert(cfg.ChecksumConcurrency, Equals, uint(4))
+	// The explicit flags override the profile.
+	c.Assert(cfg.RateLimit, Equals, 10*utils.MB)
+	cfg.applyProfile(1, 0, true)
+	c.Assert(cfg.Concurrency, Equals, uint32(minProfileRestoreConcurrency))
+	cfg.applyProfile(3, 0, false)
+	c.Assert(cfg.Concurrency, Equals, uint32(4))
+
+	// The profile scales by the capacity of the stores, within the bounds.
+	cfg.applyProfile(3, 2<<40, true)
+	c.Assert(cfg.Concurrency, Equals, uint32(96))
+	cfg.applyProfile(3, 16<<40, false)
+	c.Assert(cfg.Concurrency, Equals, uint32(8))
+	cfg.applyProfile(3, 1<<30, false)
+	c.Assert(cfg.Concurrency, Equals, uint32(2))
+	cfg.explicitFlags[flagRateLimit] = false
+	cfg.applyProfile(3, 1<<30, false)
+	c.Assert(cfg.RateLimit, Equals, 64*utils.MB)
+
+	c.Assert(flags.Set(flagProfile, "huge"), IsNil)
+	c.Assert(cfg.parseProfileFromFlags(flags), ErrorMatches, ".*must be one of small\\|medium\\|large\\|max.*")
+}
@@ -0,0 +1,400 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	flagCronJobName      = "name"
+	flagCronJobNamespace = "namespace"
+	flagCronJobImage     = "image"
+	flagCronJobBRPath    = "br-path"
+	flagCronJobSecret    = "secret"
+	// flagCronJobTLSSecret and flagCronJobCrypterSecret are the secrets of the
+	// TLS files and the master key file.
+	flagCronJobTLSSecret     = "tls-secret"
+	flagCronJobCrypterSecret = "crypter-secret"
+	// flagGCSCredentialsFile is the flag of the GCS credentials, which is
+	// replaced by the secret in the CronJob.
+	flagGCSCredentialsFile = "gcs.credentials-file"
+	// flagCrypterKeyFile and flagCrypterKeyMapping are the files of the
+	// client-side encryption.
+	flagCrypterKeyFile    = "crypter.key-file"
+	flagCrypterKeyMapping = "crypter.key-mapping"
+
+	// cronJobSecretsDir is where the secrets are mounted in the container,
+	// each in its own sub-directory.
+	cronJobSecretsDir = "/var/run/secrets/br"
+	// cronJobCredentialsDir is where the secret of the GCS credentials is
+	// mounted in the container.
+	cronJobCredentialsDir = cronJobSecretsDir + "/storage"
+	// the keys of the storage credentials in the secret.
+	secretKeyAccessKey       = "access-key"
+	secretKeySecretAccessKey = "secret-access-key"
+	secretKeyCredentialsFile = "credentials-file"
+)
+
+// the flags which aren't passed to the BR in the CronJob as is.
+var cronJobOmittedFlags = map[string]struct{}{
+	flagCron:               {},
+	flagStorage:            {},
+	flagGCSCredentialsFile: {},
+}
+
+// the local files of the TLS and the client-side encryption, which are
+// mounted from the secrets in the CronJob. The key of a file in its secret is
+// the name of its flag.
+var (
+	cronJobTLSFiles = []string{
+		flagCA, flagCert, flagKey,
+		flagStorageTLSPrefix + flagCA, flagStorageTLSPrefix + flagCert, flagStorageTLSPrefix + flagKey,
+	}
+	cronJobCrypterFiles = []string{flagCrypterKeyFile}
+)
+
+// CronJobConfig is the configuration of rendering a backup task into a
+// Kubernetes CronJob.
+type CronJobConfig struct {
+	Name      string `json:"name" toml:"name"`
+	Namespace string `json:"namespace" toml:"namespace"`
+	Image     string `json:"image" toml:"image"`
+	BRPath    string `json:"br-path" toml:"br-path"`
+	// Secret is the name of the Kubernetes secret holding the storage
+	// credentials, the credentials are never rendered into the manifest.
+	Secret string `json:"secret" toml:"secret"`
+	// TLSSecret and CrypterSecret are the names of the secrets holding the
+	// TLS files and the master key file, keyed by the names of their flags.
+	TLSSecret     string `json:"tls-secret" toml:"tls-secret"`
+	CrypterSecret string `json:"crypter-secret" toml:"crypter-secret"`
+}
+
+// DefineCronJobFlags defines the flags of rendering a CronJob.
+func DefineCronJobFlags(flags *pflag.FlagSet) {
+	flags.String(flagCronJobName, "br-backup", "the name of the CronJob")
+	flags.String(flagCronJobNamespace, "", "the namespace of the CronJob")
+	flags.String(flagCronJobImage, defaultCronJobImage(), "the image of BR run by the CronJob")
+	flags.String(flagCronJobBRPath, "/br", "the path of BR in the image")
+	flags.String(flagCronJobSecret, "",
+		"the name of the secret holding the storage credentials, with the keys "+
+			"access-key and secret-access-key for S3, or credentials-file for GCS")
+	flags.String(flagCronJobTLSSecret, "",
+		"the name of the secret holding the TLS files, keyed by the names of their flags, e.g. ca, cert and key")
+	flags.String(flagCronJobCrypterSecret, "",
+		"the name of the secret holding the master key file, with the key crypter.key-file")
+}
+
+func defaultCronJobImage() string {
+	if utils.BRReleaseVersion == "None" {
+		return "pingcap/br:latest"
+	}
+	return "pingcap/br:" + utils.BRReleaseVersion
+}
+
+// ParseFromFlags parses the config from the flag set.
+func (cfg *CronJobConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.Name, err = flags.GetString(flagCronJobName); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Namespace, err = flags.GetString(flagCronJobNamespace); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Image, err = flags.GetString(flagCronJobImage); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.BRPath, err = flags.GetString(flagCronJobBRPath); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Secret, err = flags.GetString(flagCronJobSecret); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.TLSSecret, err = flags.GetString(flagCronJobTLSSecret); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.CrypterSecret, err = flags.GetString(flagCronJobCrypterSecret); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Name == "" || cfg.Image == "" || cfg.BRPath == "" {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s, --%s and --%s must not be empty", flagCronJobName, flagCronJobImage, flagCronJobBRPath)
+	}
+	return nil
+}
+
+type cronJob struct {
+	APIVersion string        `yaml:"apiVersion"`
+	Kind       string        `yaml:"kind"`
+	Metadata   k8sObjectMeta `yaml:"metadata"`
+	Spec       cronJobSpec   `yaml:"spec"`
+}
+
+type k8sObjectMeta struct {
+	Name      string            `yaml:"name,omitempty"`
+	Namespace string            `yaml:"namespace,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+}
+
+type cronJobSpec struct {
+	Schedule          string `yaml:"schedule"`
+	ConcurrencyPolicy string `yaml:"concurrencyPolicy"`
+	JobTemplate       struct {
+		Spec struct {
+			BackoffLimit int `yaml:"backoffLimit"`
+			Template     struct {
+				Metadata k8sObjectMeta `yaml:"metadata"`
+				Spec     k8sPodSpec    `yaml:"spec"`
+			} `yaml:"template"`
+		} `yaml:"spec"`
+	} `yaml:"jobTemplate"`
+}
+
+type k8sPodSpec struct {
+	RestartPolicy string         `yaml:"restartPolicy"`
+	Containers    []k8sContainer `yaml:"containers"`
+	Volumes       []k8sVolume    `yaml:"volumes,omitempty"`
+}
+
+type k8sContainer struct {
+	Name         string           `yaml:"name"`
+	Image        string           `yaml:"image"`
+	Command      []string         `yaml:"command"`
+	Env          []k8sEnvVar      `yaml:"env,omitempty"`
+	VolumeMounts []k8sVolumeMount `yaml:"volumeMounts,omitempty"`
+}
+
+type k8sEnvVar struct {
+	Name      string           `yaml:"name"`
+	Value     string           `yaml:"value,omitempty"`
+	ValueFrom *k8sEnvVarSource `yaml:"valueFrom,omitempty"`
+}
+
+type k8sEnvVarSource struct {
+	SecretKeyRef k8sSecretKeyRef `yaml:"secretKeyRef"`
+}
+
+type k8sSecretKeyRef struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
+
+type k8sVolume struct {
+	Name   string `yaml:"name"`
+	Secret struct {
+		SecretName string `yaml:"secretName"`
+	} `yaml:"secret"`
+}
+
+type k8sVolumeMount struct {
+	Name      string `yaml:"name"`
+	MountPath string `yaml:"mountPath"`
+	ReadOnly  bool   `yaml:"readOnly"`
+}
+
+func secretEnv(name, secret, key string) k8sEnvVar {
+	return k8sEnvVar{
+		Name:      name,
+		ValueFrom: &k8sEnvVarSource{SecretKeyRef: k8sSecretKeyRef{Name: secret, Key: key}},
+	}
+}
+
+// mountSecret mounts the secret as a read-only volume at the dir.
+func mountSecret(pod *k8sPodSpec, container *k8sContainer, volumeName, secret, dir string) {
+	volume := k8sVolume{Name: volumeName}
+	volume.Secret.SecretName = secret
+	pod.Volumes = append(pod.Volumes, volume)
+	container.VolumeMounts = append(container.VolumeMounts, k8sVolumeMount{
+		Name: volumeName, MountPath: dir, ReadOnly: true,
+	})
+}
+
+// mountSecretFiles replaces the local files set by the flags with the files
+// mounted from the secret, and returns the flags of the mounted files.
+func mountSecretFiles(
+	pod *k8sPodSpec,
+	container *k8sContainer,
+	flags *pflag.FlagSet,
+	files []string,
+	volumeName, secret, secretFlag string,
+) ([]string, error) {
+	dir := cronJobSecretsDir + "/" + volumeName
+	args := make([]string, 0, len(files))
+	for _, name := range files {
+		flag := flags.Lookup(name)
+		if flag == nil || flag.Value.String() == "" {
+			continue
+		}
+		if secret == "" {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s is a local file, please create a secret with the file as the key %s and set --%s",
+				name, name, secretFlag)
+		}
+		args = append(args, fmt.Sprintf("--%s=%s/%s", name, dir, name))
+	}
+	if len(args) > 0 {
+		mountSecret(pod, container, volumeName, secret, dir)
+	}
+	return args, nil
+}
+
+// cronJobSchedule converts the schedule of the cron mode, which has the
+// seconds field, into the schedule of Kubernetes, which is by minutes.
+func cronJobSchedule(spec string) (string, error) {
+	parser := cron.NewParser(cron.Second | cron.Minute | cron.Hour |
+		cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+	if _, err := parser.Parse(spec); err != nil {
+		return "", errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s %q: %s", flagCron, spec, err)
+	}
+	if strings.HasPrefix(spec, "@") {
+		if strings.HasPrefix(spec, "@every") {
+			return "", errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s %q isn't supported by Kubernetes", flagCron, spec)
+		}
+		return spec, nil
+	}
+	fields := strings.Fields(spec)
+	if fields[0] != "0" {
+		return "", errors.Annotatef(berrors.ErrInvalidArgument,
+			"Kubernetes schedules by minutes, the seconds of --%s %q must be 0", flagCron, spec)
+	}
+	return strings.Join(fields[1:], " "), nil
+}
+
+// shellQuote quotes the string for the POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// RenderCronJob renders the backup task defined by the command and the flags
+// into the manifest of a Kubernetes CronJob. Like the cron mode, every run
+// backs up into a sub-directory of the storage named by its start time. The
+// storage credentials must be referenced from a secret.
+func RenderCronJob(cfg *CronJobConfig, command []string, flags *pflag.FlagSet) ([]byte, error) {
+	spec, err := flags.GetString(flagCron)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if spec == "" {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "please set --%s as the schedule", flagCron)
+	}
+	schedule, err := cronJobSchedule(spec)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	rawStorage, err := flags.GetString(flagStorage)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	u, err := url.Parse(rawStorage)
+	if err != nil || (u.Scheme != "s3" && u.Scheme != "gcs" && u.Scheme != "gs") {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the storage of a CronJob must be S3 or GCS, got %q", rawStorage)
+	}
+
+	if flag := flags.Lookup(flagCrypterKeyMapping); flag != nil && flag.Value.String() != "" {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s isn't supported by the CronJob", flagCrypterKeyMapping)
+	}
+
+	container := k8sContainer{Name: "br", Image: cfg.Image}
+	pod := k8sPodSpec{RestartPolicy: "Never"}
+	// Move the credentials out of the storage URL into the secret.
+	query := u.Query()
+	hasCredentials := false
+	for key := range query {
+		switch strings.ToLower(strings.ReplaceAll(key, "_", "-")) {
+		case secretKeyAccessKey, secretKeySecretAccessKey:
+			query.Del(key)
+			hasCredentials = true
+		}
+	}
+	if flag := flags.Lookup(flagGCSCredentialsFile); flag != nil && flag.Changed {
+		hasCredentials = true
+	}
+	if hasCredentials && cfg.Secret == "" {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the storage credentials must be referenced from a secret, please create it and set --%s", flagCronJobSecret)
+	}
+	if cfg.Secret != "" {
+		if u.Scheme == "s3" {
+			container.Env = append(container.Env,
+				secretEnv("AWS_ACCESS_KEY_ID", cfg.Secret, secretKeyAccessKey),
+				secretEnv("AWS_SECRET_ACCESS_KEY", cfg.Secret, secretKeySecretAccessKey))
+		} else {
+			mountSecret(&pod, &container, "storage", cfg.Secret, cronJobCredentialsDir)
+			container.Env = append(container.Env, k8sEnvVar{
+				Name:  "GOOGLE_APPLICATION_CREDENTIALS",
+				Value: cronJobCredentialsDir + "/" + secretKeyCredentialsFile,
+			})
+		}
+	}
+
+	tlsArgs, err := mountSecretFiles(&pod, &container, flags, cronJobTLSFiles,
+		"tls", cfg.TLSSecret, flagCronJobTLSSecret)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	crypterArgs, err := mountSecretFiles(&pod, &container, flags, cronJobCrypterFiles,
+		"crypter", cfg.CrypterSecret, flagCronJobCrypterSecret)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	omitted := make(map[string]struct{}, len(cronJobOmittedFlags)+len(cronJobTLSFiles)+len(cronJobCrypterFiles))
+	for name := range cronJobOmittedFlags {
+		omitted[name] = struct{}{}
+	}
+	// The local files are replaced by the mounted ones.
+	for _, name := range cronJobTLSFiles {
+		omitted[name] = struct{}{}
+	}
+	for _, name := range cronJobCrypterFiles {
+		omitted[name] = struct{}{}
+	}
+
+	args := make([]string, 0, len(command)+8)
+	args = append(args, shellQuote(cfg.BRPath))
+	for _, c := range command {
+		args = append(args, shellQuote(c))
+	}
+	for _, arg := range changedFlagArgs(flags, omitted) {
+		args = append(args, shellQuote(arg))
+	}
+	for _, arg := range append(tlsArgs, crypterArgs...) {
+		args = append(args, shellQuote(arg))
+	}
+	u.RawQuery = ""
+	storage := shellQuote("--"+flagStorage+"="+strings.TrimSuffix(u.String(), "/")+"/") +
+		`"$(date -u +%Y%m%d%H%M%S)"`
+	if encoded := query.Encode(); encoded != "" {
+		storage += shellQuote("?" + encoded)
+	}
+	args = append(args, storage)
+	container.Command = []string{"/bin/sh", "-c", "exec " + strings.Join(args, " ")}
+
+	labels := map[string]string{"app.kubernetes.io/name": "br", "app.kubernetes.io/instance": cfg.Name}
+	job := cronJob{
+		APIVersion: "batch/v1",
+		Kind:       "CronJob",
+		Metadata:   k8sObjectMeta{Name: cfg.Name, Namespace: cfg.Namespace, Labels: labels},
+	}
+	job.Spec.Schedule = schedule
+	// A backup is never retried or overlapped, the next run backs up again.
+	job.Spec.ConcurrencyPolicy = "Forbid"
+	jobSpec := &job.Spec.JobTemplate.Spec
+	jobSpec.Template.Metadata.Labels = labels
+	pod.Containers = []k8sContainer{container}
+	jobSpec.Template.Spec = pod
+	data, err := yaml.Marshal(&job)
+	return data, errors.Trace(err)
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+var _ = Suite(&testCronJobSuite{})
+
+type testCronJobSuite struct{}
+
+func (*testCronJobSuite) TestCronJobSchedule(c *C) {
+	for spec, expected := range map[string]string{
+		"0 30 2 * * *": "30 2 * * *",
+		"@daily":       "@daily",
+	} {
+		schedule, err := cronJobSchedule(spec)
+		c.Assert(err, IsNil)
+		c.Assert(schedule, Equals, expected)
+	}
+	for _, spec := range []string{"30 2 * * *", "15 30 2 * * *", "@every 1h"} {
+		_, err := cronJobSchedule(spec)
+		c.Assert(err, NotNil, Commentf("%s", spec))
+	}
+}
+
+func (*testCronJobSuite) TestRenderCronJob(c *C) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	DefineCommonFlags(flags)
+	DefineBackupFlags(flags)
+	flags.StringArrayP(flagFilter, "f", nil, "")
+	c.Assert(flags.Parse([]string{
+		"--pd", "pd:2379",
+		"-s", "s3://bucket/prefix?endpoint=http://minio:9000&access-key=ak&secret-access-key=sk",
+		"--cron", "0 0 2 * * *",
+		"-f", "db.*", "-f", "it's.*",
+	}), IsNil)
+
+	cfg := &CronJobConfig{Name: "daily", Image: "pingcap/br:v4.0.9", BRPath: "/br"}
+	_, err := RenderCronJob(cfg, []string{"backup", "full"}, flags)
+	c.Assert(err, ErrorMatches, ".*secret.*")
+
+	cfg.Secret = "backup-s3"
+	data, err := RenderCronJob(cfg, []string{"backup", "full"}, flags)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Not(Matches), "(?s).*(=ak|=sk).*")
+	job := cronJob{}
+	c.Assert(yaml.UnmarshalStrict(data, &job), IsNil)
+	c.Assert(job.Spec.Schedule, Equals, "0 2 * * *")
+	pod := job.Spec.JobTemplate.Spec.Template.Spec
+	c.Assert(pod.Containers, HasLen, 1)
+	container := pod.Containers[0]
+	c.Assert(container.Command, DeepEquals, []string{"/bin/sh", "-c", "exec '/br' 'backup' 'full' " +
+		`'--filter=db.*' '--filter=it'"'"'s.*' '--pd=pd:2379' ` +
+		`'--storage=s3://bucket/prefix/'"$(date -u +%Y%m%d%H%M%S)"'?endpoint=http%3A%2F%2Fminio%3A9000'`})
+	c.Assert(container.Env, HasLen, 2)
+	c.Assert(container.Env[1].ValueFrom.SecretKeyRef, Equals, k8sSecretKeyRef{Name: "backup-s3", Key: "secret-access-key"})
+	c.Assert(job.APIVersion, Equals, "batch/v1")
+	c.Assert(pod.Volumes, HasLen, 0)
+}
+
+func (*testCronJobSuite) TestRenderCronJobSecretFiles(c *C) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	DefineCommonFlags(flags)
+	DefineBackupFlags(flags)
+	c.Assert(flags.Parse([]string{
+		"--pd", "pd:2379",
+		"-s", "s3://bucket/prefix",
+		"--cron", "@daily",
+		"--ca", "/home/me/ca.pem", "--cert", "/home/me/br.pem", "--key", "/home/me/br-key.pem",
+		"--crypter.key-file", "/home/me/master.key",
+	}), IsNil)
+
+	cfg := &CronJobConfig{Name: "daily", Image: "pingcap/br:v4.0.9", BRPath: "/br"}
+	_, err := RenderCronJob(cfg, []string{"backup", "full"}, flags)
+	c.Assert(err, ErrorMatches, ".*--ca is a local file.*--tls-secret.*")
+	cfg.TLSSecret = "br-tls"
+	_, err = RenderCronJob(cfg, []string{"backup", "full"}, flags)
+	c.Assert(err, ErrorMatches, ".*--crypter.key-file is a local file.*--crypter-secret.*")
+
+	cfg.CrypterSecret = "br-crypter"
+	data, err := RenderCronJob(cfg, []string{"backup", "full"}, flags)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Not(Matches), "(?s).*/home/me.*")
+	job := cronJob{}
+	c.Assert(yaml.UnmarshalStrict(data, &job), IsNil)
+	pod := job.Spec.JobTemplate.Spec.Template.Spec
+	c.Assert(pod.Volumes, HasLen, 2)
+	c.Assert(pod.Volumes[0].Name, Equals, "tls")
+	c.Assert(pod.Volumes[0].Secret.SecretName, Equals, "br-tls")
+	c.Assert(pod.Volumes[1].Name, Equals, "crypter")
+	c.Assert(pod.Volumes[1].Secret.SecretName, Equals, "br-crypter")
+	container := pod.Containers[0]
+	c.Assert(container.VolumeMounts, DeepEquals, []k8sVolumeMount{
+		{Name: "tls", MountPath: "/var/run/secrets/br/tls", ReadOnly: true},
+		{Name: "crypter", MountPath: "/var/run/secrets/br/crypter", ReadOnly: true},
+	})
+	c.Assert(container.Command[2], Matches, ".*"+
+		"'--ca=/var/run/secrets/br/tls/ca' '--cert=/var/run/secrets/br/tls/cert' '--key=/var/run/secrets/br/tls/key' "+
+		"'--crypter.key-file=/var/run/secrets/br/crypter/crypter.key-file'.*")
+}
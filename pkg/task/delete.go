@@ -0,0 +1,469 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/store/tikv/oracle"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/pingcap/br/pkg/backup"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	flagBackupName = "backup"
+	flagOlderThan  = "older-than"
+
+	deleteConcurrency = 16
+)
+
+// DeleteConfig is the configuration specific for delete tasks.
+type DeleteConfig struct {
+	Config
+
+	// Backup is the path of the deleted backup relative to the storage.
+	Backup string `json:"backup" toml:"backup"`
+	// OlderThan deletes the backups whose backup TS is older than it instead
+	// of the backup, zero means only the backup is deleted.
+	OlderThan time.Duration `json:"older-than" toml:"older-than"`
+}
+
+// DefineDeleteFlags defines the flags of the delete command.
+func DefineDeleteFlags(flags *pflag.FlagSet) {
+	flags.String(flagBackupName, "", "the path of the backup to delete, relative to --storage")
+	flags.Duration(flagOlderThan, 0,
+		"delete all the backups in the storage whose backup TS is older than it instead of --backup, e.g. 720h, "+
+			"the backups which the retained incremental backups are based on are kept")
+}
+
+// ParseFromFlags parses the delete-related flags from the flag set.
+func (cfg *DeleteConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	if err := cfg.Config.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	name, err := flags.GetString(flagBackupName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if flags.Lookup(flagOlderThan) != nil {
+		cfg.OlderThan, err = flags.GetDuration(flagOlderThan)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if cfg.OlderThan < 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must not be negative", flagOlderThan)
+		}
+	}
+	if cfg.OlderThan > 0 {
+		if name != "" {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"only one of --%s and --%s can be set", flagBackupName, flagOlderThan)
+		}
+		return nil
+	}
+	cfg.Backup, err = cleanBackupName(name)
+	return errors.Trace(err)
+}
+
+// cleanBackupName normalizes the path of a backup relative to the storage.
+func cleanBackupName(name string) (string, error) {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return "", errors.Annotatef(berrors.ErrInvalidArgument, "--%s is required", flagBackupName)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "." || part == ".." {
+			return "", errors.Annotatef(berrors.ErrInvalidArgument, "invalid backup path %s", name)
+		}
+	}
+	return path.Clean(name), nil
+}
+
+// subBackend returns the backend of the dir in the storage.
+func subBackend(u *backuppb.StorageBackend, dir string) (*backuppb.StorageBackend, error) {
+	sub := proto.Clone(u).(*backuppb.StorageBackend)
+	switch b := sub.Backend.(type) {
+	case *backuppb.StorageBackend_Local:
+		b.Local.Path = filepath.Join(b.Local.Path, filepath.FromSlash(dir))
+	case *backuppb.StorageBackend_S3:
+		b.S3.Prefix = path.Join(b.S3.Prefix, dir)
+	case *backuppb.StorageBackend_Gcs:
+		b.Gcs.Prefix = path.Join(b.Gcs.Prefix, dir)
+	default:
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "storage %T is not supported", b)
+	}
+	return sub, nil
+}
+
+// subStorage opens the dir in the storage as a storage, so the files of a
+// backup are accessed in the same way as the backup task.
+func subStorage(
+	ctx context.Context,
+	cfg *Config,
+	u *backuppb.StorageBackend,
+	dir string,
+) (storage.ExternalStorage, error) {
+	sub, err := subBackend(u, dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	opts, err := cfg.StorageOptions()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// The storage has been checked when it's opened.
+	opts.SkipCheckPath = true
+	s, err := storage.New(ctx, sub, opts)
+	return s, errors.Trace(err)
+}
+
+// listBackups finds the backups in the storage by their backupmeta files.
+// The backups whose backupmeta can't be read, e.g. encrypted by another key,
+// are skipped with a warning.
+func listBackups(
+	ctx context.Context,
+	cfg *Config,
+	u *backuppb.StorageBackend,
+	s storage.ExternalStorage,
+) ([]utils.CatalogEntry, error) {
+	names := make([]string, 0)
+	err := s.WalkDir(ctx, &storage.WalkOption{}, func(file string, _ int64) error {
+		file = filepath.ToSlash(file)
+		if path.Base(file) == utils.MetaFile {
+			names = append(names, path.Dir(file))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	backups := make([]utils.CatalogEntry, 0, len(names))
+	for _, name := range names {
+		if name == "." {
+			name = ""
+		}
+		metaStorage, err := metaStorageAt(ctx, cfg, u, name)
+		if err != nil {
+			log.Warn("skip the backup whose backupmeta can't be read", zap.String("backup", name), zap.Error(err))
+			continue
+		}
+		meta, err := readBackupMetaFrom(ctx, metaStorage, name)
+		if err != nil {
+			log.Warn("skip the backup whose backupmeta can't be read", zap.String("backup", name), zap.Error(err))
+			continue
+		}
+		entry := utils.CatalogEntry{
+			Name:         name,
+			ClusterID:    meta.ClusterId,
+			StartVersion: meta.StartVersion,
+			EndVersion:   meta.EndVersion,
+			Size:         utils.ArchiveSize(meta),
+		}
+		// The topology is only for reference.
+		topology, err := utils.ReadClusterTopology(ctx, metaStorage)
+		if err != nil {
+			log.Warn("failed to read the cluster topology of the backup", zap.String("backup", name), zap.Error(err))
+		}
+		entry.SetTopology(topology)
+		backups = append(backups, entry)
+	}
+	return backups, nil
+}
+
+// metaStorageAt opens the storage of the backupmeta and the sidecar files of
+// the backup in the dir of the storage.
+func metaStorageAt(
+	ctx context.Context,
+	cfg *Config,
+	u *backuppb.StorageBackend,
+	name string,
+) (storage.ExternalStorage, error) {
+	// The meta file may be chunked, whose chunks are relative to the backup.
+	sub, err := subStorage(ctx, cfg, u, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	metaStorage, err := openMetaStorage(ctx, cfg, sub)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to open the backupmeta of backup %s", name)
+	}
+	return metaStorage, nil
+}
+
+// readBackupMetaAt reads the backupmeta of the backup in the dir of the
+// storage.
+func readBackupMetaAt(
+	ctx context.Context,
+	cfg *Config,
+	u *backuppb.StorageBackend,
+	name string,
+) (*backuppb.BackupMeta, error) {
+	metaStorage, err := metaStorageAt(ctx, cfg, u, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return readBackupMetaFrom(ctx, metaStorage, name)
+}
+
+// readBackupMetaFrom reads the backupmeta of the backup from its meta storage.
+func readBackupMetaFrom(
+	ctx context.Context,
+	metaStorage storage.ExternalStorage,
+	name string,
+) (*backuppb.BackupMeta, error) {
+	metaData, err := utils.ReadMetaFile(ctx, metaStorage, utils.MetaFile)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read the backupmeta of backup %s", name)
+	}
+	meta := &backuppb.BackupMeta{}
+	if err = proto.Unmarshal(metaData, meta); err != nil {
+		return nil, errors.Annotatef(err, "failed to parse the backupmeta of backup %s", name)
+	}
+	return meta, nil
+}
+
+// checkBackupReferences checks whether the backup can be deleted without
+// breaking the others, i.e. no backup is incremental to it or stored inside
+// it. It returns the deleted backup, or nil if it has no backupmeta.
+func checkBackupReferences(backups []utils.CatalogEntry, name string) (*utils.CatalogEntry, error) {
+	var deleted *utils.CatalogEntry
+	for i := range backups {
+		if backups[i].Name == name {
+			deleted = &backups[i]
+		}
+	}
+	for _, b := range backups {
+		if b.Name == name {
+			continue
+		}
+		if name == "" {
+			return nil, errors.Annotatef(berrors.ErrBackupReferenced,
+				"backup %s is stored inside the backup at the root of the storage to delete, delete it first", b.Name)
+		}
+		if strings.HasPrefix(b.Name, name+"/") {
+			return nil, errors.Annotatef(berrors.ErrBackupReferenced,
+				"backup %s is stored inside the backup %s to delete, delete it first", b.Name, name)
+		}
+		if deleted != nil && b.StartVersion != 0 &&
+			b.StartVersion == deleted.EndVersion && b.ClusterID == deleted.ClusterID {
+			return nil, errors.Annotatef(berrors.ErrBackupReferenced,
+				"incremental backup %s is based on the backup %s, delete it first", b.Name, name)
+		}
+	}
+	return deleted, nil
+}
+
+// deletableBackups returns the expired backups which can be deleted in the
+// order without breaking the retained backups, and the expired ones kept for
+// the backups incremental to them. The backup at the root of the storage is
+// never expired, for the others are stored inside it.
+func deletableBackups(
+	backups []utils.CatalogEntry, expired func(utils.CatalogEntry) bool,
+) (deletable []utils.CatalogEntry, kept []utils.CatalogEntry) {
+	remaining := make([]utils.CatalogEntry, len(backups))
+	copy(remaining, backups)
+	// An incremental backup is deleted before its base, so the chain expired
+	// entirely is deleted from the last backup.
+	for changed := true; changed; {
+		changed = false
+		for i := 0; i < len(remaining); i++ {
+			b := remaining[i]
+			if b.Name == "" || !expired(b) {
+				continue
+			}
+			if _, err := checkBackupReferences(remaining, b.Name); err != nil {
+				continue
+			}
+			deletable = append(deletable, b)
+			remaining = append(remaining[:i], remaining[i+1:]...)
+			i--
+			changed = true
+		}
+	}
+	for _, b := range remaining {
+		if b.Name != "" && expired(b) {
+			kept = append(kept, b)
+		}
+	}
+	return deletable, kept
+}
+
+// backupsOlderThan returns the backups whose backup TS is older than the
+// cutoff, see deletableBackups.
+func backupsOlderThan(
+	backups []utils.CatalogEntry, cutoff uint64,
+) (deletable []utils.CatalogEntry, kept []utils.CatalogEntry) {
+	return deletableBackups(backups, func(b utils.CatalogEntry) bool { return b.EndVersion < cutoff })
+}
+
+// deleteExpiredBackups deletes the deletable backups, and reports the expired
+// ones kept. The catalog is updated after every backup deleted, so the delete
+// can be retried once it fails.
+func deleteExpiredBackups(
+	ctx context.Context,
+	cfg *Config,
+	u *backuppb.StorageBackend,
+	s storage.ExternalStorage,
+	deletable, kept []utils.CatalogEntry,
+	dryRun bool,
+) error {
+	for _, b := range kept {
+		log.Warn("the expired backup is kept for the incremental backups based on it",
+			zap.String("backup", b.Name), zap.Uint64("end-version", b.EndVersion))
+	}
+	if len(kept) > 0 {
+		summary.CollectWarning("expired backups kept for the incremental backups based on them", len(kept))
+	}
+	deletedFiles := 0
+	for _, b := range deletable {
+		if dryRun {
+			log.Info("backup to delete", zap.String("backup", b.Name), zap.Uint64("end-version", b.EndVersion))
+			continue
+		}
+		files, _, err := deleteBackup(ctx, cfg, u, b.Name, true)
+		if err != nil {
+			return errors.Annotatef(err, "failed to delete backup %s", b.Name)
+		}
+		deletedFiles += files
+		if _, err = utils.RemoveFromCatalog(ctx, s, b.Name); err != nil {
+			return errors.Annotate(err, "failed to update the catalog")
+		}
+	}
+	summary.CollectInt("expired backups", len(deletable))
+	summary.CollectInt("deleted files", deletedFiles)
+	summary.SetSuccessStatus(true)
+	return nil
+}
+
+// runDeleteExpired deletes the backups older than --older-than.
+func runDeleteExpired(
+	ctx context.Context,
+	cfg *DeleteConfig,
+	u *backuppb.StorageBackend,
+	s storage.ExternalStorage,
+	backups []utils.CatalogEntry,
+) error {
+	cutoff := oracle.ComposeTS(oracle.GetPhysical(time.Now().Add(-cfg.OlderThan)), 0)
+	deletable, kept := backupsOlderThan(backups, cutoff)
+	return errors.Trace(deleteExpiredBackups(ctx, &cfg.Config, u, s, deletable, kept, false))
+}
+
+// RunDelete deletes a backup, or the backups older than --older-than, from the
+// storage. It refuses to delete the backup which other backups are
+// incremental to, so the retained incremental backups can always be restored. The backupmeta is deleted first, so a backup that
+// fails to be deleted is never taken as complete, and the delete can be
+// retried. The interrupted multipart uploads of the backup are aborted, and
+// the backup is removed from the catalog.
+func RunDelete(c context.Context, cmdName string, cfg *DeleteConfig) error {
+	defer summary.Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	u, s, err := GetStorage(ctx, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	backups, err := listBackups(ctx, &cfg.Config, u, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.OlderThan > 0 {
+		return errors.Trace(runDeleteExpired(ctx, cfg, u, s, backups))
+	}
+	deleted, err := checkBackupReferences(backups, cfg.Backup)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	files, aborted, err := deleteBackup(ctx, &cfg.Config, u, cfg.Backup, deleted != nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if deleted == nil && files == 0 && aborted == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "backup %s not found", cfg.Backup)
+	}
+
+	if _, err = utils.RemoveFromCatalog(ctx, s, cfg.Backup); err != nil {
+		return errors.Annotate(err, "failed to update the catalog")
+	}
+	summary.CollectInt("deleted files", files)
+	summary.CollectInt("aborted uploads", aborted)
+	summary.SetSuccessStatus(true)
+	return nil
+}
+
+// deleteBackup deletes the files of the backup, the backupmeta at first if
+// hasMeta is set, and aborts its interrupted multipart uploads. It returns the
+// count of the files deleted and the uploads aborted.
+func deleteBackup(
+	ctx context.Context,
+	cfg *Config,
+	u *backuppb.StorageBackend,
+	name string,
+	hasMeta bool,
+) (deleted int, aborted int, err error) {
+	sub, err := subStorage(ctx, cfg, u, name)
+	if err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	if err = backup.CheckNoRunningBackup(ctx, sub); err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+
+	files := make([]string, 0)
+	err = sub.WalkDir(ctx, &storage.WalkOption{}, func(file string, _ int64) error {
+		file = filepath.ToSlash(file)
+		// The backupmeta is deleted at first.
+		if file != utils.MetaFile {
+			files = append(files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	if hasMeta {
+		if err = sub.DeleteFile(ctx, utils.MetaFile); err != nil {
+			return 0, 0, errors.Annotate(err, "failed to delete the backupmeta")
+		}
+	} else if len(files) > 0 {
+		log.Warn("the backup has no backupmeta, delete its leftover files", zap.String("backup", name))
+	}
+
+	pool := utils.NewWorkerPool(deleteConcurrency, "delete files")
+	eg, ectx := errgroup.WithContext(ctx)
+	for _, file := range files {
+		file := file
+		pool.ApplyOnErrorGroup(eg, func() error {
+			return errors.Annotatef(sub.DeleteFile(ectx, file), "failed to delete %s", file)
+		})
+	}
+	if err = eg.Wait(); err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	if aborter, ok := sub.(storage.MultipartAborter); ok {
+		if aborted, err = aborter.AbortMultipartUploads(ctx, ""); err != nil {
+			return len(files), 0, errors.Trace(err)
+		}
+	}
+	log.Info("backup deleted",
+		zap.String("backup", name),
+		zap.Int("files", len(files)),
+		zap.Int("aborted-uploads", aborted))
+	return len(files), aborted, nil
+}
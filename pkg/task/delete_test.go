@@ -0,0 +1,107 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gogo/protobuf/proto"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+var _ = Suite(&testDeleteSuite{})
+
+type testDeleteSuite struct{}
+
+func writeTestBackup(c *C, dir string, meta *backup.BackupMeta) {
+	c.Assert(os.MkdirAll(dir, 0o755), IsNil)
+	data, err := proto.Marshal(meta)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, utils.MetaFile), data, 0o644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "1_2_default.sst"), []byte("data"), 0o644), IsNil)
+}
+
+func (*testDeleteSuite) TestCleanBackupName(c *C) {
+	name, err := cleanBackupName("/full//2020-12-01/")
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "full/2020-12-01")
+	_, err = cleanBackupName("/")
+	c.Assert(err, ErrorMatches, ".*--backup is required.*")
+	_, err = cleanBackupName("full/../inc")
+	c.Assert(err, ErrorMatches, ".*invalid backup path.*")
+}
+
+func (*testDeleteSuite) TestRunDelete(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	writeTestBackup(c, filepath.Join(dir, "full"), &backup.BackupMeta{ClusterId: 1, EndVersion: 10})
+	writeTestBackup(c, filepath.Join(dir, "inc"), &backup.BackupMeta{ClusterId: 1, StartVersion: 10, EndVersion: 20})
+	s, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	for _, entry := range []utils.CatalogEntry{
+		{Name: "full", ClusterID: 1, EndVersion: 10},
+		{Name: "inc", ClusterID: 1, StartVersion: 10, EndVersion: 20},
+	} {
+		c.Assert(utils.AddToCatalog(ctx, s, entry), IsNil)
+	}
+
+	cfg := &DeleteConfig{Config: Config{Storage: "local://" + dir}, Backup: "full"}
+	err = RunDelete(ctx, "Delete", cfg)
+	c.Assert(err, ErrorMatches, ".*incremental backup inc is based on the backup full.*")
+
+	cfg.Backup = "inc"
+	c.Assert(RunDelete(ctx, "Delete", cfg), IsNil)
+	_, err = os.Stat(filepath.Join(dir, "inc", "1_2_default.sst"))
+	c.Assert(os.IsNotExist(err), IsTrue)
+	catalog, err := utils.ReadCatalog(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(catalog.Backups, DeepEquals, []utils.CatalogEntry{{Name: "full", ClusterID: 1, EndVersion: 10}})
+
+	cfg.Backup = "full"
+	c.Assert(RunDelete(ctx, "Delete", cfg), IsNil)
+	err = RunDelete(ctx, "Delete", cfg)
+	c.Assert(err, ErrorMatches, ".*backup full not found.*")
+}
+
+func (*testDeleteSuite) TestBackupsOlderThan(c *C) {
+	backups := []utils.CatalogEntry{
+		{Name: "full1", ClusterID: 1, EndVersion: 10},
+		{Name: "inc1", ClusterID: 1, StartVersion: 10, EndVersion: 20},
+		{Name: "full2", ClusterID: 1, EndVersion: 30},
+		{Name: "inc2", ClusterID: 1, StartVersion: 30, EndVersion: 40},
+		{Name: "inc3", ClusterID: 1, StartVersion: 40, EndVersion: 50},
+	}
+	// The chain expired entirely is deleted from the last incremental backup.
+	deletable, kept := backupsOlderThan(backups, 25)
+	c.Assert(deletable, DeepEquals, []utils.CatalogEntry{backups[1], backups[0]})
+	c.Assert(kept, HasLen, 0)
+
+	// The expired bases of the retained incremental backups are kept.
+	deletable, kept = backupsOlderThan(backups, 45)
+	c.Assert(deletable, DeepEquals, []utils.CatalogEntry{backups[1], backups[0]})
+	c.Assert(kept, DeepEquals, []utils.CatalogEntry{backups[2], backups[3]})
+}
+
+func (*testDeleteSuite) TestCheckBackupReferences(c *C) {
+	backups := []utils.CatalogEntry{
+		{Name: "", ClusterID: 1, EndVersion: 10},
+		{Name: "full", ClusterID: 1, EndVersion: 20},
+		{Name: "full/nested", ClusterID: 1, EndVersion: 30},
+		{Name: "fullx", ClusterID: 1, EndVersion: 40},
+	}
+	// The backup at the root doesn't block deleting the others.
+	deleted, err := checkBackupReferences(backups, "fullx")
+	c.Assert(err, IsNil)
+	c.Assert(deleted.Name, Equals, "fullx")
+	_, err = checkBackupReferences(backups, "full")
+	c.Assert(err, ErrorMatches, "backup full/nested is stored inside the backup full to delete.*")
+	_, err = checkBackupReferences(backups, "")
+	c.Assert(err, ErrorMatches, "backup full is stored inside the backup at the root.*")
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/encryption"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// s3SseKMS is the server-side encryption of S3 by a key on AWS KMS, which
+// encrypts the SST files.
+const s3SseKMS = "aws:kms"
+
+// newDataKey generates the data key encrypting the meta files of the backup,
+// nil is returned if the backup isn't encrypted.
+func newDataKey(ctx context.Context, cfg *Config) (*encryption.DataKey, error) {
+	if !cfg.Crypter.Enabled() {
+		return nil, nil
+	}
+	masterKey, err := encryption.NewMasterKey(ctx, &cfg.Crypter)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	key, err := encryption.NewDataKey(ctx, masterKey)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = key.NewDatabaseKeys(ctx, &cfg.Crypter); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return key, nil
+}
+
+// encryptDataFiles makes the storage encrypt the SST files of the encrypted
+// backup by the master key. TiKV writes the SST files to the storage
+// directly and BR can't encrypt them, so they are encrypted by the
+// server-side encryption of S3 with the master key on AWS KMS, which decrypts
+// them transparently on restore. The other storages and master keys, e.g.
+// --crypter.key-file and GCP KMS, can't encrypt the SST files, so they are
+// refused instead of leaving the rows in plaintext.
+func encryptDataFiles(cfg *Config, u *backup.StorageBackend) error {
+	if !cfg.Crypter.Enabled() {
+		return nil
+	}
+	s3 := u.GetS3()
+	if s3 == nil || cfg.Crypter.KMS.Vendor != encryption.KMSVendorAWS {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"the encrypted backup requires S3 storage and the master key on AWS KMS, "+
+				"the SST files written by TiKV are only encrypted by the server-side encryption of S3 with the master key")
+	}
+	if (s3.Sse != "" && s3.Sse != s3SseKMS) || (s3.SseKmsKeyId != "" && s3.SseKmsKeyId != cfg.Crypter.KMS.KeyID) {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"the server-side encryption %s %s conflicts with the master key %s, which encrypts the SST files",
+			s3.Sse, s3.SseKmsKeyId, cfg.Crypter.KMS.KeyID)
+	}
+	// The SST files of the databases are encrypted by their own keys, see
+	// tableBackends.
+	for db, dbCfg := range cfg.Crypter.Databases {
+		if dbCfg.KMS.Vendor != encryption.KMSVendorAWS {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"the master key of database %s must be on AWS KMS, which encrypts the SST files of the database", db)
+		}
+	}
+	s3.Sse, s3.SseKmsKeyId = s3SseKMS, cfg.Crypter.KMS.KeyID
+	log.Info("the SST files are encrypted by the server-side encryption of S3 with the master key",
+		zap.String("sse-kms-key-id", s3.SseKmsKeyId))
+	return nil
+}
+
+// tableBackends returns the backends writing the SST files of the tables in
+// the databases with master keys of their own, indexed by the physical table
+// ID. The files are encrypted by the server-side encryption of S3 with the
+// master keys of the databases, so revoking the key of a database revokes
+// reading its rows as well as its schemas.
+func tableBackends(
+	dbs []*model.DBInfo, databases map[string]*encryption.Config, u *backup.StorageBackend,
+) map[int64]*backup.StorageBackend {
+	backends := make(map[int64]*backup.StorageBackend)
+	for _, db := range dbs {
+		dbCfg, ok := databases[db.Name.L]
+		if !ok {
+			continue
+		}
+		backend := proto.Clone(u).(*backup.StorageBackend)
+		backend.GetS3().SseKmsKeyId = dbCfg.KMS.KeyID
+		for _, table := range db.Tables {
+			backends[table.ID] = backend
+			if pi := table.GetPartitionInfo(); pi != nil {
+				for _, def := range pi.Definitions {
+					backends[def.ID] = backend
+				}
+			}
+		}
+		log.Info("the SST files of the database are encrypted by its master key",
+			zap.String("db", db.Name.O), zap.String("sse-kms-key-id", dbCfg.KMS.KeyID))
+	}
+	return backends
+}
+
+// prepareDataKey returns the data key encrypting the backup, and saves it
+// before any file is encrypted by it. The resumed backup reuses the data key
+// of the previous run, so the sidecar files written by it can be read.
+func prepareDataKey(
+	ctx context.Context, cfg *Config, s storage.ExternalStorage, resume bool,
+) (*encryption.DataKey, error) {
+	if !cfg.Crypter.Enabled() {
+		return nil, nil
+	}
+	if resume {
+		exists, err := s.FileExists(ctx, encryption.DataKeyFile)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if exists {
+			key, err := encryption.ReadDataKey(ctx, s, &cfg.Crypter)
+			return key, errors.Trace(err)
+		}
+	}
+	key, err := newDataKey(ctx, cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return key, errors.Trace(encryption.SaveDataKey(ctx, s, key.Info))
+}
+
+// sidecarStorage returns the storage of the sidecar files of the backup, e.g.
+// the checkpoint and the snapshots of the cluster, which are encrypted by the
+// data key as the backupmeta.
+func sidecarStorage(key *encryption.DataKey, s storage.ExternalStorage) (storage.ExternalStorage, error) {
+	if key == nil {
+		return s, nil
+	}
+	return key.Storage(s)
+}
+
+// readDataKey reads the data key of the backup, nil is returned if the
+// backup isn't encrypted. It also checks this BR can read the backup.
+func readDataKey(ctx context.Context, cfg *Config, s storage.ExternalStorage) (*encryption.DataKey, error) {
+	format, err := utils.ReadBackupFormat(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if format == nil || format.Features&utils.FormatEncrypted == 0 {
+		return nil, nil
+	}
+	key, err := encryption.ReadDataKey(ctx, s, &cfg.Crypter)
+	return key, errors.Trace(err)
+}
+
+// openMetaStorage returns the storage reading the meta files of the backup,
+// which decrypts them if the backup is encrypted. It also checks this BR can
+// read the backup.
+func openMetaStorage(ctx context.Context, cfg *Config, s storage.ExternalStorage) (storage.ExternalStorage, error) {
+	key, err := readDataKey(ctx, cfg, s)
+	if err != nil || key == nil {
+		return s, errors.Trace(err)
+	}
+	return key.Storage(s)
+}
+
+// schemaStorageOf returns the storages of the external schemas of the
+// databases, which are encrypted by the keys of the databases if they have.
+func schemaStorageOf(key *encryption.DataKey, s storage.ExternalStorage) utils.SchemaStorageFunc {
+	return func(db string) (storage.ExternalStorage, error) {
+		if key == nil {
+			return s, nil
+		}
+		return key.DatabaseStorage(s, db)
+	}
+}
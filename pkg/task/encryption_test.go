@@ -0,0 +1,114 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+
+	"github.com/pingcap/br/pkg/encryption"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+var _ = Suite(&testEncryptionSuite{})
+
+type testEncryptionSuite struct{}
+
+func (*testEncryptionSuite) TestSidecarStorage(c *C) {
+	ctx := context.Background()
+	keyFile := filepath.Join(c.MkDir(), "master.key")
+	c.Assert(ioutil.WriteFile(keyFile,
+		[]byte("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"), 0o600), IsNil)
+	cfg := &Config{Crypter: encryption.Config{Method: encryption.MethodAES256GCM, KeyFile: keyFile}}
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	key, err := prepareDataKey(ctx, cfg, s, false)
+	c.Assert(err, IsNil)
+	sidecar, err := sidecarStorage(key, s)
+	c.Assert(err, IsNil)
+	c.Assert(sidecar.Write(ctx, "backup.checkpoint", []byte("ranges")), IsNil)
+	// The sidecar file isn't written in plaintext.
+	data, err := s.Read(ctx, "backup.checkpoint")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Not(Equals), "ranges")
+
+	// The resumed backup reads the sidecar files by the saved data key.
+	resumed, err := prepareDataKey(ctx, cfg, s, true)
+	c.Assert(err, IsNil)
+	c.Assert(resumed.Key, DeepEquals, key.Key)
+	sidecar, err = sidecarStorage(resumed, s)
+	c.Assert(err, IsNil)
+	data, err = sidecar.Read(ctx, "backup.checkpoint")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "ranges")
+
+	plain, err := sidecarStorage(nil, s)
+	c.Assert(err, IsNil)
+	c.Assert(plain, Equals, s)
+}
+
+func (*testEncryptionSuite) TestEncryptDataFiles(c *C) {
+	newS3 := func(sse, keyID string) *backup.StorageBackend {
+		return &backup.StorageBackend{Backend: &backup.StorageBackend_S3{
+			S3: &backup.S3{Bucket: "b", Sse: sse, SseKmsKeyId: keyID},
+		}}
+	}
+	u := newS3("", "")
+	c.Assert(encryptDataFiles(&Config{}, u), IsNil)
+	c.Assert(u.GetS3().Sse, Equals, "")
+
+	// The master key on AWS KMS encrypts the SST files.
+	cfg := &Config{Crypter: encryption.Config{
+		Method: encryption.MethodAES256GCM,
+		KMS:    encryption.KMSConfig{Vendor: encryption.KMSVendorAWS, KeyID: "alias/br"},
+	}}
+	c.Assert(encryptDataFiles(cfg, u), IsNil)
+	c.Assert(u.GetS3().Sse, Equals, s3SseKMS)
+	c.Assert(u.GetS3().SseKmsKeyId, Equals, "alias/br")
+	c.Assert(encryptDataFiles(cfg, newS3(s3SseKMS, "alias/br")), IsNil)
+
+	// The SST files aren't left encrypted by another key or in plaintext.
+	c.Assert(encryptDataFiles(cfg, newS3("AES256", "")), ErrorMatches, ".*conflicts with the master key.*")
+	c.Assert(encryptDataFiles(cfg, newS3(s3SseKMS, "alias/other")), ErrorMatches, ".*conflicts with the master key.*")
+	local := &backup.StorageBackend{Backend: &backup.StorageBackend_Local{Local: &backup.Local{Path: "/tmp"}}}
+	c.Assert(encryptDataFiles(cfg, local), ErrorMatches, ".*requires S3 storage and the master key on AWS KMS.*")
+	cfg.Crypter = encryption.Config{Method: encryption.MethodAES256GCM, KeyFile: "master.key"}
+	c.Assert(encryptDataFiles(cfg, newS3("", "")), ErrorMatches, ".*requires S3 storage and the master key on AWS KMS.*")
+}
+
+func (*testEncryptionSuite) TestTableBackends(c *C) {
+	u := &backup.StorageBackend{Backend: &backup.StorageBackend_S3{S3: &backup.S3{Bucket: "b"}}}
+	cfg := &Config{Crypter: encryption.Config{
+		Method: encryption.MethodAES256GCM,
+		KMS:    encryption.KMSConfig{Vendor: encryption.KMSVendorAWS, KeyID: "alias/br"},
+		Databases: map[string]*encryption.Config{
+			"tenant": {Method: encryption.MethodAES256GCM, KMS: encryption.KMSConfig{Vendor: encryption.KMSVendorAWS, KeyID: "alias/tenant"}},
+		},
+	}}
+	c.Assert(encryptDataFiles(cfg, u), IsNil)
+
+	partitioned := &model.TableInfo{ID: 12, Partition: &model.PartitionInfo{
+		Definitions: []model.PartitionDefinition{{ID: 13}, {ID: 14}},
+	}}
+	backends := tableBackends([]*model.DBInfo{
+		{Name: model.NewCIStr("Tenant"), Tables: []*model.TableInfo{{ID: 11}, partitioned}},
+		{Name: model.NewCIStr("other"), Tables: []*model.TableInfo{{ID: 21}}},
+	}, cfg.Crypter.Databases, u)
+	c.Assert(backends, HasLen, 4)
+	for _, id := range []int64{11, 12, 13, 14} {
+		c.Assert(backends[id].GetS3().Sse, Equals, s3SseKMS)
+		c.Assert(backends[id].GetS3().SseKmsKeyId, Equals, "alias/tenant")
+	}
+	// The backend of the storage is kept.
+	c.Assert(u.GetS3().SseKmsKeyId, Equals, "alias/br")
+
+	// The SST files of a database can't be encrypted by a key file.
+	cfg.Crypter.Databases["tenant"] = &encryption.Config{Method: encryption.MethodAES256GCM, KeyFile: "tenant.key"}
+	c.Assert(encryptDataFiles(cfg, u), ErrorMatches, ".*master key of database tenant must be on AWS KMS.*")
+}
@@ -0,0 +1,200 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	flagExportOutput        = "output"
+	flagExportFileSize      = "filesize"
+	flagExportStatementSize = "statement-size"
+	flagExportConcurrency   = "export-concurrency"
+
+	defaultExportConcurrency = 8
+)
+
+// ExportConfig is the configuration specific for the export tasks.
+type ExportConfig struct {
+	Config
+
+	// Output is the storage URL the backup is exported into.
+	Output string `json:"output" toml:"output"`
+	// FileSize is the max size of a data file exported.
+	FileSize uint64 `json:"filesize" toml:"filesize"`
+	// StatementSize is the max size of an INSERT statement exported.
+	StatementSize uint64 `json:"statement-size" toml:"statement-size"`
+	// ExportConcurrency is the count of the tables exported concurrently.
+	ExportConcurrency uint `json:"export-concurrency" toml:"export-concurrency"`
+}
+
+// DefineExportFlags defines the flags of the export command.
+func DefineExportFlags(flags *pflag.FlagSet) {
+	flags.StringP(flagExportOutput, "o", "",
+		"the storage URL the backup is exported into in the format of Dumpling, e.g. local:///data/dump")
+	flags.String(flagExportFileSize, "256MiB", "the max size of a data file exported")
+	flags.String(flagExportStatementSize, "1MiB", "the max size of an INSERT statement exported")
+	flags.Uint(flagExportConcurrency, defaultExportConcurrency, "the count of the tables exported concurrently")
+}
+
+// ParseFromFlags parses the export-related flags from the flag set.
+func (cfg *ExportConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	if err := cfg.Config.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	var err error
+	cfg.Output, err = flags.GetString(flagExportOutput)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Output == "" {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s is required", flagExportOutput)
+	}
+	for flag, size := range map[string]*uint64{
+		flagExportFileSize:      &cfg.FileSize,
+		flagExportStatementSize: &cfg.StatementSize,
+	} {
+		s, err := flags.GetString(flag)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if *size, err = utils.ParseByteSize(s); err != nil {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s: %v", flag, err)
+		}
+	}
+	cfg.ExportConcurrency, err = flags.GetUint(flagExportConcurrency)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ExportConcurrency == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must be positive", flagExportConcurrency)
+	}
+	return nil
+}
+
+// RunExport materializes the backup into a directory in the format of
+// Dumpling, i.e. the schema files and the INSERT statements of the rows, so
+// the tools only understanding the logical dumps can consume the backup.
+func RunExport(c context.Context, g glue.Glue, cmdName string, cfg *ExportConfig) error {
+	defer summary.Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+	startedAt := time.Now()
+
+	_, s, backupMeta, err := ReadBackupMeta(ctx, utils.MetaFile, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if backupMeta.IsRawKv {
+		return errors.Annotate(berrors.ErrRestoreModeMismatch, "the raw kv backup can't be exported")
+	}
+	if backupMeta.StartVersion > 0 {
+		// The deletions of an incremental backup can't be exported.
+		return errors.Annotate(berrors.ErrRestoreModeMismatch, "the incremental backup can't be exported")
+	}
+	outputBackend, err := storage.ParseBackend(cfg.Output, &cfg.BackendOptions)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	opts, err := cfg.StorageOptions()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	output, err := storage.New(ctx, outputBackend, opts)
+	if err != nil {
+		return errors.Annotate(err, "create output storage failed")
+	}
+
+	dbs, err := utils.LoadBackupTables(backupMeta)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	dbNames := make([]string, 0, len(dbs))
+	for name := range dbs {
+		dbNames = append(dbNames, name)
+	}
+	sort.Strings(dbNames)
+	exporter := restore.NewDumplingExporter(s, output, cfg.FileSize, cfg.StatementSize)
+	if cfg.TmpDir != "" {
+		tmpDir, err := cfg.openTempDir(ctx)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer func() {
+			if err := tmpDir.Close(); err != nil {
+				log.Warn("failed to remove the temporary directory", zap.String("path", tmpDir.Path()), zap.Error(err))
+			}
+		}()
+		exporter.SetTempDir(tmpDir)
+	}
+	tables := make([]*utils.Table, 0)
+	files := 0
+	for _, name := range dbNames {
+		db := dbs[name]
+		exported := false
+		for _, table := range db.Tables {
+			if !cfg.TableFilter.MatchTable(name, table.Info.Name.O) {
+				continue
+			}
+			if !exported {
+				if err = exporter.ExportDatabase(ctx, db.Info); err != nil {
+					return errors.Trace(err)
+				}
+				exported = true
+			}
+			if err = exporter.ExportTableSchema(ctx, table); err != nil {
+				return errors.Trace(err)
+			}
+			tables = append(tables, table)
+			files += restore.EstimateRangeSize(table.Files)
+		}
+	}
+	log.Info("start to export the backup",
+		zap.String("output", output.URI()),
+		zap.Int("tables", len(tables)),
+		zap.Int("files", files))
+
+	// Redirect to log if there is no log file to avoid unreadable output.
+	updateCh := g.StartProgress(ctx, cmdName, int64(files), !cfg.LogProgress)
+	defer updateCh.Close()
+	var rows int64
+	pool := utils.NewWorkerPool(cfg.ExportConcurrency, "export")
+	eg, ectx := errgroup.WithContext(ctx)
+	for _, table := range tables {
+		table := table
+		pool.ApplyOnErrorGroup(eg, func() error {
+			n, err := exporter.ExportTable(ectx, table, updateCh)
+			atomic.AddInt64(&rows, int64(n))
+			return errors.Trace(err)
+		})
+	}
+	err = eg.Wait()
+	summary.CollectInt("exported rows", int(atomic.LoadInt64(&rows)))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// The metadata is written last, so an export is complete with it.
+	if err = exporter.WriteMetadata(ctx, backupMeta, startedAt, time.Now()); err != nil {
+		return errors.Trace(err)
+	}
+	summary.CollectInt("exported tables", len(tables))
+	summary.SetSuccessStatus(true)
+	return nil
+}
@@ -0,0 +1,272 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	flagFanOutPD          = "fan-out-pd"
+	flagFanOutFile        = "fan-out-file"
+	flagFanOutConcurrency = "fan-out-concurrency"
+
+	defaultFanOutConcurrency = 4
+)
+
+var invalidClusterNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// FanOutCluster is a cluster backed up by a fan-out backup.
+type FanOutCluster struct {
+	Name string   `yaml:"name"`
+	PD   []string `yaml:"pd"`
+	// Prefix is the sub-prefix of the storage the cluster is backed up to,
+	// it's the name by default.
+	Prefix string `yaml:"prefix"`
+}
+
+// FanOutConfig is the configuration of running the same backup against
+// multiple clusters, e.g.
+//
+//	clusters:
+//	- name: prod-east
+//	  pd: ["pd-east-1:2379", "pd-east-2:2379"]
+//	- name: prod-west
+//	  pd: ["pd-west:2379"]
+//	  prefix: west
+type FanOutConfig struct {
+	Clusters    []FanOutCluster `yaml:"clusters"`
+	Concurrency uint            `yaml:"-"`
+}
+
+// FanOutResult is the result of backing up a cluster of a fan-out backup.
+type FanOutResult struct {
+	Cluster  string
+	Storage  string
+	Duration time.Duration
+	Files    int
+	Size     uint64
+	Err      error
+}
+
+// FanOutRunner backs up the cluster into the storage.
+type FanOutRunner func(ctx context.Context, cluster *FanOutCluster, storage string) error
+
+// DefineFanOutFlags defines the flags of the fan-out backup.
+func DefineFanOutFlags(flags *pflag.FlagSet) {
+	flags.StringArray(flagFanOutPD, nil,
+		"back up the clusters of the PD addresses concurrently, each into its sub-prefix of --storage, "+
+			`one cluster per flag, e.g. --fan-out-pd "east=pd-e1:2379,pd-e2:2379" --fan-out-pd pd-w:2379`)
+	flags.String(flagFanOutFile, "",
+		"the YAML file of the clusters to back up concurrently, each into its sub-prefix of --storage")
+	flags.Uint(flagFanOutConcurrency, defaultFanOutConcurrency,
+		"the number of clusters backed up concurrently by the fan-out backup")
+}
+
+// ParseFromFlags parses the config from the flag set.
+func (cfg *FanOutConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	pds, err := flags.GetStringArray(flagFanOutPD)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	file, err := flags.GetString(flagFanOutFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Concurrency, err = flags.GetUint(flagFanOutConcurrency); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = defaultFanOutConcurrency
+	}
+
+	switch {
+	case len(pds) > 0 && file != "":
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s and --%s can't be both set", flagFanOutPD, flagFanOutFile)
+	case file != "":
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return errors.Annotatef(err, "failed to read clusters file %s", file)
+		}
+		if err = yaml.UnmarshalStrict(data, cfg); err != nil {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "invalid clusters file: %s", err)
+		}
+	default:
+		cfg.Clusters = make([]FanOutCluster, 0, len(pds))
+		for _, pd := range pds {
+			cfg.Clusters = append(cfg.Clusters, parseFanOutCluster(pd))
+		}
+	}
+	return errors.Trace(cfg.adjust())
+}
+
+// Enabled returns whether there are clusters to fan out.
+func (cfg *FanOutConfig) Enabled() bool {
+	return len(cfg.Clusters) > 0
+}
+
+// parseFanOutCluster parses the cluster in the form of `[name=]pd[,pd...]`,
+// the name is from the first PD address if omitted.
+func parseFanOutCluster(s string) FanOutCluster {
+	cluster := FanOutCluster{}
+	if i := strings.Index(s, "="); i >= 0 {
+		cluster.Name, s = s[:i], s[i+1:]
+	}
+	for _, pd := range strings.Split(s, ",") {
+		if pd = strings.TrimSpace(pd); pd != "" {
+			cluster.PD = append(cluster.PD, pd)
+		}
+	}
+	if cluster.Name == "" && len(cluster.PD) > 0 {
+		name := strings.TrimPrefix(strings.TrimPrefix(cluster.PD[0], "http://"), "https://")
+		cluster.Name = strings.Trim(invalidClusterNameChars.ReplaceAllString(name, "-"), "-")
+	}
+	return cluster
+}
+
+func (cfg *FanOutConfig) adjust() error {
+	names := make(map[string]struct{}, len(cfg.Clusters))
+	prefixes := make(map[string]string, len(cfg.Clusters))
+	for i := range cfg.Clusters {
+		cluster := &cfg.Clusters[i]
+		if cluster.Name == "" || invalidClusterNameChars.MatchString(cluster.Name) {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"invalid name %q of cluster #%d", cluster.Name, i+1)
+		}
+		if _, ok := names[cluster.Name]; ok {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "duplicated cluster %s", cluster.Name)
+		}
+		names[cluster.Name] = struct{}{}
+		if len(cluster.PD) == 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "the PD of cluster %s is empty", cluster.Name)
+		}
+		if cluster.Prefix == "" {
+			cluster.Prefix = cluster.Name
+		}
+		prefix := path.Clean("/" + cluster.Prefix)
+		if prefix == "/" || strings.Contains(cluster.Prefix, "..") {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"invalid prefix %q of cluster %s", cluster.Prefix, cluster.Name)
+		}
+		// Otherwise, a cluster would overwrite the backup of another.
+		if other, ok := prefixes[prefix]; ok {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"the clusters %s and %s have the same prefix %q", other, cluster.Name, cluster.Prefix)
+		}
+		prefixes[prefix] = cluster.Name
+		cluster.Prefix = strings.TrimPrefix(prefix, "/")
+	}
+	return nil
+}
+
+// FanOutArgs returns the arguments of the backup command for the backup of
+// each cluster, without the flags set per cluster and the omitted ones.
+func FanOutArgs(flags *pflag.FlagSet, omitted ...string) []string {
+	omittedFlags := map[string]struct{}{
+		flagFanOutPD:          {},
+		flagFanOutFile:        {},
+		flagFanOutConcurrency: {},
+		flagPD:                {},
+		flagStorage:           {},
+		flagCron:              {},
+	}
+	for _, name := range omitted {
+		omittedFlags[name] = struct{}{}
+	}
+	return changedFlagArgs(flags, omittedFlags)
+}
+
+// RunFanOut backs up the clusters by the runner concurrently, each into its
+// sub-prefix of the storage, then summarizes the backups. The failure of a
+// cluster doesn't stop the others.
+func RunFanOut(
+	ctx context.Context,
+	cmdName string,
+	cfg *Config,
+	fanOut *FanOutConfig,
+	run FanOutRunner,
+) ([]FanOutResult, error) {
+	defer summary.Summary(cmdName)
+	start := time.Now()
+	results := make([]FanOutResult, len(fanOut.Clusters))
+	for i, cluster := range fanOut.Clusters {
+		u, err := storage.ParseRawURL(cfg.Storage)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		u.Path = path.Join(u.Path, cluster.Prefix)
+		results[i].Cluster = cluster.Name
+		results[i].Storage = u.String()
+	}
+
+	pool := utils.NewWorkerPool(fanOut.Concurrency, "fan-out backup")
+	wg := new(sync.WaitGroup)
+	for i := range fanOut.Clusters {
+		cluster := &fanOut.Clusters[i]
+		result := &results[i]
+		wg.Add(1)
+		pool.Apply(func() {
+			defer wg.Done()
+			log.Info("start to backup the cluster of fan-out",
+				zap.String("cluster", cluster.Name),
+				zap.Strings("pd", cluster.PD),
+				zap.String("prefix", cluster.Prefix))
+			clusterStart := time.Now()
+			result.Err = run(ctx, cluster, result.Storage)
+			result.Duration = time.Since(clusterStart)
+			if result.Err != nil {
+				log.Error("failed to backup the cluster of fan-out",
+					zap.String("cluster", cluster.Name), zap.Error(result.Err))
+				return
+			}
+			clusterCfg := *cfg
+			clusterCfg.Storage = result.Storage
+			status, err := GetTaskStatus(ctx, &clusterCfg)
+			if err != nil {
+				log.Warn("failed to get the status of the backup",
+					zap.String("cluster", cluster.Name), zap.Error(err))
+				return
+			}
+			result.Files, result.Size = status.Files, status.Size
+		})
+	}
+	wg.Wait()
+
+	var err error
+	failed, files, size := 0, 0, uint64(0)
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			err = multierr.Append(err, errors.Annotatef(result.Err, "failed to backup cluster %s", result.Cluster))
+			continue
+		}
+		files += result.Files
+		size += result.Size
+	}
+	summary.CollectInt("fan-out clusters", len(results))
+	summary.CollectInt("fan-out failed clusters", failed)
+	summary.CollectInt("fan-out files", files)
+	summary.CollectUint("fan-out size", size)
+	summary.CollectDuration("fan-out take", time.Since(start))
+	summary.SetSuccessStatus(failed == 0)
+	return results, errors.Trace(err)
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/spf13/pflag"
+)
+
+var _ = Suite(&testFanOutSuite{})
+
+type testFanOutSuite struct{}
+
+func (*testFanOutSuite) TestParseFanOutConfig(c *C) {
+	parse := func(args ...string) (*FanOutConfig, error) {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		DefineFanOutFlags(flags)
+		c.Assert(flags.Parse(args), IsNil)
+		cfg := &FanOutConfig{}
+		return cfg, cfg.ParseFromFlags(flags)
+	}
+
+	cfg, err := parse()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Enabled(), IsFalse)
+
+	cfg, err = parse("--fan-out-pd", "east=pd-e1:2379,pd-e2:2379", "--fan-out-pd", "http://pd-w:2379")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Clusters, DeepEquals, []FanOutCluster{
+		{Name: "east", PD: []string{"pd-e1:2379", "pd-e2:2379"}, Prefix: "east"},
+		{Name: "pd-w-2379", PD: []string{"http://pd-w:2379"}, Prefix: "pd-w-2379"},
+	})
+
+	_, err = parse("--fan-out-pd", "a=pd1:2379", "--fan-out-pd", "a=pd2:2379")
+	c.Assert(err, ErrorMatches, ".*duplicated cluster a.*")
+
+	file := filepath.Join(c.MkDir(), "clusters.yaml")
+	c.Assert(ioutil.WriteFile(file, []byte(`
+clusters:
+- name: east
+  pd: ["pd-e:2379"]
+  prefix: shared
+- name: west
+  pd: ["pd-w:2379"]
+  prefix: /shared/
+`), 0o644), IsNil)
+	_, err = parse("--fan-out-file", file)
+	c.Assert(err, ErrorMatches, ".*the clusters east and west have the same prefix.*")
+}
+
+func (*testFanOutSuite) TestRunFanOut(c *C) {
+	dir := c.MkDir()
+	fanOut := &FanOutConfig{
+		Clusters: []FanOutCluster{
+			{Name: "a", PD: []string{"pd-a:2379"}, Prefix: "a"},
+			{Name: "b", PD: []string{"pd-b:2379"}, Prefix: "b"},
+			{Name: "c", PD: []string{"pd-c:2379"}, Prefix: "c"},
+		},
+		Concurrency: 2,
+	}
+	var mu sync.Mutex
+	storages := make([]string, 0, len(fanOut.Clusters))
+	run := func(ctx context.Context, cluster *FanOutCluster, storage string) error {
+		mu.Lock()
+		storages = append(storages, storage)
+		mu.Unlock()
+		if cluster.Name == "b" {
+			return errors.New("cluster b is down")
+		}
+		return nil
+	}
+	results, err := RunFanOut(context.Background(), "fan-out test", &Config{Storage: "local://" + dir}, fanOut, run)
+	c.Assert(err, ErrorMatches, ".*failed to backup cluster b: cluster b is down.*")
+	c.Assert(results, HasLen, 3)
+	c.Assert(results[0].Err, IsNil)
+	c.Assert(results[1].Err, NotNil)
+	c.Assert(results[2].Err, IsNil)
+	sort.Strings(storages)
+	c.Assert(storages, DeepEquals, []string{"local://" + dir + "/a", "local://" + dir + "/b", "local://" + dir + "/c"})
+}
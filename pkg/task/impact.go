@@ -0,0 +1,76 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/conn"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// startImpactCollector samples the load of the cluster before the backup, and
+// keeps sampling until the backup finishes. The report is only for reference,
+// so the failures are ignored and nil is returned.
+func startImpactCollector(ctx context.Context, mgr *conn.Mgr, cfg *BackupConfig) *utils.ImpactCollector {
+	stores, err := conn.GetAllTiKVStores(ctx, mgr.GetPDClient(), conn.SkipTiFlash)
+	if err != nil {
+		log.Warn("failed to get the stores for the impact report", zap.Error(err))
+		return nil
+	}
+	tikv := make([]string, 0, len(stores))
+	for _, store := range stores {
+		if addr := store.GetStatusAddress(); addr != "" {
+			tikv = append(tikv, addr)
+		}
+	}
+	collector := utils.NewImpactCollector(mgr.GetTLSConfig(), tikv, cfg.ImpactTiDBStatus)
+	log.Info("sampling the load of the cluster before backup",
+		zap.Int("tikv", len(tikv)),
+		zap.Int("tidb", len(cfg.ImpactTiDBStatus)),
+		zap.Duration("window", cfg.ImpactInterval))
+	if err = collector.Start(ctx, cfg.ImpactInterval, cfg.ImpactInterval); err != nil {
+		log.Warn("failed to sample the load of the cluster before backup", zap.Error(err))
+		return nil
+	}
+	return collector
+}
+
+// finishImpactReport samples the load of the cluster after the backup, and
+// saves the report comparing the load before, during and after the backup.
+func finishImpactReport(
+	ctx context.Context,
+	collector *utils.ImpactCollector,
+	s storage.ExternalStorage,
+	window time.Duration,
+) {
+	report, err := collector.Finish(ctx, window)
+	if err == nil {
+		err = utils.SaveImpactReport(ctx, s, report)
+	}
+	if err != nil {
+		log.Warn("failed to report the impact of the backup", zap.Error(err))
+		return
+	}
+	log.Info("backup impact",
+		zap.Float64("tikv-cpu-cores-before", report.Before.TiKVCPUCores),
+		zap.Float64("tikv-cpu-cores-during", report.During.TiKVCPUCores),
+		zap.Float64("tikv-cpu-cores-peak", report.DuringPeak.TiKVCPUCores),
+		zap.Float64("tikv-cpu-cores-after", report.After.TiKVCPUCores),
+		zap.Float64("tikv-cpu-cores-delta", report.During.TiKVCPUCores-report.Before.TiKVCPUCores),
+		zap.Float64("tikv-flow-bytes-per-second-before", report.Before.TiKVFlowBytesPerSecond),
+		zap.Float64("tikv-flow-bytes-per-second-during", report.During.TiKVFlowBytesPerSecond),
+		zap.Float64("tikv-flow-bytes-per-second-peak", report.DuringPeak.TiKVFlowBytesPerSecond),
+		zap.Float64("tikv-flow-bytes-per-second-after", report.After.TiKVFlowBytesPerSecond),
+		zap.Float64("tikv-flow-bytes-per-second-delta",
+			report.During.TiKVFlowBytesPerSecond-report.Before.TiKVFlowBytesPerSecond),
+		zap.Float64("sql-p99-seconds-before", report.Before.SQLP99Seconds),
+		zap.Float64("sql-p99-seconds-during", report.During.SQLP99Seconds),
+		zap.Float64("sql-p99-seconds-peak", report.DuringPeak.SQLP99Seconds),
+		zap.Float64("sql-p99-seconds-after", report.After.SQLP99Seconds))
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/conn"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	flagProfile = "profile"
+
+	// minProfileRestoreConcurrency is the min restore concurrency of the
+	// profiles, so a tiny cluster still restores in batches.
+	minProfileRestoreConcurrency = 16
+
+	// referenceStoreCapacity is the store capacity the profiles are tuned
+	// for, the profiles scale by the capacity of the stores relative to it,
+	// in [minCapacityScale, maxCapacityScale].
+	referenceStoreCapacity = 1 << 40
+	minCapacityScale       = 0.5
+	maxCapacityScale       = 2
+)
+
+// profile is a tuned set of the performance flags.
+type profile struct {
+	// backupConcurrency is the size of the backup thread pool on each node.
+	backupConcurrency uint32
+	// restoreConcurrencyPerStore is the restore concurrency per TiKV store.
+	restoreConcurrencyPerStore uint32
+	checksumConcurrency        uint
+	// rateLimit is the rate limit per node, zero means no limit.
+	rateLimit uint64
+}
+
+// profiles are the presets of `--profile`, from the gentlest to the fastest.
+var profiles = map[string]profile{
+	"small": {
+		backupConcurrency:          2,
+		restoreConcurrencyPerStore: 8,
+		checksumConcurrency:        2,
+		rateLimit:                  64 * utils.MB,
+	},
+	"medium": {
+		backupConcurrency:          4,
+		restoreConcurrencyPerStore: 16,
+		checksumConcurrency:        4,
+		rateLimit:                  128 * utils.MB,
+	},
+	"large": {
+		backupConcurrency:          8,
+		restoreConcurrencyPerStore: 32,
+		checksumConcurrency:        8,
+	},
+	"max": {
+		backupConcurrency:          16,
+		restoreConcurrencyPerStore: 64,
+		checksumConcurrency:        16,
+	},
+}
+
+func profileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return profiles[names[i]].restoreConcurrencyPerStore < profiles[names[j]].restoreConcurrencyPerStore
+	})
+	return names
+}
+
+// parseProfileFromFlags parses the profile, and records the performance flags
+// set explicitly, which override the profile.
+func (cfg *Config) parseProfileFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.Profile, err = flags.GetString(flagProfile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Profile == "" {
+		return nil
+	}
+	if _, ok := profiles[cfg.Profile]; !ok {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"unknown profile %q, must be one of %s", cfg.Profile, strings.Join(profileNames(), "|"))
+	}
+	cfg.explicitFlags = make(map[string]bool)
+	for _, name := range []string{flagConcurrency, flagChecksumConcurrency, flagRateLimit} {
+		cfg.explicitFlags[name] = flags.Changed(name)
+	}
+	return nil
+}
+
+// capacityScale returns how much the profiles scale for the average capacity
+// of the stores, zero means unknown and doesn't scale.
+func capacityScale(avgCapacity uint64) float64 {
+	if avgCapacity == 0 {
+		return 1
+	}
+	scale := float64(avgCapacity) / referenceStoreCapacity
+	if scale < minCapacityScale {
+		return minCapacityScale
+	}
+	if scale > maxCapacityScale {
+		return maxCapacityScale
+	}
+	return scale
+}
+
+// applyProfile applies the profile to the performance fields not set
+// explicitly. The restore concurrency, which also decides the restore batch
+// size, is scaled by the count of TiKV stores, and the concurrency and the
+// rate limit per node are scaled by the average capacity of the stores.
+func (cfg *Config) applyProfile(tikvStores int, avgCapacity uint64, forRestore bool) { // revive:disable-line:flag-parameter
+	p, ok := profiles[cfg.Profile]
+	if !ok {
+		return
+	}
+	scale := capacityScale(avgCapacity)
+	if !cfg.explicitFlags[flagConcurrency] {
+		if forRestore {
+			concurrency := utils.ClampInt(int(float64(p.restoreConcurrencyPerStore)*scale)*tikvStores,
+				minProfileRestoreConcurrency, maxRestoreBatchSizeLimit)
+			cfg.Concurrency = uint32(concurrency)
+		} else {
+			cfg.Concurrency = uint32(utils.ClampInt(int(float64(p.backupConcurrency)*scale), 1, math.MaxInt32))
+		}
+	}
+	if !cfg.explicitFlags[flagChecksumConcurrency] {
+		cfg.ChecksumConcurrency = p.checksumConcurrency
+	}
+	if !cfg.explicitFlags[flagRateLimit] {
+		cfg.RateLimit = uint64(float64(p.rateLimit) * scale)
+	}
+	log.Info("apply the profile",
+		zap.String("profile", cfg.Profile),
+		zap.Int("tikv-stores", tikvStores),
+		zap.Uint64("avg-capacity", avgCapacity),
+		zap.Float64("capacity-scale", scale),
+		zap.Uint32("concurrency", cfg.Concurrency),
+		zap.Uint("checksum-concurrency", cfg.ChecksumConcurrency),
+		zap.Uint64("ratelimit", cfg.RateLimit))
+}
+
+// applyProfileOfCluster applies the profile according to the TiKV stores of
+// the cluster and their capacities.
+func applyProfileOfCluster(ctx context.Context, mgr *conn.Mgr, cfg *Config, forRestore bool) error { // revive:disable-line:flag-parameter
+	if cfg.Profile == "" {
+		return nil
+	}
+	stores, err := mgr.GetPDClient().GetAllStores(ctx, pd.WithExcludeTombstone())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// The capacities only tune the profile, so the profile is still applied
+	// without them.
+	capacities, err := mgr.GetStoreCapacities(ctx)
+	if err != nil {
+		log.Warn("failed to get the capacities of the stores, the profile isn't scaled by them", zap.Error(err))
+	}
+	tikvStores := 0
+	var totalCapacity, reported uint64
+	for _, store := range stores {
+		if utils.IsTiFlash(store) {
+			continue
+		}
+		tikvStores++
+		if capacity, ok := capacities[store.GetId()]; ok {
+			totalCapacity += capacity
+			reported++
+		}
+	}
+	var avgCapacity uint64
+	if reported > 0 {
+		avgCapacity = totalCapacity / reported
+	}
+	cfg.applyProfile(tikvStores, avgCapacity, forRestore)
+	return nil
+}
@@ -4,6 +4,8 @@ package task
 
 import (
 	"context"
+	"io/ioutil"
+	"strings"
 	"time"
 
 	"github.com/pingcap/errors"
@@ -18,6 +20,7 @@ import (
 	"github.com/pingcap/br/pkg/conn"
 	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/logutil"
 	"github.com/pingcap/br/pkg/pdutil"
 	"github.com/pingcap/br/pkg/restore"
 	"github.com/pingcap/br/pkg/storage"
@@ -26,12 +29,73 @@ import (
 )
 
 const (
-	flagOnline   = "online"
-	flagNoSchema = "no-schema"
+	flagOnline         = "online"
+	flagOnlineLearner  = "online-learner"
+	flagNoSchema       = "no-schema"
+	flagChecksumBudget = "checksum-budget"
+	// flagChecksumSampleRate is the ratio of the tables verified by checksum.
+	flagChecksumSampleRate = "checksum-sample-rate"
+	flagChecksumSampleSeed = "checksum-sample-seed"
+	// flagOnlineEvictLeaders is the max count of the ingest-heavy stores the
+	// leaders are evicted from.
+	flagOnlineEvictLeaders = "online-evict-leaders"
+	// flagPlacementMapping is the path of the placement mapping file.
+	flagPlacementMapping = "placement-mapping"
+	flagScatterPriority  = "scatter-priority"
+	flagSkipIndex        = "skip-index"
+	flagIndexOnly        = "index-only"
+	// flagRegionCacheCapacity is the max count of the regions cached by restore.
+	flagRegionCacheCapacity = "region-cache-capacity"
+
+	flagResume = "resume"
+	flagStrict = "strict"
+	// flagRestoreStores is the IDs of the stores labeled for online restore.
+	flagRestoreStores = "restore-stores"
+	flagDryRunLabels  = "dry-run-labels"
+	// flagDumpRegions is the path of the region layout dumped for debugging.
+	flagDumpRegions = "dump-regions"
+	// flagDDLAuditLog is the path of the audit log of the executed statements.
+	flagDDLAuditLog = "ddl-audit-log"
+	// flagImportBackend is the backend importing the files.
+	flagImportBackend = "import-backend"
+	// flagTableRetry is the count of the retries of the failed files of a table.
+	flagTableRetry = "table-retry"
+
+	// flagWaitTiFlash waits for the TiFlash replicas of the restored tables.
+	flagWaitTiFlash        = "wait-tiflash"
+	flagWaitTiFlashTimeout = "wait-tiflash-timeout"
+
+	// flagRegionTopology is the path of the multi-region topology file.
+	flagRegionTopology        = "region-topology"
+	flagRegionTopologyTimeout = "region-topology-timeout"
+
+	// flagPerStoreInflight is the max count of the requests in flight to a store.
+	flagPerStoreInflight = "per-store-inflight"
+
+	flagIngestTimeoutPerMB = "ingest-timeout-per-mb"
+	flagIngestTimeoutMin   = "ingest-timeout-min"
+	flagIngestTimeoutMax   = "ingest-timeout-max"
 
 	defaultRestoreConcurrency = 128
 	maxRestoreBatchSizeLimit  = 10240
 	defaultDDLConcurrency     = 16
+
+	// checksumSpeedPerConcurrency is the estimated speed of `ADMIN CHECKSUM`
+	// on a single table, used to estimate the time taken by the full checksum.
+	checksumSpeedPerConcurrency = 128 * utils.MB
+)
+
+type checksumMode int
+
+const (
+	// checksumModeSkip doesn't verify the restored data at all.
+	checksumModeSkip checksumMode = iota
+	// checksumModeFull runs `ADMIN CHECKSUM` on every restored table.
+	checksumModeFull
+	// checksumModeBackupOnly only checks the checksums of the backup files
+	// add up to the table checksums recorded in the backup meta, the
+	// restored data isn't verified.
+	checksumModeBackupOnly
 )
 
 // RestoreConfig is the configuration specific for restore tasks.
@@ -40,16 +104,185 @@ type RestoreConfig struct {
 
 	Online   bool `json:"online" toml:"online"`
 	NoSchema bool `json:"no-schema" toml:"no-schema"`
+	// OnlineLearner makes the online restore ingest into learners on the
+	// restore stores, and promote them after the tables are restored.
+	OnlineLearner bool `json:"online-learner" toml:"online-learner"`
+	// OnlineEvictLeaders is the max count of the stores receiving the
+	// heaviest ingest to evict the leaders from during online restore, zero
+	// means no eviction.
+	OnlineEvictLeaders int `json:"online-evict-leaders" toml:"online-evict-leaders"`
+	// ChecksumBudget is the max duration the full checksum is expected to take,
+	// only the checksums of the backup files are checked if it's exceeded.
+	// Zero means no limit.
+	ChecksumBudget time.Duration `json:"checksum-budget" toml:"checksum-budget"`
+	// ChecksumSampleRate is the ratio of the tables verified by the full
+	// checksum, the zero value means all tables.
+	ChecksumSampleRate float64 `json:"checksum-sample-rate" toml:"checksum-sample-rate"`
+	// ChecksumSampleSeed decides the tables sampled, the zero value means a
+	// random seed, which is recorded in the summary.
+	ChecksumSampleSeed int64 `json:"checksum-sample-seed" toml:"checksum-sample-seed"`
+	// PlacementMapping is the path of the file mapping tables to placement rules.
+	PlacementMapping string `json:"placement-mapping" toml:"placement-mapping"`
+	// SplitKeysFile is the path of the file of the keys to split the regions
+	// at before the tables are restored.
+	SplitKeysFile string `json:"split-keys-file" toml:"split-keys-file"`
+	// ScatterPriority is the priority of the scatter operators created by restore.
+	ScatterPriority restore.ScatterPriority `json:"scatter-priority" toml:"scatter-priority"`
+	// SkipIndex restores the rows only, and rebuilds the secondary indexes
+	// by ADD INDEX after the rows are restored.
+	SkipIndex bool `json:"skip-index" toml:"skip-index"`
+	// IndexOnly restores the indexes only into the existing tables.
+	IndexOnly bool `json:"index-only" toml:"index-only"`
+	// ClusterSettings is how the cluster settings in the backup are handled.
+	ClusterSettings ClusterSettingsMode `json:"cluster-settings" toml:"cluster-settings"`
+	// RegionCacheCapacity is the max count of the regions cached by restore,
+	// zero means the default capacity and a negative value disables the cache.
+	RegionCacheCapacity int `json:"region-cache-capacity" toml:"region-cache-capacity"`
+	// IngestTimeout is the timeout of the download and ingest RPCs of a file,
+	// the zero value means no timeout.
+	IngestTimeout restore.IngestTimeout `json:"ingest-timeout" toml:"ingest-timeout"`
+	// PerStoreInflight is the max count of the download and ingest requests
+	// in flight to a store, zero means no limit.
+	PerStoreInflight uint `json:"per-store-inflight" toml:"per-store-inflight"`
+	// WaitTiFlash waits until the TiFlash replicas requested by the restored
+	// tables are available before the restore returns, at most for
+	// WaitTiFlashTimeout, zero means no limit.
+	WaitTiFlash        bool          `json:"wait-tiflash" toml:"wait-tiflash"`
+	WaitTiFlashTimeout time.Duration `json:"wait-tiflash-timeout" toml:"wait-tiflash-timeout"`
+	// RegionTopology is the path of the file of the multi-region topology the
+	// databases are restored in, see restore.RegionTopology. The placement is
+	// verified for at most RegionTopologyTimeout, zero means no limit.
+	RegionTopology        string        `json:"region-topology" toml:"region-topology"`
+	RegionTopologyTimeout time.Duration `json:"region-topology-timeout" toml:"region-topology-timeout"`
+	// Resume resumes the restore from the checkpoint in the storage.
+	Resume bool `json:"resume" toml:"resume"`
+	// NonStrictChecksum only reports the restored tables whose checksums
+	// deviate from the backup, instead of failing the restore.
+	NonStrictChecksum bool `json:"non-strict-checksum" toml:"non-strict-checksum"`
+	// RestoreStores is the stores labeled as the restore stores of the online
+	// restore, their original labels are rolled back after the restore.
+	RestoreStores []uint64 `json:"restore-stores" toml:"restore-stores"`
+	// SQL is the config of the logical restore through the SQL interface.
+	SQL RestoreSQLConfig `json:"sql" toml:"sql"`
+	// DumpRegions is the path of the file the region layout of the restored
+	// ranges is dumped into, empty means not dumping.
+	DumpRegions string `json:"dump-regions" toml:"dump-regions"`
+	// DDLAuditLog is the path of the file the statements executed during
+	// restore are recorded into, empty means not recording.
+	DDLAuditLog string `json:"ddl-audit-log" toml:"ddl-audit-log"`
+	// ImportBackend is the backend importing the files, see
+	// restore.ImportBackend. Empty means the default backend.
+	ImportBackend string `json:"import-backend" toml:"import-backend"`
+	// TableRetry is the count of the table retries of a table whose files
+	// exhausted their retry budget, every retry imports the failed files of
+	// the table again. Zero means failing the restore at once.
+	TableRetry int `json:"table-retry" toml:"table-retry"`
+	// ScanVerify verifies the restored ranges of the txn restore by scanning
+	// them.
+	ScanVerify ScanVerifyConfig `json:"scan-verify" toml:"scan-verify"`
+	// DryRunLabels reports the store label changes and exits without
+	// restoring.
+	DryRunLabels bool `json:"dry-run-labels" toml:"dry-run-labels"`
+	// SchemaOnly creates the schemas and tables without restoring the data,
+	// so nothing preparing the cluster for the data, e.g. the global
+	// variables, the store labels, the bandwidth budget and the GC safepoint,
+	// is changed.
+	SchemaOnly bool `json:"schema-only" toml:"schema-only"`
 }
 
 // DefineRestoreFlags defines common flags for the restore command.
 func DefineRestoreFlags(flags *pflag.FlagSet) {
 	// TODO remove experimental tag if it's stable
 	flags.Bool(flagOnline, false, "(experimental) Whether online when restore")
+	flags.Bool(flagOnlineLearner, false,
+		"(experimental) with --online, move the leaders to the restore stores and ingest into them and "+
+			"the learners there under temporary placement rules, and promote the learners afterwards, "+
+			"instead of moving the serving voters first")
+	flags.Int(flagOnlineEvictLeaders, 0,
+		"(experimental) with --online, temporarily move the leaders away from at most this count of "+
+			"the stores receiving the heaviest ingest, and move them back afterwards, 0 means never")
 	flags.Bool(flagNoSchema, false, "skip creating schemas and tables, reuse existing empty ones")
+	flags.Duration(flagChecksumBudget, 0,
+		"the time budget of checksum, if the full checksum of the restored tables is estimated to exceed it, "+
+			"only the checksums of the backup files are checked against the backupmeta and the restored data "+
+			"isn't verified, 0 means no limit")
+	flags.Float64(flagChecksumSampleRate, 1,
+		"the ratio of the tables verified by the full checksum, e.g. 0.1 verifies a random 10% of the tables, "+
+			"the seed of the sample is recorded in the summary")
+	flags.Int64(flagChecksumSampleSeed, 0,
+		"the seed of --checksum-sample-rate to reproduce a sample, 0 means a random seed")
+	flags.String(flagPlacementMapping, "",
+		"the path of a JSON file mapping `db.table` or `db.*` to placement rule templates, "+
+			"which are applied as tables are restored")
+	DefineSplitKeysFlags(flags)
+	flags.String(flagScatterPriority, string(restore.ScatterPriorityNormal),
+		"the priority of scattering restored regions relative to routine balancing of PD, "+
+			"value can be one of 'high|normal|low'")
+	flags.Bool(flagSkipIndex, false,
+		"restore the row data only, and rebuild the secondary indexes by ADD INDEX afterwards")
+	flags.Bool(flagIndexOnly, false,
+		"restore the indexes only, the tables and their row data should have been restored")
+	flags.String(flagClusterSettings, string(ClusterSettingsIgnore),
+		"how to handle the global variables and the PD schedule config saved in the backup, "+
+			"value can be one of 'ignore|review|apply', 'review' only logs the settings differing from the backup")
+	flags.Bool(flagResume, false,
+		"record the restore checkpoint in the storage, so the restore can be resumed or cleaned up by "+
+			"`br restore abort`, and resume from the checkpoint of the previous run if any, "+
+			"the checksums of the tables verified, the regions scattered and the files ingested "+
+			"by the previous runs are skipped")
+	flags.Bool(flagStrict, true,
+		"fail the restore if the checksum of a restored table deviates from the backup, "+
+			"otherwise the deviations are only reported at the end of the restore")
+	flags.UintSlice(flagRestoreStores, nil,
+		"with --online, the IDs of the stores to label as the restore stores, "+
+			"their original labels are rolled back when the restore completes or fails")
+	flags.Bool(flagDryRunLabels, false,
+		"report the store label changes of --restore-stores and exit without changing the cluster")
+	flags.Int(flagRegionCacheCapacity, restore.DefaultRegionCacheCapacity,
+		"the max count of the regions cached by restore, a negative value disables the cache")
+	flags.Duration(flagIngestTimeoutPerMB, 0,
+		"the timeout budget of every MB of a file for downloading and ingesting it, "+
+			"the timeout of a file is between --ingest-timeout-min and --ingest-timeout-max, "+
+			"all of them 0 means no timeout")
+	flags.Duration(flagIngestTimeoutMin, 0,
+		"the min timeout of downloading and ingesting a file")
+	flags.Duration(flagIngestTimeoutMax, 0,
+		"the max timeout of downloading and ingesting a file, it applies to every file if set alone, "+
+			"0 means no limit")
+	flags.Bool(flagWaitTiFlash, false,
+		"wait until the TiFlash replicas requested by the restored tables are available before returning, "+
+			"so the cluster is ready for the analytics traffic once the restore succeeds")
+	flags.Duration(flagWaitTiFlashTimeout, 0,
+		"the max duration of --wait-tiflash, the restore fails if it's exceeded, 0 means no limit")
+	flags.String(flagRegionTopology, "",
+		"the path of a JSON file of the multi-region topology, i.e. the primary region and the replica regions "+
+			"of the restored databases, which is applied as placement rules and verified before the restore succeeds")
+	flags.Duration(flagRegionTopologyTimeout, 30*time.Minute,
+		"the max duration of verifying the placement of --region-topology, the restore fails if it's exceeded, "+
+			"0 means no limit")
+	flags.Uint(flagPerStoreInflight, 0,
+		"the max count of the download and ingest requests in flight to a store, so an overloaded store "+
+			"can't hold an unfair share of the --concurrency while the others idle, 0 means no limit")
+
+	flags.String(flagDumpRegions, "",
+		"(debug) dump the region layout of the restored ranges before split, after split and after scatter "+
+			"into the file as gzipped JSON, for analyzing the balance problems offline")
+	flags.String(flagDDLAuditLog, "",
+		"record every statement executed during restore with the timing, attempts and the created table IDs "+
+			"into the file as JSON, for auditing what BR did to the cluster")
+	flags.String(flagImportBackend, restore.DefaultImportBackend,
+		"the backend importing the files, 'tikv' ingests them into TiKV directly, 'sql' inserts the rows "+
+			"through --sql-dsn, the others are registered by the applications embedding BR, e.g. to drive "+
+			"the import API of a managed service. The backends other than 'tikv' don't access PD or TiKV")
+	flags.Int(flagTableRetry, 0,
+		"import the failed files of a table again at most this count of times after the other tables are "+
+			"restored, if some of its files still fail after their retries, 0 means failing the restore at once")
+
+	DefineRestoreSQLFlags(flags)
 
 	// Do not expose this flag
 	_ = flags.MarkHidden(flagNoSchema)
+	_ = flags.MarkHidden(flagRegionCacheCapacity)
 }
 
 // ParseFromFlags parses the restore-related flags from the flag set.
@@ -63,6 +296,158 @@ func (cfg *RestoreConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	cfg.OnlineLearner, err = flags.GetBool(flagOnlineLearner)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.OnlineLearner && !cfg.Online {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s requires --%s", flagOnlineLearner, flagOnline)
+	}
+	cfg.OnlineEvictLeaders, err = flags.GetInt(flagOnlineEvictLeaders)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.OnlineEvictLeaders < 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "negative --%s is not allowed", flagOnlineEvictLeaders)
+	}
+	if cfg.OnlineEvictLeaders > 0 && !cfg.Online {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s requires --%s", flagOnlineEvictLeaders, flagOnline)
+	}
+	cfg.ChecksumBudget, err = flags.GetDuration(flagChecksumBudget)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ChecksumBudget < 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "negative checksum-budget is not allowed")
+	}
+	if err = cfg.parseChecksumSampleFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.PlacementMapping, err = flags.GetString(flagPlacementMapping)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.SplitKeysFile, err = flags.GetString(flagSplitKeysFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if flags.Lookup(flagDumpRegions) != nil {
+		cfg.DumpRegions, err = flags.GetString(flagDumpRegions)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if flags.Lookup(flagDDLAuditLog) != nil {
+		cfg.DDLAuditLog, err = flags.GetString(flagDDLAuditLog)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if flags.Lookup(flagImportBackend) != nil {
+		cfg.ImportBackend, err = flags.GetString(flagImportBackend)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = restore.CheckImportBackend(cfg.ImportBackend); err != nil {
+			return errors.Trace(err)
+		}
+		cfg.ImportBackend = strings.ToLower(cfg.ImportBackend)
+	}
+	cfg.ScatterPriority, err = parseScatterPriority(flags)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.parseIndexModeFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if flags.Lookup(flagTableRetry) != nil {
+		cfg.TableRetry, err = flags.GetInt(flagTableRetry)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if cfg.TableRetry < 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "negative --%s is not allowed", flagTableRetry)
+		}
+		// The indexes are rebuilt from the rows before the failed files are
+		// imported again.
+		if cfg.TableRetry > 0 && cfg.SkipIndex {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s is incompatible with --%s", flagTableRetry, flagSkipIndex)
+		}
+	}
+	cfg.ClusterSettings, err = parseClusterSettingsMode(flags)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.IngestTimeout, err = parseIngestTimeout(flags)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.PerStoreInflight, err = parsePerStoreInflight(flags)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if flags.Lookup(flagWaitTiFlash) != nil {
+		cfg.WaitTiFlash, err = flags.GetBool(flagWaitTiFlash)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg.WaitTiFlashTimeout, err = flags.GetDuration(flagWaitTiFlashTimeout)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if cfg.WaitTiFlashTimeout < 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "--%s can't be negative", flagWaitTiFlashTimeout)
+		}
+	}
+	if flags.Lookup(flagRegionTopology) != nil {
+		cfg.RegionTopology, err = flags.GetString(flagRegionTopology)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg.RegionTopologyTimeout, err = flags.GetDuration(flagRegionTopologyTimeout)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if cfg.RegionTopologyTimeout < 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "--%s can't be negative", flagRegionTopologyTimeout)
+		}
+		if cfg.RegionTopology != "" && cfg.PlacementMapping != "" {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s and --%s can't be used together", flagRegionTopology, flagPlacementMapping)
+		}
+	}
+	if flags.Lookup(flagResume) != nil {
+		cfg.Resume, err = flags.GetBool(flagResume)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if flags.Lookup(flagStrict) != nil {
+		strict, err := flags.GetBool(flagStrict)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg.NonStrictChecksum = !strict
+	}
+	if err = cfg.parseRestoreStoresFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if flags.Lookup(flagRegionCacheCapacity) != nil {
+		cfg.RegionCacheCapacity, err = flags.GetInt(flagRegionCacheCapacity)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err = cfg.SQL.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.adjustImportBackend(); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.ScanVerify.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
 	err = cfg.Config.ParseFromFlags(flags)
 	if err != nil {
 		return errors.Trace(err)
@@ -74,6 +459,197 @@ func (cfg *RestoreConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 	return nil
 }
 
+// parseChecksumSampleFromFlags parses the checksum sample flags, they're
+// defined in the persistent flags of the restore command, so they may be
+// missing in tests.
+func (cfg *RestoreConfig) parseChecksumSampleFromFlags(flags *pflag.FlagSet) error {
+	if flags.Lookup(flagChecksumSampleRate) == nil {
+		return nil
+	}
+	var err error
+	cfg.ChecksumSampleRate, err = flags.GetFloat64(flagChecksumSampleRate)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !(cfg.ChecksumSampleRate > 0 && cfg.ChecksumSampleRate <= 1) {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s must be in (0, 1], got %v", flagChecksumSampleRate, cfg.ChecksumSampleRate)
+	}
+	cfg.ChecksumSampleSeed, err = flags.GetInt64(flagChecksumSampleSeed)
+	return errors.Trace(err)
+}
+
+// checksumSampler returns the sampler of the tables to verify, nil means all
+// tables are verified.
+func (cfg *RestoreConfig) checksumSampler() *restore.ChecksumSampler {
+	if cfg.ChecksumSampleRate <= 0 || cfg.ChecksumSampleRate >= 1 {
+		return nil
+	}
+	seed := cfg.ChecksumSampleSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return restore.NewChecksumSampler(cfg.ChecksumSampleRate, seed)
+}
+
+// regionCacheCapacity returns the capacity of the region cache, the zero
+// value is left by the callers other than the command line, e.g. TiDB.
+func (cfg *RestoreConfig) regionCacheCapacity() int {
+	switch {
+	case cfg.RegionCacheCapacity == 0:
+		return restore.DefaultRegionCacheCapacity
+	case cfg.RegionCacheCapacity < 0:
+		return 0
+	default:
+		return cfg.RegionCacheCapacity
+	}
+}
+
+// parseRestoreStoresFromFlags parses the store label flags, they're defined in
+// the persistent flags of the restore command, so they may be missing in tests.
+func (cfg *RestoreConfig) parseRestoreStoresFromFlags(flags *pflag.FlagSet) error {
+	if flags.Lookup(flagRestoreStores) == nil {
+		return nil
+	}
+	stores, err := flags.GetUintSlice(flagRestoreStores)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.RestoreStores = make([]uint64, 0, len(stores))
+	for _, id := range stores {
+		cfg.RestoreStores = append(cfg.RestoreStores, uint64(id))
+	}
+	if len(cfg.RestoreStores) > 0 && !cfg.Online {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s requires --%s", flagRestoreStores, flagOnline)
+	}
+	cfg.DryRunLabels, err = flags.GetBool(flagDryRunLabels)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.DryRunLabels && len(cfg.RestoreStores) == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s requires --%s", flagDryRunLabels, flagRestoreStores)
+	}
+	return nil
+}
+
+// parseIngestTimeout parses the ingest timeout flags, they're defined in the
+// persistent flags of the restore command, so they may be missing in tests.
+func parseIngestTimeout(flags *pflag.FlagSet) (restore.IngestTimeout, error) {
+	var (
+		timeout restore.IngestTimeout
+		err     error
+	)
+	if flags.Lookup(flagIngestTimeoutPerMB) == nil {
+		return timeout, nil
+	}
+	timeout.PerMB, err = flags.GetDuration(flagIngestTimeoutPerMB)
+	if err != nil {
+		return timeout, errors.Trace(err)
+	}
+	timeout.Min, err = flags.GetDuration(flagIngestTimeoutMin)
+	if err != nil {
+		return timeout, errors.Trace(err)
+	}
+	timeout.Max, err = flags.GetDuration(flagIngestTimeoutMax)
+	if err != nil {
+		return timeout, errors.Trace(err)
+	}
+	if timeout.PerMB < 0 || timeout.Min < 0 || timeout.Max < 0 {
+		return timeout, errors.Annotate(berrors.ErrInvalidArgument, "the ingest timeout can't be negative")
+	}
+	if timeout.Max > 0 && timeout.Min > timeout.Max {
+		return timeout, errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s %s is greater than --%s %s", flagIngestTimeoutMin, timeout.Min, flagIngestTimeoutMax, timeout.Max)
+	}
+	return timeout, nil
+}
+
+// adjustImportBackend selects the SQL import backend by --sql-dsn.
+func (cfg *RestoreConfig) adjustImportBackend() error {
+	switch cfg.ImportBackend {
+	case "", restore.DefaultImportBackend:
+		if cfg.SQL.DSN != "" {
+			cfg.ImportBackend = restore.SQLImportBackend
+		}
+	case restore.SQLImportBackend:
+		if cfg.SQL.DSN == "" {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"the %s import backend requires --%s", restore.SQLImportBackend, flagSQLDSN)
+		}
+	default:
+		if cfg.SQL.DSN != "" {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s requires the %s import backend", flagSQLDSN, restore.SQLImportBackend)
+		}
+	}
+	return nil
+}
+
+// importByBackend returns whether the restore is done by an import backend
+// without accessing PD or TiKV.
+func (cfg *RestoreConfig) importByBackend() bool {
+	return cfg.ImportBackend != "" && cfg.ImportBackend != restore.DefaultImportBackend
+}
+
+// parsePerStoreInflight parses the per-store inflight flag, it's defined in the
+// persistent flags of the restore command, so it may be missing in tests.
+func parsePerStoreInflight(flags *pflag.FlagSet) (uint, error) {
+	if flags.Lookup(flagPerStoreInflight) == nil {
+		return 0, nil
+	}
+	limit, err := flags.GetUint(flagPerStoreInflight)
+	return limit, errors.Trace(err)
+}
+
+// parseScatterPriority parses the scatter priority flag, it's defined in the
+// persistent flags of the restore command, so it may be missing in tests.
+func parseScatterPriority(flags *pflag.FlagSet) (restore.ScatterPriority, error) {
+	if flags.Lookup(flagScatterPriority) == nil {
+		return restore.ScatterPriorityNormal, nil
+	}
+	priority, err := flags.GetString(flagScatterPriority)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	p, err := restore.ParseScatterPriority(priority)
+	return p, errors.Trace(err)
+}
+
+// parseIndexModeFromFlags parses the index restore mode flags, they are
+// defined in the persistent flags of the restore command, so they may be
+// missing in tests.
+func (cfg *RestoreConfig) parseIndexModeFromFlags(flags *pflag.FlagSet) error {
+	if flags.Lookup(flagSkipIndex) == nil {
+		return nil
+	}
+	var err error
+	cfg.SkipIndex, err = flags.GetBool(flagSkipIndex)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.IndexOnly, err = flags.GetBool(flagIndexOnly)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.SkipIndex && cfg.IndexOnly {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s and --%s are mutually exclusive", flagSkipIndex, flagIndexOnly)
+	}
+	return nil
+}
+
+// indexRestoreMode returns the index restore mode of the config.
+func (cfg *RestoreConfig) indexRestoreMode() restore.IndexRestoreMode {
+	switch {
+	case cfg.SkipIndex:
+		return restore.RestoreSkipIndex
+	case cfg.IndexOnly:
+		return restore.RestoreIndexOnly
+	default:
+		return restore.RestoreRowsAndIndexes
+	}
+}
+
 // adjustRestoreConfig is use for BR(binary) and BR in TiDB.
 // When new config was add and not included in parser.
 // we should set proper value in this function.
@@ -89,50 +665,173 @@ func (cfg *RestoreConfig) adjustRestoreConfig() {
 	}
 }
 
+// CmdSchemaRestore is the name of the restore command which creates the
+// schemas and tables only.
+const CmdSchemaRestore = "Schema restore"
+
 // RunRestore starts a restore task inside the current goroutine.
 func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConfig) error {
+	if cfg.importByBackend() {
+		// The import backends don't access PD or TiKV.
+		return RunRestoreByBackend(c, g, cmdName, cfg)
+	}
 	cfg.adjustRestoreConfig()
 
 	defer summary.Summary(cmdName)
-	ctx, cancel := context.WithCancel(c)
+	// The gRPC messages are counted per task.
+	wireStats := &utils.GRPCWireStats{}
+	defer collectGRPCCompression(&cfg.Config, wireStats)
+	// The BRIE statements of TiDB may restore concurrently, each of them has
+	// its own task ID.
+	ctx, cancel := context.WithCancel(logutil.EnsureTaskID(c))
 	defer cancel()
 
-	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements)
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.GRPCMaxMsgSize(), cfg.CheckRequirements)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	defer mgr.Close()
+	if err = applyProfileOfCluster(ctx, mgr, &cfg.Config, true); err != nil {
+		return errors.Trace(err)
+	}
 
 	keepaliveCfg := GetKeepalive(&cfg.Config)
 	keepaliveCfg.PermitWithoutStream = true
-	client, err := restore.NewRestoreClient(g, mgr.GetPDClient(), mgr.GetTiKV(), mgr.GetTLSConfig(), keepaliveCfg)
+	client, err := restore.NewRestoreClient(g, mgr.GetPDClient(), mgr.GetTiKV(), mgr.GetPDTLSConfig(), mgr.GetTLSConfig(), keepaliveCfg, mgr.GetGRPCMaxMsgSize())
 	if err != nil {
 		return errors.Trace(err)
 	}
 	defer client.Close()
+	client.SetTaskID(logutil.TaskIDFromContext(ctx))
+	client.SetGRPCCompression(cfg.GRPCCompression, wireStats)
 
 	u, err := storage.ParseBackend(cfg.Storage, &cfg.BackendOptions)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	if err = client.SetStorage(ctx, u, cfg.SendCreds); err != nil {
+	opts, err := cfg.StorageOptions()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = client.SetStorage(ctx, u, opts); err != nil {
 		return errors.Trace(err)
 	}
 	client.SetRateLimit(cfg.RateLimit)
+	if !cfg.SchemaOnly {
+		budget, leaveBudget, err := joinBandwidthBudget(ctx, &cfg.Config)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer leaveBudget()
+		client.SetBandwidthBudget(budget)
+	}
+	tuning, unsetTuning := exposeLiveTuning(&cfg.Config)
+	defer unsetTuning()
+	client.SetLiveTuning(tuning)
 	client.SetConcurrency(uint(cfg.Concurrency))
 	if cfg.Online {
 		client.EnableOnline()
 	}
+	if cfg.OnlineLearner {
+		client.EnableLearnerIngest()
+	}
 	if cfg.NoSchema {
 		client.EnableSkipCreateSQL()
 	}
+	client.SetIndexRestoreMode(cfg.indexRestoreMode())
+	client.SetTableRetry(cfg.TableRetry)
 	client.SetSwitchModeInterval(cfg.SwitchModeInterval)
+	client.SetRegionCacheCapacity(cfg.regionCacheCapacity())
+	client.SetIngestTimeout(cfg.IngestTimeout)
+	client.SetPerStoreInflight(cfg.PerStoreInflight)
+	if cfg.ScatterPriority != "" {
+		client.SetScatterPriority(cfg.ScatterPriority)
+	}
+	storeLabels, done, err := labelRestoreStores(ctx, client, cfg)
+	// Roll back the labels applied even on error.
+	defer rollbackStoreLabels(ctx, client, storeLabels)
+	if err != nil || done {
+		return errors.Trace(err)
+	}
 	err = client.LoadRestoreStores(ctx)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if cfg.PlacementMapping != "" {
+		data, err := ioutil.ReadFile(cfg.PlacementMapping)
+		if err != nil {
+			return errors.Annotatef(err, "failed to read placement mapping file %s", cfg.PlacementMapping)
+		}
+		mapping, err := restore.ParsePlacementMapping(data)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("placement mapping loaded", zap.Int("templates", mapping.Len()))
+		client.SetPlacementMapping(mapping)
+	}
+	if cfg.RegionTopology != "" {
+		data, err := ioutil.ReadFile(cfg.RegionTopology)
+		if err != nil {
+			return errors.Annotatef(err, "failed to read region topology file %s", cfg.RegionTopology)
+		}
+		topology, err := restore.ParseRegionTopology(data)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		maxReplicas, err := mgr.GetMaxReplicas(ctx)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = topology.CheckReplicas(maxReplicas); err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("region topology loaded",
+			zap.String("primary", topology.Primary),
+			zap.Strings("replicas", topology.Replicas),
+			zap.Int("databases", len(topology.Databases)))
+		client.SetRegionTopology(topology)
+	}
+	if cfg.DumpRegions != "" {
+		dumper := restore.NewRegionDumper()
+		client.SetRegionDumper(dumper)
+		// Dump the layout even on error, it's for the post-mortem analysis.
+		defer func() {
+			if err := dumper.Save(cfg.DumpRegions); err != nil {
+				log.Warn("failed to dump the region layout", zap.String("file", cfg.DumpRegions), zap.Error(err))
+				return
+			}
+			log.Info("region layout dumped", zap.String("file", cfg.DumpRegions),
+				zap.Int("snapshots", len(dumper.Snapshots())))
+		}()
+	}
+	var ddlAudit *restore.DDLAuditLog
+	if cfg.DDLAuditLog != "" {
+		ddlAudit = restore.NewDDLAuditLog()
+		client.SetDDLAuditLog(ddlAudit)
+		// Save the log even on error, the partially applied statements matter.
+		defer func() {
+			if err := ddlAudit.Save(cfg.DDLAuditLog); err != nil {
+				log.Warn("failed to save the ddl audit log", zap.String("file", cfg.DDLAuditLog), zap.Error(err))
+				return
+			}
+			log.Info("ddl audit log saved", zap.String("file", cfg.DDLAuditLog),
+				zap.Int("statements", len(ddlAudit.Entries())))
+		}()
+	}
+	splitKeys, err := loadSplitKeys(cfg.SplitKeysFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	evacuator, err := newLeaderEvacuator(ctx, mgr, cfg.OnlineEvictLeaders)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if evacuator != nil {
+		// The ingest load must be set before the file importer is created.
+		client.SetStoreIngestLoad(evacuator.Load())
+	}
 
-	u, _, backupMeta, err := ReadBackupMeta(ctx, utils.MetaFile, &cfg.Config)
+	u, s, backupMeta, err := ReadBackupMeta(ctx, utils.MetaFile, &cfg.Config)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -140,15 +839,59 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 	if err = client.InitBackupMeta(backupMeta, u); err != nil {
 		return errors.Trace(err)
 	}
+	format, err := utils.ReadBackupFormat(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	backupSchemaOnly := format != nil && format.SchemaOnly
+	schemaOnly := cfg.SchemaOnly || backupSchemaOnly
 
 	if client.IsRawKvMode() {
 		return errors.Annotate(berrors.ErrRestoreModeMismatch, "cannot do transactional restore from raw kv data")
 	}
+	// The checkpoint is only recorded on demand, the restore lock keeps the
+	// other restores from the backup from overwriting it. It records the
+	// tables verified, the regions scattered and the files ingested, so the
+	// resumed restore skips them.
+	var recorder *restore.CheckpointRecorder
+	if cfg.Resume {
+		release, err := lockRestoreSource(ctx, cancel, &cfg.Config, u)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer release()
+		if recorder, err = resumeRestoreCheckpoint(ctx, mgr, s, cfg.Online); err != nil {
+			return errors.Trace(err)
+		}
+		// Record the progress even on error. The context may have been
+		// canceled.
+		defer recorder.Save(context.Background())
+	}
+	client.SetScatterCache(restore.NewScatterCache(recorder))
+	client.SetIngestCache(restore.NewIngestCache(recorder))
+	client.SetChecksumCache(restore.NewChecksumCache(recorder))
+	// The sidecar files are encrypted as the backupmeta.
+	sidecar, err := openMetaStorage(ctx, &cfg.Config, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// Apply the settings before pausing the schedulers, so they wouldn't be
+	// overwritten when the schedulers are resumed.
+	if err = restoreClusterSettings(ctx, g, mgr, sidecar, cfg.clusterSettingsMode(schemaOnly)); err != nil {
+		return errors.Trace(err)
+	}
+	checkClusterTopology(ctx, mgr, sidecar)
 
 	files, tables, dbs := filterRestoreFiles(client, cfg)
 	if len(dbs) == 0 && len(tables) != 0 {
 		return errors.Annotate(berrors.ErrRestoreInvalidBackup, "contain tables but no databases")
 	}
+	if mode := cfg.indexRestoreMode(); mode != restore.RestoreRowsAndIndexes {
+		filtered := restore.FilterFilesByIndexMode(files, tables, mode)
+		log.Info("filter files by index restore mode",
+			zap.Int("files", len(files)), zap.Int("filtered", len(files)-len(filtered)))
+		files = filtered
+	}
 
 	restoreTS, err := client.GetTS(ctx)
 	if err != nil {
@@ -163,19 +906,24 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 	// restore checksum will check safe point with its start ts, see details at
 	// https://github.com/pingcap/tidb/blob/180c02127105bed73712050594da6ead4d70a85f/store/tikv/kv.go#L186-L190
 	// so, we should keep the safe point unchangeable. to avoid GC life time is shorter than transaction duration.
-	utils.StartServiceSafePointKeeper(ctx, mgr.GetPDClient(), sp)
+	// Creating the schemas only doesn't checksum.
+	if !schemaOnly {
+		utils.StartServiceSafePointKeeper(ctx, mgr.GetPDClient(), sp)
+	}
 
 	var newTS uint64
 	if client.IsIncremental() {
 		newTS = restoreTS
 	}
 	ddlJobs := restore.FilterDDLJobs(client.GetDDLJobs(), tables)
+	ddlJobs = restore.FilterReplayDDLJobs(ddlJobs, cfg.TableFilter)
 
 	// pre-set TiDB config for restore
 	restoreDBConfig := enableTiDBConfig()
 	defer restoreDBConfig()
 
-	// execute DDL first
+	// execute DDL first, the schema stage ends after creating the tables.
+	summary.RegisterStage(summary.StageSchema)
 	err = client.ExecDDLs(ctx, ddlJobs)
 	if err != nil {
 		return errors.Trace(err)
@@ -208,7 +956,11 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 		// Only in binary we can use multi-thread sessions to create tables.
 		// so use OwnStorage() to tell whether we are use binary or SQL.
 		dbPool, err = restore.MakeDBPool(defaultDDLConcurrency, func() (*restore.DB, error) {
-			return restore.NewDB(g, mgr.GetTiKV())
+			db, err := restore.NewDB(g, mgr.GetTiKV())
+			if err == nil && db != nil {
+				db.SetDDLAuditLog(ddlAudit)
+			}
+			return db, err
 		})
 	}
 	if err != nil {
@@ -218,11 +970,26 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 		)
 	}
 	tableStream := client.GoCreateTables(ctx, mgr.GetDomain(), tables, newTS, dbPool, errCh)
+	if backupSchemaOnly && !cfg.SchemaOnly {
+		log.Warn("the backup has the schemas without the data, the tables are created without data")
+		summary.CollectWarning("tables restored without data from the schema-only backup", len(tables))
+	}
+	if schemaOnly {
+		return errors.Trace(waitTablesCreated(ctx, g, cmdName, cfg, tableStream, len(tables), errCh))
+	}
 	if len(files) == 0 {
 		log.Info("no files, empty databases and tables are restored")
 		summary.SetSuccessStatus(true)
 		// don't return immediately, wait all pipeline done.
 	}
+	if splitKeys != nil {
+		// The raw keys are split at once, and the handles of the tables are
+		// split at as the tables are created, before their ranges.
+		if err = client.SplitKeys(ctx, splitKeys.RawKeys()); err != nil {
+			return errors.Trace(err)
+		}
+		tableStream = client.GoSplitTableKeys(ctx, splitKeys, tableStream, errCh)
+	}
 
 	tableFileMap := restore.MapTableToFiles(files)
 	log.Debug("mapped table to files", zap.Any("result map", tableFileMap))
@@ -233,13 +1000,29 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 	summary.CollectInt("restore ranges", rangeSize)
 	log.Info("range and file prepared", zap.Int("file count", len(files)), zap.Int("range count", rangeSize))
 
-	restoreSchedulers, err := restorePreWork(ctx, client, mgr)
+	restoreSchedulers, pdConfig, err := restorePreWork(ctx, client, mgr, &cfg.Config)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	// Always run the post-work even on error, so we don't stuck in the import
 	// mode or emptied schedulers
 	defer restorePostWork(ctx, client, restoreSchedulers)
+	if evacuator != nil {
+		if err = evacuator.Start(ctx, restore.DefaultLeaderEvacuationInterval); err != nil {
+			return errors.Trace(err)
+		}
+		// Move the leaders back even on error, so the stores aren't left
+		// without leaders.
+		defer evacuator.Stop(context.Background())
+	}
+	recorder.Update(ctx, func(cp *restore.Checkpoint) {
+		cp.SafePointID = sp.ID
+		cp.PDConfig = pdConfig
+		cp.StoreLabels = storeLabels
+	})
+	client.SetNonStrictChecksum(cfg.NonStrictChecksum)
+	sampler := cfg.checksumSampler()
+	client.SetChecksumSampler(sampler)
 
 	// Do not reset timestamp if we are doing incremental restore, because
 	// we are not allowed to decrease timestamp.
@@ -265,7 +1048,12 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 		int64(rangeSize+len(files)+len(tables)),
 		!cfg.LogProgress)
 	defer updateCh.Close()
-	sender, err := restore.NewTiKVSender(ctx, client, updateCh)
+	// Download and ingest are pipelined region by region in a file,
+	// so they are shown as one stage.
+	splitCh := glue.AddStage(updateCh, "split", int64(rangeSize))
+	importCh := glue.AddStage(updateCh, "download&ingest", int64(len(files)))
+	checksumCh := glue.AddStage(updateCh, "checksum", int64(len(tables)))
+	sender, err := restore.NewTiKVSender(ctx, client, splitCh, importCh)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -274,15 +1062,32 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 	batcher.SetThreshold(batchSize)
 	batcher.EnableAutoCommit(ctx, time.Second)
 	go restoreTableStream(ctx, rangeStream, batcher, errCh)
+	if cfg.SkipIndex {
+		afterRestoreStream = client.GoRebuildIndexes(ctx, mgr.GetDomain(), afterRestoreStream, errCh)
+	}
+	if cfg.TableRetry > 0 {
+		afterRestoreStream = client.GoDivertFailedTables(ctx, afterRestoreStream, errCh, checksumCh)
+	}
 
 	var finish <-chan struct{}
 	// Checksum
-	if cfg.Checksum {
+	mode := chooseChecksumMode(cfg, files, client.IsIncremental())
+	switch mode {
+	case checksumModeFull:
 		finish = client.GoValidateChecksum(
-			ctx, afterRestoreStream, mgr.GetTiKV().GetClient(), errCh, updateCh, cfg.ChecksumConcurrency)
-	} else {
+			ctx, afterRestoreStream, mgr.GetTiKV().GetClient(), errCh, checksumCh, cfg.ChecksumConcurrency)
+	case checksumModeBackupOnly:
+		report := client.ChecksumReport()
+		if report.AddBackupFiles(tables) > 0 && !cfg.NonStrictChecksum {
+			report.Log()
+			return errors.Annotate(berrors.ErrBackupChecksumMismatch, "the backup files deviate from the checksums")
+		}
+		summary.CollectWarning("tables restored without verifying their data", len(tables))
+		finish = dropToBlackhole(ctx, afterRestoreStream, errCh, checksumCh)
+	default:
 		// when user skip checksum, just collect tables, and drop them.
-		finish = dropToBlackhole(ctx, afterRestoreStream, errCh, updateCh)
+		client.ChecksumReport().AddUnverified(len(tables))
+		finish = dropToBlackhole(ctx, afterRestoreStream, errCh, checksumCh)
 	}
 
 	select {
@@ -290,17 +1095,171 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 		err = multierr.Append(err, multierr.Combine(restore.Exhaust(errCh)...))
 	case <-finish:
 	}
+	if err == nil && client.DivertedTableCount() > 0 {
+		err = retryFailedTables(ctx, g, mgr, client, cfg, mode == checksumModeFull)
+	}
+	client.ChecksumReport().Log()
+	if mode == checksumModeFull {
+		if sampler != nil {
+			sampled, skipped := sampler.Counts()
+			log.Info("checksum verified a sample of the tables",
+				zap.Float64("rate", cfg.ChecksumSampleRate),
+				zap.Int64("seed", sampler.Seed()),
+				zap.Int("sampled", sampled),
+				zap.Int("skipped", skipped))
+			summary.CollectInt("checksum sample seed", int(sampler.Seed()))
+			summary.CollectInt("checksum sampled tables", sampled)
+			summary.CollectInt("checksum skipped tables", skipped)
+		}
+	}
 
 	// If any error happened, return now.
 	if err != nil {
 		return errors.Trace(err)
 	}
 
+	recorder.Update(ctx, func(cp *restore.Checkpoint) {
+		cp.State = restore.CheckpointFinished
+	})
+	if cfg.WaitTiFlash {
+		if err = waitTiFlashReplicas(ctx, g, mgr, cfg, tables); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if cfg.RegionTopology != "" {
+		if err = waitRegionPlacement(ctx, g, client, cfg); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	// Set task summary to success status.
 	summary.SetSuccessStatus(true)
 	return nil
 }
 
+// waitTiFlashReplicas waits until the TiFlash replicas requested by the
+// restored tables are available, with the progress of the tables.
+func waitTiFlashReplicas(
+	ctx context.Context, g glue.Glue, mgr *conn.Mgr, cfg *RestoreConfig, tables []*utils.Table,
+) error {
+	waiter := restore.NewTiFlashReplicaWaiter(mgr.GetDomain())
+	count, err := waiter.AddTables(tables)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if count == 0 {
+		log.Info("no restored tables request TiFlash replicas")
+		return nil
+	}
+	if cfg.WaitTiFlashTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.WaitTiFlashTimeout)
+		defer cancel()
+	}
+	log.Info("start to wait for TiFlash replicas", zap.Int("tables", count))
+	start := time.Now()
+	updateCh := g.StartProgress(ctx, "Wait TiFlash", int64(count), !cfg.LogProgress)
+	defer updateCh.Close()
+	if err = waiter.Wait(ctx, updateCh); err != nil {
+		return errors.Trace(err)
+	}
+	summary.CollectDuration("wait TiFlash", time.Since(start))
+	return nil
+}
+
+// waitRegionPlacement verifies the regions of the restored tables are placed
+// as the region topology, with the progress of the tables.
+func waitRegionPlacement(ctx context.Context, g glue.Glue, client *restore.Client, cfg *RestoreConfig) error {
+	count := client.PlacedTableCount()
+	if count == 0 {
+		return nil
+	}
+	if cfg.RegionTopologyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.RegionTopologyTimeout)
+		defer cancel()
+	}
+	log.Info("start to verify the region placement", zap.Int("tables", count))
+	start := time.Now()
+	updateCh := g.StartProgress(ctx, "Verify Placement", int64(count), !cfg.LogProgress)
+	defer updateCh.Close()
+	err := client.WaitRegionPlacement(ctx, restore.DefaultRegionPlacementWaitInterval, updateCh)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	summary.CollectDuration("verify placement", time.Since(start))
+	return nil
+}
+
+// retryFailedTables imports the failed files of the tables diverted for the
+// table retry again, it fails with the list of the tables still failed.
+func retryFailedTables(
+	ctx context.Context,
+	g glue.Glue,
+	mgr *conn.Mgr,
+	client *restore.Client,
+	cfg *RestoreConfig,
+	checksum bool,
+) error {
+	count := client.DivertedTableCount()
+	log.Info("start to retry the failed tables", zap.Int("tables", count), zap.Int("retry", cfg.TableRetry))
+	updateCh := g.StartProgress(ctx, "Retry Tables", int64(count), !cfg.LogProgress)
+	failed := client.RetryFailedTables(ctx, mgr.GetTiKV().GetClient(), checksum, cfg.ChecksumConcurrency, updateCh)
+	updateCh.Close()
+	summary.CollectInt("retried tables", count)
+	if len(failed) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(failed))
+	for _, t := range failed {
+		log.Error("table still failed after the table retries",
+			zap.String("db", t.DB),
+			zap.String("table", t.Table),
+			zap.Int("attempts", t.Attempts),
+			zap.Error(t.Err))
+		names = append(names, utils.EncloseName(t.DB)+"."+utils.EncloseName(t.Table))
+	}
+	return errors.Annotatef(berrors.ErrRestoreTableRetryExhausted,
+		"%d tables failed: [%s], the first error: %v", len(failed), strings.Join(names, ", "), failed[0].Err)
+}
+
+// chooseChecksumMode picks the checksum mode according to the size of the
+// files to restore and the checksum budget.
+func chooseChecksumMode(cfg *RestoreConfig, files []*backup.File, isIncremental bool) checksumMode {
+	if !cfg.Checksum {
+		return checksumModeSkip
+	}
+	if cfg.ChecksumBudget == 0 {
+		return checksumModeFull
+	}
+	var totalBytes uint64
+	for _, file := range files {
+		totalBytes += file.TotalBytes
+	}
+	if cfg.ChecksumSampleRate > 0 && cfg.ChecksumSampleRate < 1 {
+		totalBytes = uint64(float64(totalBytes) * cfg.ChecksumSampleRate)
+	}
+	concurrency := uint64(cfg.ChecksumConcurrency)
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	estimated := time.Duration(float64(totalBytes) / float64(checksumSpeedPerConcurrency*concurrency) * float64(time.Second))
+	if estimated <= cfg.ChecksumBudget {
+		return checksumModeFull
+	}
+	// The checksum of incremental data isn't recorded in the backup meta,
+	// so the backup files can't be checked against it.
+	if isIncremental {
+		log.Warn("full checksum may exceed the budget, but checking the backup files only "+
+			"is not supported in incremental restore",
+			zap.Duration("estimated", estimated), zap.Duration("budget", cfg.ChecksumBudget))
+		return checksumModeFull
+	}
+	log.Warn("full checksum may exceed the budget, only check the backup files, "+
+		"the restored data isn't verified",
+		zap.Duration("estimated", estimated), zap.Duration("budget", cfg.ChecksumBudget))
+	return checksumModeBackupOnly
+}
+
 // dropToBlackhole drop all incoming tables into black hole,
 // i.e. don't execute checksum, just increase the process anyhow.
 func dropToBlackhole(
@@ -352,17 +1311,197 @@ func filterRestoreFiles(
 	return
 }
 
+// waitTablesCreated waits for the tables to be created for the schema-only
+// restore, the data can be restored into them later.
+func waitTablesCreated(
+	ctx context.Context,
+	g glue.Glue,
+	cmdName string,
+	cfg *RestoreConfig,
+	tableStream <-chan restore.CreatedTable,
+	tableCount int,
+	errCh <-chan error,
+) error {
+	updateCh := g.StartProgress(ctx, cmdName, int64(tableCount), !cfg.LogProgress)
+	defer updateCh.Close()
+	created := 0
+	for {
+		select {
+		case err := <-errCh:
+			return errors.Trace(err)
+		case _, ok := <-tableStream:
+			if !ok {
+				// The error is sent before the stream is closed.
+				select {
+				case err := <-errCh:
+					return errors.Trace(err)
+				default:
+				}
+				log.Info("schemas restored without data", zap.Int("tables", created))
+				summary.CollectInt("created tables", created)
+				summary.SetSuccessStatus(true)
+				return nil
+			}
+			created++
+			updateCh.Inc()
+		}
+	}
+}
+
+// labelRestoreStores labels the stores of --restore-stores as the restore
+// stores, and returns the original labels of the changed stores. In dry run,
+// it reports the changes and returns done without changing the cluster. The
+// schema-only restore doesn't label the stores.
+func labelRestoreStores(
+	ctx context.Context, client *restore.Client, cfg *RestoreConfig,
+) (origins []restore.StoreLabel, done bool, err error) {
+	if cfg.SchemaOnly {
+		if cfg.DryRunLabels {
+			return nil, false, errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s isn't supported by the schema-only restore", flagDryRunLabels)
+		}
+		if len(cfg.RestoreStores) > 0 {
+			log.Warn("the restore stores aren't labeled by the schema-only restore",
+				zap.Uint64s("restore-stores", cfg.RestoreStores))
+		}
+		return nil, false, nil
+	}
+	changes, err := client.PlanRestoreLabels(ctx, cfg.RestoreStores)
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+	if cfg.DryRunLabels {
+		for _, c := range changes {
+			log.Info("store label to change",
+				zap.Uint64("store-id", c.StoreID),
+				zap.String("key", c.Key),
+				zap.String("origin", c.Value),
+				zap.String("value", c.NewValue))
+		}
+		log.Info("dry run, the cluster is not changed", zap.Int("label-changes", len(changes)))
+		summary.CollectInt("store label changes", len(changes))
+		summary.SetSuccessStatus(true)
+		return nil, true, nil
+	}
+	origins, err = client.ApplyStoreLabels(ctx, changes)
+	return origins, false, errors.Trace(err)
+}
+
+// rollbackStoreLabels sets the labels changed by restore back to their
+// original values.
+func rollbackStoreLabels(ctx context.Context, client *restore.Client, origins []restore.StoreLabel) {
+	if len(origins) == 0 {
+		return
+	}
+	if ctx.Err() != nil {
+		log.Warn("context canceled, roll back the store labels in background")
+		ctx = context.Background()
+	}
+	if err := client.RollbackStoreLabels(ctx, origins); err != nil {
+		log.Warn("failed to roll back the store labels, run `br restore abort` to retry", zap.Error(err))
+	}
+}
+
+// newLeaderEvacuator creates the evacuator evicting the leaders from at most
+// maxStores ingest-heavy stores, a nil evacuator means no eviction. At least one
+// store is always left to hold the leaders.
+func newLeaderEvacuator(ctx context.Context, mgr *conn.Mgr, maxStores int) (*restore.LeaderEvacuator, error) {
+	if maxStores == 0 {
+		return nil, nil
+	}
+	stores, err := conn.GetAllTiKVStores(ctx, mgr.GetPDClient(), conn.SkipTiFlash)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if maxStores > len(stores)-1 {
+		maxStores = len(stores) - 1
+	}
+	if maxStores <= 0 {
+		log.Warn("too few stores to evacuate the leaders from, skip the leader evacuation",
+			zap.Int("stores", len(stores)))
+		return nil, nil
+	}
+	return restore.NewLeaderEvacuator(mgr.PdController, maxStores), nil
+}
+
+// configSnapshotTimeout is the timeout of recovering and saving the PD config
+// snapshots, which shouldn't hold the restore up.
+const configSnapshotTimeout = 10 * time.Second
+
 // restorePreWork executes some prepare work before restore.
+// It also returns the removed schedulers along with the original schedule
+// config, which is nil if nothing has been changed.
 // TODO make this function returns a restore post work.
-func restorePreWork(ctx context.Context, client *restore.Client, mgr *conn.Mgr) (pdutil.UndoFunc, error) {
+func restorePreWork(
+	ctx context.Context, client *restore.Client, mgr *conn.Mgr, cfg *Config,
+) (pdutil.UndoFunc, *pdutil.ClusterConfig, error) {
+	// Fail before changing the cluster if any store can't restore files.
+	if _, err := client.ProbeStores(ctx); err != nil {
+		return pdutil.Nop, nil, errors.Trace(err)
+	}
 	if client.IsOnline() {
-		return pdutil.Nop, nil
+		return pdutil.Nop, nil, nil
 	}
 
 	// Switch TiKV cluster to import mode (adjust rocksdb configuration).
 	client.SwitchToImportMode(ctx)
 
-	return mgr.RemoveSchedulers(ctx)
+	// The original config is persisted in PD before it's changed, so it can
+	// be recovered by the next restore even if this one is killed. The
+	// snapshot is best-effort, the restore goes on without it.
+	cli, err := newEtcdClient(cfg)
+	if err != nil {
+		log.Warn("failed to connect the etcd of PD, restore without the PD config snapshot", zap.Error(err))
+		cli = nil
+	}
+	if cli != nil {
+		recoverCtx, cancel := context.WithTimeout(ctx, configSnapshotTimeout)
+		if n, err := pdutil.RecoverStaleConfigSnapshots(recoverCtx, cli, mgr.RestoreSchedulers); err != nil {
+			log.Warn("failed to recover the PD config left by the restores gone", zap.Error(err))
+		} else if n > 0 {
+			log.Info("recovered the PD config left by the restores gone", zap.Int("snapshots", n))
+		}
+		cancel()
+	}
+	var guard *pdutil.ConfigSnapshotGuard
+	origin, err := mgr.RemoveSchedulersWithSnapshot(ctx, func(ctx context.Context, origin pdutil.ClusterConfig) {
+		if cli == nil {
+			return
+		}
+		saveCtx, cancel := context.WithTimeout(ctx, configSnapshotTimeout)
+		defer cancel()
+		var err error
+		if guard, err = pdutil.SaveConfigSnapshot(saveCtx, cli, origin); err != nil {
+			log.Warn("failed to save the PD config snapshot, restore without it", zap.Error(err))
+		}
+	})
+	release := func(ctx context.Context, restored bool) {
+		if guard != nil {
+			if err := guard.Release(ctx, restored); err != nil {
+				log.Warn("failed to release the PD config snapshot", zap.Error(err))
+			}
+		}
+		if cli != nil {
+			_ = cli.Close()
+		}
+	}
+	if err != nil {
+		// The config may have been changed partially, leave the snapshot to
+		// the next restore.
+		release(ctx, false)
+		return pdutil.Nop, nil, errors.Trace(err)
+	}
+	if origin.ScheduleCfg == nil {
+		release(ctx, true)
+		return pdutil.Nop, nil, nil
+	}
+	undo := func(ctx context.Context) error {
+		err := mgr.RestoreSchedulers(ctx, origin)
+		// Leave the snapshot to the next restore if the config isn't restored.
+		release(ctx, err == nil)
+		return errors.Trace(err)
+	}
+	return undo, &origin, nil
 }
 
 // restorePostWork executes some post work after restore.
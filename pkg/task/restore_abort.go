@@ -0,0 +1,233 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/backup"
+	"github.com/pingcap/br/pkg/conn"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// resumeRestoreCheckpoint creates the recorder of the restore checkpoint in
+// the backup storage, which resumes the progress of the previous run if it's
+// of the same restore in the cluster, see restore.ResumeCheckpoint.
+func resumeRestoreCheckpoint(
+	ctx context.Context, mgr *conn.Mgr, s storage.ExternalStorage, online bool,
+) (*restore.CheckpointRecorder, error) {
+	previous, err := restore.ReadCheckpoint(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cp := restore.ResumeCheckpoint(previous, mgr.GetPDClient().GetClusterID(ctx), online)
+	switch {
+	case previous == nil:
+		log.Info("restore checkpoint not found, start a new one", zap.String("restore-id", cp.RestoreID))
+	case previous.RestoreID != cp.RestoreID:
+		log.Info("the restore checkpoint is of a finished restore or another cluster, start a new one",
+			zap.String("previous-task-id", previous.TaskID),
+			zap.Uint64("previous-cluster-id", previous.ClusterID),
+			zap.String("state", string(previous.State)),
+			zap.String("restore-id", cp.RestoreID))
+	default:
+		log.Info("resume the restore",
+			zap.String("previous-task-id", previous.TaskID),
+			zap.String("state", string(previous.State)),
+			zap.String("restore-id", cp.RestoreID),
+			zap.Int("verified-tables", len(cp.VerifiedTables)),
+			zap.Int("scattered-regions", len(cp.ScatteredRegions)),
+			zap.Int("ingested-files", len(cp.IngestedFiles)))
+	}
+	recorder := restore.NewCheckpointRecorder(s, cp)
+	recorder.Save(ctx)
+	return recorder, nil
+}
+
+// lockRestoreSource acquires the restore lock of the backup in PD, so only one
+// restore from the backup records the checkpoint at a time. The task is
+// canceled once the lock is lost.
+func lockRestoreSource(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	cfg *Config,
+	u *backuppb.StorageBackend,
+) (release func(), err error) {
+	return holdStorageLock(ctx, cancel, cfg, u, backup.AcquireRestoreLock)
+}
+
+// RunRestoreAbort cleans up the cluster after a cancelled restore from the
+// storage. It resumes the schedulers, switches TiKV back to normal mode,
+// removes the placement rules of the task and rolls back the store labels of
+// online restore, removes the service safe point, and marks the checkpoint
+// aborted. It refuses to run while a restore from the storage holds the
+// restore lock.
+//
+// Without a checkpoint, i.e. the restore ran without `--resume`, the original
+// schedule config is recovered from the snapshots in PD left by the restores
+// gone, or it's left to expire with the TTL, and the placement rules are left
+// since the task is unknown. Everything else is cleaned up regardless of the
+// restore mode.
+func RunRestoreAbort(c context.Context, g glue.Glue, cmdName string, cfg *Config) error {
+	defer summary.Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	u, s, err := GetStorage(ctx, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = checkNoRunningRestore(ctx, cfg, u); err != nil {
+		return errors.Trace(err)
+	}
+	cp, err := restore.ReadCheckpoint(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	hasCheckpoint := cp != nil
+	if !hasCheckpoint {
+		log.Warn("restore checkpoint not found, clean up everything restore may change")
+		cp = &restore.Checkpoint{State: restore.CheckpointRunning, Online: true}
+	}
+	if cp.State == restore.CheckpointFinished {
+		log.Info("the restore has finished, nothing to abort")
+		summary.SetSuccessStatus(true)
+		return nil
+	}
+
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(cfg), cfg.GRPCMaxMsgSize(), cfg.CheckRequirements)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer mgr.Close()
+	client, err := restore.NewRestoreClient(g, mgr.GetPDClient(), mgr.GetTiKV(), mgr.GetPDTLSConfig(), mgr.GetTLSConfig(), GetKeepalive(cfg), mgr.GetGRPCMaxMsgSize())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer client.Close()
+
+	// Go on cleaning up on error, and report all the errors at last.
+	if err1 := client.SwitchToNormalMode(ctx); err1 != nil {
+		err = multierr.Append(err, errors.Annotate(err1, "failed to switch to normal mode"))
+	}
+	if err1 := abortRestoreSchedulers(ctx, mgr, cp.PDConfig, hasCheckpoint); err1 != nil {
+		err = multierr.Append(err, err1)
+	}
+	if err1 := recoverPDConfigSnapshots(ctx, mgr, cfg); err1 != nil {
+		err = multierr.Append(err, err1)
+	}
+	if cp.Online {
+		client.EnableOnline()
+		if hasCheckpoint && cp.TaskID != "" {
+			if err1 := client.ResetTaskPlacementRules(ctx, cfg.PD, cp.TaskID); err1 != nil {
+				err = multierr.Append(err, errors.Annotate(err1, "failed to reset placement rules"))
+			}
+		} else {
+			log.Warn("the restore task is unknown, its placement rules with the prefix restore-t are left")
+		}
+		if len(cp.StoreLabels) > 0 {
+			if err1 := client.RollbackStoreLabels(ctx, cp.StoreLabels); err1 != nil {
+				err = multierr.Append(err, errors.Annotate(err1, "failed to roll back restore labels"))
+			}
+		} else if err1 := client.LoadRestoreStores(ctx); err1 != nil {
+			err = multierr.Append(err, errors.Annotate(err1, "failed to load restore stores"))
+		} else if err1 = client.ResetRestoreLabels(ctx); err1 != nil {
+			err = multierr.Append(err, errors.Annotate(err1, "failed to reset restore labels"))
+		}
+	}
+	if cp.SafePointID != "" {
+		if err1 := utils.RemoveServiceSafePoint(ctx, mgr.GetPDClient(), cp.SafePointID); err1 != nil {
+			err = multierr.Append(err, errors.Annotate(err1, "failed to remove service safe point"))
+		}
+	}
+	if err != nil {
+		// Keep the checkpoint running, so the abort can be retried.
+		return errors.Trace(err)
+	}
+
+	if hasCheckpoint {
+		cp.State = restore.CheckpointAborted
+		if err = restore.SaveCheckpoint(ctx, s, cp); err != nil {
+			return errors.Annotate(err, "failed to mark the restore checkpoint aborted")
+		}
+	}
+	log.Info("the restore has been aborted")
+	summary.SetSuccessStatus(true)
+	return nil
+}
+
+// checkNoRunningRestore checks no restore from the storage holds the restore
+// lock.
+func checkNoRunningRestore(ctx context.Context, cfg *Config, u *backuppb.StorageBackend) error {
+	cli, err := newEtcdClient(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer cli.Close()
+	info, err := backup.GetRestoreLock(ctx, cli, storage.FormatBackendURL(u).String())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if info != nil {
+		return errors.Annotatef(berrors.ErrBackupLocked,
+			"the restore from the storage is still running by %s since %s", info.Owner, info.AcquiredAt)
+	}
+	return nil
+}
+
+// abortRestoreSchedulers resumes the schedulers removed by restore. Without
+// the recorded config, it resumes all the schedulers BR may remove.
+func abortRestoreSchedulers(
+	ctx context.Context, mgr *conn.Mgr, pdConfig *pdutil.ClusterConfig, hasCheckpoint bool,
+) error {
+	if pdConfig != nil {
+		return errors.Annotate(mgr.RestoreSchedulers(ctx, *pdConfig), "failed to restore PD schedulers")
+	}
+	if hasCheckpoint {
+		// Online restore doesn't touch the schedulers.
+		return nil
+	}
+	existSchedulers, err := mgr.ListSchedulers(ctx)
+	if err != nil {
+		return errors.Annotate(err, "failed to list PD schedulers")
+	}
+	schedulers := make([]string, 0, len(existSchedulers))
+	for _, s := range existSchedulers {
+		if _, ok := pdutil.Schedulers[s]; ok {
+			schedulers = append(schedulers, s)
+		}
+	}
+	log.Warn("the original PD schedule config is unknown, it will be reset after the TTL expires",
+		zap.Strings("resumed-schedulers", schedulers))
+	return errors.Annotate(mgr.ResumeSchedulers(ctx, schedulers), "failed to resume PD schedulers")
+}
+
+// recoverPDConfigSnapshots restores the original schedule config persisted in
+// PD by the restores which have gone without restoring it.
+func recoverPDConfigSnapshots(ctx context.Context, mgr *conn.Mgr, cfg *Config) error {
+	cli, err := newEtcdClient(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer cli.Close()
+	n, err := pdutil.RecoverStaleConfigSnapshots(ctx, cli, mgr.RestoreSchedulers)
+	if err != nil {
+		return errors.Annotate(err, "failed to recover PD config snapshots")
+	}
+	if n > 0 {
+		log.Info("recovered the PD config left by the restores gone", zap.Int("snapshots", n))
+	}
+	return nil
+}
@@ -99,7 +99,7 @@ func RunLogRestore(c context.Context, g glue.Glue, cfg *LogRestoreConfig) error
 	ctx, cancel := context.WithCancel(c)
 	defer cancel()
 
-	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements)
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.GRPCMaxMsgSize(), cfg.CheckRequirements)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -111,13 +111,18 @@ func RunLogRestore(c context.Context, g glue.Glue, cfg *LogRestoreConfig) error
 	}
 	keepaliveCfg := GetKeepalive(&cfg.Config)
 	keepaliveCfg.PermitWithoutStream = true
-	client, err := restore.NewRestoreClient(g, mgr.GetPDClient(), mgr.GetTiKV(), mgr.GetTLSConfig(), keepaliveCfg)
+	client, err := restore.NewRestoreClient(g, mgr.GetPDClient(), mgr.GetTiKV(), mgr.GetPDTLSConfig(), mgr.GetTLSConfig(), keepaliveCfg, mgr.GetGRPCMaxMsgSize())
 	if err != nil {
 		return errors.Trace(err)
 	}
 	defer client.Close()
 
-	if err = client.SetStorage(ctx, u, false); err != nil {
+	opts, err := cfg.StorageOptions()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	opts.SendCredentials = false
+	if err = client.SetStorage(ctx, u, opts); err != nil {
 		return errors.Trace(err)
 	}
 
@@ -7,21 +7,121 @@ import (
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/config"
+	"github.com/pingcap/tidb/store/tikv"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"go.uber.org/zap"
 
+	"github.com/pingcap/br/pkg/conn"
 	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/glue"
 	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/rtree"
 	"github.com/pingcap/br/pkg/summary"
 	"github.com/pingcap/br/pkg/utils"
 )
 
+const (
+	flagTargetColumnFamily = "target-cf"
+	// flagVerifyScan verifies the restored ranges by scanning them.
+	flagVerifyScan          = "verify-scan"
+	flagVerifyScanRateLimit = "verify-scan-ratelimit"
+
+	// rawDefaultCF is the only column family the raw scan reads.
+	rawDefaultCF = "default"
+)
+
+// ScanVerifyConfig is the configuration of the verification of the raw and
+// txn restores by scanning the restored ranges, since `ADMIN CHECKSUM` isn't
+// available for them.
+type ScanVerifyConfig struct {
+	Enabled bool `json:"verify-scan" toml:"verify-scan"`
+	// RateLimit is the max bytes scanned per second, zero means no limit.
+	RateLimit uint64 `json:"verify-scan-ratelimit" toml:"verify-scan-ratelimit"`
+}
+
+// ParseFromFlags parses the config from the flag set, the flags are only
+// defined for the raw and txn restores.
+func (cfg *ScanVerifyConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	if flags.Lookup(flagVerifyScan) == nil {
+		return nil
+	}
+	var err error
+	cfg.Enabled, err = flags.GetBool(flagVerifyScan)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	rateLimit, err := flags.GetUint64(flagVerifyScanRateLimit)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.RateLimit = rateLimit * utils.MB
+	return nil
+}
+
+// verifyRestoredRanges scans the restored ranges within [start, end), and
+// compares them with the checksums of the backup files.
+func verifyRestoredRanges(
+	ctx context.Context,
+	g glue.Glue,
+	mgr *conn.Mgr,
+	cfg *Config,
+	verifyCfg ScanVerifyConfig,
+	isRawKv bool,
+	ranges []rtree.Range,
+	start, end []byte,
+) error {
+	var scanner restore.KVScanner
+	if isRawKv {
+		security := config.Security{}
+		if pdTLS := cfg.TLS.ForPD(); pdTLS.IsEnabled() {
+			security.ClusterSSLCA = pdTLS.CA
+			security.ClusterSSLCert = pdTLS.Cert
+			security.ClusterSSLKey = pdTLS.Key
+		}
+		cli, err := tikv.NewRawKVClient(cfg.PD, security)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		scanner = restore.NewRawKVScanner(cli)
+	} else {
+		var err error
+		scanner, err = restore.NewTxnKVScanner(mgr.GetTiKV())
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	defer func() {
+		if err := scanner.Close(); err != nil {
+			log.Warn("failed to close the scanner", zap.Error(err))
+		}
+	}()
+
+	updateCh := g.StartProgress(ctx, "Verify", int64(len(ranges)), !cfg.LogProgress)
+	defer updateCh.Close()
+	verifier := restore.NewScanVerifier(scanner, verifyCfg.RateLimit, restore.DefaultScanVerifyConcurrency)
+	return errors.Trace(verifier.Verify(ctx, ranges, start, end, updateCh))
+}
+
 // RestoreRawConfig is the configuration specific for raw kv restore tasks.
 type RestoreRawConfig struct {
 	RawKvConfig
 
 	Online bool `json:"online" toml:"online"`
+	// TargetCF is the column family on the target cluster to restore into,
+	// empty means the same column family as the backup (i.e. CF).
+	TargetCF string `json:"target-cf" toml:"target-cf"`
+	// ScatterPriority is the priority of the scatter operators created by restore.
+	ScatterPriority restore.ScatterPriority `json:"scatter-priority" toml:"scatter-priority"`
+	// IngestTimeout is the timeout of the download and ingest RPCs of a file,
+	// the zero value means no timeout.
+	IngestTimeout restore.IngestTimeout `json:"ingest-timeout" toml:"ingest-timeout"`
+	// PerStoreInflight is the max count of the download and ingest requests
+	// in flight to a store, zero means no limit.
+	PerStoreInflight uint `json:"per-store-inflight" toml:"per-store-inflight"`
+	// ScanVerify verifies the restored ranges by scanning them.
+	ScanVerify ScanVerifyConfig `json:"scan-verify" toml:"scan-verify"`
 }
 
 // DefineRawRestoreFlags defines common flags for the backup command.
@@ -30,6 +130,13 @@ func DefineRawRestoreFlags(command *cobra.Command) {
 	command.Flags().StringP(flagTiKVColumnFamily, "", "default", "restore specify cf, correspond to tikv cf")
 	command.Flags().StringP(flagStartKey, "", "", "restore raw kv start key, key is inclusive")
 	command.Flags().StringP(flagEndKey, "", "", "restore raw kv end key, key is exclusive")
+	command.Flags().String(flagTargetColumnFamily, "",
+		"restore into the specified cf of tikv, default to the cf of the backup")
+	command.Flags().Bool(flagVerifyScan, false,
+		"verify the restored ranges by scanning them and comparing the checksums with the backup, "+
+			"the raw restore can only be verified in the default cf")
+	command.Flags().Uint64(flagVerifyScanRateLimit, 0,
+		"the rate limit (MB/s) of the scan with --verify-scan, 0 means no limit")
 
 	command.Flags().Bool(flagOnline, false, "Whether online when restore")
 	// TODO remove hidden flag if it's stable
@@ -43,7 +150,46 @@ func (cfg *RestoreRawConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
-	return cfg.RawKvConfig.ParseFromFlags(flags)
+	cfg.ScatterPriority, err = parseScatterPriority(flags)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.IngestTimeout, err = parseIngestTimeout(flags)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.PerStoreInflight, err = parsePerStoreInflight(flags)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.TargetCF, err = flags.GetString(flagTargetColumnFamily)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.TargetCF != "" {
+		if err = restore.ValidateColumnFamily(cfg.TargetCF); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err = cfg.ScanVerify.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.RawKvConfig.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ScanVerify.Enabled && cfg.targetCF() != rawDefaultCF {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s only supports restoring into the %s cf", flagVerifyScan, rawDefaultCF)
+	}
+	return nil
+}
+
+// targetCF returns the column family restored into.
+func (cfg *RestoreRawConfig) targetCF() string {
+	if cfg.TargetCF != "" {
+		return cfg.TargetCF
+	}
+	return cfg.CF
 }
 
 func (cfg *RestoreRawConfig) adjust() {
@@ -59,30 +205,51 @@ func RunRestoreRaw(c context.Context, g glue.Glue, cmdName string, cfg *RestoreR
 	cfg.adjust()
 
 	defer summary.Summary(cmdName)
+	// The gRPC messages are counted per task.
+	wireStats := &utils.GRPCWireStats{}
+	defer collectGRPCCompression(&cfg.Config, wireStats)
 	ctx, cancel := context.WithCancel(c)
 	defer cancel()
 
-	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements)
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.GRPCMaxMsgSize(), cfg.CheckRequirements)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	defer mgr.Close()
+	if err = applyProfileOfCluster(ctx, mgr, &cfg.Config, true); err != nil {
+		return errors.Trace(err)
+	}
 
 	keepaliveCfg := GetKeepalive(&cfg.Config)
 	// sometimes we have pooled the connections.
 	// sending heartbeats in idle times is useful.
 	keepaliveCfg.PermitWithoutStream = true
-	client, err := restore.NewRestoreClient(g, mgr.GetPDClient(), mgr.GetTiKV(), mgr.GetTLSConfig(), keepaliveCfg)
+	client, err := restore.NewRestoreClient(g, mgr.GetPDClient(), mgr.GetTiKV(), mgr.GetPDTLSConfig(), mgr.GetTLSConfig(), keepaliveCfg, mgr.GetGRPCMaxMsgSize())
 	if err != nil {
 		return errors.Trace(err)
 	}
 	defer client.Close()
+	client.SetGRPCCompression(cfg.GRPCCompression, wireStats)
 	client.SetRateLimit(cfg.RateLimit)
+	budget, leaveBudget, err := joinBandwidthBudget(ctx, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer leaveBudget()
+	client.SetBandwidthBudget(budget)
+	tuning, unsetTuning := exposeLiveTuning(&cfg.Config)
+	defer unsetTuning()
+	client.SetLiveTuning(tuning)
 	client.SetConcurrency(uint(cfg.Concurrency))
 	if cfg.Online {
 		client.EnableOnline()
 	}
 	client.SetSwitchModeInterval(cfg.SwitchModeInterval)
+	client.SetIngestTimeout(cfg.IngestTimeout)
+	client.SetPerStoreInflight(cfg.PerStoreInflight)
+	if cfg.ScatterPriority != "" {
+		client.SetScatterPriority(cfg.ScatterPriority)
+	}
 
 	u, _, backupMeta, err := ReadBackupMeta(ctx, utils.MetaFile, &cfg.Config)
 	if err != nil {
@@ -96,6 +263,13 @@ func RunRestoreRaw(c context.Context, g glue.Glue, cmdName string, cfg *RestoreR
 	if !client.IsRawKvMode() {
 		return errors.Annotate(berrors.ErrRestoreModeMismatch, "cannot do raw restore from transactional data")
 	}
+	if cfg.TargetCF != "" && cfg.TargetCF != cfg.CF {
+		log.Info("restore raw kv into another column family",
+			zap.String("cf", cfg.CF), zap.String("target-cf", cfg.TargetCF))
+		if err = client.SetRawTargetCF(cfg.TargetCF); err != nil {
+			return errors.Trace(err)
+		}
+	}
 
 	files, err := client.GetFilesInRawRange(cfg.StartKey, cfg.EndKey, cfg.CF)
 	if err != nil {
@@ -127,7 +301,7 @@ func RunRestoreRaw(c context.Context, g glue.Glue, cmdName string, cfg *RestoreR
 		return errors.Trace(err)
 	}
 
-	restoreSchedulers, err := restorePreWork(ctx, client, mgr)
+	restoreSchedulers, _, err := restorePreWork(ctx, client, mgr, &cfg.Config)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -141,6 +315,13 @@ func RunRestoreRaw(c context.Context, g glue.Glue, cmdName string, cfg *RestoreR
 	// Restore has finished.
 	updateCh.Close()
 
+	if cfg.ScanVerify.Enabled {
+		err = verifyRestoredRanges(ctx, g, mgr, &cfg.Config, cfg.ScanVerify, true, ranges, cfg.StartKey, cfg.EndKey)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
 	// Set task summary to success status.
 	summary.SetSuccessStatus(true)
 	return nil
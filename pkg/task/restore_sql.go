@@ -0,0 +1,273 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/errors"
+	kvproto "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	flagSQLDSN         = "sql-dsn"
+	flagSQLBatchSize   = "sql-batch-size"
+	flagSQLConcurrency = "sql-concurrency"
+	flagSQLHandleRange = "sql-handle-range"
+	flagSQLPartitions  = "sql-partitions"
+
+	defaultSQLBatchSize   = 256
+	defaultSQLConcurrency = 8
+)
+
+// RestoreSQLConfig is the configuration of the logical restore through the
+// SQL interface only.
+type RestoreSQLConfig struct {
+	// DSN is the data source name of the TiDB, or the SQL proxy in front of
+	// it, e.g. `user:password@tcp(proxy:4000)/`. The logical restore is
+	// enabled if it's set.
+	DSN         string `json:"sql-dsn" toml:"sql-dsn"`
+	BatchSize   int    `json:"sql-batch-size" toml:"sql-batch-size"`
+	Concurrency uint   `json:"sql-concurrency" toml:"sql-concurrency"`
+	// HandleRange restores only the rows whose int handles are in the range,
+	// see restore.ParseHandleRange.
+	HandleRange string `json:"sql-handle-range" toml:"sql-handle-range"`
+	// Partitions restores only the rows in the partitions.
+	Partitions []string `json:"sql-partitions" toml:"sql-partitions"`
+}
+
+// DefineRestoreSQLFlags defines the flags of the logical restore.
+func DefineRestoreSQLFlags(flags *pflag.FlagSet) {
+	flags.String(flagSQLDSN, "",
+		"(experimental) restore through the SQL interface of the DSN only, e.g. user:password@tcp(proxy:4000)/, "+
+			"without accessing PD or TiKV. The rows are decoded from the backup and inserted in batches, "+
+			"which is much slower. It selects the 'sql' import backend")
+	flags.Int(flagSQLBatchSize, defaultSQLBatchSize, "the max count of the rows inserted by a statement with --sql-dsn")
+	flags.Uint(flagSQLConcurrency, defaultSQLConcurrency, "the count of the tables restored concurrently with --sql-dsn")
+	flags.String(flagSQLHandleRange, "",
+		"restore only the rows whose int primary keys or _tidb_rowid are in the inclusive range with --sql-dsn, "+
+			"e.g. '100:200', ':200' or '100:', the files out of the range aren't read")
+	flags.StringSlice(flagSQLPartitions, nil, "restore only the rows in the partitions with --sql-dsn, e.g. 'p0,p1'")
+}
+
+// ParseFromFlags parses the config from the flag set.
+func (cfg *RestoreSQLConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.DSN, err = flags.GetString(flagSQLDSN); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.BatchSize, err = flags.GetInt(flagSQLBatchSize); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Concurrency, err = flags.GetUint(flagSQLConcurrency); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.HandleRange, err = flags.GetString(flagSQLHandleRange); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Partitions, err = flags.GetStringSlice(flagSQLPartitions); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.DSN == "" {
+		if cfg.HandleRange != "" || len(cfg.Partitions) > 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s and --%s require --%s", flagSQLHandleRange, flagSQLPartitions, flagSQLDSN)
+		}
+		return nil
+	}
+	if _, err = mysql.ParseDSN(cfg.DSN); err != nil {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s: %s", flagSQLDSN, err)
+	}
+	if cfg.BatchSize <= 0 || cfg.Concurrency == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s and --%s must be positive", flagSQLBatchSize, flagSQLConcurrency)
+	}
+	_, err = cfg.rowFilter()
+	return errors.Trace(err)
+}
+
+// rowFilter returns the filter of the rows restored, nil means all.
+func (cfg *RestoreSQLConfig) rowFilter() (*restore.RowFilter, error) {
+	if cfg.HandleRange == "" && len(cfg.Partitions) == 0 {
+		return nil, nil
+	}
+	filter := &restore.RowFilter{Partitions: cfg.Partitions}
+	if cfg.HandleRange != "" {
+		handles, err := restore.ParseHandleRange(cfg.HandleRange)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid --%s", flagSQLHandleRange)
+		}
+		filter.Handles = handles
+	}
+	return filter, nil
+}
+
+// redactDSN hides the password of the DSN.
+func redactDSN(dsn string) string {
+	dsnCfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "<invalid DSN>"
+	}
+	if dsnCfg.Passwd != "" {
+		dsnCfg.Passwd = "******"
+	}
+	return dsnCfg.FormatDSN()
+}
+
+// openSQLDB opens the database of the DSN for the logical restore.
+func openSQLDB(dsn string, concurrency uint) (*sql.DB, error) {
+	dsnCfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if dsnCfg.Params == nil {
+		dsnCfg.Params = make(map[string]string)
+	}
+	// The rows are decoded in UTC, and inserted as they are.
+	dsnCfg.Params["time_zone"] = "'+00:00'"
+	dsnCfg.Params["sql_mode"] = "''"
+	db, err := sql.Open("mysql", dsnCfg.FormatDSN())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	db.SetMaxOpenConns(int(concurrency))
+	db.SetMaxIdleConns(int(concurrency))
+	return db, nil
+}
+
+// openImportBackend opens the import backend of the config, the storage is
+// of the backup.
+func openImportBackend(
+	ctx context.Context,
+	cfg *RestoreConfig,
+	u *kvproto.StorageBackend,
+	s storage.ExternalStorage,
+) (restore.ImportBackend, uint, error) {
+	if cfg.ImportBackend != restore.SQLImportBackend {
+		backend, err := restore.NewImportBackend(ctx, cfg.ImportBackend, u)
+		return backend, cfg.Concurrency, errors.Trace(err)
+	}
+	filter, err := cfg.SQL.rowFilter()
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	db, err := openSQLDB(cfg.SQL.DSN, cfg.SQL.Concurrency)
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	log.Info("restore through SQL", zap.String("dsn", redactDSN(cfg.SQL.DSN)))
+	restorer := restore.NewLogicalRestorer(db, s, cfg.SQL.BatchSize)
+	if filter != nil {
+		log.Info("restore the rows matching the filter",
+			zap.String("handle-range", cfg.SQL.HandleRange),
+			zap.Strings("partitions", cfg.SQL.Partitions))
+		restorer.SetRowFilter(filter)
+	}
+	return restorer, cfg.SQL.Concurrency, nil
+}
+
+// RunRestoreByBackend restores the tables by the import backend of the config,
+// for the environments where PD and TiKV can't be accessed directly, e.g.
+// through the SQL interface only. The tables are created if not exist, and
+// their data is imported by the backend.
+func RunRestoreByBackend(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConfig) error {
+	defer summary.Summary(cmdName)
+	if cfg.SchemaOnly {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"the schema-only restore can't be done by the %s import backend", cfg.ImportBackend)
+	}
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	u, s, backupMeta, err := ReadBackupMeta(ctx, utils.MetaFile, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if backupMeta.IsRawKv {
+		return errors.Annotatef(berrors.ErrRestoreModeMismatch,
+			"the raw kv backup can't be restored by the %s import backend", cfg.ImportBackend)
+	}
+	if backupMeta.StartVersion > 0 {
+		// The deletions of an incremental backup can't be replayed.
+		return errors.Annotatef(berrors.ErrRestoreModeMismatch,
+			"the incremental backup can't be restored by the %s import backend", cfg.ImportBackend)
+	}
+	dbs, err := utils.LoadBackupTables(backupMeta)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tables := make([]*utils.Table, 0)
+	files := 0
+	for _, db := range dbs {
+		for _, table := range db.Tables {
+			if !cfg.TableFilter.MatchTable(db.Info.Name.O, table.Info.Name.O) {
+				continue
+			}
+			tables = append(tables, table)
+			files += restore.EstimateRangeSize(table.Files)
+		}
+	}
+	if len(tables) == 0 {
+		log.Warn("no tables to restore by the import backend", zap.String("backend", cfg.ImportBackend))
+		summary.SetSuccessStatus(true)
+		return nil
+	}
+
+	backend, concurrency, err := openImportBackend(ctx, cfg, u, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer func() {
+		if err := backend.Close(); err != nil {
+			log.Warn("failed to close the import backend", zap.Error(err))
+		}
+	}()
+	log.Info("start to restore by the import backend",
+		zap.String("backend", cfg.ImportBackend),
+		zap.Int("tables", len(tables)),
+		zap.Int("files", files))
+
+	summary.RegisterStage(summary.StageSchema)
+	// The views are created after the tables they refer to.
+	for _, table := range restore.OrderTablesByDependency(tables) {
+		if err = backend.CreateTable(ctx, table); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	summary.EndStage(summary.StageSchema)
+
+	// Redirect to log if there is no log file to avoid unreadable output.
+	updateCh := g.StartProgress(ctx, cmdName, int64(files), !cfg.LogProgress)
+	defer updateCh.Close()
+	pool := utils.NewWorkerPool(concurrency, "restore by import backend")
+	eg, ectx := errgroup.WithContext(ctx)
+	for _, table := range tables {
+		table := table
+		pool.ApplyOnErrorGroup(eg, func() error {
+			return errors.Trace(backend.ImportTable(ectx, table, updateCh))
+		})
+	}
+	err = eg.Wait()
+	if restorer, ok := backend.(*restore.LogicalRestorer); ok {
+		summary.CollectInt("restored rows", int(restorer.Rows()))
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	summary.CollectInt("restored tables", len(tables))
+	summary.SetSuccessStatus(true)
+	return nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+	"github.com/spf13/pflag"
+
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/gluetikv"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+var _ = Suite(&testRestoreSuite{})
+
+type testRestoreSuite struct{}
+
+func (s *testRestoreSuite) TestChooseChecksumMode(c *C) {
+	files := []*backup.File{
+		{TotalBytes: 512 * utils.MB},
+		{TotalBytes: 512 * utils.MB},
+	}
+	cfg := &RestoreConfig{Config: Config{Checksum: false, ChecksumConcurrency: 4}}
+	c.Assert(chooseChecksumMode(cfg, files, false), Equals, checksumModeSkip)
+
+	cfg.Checksum = true
+	c.Assert(chooseChecksumMode(cfg, files, false), Equals, checksumModeFull)
+
+	// 1GiB with 4 * 128MiB/s is estimated to be 2s.
+	cfg.ChecksumBudget = 3 * time.Second
+	c.Assert(chooseChecksumMode(cfg, files, false), Equals, checksumModeFull)
+	cfg.ChecksumBudget = time.Second
+	c.Assert(chooseChecksumMode(cfg, files, false), Equals, checksumModeBackupOnly)
+	c.Assert(chooseChecksumMode(cfg, files, true), Equals, checksumModeFull)
+}
+
+func (s *testRestoreSuite) TestChecksumSampler(c *C) {
+	cfg := &RestoreConfig{}
+	c.Assert(cfg.checksumSampler(), IsNil)
+	cfg.ChecksumSampleRate = 1
+	c.Assert(cfg.checksumSampler(), IsNil)
+
+	cfg.ChecksumSampleRate = 0.1
+	c.Assert(cfg.checksumSampler().Seed(), Not(Equals), int64(0))
+	cfg.ChecksumSampleSeed = 42
+	sample := func() []string {
+		sampler := cfg.checksumSampler()
+		c.Assert(sampler.Seed(), Equals, int64(42))
+		sampled := make([]string, 0)
+		for i := 0; i < 1000; i++ {
+			table := fmt.Sprintf("t%d", i)
+			if sampler.Sample("test", table) {
+				sampled = append(sampled, table)
+			}
+		}
+		n, skipped := sampler.Counts()
+		c.Assert(n, Equals, len(sampled))
+		c.Assert(n+skipped, Equals, 1000)
+		return sampled
+	}
+	sampled := sample()
+	c.Assert(len(sampled) > 50 && len(sampled) < 150, IsTrue, Commentf("sampled %d", len(sampled)))
+	// The same seed picks the same tables.
+	c.Assert(sample(), DeepEquals, sampled)
+
+	// The full checksum of the sample is estimated to be 0.2s.
+	files := []*backup.File{{TotalBytes: 1024 * utils.MB}}
+	cfg.Checksum = true
+	cfg.ChecksumConcurrency = 4
+	cfg.ChecksumBudget = time.Second
+	c.Assert(chooseChecksumMode(cfg, files, false), Equals, checksumModeFull)
+}
+
+func (s *testRestoreSuite) TestSetGlobalVariableSQL(c *C) {
+	sql, ok := setGlobalVariableSQL("SQL_MODE", `NO_BACKSLASH'\`)
+	c.Assert(ok, IsTrue)
+	c.Assert(sql, Equals, `SET GLOBAL sql_mode = 'NO_BACKSLASH''\\'`)
+
+	_, ok = setGlobalVariableSQL("tidb_gc_life_time", "10m")
+	c.Assert(ok, IsFalse)
+	_, ok = setGlobalVariableSQL("sql_mode = ''; DROP DATABASE test; --", "")
+	c.Assert(ok, IsFalse)
+}
+
+func (s *testRestoreSuite) TestSchemaOnlyRestore(c *C) {
+	cfg := &RestoreConfig{ClusterSettings: ClusterSettingsApply}
+	c.Assert(cfg.clusterSettingsMode(false), Equals, ClusterSettingsApply)
+	// The global variables aren't set by the schema-only restore.
+	c.Assert(cfg.clusterSettingsMode(true), Equals, ClusterSettingsReview)
+	cfg.ClusterSettings = ClusterSettingsIgnore
+	c.Assert(cfg.clusterSettingsMode(true), Equals, ClusterSettingsIgnore)
+
+	// The stores aren't labeled, the client isn't touched at all.
+	cfg.SchemaOnly = true
+	cfg.RestoreStores = []uint64{4, 5}
+	origins, done, err := labelRestoreStores(context.Background(), nil, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(done, IsFalse)
+	c.Assert(origins, HasLen, 0)
+	cfg.DryRunLabels = true
+	_, _, err = labelRestoreStores(context.Background(), nil, cfg)
+	c.Assert(err, ErrorMatches, ".*--dry-run-labels isn't supported by the schema-only restore.*")
+
+	cfg.ImportBackend = restore.SQLImportBackend
+	err = RunRestoreByBackend(context.Background(), nil, CmdSchemaRestore, cfg)
+	c.Assert(err, ErrorMatches, ".*the schema-only restore can't be done by the sql import backend.*")
+}
+
+type recordImportBackend struct {
+	mu       sync.Mutex
+	created  []string
+	imported []string
+	closed   bool
+}
+
+func (b *recordImportBackend) CreateTable(ctx context.Context, tbl *utils.Table) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.created = append(b.created, tbl.Info.Name.O)
+	return nil
+}
+
+func (b *recordImportBackend) ImportTable(ctx context.Context, tbl *utils.Table, updateCh glue.Progress) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.imported = append(b.imported, tbl.Info.Name.O)
+	return nil
+}
+
+func (b *recordImportBackend) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (s *testRestoreSuite) TestRestoreByImportBackend(c *C) {
+	ctx := context.Background()
+	backend := &recordImportBackend{}
+	restore.RegisterImportBackend("task-record", func(context.Context, *backup.StorageBackend) (restore.ImportBackend, error) {
+		return backend, nil
+	})
+
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	db, err := json.Marshal(&model.DBInfo{ID: 1, Name: model.NewCIStr("test")})
+	c.Assert(err, IsNil)
+	tbl, err := json.Marshal(&model.TableInfo{ID: 2, Name: model.NewCIStr("t")})
+	c.Assert(err, IsNil)
+	data, err := proto.Marshal(&backup.BackupMeta{Schemas: []*backup.Schema{{Db: db, Table: tbl}}})
+	c.Assert(err, IsNil)
+	c.Assert(store.Write(ctx, utils.MetaFile, data), IsNil)
+
+	parse := func(args ...string) (*RestoreConfig, error) {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		DefineCommonFlags(flags)
+		DefineRestoreFlags(flags)
+		flags.StringArrayP(flagFilter, "f", []string{"*.*"}, "")
+		flags.Bool(flagCaseSensitive, false, "")
+		// Nothing listens on the PD address, the backend doesn't access it.
+		c.Assert(flags.Parse(append([]string{"--pd", "127.0.0.1:1", "-s", "local://" + dir}, args...)), IsNil)
+		cfg := &RestoreConfig{}
+		return cfg, cfg.ParseFromFlags(flags)
+	}
+	_, err = parse("--import-backend", "sql")
+	c.Assert(err, ErrorMatches, ".*the sql import backend requires --sql-dsn.*")
+	_, err = parse("--import-backend", "task-record", "--sql-dsn", "root@tcp(127.0.0.1:4000)/")
+	c.Assert(err, ErrorMatches, ".*--sql-dsn requires the sql import backend.*")
+	cfg, err := parse("--sql-dsn", "root@tcp(127.0.0.1:4000)/")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.ImportBackend, Equals, restore.SQLImportBackend)
+
+	cfg, err = parse("--import-backend", "Task-Record")
+	c.Assert(err, IsNil)
+	c.Assert(RunRestore(ctx, gluetikv.Glue{}, "Full restore", cfg), IsNil)
+	c.Assert(backend.created, DeepEquals, []string{"t"})
+	c.Assert(backend.imported, DeepEquals, []string{"t"})
+	c.Assert(backend.closed, IsTrue)
+}
@@ -18,10 +18,13 @@ func RunRestoreTxn(c context.Context, g glue.Glue, cmdName string, cfg *RestoreC
 	cfg.adjust()
 
 	defer summary.Summary(cmdName)
+	// The gRPC messages are counted per task.
+	wireStats := &utils.GRPCWireStats{}
+	defer collectGRPCCompression(&cfg.Config, wireStats)
 	ctx, cancel := context.WithCancel(c)
 	defer cancel()
 
-	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements)
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.GRPCMaxMsgSize(), cfg.CheckRequirements)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -31,17 +34,32 @@ func RunRestoreTxn(c context.Context, g glue.Glue, cmdName string, cfg *RestoreC
 	// sometimes we have pooled the connections.
 	// sending heartbeats in idle times is useful.
 	keepaliveCfg.PermitWithoutStream = true
-	client, err := restore.NewRestoreClient(g, mgr.GetPDClient(), mgr.GetTiKV(), mgr.GetTLSConfig(), keepaliveCfg)
+	client, err := restore.NewRestoreClient(g, mgr.GetPDClient(), mgr.GetTiKV(), mgr.GetPDTLSConfig(), mgr.GetTLSConfig(), keepaliveCfg, mgr.GetGRPCMaxMsgSize())
 	if err != nil {
 		return errors.Trace(err)
 	}
 	defer client.Close()
+	client.SetGRPCCompression(cfg.GRPCCompression, wireStats)
 	client.SetRateLimit(cfg.RateLimit)
+	budget, leaveBudget, err := joinBandwidthBudget(ctx, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer leaveBudget()
+	client.SetBandwidthBudget(budget)
+	tuning, unsetTuning := exposeLiveTuning(&cfg.Config)
+	defer unsetTuning()
+	client.SetLiveTuning(tuning)
 	client.SetConcurrency(uint(cfg.Concurrency))
 	if cfg.Online {
 		client.EnableOnline()
 	}
 	client.SetSwitchModeInterval(cfg.SwitchModeInterval)
+	client.SetIngestTimeout(cfg.IngestTimeout)
+	client.SetPerStoreInflight(cfg.PerStoreInflight)
+	if cfg.ScatterPriority != "" {
+		client.SetScatterPriority(cfg.ScatterPriority)
+	}
 
 	u, _, backupMeta, err := ReadBackupMeta(ctx, utils.MetaFile, &cfg.Config)
 	if err != nil {
@@ -86,7 +104,7 @@ func RunRestoreTxn(c context.Context, g glue.Glue, cmdName string, cfg *RestoreC
 		return errors.Trace(err)
 	}
 
-	restoreSchedulers, err := restorePreWork(ctx, client, mgr)
+	restoreSchedulers, _, err := restorePreWork(ctx, client, mgr, &cfg.Config)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -100,6 +118,13 @@ func RunRestoreTxn(c context.Context, g glue.Glue, cmdName string, cfg *RestoreC
 	// Restore has finished.
 	updateCh.Close()
 
+	if cfg.ScanVerify.Enabled {
+		err = verifyRestoredRanges(ctx, g, mgr, &cfg.Config, cfg.ScanVerify, false, ranges, nil, nil)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
 	// Set task summary to success status.
 	summary.SetSuccessStatus(true)
 	return nil
@@ -0,0 +1,176 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+	"github.com/spf13/pflag"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/rtree"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	// Not --key, which is the key of TLS.
+	flagSearchKey           = "search-key"
+	flagSearchMemComparable = "mem-comparable"
+	// Not --db and --table, which are parsed as the table filter.
+	flagSearchDB     = "db-name"
+	flagSearchTable  = "table-name"
+	flagSearchHandle = "handle"
+)
+
+// SearchKeyConfig is the configuration specific for searching a key in the
+// backup files.
+type SearchKeyConfig struct {
+	Config
+
+	// Key is the raw key to search, if it's nil the row of DB, Table and
+	// Handle is searched.
+	Key    []byte `json:"key" toml:"key"`
+	DB     string `json:"db-name" toml:"db-name"`
+	Table  string `json:"table-name" toml:"table-name"`
+	Handle int64  `json:"handle" toml:"handle"`
+}
+
+// DefineSearchKeyFlags defines the flags of the search-key command.
+func DefineSearchKeyFlags(flags *pflag.FlagSet) {
+	flags.String(flagSearchKey, "", "the hex encoded key to search")
+	flags.Bool(flagSearchMemComparable, false,
+		"whether the key is mem-comparable encoded, like the region boundaries in PD")
+	flags.String(flagSearchDB, "", "the database of the row to search")
+	flags.String(flagSearchTable, "", "the table of the row to search")
+	flags.Int64(flagSearchHandle, 0, "the integer handle of the row to search")
+}
+
+// ParseFromFlags parses the search-key related flags from the flag set.
+func (cfg *SearchKeyConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	if err := cfg.Config.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	keyHex, err := flags.GetString(flagSearchKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if keyHex != "" {
+		key, err := hex.DecodeString(keyHex)
+		if err != nil {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "invalid hex key %s", keyHex)
+		}
+		memComparable, err := flags.GetBool(flagSearchMemComparable)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if memComparable {
+			if _, key, err = codec.DecodeBytes(key, nil); err != nil {
+				return errors.Annotatef(berrors.ErrInvalidArgument, "invalid mem-comparable key %s", keyHex)
+			}
+		}
+		cfg.Key = key
+		return nil
+	}
+
+	if cfg.DB, err = flags.GetString(flagSearchDB); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Table, err = flags.GetString(flagSearchTable); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Handle, err = flags.GetInt64(flagSearchHandle); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.DB == "" || cfg.Table == "" || !flags.Changed(flagSearchHandle) {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"either --%s or all of --%s, --%s and --%s are required",
+			flagSearchKey, flagSearchDB, flagSearchTable, flagSearchHandle)
+	}
+	// Only the schema of the table is needed, which may be stored out of the
+	// backupmeta.
+	cfg.TableFilter = filter.CaseInsensitive(filter.NewTablesFilter(filter.Table{Schema: cfg.DB, Name: cfg.Table}))
+	return nil
+}
+
+// SearchedFile is a backup file containing the searched key.
+type SearchedFile struct {
+	Key  []byte
+	File *backup.File
+}
+
+// SearchKey returns the backup files containing the key, or the row of the
+// table. The schema of the table is loaded even if it's stored out of the
+// backupmeta.
+func SearchKey(ctx context.Context, cfg *SearchKeyConfig) ([]SearchedFile, error) {
+	_, _, backupMeta, err := ReadBackupMeta(ctx, utils.MetaFile, &cfg.Config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	keys, err := searchKeys(cfg, backupMeta)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return filesContaining(backupMeta.Files, keys), nil
+}
+
+// searchKeys returns the keys to search, either the raw key or the row key in
+// every partition of the table.
+func searchKeys(cfg *SearchKeyConfig, backupMeta *backup.BackupMeta) ([][]byte, error) {
+	if cfg.Key != nil {
+		return [][]byte{cfg.Key}, nil
+	}
+	dbs, err := utils.LoadBackupTables(backupMeta)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var table *utils.Table
+	for name, db := range dbs {
+		if !strings.EqualFold(name, cfg.DB) {
+			continue
+		}
+		for _, t := range db.Tables {
+			if strings.EqualFold(t.Info.Name.O, cfg.Table) {
+				table = t
+			}
+		}
+	}
+	if table == nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"table %s.%s not found in the backup", cfg.DB, cfg.Table)
+	}
+	// The row can be in any partition of a partitioned table.
+	tableIDs := []int64{table.Info.ID}
+	if partitions := table.Info.GetPartitionInfo(); partitions != nil {
+		tableIDs = tableIDs[:0]
+		for _, def := range partitions.Definitions {
+			tableIDs = append(tableIDs, def.ID)
+		}
+	}
+	keys := make([][]byte, 0, len(tableIDs))
+	for _, id := range tableIDs {
+		keys = append(keys, tablecodec.EncodeRowKeyWithHandle(id, kv.IntHandle(cfg.Handle)))
+	}
+	return keys, nil
+}
+
+// filesContaining returns the files whose range contains any of the keys.
+func filesContaining(files []*backup.File, keys [][]byte) []SearchedFile {
+	var found []SearchedFile
+	for _, key := range keys {
+		for _, file := range files {
+			rg := rtree.Range{StartKey: file.GetStartKey(), EndKey: file.GetEndKey()}
+			if rg.Contains(key) {
+				found = append(found, SearchedFile{Key: key, File: file})
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gogo/protobuf/proto"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/spf13/pflag"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+var _ = Suite(&testSearchKeySuite{})
+
+type testSearchKeySuite struct{}
+
+func (*testSearchKeySuite) TestSearchKey(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	s, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+
+	db, err := json.Marshal(&model.DBInfo{ID: 1, Name: model.NewCIStr("test")})
+	c.Assert(err, IsNil)
+	plain, err := json.Marshal(&model.TableInfo{ID: 2, Name: model.NewCIStr("t")})
+	c.Assert(err, IsNil)
+	partitioned, err := json.Marshal(&model.TableInfo{
+		ID:   3,
+		Name: model.NewCIStr("p"),
+		Partition: &model.PartitionInfo{
+			Enable:      true,
+			Definitions: []model.PartitionDefinition{{ID: 4}, {ID: 5}},
+		},
+	})
+	c.Assert(err, IsNil)
+	meta := &backup.BackupMeta{
+		Files: []*backup.File{
+			{Name: "2.sst", StartKey: tablecodec.EncodeRowKeyWithHandle(2, kv.IntHandle(0)),
+				EndKey: tablecodec.EncodeRowKeyWithHandle(2, kv.IntHandle(100))},
+			{Name: "4.sst", StartKey: tablecodec.GenTableRecordPrefix(4), EndKey: tablecodec.GenTableRecordPrefix(5)},
+			{Name: "5.sst", StartKey: tablecodec.GenTableRecordPrefix(5), EndKey: tablecodec.GenTableRecordPrefix(6)},
+		},
+		Schemas: []*backup.Schema{{Db: db, Table: plain}, {Db: db, Table: partitioned}},
+	}
+	// The schemas stored out of the backupmeta are searched too.
+	c.Assert(utils.ExternalizeSchemas(ctx, s, meta), IsNil)
+	data, err := proto.Marshal(meta)
+	c.Assert(err, IsNil)
+	c.Assert(s.Write(ctx, utils.MetaFile, data), IsNil)
+
+	search := func(args ...string) ([]string, error) {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		DefineCommonFlags(flags)
+		DefineSearchKeyFlags(flags)
+		c.Assert(flags.Parse(append(args, "--storage", "local://"+dir)), IsNil)
+		cfg := &SearchKeyConfig{}
+		if err := cfg.ParseFromFlags(flags); err != nil {
+			return nil, err
+		}
+		found, err := SearchKey(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(found))
+		for _, f := range found {
+			names = append(names, f.File.Name)
+		}
+		return names, nil
+	}
+
+	names, err := search("--db-name", "TEST", "--table-name", "t", "--handle", "42")
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"2.sst"})
+	names, err = search("--db-name", "test", "--table-name", "t", "--handle", "100")
+	c.Assert(err, IsNil)
+	c.Assert(names, HasLen, 0)
+	// The row can be in any partition.
+	names, err = search("--db-name", "test", "--table-name", "p", "--handle", "1")
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"4.sst", "5.sst"})
+	names, err = search("--search-key", "7480000000000000045f728000000000000001")
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"4.sst"})
+
+	_, err = search("--db-name", "test", "--table-name", "missing", "--handle", "1")
+	c.Assert(err, ErrorMatches, ".*table test.missing not found in the backup.*")
+	_, err = search("--db-name", "test", "--table-name", "t")
+	c.Assert(err, ErrorMatches, ".*either --search-key or all of.*")
+}
@@ -0,0 +1,208 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/conn"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	flagBackupSettings  = "backup-settings"
+	flagClusterSettings = "cluster-settings"
+)
+
+// ClusterSettingsMode is how the cluster settings in the backup are handled
+// on restore.
+type ClusterSettingsMode string
+
+const (
+	// ClusterSettingsIgnore ignores the cluster settings in the backup.
+	ClusterSettingsIgnore ClusterSettingsMode = "ignore"
+	// ClusterSettingsReview reports the settings differing from the backup.
+	ClusterSettingsReview ClusterSettingsMode = "review"
+	// ClusterSettingsApply applies the settings differing from the backup.
+	ClusterSettingsApply ClusterSettingsMode = "apply"
+)
+
+// restorableGlobalVariables is the global variables which can be applied from
+// the backup. The others, e.g. the ones about the security, the GC and the
+// resources of the cluster, are only reviewed.
+var restorableGlobalVariables = map[string]struct{}{
+	"auto_increment_increment":        {},
+	"auto_increment_offset":           {},
+	"character_set_server":            {},
+	"collation_server":                {},
+	"default_week_format":             {},
+	"div_precision_increment":         {},
+	"explicit_defaults_for_timestamp": {},
+	"max_allowed_packet":              {},
+	"sql_mode":                        {},
+	"time_zone":                       {},
+	"tidb_enable_clustered_index":     {},
+	"tidb_row_format_version":         {},
+	"tidb_txn_mode":                   {},
+}
+
+// setGlobalVariableSQL returns the statement setting the global variable, or
+// false if the variable isn't restorable.
+func setGlobalVariableSQL(name, value string) (string, bool) {
+	name = strings.ToLower(name)
+	if _, ok := restorableGlobalVariables[name]; !ok {
+		return "", false
+	}
+	value = strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(value)
+	return fmt.Sprintf("SET GLOBAL %s = '%s'", name, value), true
+}
+
+func parseClusterSettingsMode(flags *pflag.FlagSet) (ClusterSettingsMode, error) {
+	if flags.Lookup(flagClusterSettings) == nil {
+		return ClusterSettingsIgnore, nil
+	}
+	mode, err := flags.GetString(flagClusterSettings)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	switch m := ClusterSettingsMode(strings.ToLower(mode)); m {
+	case ClusterSettingsIgnore, ClusterSettingsReview, ClusterSettingsApply:
+		return m, nil
+	default:
+		return "", errors.Annotatef(berrors.ErrInvalidArgument,
+			"invalid --%s %s, value can be one of 'ignore|review|apply'", flagClusterSettings, mode)
+	}
+}
+
+// clusterSettingsMode returns how the restore handles the cluster settings.
+// Restoring the schemas only doesn't apply the settings, they are reviewed
+// instead.
+func (cfg *RestoreConfig) clusterSettingsMode(schemaOnly bool) ClusterSettingsMode {
+	if schemaOnly && cfg.ClusterSettings == ClusterSettingsApply {
+		return ClusterSettingsReview
+	}
+	return cfg.ClusterSettings
+}
+
+// collectClusterSettings takes a snapshot of the settings of the cluster.
+func collectClusterSettings(ctx context.Context, g glue.Glue, mgr *conn.Mgr) (*utils.ClusterSettings, error) {
+	settings := &utils.ClusterSettings{}
+	se, err := g.CreateSession(mgr.GetTiKV())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer se.Close()
+	if vs, ok := se.(glue.GlobalVariableSession); ok {
+		settings.GlobalVariables, err = vs.GetGlobalVariables()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	} else {
+		log.Warn("the session cannot read global variables, skip them in the cluster settings")
+	}
+	settings.PDScheduleConfig, err = mgr.GetPDScheduleConfig(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return settings, nil
+}
+
+// backupClusterSettings saves a snapshot of the cluster settings into the
+// backup. The settings are only for reference, so the failures are ignored.
+func backupClusterSettings(ctx context.Context, g glue.Glue, mgr *conn.Mgr, s storage.ExternalStorage) {
+	settings, err := collectClusterSettings(ctx, g, mgr)
+	if err == nil {
+		err = utils.SaveClusterSettings(ctx, s, settings)
+	}
+	if err != nil {
+		log.Warn("failed to backup the cluster settings", zap.Error(err))
+		return
+	}
+	log.Info("backup the cluster settings",
+		zap.Int("global-variables", len(settings.GlobalVariables)),
+		zap.Int("pd-schedule-config", len(settings.PDScheduleConfig)))
+}
+
+// restoreClusterSettings reviews or applies the cluster settings in the
+// backup according to the mode.
+func restoreClusterSettings(
+	ctx context.Context,
+	g glue.Glue,
+	mgr *conn.Mgr,
+	s storage.ExternalStorage,
+	mode ClusterSettingsMode,
+) error {
+	if mode == ClusterSettingsIgnore {
+		return nil
+	}
+	backupSettings, err := utils.ReadClusterSettings(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if backupSettings == nil {
+		log.Warn("the backup doesn't contain the cluster settings")
+		return nil
+	}
+	current, err := collectClusterSettings(ctx, g, mgr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	changes := backupSettings.Diff(current)
+	summary.CollectInt("cluster settings differ", len(changes))
+	for _, change := range changes {
+		log.Info("cluster setting differs from the backup", zap.Stringer("setting", change))
+	}
+	if mode != ClusterSettingsApply || len(changes) == 0 {
+		return nil
+	}
+
+	se, err := g.CreateSession(mgr.GetTiKV())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer se.Close()
+	pdConfig := make(map[string]interface{})
+	for _, change := range changes {
+		switch change.Kind {
+		case "variable":
+			value, _ := change.Backup.(string)
+			sql, ok := setGlobalVariableSQL(change.Name, value)
+			if !ok {
+				log.Warn("skip applying global variable not restorable", zap.String("name", change.Name))
+				continue
+			}
+			if err := se.Execute(ctx, sql); err != nil {
+				// Some variables can't be set in the cluster, e.g. the version
+				// specific ones, the others are applied anyway.
+				log.Warn("failed to apply global variable", zap.String("name", change.Name), zap.Error(err))
+			}
+		case "pd":
+			switch change.Backup.(type) {
+			case map[string]interface{}, []interface{}:
+				// The composite items, e.g. the schedulers, are managed by
+				// their own API.
+				log.Warn("skip applying composite PD config", zap.String("name", change.Name))
+			default:
+				pdConfig[change.Name] = change.Backup
+			}
+		}
+	}
+	if len(pdConfig) > 0 {
+		if err := mgr.SetPDScheduleConfig(ctx, pdConfig); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	log.Info("applied the cluster settings of the backup", zap.Int("changes", len(changes)))
+	return nil
+}
@@ -0,0 +1,220 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/errors"
+	kvproto "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+	"github.com/pingcap/tidb/executor"
+	"github.com/pingcap/tidb/meta/autoid"
+	"github.com/pingcap/tidb/util/mock"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/backup"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	flagShowFormat = "format"
+
+	// ShowFormatTable prints the backups as a table.
+	ShowFormatTable = "table"
+	// ShowFormatJSON prints the backups as JSON.
+	ShowFormatJSON = "json"
+)
+
+// ShowConfig is the configuration specific for listing the backups under a
+// storage.
+type ShowConfig struct {
+	Config
+
+	// Format is the output format, either table or json.
+	Format string `json:"format" toml:"format"`
+}
+
+// DefineShowFlags defines the flags of the show command.
+func DefineShowFlags(flags *pflag.FlagSet) {
+	flags.String(flagShowFormat, ShowFormatTable, "the output format, either table or json")
+}
+
+// ParseFromFlags parses the show-related flags from the flag set.
+func (cfg *ShowConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	if err := cfg.Config.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	format, err := flags.GetString(flagShowFormat)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.Format = strings.ToLower(format)
+	if cfg.Format != ShowFormatTable && cfg.Format != ShowFormatJSON {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s must be %s or %s", flagShowFormat, ShowFormatTable, ShowFormatJSON)
+	}
+	return nil
+}
+
+// BackupInfo describes a backup found under the storage.
+type BackupInfo struct {
+	// Name is the path of the backup relative to the storage, the backup at
+	// the root of the storage is named "".
+	Name string `json:"name"`
+	// State is either TaskStateComplete or TaskStateIncomplete.
+	State        string `json:"state"`
+	ClusterID    uint64 `json:"cluster-id,omitempty"`
+	StartVersion uint64 `json:"start-version,omitempty"`
+	EndVersion   uint64 `json:"end-version,omitempty"`
+	// Size is the size of the archive, or of the data files written so far
+	// if the backup is incomplete.
+	Size uint64 `json:"size"`
+	// Tables is the tables in the backup as `db.table`, the empty databases
+	// are listed by their names. It's empty if the backup is incomplete.
+	Tables []string `json:"tables,omitempty"`
+}
+
+// ShowBackups describes the backups under the storage, ordered by the names.
+// The complete backups are listed by ListBackups, and the tables of them are
+// read from their backupmeta. A directory with the data files, the lock or
+// the checkpoint of a backup but without the backupmeta is an incomplete
+// backup. The backups whose backupmeta can't be read are skipped with a
+// warning.
+func ShowBackups(ctx context.Context, cfg *Config) ([]BackupInfo, error) {
+	u, s, err := GetStorage(ctx, cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	backups, err := listCatalogBackups(ctx, cfg, u, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	infos := make([]BackupInfo, 0, len(backups))
+	for _, b := range backups {
+		// The catalog doesn't record the tables.
+		meta, err := readBackupMetaAt(ctx, cfg, u, b.Name)
+		if err != nil {
+			log.Warn("skip the backup whose backupmeta can't be read", zap.String("backup", b.Name), zap.Error(err))
+			continue
+		}
+		tables, err := utils.BackupTableNames(meta)
+		if err != nil {
+			log.Warn("skip the backup whose schemas can't be parsed", zap.String("backup", b.Name), zap.Error(err))
+			continue
+		}
+		sort.Strings(tables)
+		infos = append(infos, BackupInfo{
+			Name:         b.Name,
+			State:        TaskStateComplete,
+			ClusterID:    b.ClusterID,
+			StartVersion: b.StartVersion,
+			EndVersion:   b.EndVersion,
+			Size:         b.Size,
+			Tables:       tables,
+		})
+	}
+	incomplete, err := incompleteBackups(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	infos = append(infos, incomplete...)
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos, nil
+}
+
+// incompleteBackups walks the storage for the directories with the data
+// files, the lock or the checkpoint of a backup but without the backupmeta.
+// The size of an incomplete backup is of the data files written so far.
+func incompleteBackups(ctx context.Context, s storage.ExternalStorage) ([]BackupInfo, error) {
+	complete := make(map[string]struct{})
+	backups := make(map[string]*BackupInfo)
+	err := s.WalkDir(ctx, &storage.WalkOption{}, func(file string, size int64) error {
+		file = filepath.ToSlash(file)
+		name := path.Dir(file)
+		if name == "." {
+			name = ""
+		}
+		switch base := path.Base(file); {
+		case base == utils.MetaFile:
+			complete[name] = struct{}{}
+			return nil
+		case base == utils.LockFile, base == backup.CheckpointFile, strings.HasSuffix(base, ".sst"):
+		default:
+			return nil
+		}
+		info, ok := backups[name]
+		if !ok {
+			info = &BackupInfo{Name: name, State: TaskStateIncomplete}
+			backups[name] = info
+		}
+		if strings.HasSuffix(file, ".sst") {
+			info.Size += uint64(size)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	infos := make([]BackupInfo, 0, len(backups))
+	for name, info := range backups {
+		if _, ok := complete[name]; !ok {
+			infos = append(infos, *info)
+		}
+	}
+	return infos, nil
+}
+
+// CreateTableStmt is the CREATE TABLE statement of a table in the backup.
+type CreateTableStmt struct {
+	DB    string
+	Table string
+	SQL   string
+}
+
+// ShowCreateTables returns the CREATE TABLE statements of the tables in the
+// backupmeta matching the table filter, ordered by the database and table
+// names.
+func ShowCreateTables(backupMeta *kvproto.BackupMeta, tableFilter filter.Filter) ([]CreateTableStmt, error) {
+	dbs, err := utils.LoadBackupTables(backupMeta)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	dbNames := make([]string, 0, len(dbs))
+	for name := range dbs {
+		dbNames = append(dbNames, name)
+	}
+	sort.Strings(dbNames)
+
+	sctx := mock.NewContext()
+	var stmts []CreateTableStmt
+	var buf bytes.Buffer
+	for _, dbName := range dbNames {
+		tables := dbs[dbName].Tables
+		sort.Slice(tables, func(i, j int) bool {
+			return tables[i].Info.Name.L < tables[j].Info.Name.L
+		})
+		for _, table := range tables {
+			if !tableFilter.MatchTable(dbName, table.Info.Name.O) {
+				continue
+			}
+			buf.Reset()
+			if err := executor.ConstructResultOfShowCreateTable(sctx, table.Info, autoid.Allocators{}, &buf); err != nil {
+				return nil, errors.Annotatef(err, "failed to show create table %s.%s", dbName, table.Info.Name)
+			}
+			stmts = append(stmts, CreateTableStmt{DB: dbName, Table: table.Info.Name.O, SQL: buf.String()})
+		}
+	}
+	return stmts, nil
+}
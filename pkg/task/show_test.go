@@ -0,0 +1,96 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gogo/protobuf/proto"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+var _ = Suite(&testShowSuite{})
+
+type testShowSuite struct{}
+
+func (*testShowSuite) TestShowBackups(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	cfg := &Config{Storage: "local://" + dir}
+
+	meta, err := proto.Marshal(&backup.BackupMeta{
+		ClusterId:  1,
+		EndVersion: 42,
+		Files:      []*backup.File{{Name: "1_2_default.sst", Size_: 4}},
+		Schemas: []*backup.Schema{
+			{Db: []byte(`{"id":1,"db_name":{"O":"test","L":"test"}}`), Table: []byte(`{"id":2,"name":{"O":"t2","L":"t2"}}`)},
+			{Db: []byte(`{"id":1,"db_name":{"O":"test","L":"test"}}`), Table: []byte(`{"id":3,"name":{"O":"t1","L":"t1"}}`)},
+			{Db: []byte(`{"id":4,"db_name":{"O":"empty","L":"empty"}}`)},
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(dir, "full"), 0o755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "full", utils.MetaFile), meta, 0o644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "full", "1_2_default.sst"), []byte("data"), 0o644), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(dir, "inc"), 0o755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "inc", utils.LockFile), []byte("lock"), 0o644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "inc", "1_3_default.sst"), []byte("data!"), 0o644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a backup"), 0o644), IsNil)
+	// The backup whose backupmeta can't be read is skipped.
+	c.Assert(os.MkdirAll(filepath.Join(dir, "broken"), 0o755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "broken", utils.MetaFile), []byte("not a backupmeta"), 0o644), IsNil)
+
+	backups, err := ShowBackups(ctx, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(backups, HasLen, 2)
+	c.Assert(backups[0].Name, Equals, "full")
+	c.Assert(backups[0].State, Equals, TaskStateComplete)
+	c.Assert(backups[0].ClusterID, Equals, uint64(1))
+	c.Assert(backups[0].EndVersion, Equals, uint64(42))
+	c.Assert(backups[0].Tables, DeepEquals, []string{"empty", "test.t1", "test.t2"})
+	c.Assert(backups[1], DeepEquals, BackupInfo{Name: "inc", State: TaskStateIncomplete, Size: 5})
+}
+
+func (*testShowSuite) TestShowCreateTables(c *C) {
+	db, err := json.Marshal(&model.DBInfo{ID: 1, Name: model.NewCIStr("test")})
+	c.Assert(err, IsNil)
+	newTable := func(id int64, name string) []byte {
+		col := &model.ColumnInfo{ID: 1, Name: model.NewCIStr("a"), State: model.StatePublic}
+		col.FieldType = *types.NewFieldType(mysql.TypeLong)
+		table, err := json.Marshal(&model.TableInfo{
+			ID:      id,
+			Name:    model.NewCIStr(name),
+			Columns: []*model.ColumnInfo{col},
+			State:   model.StatePublic,
+		})
+		c.Assert(err, IsNil)
+		return table
+	}
+	meta := &backup.BackupMeta{Schemas: []*backup.Schema{
+		{Db: db, Table: newTable(2, "t2")},
+		{Db: db, Table: newTable(3, "t1")},
+		{Db: db, Table: newTable(4, "skipped")},
+	}}
+	f, err := filter.Parse([]string{"test.t*"})
+	c.Assert(err, IsNil)
+
+	stmts, err := ShowCreateTables(meta, f)
+	c.Assert(err, IsNil)
+	c.Assert(stmts, HasLen, 2)
+	c.Assert(stmts[0].DB, Equals, "test")
+	c.Assert(stmts[0].Table, Equals, "t1")
+	c.Assert(stmts[0].SQL, Matches, "(?s)CREATE TABLE `t1` \\(.*`a` int.*")
+	c.Assert(stmts[1].Table, Equals, "t2")
+	c.Assert(stmts[1].SQL, Matches, "(?s)CREATE TABLE `t2` \\(.*")
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/summary"
+)
+
+// flagSplitKeysFile is the path of the file of the keys to split the regions at.
+const flagSplitKeysFile = "split-keys-file"
+
+// SplitConfig is the configuration specific for split tasks.
+type SplitConfig struct {
+	Config
+
+	// SplitKeysFile is the path of the file of the split keys, see
+	// restore.ParseSplitKeys for its format.
+	SplitKeysFile string `json:"split-keys-file" toml:"split-keys-file"`
+}
+
+// DefineSplitKeysFlags defines the flag of the split keys file.
+func DefineSplitKeysFlags(flags *pflag.FlagSet) {
+	flags.String(flagSplitKeysFile, "",
+		"the path of a file of the keys to split the regions at before ingesting, one per line, "+
+			"either `db.table handle` or a raw key in hex")
+}
+
+// ParseFromFlags parses the split-related flags from the flag set.
+func (cfg *SplitConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.SplitKeysFile, err = flags.GetString(flagSplitKeysFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.SplitKeysFile == "" {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s is required", flagSplitKeysFile)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+// loadSplitKeys reads the split keys from the file, nil means no split keys.
+func loadSplitKeys(path string) (*restore.SplitKeys, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read split keys file %s", path)
+	}
+	keys, err := restore.ParseSplitKeys(data)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to parse split keys file %s", path)
+	}
+	log.Info("split keys loaded", zap.String("file", path), zap.Int("keys", keys.Len()))
+	return keys, nil
+}
+
+// RunSplit splits the regions of the cluster at the keys of the file, so the
+// regions of the known hot spots are shaped before the data is loaded.
+func RunSplit(c context.Context, g glue.Glue, cmdName string, cfg *SplitConfig) error {
+	defer summary.Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	splitKeys, err := loadSplitKeys(cfg.SplitKeysFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.GRPCMaxMsgSize(), cfg.CheckRequirements)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer mgr.Close()
+
+	keys := splitKeys.RawKeys()
+	info := mgr.GetDomain().InfoSchema()
+	for _, name := range splitKeys.Tables() {
+		parts := strings.SplitN(name, ".", 2)
+		table, err := info.TableByName(model.NewCIStr(parts[0]), model.NewCIStr(parts[1]))
+		if err != nil {
+			return errors.Annotatef(err, "failed to find the table %s of the split keys", name)
+		}
+		keys = append(keys, splitKeys.TableKeys(parts[0], parts[1], table.Meta())...)
+	}
+
+	start := time.Now()
+	splitter := restore.NewRegionSplitter(restore.NewSplitClient(mgr.GetPDClient(), mgr.GetPDTLSConfig(), mgr.GetTLSConfig(), mgr.GetGRPCMaxMsgSize()))
+	split := 0
+	err = splitter.SplitKeys(ctx, keys, func(keys [][]byte) {
+		split += len(keys)
+	})
+	summary.CollectDuration("split region", time.Since(start))
+	summary.CollectInt("split keys", split)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	summary.SetSuccessStatus(true)
+	return nil
+}
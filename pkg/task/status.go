@@ -0,0 +1,104 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	kvproto "github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/backup"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	// TaskStateNotFound means there is no backup at the storage.
+	TaskStateNotFound = "not-found"
+	// TaskStateIncomplete means a backup has started at the storage, but
+	// hasn't finished. It may be still running or has failed.
+	TaskStateIncomplete = "incomplete"
+	// TaskStateComplete means the backup at the storage has finished.
+	TaskStateComplete = "complete"
+)
+
+// TaskStatus is the status of the backup at a storage.
+type TaskStatus struct {
+	Storage string `json:"storage"`
+	State   string `json:"state"`
+	// Resumable is whether the incomplete backup has a checkpoint, so it can
+	// be resumed by `--resume` from where it stopped.
+	Resumable bool `json:"resumable"`
+	// Progress is the finished fraction in [0, 1], or negative if unknown.
+	Progress float64 `json:"progress"`
+	// Files and Size are the count and total size of the data files.
+	Files    int    `json:"files"`
+	Size     uint64 `json:"size"`
+	BackupTS uint64 `json:"backup-ts,omitempty"`
+}
+
+// GetTaskStatus inspects the storage and reports the status of the backup.
+func GetTaskStatus(ctx context.Context, cfg *Config) (*TaskStatus, error) {
+	u, s, err := GetStorage(ctx, cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	storageURL := storage.FormatBackendURL(u)
+	status := &TaskStatus{
+		Storage:  storageURL.String(),
+		State:    TaskStateNotFound,
+		Progress: -1,
+	}
+
+	exists, err := s.FileExists(ctx, utils.MetaFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if exists {
+		metaStorage, err := openMetaStorage(ctx, cfg, s)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		metaData, err := utils.ReadMetaFile(ctx, metaStorage, utils.MetaFile)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		backupMeta := &kvproto.BackupMeta{}
+		if err = proto.Unmarshal(metaData, backupMeta); err != nil {
+			return nil, errors.Trace(err)
+		}
+		status.State = TaskStateComplete
+		status.Progress = 1
+		status.Files = len(backupMeta.Files)
+		status.Size = utils.ArchiveSize(backupMeta)
+		status.BackupTS = backupMeta.EndVersion
+		return status, nil
+	}
+
+	locked, err := s.FileExists(ctx, utils.LockFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	status.Resumable, err = s.FileExists(ctx, backup.CheckpointFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	err = s.WalkDir(ctx, &storage.WalkOption{}, func(path string, size int64) error {
+		if strings.HasSuffix(path, ".sst") {
+			status.Files++
+			status.Size += uint64(size)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// The total of an incomplete backup is only known by the running BR.
+	if locked || status.Resumable || status.Files > 0 {
+		status.State = TaskStateIncomplete
+	}
+	return status, nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/gogo/protobuf/proto"
+	. "github.com/pingcap/check"
+	kvproto "github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/backup"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+var _ = Suite(&testStatusSuite{})
+
+type testStatusSuite struct{}
+
+func (*testStatusSuite) TestGetTaskStatus(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	cfg := &Config{Storage: "local://" + dir}
+
+	status, err := GetTaskStatus(ctx, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(status.State, Equals, TaskStateNotFound)
+
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, utils.LockFile), []byte("lock"), 0o644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "1_2_default.sst"), []byte("data"), 0o644), IsNil)
+	status, err = GetTaskStatus(ctx, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(status.State, Equals, TaskStateIncomplete)
+	c.Assert(status.Resumable, IsFalse)
+	c.Assert(status.Progress < 0, IsTrue)
+	c.Assert(status.Files, Equals, 1)
+	c.Assert(status.Size, Equals, uint64(4))
+
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, backup.CheckpointFile), []byte(`{"ranges":[]}`), 0o644), IsNil)
+	status, err = GetTaskStatus(ctx, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(status.State, Equals, TaskStateIncomplete)
+	c.Assert(status.Resumable, IsTrue)
+
+	meta, err := proto.Marshal(&kvproto.BackupMeta{
+		EndVersion: 42,
+		Files:      []*kvproto.File{{Name: "1_2_default.sst", Size_: 4}},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, utils.MetaFile), meta, 0o644), IsNil)
+	status, err = GetTaskStatus(ctx, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(status.State, Equals, TaskStateComplete)
+	c.Assert(status.Progress, Equals, 1.0)
+	c.Assert(status.Files, Equals, 1)
+	c.Assert(status.BackupTS, Equals, uint64(42))
+}
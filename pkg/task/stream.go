@@ -0,0 +1,196 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/restore"
+)
+
+const (
+	flagStreamWatch   = "watch"
+	flagStreamMaxLag  = "max-lag"
+	flagStreamByStore = "by-store"
+)
+
+// StreamStatusConfig is the configuration specific for the log backup status.
+type StreamStatusConfig struct {
+	Config
+
+	// Watch is the interval of reporting the status repeatedly, zero means
+	// reporting once.
+	Watch time.Duration `json:"watch" toml:"watch"`
+	// MaxLag is the max lag of the checkpoint, the status fails once it's
+	// exceeded. Zero means no limit.
+	MaxLag time.Duration `json:"max-lag" toml:"max-lag"`
+	// ByStore aggregates the lags of the tables by the stores leading their
+	// regions, which needs PD.
+	ByStore bool `json:"by-store" toml:"by-store"`
+}
+
+// DefineStreamStatusFlags defines the flags of the log backup status.
+func DefineStreamStatusFlags(flags *pflag.FlagSet) {
+	flags.Duration(flagStreamWatch, 0, "report the status at the interval until it fails, 0 means report once")
+	flags.Duration(flagStreamMaxLag, 0,
+		"exit with non-zero status if the checkpoint lags behind more than it, 0 means no limit")
+	flags.Bool(flagStreamByStore, true,
+		"report the lag of every store by the laggiest table it leads the regions of, which needs --pd")
+}
+
+// ParseFromFlags parses the status-related flags from the flag set.
+func (cfg *StreamStatusConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.Watch, err = flags.GetDuration(flagStreamWatch); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.MaxLag, err = flags.GetDuration(flagStreamMaxLag); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ByStore, err = flags.GetBool(flagStreamByStore); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Watch < 0 || cfg.MaxLag < 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s and --%s must not be negative", flagStreamWatch, flagStreamMaxLag)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+// StreamTableLag is the lag of a table of the log backup.
+type StreamTableLag struct {
+	restore.LogTableStatus
+	// Lag is how long the latest file of the table lags behind, negative
+	// means no file has been rotated.
+	Lag time.Duration `json:"lag"`
+}
+
+// StreamStoreLag is the lag of a store of the log backup.
+type StreamStoreLag struct {
+	restore.LogStoreStatus
+	// Lag is how long the laggiest table of the store lags behind, negative
+	// means none of the tables has rotated a file.
+	Lag time.Duration `json:"lag"`
+}
+
+// StreamStatus is the status of the log backup reported at a time.
+type StreamStatus struct {
+	Time       time.Time        `json:"time"`
+	ResolvedTS uint64           `json:"resolved-ts"`
+	Lag        time.Duration    `json:"lag"`
+	Tables     []StreamTableLag `json:"tables"`
+	Stores     []StreamStoreLag `json:"stores"`
+	Files      int              `json:"files"`
+	Size       int64            `json:"size"`
+	// WriteRate is the bytes written to the storage per second since the
+	// previous report, negative means unknown.
+	WriteRate float64 `json:"write-rate"`
+	// Err is the error inspecting the log backup.
+	Err error `json:"-"`
+}
+
+func tsLagOrUnknown(ts uint64, now time.Time) time.Duration {
+	if ts == 0 {
+		return -1
+	}
+	return restore.TSLag(ts, now)
+}
+
+func newStreamStatus(
+	status *restore.LogBackupStatus, stores []restore.LogStoreStatus, now time.Time, prev *StreamStatus,
+) *StreamStatus {
+	s := &StreamStatus{
+		Time:       now,
+		ResolvedTS: status.ResolvedTS,
+		Lag:        restore.TSLag(status.ResolvedTS, now),
+		Tables:     make([]StreamTableLag, 0, len(status.Tables)),
+		Files:      status.Files,
+		Size:       status.Size,
+		WriteRate:  -1,
+	}
+	for _, table := range status.Tables {
+		s.Tables = append(s.Tables, StreamTableLag{LogTableStatus: table, Lag: tsLagOrUnknown(table.LastTS, now)})
+	}
+	for _, store := range stores {
+		s.Stores = append(s.Stores, StreamStoreLag{LogStoreStatus: store, Lag: tsLagOrUnknown(store.LastTS, now)})
+	}
+	if prev != nil && prev.Err == nil && now.After(prev.Time) {
+		s.WriteRate = float64(s.Size-prev.Size) / now.Sub(prev.Time).Seconds()
+	}
+	return s
+}
+
+// RunStreamStatus reports the status of the log backup at the storage by the
+// report function, repeatedly if watching. It fails once the checkpoint lags
+// behind more than the max lag, so it can be used by the monitoring. The
+// errors inspecting the log backup are reported instead when watching.
+func RunStreamStatus(ctx context.Context, cfg *StreamStatusConfig, report func(*StreamStatus)) error {
+	_, s, err := GetStorage(ctx, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var splitClient restore.SplitClient
+	if cfg.ByStore {
+		securityOption, pdTLSConf, err := pdSecurityOption(cfg.TLS)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		pdCtl, err := pdutil.NewPdController(ctx, strings.Join(cfg.PD, ","), pdTLSConf, securityOption, cfg.GRPCMaxMsgSize())
+		if err != nil {
+			return errors.Annotatef(err, "failed to connect PD for --%s", flagStreamByStore)
+		}
+		defer pdCtl.Close()
+		splitClient = restore.NewSplitClient(pdCtl.GetPDClient(), pdTLSConf, nil, cfg.GRPCMaxMsgSize())
+	}
+	var prev *StreamStatus
+	for {
+		now := time.Now()
+		var status *StreamStatus
+		logStatus, err := restore.GetLogBackupStatus(ctx, s)
+		var stores []restore.LogStoreStatus
+		if err == nil && splitClient != nil {
+			stores, err = restore.AggregateLogStatusByStore(ctx, splitClient, logStatus.Tables)
+		}
+		if err != nil {
+			if cfg.Watch == 0 {
+				return errors.Trace(err)
+			}
+			log.Warn("failed to inspect the log backup", zap.Error(err))
+			status = &StreamStatus{Time: now, Err: err}
+		} else {
+			status = newStreamStatus(logStatus, stores, now, prev)
+		}
+		report(status)
+		if err = checkStreamLag(status, cfg.MaxLag); err != nil {
+			return errors.Trace(err)
+		}
+		if cfg.Watch == 0 {
+			return nil
+		}
+		if status.Err == nil {
+			prev = status
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(cfg.Watch):
+		}
+	}
+}
+
+func checkStreamLag(status *StreamStatus, maxLag time.Duration) error {
+	if maxLag == 0 || status.Err != nil || status.Lag <= maxLag {
+		return nil
+	}
+	return errors.Annotatef(berrors.ErrPiTRCheckpointLag,
+		"the checkpoint %d lags behind %s, more than %s", status.ResolvedTS, status.Lag, maxLag)
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/store/tikv/oracle"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+var _ = Suite(&testStreamSuite{})
+
+type testStreamSuite struct{}
+
+func (*testStreamSuite) TestRunStreamStatus(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	cfg := &StreamStatusConfig{Config: Config{Storage: "local://" + dir}}
+	var statuses []*StreamStatus
+	report := func(status *StreamStatus) { statuses = append(statuses, status) }
+	c.Assert(RunStreamStatus(ctx, cfg, report), ErrorMatches, ".*log.meta.*")
+
+	now := time.Now()
+	resolvedTS := oracle.ComposeTS(now.Add(-time.Minute).UnixNano()/int64(time.Millisecond), 0)
+	fileTS := oracle.ComposeTS(now.Add(-2*time.Minute).UnixNano()/int64(time.Millisecond), 0)
+	meta := fmt.Sprintf(`{"names": {"45": "test.t1", "46": "test.t2"}, "global_resolved_ts": %d}`, resolvedTS)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "log.meta"), []byte(meta), 0o644), IsNil)
+	c.Assert(os.Mkdir(filepath.Join(dir, "t_45"), 0o755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "t_45", fmt.Sprintf("cdclog.%d", fileTS)), []byte("rows"), 0o644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "t_45", "cdclog"), []byte("row"), 0o644), IsNil)
+
+	c.Assert(RunStreamStatus(ctx, cfg, report), IsNil)
+	status := statuses[len(statuses)-1]
+	c.Assert(status.ResolvedTS, Equals, resolvedTS)
+	c.Assert(status.Lag >= time.Minute && status.Lag < 2*time.Minute, IsTrue, Commentf("lag %s", status.Lag))
+	c.Assert(status.WriteRate < 0, IsTrue)
+	c.Assert(status.Tables, HasLen, 2)
+	c.Assert(status.Tables[0].Name, Equals, "test.t1")
+	c.Assert(status.Tables[0].LastTS, Equals, fileTS)
+	c.Assert(status.Tables[0].Files, Equals, 2)
+	c.Assert(status.Tables[0].Lag >= 2*time.Minute, IsTrue)
+	c.Assert(status.Tables[1].Lag < 0, IsTrue)
+
+	cfg.MaxLag = 30 * time.Second
+	err := RunStreamStatus(ctx, cfg, report)
+	c.Assert(berrors.ErrPiTRCheckpointLag.Equal(err), IsTrue, Commentf("%s", err))
+	cfg.MaxLag = time.Hour
+	c.Assert(RunStreamStatus(ctx, cfg, report), IsNil)
+}
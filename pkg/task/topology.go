@@ -0,0 +1,77 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/conn"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// collectClusterTopology takes a snapshot of the topology of the cluster.
+func collectClusterTopology(ctx context.Context, mgr *conn.Mgr) (*utils.ClusterTopology, error) {
+	stores, err := mgr.GetPDClient().GetAllStores(ctx, pd.WithExcludeTombstone())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	regionCount, err := mgr.GetRegionCount(ctx, []byte{}, []byte{})
+	if err != nil {
+		log.Warn("failed to get the region count of the cluster", zap.Error(err))
+		regionCount = -1
+	}
+	return utils.NewClusterTopology(stores, regionCount), nil
+}
+
+// backupClusterTopology saves a snapshot of the cluster topology into the
+// backup, and returns it for the catalog. The topology is only for reference,
+// so the failures are ignored and nil is returned.
+func backupClusterTopology(ctx context.Context, mgr *conn.Mgr, s storage.ExternalStorage) *utils.ClusterTopology {
+	topology, err := collectClusterTopology(ctx, mgr)
+	if err == nil {
+		err = utils.SaveClusterTopology(ctx, s, topology)
+	}
+	if err != nil {
+		log.Warn("failed to backup the cluster topology", zap.Error(err))
+		return nil
+	}
+	tikv, tiflash := topology.StoreCount()
+	log.Info("backup the cluster topology",
+		zap.Int("tikv-stores", tikv),
+		zap.Int("tiflash-stores", tiflash),
+		zap.Int("regions", topology.RegionCount))
+	return topology
+}
+
+// checkClusterTopology warns the material differences of the restore target
+// from the source of the backup. The differences don't fail the restore.
+func checkClusterTopology(ctx context.Context, mgr *conn.Mgr, s storage.ExternalStorage) {
+	source, err := utils.ReadClusterTopology(ctx, s)
+	if err != nil {
+		log.Warn("failed to read the cluster topology of the backup", zap.Error(err))
+		return
+	}
+	if source == nil {
+		log.Info("the backup doesn't contain the cluster topology")
+		return
+	}
+	target, err := collectClusterTopology(ctx, mgr)
+	if err != nil {
+		log.Warn("failed to collect the cluster topology", zap.Error(err))
+		return
+	}
+	diffs := source.Diff(target)
+	for _, diff := range diffs {
+		log.Warn("the cluster topology differs from the backup", zap.String("diff", diff))
+	}
+	if len(diffs) > 0 {
+		summary.CollectWarning("differences of the cluster topology from the backup", len(diffs))
+	}
+}
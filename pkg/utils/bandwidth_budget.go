@@ -0,0 +1,195 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.etcd.io/etcd/clientv3"
+	"go.uber.org/zap"
+)
+
+const (
+	// bandwidthBudgetPrefix is the prefix of the leases of the running tasks
+	// sharing the bandwidth budget in the etcd of PD.
+	bandwidthBudgetPrefix = "/tidb/br/bandwidth-budget/"
+	// BandwidthBudgetTTL is the TTL (in seconds) of the lease of a task. The
+	// share of a task is released automatically once it exits without
+	// refreshing the lease.
+	BandwidthBudgetTTL = 30
+)
+
+// BandwidthLease is the value of the lease of a task sharing the budget.
+type BandwidthLease struct {
+	Owner string `json:"owner"`
+	// Ceiling is the aggregate rate limit (in bytes/s per TiKV node) the task
+	// is configured with.
+	Ceiling  uint64    `json:"ceiling"`
+	JoinedAt time.Time `json:"joined-at"`
+}
+
+// bandwidthShare returns the rate limit of every task leasing the budget.
+// The tasks may be configured with different ceilings, the lowest one wins.
+func bandwidthShare(leases []BandwidthLease) uint64 {
+	if len(leases) == 0 {
+		return 0
+	}
+	ceiling := leases[0].Ceiling
+	for _, lease := range leases[1:] {
+		if lease.Ceiling < ceiling {
+			ceiling = lease.Ceiling
+		}
+	}
+	share := ceiling / uint64(len(leases))
+	if share == 0 {
+		// Zero means no limit for TiKV.
+		share = 1
+	}
+	return share
+}
+
+// BandwidthBudget is a per-cluster aggregate rate limit leased among the
+// running BR tasks through PD, so the sum of their rates stays under the
+// ceiling. Every task gets an equal share, which is updated as tasks join
+// and leave.
+type BandwidthBudget struct {
+	// share is accessed atomically, keep it first for the 64-bit alignment.
+	share   uint64
+	cli     *clientv3.Client
+	key     string
+	leaseID clientv3.LeaseID
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// JoinBandwidthBudget leases a share of the budget with the ceiling (in
+// bytes/s per TiKV node), and keeps it alive until Leave is called.
+func JoinBandwidthBudget(ctx context.Context, cli *clientv3.Client, ceiling uint64) (*BandwidthBudget, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	owner := fmt.Sprintf("%s:%d", hostname, os.Getpid())
+	value, err := json.Marshal(BandwidthLease{Owner: owner, Ceiling: ceiling, JoinedAt: time.Now()})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	lease, err := cli.Grant(ctx, BandwidthBudgetTTL)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	key := fmt.Sprintf("%s%x", bandwidthBudgetPrefix, lease.ID)
+	if _, err = cli.Put(ctx, key, string(value), clientv3.WithLease(lease.ID)); err != nil {
+		_, _ = cli.Revoke(ctx, lease.ID)
+		return nil, errors.Trace(err)
+	}
+	keepCtx, cancel := context.WithCancel(context.Background())
+	keepCh, err := cli.KeepAlive(keepCtx, lease.ID)
+	if err != nil {
+		cancel()
+		_, _ = cli.Revoke(ctx, lease.ID)
+		return nil, errors.Trace(err)
+	}
+	b := &BandwidthBudget{
+		cli:     cli,
+		key:     key,
+		leaseID: lease.ID,
+		share:   ceiling,
+		cancel:  cancel,
+	}
+	// Watch before the first refresh, so no change is missed in between.
+	watchCh := cli.Watch(keepCtx, bandwidthBudgetPrefix, clientv3.WithPrefix())
+	if err = b.refresh(ctx); err != nil {
+		_ = b.Leave(ctx)
+		return nil, errors.Trace(err)
+	}
+	b.wg.Add(2)
+	go func() {
+		defer b.wg.Done()
+		for {
+			if _, ok := <-keepCh; !ok {
+				break
+			}
+		}
+		if keepCtx.Err() == nil {
+			log.Warn("bandwidth budget lease lost, keep the last share", zap.String("key", key))
+		}
+	}()
+	go func() {
+		defer b.wg.Done()
+		for resp := range watchCh {
+			if resp.Err() != nil {
+				log.Warn("failed to watch the bandwidth budget", zap.Error(resp.Err()))
+				continue
+			}
+			if err := b.refresh(keepCtx); err != nil && keepCtx.Err() == nil {
+				log.Warn("failed to refresh the bandwidth budget", zap.Error(err))
+			}
+		}
+	}()
+	log.Info("bandwidth budget joined", zap.String("key", key),
+		zap.Uint64("ceiling", ceiling), zap.Uint64("share", b.Share()))
+	return b, nil
+}
+
+func (b *BandwidthBudget) refresh(ctx context.Context) error {
+	resp, err := b.cli.Get(ctx, bandwidthBudgetPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	leases := make([]BandwidthLease, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var lease BandwidthLease
+		if err := json.Unmarshal(kv.Value, &lease); err != nil {
+			log.Warn("skip the malformed bandwidth lease", zap.ByteString("key", kv.Key), zap.Error(err))
+			continue
+		}
+		leases = append(leases, lease)
+	}
+	share := bandwidthShare(leases)
+	if share == 0 {
+		// Our own lease has expired, keep the last share.
+		return nil
+	}
+	if old := atomic.SwapUint64(&b.share, share); old != share {
+		log.Info("bandwidth share changed", zap.Int("tasks", len(leases)),
+			zap.Uint64("from", old), zap.Uint64("to", share))
+	}
+	return nil
+}
+
+// Share returns the rate limit (in bytes/s per TiKV node) of this task.
+func (b *BandwidthBudget) Share() uint64 {
+	return atomic.LoadUint64(&b.share)
+}
+
+// Limit returns the rate limit capped by the share, zero rate limit means no
+// limit. A nil budget returns the rate limit as is.
+func (b *BandwidthBudget) Limit(rateLimit uint64) uint64 {
+	if b == nil {
+		return rateLimit
+	}
+	if share := b.Share(); rateLimit == 0 || share < rateLimit {
+		return share
+	}
+	return rateLimit
+}
+
+// Leave releases the share of the budget to the other tasks.
+func (b *BandwidthBudget) Leave(ctx context.Context) error {
+	b.cancel()
+	b.wg.Wait()
+	if _, err := b.cli.Revoke(ctx, b.leaseID); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("bandwidth budget left", zap.String("key", b.key))
+	return nil
+}
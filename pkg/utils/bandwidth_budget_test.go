@@ -0,0 +1,32 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	. "github.com/pingcap/check"
+)
+
+type testBandwidthBudgetSuite struct{}
+
+var _ = Suite(&testBandwidthBudgetSuite{})
+
+func (s *testBandwidthBudgetSuite) TestBandwidthShare(c *C) {
+	c.Assert(bandwidthShare(nil), Equals, uint64(0))
+	c.Assert(bandwidthShare([]BandwidthLease{{Ceiling: 300 * MB}}), Equals, uint64(300*MB))
+	// The lowest ceiling wins.
+	c.Assert(bandwidthShare([]BandwidthLease{
+		{Ceiling: 300 * MB}, {Ceiling: 200 * MB}, {Ceiling: 400 * MB},
+	}), Equals, uint64(200*MB/3))
+	c.Assert(bandwidthShare([]BandwidthLease{{Ceiling: 1}, {Ceiling: 1}}), Equals, uint64(1))
+}
+
+func (s *testBandwidthBudgetSuite) TestBandwidthLimit(c *C) {
+	var b *BandwidthBudget
+	c.Assert(b.Limit(0), Equals, uint64(0))
+	c.Assert(b.Limit(100), Equals, uint64(100))
+
+	b = &BandwidthBudget{share: 50}
+	c.Assert(b.Limit(0), Equals, uint64(50))
+	c.Assert(b.Limit(100), Equals, uint64(50))
+	c.Assert(b.Limit(10), Equals, uint64(10))
+}
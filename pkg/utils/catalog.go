@@ -0,0 +1,125 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/errors"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// CatalogDir is the dir of the index of the backups under a storage prefix.
+const CatalogDir = "catalog"
+
+// CatalogBackupName returns the path of a backup in the catalog layout, i.e.
+// `<cluster-id>/<backup-id>` relative to the catalog, so the backups of a
+// cluster share a prefix for the lifecycle rules of the storage.
+func CatalogBackupName(clusterID uint64, backupID string) string {
+	return fmt.Sprintf("%d/%s", clusterID, backupID)
+}
+
+// CatalogEntry is a backup in the catalog.
+type CatalogEntry struct {
+	// Name is the path of the backup relative to the catalog.
+	Name         string `json:"name"`
+	ClusterID    uint64 `json:"cluster-id"`
+	StartVersion uint64 `json:"start-version"`
+	EndVersion   uint64 `json:"end-version"`
+	// Size is the total bytes of the backup files, zero if unknown.
+	Size uint64 `json:"size,omitempty"`
+	// TiKVStores, TiFlashStores and Regions summarize the topology of the
+	// source cluster, see ClusterTopology. They're zero if unknown.
+	TiKVStores    int `json:"tikv-stores,omitempty"`
+	TiFlashStores int `json:"tiflash-stores,omitempty"`
+	Regions       int `json:"regions,omitempty"`
+}
+
+// SetTopology records the summary of the topology of the source cluster, a
+// nil topology is unknown.
+func (e *CatalogEntry) SetTopology(topology *ClusterTopology) {
+	if topology == nil {
+		return
+	}
+	e.TiKVStores, e.TiFlashStores = topology.StoreCount()
+	if topology.RegionCount > 0 {
+		e.Regions = topology.RegionCount
+	}
+}
+
+// Catalog indexes the backups under a storage prefix. Every backup has its own
+// entry file `<CatalogDir>/<name>.json`, so the backups of different clusters
+// sharing the storage never overwrite the entries of each other.
+type Catalog struct {
+	Backups []CatalogEntry `json:"backups"`
+}
+
+// catalogEntryPath returns the path of the entry file of the backup.
+func catalogEntryPath(name string) string {
+	return path.Join(CatalogDir, name+".json")
+}
+
+// ReadCatalog reads the catalog from the storage, it returns nil if there's
+// no catalog. The backups are ordered by the clusters and then the end
+// versions.
+func ReadCatalog(ctx context.Context, s storage.ExternalStorage) (*Catalog, error) {
+	c := new(Catalog)
+	err := s.WalkDir(ctx, &storage.WalkOption{SubDir: CatalogDir + "/"}, func(file string, _ int64) error {
+		file = filepath.ToSlash(file)
+		if !strings.HasPrefix(file, CatalogDir+"/") || !strings.HasSuffix(file, ".json") {
+			return nil
+		}
+		data, err := s.Read(ctx, file)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		var entry CatalogEntry
+		if err = json.Unmarshal(data, &entry); err != nil {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "invalid catalog entry %s: %v", file, err)
+		}
+		c.Backups = append(c.Backups, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(c.Backups) == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(c.Backups, func(i, j int) bool {
+		if c.Backups[i].ClusterID != c.Backups[j].ClusterID {
+			return c.Backups[i].ClusterID < c.Backups[j].ClusterID
+		}
+		return c.Backups[i].EndVersion < c.Backups[j].EndVersion
+	})
+	return c, nil
+}
+
+// AddToCatalog adds the backup to the catalog in the storage, replacing the
+// one of the same name. Only the entry of the backup is written.
+func AddToCatalog(ctx context.Context, s storage.ExternalStorage, entry CatalogEntry) error {
+	data, err := json.MarshalIndent(&entry, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.Write(ctx, catalogEntryPath(entry.Name), data))
+}
+
+// RemoveFromCatalog removes the backup from the catalog in the storage, and
+// returns whether it's found.
+func RemoveFromCatalog(ctx context.Context, s storage.ExternalStorage, name string) (bool, error) {
+	entryPath := catalogEntryPath(name)
+	exists, err := s.FileExists(ctx, entryPath)
+	if err != nil || !exists {
+		return false, errors.Trace(err)
+	}
+	return true, errors.Trace(s.DeleteFile(ctx, entryPath))
+}
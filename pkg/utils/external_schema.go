@@ -0,0 +1,273 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// externalSchemaMagic is the header of the table schema in the backupmeta
+// holding an ExternalSchema instead of the schema itself.
+var externalSchemaMagic = []byte("BR-EXTERNAL-SCHEMA\n")
+
+// externalSchemaConcurrency is the number of the concurrent requests reading
+// or writing the external schemas.
+const externalSchemaConcurrency = 64
+
+// ExternalSchema references the schema and the stats of a table stored out
+// of the backupmeta, so parsing the backupmeta of a cluster with a huge
+// count of tables stays fast, and a restore loads only the schemas of the
+// tables it restores.
+type ExternalSchema struct {
+	// Table is the name of the table, for filtering without loading.
+	Table  string `json:"table"`
+	Path   string `json:"path"`
+	Size   int    `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// externalSchemaBlob is the content of an external schema.
+type externalSchemaBlob struct {
+	Table json.RawMessage `json:"table"`
+	Stats json.RawMessage `json:"stats,omitempty"`
+}
+
+func decodeExternalSchema(schema *backup.Schema) (*ExternalSchema, bool, error) {
+	if !bytes.HasPrefix(schema.Table, externalSchemaMagic) {
+		return nil, false, nil
+	}
+	ref := &ExternalSchema{}
+	if err := json.Unmarshal(schema.Table[len(externalSchemaMagic):], ref); err != nil {
+		return nil, true, errors.Annotate(berrors.ErrRestoreInvalidBackup, err.Error())
+	}
+	return ref, true, nil
+}
+
+// HasExternalSchemas checks whether any table schema of the backupmeta is
+// stored out of it.
+func HasExternalSchemas(meta *backup.BackupMeta) bool {
+	for _, schema := range meta.Schemas {
+		if bytes.HasPrefix(schema.Table, externalSchemaMagic) {
+			return true
+		}
+	}
+	return false
+}
+
+// BackupTableNames returns the names of the tables in the backupmeta as
+// `db.table`, without loading the external schemas. The empty databases are
+// listed by their names.
+func BackupTableNames(meta *backup.BackupMeta) ([]string, error) {
+	names := make([]string, 0, len(meta.Schemas))
+	for _, schema := range meta.Schemas {
+		var db struct {
+			Name model.CIStr `json:"db_name"`
+		}
+		if err := json.Unmarshal(schema.Db, &db); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(schema.Table) == 0 {
+			names = append(names, db.Name.O)
+			continue
+		}
+		ref, ok, err := decodeExternalSchema(schema)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if ok {
+			names = append(names, db.Name.O+"."+ref.Table)
+			continue
+		}
+		var table struct {
+			Name model.CIStr `json:"name"`
+		}
+		if err = json.Unmarshal(schema.Table, &table); err != nil {
+			return nil, errors.Trace(err)
+		}
+		names = append(names, db.Name.O+"."+table.Name.O)
+	}
+	return names, nil
+}
+
+// SchemaStorageFunc returns the storage of the external schemas of the
+// tables in the database, e.g. encrypting them by the key of the database.
+type SchemaStorageFunc func(db string) (storage.ExternalStorage, error)
+
+// ExternalizeSchemas writes the schema and the stats of every table into an
+// object of its own under `schemas/<db id>/<table id>.json`, and replaces
+// them in the backupmeta by the references.
+func ExternalizeSchemas(ctx context.Context, s storage.ExternalStorage, meta *backup.BackupMeta) error {
+	return ExternalizeSchemasBy(ctx, func(string) (storage.ExternalStorage, error) { return s, nil }, meta)
+}
+
+// ExternalizeSchemasBy is like ExternalizeSchemas, with the storage of every
+// database returned by storageOf.
+func ExternalizeSchemasBy(ctx context.Context, storageOf SchemaStorageFunc, meta *backup.BackupMeta) error {
+	pool := NewWorkerPool(externalSchemaConcurrency, "externalize schemas")
+	eg, ectx := errgroup.WithContext(ctx)
+	var written int64
+	for _, schema := range meta.Schemas {
+		schema := schema
+		// The schemas of the empty databases have no table.
+		if len(schema.Table) == 0 || bytes.HasPrefix(schema.Table, externalSchemaMagic) {
+			continue
+		}
+		pool.ApplyOnErrorGroup(eg, func() error {
+			var db struct {
+				ID   int64       `json:"id"`
+				Name model.CIStr `json:"db_name"`
+			}
+			var table struct {
+				ID   int64       `json:"id"`
+				Name model.CIStr `json:"name"`
+			}
+			if err := json.Unmarshal(schema.Db, &db); err != nil {
+				return errors.Trace(err)
+			}
+			if err := json.Unmarshal(schema.Table, &table); err != nil {
+				return errors.Trace(err)
+			}
+			data, err := json.Marshal(externalSchemaBlob{Table: schema.Table, Stats: schema.Stats})
+			if err != nil {
+				return errors.Trace(err)
+			}
+			checksum := sha256.Sum256(data)
+			ref := ExternalSchema{
+				Table:  table.Name.O,
+				Path:   fmt.Sprintf("schemas/%d/%d.json", db.ID, table.ID),
+				Size:   len(data),
+				Sha256: hex.EncodeToString(checksum[:]),
+			}
+			s, err := storageOf(db.Name.O)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if err = s.Write(ectx, ref.Path, data); err != nil {
+				return errors.Trace(err)
+			}
+			refData, err := json.Marshal(&ref)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			schema.Table = append(append([]byte{}, externalSchemaMagic...), refData...)
+			schema.Stats = nil
+			atomic.AddInt64(&written, 1)
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("table schemas stored out of the backupmeta", zap.Int64("tables", written))
+	return nil
+}
+
+// LoadExternalSchemas loads the external schemas of the tables matched, and
+// drops the schemas of the other tables from the backupmeta, so the
+// backupmeta can be read like the one embedding the schemas. A nil match
+// loads all the schemas.
+func LoadExternalSchemas(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	meta *backup.BackupMeta,
+	match func(db, table string) bool,
+) error {
+	return LoadExternalSchemasBy(ctx, func(string) (storage.ExternalStorage, error) { return s, nil }, meta, match)
+}
+
+// LoadExternalSchemasBy is like LoadExternalSchemas, with the storage of
+// every database returned by storageOf. Only the storages of the databases
+// matched are required.
+func LoadExternalSchemasBy(
+	ctx context.Context,
+	storageOf SchemaStorageFunc,
+	meta *backup.BackupMeta,
+	match func(db, table string) bool,
+) error {
+	schemas := meta.Schemas[:0]
+	pool := NewWorkerPool(externalSchemaConcurrency, "load schemas")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	eg, ectx := errgroup.WithContext(ctx)
+	var loaded int64
+	// scheduleErr stops scheduling the schemas, the ones scheduled are still
+	// waited by eg, so no goroutine outlives the call.
+	var scheduleErr error
+	for _, schema := range meta.Schemas {
+		schema := schema
+		ref, ok, err := decodeExternalSchema(schema)
+		if err != nil {
+			scheduleErr = errors.Trace(err)
+			break
+		}
+		if !ok {
+			schemas = append(schemas, schema)
+			continue
+		}
+		var db struct {
+			Name model.CIStr `json:"db_name"`
+		}
+		if err = json.Unmarshal(schema.Db, &db); err != nil {
+			scheduleErr = errors.Trace(err)
+			break
+		}
+		if match != nil && !match(db.Name.O, ref.Table) {
+			continue
+		}
+		s, err := storageOf(db.Name.O)
+		if err != nil {
+			scheduleErr = errors.Trace(err)
+			break
+		}
+		schemas = append(schemas, schema)
+		pool.ApplyOnErrorGroup(eg, func() error {
+			data, err := s.Read(ectx, ref.Path)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			checksum := sha256.Sum256(data)
+			if len(data) != ref.Size || hex.EncodeToString(checksum[:]) != ref.Sha256 {
+				return errors.Annotatef(berrors.ErrRestoreInvalidBackup, "schema %s is corrupted", ref.Path)
+			}
+			blob := externalSchemaBlob{}
+			if err = json.Unmarshal(data, &blob); err != nil {
+				return errors.Annotatef(berrors.ErrRestoreInvalidBackup, "schema %s: %v", ref.Path, err)
+			}
+			schema.Table = blob.Table
+			schema.Stats = blob.Stats
+			atomic.AddInt64(&loaded, 1)
+			return nil
+		})
+	}
+	if scheduleErr != nil {
+		cancel()
+	}
+	if err := eg.Wait(); scheduleErr == nil && err != nil {
+		scheduleErr = errors.Trace(err)
+	}
+	if scheduleErr != nil {
+		return scheduleErr
+	}
+	if loaded > 0 {
+		log.Info("external table schemas loaded",
+			zap.Int64("loaded", loaded), zap.Int("skipped", len(meta.Schemas)-len(schemas)))
+	}
+	meta.Schemas = schemas
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+type testExternalSchemaSuite struct{}
+
+// slowStorage reads slowly, and counts the reads in flight.
+type slowStorage struct {
+	storage.ExternalStorage
+	inflight int64
+}
+
+func (s *slowStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	atomic.AddInt64(&s.inflight, 1)
+	defer atomic.AddInt64(&s.inflight, -1)
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(50 * time.Millisecond):
+	}
+	return s.ExternalStorage.Read(ctx, name)
+}
+
+var _ = Suite(&testExternalSchemaSuite{})
+
+func (s *testExternalSchemaSuite) TestExternalSchemas(c *C) {
+	ctx := context.Background()
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	newSchema := func(dbID int64, db string, tableID int64, table string) *backup.Schema {
+		dbData, err := json.Marshal(&model.DBInfo{ID: dbID, Name: model.NewCIStr(db)})
+		c.Assert(err, IsNil)
+		tableData, err := json.Marshal(&model.TableInfo{ID: tableID, Name: model.NewCIStr(table)})
+		c.Assert(err, IsNil)
+		return &backup.Schema{Db: dbData, Table: tableData, Stats: []byte(`{"count":1}`), TotalKvs: 10}
+	}
+	newMeta := func() *backup.BackupMeta {
+		return &backup.BackupMeta{Schemas: []*backup.Schema{
+			newSchema(1, "test", 10, "t1"),
+			newSchema(1, "test", 11, "t2"),
+			newSchema(2, "other", 20, "t1"),
+		}}
+	}
+	original := newMeta()
+
+	meta := newMeta()
+	c.Assert(HasExternalSchemas(meta), IsFalse)
+	c.Assert(ExternalizeSchemas(ctx, store, meta), IsNil)
+	c.Assert(HasExternalSchemas(meta), IsTrue)
+	c.Assert(meta.Schemas[0].Stats, IsNil)
+	c.Assert(meta.Schemas[0].TotalKvs, Equals, uint64(10))
+	exists, err := store.FileExists(ctx, "schemas/1/10.json")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsTrue)
+	externalized := *meta.Schemas[1]
+
+	// Only the schemas of the tables matched are loaded.
+	c.Assert(LoadExternalSchemas(ctx, store, meta, func(db, table string) bool {
+		return db == "test" && table == "t2"
+	}), IsNil)
+	c.Assert(meta.Schemas, HasLen, 1)
+	c.Assert(meta.Schemas[0].Table, DeepEquals, original.Schemas[1].Table)
+	c.Assert(meta.Schemas[0].Stats, DeepEquals, original.Schemas[1].Stats)
+	c.Assert(HasExternalSchemas(meta), IsFalse)
+
+	// All the schemas are loaded without the filter.
+	meta = newMeta()
+	c.Assert(ExternalizeSchemas(ctx, store, meta), IsNil)
+	c.Assert(LoadExternalSchemas(ctx, store, meta, nil), IsNil)
+	c.Assert(meta, DeepEquals, original)
+
+	// The corrupted schema is rejected.
+	c.Assert(store.Write(ctx, "schemas/1/11.json", []byte(`{}`)), IsNil)
+	meta = &backup.BackupMeta{Schemas: []*backup.Schema{&externalized}}
+	c.Assert(LoadExternalSchemas(ctx, store, meta, nil), ErrorMatches, ".*schemas/1/11.json is corrupted.*")
+
+	// The loads scheduled are done before the failure is returned.
+	meta = newMeta()
+	c.Assert(ExternalizeSchemas(ctx, store, meta), IsNil)
+	slow := &slowStorage{ExternalStorage: store}
+	err = LoadExternalSchemasBy(ctx, func(db string) (storage.ExternalStorage, error) {
+		if db == "other" {
+			return nil, errors.New("no storage")
+		}
+		return slow, nil
+	}, meta, nil)
+	c.Assert(err, ErrorMatches, "no storage")
+	c.Assert(atomic.LoadInt64(&slow.inflight), Equals, int64(0))
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/pingcap/errors"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// FormatFile represents the file name describing the format of the backup.
+// It's written before the backupmeta and checked before reading it, so a BR
+// can't read the backup fails early and clearly.
+const FormatFile = "backup.format"
+
+// FormatVersion is the version of the backup format written by this BR.
+const FormatVersion = 1
+
+// FormatFeature is a feature of the backup format which changes how the
+// backup must be read.
+type FormatFeature uint64
+
+// The features of the backup format.
+const (
+	// FormatEncrypted means the meta files are encrypted by the data key in
+	// the backup, see encryption.DataKey.
+	FormatEncrypted FormatFeature = 1 << iota
+	// FormatCompressedMeta means the meta files are compressed.
+	FormatCompressedMeta
+	// FormatShardedMeta means the meta files are uploaded in chunks.
+	FormatShardedMeta
+	// FormatRawAPIV2 means the raw kv data is encoded in API V2.
+	FormatRawAPIV2
+	// FormatExternalSchemas means the table schemas are stored out of the
+	// backupmeta, see ExternalSchema.
+	FormatExternalSchemas
+)
+
+var formatFeatureNames = []struct {
+	feature FormatFeature
+	name    string
+}{
+	{FormatEncrypted, "encrypted"},
+	{FormatCompressedMeta, "compressed meta"},
+	{FormatShardedMeta, "sharded meta"},
+	{FormatRawAPIV2, "raw API v2"},
+	{FormatExternalSchemas, "external schemas"},
+}
+
+// supportedFormatFeatures are the features this BR can read.
+const supportedFormatFeatures = FormatEncrypted | FormatCompressedMeta | FormatShardedMeta | FormatExternalSchemas
+
+// String implements fmt.Stringer.
+func (f FormatFeature) String() string {
+	names := make([]string, 0, len(formatFeatureNames))
+	for _, n := range formatFeatureNames {
+		if f&n.feature != 0 {
+			names = append(names, n.name)
+			f &^= n.feature
+		}
+	}
+	if f != 0 {
+		names = append(names, "unknown")
+	}
+	return strings.Join(names, ",")
+}
+
+// BackupFormat describes the format of the backup.
+type BackupFormat struct {
+	Version  uint32        `json:"version"`
+	Features FormatFeature `json:"features"`
+	// MinBRVersion is the version of the BR which wrote the backup with
+	// features, any BR not older than it can read the backup.
+	MinBRVersion string `json:"min-br-version,omitempty"`
+	// SchemaOnly means the backup has the schemas of the tables without their
+	// data, which is written by `br backup --schema-only`.
+	SchemaOnly bool `json:"schema-only,omitempty"`
+}
+
+// NewBackupFormat returns the format of the backup whose backupmeta has the
+// size.
+func NewBackupFormat(metaSize int) *BackupFormat {
+	format := &BackupFormat{Version: FormatVersion}
+	if uint64(metaSize) > metaChunkThreshold {
+		format.Features |= FormatCompressedMeta | FormatShardedMeta
+	}
+	if format.Features != 0 {
+		format.MinBRVersion = BRReleaseVersion
+	}
+	return format
+}
+
+// Require adds the features to the format, so only the BRs supporting them
+// read the backup.
+func (format *BackupFormat) Require(features FormatFeature) {
+	format.Features |= features
+	format.MinBRVersion = BRReleaseVersion
+}
+
+// SaveBackupFormat writes the backup format to the storage.
+func SaveBackupFormat(ctx context.Context, s storage.ExternalStorage, format *BackupFormat) error {
+	data, err := json.Marshal(format)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.Write(ctx, FormatFile, data))
+}
+
+// CheckBackupFormat checks this BR can read the backup. The backups without
+// the format file are written by older BRs, which are always readable.
+func CheckBackupFormat(ctx context.Context, s storage.ExternalStorage) error {
+	_, err := ReadBackupFormat(ctx, s)
+	return errors.Trace(err)
+}
+
+// ReadBackupFormat reads the format of the backup and checks this BR can read
+// it, nil is returned if the backup has no format file.
+func ReadBackupFormat(ctx context.Context, s storage.ExternalStorage) (*BackupFormat, error) {
+	exists, err := s.FileExists(ctx, FormatFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.Read(ctx, FormatFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	format := &BackupFormat{}
+	if err = json.Unmarshal(data, format); err != nil {
+		return nil, errors.Annotatef(err, "failed to parse %s", FormatFile)
+	}
+	if err = format.check(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return format, nil
+}
+
+func (format *BackupFormat) check() error {
+	unsupported := format.Features &^ supportedFormatFeatures
+	if format.Version <= FormatVersion && unsupported == 0 {
+		return nil
+	}
+	required := format.MinBRVersion
+	if required == "" {
+		required = "the version writing it"
+	}
+	if format.Version > FormatVersion {
+		return errors.Annotatef(berrors.ErrRestoreIncompatibleFormat,
+			"this backup requires BR >= %s, the format version %d is newer than %d of this BR (%s)",
+			required, format.Version, FormatVersion, BRReleaseVersion)
+	}
+	return errors.Annotatef(berrors.ErrRestoreIncompatibleFormat,
+		"this backup requires BR >= %s, the features [%s] aren't supported by this BR (%s)",
+		required, unsupported, BRReleaseVersion)
+}
@@ -0,0 +1,40 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+type testFormatSuite struct{}
+
+var _ = Suite(&testFormatSuite{})
+
+func (s *testFormatSuite) TestCheckBackupFormat(c *C) {
+	ctx := context.Background()
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	// The backups of older BRs have no format file.
+	c.Assert(CheckBackupFormat(ctx, store), IsNil)
+
+	format := NewBackupFormat(1024)
+	c.Assert(format, DeepEquals, &BackupFormat{Version: FormatVersion})
+	format = NewBackupFormat(int(metaChunkThreshold) + 1)
+	c.Assert(format.Features, Equals, FormatCompressedMeta|FormatShardedMeta)
+	c.Assert(SaveBackupFormat(ctx, store, format), IsNil)
+	c.Assert(CheckBackupFormat(ctx, store), IsNil)
+
+	format = &BackupFormat{Version: FormatVersion, Features: FormatRawAPIV2 | FormatShardedMeta | 1<<10, MinBRVersion: "v9.0.0"}
+	c.Assert(SaveBackupFormat(ctx, store, format), IsNil)
+	c.Assert(CheckBackupFormat(ctx, store), ErrorMatches,
+		`.*requires BR >= v9.0.0, the features \[raw API v2,unknown\] aren't supported.*`)
+
+	format = &BackupFormat{Version: FormatVersion + 1}
+	c.Assert(SaveBackupFormat(ctx, store, format), IsNil)
+	c.Assert(CheckBackupFormat(ctx, store), ErrorMatches,
+		`.*requires BR >= the version writing it, the format version 2 is newer.*`)
+}
@@ -0,0 +1,219 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+	"math"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pingcap/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/logutil"
+)
+
+const (
+	// GRPCCompressionNone disables gRPC compression.
+	GRPCCompressionNone = "none"
+	// GRPCCompressionGzip compresses gRPC messages with gzip.
+	GRPCCompressionGzip = "gzip"
+	// GRPCCompressionZstd compresses gRPC messages with zstd.
+	GRPCCompressionZstd = "zstd"
+
+	// DefaultGRPCMaxMsgSize is the default max size of the gRPC messages BR
+	// sends and receives, e.g. ScanRegions of PD may return a large response.
+	DefaultGRPCMaxMsgSize = 128 * MB
+
+	// GRPCMetadataTaskID, GRPCMetadataUser and GRPCMetadataCommand are the
+	// keys of the gRPC metadata identifying the BR invocation sending the
+	// request, so the slow logs and audits of TiKV and PD can attribute the
+	// load to it.
+	GRPCMetadataTaskID  = "br-task-id"
+	GRPCMetadataUser    = "br-user"
+	GRPCMetadataCommand = "br-command"
+)
+
+// GRPCMaxMsgSize is the max size of the gRPC messages BR receives and sends,
+// zero means DefaultGRPCMaxMsgSize.
+type GRPCMaxMsgSize struct {
+	Recv uint64
+	Send uint64
+}
+
+// RecvSize returns the max size of the gRPC messages BR receives.
+func (s GRPCMaxMsgSize) RecvSize() int {
+	return clampMsgSize(s.Recv)
+}
+
+// SendSize returns the max size of the gRPC messages BR sends, the batch
+// requests larger than it should be chunked.
+func (s GRPCMaxMsgSize) SendSize() int {
+	return clampMsgSize(s.Send)
+}
+
+// DialOptions returns the dial options of the max size of the gRPC messages.
+func (s GRPCMaxMsgSize) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(s.RecvSize()),
+			grpc.MaxCallSendMsgSize(s.SendSize()),
+		),
+	}
+}
+
+// clampMsgSize returns the size, DefaultGRPCMaxMsgSize if it's zero, clamped
+// to the max message size gRPC supports.
+func clampMsgSize(size uint64) int {
+	if size == 0 {
+		return int(DefaultGRPCMaxMsgSize)
+	}
+	if size > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(size)
+}
+
+// grpcCommand is the command of this BR invocation, e.g. `br backup full`.
+var grpcCommand atomic.Value
+
+// SetGRPCCommand sets the command attached to the RPCs sent afterwards.
+func SetGRPCCommand(command string) {
+	grpcCommand.Store(command)
+}
+
+var (
+	grpcUserOnce sync.Once
+	grpcUser     string
+)
+
+// currentUser returns the OS user running BR.
+func currentUser() string {
+	grpcUserOnce.Do(func() {
+		if u, err := user.Current(); err == nil {
+			grpcUser = u.Username
+		} else {
+			grpcUser = os.Getenv("USER")
+		}
+	})
+	return grpcUser
+}
+
+// grpcMetadataValue replaces the characters not allowed in the gRPC metadata.
+func grpcMetadataValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return '?'
+		}
+		return r
+	}, s)
+}
+
+// withTaskMetadata attaches the identity of the BR invocation to the
+// outgoing context.
+func withTaskMetadata(ctx context.Context) context.Context {
+	kv := []string{GRPCMetadataTaskID, logutil.TaskIDFromContext(ctx)}
+	if name := currentUser(); name != "" {
+		kv = append(kv, GRPCMetadataUser, grpcMetadataValue(name))
+	}
+	if command, ok := grpcCommand.Load().(string); ok && command != "" {
+		kv = append(kv, GRPCMetadataCommand, grpcMetadataValue(command))
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// GRPCTaskDialOptions returns the dial options attaching the task ID, the
+// user and the command of this BR invocation to every RPC as the metadata.
+func GRPCTaskDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(func(
+			ctx context.Context, method string, req, reply interface{},
+			cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption,
+		) error {
+			return invoker(withTaskMetadata(ctx), method, req, reply, cc, opts...)
+		}),
+		grpc.WithChainStreamInterceptor(func(
+			ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+			method string, streamer grpc.Streamer, opts ...grpc.CallOption,
+		) (grpc.ClientStream, error) {
+			return streamer(withTaskMetadata(ctx), desc, cc, method, opts...)
+		}),
+	}
+}
+
+// ParseGRPCCompression parses the gRPC compression algorithm.
+func ParseGRPCCompression(s string) (string, error) {
+	switch name := strings.ToLower(s); name {
+	case "", GRPCCompressionNone:
+		return GRPCCompressionNone, nil
+	case GRPCCompressionGzip:
+		return name, nil
+	case GRPCCompressionZstd:
+		// TiKV (grpcio) only accepts gzip and deflate message encodings.
+		return "", errors.Annotate(berrors.ErrInvalidArgument,
+			"zstd gRPC compression is not supported by TiKV yet, please use gzip")
+	default:
+		return "", errors.Annotatef(berrors.ErrInvalidArgument,
+			"unknown gRPC compression %s, must be one of none|gzip", s)
+	}
+}
+
+// GRPCCompressionDialOptions returns the dial options enabling the compression.
+// The returned options also record the size of messages in the wire stats if
+// it isn't nil.
+func GRPCCompressionDialOptions(name string, wireStats *GRPCWireStats) []grpc.DialOption {
+	if name != GRPCCompressionGzip {
+		return nil
+	}
+	opts := []grpc.DialOption{grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name))}
+	if wireStats != nil {
+		opts = append(opts, grpc.WithStatsHandler(wireStats))
+	}
+	return opts
+}
+
+// GRPCWireStats collects the size of the payloads of all RPCs of a task, it's
+// the stats handler of the connections of the task.
+type GRPCWireStats struct {
+	payloadBytes uint64
+	wireBytes    uint64
+}
+
+// Bytes returns the total size of the gRPC messages, and the total size on
+// the wire, i.e. after compression.
+func (h *GRPCWireStats) Bytes() (payloadBytes, wireBytes uint64) {
+	return atomic.LoadUint64(&h.payloadBytes), atomic.LoadUint64(&h.wireBytes)
+}
+
+// TagRPC implements stats.Handler.
+func (h *GRPCWireStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+// HandleRPC implements stats.Handler.
+func (h *GRPCWireStats) HandleRPC(_ context.Context, s stats.RPCStats) {
+	switch p := s.(type) {
+	case *stats.InPayload:
+		atomic.AddUint64(&h.payloadBytes, uint64(p.Length))
+		atomic.AddUint64(&h.wireBytes, uint64(p.WireLength))
+	case *stats.OutPayload:
+		atomic.AddUint64(&h.payloadBytes, uint64(p.Length))
+		atomic.AddUint64(&h.wireBytes, uint64(p.WireLength))
+	}
+}
+
+// TagConn implements stats.Handler.
+func (h *GRPCWireStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+// HandleConn implements stats.Handler.
+func (h *GRPCWireStats) HandleConn(context.Context, stats.ConnStats) {}
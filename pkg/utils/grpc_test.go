@@ -0,0 +1,80 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+
+	"github.com/pingcap/br/pkg/logutil"
+)
+
+type testGRPCSuite struct{}
+
+var _ = Suite(&testGRPCSuite{})
+
+func (s *testGRPCSuite) TestParseGRPCCompression(c *C) {
+	name, err := ParseGRPCCompression("")
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, GRPCCompressionNone)
+	c.Assert(GRPCCompressionDialOptions(name, &GRPCWireStats{}), HasLen, 0)
+
+	name, err = ParseGRPCCompression("GZIP")
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, GRPCCompressionGzip)
+	c.Assert(GRPCCompressionDialOptions(name, &GRPCWireStats{}), HasLen, 2)
+	c.Assert(GRPCCompressionDialOptions(name, nil), HasLen, 1)
+
+	_, err = ParseGRPCCompression("zstd")
+	c.Assert(err, ErrorMatches, ".*not supported by TiKV.*")
+	_, err = ParseGRPCCompression("lz4")
+	c.Assert(err, ErrorMatches, ".*unknown gRPC compression.*")
+}
+
+func (s *testGRPCSuite) TestGRPCWireStats(c *C) {
+	// The concurrent tasks count their own messages.
+	task1, task2 := &GRPCWireStats{}, &GRPCWireStats{}
+	task1.HandleRPC(context.Background(), &stats.OutPayload{Length: 100, WireLength: 40})
+	task1.HandleRPC(context.Background(), &stats.InPayload{Length: 50, WireLength: 20})
+	task2.HandleRPC(context.Background(), &stats.InPayload{Length: 10, WireLength: 10})
+	payloadBytes, wireBytes := task1.Bytes()
+	c.Assert(payloadBytes, Equals, uint64(150))
+	c.Assert(wireBytes, Equals, uint64(60))
+	payloadBytes, wireBytes = task2.Bytes()
+	c.Assert(payloadBytes, Equals, uint64(10))
+	c.Assert(wireBytes, Equals, uint64(10))
+}
+
+func (s *testGRPCSuite) TestGRPCMaxMsgSize(c *C) {
+	size := GRPCMaxMsgSize{}
+	c.Assert(size.RecvSize(), Equals, int(DefaultGRPCMaxMsgSize))
+	c.Assert(size.SendSize(), Equals, int(DefaultGRPCMaxMsgSize))
+
+	size = GRPCMaxMsgSize{Recv: GB, Send: 16 * MB}
+	c.Assert(size.RecvSize(), Equals, int(GB))
+	c.Assert(size.SendSize(), Equals, int(16*MB))
+	c.Assert(size.DialOptions(), HasLen, 1)
+
+	// gRPC doesn't support the messages larger than 2GiB.
+	size.Send = 4 * GB
+	c.Assert(size.SendSize(), Equals, 1<<31-1)
+}
+
+func (s *testGRPCSuite) TestTaskMetadata(c *C) {
+	defer SetGRPCCommand("")
+
+	SetGRPCCommand("br backup full\n")
+	md, ok := metadata.FromOutgoingContext(withTaskMetadata(context.Background()))
+	c.Assert(ok, IsTrue)
+	c.Assert(md.Get(GRPCMetadataTaskID), DeepEquals, []string{logutil.TaskID()})
+	c.Assert(md.Get(GRPCMetadataCommand), DeepEquals, []string{"br backup full?"})
+	c.Assert(GRPCTaskDialOptions(), HasLen, 2)
+
+	// The task of the context, e.g. a BRIE statement, has its own ID.
+	md, ok = metadata.FromOutgoingContext(withTaskMetadata(logutil.ContextWithTaskID(context.Background(), "task")))
+	c.Assert(ok, IsTrue)
+	c.Assert(md.Get(GRPCMetadataTaskID), DeepEquals, []string{"task"})
+}
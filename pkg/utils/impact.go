@@ -0,0 +1,352 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// ImpactReportFile is the report of the impact of the backup on the cluster.
+const ImpactReportFile = "impact.json"
+
+// The metrics sampled from the status ports of TiKV and TiDB.
+const (
+	tikvCPUMetric      = "process_cpu_seconds_total"
+	tikvFlowMetric     = "tikv_engine_flow_bytes"
+	tidbQueryHistogram = "tidb_server_handle_query_duration_seconds_bucket"
+
+	impactRequestTimeout = 10 * time.Second
+)
+
+// tikvFlowTypes are the types of the RocksDB flow counted as the IO of TiKV.
+var tikvFlowTypes = map[string]struct{}{"bytes_read": {}, "bytes_written": {}}
+
+// ImpactSample is the metrics of the cluster at a time, by the status
+// addresses of the TiKV and TiDB instances.
+type ImpactSample struct {
+	Time      time.Time
+	CPU       map[string]float64
+	Flow      map[string]float64
+	QueryHist map[string]map[float64]float64
+}
+
+// ImpactRates is the load of the cluster between two samples.
+type ImpactRates struct {
+	// TiKVCPUCores is the total CPU cores used by TiKV.
+	TiKVCPUCores float64 `json:"tikv-cpu-cores"`
+	// TiKVFlowBytesPerSecond is the total bytes read and written by the
+	// RocksDB of TiKV per second.
+	TiKVFlowBytesPerSecond float64 `json:"tikv-flow-bytes-per-second"`
+	// SQLP99Seconds is the P99 duration of the queries of TiDB, -1 means no
+	// queries or unknown.
+	SQLP99Seconds float64 `json:"sql-p99-seconds"`
+}
+
+// ImpactReport compares the load of the cluster before, during and after the
+// backup, for quantifying the impact of the backup in capacity planning.
+type ImpactReport struct {
+	Before ImpactRates `json:"before"`
+	// During is the average load during the backup, DuringPeak is the max of
+	// every rate over the sample intervals.
+	During     ImpactRates `json:"during"`
+	DuringPeak ImpactRates `json:"during-peak"`
+	After      ImpactRates `json:"after"`
+}
+
+// ImpactCollector samples the metrics of the cluster periodically during a
+// task. The failures of sampling an instance are ignored, and the rates are
+// calculated by the instances sampled both times.
+type ImpactCollector struct {
+	cli    *http.Client
+	scheme string
+	tikv   []string
+	tidb   []string
+
+	mu       sync.Mutex
+	baseline []ImpactSample
+	samples  []ImpactSample
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewImpactCollector returns an ImpactCollector of the status addresses of the
+// TiKV and TiDB instances.
+func NewImpactCollector(tlsConf *tls.Config, tikv, tidb []string) *ImpactCollector {
+	cli := &http.Client{Timeout: impactRequestTimeout}
+	scheme := "http"
+	if tlsConf != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConf
+		cli.Transport = transport
+		scheme = "https"
+	}
+	return &ImpactCollector{cli: cli, scheme: scheme, tikv: tikv, tidb: tidb}
+}
+
+// Start samples the load before the task for the window, and then samples
+// every interval until Finish.
+func (c *ImpactCollector) Start(ctx context.Context, window, interval time.Duration) error {
+	first := c.sample(ctx)
+	select {
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	case <-time.After(window):
+	}
+	start := c.sample(ctx)
+	c.baseline = []ImpactSample{first, start}
+	c.samples = []ImpactSample{start}
+
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s := c.sample(ctx)
+				c.mu.Lock()
+				c.samples = append(c.samples, s)
+				c.mu.Unlock()
+			}
+		}
+	}()
+	return nil
+}
+
+// Finish stops sampling during the task, samples the load after the task for
+// the window, and returns the report.
+func (c *ImpactCollector) Finish(ctx context.Context, window time.Duration) (*ImpactReport, error) {
+	c.cancel()
+	<-c.done
+	end := c.sample(ctx)
+	samples := append(c.samples, end)
+	select {
+	case <-ctx.Done():
+		return nil, errors.Trace(ctx.Err())
+	case <-time.After(window):
+	}
+	after := c.sample(ctx)
+
+	report := &ImpactReport{
+		Before: impactRates(c.baseline[0], c.baseline[1]),
+		During: impactRates(samples[0], end),
+		After:  impactRates(end, after),
+	}
+	report.DuringPeak.SQLP99Seconds = -1
+	for i := 1; i < len(samples); i++ {
+		r := impactRates(samples[i-1], samples[i])
+		report.DuringPeak.TiKVCPUCores = math.Max(report.DuringPeak.TiKVCPUCores, r.TiKVCPUCores)
+		report.DuringPeak.TiKVFlowBytesPerSecond = math.Max(
+			report.DuringPeak.TiKVFlowBytesPerSecond, r.TiKVFlowBytesPerSecond)
+		report.DuringPeak.SQLP99Seconds = math.Max(report.DuringPeak.SQLP99Seconds, r.SQLP99Seconds)
+	}
+	return report, nil
+}
+
+func (c *ImpactCollector) sample(ctx context.Context) ImpactSample {
+	s := ImpactSample{
+		Time:      time.Now(),
+		CPU:       make(map[string]float64, len(c.tikv)),
+		Flow:      make(map[string]float64, len(c.tikv)),
+		QueryHist: make(map[string]map[float64]float64, len(c.tidb)),
+	}
+	for _, addr := range c.tikv {
+		body, err := c.fetch(ctx, addr)
+		if err != nil {
+			log.Warn("failed to sample the metrics of TiKV", zap.String("addr", addr), zap.Error(err))
+			continue
+		}
+		s.CPU[addr] = sumMetric(body, tikvCPUMetric, nil)
+		s.Flow[addr] = sumMetric(body, tikvFlowMetric, func(labels map[string]string) bool {
+			_, ok := tikvFlowTypes[labels["type"]]
+			return ok
+		})
+	}
+	for _, addr := range c.tidb {
+		body, err := c.fetch(ctx, addr)
+		if err != nil {
+			log.Warn("failed to sample the metrics of TiDB", zap.String("addr", addr), zap.Error(err))
+			continue
+		}
+		s.QueryHist[addr] = histogramBuckets(body, tidbQueryHistogram)
+	}
+	return s
+}
+
+func (c *ImpactCollector) fetch(ctx context.Context, addr string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s/metrics", c.scheme, addr), nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	resp, err := c.cli.Do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	return body, errors.Trace(err)
+}
+
+// impactRates returns the load of the cluster between the samples.
+func impactRates(from, to ImpactSample) ImpactRates {
+	r := ImpactRates{SQLP99Seconds: -1}
+	seconds := to.Time.Sub(from.Time).Seconds()
+	if seconds <= 0 {
+		return r
+	}
+	delta := func(a, b map[string]float64) float64 {
+		total := 0.0
+		for addr, v := range b {
+			if prev, ok := a[addr]; ok && v >= prev {
+				total += v - prev
+			}
+		}
+		return total
+	}
+	r.TiKVCPUCores = delta(from.CPU, to.CPU) / seconds
+	r.TiKVFlowBytesPerSecond = delta(from.Flow, to.Flow) / seconds
+
+	buckets := make(map[float64]float64)
+	for addr, hist := range to.QueryHist {
+		prev, ok := from.QueryHist[addr]
+		if !ok {
+			continue
+		}
+		for le, count := range hist {
+			if count >= prev[le] {
+				buckets[le] += count - prev[le]
+			}
+		}
+	}
+	r.SQLP99Seconds = histogramQuantile(buckets, 0.99)
+	return r
+}
+
+// histogramQuantile returns the upper bound of the bucket of the quantile in
+// the cumulative buckets, -1 if the histogram is empty.
+func histogramQuantile(buckets map[float64]float64, q float64) float64 {
+	total := buckets[math.Inf(1)]
+	if total <= 0 {
+		return -1
+	}
+	bounds := make([]float64, 0, len(buckets))
+	for le := range buckets {
+		bounds = append(bounds, le)
+	}
+	sort.Float64s(bounds)
+	for _, le := range bounds {
+		if buckets[le] >= q*total {
+			if math.IsInf(le, 1) && len(bounds) > 1 {
+				// The quantile is beyond the largest finite bound.
+				return bounds[len(bounds)-2]
+			}
+			return le
+		}
+	}
+	return -1
+}
+
+// parseMetricLine parses a sample line of the Prometheus text format, e.g.
+// `name{a="b"} 1.5`.
+func parseMetricLine(line string) (name string, labels map[string]string, value float64, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", nil, 0, false
+	}
+	rest := line
+	if i := strings.IndexByte(line, '{'); i >= 0 {
+		j := strings.LastIndexByte(line, '}')
+		if j < i {
+			return "", nil, 0, false
+		}
+		name, rest = line[:i], line[j+1:]
+		labels = make(map[string]string)
+		for _, pair := range strings.Split(line[i+1:j], ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) == 2 {
+				labels[strings.TrimSpace(kv[0])] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+			}
+		}
+	} else {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return "", nil, 0, false
+		}
+		name, rest = fields[0], strings.Join(fields[1:], " ")
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return "", nil, 0, false
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", nil, 0, false
+	}
+	return name, labels, value, true
+}
+
+// sumMetric sums the samples of the metric whose labels match.
+func sumMetric(body []byte, metric string, match func(labels map[string]string) bool) float64 {
+	total := 0.0
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		name, labels, value, ok := parseMetricLine(scanner.Text())
+		if ok && name == metric && (match == nil || match(labels)) {
+			total += value
+		}
+	}
+	return total
+}
+
+// histogramBuckets sums the buckets of the histogram by the upper bounds.
+func histogramBuckets(body []byte, metric string) map[float64]float64 {
+	buckets := make(map[float64]float64)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		name, labels, value, ok := parseMetricLine(scanner.Text())
+		if !ok || name != metric {
+			continue
+		}
+		le, err := strconv.ParseFloat(labels["le"], 64)
+		if err != nil {
+			continue
+		}
+		buckets[le] += value
+	}
+	return buckets
+}
+
+// SaveImpactReport saves the impact report into the storage.
+func SaveImpactReport(ctx context.Context, s storage.ExternalStorage, report *ImpactReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.Write(ctx, ImpactReportFile, data))
+}
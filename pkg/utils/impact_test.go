@@ -0,0 +1,63 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"math"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+type testImpactSuite struct{}
+
+var _ = Suite(&testImpactSuite{})
+
+func (s *testImpactSuite) TestParseMetrics(c *C) {
+	body := []byte(`# HELP process_cpu_seconds_total Total user and system CPU time spent in seconds.
+# TYPE process_cpu_seconds_total counter
+process_cpu_seconds_total 12.5
+tikv_engine_flow_bytes{db="kv",type="bytes_read"} 100
+tikv_engine_flow_bytes{db="kv",type="bytes_written"} 50
+tikv_engine_flow_bytes{db="kv",type="keys_read"} 7
+tidb_server_handle_query_duration_seconds_bucket{sql_type="Select",le="0.001"} 2 1607000000000
+tidb_server_handle_query_duration_seconds_bucket{sql_type="Select",le="+Inf"} 4
+tidb_server_handle_query_duration_seconds_bucket{sql_type="Insert",le="+Inf"} 1
+`)
+	c.Assert(sumMetric(body, tikvCPUMetric, nil), Equals, 12.5)
+	c.Assert(sumMetric(body, tikvFlowMetric, func(labels map[string]string) bool {
+		_, ok := tikvFlowTypes[labels["type"]]
+		return ok
+	}), Equals, 150.0)
+	c.Assert(histogramBuckets(body, tidbQueryHistogram), DeepEquals,
+		map[float64]float64{0.001: 2, math.Inf(1): 5})
+}
+
+func (s *testImpactSuite) TestImpactRates(c *C) {
+	now := time.Now()
+	from := ImpactSample{
+		Time: now,
+		CPU:  map[string]float64{"tikv1": 10, "tikv2": 20},
+		Flow: map[string]float64{"tikv1": 1000},
+		QueryHist: map[string]map[float64]float64{
+			"tidb1": {0.01: 10, 0.1: 10, math.Inf(1): 10},
+		},
+	}
+	to := ImpactSample{
+		Time: now.Add(10 * time.Second),
+		// tikv2 failed to be sampled.
+		CPU:  map[string]float64{"tikv1": 30},
+		Flow: map[string]float64{"tikv1": 6000},
+		QueryHist: map[string]map[float64]float64{
+			"tidb1": {0.01: 108, 0.1: 110, math.Inf(1): 110},
+		},
+	}
+	r := impactRates(from, to)
+	c.Assert(r.TiKVCPUCores, Equals, 2.0)
+	c.Assert(r.TiKVFlowBytesPerSecond, Equals, 500.0)
+	c.Assert(r.SQLP99Seconds, Equals, 0.1)
+
+	// No queries between the samples.
+	c.Assert(impactRates(to, to).SQLP99Seconds, Equals, -1.0)
+	c.Assert(histogramQuantile(map[float64]float64{0.5: 1, math.Inf(1): 100}, 0.99), Equals, 0.5)
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// LiveTuningPath is the path of the live tuning endpoint of the status server.
+const LiveTuningPath = "/tuning"
+
+// LiveTuning is the rate limit and the concurrency of the running task, the
+// operators can change them through the status server without restarting
+// the task, e.g. to slow a restore down when the production load spikes.
+// Once tuned, the values override the ones of the task, including the rate
+// limits of --ratelimit-schedule.
+type LiveTuning struct {
+	// rateLimit is accessed atomically, keep it first for the 64-bit alignment.
+	rateLimit   uint64
+	concurrency uint32
+	// rateLimitTuned and concurrencyTuned are set to 1 once the values are
+	// tuned.
+	rateLimitTuned   uint32
+	concurrencyTuned uint32
+}
+
+// NewLiveTuning creates the live tuning with the rate limit (in bytes/s per
+// TiKV node) and the concurrency the task starts with.
+func NewLiveTuning(rateLimit uint64, concurrency uint32) *LiveTuning {
+	return &LiveTuning{rateLimit: rateLimit, concurrency: concurrency}
+}
+
+// RateLimit returns the tuned rate limit, zero means no limit. It returns the
+// rate limit of the task as is if the live tuning is nil or the rate limit
+// isn't tuned.
+func (t *LiveTuning) RateLimit(rateLimit uint64) uint64 {
+	if t == nil || atomic.LoadUint32(&t.rateLimitTuned) == 0 {
+		return rateLimit
+	}
+	return atomic.LoadUint64(&t.rateLimit)
+}
+
+// Concurrency returns the tuned concurrency, which may be higher or lower
+// than the one the task starts with. It returns the concurrency of the task
+// as is if the live tuning is nil or the concurrency isn't tuned.
+func (t *LiveTuning) Concurrency(concurrency uint32) uint32 {
+	if t == nil || atomic.LoadUint32(&t.concurrencyTuned) == 0 {
+		return concurrency
+	}
+	return atomic.LoadUint32(&t.concurrency)
+}
+
+// LiveTuningPatch is the body of the PATCH request of the live tuning
+// endpoint, the absent fields are unchanged.
+type LiveTuningPatch struct {
+	// RateLimit is in MB/s per TiKV node like `--ratelimit`, zero means no
+	// limit.
+	RateLimit   *uint64 `json:"ratelimit,omitempty"`
+	Concurrency *uint32 `json:"concurrency,omitempty"`
+}
+
+// Apply applies the patch.
+func (t *LiveTuning) Apply(patch LiveTuningPatch) error {
+	if patch.Concurrency != nil && *patch.Concurrency == 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "concurrency must be positive")
+	}
+	if patch.RateLimit != nil {
+		rateLimit := *patch.RateLimit * MB
+		if old := atomic.SwapUint64(&t.rateLimit, rateLimit); old != rateLimit {
+			log.Info("rate limit tuned", zap.Uint64("from", old), zap.Uint64("to", rateLimit))
+		}
+		atomic.StoreUint32(&t.rateLimitTuned, 1)
+	}
+	if patch.Concurrency != nil {
+		if old := atomic.SwapUint32(&t.concurrency, *patch.Concurrency); old != *patch.Concurrency {
+			log.Info("concurrency tuned", zap.Uint32("from", old), zap.Uint32("to", *patch.Concurrency))
+		}
+		atomic.StoreUint32(&t.concurrencyTuned, 1)
+	}
+	return nil
+}
+
+func (t *LiveTuning) current() LiveTuningPatch {
+	rateLimit := atomic.LoadUint64(&t.rateLimit) / MB
+	concurrency := atomic.LoadUint32(&t.concurrency)
+	return LiveTuningPatch{RateLimit: &rateLimit, Concurrency: &concurrency}
+}
+
+var liveTuning struct {
+	sync.Mutex
+	t *LiveTuning
+}
+
+// SetLiveTuning exposes the live tuning of the running task through the
+// status server, the returned function withdraws it.
+func SetLiveTuning(t *LiveTuning) (unset func()) {
+	liveTuning.Lock()
+	liveTuning.t = t
+	liveTuning.Unlock()
+	return func() {
+		liveTuning.Lock()
+		if liveTuning.t == t {
+			liveTuning.t = nil
+		}
+		liveTuning.Unlock()
+	}
+}
+
+// serveLiveTuning shows the live tuning on GET, and changes it on PATCH. The
+// changes require the status server to authenticate the clients.
+func serveLiveTuning(w http.ResponseWriter, r *http.Request) {
+	liveTuning.Lock()
+	t := liveTuning.t
+	liveTuning.Unlock()
+	if t == nil {
+		http.Error(w, "no running task to tune", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		mu.Lock()
+		authenticated := statusServerConfig.authenticated()
+		mu.Unlock()
+		if !authenticated {
+			http.Error(w, "tuning requires the token or the client CA of the status server", http.StatusForbidden)
+			return
+		}
+		var patch LiveTuningPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := t.Apply(patch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PATCH")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(t.current())
+}
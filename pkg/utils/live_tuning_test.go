@@ -0,0 +1,72 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/pingcap/check"
+)
+
+type testLiveTuningSuite struct{}
+
+var _ = Suite(&testLiveTuningSuite{})
+
+func (*testLiveTuningSuite) TestLiveTuning(c *C) {
+	var nilTuning *LiveTuning
+	c.Assert(nilTuning.RateLimit(10), Equals, uint64(10))
+	c.Assert(nilTuning.Concurrency(4), Equals, uint32(4))
+
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		serveLiveTuning(w, httptest.NewRequest(method, LiveTuningPath, strings.NewReader(body)))
+		return w
+	}
+	c.Assert(serve(http.MethodGet, "").Code, Equals, http.StatusNotFound)
+
+	tuning := NewLiveTuning(100*MB, 4)
+	unset := SetLiveTuning(tuning)
+	// The tuning isn't changed through the status server without the auth.
+	c.Assert(serve(http.MethodGet, "").Code, Equals, http.StatusOK)
+	c.Assert(serve(http.MethodPatch, `{"ratelimit": 20}`).Code, Equals, http.StatusForbidden)
+	c.Assert(tuning.RateLimit(50*MB), Equals, 50*MB)
+	c.Assert(tuning.Concurrency(8), Equals, uint32(8))
+	mu.Lock()
+	statusServerConfig.Token = "token"
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		statusServerConfig.Token = ""
+		mu.Unlock()
+	}()
+
+	w := serve(http.MethodPatch, `{"ratelimit": 20}`)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	var current LiveTuningPatch
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &current), IsNil)
+	c.Assert(*current.RateLimit, Equals, uint64(20))
+	c.Assert(*current.Concurrency, Equals, uint32(4))
+	// The tuned rate limit wins over the one of the task, e.g. the scheduled.
+	c.Assert(tuning.RateLimit(100*MB), Equals, 20*MB)
+	c.Assert(tuning.RateLimit(0), Equals, 20*MB)
+	c.Assert(tuning.Concurrency(4), Equals, uint32(4))
+
+	c.Assert(serve(http.MethodPatch, `{"concurrency": 2}`).Code, Equals, http.StatusOK)
+	c.Assert(tuning.Concurrency(4), Equals, uint32(2))
+	c.Assert(tuning.RateLimit(100*MB), Equals, 20*MB)
+	// The concurrency can be raised above the one the task starts with.
+	c.Assert(serve(http.MethodPatch, `{"concurrency": 16}`).Code, Equals, http.StatusOK)
+	c.Assert(tuning.Concurrency(4), Equals, uint32(16))
+	c.Assert(serve(http.MethodPatch, `{"concurrency": 2}`).Code, Equals, http.StatusOK)
+
+	c.Assert(serve(http.MethodPatch, `{"concurrency": 0}`).Code, Equals, http.StatusBadRequest)
+	c.Assert(serve(http.MethodPatch, `not json`).Code, Equals, http.StatusBadRequest)
+	c.Assert(serve(http.MethodPost, "").Code, Equals, http.StatusMethodNotAllowed)
+	c.Assert(tuning.Concurrency(4), Equals, uint32(2))
+
+	unset()
+	c.Assert(serve(http.MethodGet, "").Code, Equals, http.StatusNotFound)
+}
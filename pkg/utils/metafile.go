@@ -0,0 +1,170 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// metaManifestMagic is the header of a meta file holding a MetaManifest
+// instead of the meta itself.
+var metaManifestMagic = []byte("BR-META-MANIFEST\n")
+
+const (
+	// MetaCompressionGzip is the compression of the chunks of meta files.
+	//
+	// zstd is preferred but requires a new dependency, the compression is
+	// recorded in the manifest so it can be added in a compatible way.
+	MetaCompressionGzip = "gzip"
+
+	metaChunkConcurrency = 8
+)
+
+var (
+	// metaChunkThreshold is the size above which the meta file is chunked.
+	metaChunkThreshold = 64 * MB
+	// metaChunkSize is the uncompressed size of each chunk.
+	metaChunkSize = 32 * MB
+)
+
+// MetaChunk is a chunk of a chunked meta file.
+type MetaChunk struct {
+	Name string `json:"name"`
+	// Size is the size before compression.
+	Size   uint64 `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// MetaManifest describes a meta file which is compressed and uploaded in chunks.
+type MetaManifest struct {
+	Compression string      `json:"compression"`
+	Size        uint64      `json:"size"`
+	Chunks      []MetaChunk `json:"chunks"`
+}
+
+// WriteMetaFile writes the meta file to the storage. Large meta files are
+// compressed and uploaded in chunks concurrently, followed by a manifest
+// written at the name of the meta file.
+func WriteMetaFile(ctx context.Context, s storage.ExternalStorage, name string, data []byte) error {
+	if uint64(len(data)) <= metaChunkThreshold {
+		return errors.Trace(s.Write(ctx, name, data))
+	}
+	chunkCount := (uint64(len(data)) + metaChunkSize - 1) / metaChunkSize
+	manifest := MetaManifest{
+		Compression: MetaCompressionGzip,
+		Size:        uint64(len(data)),
+		Chunks:      make([]MetaChunk, chunkCount),
+	}
+	pool := NewWorkerPool(metaChunkConcurrency, "meta chunks")
+	eg, ectx := errgroup.WithContext(ctx)
+	for i := uint64(0); i < chunkCount; i++ {
+		idx := i
+		end := (idx + 1) * metaChunkSize
+		if end > uint64(len(data)) {
+			end = uint64(len(data))
+		}
+		chunk := data[idx*metaChunkSize : end]
+		pool.ApplyOnErrorGroup(eg, func() error {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			if _, err := w.Write(chunk); err != nil {
+				return errors.Trace(err)
+			}
+			if err := w.Close(); err != nil {
+				return errors.Trace(err)
+			}
+			checksum := sha256.Sum256(chunk)
+			chunkName := fmt.Sprintf("%s.part.%05d", name, idx)
+			manifest.Chunks[idx] = MetaChunk{
+				Name:   chunkName,
+				Size:   uint64(len(chunk)),
+				Sha256: hex.EncodeToString(checksum[:]),
+			}
+			return errors.Trace(s.Write(ectx, chunkName, buf.Bytes()))
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+	manifestData, err := json.Marshal(&manifest)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("meta file uploaded in chunks",
+		zap.String("name", name),
+		zap.Uint64("size", manifest.Size),
+		zap.Int("chunks", len(manifest.Chunks)))
+	return errors.Trace(s.Write(ctx, name, append(append([]byte{}, metaManifestMagic...), manifestData...)))
+}
+
+// ReadMetaFile reads the meta file written by WriteMetaFile, both the plain
+// meta files and the chunked ones are supported.
+func ReadMetaFile(ctx context.Context, s storage.ExternalStorage, name string) ([]byte, error) {
+	data, err := s.Read(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !bytes.HasPrefix(data, metaManifestMagic) {
+		return data, nil
+	}
+	manifest := MetaManifest{}
+	if err = json.Unmarshal(data[len(metaManifestMagic):], &manifest); err != nil {
+		return nil, errors.Annotate(berrors.ErrRestoreInvalidBackup, err.Error())
+	}
+	if manifest.Compression != MetaCompressionGzip {
+		return nil, errors.Annotatef(berrors.ErrRestoreInvalidBackup,
+			"unsupported compression %s of meta file %s", manifest.Compression, name)
+	}
+
+	chunks := make([][]byte, len(manifest.Chunks))
+	pool := NewWorkerPool(metaChunkConcurrency, "meta chunks")
+	eg, ectx := errgroup.WithContext(ctx)
+	for i := range manifest.Chunks {
+		idx := i
+		chunk := manifest.Chunks[idx]
+		pool.ApplyOnErrorGroup(eg, func() error {
+			compressed, err := s.Read(ectx, chunk.Name)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			r, err := gzip.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				return errors.Annotatef(berrors.ErrRestoreInvalidBackup, "chunk %s: %v", chunk.Name, err)
+			}
+			content, err := ioutil.ReadAll(r)
+			if err != nil {
+				return errors.Annotatef(berrors.ErrRestoreInvalidBackup, "chunk %s: %v", chunk.Name, err)
+			}
+			checksum := sha256.Sum256(content)
+			if uint64(len(content)) != chunk.Size || hex.EncodeToString(checksum[:]) != chunk.Sha256 {
+				return errors.Annotatef(berrors.ErrRestoreInvalidBackup, "chunk %s is corrupted", chunk.Name)
+			}
+			chunks[idx] = content
+			return nil
+		})
+	}
+	if err = eg.Wait(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	meta := bytes.Join(chunks, nil)
+	if uint64(len(meta)) != manifest.Size {
+		return nil, errors.Annotatef(berrors.ErrRestoreInvalidBackup,
+			"size of meta file %s mismatch, expect %d, got %d", name, manifest.Size, len(meta))
+	}
+	return meta, nil
+}
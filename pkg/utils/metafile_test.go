@@ -0,0 +1,51 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"bytes"
+	"context"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+type testMetaFileSuite struct{}
+
+var _ = Suite(&testMetaFileSuite{})
+
+func (s *testMetaFileSuite) TestChunkedMetaFile(c *C) {
+	ctx := context.Background()
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	oldThreshold, oldSize := metaChunkThreshold, metaChunkSize
+	metaChunkThreshold, metaChunkSize = 100, 30
+	defer func() {
+		metaChunkThreshold, metaChunkSize = oldThreshold, oldSize
+	}()
+
+	small := []byte("small meta")
+	c.Assert(WriteMetaFile(ctx, store, "small", small), IsNil)
+	raw, err := store.Read(ctx, "small")
+	c.Assert(err, IsNil)
+	c.Assert(raw, DeepEquals, small)
+	data, err := ReadMetaFile(ctx, store, "small")
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, small)
+
+	large := bytes.Repeat([]byte("0123456789"), 20)
+	c.Assert(WriteMetaFile(ctx, store, MetaFile, large), IsNil)
+	exists, err := store.FileExists(ctx, MetaFile+".part.00006")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsTrue)
+	data, err = ReadMetaFile(ctx, store, MetaFile)
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, large)
+
+	// corrupted chunk is detected.
+	c.Assert(store.Write(ctx, MetaFile+".part.00003", []byte("corrupted")), IsNil)
+	_, err = ReadMetaFile(ctx, store, MetaFile)
+	c.Assert(err, NotNil)
+}
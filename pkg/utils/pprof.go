@@ -36,17 +36,24 @@ func StartPProfListener(statusAddr string) {
 		statusAddr = fmt.Sprintf(":%d", port)
 		log.Info("injecting failpoint, pprof will start at determined port", zap.Int("port", port))
 	})
-	listener, err := net.Listen("tcp", statusAddr)
+	rawListener, err := net.Listen("tcp", statusAddr)
 	if err != nil {
 		log.Warn("failed to start pprof", zap.String("addr", statusAddr), zap.Error(err))
 		return
 	}
+	var listener net.Listener
+	if listener, err = statusServerConfig.wrapListener(rawListener); err != nil {
+		_ = rawListener.Close()
+		log.Warn("failed to start pprof", zap.String("addr", statusAddr), zap.Error(err))
+		return
+	}
+	handler := statusServerConfig.wrapHandler(statusHandler())
 	startedPProf = listener.Addr().String()
 	log.Info("bound pprof to addr", zap.String("addr", startedPProf))
 	_, _ = fmt.Fprintf(os.Stderr, "bound pprof to addr %s\n", startedPProf)
 
 	go func() {
-		if e := http.Serve(listener, nil); e != nil {
+		if e := http.Serve(listener, handler); e != nil {
 			log.Warn("failed to serve pprof", zap.String("addr", startedPProf), zap.Error(e))
 			mu.Lock()
 			startedPProf = ""
@@ -55,3 +62,13 @@ func StartPProfListener(statusAddr string) {
 		}
 	}()
 }
+
+// statusHandler is the handler of the status server of BR, it serves pprof and
+// the live tuning only, rather than every handler registered on
+// http.DefaultServeMux.
+func statusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/pprof/", http.DefaultServeMux)
+	mux.HandleFunc(LiveTuningPath, serveLiveTuning)
+	return mux
+}
@@ -5,7 +5,11 @@ package utils
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
+	"os"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -13,10 +17,95 @@ import (
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/logutil"
 )
 
 type logFunc func(msg string, fields ...zap.Field)
 
+const (
+	// progressLogInterval is the interval of logging the progress.
+	progressLogInterval = 2 * time.Minute
+	// progressLineInterval is the interval of printing the progress lines
+	// when the stdout isn't a terminal.
+	progressLineInterval = 30 * time.Second
+)
+
+// progressLineOutput is where the progress lines are printed, it's the stderr
+// by default so that the stdout is kept for the output of the commands.
+var progressLineOutput io.Writer = os.Stderr
+
+// SetProgressLineOutput sets where the progress lines are printed when the
+// stdout isn't a terminal.
+func SetProgressLineOutput(w io.Writer) {
+	progressLineOutput = w
+}
+
+// ProgressStage is the progress of one stage of a task, e.g. split or ingest.
+type ProgressStage struct {
+	name     string
+	total    int64
+	progress int64
+	// bytes is the bytes processed in the stage, it's shown only if non-zero.
+	bytes uint64
+}
+
+// Inc increases the progress of the stage.
+func (s *ProgressStage) Inc() {
+	atomic.AddInt64(&s.progress, 1)
+}
+
+// Close marks the stage as 100% complete.
+func (s *ProgressStage) Close() {
+	atomic.StoreInt64(&s.progress, s.total)
+}
+
+// AddBytes increases the bytes processed in the stage.
+func (s *ProgressStage) AddBytes(n uint64) {
+	atomic.AddUint64(&s.bytes, n)
+}
+
+func (s *ProgressStage) String() string {
+	progress := atomic.LoadInt64(&s.progress)
+	if progress > s.total {
+		progress = s.total
+	}
+	percent := 100.0
+	if s.total > 0 {
+		percent = float64(progress) * 100 / float64(s.total)
+	}
+	str := fmt.Sprintf("%s %d/%d(%.0f%%)", s.name, progress, s.total, percent)
+	if b := atomic.LoadUint64(&s.bytes); b > 0 {
+		str += " " + formatBytes(b)
+	}
+	return str
+}
+
+// formatBytes formats the bytes in the largest binary unit, e.g. 1.50GiB.
+func formatBytes(b uint64) string {
+	switch {
+	case b >= TB:
+		return fmt.Sprintf("%.2fTiB", float64(b)/float64(TB))
+	case b >= GB:
+		return fmt.Sprintf("%.2fGiB", float64(b)/float64(GB))
+	case b >= MB:
+		return fmt.Sprintf("%.2fMiB", float64(b)/float64(MB))
+	case b >= KB:
+		return fmt.Sprintf("%.2fKiB", float64(b)/float64(KB))
+	default:
+		return fmt.Sprintf("%dB", b)
+	}
+}
+
+// isTerminal checks whether the file is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
 // ProgressPrinter prints a progress bar.
 type ProgressPrinter struct {
 	name        string
@@ -24,6 +113,9 @@ type ProgressPrinter struct {
 	redirectLog bool
 	progress    int64
 
+	stagesMu sync.Mutex
+	stages   []*ProgressStage
+
 	cancel context.CancelFunc
 }
 
@@ -53,6 +145,25 @@ func (pp *ProgressPrinter) Close() {
 	pp.cancel()
 }
 
+// AddStage adds a stage of the task, which is shown along with the progress bar.
+func (pp *ProgressPrinter) AddStage(name string, total int64) *ProgressStage {
+	stage := &ProgressStage{name: name, total: total}
+	pp.stagesMu.Lock()
+	pp.stages = append(pp.stages, stage)
+	pp.stagesMu.Unlock()
+	return stage
+}
+
+func (pp *ProgressPrinter) stagesString() string {
+	pp.stagesMu.Lock()
+	defer pp.stagesMu.Unlock()
+	stages := make([]string, 0, len(pp.stages))
+	for _, stage := range pp.stages {
+		stages = append(stages, stage.String())
+	}
+	return strings.Join(stages, ", ")
+}
+
 // goPrintProgress starts a gorouinte and prints progress.
 func (pp *ProgressPrinter) goPrintProgress(
 	ctx context.Context,
@@ -62,21 +173,30 @@ func (pp *ProgressPrinter) goPrintProgress(
 	cctx, cancel := context.WithCancel(ctx)
 	pp.cancel = cancel
 	bar := pb.New64(pp.total)
-	if pp.redirectLog || testWriter != nil {
-		tmpl := `{"P":"{{percent .}}","C":"{{counters . }}","E":"{{etime .}}","R":"{{rtime .}}","S":"{{speed .}}"}`
+	// Print machine-parsable progress lines instead of the bar when the
+	// stdout isn't a terminal, e.g. redirected to a file or a pipe.
+	printLines := !pp.redirectLog && testWriter == nil && !isTerminal(os.Stdout)
+	if pp.redirectLog || printLines || testWriter != nil {
+		tmpl := `{"P":"{{percent .}}","C":"{{counters . }}","E":"{{etime .}}","R":"{{rtime .}}","S":"{{speed .}}","T":"{{string . "stages"}}"}`
 		bar.SetTemplateString(tmpl)
-		bar.SetRefreshRate(2 * time.Minute)
+		bar.SetRefreshRate(progressLogInterval)
 		bar.Set(pb.Static, false)       // Do not update automatically
 		bar.Set(pb.ReturnSymbol, false) // Do not append '\r'
 		bar.Set(pb.Terminal, false)     // Do not use terminal width
 		// Hack! set Color to avoid separate progress string
 		bar.Set(pb.Color, true)
-		if logFuncImpl == nil {
-			logFuncImpl = log.Info
+		if printLines {
+			bar.SetRefreshRate(progressLineInterval)
+			bar.SetWriter(&wrappedWriter{name: pp.name, out: progressLineOutput})
+		} else {
+			if logFuncImpl == nil {
+				logFuncImpl = log.Info
+			}
+			bar.SetWriter(&wrappedWriter{name: pp.name, log: logFuncImpl})
 		}
-		bar.SetWriter(&wrappedWriter{name: pp.name, log: logFuncImpl})
 	} else {
-		tmpl := `{{string . "barName" | green}} {{ bar . "<" "-" (cycle . "-" "\\" "|" "/" ) "." ">"}} {{percent .}}`
+		tmpl := `{{string . "barName" | green}} {{ bar . "<" "-" (cycle . "-" "\\" "|" "/" ) "." ">"}} {{percent .}} ` +
+			`{{speed . "%s/s" ""}} {{rtime . "ETA %s" "" ""}} {{string . "stages"}}`
 		bar.SetTemplateString(tmpl)
 		bar.Set("barName", pp.name)
 	}
@@ -105,6 +225,7 @@ func (pp *ProgressPrinter) goPrintProgress(
 			case <-t.C:
 			}
 
+			bar.Set("stages", pp.stagesString())
 			currentProgress := atomic.LoadInt64(&pp.progress)
 			if currentProgress <= pp.total {
 				bar.SetCurrent(currentProgress)
@@ -115,9 +236,23 @@ func (pp *ProgressPrinter) goPrintProgress(
 	}()
 }
 
+// wrappedWriter writes the progress to the log, or to out as JSON lines.
 type wrappedWriter struct {
 	name string
 	log  logFunc
+	out  io.Writer
+}
+
+// progressLine is the machine-parsable progress line.
+type progressLine struct {
+	TaskID    string `json:"task-id"`
+	Step      string `json:"step"`
+	Progress  string `json:"progress"`
+	Count     string `json:"count"`
+	Speed     string `json:"speed"`
+	Elapsed   string `json:"elapsed"`
+	Remaining string `json:"remaining"`
+	Stages    string `json:"stages,omitempty"`
 }
 
 func (ww *wrappedWriter) Write(p []byte) (int, error) {
@@ -127,17 +262,42 @@ func (ww *wrappedWriter) Write(p []byte) (int, error) {
 		E string
 		R string
 		S string
+		T string
 	}
 	if err := json.Unmarshal(p, &info); err != nil {
 		return 0, errors.Trace(err)
 	}
-	ww.log("progress",
+	if ww.out != nil {
+		line, err := json.Marshal(progressLine{
+			TaskID:    logutil.TaskID(),
+			Step:      ww.name,
+			Progress:  info.P,
+			Count:     info.C,
+			Speed:     info.S,
+			Elapsed:   info.E,
+			Remaining: info.R,
+			Stages:    info.T,
+		})
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		if _, err := fmt.Fprintf(ww.out, "[progress] %s\n", line); err != nil {
+			return 0, errors.Trace(err)
+		}
+		return len(p), nil
+	}
+	fields := []zap.Field{
 		zap.String("step", ww.name),
 		zap.String("progress", info.P),
 		zap.String("count", info.C),
 		zap.String("speed", info.S),
 		zap.String("elapsed", info.E),
-		zap.String("remaining", info.R))
+		zap.String("remaining", info.R),
+	}
+	if info.T != "" {
+		fields = append(fields, zap.String("stages", info.T))
+	}
+	ww.log("progress", fields...)
 	return len(p), nil
 }
 
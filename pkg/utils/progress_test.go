@@ -3,6 +3,7 @@
 package utils
 
 import (
+	"bytes"
 	"context"
 	"time"
 
@@ -75,3 +76,36 @@ func (r *testProgressSuite) TestProgress(c *C) {
 	p = <-pCh8
 	c.Assert(p, Matches, `.*"P":"25\.00%".*`)
 }
+
+func (r *testProgressSuite) TestProgressLine(c *C) {
+	var buf bytes.Buffer
+	ww := &wrappedWriter{name: "test", out: &buf}
+	_, err := ww.Write([]byte(`{"P":"50.00%","C":"1/2","E":"1s","R":"1s","S":"1 p/s","T":"split 1/1(100%)"}`))
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Matches, `\[progress\] \{"task-id":".*","step":"test","progress":"50\.00%",.*"stages":"split 1/1\(100%\)"\}\n`)
+}
+
+func (r *testProgressSuite) TestProgressStages(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pCh := make(chan string, 4)
+	progress := NewProgressPrinter("test", 4, false)
+	split := progress.AddStage("split", 1)
+	ingest := progress.AddStage("ingest", 3)
+	progress.goPrintProgress(ctx, nil, &testWriter{
+		fn: func(p string) { pCh <- p },
+	})
+	split.Inc()
+	progress.Inc()
+	ingest.Inc()
+	progress.Inc()
+	time.Sleep(2 * time.Second)
+	p := <-pCh
+	c.Assert(p, Matches, `.*"P":"50\.00%".*"T":"split 1/1\(100%\), ingest 1/3\(33%\)".*`)
+
+	ingest.Close()
+	c.Assert(ingest.String(), Equals, "ingest 3/3(100%)")
+	ingest.AddBytes(3 * MB / 2)
+	c.Assert(ingest.String(), Equals, "ingest 3/3(100%) 1.50MiB")
+}
@@ -0,0 +1,293 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/summary"
+)
+
+const (
+	resourceSampleInterval = time.Second
+	// resourceThrottleInterval is the interval to check the memory while the
+	// work is throttled.
+	resourceThrottleInterval = 100 * time.Millisecond
+	// limitedGCPercent makes GC more frequent under a memory limit, so the
+	// heap grows less between two GCs.
+	limitedGCPercent = 50
+)
+
+var byteSizeUnits = []struct {
+	suffix string
+	unit   uint64
+}{
+	{"KiB", KB},
+	{"MiB", MB},
+	{"GiB", GB},
+	{"TiB", TB},
+	{"KB", KB},
+	{"MB", MB},
+	{"GB", GB},
+	{"TB", TB},
+	{"B", B},
+}
+
+// ParseByteSize parses the size like `512MiB` or `4GB` into bytes, like the
+// other sizes of BR, 1KB is 1024 bytes. The size without unit is in bytes.
+func ParseByteSize(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	value, unit := s, B
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			value, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.unit
+			break
+		}
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid size %q", s)
+	}
+	if n > math.MaxUint64/unit {
+		return 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid size %q, it overflows", s)
+	}
+	return n * unit, nil
+}
+
+// processLimits is the settings of the process shared by the limiters of the
+// concurrent tasks, e.g. the BRIE statements of TiDB. The strictest limit
+// applies, and the origin settings are restored after the last limiter stops,
+// unless they are changed by others since.
+var processLimits struct {
+	sync.Mutex
+	procsRefs   int
+	originProcs int
+	procs       int
+
+	gcRefs          int
+	originGCPercent int
+}
+
+func limitProcs(procs int) int {
+	processLimits.Lock()
+	defer processLimits.Unlock()
+	current := runtime.GOMAXPROCS(0)
+	if processLimits.procsRefs == 0 {
+		processLimits.originProcs = current
+	}
+	processLimits.procsRefs++
+	if procs < current {
+		runtime.GOMAXPROCS(procs)
+		current = procs
+	}
+	processLimits.procs = current
+	return current
+}
+
+func restoreProcs() {
+	processLimits.Lock()
+	defer processLimits.Unlock()
+	processLimits.procsRefs--
+	if processLimits.procsRefs > 0 {
+		return
+	}
+	if runtime.GOMAXPROCS(0) == processLimits.procs {
+		runtime.GOMAXPROCS(processLimits.originProcs)
+	}
+}
+
+func limitGCPercent() {
+	processLimits.Lock()
+	defer processLimits.Unlock()
+	if processLimits.gcRefs == 0 {
+		processLimits.originGCPercent = debug.SetGCPercent(limitedGCPercent)
+		if origin := processLimits.originGCPercent; origin >= 0 && origin < limitedGCPercent {
+			// GC is already more frequent.
+			debug.SetGCPercent(origin)
+		}
+	}
+	processLimits.gcRefs++
+}
+
+func restoreGCPercent() {
+	processLimits.Lock()
+	defer processLimits.Unlock()
+	processLimits.gcRefs--
+	if processLimits.gcRefs > 0 {
+		return
+	}
+	// The GC percent can only be read by setting it.
+	if current := debug.SetGCPercent(processLimits.originGCPercent); current != limitedGCPercent {
+		debug.SetGCPercent(current)
+	}
+}
+
+// ResourceLimiter throttles BR itself, so it doesn't starve the other services
+// on a shared host. The CPU is limited by GOMAXPROCS. The memory is limited by
+// the heap in use, once it exceeds the limit, no more work starts until the
+// heap shrinks below the limit or all the work in flight finishes.
+type ResourceLimiter struct {
+	maxCPU      int
+	memoryLimit uint64
+	// readMemory returns the memory in use, it's replaced in tests.
+	readMemory func() uint64
+
+	mu        sync.Mutex
+	inFlight  int
+	inUse     uint64
+	peak      uint64
+	throttled time.Duration
+
+	started bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewResourceLimiter returns a resource limiter, zero means no limit.
+func NewResourceLimiter(maxCPU int, memoryLimit uint64) *ResourceLimiter {
+	return &ResourceLimiter{
+		maxCPU:      maxCPU,
+		memoryLimit: memoryLimit,
+		readMemory:  heapInUse,
+	}
+}
+
+func heapInUse() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapInuse
+}
+
+// Start applies the limits and starts sampling the memory. GOMAXPROCS and the
+// GC percent are left alone unless their limits are set.
+func (l *ResourceLimiter) Start(ctx context.Context) {
+	if l.maxCPU > 0 {
+		procs := limitProcs(MinInt(l.maxCPU, runtime.NumCPU()))
+		log.Info("limit the CPU of BR", zap.Int("max-procs", procs))
+	}
+	if l.memoryLimit > 0 {
+		limitGCPercent()
+		log.Info("limit the memory of BR", zap.Uint64("memory-limit", l.memoryLimit))
+	}
+	l.started = true
+
+	ctx, l.cancel = context.WithCancel(ctx)
+	l.done = make(chan struct{})
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(resourceSampleInterval)
+		defer ticker.Stop()
+		for {
+			l.sample()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// sample reads the memory in use.
+func (l *ResourceLimiter) sample() {
+	inUse := l.readMemory()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inUse = inUse
+	if inUse > l.peak {
+		l.peak = inUse
+	}
+}
+
+// tryAcquire starts a piece of work unless the memory exceeds the limit. At
+// least one piece of work is always allowed, otherwise nothing would free the
+// memory.
+func (l *ResourceLimiter) tryAcquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight > 0 && l.inUse >= l.memoryLimit {
+		return false
+	}
+	l.inFlight++
+	return true
+}
+
+// Acquire blocks while the memory exceeds the limit, the work must call
+// Release after it finishes. A nil limiter never blocks.
+func (l *ResourceLimiter) Acquire(ctx context.Context) error {
+	if l == nil || l.memoryLimit == 0 {
+		return nil
+	}
+	if l.tryAcquire() {
+		return nil
+	}
+	start := time.Now()
+	defer func() {
+		l.mu.Lock()
+		l.throttled += time.Since(start)
+		l.mu.Unlock()
+	}()
+	ticker := time.NewTicker(resourceThrottleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-ticker.C:
+		}
+		// Read the memory again, the sampling is too slow to unblock the work
+		// once the garbage is collected.
+		l.sample()
+		if l.tryAcquire() {
+			return nil
+		}
+	}
+}
+
+// Release finishes the work started by Acquire.
+func (l *ResourceLimiter) Release() {
+	if l == nil || l.memoryLimit == 0 {
+		return
+	}
+	l.mu.Lock()
+	l.inFlight--
+	l.mu.Unlock()
+}
+
+// Stop stops sampling, restores the settings changed by Start, and collects
+// the resource usage into the summary.
+func (l *ResourceLimiter) Stop() {
+	if !l.started {
+		return
+	}
+	l.started = false
+	l.cancel()
+	<-l.done
+	procs := runtime.GOMAXPROCS(0)
+	if l.maxCPU > 0 {
+		restoreProcs()
+	}
+	if l.memoryLimit > 0 {
+		restoreGCPercent()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	summary.CollectInt("max procs", procs)
+	summary.CollectUint("peak heap in use", l.peak)
+	if l.throttled > 0 {
+		summary.CollectDuration("memory throttled", l.throttled)
+	}
+}
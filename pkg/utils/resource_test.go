@@ -0,0 +1,116 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+type testResourceSuite struct{}
+
+var _ = Suite(&testResourceSuite{})
+
+func (*testResourceSuite) TestParseByteSize(c *C) {
+	for _, t := range []struct {
+		s    string
+		size uint64
+	}{
+		{"0", 0},
+		{"512", 512},
+		{"512B", 512},
+		{"4KB", 4 * KB},
+		{"64MiB", 64 * MB},
+		{" 2 GiB ", 2 * GB},
+		{"1TB", TB},
+	} {
+		size, err := ParseByteSize(t.s)
+		c.Assert(err, IsNil, Commentf("%q", t.s))
+		c.Assert(size, Equals, t.size, Commentf("%q", t.s))
+	}
+	for _, s := range []string{"", "GiB", "-1MiB", "1.5GiB", "1PB", "17179869184TiB"} {
+		_, err := ParseByteSize(s)
+		c.Assert(err, ErrorMatches, ".*invalid size.*", Commentf("%q", s))
+	}
+}
+
+func (*testResourceSuite) TestResourceLimiterAcquire(c *C) {
+	ctx := context.Background()
+	var nilLimiter *ResourceLimiter
+	c.Assert(nilLimiter.Acquire(ctx), IsNil)
+	nilLimiter.Release()
+
+	inUse := uint64(0)
+	limiter := NewResourceLimiter(0, 2*MB)
+	limiter.readMemory = func() uint64 { return atomic.LoadUint64(&inUse) }
+	c.Assert(limiter.Acquire(ctx), IsNil)
+	c.Assert(limiter.Acquire(ctx), IsNil)
+
+	// The heap exceeds the limit.
+	atomic.StoreUint64(&inUse, 3*MB)
+	limiter.sample()
+	waitCtx, cancel := context.WithTimeout(ctx, 3*resourceThrottleInterval)
+	defer cancel()
+	c.Assert(limiter.Acquire(waitCtx), ErrorMatches, ".*deadline exceeded.*")
+
+	done := make(chan error, 1)
+	go func() { done <- limiter.Acquire(ctx) }()
+	atomic.StoreUint64(&inUse, MB)
+	select {
+	case err := <-done:
+		c.Assert(err, IsNil)
+	case <-time.After(time.Second):
+		c.Fatal("acquire isn't unblocked after the heap shrinks")
+	}
+	c.Assert(limiter.throttled, Greater, time.Duration(0))
+
+	// The only work is always allowed.
+	atomic.StoreUint64(&inUse, 3*MB)
+	limiter.sample()
+	for i := 0; i < 3; i++ {
+		limiter.Release()
+	}
+	c.Assert(limiter.Acquire(ctx), IsNil)
+}
+
+func (*testResourceSuite) TestResourceLimiterRestore(c *C) {
+	originProcs := runtime.GOMAXPROCS(0)
+	originGCPercent := debug.SetGCPercent(80)
+	defer debug.SetGCPercent(originGCPercent)
+
+	inUse := uint64(2 * MB)
+	limiter := NewResourceLimiter(1, MB)
+	limiter.readMemory = func() uint64 { return atomic.LoadUint64(&inUse) }
+	limiter.Start(context.Background())
+	c.Assert(runtime.GOMAXPROCS(0), Equals, 1)
+	// The limiter of a concurrent task keeps the stricter limits.
+	another := NewResourceLimiter(4, MB)
+	another.Start(context.Background())
+	c.Assert(runtime.GOMAXPROCS(0), Equals, 1)
+	limiter.Stop()
+	c.Assert(runtime.GOMAXPROCS(0), Equals, 1)
+	c.Assert(debug.SetGCPercent(limitedGCPercent), Equals, limitedGCPercent)
+	another.Stop()
+	c.Assert(runtime.GOMAXPROCS(0), Equals, originProcs)
+	c.Assert(debug.SetGCPercent(80), Equals, 80)
+	c.Assert(limiter.peak, Equals, 2*MB)
+
+	// Nothing is changed without the limits, and the settings changed by
+	// others aren't restored.
+	limiter = NewResourceLimiter(0, 0)
+	limiter.Start(context.Background())
+	c.Assert(debug.SetGCPercent(90), Equals, 80)
+	limiter.Stop()
+	c.Assert(debug.SetGCPercent(80), Equals, 90)
+
+	limiter = NewResourceLimiter(0, MB)
+	limiter.Start(context.Background())
+	debug.SetGCPercent(70)
+	limiter.Stop()
+	c.Assert(debug.SetGCPercent(80), Equals, 70)
+}
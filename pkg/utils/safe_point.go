@@ -10,6 +10,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/store/tikv/oracle"
 	pd "github.com/tikv/pd/client"
 	"github.com/tikv/pd/pkg/tsoutil"
 	"go.uber.org/zap"
@@ -74,6 +75,59 @@ func CheckGCSafePoint(ctx context.Context, pdClient pd.Client, ts uint64) error
 	return nil
 }
 
+// GCWindow is the range of the TS readable by the snapshots, i.e.
+// (SafePoint, CurrentTS].
+type GCWindow struct {
+	SafePoint uint64
+	CurrentTS uint64
+}
+
+// GetGCWindow returns the current GC window of the cluster.
+func GetGCWindow(ctx context.Context, pdClient pd.Client) (GCWindow, error) {
+	safePoint, err := getGCSafePoint(ctx, pdClient)
+	if err != nil {
+		return GCWindow{}, errors.Trace(err)
+	}
+	physical, logical, err := pdClient.GetTS(ctx)
+	if err != nil {
+		return GCWindow{}, errors.Trace(err)
+	}
+	return GCWindow{SafePoint: safePoint, CurrentTS: oracle.ComposeTS(physical, logical)}, nil
+}
+
+func formatTS(ts uint64) string {
+	t, _ := tsoutil.ParseTS(ts)
+	return fmt.Sprintf("%d (%s)", ts, t.Format(time.RFC3339))
+}
+
+// Check checks whether the ts of the name is newer than the GC safe point,
+// the error names the safe TS range otherwise.
+func (w GCWindow) Check(name string, ts uint64) error {
+	if ts > w.SafePoint {
+		return nil
+	}
+	return errors.Annotatef(berrors.ErrBackupGCSafepointExceeded,
+		"%s %s is out of the GC window, the safe TS range is (%s, %s]",
+		name, formatTS(ts), formatTS(w.SafePoint), formatTS(w.CurrentTS))
+}
+
+// PushServiceSafePoint registers the service safe point like
+// UpdateServiceSafePoint, and verifies that PD holds it, i.e. the GC safe
+// point hasn't passed the BackupTS.
+func PushServiceSafePoint(ctx context.Context, pdClient pd.Client, sp BRServiceSafePoint) error {
+	minSafePoint, err := pdClient.UpdateServiceGCSafePoint(ctx, sp.ID, sp.TTL, sp.BackupTS-1)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if minSafePoint > sp.BackupTS-1 {
+		return errors.Annotatef(berrors.ErrBackupGCSafepointExceeded,
+			"PD can't hold the service safe point at TS %s, the min service safe point is %s",
+			formatTS(sp.BackupTS), formatTS(minSafePoint))
+	}
+	log.Info("service safe point pushed", zap.Object("safePoint", sp))
+	return nil
+}
+
 // UpdateServiceSafePoint register BackupTS to PD, to lock down BackupTS as safePoint with TTL seconds.
 func UpdateServiceSafePoint(ctx context.Context, pdClient pd.Client, sp BRServiceSafePoint) error {
 	log.Debug("update PD safePoint limit with TTL",
@@ -90,6 +144,14 @@ func UpdateServiceSafePoint(ctx context.Context, pdClient pd.Client, sp BRServic
 	return errors.Trace(err)
 }
 
+// RemoveServiceSafePoint removes the service safe point of the ID from PD.
+func RemoveServiceSafePoint(ctx context.Context, pdClient pd.Client, id string) error {
+	log.Info("remove PD service safePoint", zap.String("ID", id))
+	// A non-positive TTL removes the service safe point.
+	_, err := pdClient.UpdateServiceGCSafePoint(ctx, id, 0, 0)
+	return errors.Trace(err)
+}
+
 // StartServiceSafePointKeeper will run UpdateServiceSafePoint periodicity
 // hence keeping service safepoint won't lose.
 func StartServiceSafePointKeeper(
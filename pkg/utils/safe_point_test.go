@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/store/tikv/oracle"
 	"github.com/pingcap/tidb/util/testleak"
 	pd "github.com/tikv/pd/client"
 
@@ -44,10 +45,54 @@ func (s *testSafePointSuite) TestCheckGCSafepoint(c *C) {
 	}
 }
 
+func (s *testSafePointSuite) TestGCWindow(c *C) {
+	ctx := context.Background()
+	pdClient := &mockSafePoint{safepoint: 2333, physical: 1}
+	window, err := utils.GetGCWindow(ctx, pdClient)
+	c.Assert(err, IsNil)
+	currentTS := oracle.ComposeTS(1, 0)
+	c.Assert(window, Equals, utils.GCWindow{SafePoint: 2333, CurrentTS: currentTS})
+	c.Assert(window.Check("backup TS", 2333+1), IsNil)
+	c.Assert(window.Check("backup TS", currentTS), IsNil)
+	c.Assert(window.Check("last backup TS", 2333), ErrorMatches,
+		".*last backup TS 2333 .* is out of the GC window, the safe TS range is \\(2333 .*, 262144 .*\\].*")
+	// The snapshot in the future isn't collected by GC.
+	c.Assert(window.Check("backup TS", currentTS+1), IsNil)
+
+	sp := utils.BRServiceSafePoint{ID: "br", TTL: 300, BackupTS: 3000}
+	c.Assert(utils.PushServiceSafePoint(ctx, pdClient, sp), IsNil)
+	c.Assert(pdClient.serviceSafePoint, Equals, uint64(2999))
+	// The GC safe point has passed the backup TS.
+	sp.BackupTS = 2000
+	c.Assert(utils.PushServiceSafePoint(ctx, pdClient, sp), ErrorMatches,
+		".*PD can't hold the service safe point at TS 2000 .*")
+}
+
 type mockSafePoint struct {
 	sync.Mutex
 	pd.Client
-	safepoint uint64
+	safepoint        uint64
+	serviceSafePoint uint64
+	physical         int64
+}
+
+func (m *mockSafePoint) GetTS(ctx context.Context) (int64, int64, error) {
+	return m.physical, 0, nil
+}
+
+// UpdateServiceGCSafePoint mocks PD, which returns the min service safe point
+// without updating it if the safe point is behind the GC safe point.
+func (m *mockSafePoint) UpdateServiceGCSafePoint(
+	ctx context.Context, serviceID string, ttl int64, safePoint uint64,
+) (uint64, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if safePoint < m.safepoint {
+		return m.safepoint, nil
+	}
+	m.serviceSafePoint = safePoint
+	return safePoint, nil
 }
 
 func (m *mockSafePoint) UpdateGCSafePoint(ctx context.Context, safePoint uint64) (uint64, error) {
@@ -0,0 +1,95 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/pingcap/errors"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// SettingsFile represents the file name of the cluster settings snapshot.
+const SettingsFile = "backup.settings"
+
+// ClusterSettings is a snapshot of the settings of the cluster at backup
+// time, it's used to configure a DR cluster like the source.
+type ClusterSettings struct {
+	// GlobalVariables are the global system variables of TiDB.
+	GlobalVariables map[string]string `json:"global-variables"`
+	// PDScheduleConfig is the schedule config of PD.
+	PDScheduleConfig map[string]interface{} `json:"pd-schedule-config"`
+}
+
+// SettingChange is a setting whose value in the snapshot differs from the
+// current one.
+type SettingChange struct {
+	// Kind is either "variable" or "pd".
+	Kind    string
+	Name    string
+	Current interface{}
+	Backup  interface{}
+}
+
+// String implements fmt.Stringer.
+func (c SettingChange) String() string {
+	return fmt.Sprintf("[%s] %s: %v -> %v", c.Kind, c.Name, c.Current, c.Backup)
+}
+
+// SaveClusterSettings writes the cluster settings to the storage.
+func SaveClusterSettings(ctx context.Context, s storage.ExternalStorage, settings *ClusterSettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.Write(ctx, SettingsFile, data))
+}
+
+// ReadClusterSettings reads the cluster settings from the storage. It returns
+// nil if the backup doesn't contain a snapshot of the settings.
+func ReadClusterSettings(ctx context.Context, s storage.ExternalStorage) (*ClusterSettings, error) {
+	exists, err := s.FileExists(ctx, SettingsFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.Read(ctx, SettingsFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	settings := &ClusterSettings{}
+	if err = json.Unmarshal(data, settings); err != nil {
+		return nil, errors.Annotatef(err, "failed to parse %s", SettingsFile)
+	}
+	return settings, nil
+}
+
+// Diff returns the settings in the snapshot which differ from the current
+// ones, sorted by kind and name. Settings missing in the current cluster are
+// ignored, since they can't be applied.
+func (s *ClusterSettings) Diff(current *ClusterSettings) []SettingChange {
+	changes := make([]SettingChange, 0)
+	for name, value := range s.GlobalVariables {
+		cur, ok := current.GlobalVariables[name]
+		if ok && cur != value {
+			changes = append(changes, SettingChange{Kind: "variable", Name: name, Current: cur, Backup: value})
+		}
+	}
+	for name, value := range s.PDScheduleConfig {
+		cur, ok := current.PDScheduleConfig[name]
+		if ok && !reflect.DeepEqual(cur, value) {
+			changes = append(changes, SettingChange{Kind: "pd", Name: name, Current: cur, Backup: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind > changes[j].Kind
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
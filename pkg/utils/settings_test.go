@@ -0,0 +1,56 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+type testSettingsSuite struct{}
+
+var _ = Suite(&testSettingsSuite{})
+
+func (s *testSettingsSuite) TestClusterSettings(c *C) {
+	ctx := context.Background()
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	settings, err := ReadClusterSettings(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(settings, IsNil)
+
+	backupSettings := &ClusterSettings{
+		GlobalVariables: map[string]string{
+			"tidb_gc_life_time": "24h",
+			"max_connections":   "0",
+			"tidb_removed_var":  "1",
+		},
+		PDScheduleConfig: map[string]interface{}{
+			"leader-schedule-limit": float64(8),
+			"region-schedule-limit": float64(2048),
+		},
+	}
+	c.Assert(SaveClusterSettings(ctx, store, backupSettings), IsNil)
+	settings, err = ReadClusterSettings(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(settings, DeepEquals, backupSettings)
+
+	current := &ClusterSettings{
+		GlobalVariables: map[string]string{
+			"tidb_gc_life_time": "10m",
+			"max_connections":   "0",
+		},
+		PDScheduleConfig: map[string]interface{}{
+			"leader-schedule-limit": float64(4),
+			"region-schedule-limit": float64(2048),
+		},
+	}
+	c.Assert(settings.Diff(current), DeepEquals, []SettingChange{
+		{Kind: "variable", Name: "tidb_gc_life_time", Current: "10m", Backup: "24h"},
+		{Kind: "pd", Name: "leader-schedule-limit", Current: float64(4), Backup: float64(8)},
+	})
+}
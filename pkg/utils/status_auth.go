@@ -0,0 +1,120 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// StatusServerConfig is the TLS and the client authentication of the status
+// server, so it can be exposed on shared hosts safely.
+type StatusServerConfig struct {
+	// CertPath and KeyPath enable TLS.
+	CertPath string
+	KeyPath  string
+	// CAPath enables mTLS, the clients must present certificates signed by the
+	// CA.
+	CAPath string
+	// Token enables the bearer token authentication, the clients must send the
+	// header `Authorization: Bearer <token>`.
+	Token string
+}
+
+var statusServerConfig StatusServerConfig
+
+// SetStatusServerConfig validates and sets the config of the status server,
+// it should be called before the status server starts.
+func SetStatusServerConfig(cfg StatusServerConfig) error {
+	if _, err := cfg.tlsConfig(); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Token != "" && cfg.CertPath == "" {
+		log.Warn("the token of the status server is sent in plain text, enable TLS to protect it")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	statusServerConfig = cfg
+	return nil
+}
+
+// tlsConfig returns the TLS config of the server, or nil if TLS is disabled.
+func (cfg *StatusServerConfig) tlsConfig() (*tls.Config, error) {
+	if (cfg.CertPath == "") != (cfg.KeyPath == "") {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument,
+			"the cert and key of the status server must be provided together")
+	}
+	if cfg.CertPath == "" {
+		if cfg.CAPath != "" {
+			return nil, errors.Annotate(berrors.ErrInvalidArgument,
+				"the CA of the status server requires the cert and key")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertPath, cfg.KeyPath)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to load the cert of the status server")
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.CAPath != "" {
+		ca, err := ioutil.ReadFile(cfg.CAPath)
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to read the CA of the status server")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "no certificate found in %s", cfg.CAPath)
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// wrapListener wraps the listener by TLS if enabled.
+func (cfg *StatusServerConfig) wrapListener(listener net.Listener) (net.Listener, error) {
+	conf, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if conf == nil {
+		return listener, nil
+	}
+	return tls.NewListener(listener, conf), nil
+}
+
+// authenticated returns whether the clients are authenticated by the token or
+// the client certificates.
+func (cfg *StatusServerConfig) authenticated() bool {
+	return cfg.Token != "" || cfg.CAPath != ""
+}
+
+// wrapHandler rejects the requests without the token if enabled.
+func (cfg *StatusServerConfig) wrapHandler(handler http.Handler) http.Handler {
+	if cfg.Token == "" {
+		return handler
+	}
+	expected := []byte("Bearer " + cfg.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := strings.TrimSpace(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare([]byte(auth), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="br"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
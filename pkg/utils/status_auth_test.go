@@ -0,0 +1,84 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+type testStatusAuthSuite struct{}
+
+var _ = Suite(&testStatusAuthSuite{})
+
+func (*testStatusAuthSuite) TestStatusServerToken(c *C) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	cfg := StatusServerConfig{Token: "secret"}
+	handler := cfg.wrapHandler(ok)
+
+	for _, auth := range []string{"", "secret", "Bearer wrong", "Basic c2VjcmV0"} {
+		req := httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		c.Assert(resp.Code, Equals, http.StatusUnauthorized, Commentf("%q", auth))
+		c.Assert(resp.Header().Get("WWW-Authenticate"), Matches, "Bearer.*")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	c.Assert(resp.Code, Equals, http.StatusOK)
+}
+
+func (*testStatusAuthSuite) TestStatusServerMutualTLS(c *C) {
+	dir := c.MkDir()
+	writeCertPair(c, dir, 1, time.Now())
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	for _, cfg := range []StatusServerConfig{
+		{CertPath: certPath},
+		{KeyPath: keyPath},
+		{CAPath: certPath},
+	} {
+		_, err := cfg.tlsConfig()
+		c.Assert(err, ErrorMatches, ".*status server.*")
+	}
+
+	// The self-signed certificate is the CA of itself.
+	cfg := StatusServerConfig{CertPath: certPath, KeyPath: keyPath, CAPath: certPath}
+	rawListener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	listener, err := cfg.wrapListener(rawListener)
+	c.Assert(err, IsNil)
+	server := &http.Server{Handler: cfg.wrapHandler(http.NotFoundHandler())}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+	url := "https://" + listener.Addr().String() + "/"
+
+	clientWith := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			Certificates: certs,
+			// The test certificate isn't issued for the address.
+			InsecureSkipVerify: true, // nolint:gosec
+		}}}
+	}
+	_, err = clientWith().Get(url)
+	c.Assert(err, NotNil)
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	c.Assert(err, IsNil)
+	resp, err := clientWith(cert).Get(url)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusNotFound)
+}
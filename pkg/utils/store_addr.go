@@ -0,0 +1,105 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// storeAddrRegexPrefix marks the rule rewriting the addresses by a regexp.
+const storeAddrRegexPrefix = "~"
+
+type storeAddrRewrite struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// StoreAddrMap maps the store addresses reported by PD to the addresses
+// reachable from BR, e.g. in NAT'd or Kubernetes environments.
+type StoreAddrMap struct {
+	exact    map[string]string
+	rewrites []storeAddrRewrite
+}
+
+// ParseStoreAddrMap parses the rules of the store address map, each rule is
+// either `from=to` mapping an address exactly, e.g.
+// `10.0.0.5:20160=tikv-0.tikv:20160`, or `~regexp=replacement` rewriting the
+// matched addresses, e.g. `~^10\.0\.0\.(\d+):(\d+)$=tikv-$1.tikv:$2`. The exact
+// rules take precedence, then the first matched rewrite applies.
+func ParseStoreAddrMap(rules []string) (*StoreAddrMap, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	m := &StoreAddrMap{exact: make(map[string]string)}
+	for _, rule := range rules {
+		sep := strings.Index(rule, "=")
+		if sep <= 0 || sep == len(rule)-1 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"invalid store address map %q, must be `from=to` or `~regexp=replacement`", rule)
+		}
+		from, to := rule[:sep], rule[sep+1:]
+		if !strings.HasPrefix(from, storeAddrRegexPrefix) {
+			m.exact[from] = to
+			continue
+		}
+		re, err := regexp.Compile(strings.TrimPrefix(from, storeAddrRegexPrefix))
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"invalid regexp in store address map %q: %s", rule, err)
+		}
+		m.rewrites = append(m.rewrites, storeAddrRewrite{re: re, repl: to})
+	}
+	return m, nil
+}
+
+// Map returns the address mapped from the store address, the address is
+// returned as is if no rule matches. A nil map maps nothing.
+func (m *StoreAddrMap) Map(addr string) string {
+	if m == nil {
+		return addr
+	}
+	if to, ok := m.exact[addr]; ok {
+		return to
+	}
+	for _, rewrite := range m.rewrites {
+		if rewrite.re.MatchString(addr) {
+			return rewrite.re.ReplaceAllString(addr, rewrite.repl)
+		}
+	}
+	return addr
+}
+
+// storeAddrMap is the store address map of all BR clients.
+var storeAddrMap = struct {
+	mu sync.RWMutex
+	m  *StoreAddrMap
+}{}
+
+// SetStoreAddrMap sets the store address map of all BR clients, it applies to
+// the connections created afterwards.
+func SetStoreAddrMap(m *StoreAddrMap) {
+	storeAddrMap.mu.Lock()
+	storeAddrMap.m = m
+	storeAddrMap.mu.Unlock()
+}
+
+// MapStoreAddr returns the address to dial the store at by the store address
+// map.
+func MapStoreAddr(addr string) string {
+	storeAddrMap.mu.RLock()
+	m := storeAddrMap.m
+	storeAddrMap.mu.RUnlock()
+	mapped := m.Map(addr)
+	if mapped != addr {
+		log.Debug("store address mapped", zap.String("from", addr), zap.String("to", mapped))
+	}
+	return mapped
+}
@@ -0,0 +1,35 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	. "github.com/pingcap/check"
+)
+
+type testStoreAddrSuite struct{}
+
+var _ = Suite(&testStoreAddrSuite{})
+
+func (s *testStoreAddrSuite) TestStoreAddrMap(c *C) {
+	m, err := ParseStoreAddrMap(nil)
+	c.Assert(err, IsNil)
+	c.Assert(m.Map("10.0.0.5:20160"), Equals, "10.0.0.5:20160")
+
+	m, err = ParseStoreAddrMap([]string{
+		"10.0.0.5:20160=tikv-a.tikv:20160",
+		`~^10\.0\.0\.(\d+):(\d+)$=tikv-$1.tikv:$2`,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(m.Map("10.0.0.5:20160"), Equals, "tikv-a.tikv:20160")
+	c.Assert(m.Map("10.0.0.6:20161"), Equals, "tikv-6.tikv:20161")
+	c.Assert(m.Map("10.0.1.6:20160"), Equals, "10.0.1.6:20160")
+
+	SetStoreAddrMap(m)
+	defer SetStoreAddrMap(nil)
+	c.Assert(MapStoreAddr("10.0.0.7:20160"), Equals, "tikv-7.tikv:20160")
+
+	for _, rule := range []string{"10.0.0.5:20160", "=tikv-0:20160", "10.0.0.5:20160=", "~(=x"} {
+		_, err = ParseStoreAddrMap([]string{rule})
+		c.Assert(err, ErrorMatches, ".*invalid.*", Commentf("rule %s", rule))
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// CertReloader loads the client certificate from the files, and loads it
+// again once the files are modified, so the certificates rotated during a
+// long-running task are used by the new connections.
+type CertReloader struct {
+	certPath string
+	keyPath  string
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// NewCertReloader creates a CertReloader and loads the certificate.
+func NewCertReloader(certPath, keyPath string) (*CertReloader, error) {
+	r := &CertReloader{certPath: certPath, keyPath: keyPath}
+	if _, err := r.load(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return r, nil
+}
+
+func modTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, errors.Trace(err)
+	}
+	return info.ModTime(), nil
+}
+
+// load reloads the certificate if the files are modified since last load.
+func (r *CertReloader) load() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	certModTime, err := modTime(r.certPath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	keyModTime, err := modTime(r.keyPath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if r.cert != nil && certModTime.Equal(r.certModTime) && keyModTime.Equal(r.keyModTime) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if r.cert != nil {
+		log.Info("client certificate reloaded",
+			zap.String("cert", r.certPath), zap.String("key", r.keyPath))
+	}
+	r.cert = &cert
+	r.certModTime = certModTime
+	r.keyModTime = keyModTime
+	return r.cert, nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate.
+//
+// If the modified files can't be loaded, e.g. the cert is written but the
+// key isn't yet, the last loaded certificate is used.
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert, err := r.load()
+	if err == nil {
+		return cert, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cert == nil {
+		return nil, errors.Trace(err)
+	}
+	log.Warn("failed to reload client certificate, use the last one",
+		zap.String("cert", r.certPath), zap.String("key", r.keyPath), zap.Error(err))
+	return r.cert, nil
+}
+
+// Apply makes the tls config get the client certificate from the reloader.
+func (r *CertReloader) Apply(conf *tls.Config) {
+	conf.Certificates = nil
+	conf.GetClientCertificate = r.GetClientCertificate
+}
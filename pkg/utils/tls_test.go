@@ -0,0 +1,70 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+type testTLSSuite struct{}
+
+var _ = Suite(&testTLSSuite{})
+
+func writeCertPair(c *C, dir string, serial int64, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "br"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	c.Assert(err, IsNil)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	c.Assert(err, IsNil)
+
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	c.Assert(ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600), IsNil)
+	c.Assert(ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600), IsNil)
+	c.Assert(os.Chtimes(certPath, modTime, modTime), IsNil)
+	c.Assert(os.Chtimes(keyPath, modTime, modTime), IsNil)
+}
+
+func serialOf(c *C, r *CertReloader) int64 {
+	cert, err := r.GetClientCertificate(nil)
+	c.Assert(err, IsNil)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	c.Assert(err, IsNil)
+	return leaf.SerialNumber.Int64()
+}
+
+func (s *testTLSSuite) TestCertReloader(c *C) {
+	dir := c.MkDir()
+	now := time.Now()
+	writeCertPair(c, dir, 1, now.Add(-time.Minute))
+	r, err := NewCertReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	c.Assert(err, IsNil)
+	c.Assert(serialOf(c, r), Equals, int64(1))
+
+	// the rotated certificate is picked up.
+	writeCertPair(c, dir, 2, now)
+	c.Assert(serialOf(c, r), Equals, int64(2))
+
+	// the broken files are ignored.
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "key.pem"), []byte("half written"), 0o600), IsNil)
+	c.Assert(serialOf(c, r), Equals, int64(2))
+}
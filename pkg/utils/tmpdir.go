@@ -0,0 +1,226 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/logutil"
+)
+
+const (
+	// tmpDirPrefix is the prefix of the temporary directories of the tasks,
+	// which are named by the task IDs.
+	tmpDirPrefix = "br-tmp-"
+	// tmpDirHeartbeat is the file touched periodically by the running task,
+	// the directory is stale once it isn't touched for tmpDirStaleAfter.
+	tmpDirHeartbeat         = ".heartbeat"
+	tmpDirHeartbeatInterval = 10 * time.Second
+	tmpDirStaleAfter        = time.Minute
+)
+
+var (
+	tmpDirUsedBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "br",
+			Subsystem: "tmp_dir",
+			Name:      "used_bytes",
+			Help:      "Bytes of the files staged in the temporary directories.",
+		})
+
+	tmpDirQuotaBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "br",
+			Subsystem: "tmp_dir",
+			Name:      "quota_bytes",
+			Help:      "Total quota of the temporary directories, 0 means no limit.",
+		})
+)
+
+func init() { // nolint:gochecknoinits
+	prometheus.MustRegister(tmpDirUsedBytes)
+	prometheus.MustRegister(tmpDirQuotaBytes)
+}
+
+// TempDir is the temporary directory of a task for staging the data on the
+// local disk, the total bytes of the files in it are limited by the quota.
+// The directory is removed on Close, and the ones left by the crashed tasks
+// are removed by the next task using the same root.
+type TempDir struct {
+	path  string
+	quota uint64
+	used  uint64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewTempDir creates the temporary directory of the task of the context under
+// the root, the default root is the temporary directory of the OS. The quota is
+// the max total bytes of the files in it, 0 means no limit.
+func NewTempDir(ctx context.Context, root string, quota uint64) (*TempDir, error) {
+	if root == "" {
+		root = os.TempDir()
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, errors.Annotatef(err, "failed to create temporary directory %s", root)
+	}
+	if _, err := CleanStaleTempDirs(root); err != nil {
+		log.Warn("failed to clean the stale temporary directories", zap.String("root", root), zap.Error(err))
+	}
+	path := filepath.Join(root, tmpDirPrefix+logutil.TaskIDFromContext(ctx))
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, errors.Annotatef(err, "failed to create temporary directory %s", path)
+	}
+	heartbeat := filepath.Join(path, tmpDirHeartbeat)
+	if err := ioutil.WriteFile(heartbeat, nil, 0o600); err != nil {
+		_ = os.RemoveAll(path)
+		return nil, errors.Annotatef(err, "failed to create temporary directory %s", path)
+	}
+	// The heartbeat lasts until Close, regardless of the context.
+	heartbeatCtx, cancel := context.WithCancel(context.Background())
+	d := &TempDir{path: path, quota: quota, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(tmpDirHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-heartbeatCtx.Done():
+				return
+			case <-ticker.C:
+				now := time.Now()
+				if err := os.Chtimes(heartbeat, now, now); err != nil {
+					log.Warn("failed to touch the temporary directory", zap.String("path", path), zap.Error(err))
+				}
+			}
+		}
+	}()
+	// The gauges sum up the temporary directories of the concurrent tasks.
+	tmpDirQuotaBytes.Add(float64(quota))
+	log.Info("temporary directory created", zap.String("path", path), zap.Uint64("quota", quota))
+	return d, nil
+}
+
+// Path returns the path of the temporary directory.
+func (d *TempDir) Path() string {
+	return d.path
+}
+
+// Used returns the total bytes of the files in the temporary directory.
+func (d *TempDir) Used() uint64 {
+	return atomic.LoadUint64(&d.used)
+}
+
+func (d *TempDir) reserve(n uint64) error {
+	used := atomic.AddUint64(&d.used, n)
+	if d.quota > 0 && used > d.quota {
+		atomic.AddUint64(&d.used, ^(n - 1))
+		return errors.Annotatef(berrors.ErrTempDirQuotaExceeded,
+			"%s of %s used by %s", formatBytes(used-n), formatBytes(d.quota), d.path)
+	}
+	tmpDirUsedBytes.Add(float64(n))
+	return nil
+}
+
+func (d *TempDir) release(n uint64) {
+	atomic.AddUint64(&d.used, ^(n - 1))
+	tmpDirUsedBytes.Sub(float64(n))
+}
+
+// Create creates the file of the name in the temporary directory.
+func (d *TempDir) Create(name string) (*TempFile, error) {
+	f, err := os.Create(filepath.Join(d.path, name))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &TempFile{File: f, dir: d}, nil
+}
+
+// Close removes the temporary directory.
+func (d *TempDir) Close() error {
+	d.cancel()
+	<-d.done
+	tmpDirUsedBytes.Sub(float64(atomic.SwapUint64(&d.used, 0)))
+	tmpDirQuotaBytes.Sub(float64(d.quota))
+	return errors.Trace(os.RemoveAll(d.path))
+}
+
+// TempFile is a file in the temporary directory, its writes are limited by
+// the quota of the directory.
+type TempFile struct {
+	*os.File
+	dir  *TempDir
+	size uint64
+}
+
+// Write implements io.Writer.
+func (f *TempFile) Write(p []byte) (int, error) {
+	if err := f.dir.reserve(uint64(len(p))); err != nil {
+		return 0, errors.Trace(err)
+	}
+	n, err := f.File.Write(p)
+	f.size += uint64(n)
+	if n < len(p) {
+		f.dir.release(uint64(len(p) - n))
+	}
+	return n, errors.Trace(err)
+}
+
+// Size returns the bytes written into the file.
+func (f *TempFile) Size() uint64 {
+	return f.size
+}
+
+// Remove closes and removes the file, its bytes are given back to the quota.
+func (f *TempFile) Remove() error {
+	_ = f.File.Close()
+	err := os.Remove(f.Name())
+	f.dir.release(f.size)
+	f.size = 0
+	return errors.Trace(err)
+}
+
+// CleanStaleTempDirs removes the temporary directories under the root left by
+// the tasks gone, i.e. whose heartbeats have stopped. It returns the count of
+// the directories removed.
+func CleanStaleTempDirs(root string) (int, error) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), tmpDirPrefix) {
+			continue
+		}
+		path := filepath.Join(root, entry.Name())
+		lastBeat := entry.ModTime()
+		if info, err := os.Stat(filepath.Join(path, tmpDirHeartbeat)); err == nil {
+			lastBeat = info.ModTime()
+		}
+		if time.Since(lastBeat) < tmpDirStaleAfter {
+			continue
+		}
+		log.Warn("remove the temporary directory left by a task gone",
+			zap.String("path", path), zap.String("task-id", strings.TrimPrefix(entry.Name(), tmpDirPrefix)),
+			zap.Time("last-heartbeat", lastBeat))
+		if err = os.RemoveAll(path); err != nil {
+			return removed, errors.Trace(err)
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+type testTempDirSuite struct{}
+
+var _ = Suite(&testTempDirSuite{})
+
+func (s *testTempDirSuite) TestQuota(c *C) {
+	dir, err := NewTempDir(context.Background(), c.MkDir(), 10)
+	c.Assert(err, IsNil)
+	defer dir.Close()
+
+	f1, err := dir.Create("f1")
+	c.Assert(err, IsNil)
+	_, err = f1.Write([]byte("123456"))
+	c.Assert(err, IsNil)
+	f2, err := dir.Create("f2")
+	c.Assert(err, IsNil)
+	_, err = f2.Write([]byte("12345"))
+	c.Assert(err, ErrorMatches, ".*temporary directory quota exceeded.*")
+	_, err = f2.Write([]byte("1234"))
+	c.Assert(err, IsNil)
+	c.Assert(dir.Used(), Equals, uint64(10))
+
+	// The bytes of the removed file are given back.
+	c.Assert(f1.Remove(), IsNil)
+	c.Assert(dir.Used(), Equals, uint64(4))
+	_, err = f2.Write([]byte("123456"))
+	c.Assert(err, IsNil)
+
+	path := dir.Path()
+	c.Assert(dir.Close(), IsNil)
+	_, err = os.Stat(path)
+	c.Assert(os.IsNotExist(err), IsTrue)
+}
+
+func (s *testTempDirSuite) TestCleanStaleTempDirs(c *C) {
+	root := c.MkDir()
+	stale := filepath.Join(root, tmpDirPrefix+"stale")
+	c.Assert(os.Mkdir(stale, 0o700), IsNil)
+	heartbeat := filepath.Join(stale, tmpDirHeartbeat)
+	c.Assert(ioutil.WriteFile(heartbeat, nil, 0o600), IsNil)
+	lastBeat := time.Now().Add(-2 * tmpDirStaleAfter)
+	c.Assert(os.Chtimes(heartbeat, lastBeat, lastBeat), IsNil)
+	other := filepath.Join(root, "other")
+	c.Assert(os.Mkdir(other, 0o700), IsNil)
+
+	// The directory of the running task is kept.
+	dir, err := NewTempDir(context.Background(), root, 0)
+	c.Assert(err, IsNil)
+	defer dir.Close()
+	_, err = os.Stat(stale)
+	c.Assert(os.IsNotExist(err), IsTrue)
+	n, err := CleanStaleTempDirs(root)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+	_, err = os.Stat(dir.Path())
+	c.Assert(err, IsNil)
+	_, err = os.Stat(other)
+	c.Assert(err, IsNil)
+}
@@ -0,0 +1,164 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// TopologyFile represents the file name of the cluster topology snapshot.
+const TopologyFile = "backup.topology"
+
+// ClusterTopology is a snapshot of the topology of the cluster at backup
+// time, it's used to tell whether the restore target differs materially from
+// the source.
+type ClusterTopology struct {
+	Stores []StoreTopology `json:"stores"`
+	// RegionCount is the count of the regions of the cluster, or -1 if unknown.
+	RegionCount int `json:"region-count"`
+}
+
+// StoreTopology is a store in the topology snapshot.
+type StoreTopology struct {
+	ID      uint64            `json:"id"`
+	Address string            `json:"address"`
+	Version string            `json:"version"`
+	TiFlash bool              `json:"tiflash,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// NewClusterTopology takes a snapshot of the stores, the tombstone stores are
+// ignored.
+func NewClusterTopology(stores []*metapb.Store, regionCount int) *ClusterTopology {
+	topology := &ClusterTopology{
+		Stores:      make([]StoreTopology, 0, len(stores)),
+		RegionCount: regionCount,
+	}
+	for _, store := range stores {
+		if store.GetState() == metapb.StoreState_Tombstone {
+			continue
+		}
+		s := StoreTopology{
+			ID:      store.GetId(),
+			Address: store.GetAddress(),
+			Version: store.GetVersion(),
+			TiFlash: IsTiFlash(store),
+		}
+		for _, label := range store.GetLabels() {
+			if s.Labels == nil {
+				s.Labels = make(map[string]string)
+			}
+			s.Labels[label.GetKey()] = label.GetValue()
+		}
+		topology.Stores = append(topology.Stores, s)
+	}
+	sort.Slice(topology.Stores, func(i, j int) bool {
+		return topology.Stores[i].ID < topology.Stores[j].ID
+	})
+	return topology
+}
+
+// SaveClusterTopology writes the cluster topology to the storage.
+func SaveClusterTopology(ctx context.Context, s storage.ExternalStorage, topology *ClusterTopology) error {
+	data, err := json.Marshal(topology)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.Write(ctx, TopologyFile, data))
+}
+
+// ReadClusterTopology reads the cluster topology from the storage. It returns
+// nil if the backup doesn't contain a snapshot of the topology.
+func ReadClusterTopology(ctx context.Context, s storage.ExternalStorage) (*ClusterTopology, error) {
+	exists, err := s.FileExists(ctx, TopologyFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.Read(ctx, TopologyFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	topology := &ClusterTopology{}
+	if err = json.Unmarshal(data, topology); err != nil {
+		return nil, errors.Annotatef(err, "failed to parse %s", TopologyFile)
+	}
+	return topology, nil
+}
+
+// StoreCount returns the count of the TiKV and TiFlash stores.
+func (t *ClusterTopology) StoreCount() (tikv int, tiflash int) {
+	for _, s := range t.Stores {
+		if s.TiFlash {
+			tiflash++
+		} else {
+			tikv++
+		}
+	}
+	return
+}
+
+// versions returns the sorted distinct versions of the TiKV stores.
+func (t *ClusterTopology) versions() []string {
+	set := make(map[string]struct{})
+	for _, s := range t.Stores {
+		if !s.TiFlash {
+			set[s.Version] = struct{}{}
+		}
+	}
+	versions := make([]string, 0, len(set))
+	for v := range set {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// labelKeys returns the sorted distinct label keys of the stores.
+func (t *ClusterTopology) labelKeys() []string {
+	set := make(map[string]struct{})
+	for _, s := range t.Stores {
+		for key := range s.Labels {
+			set[key] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Diff returns the material differences of the target topology from the
+// snapshot, e.g. restoring a 30-store backup into 3 stores.
+func (t *ClusterTopology) Diff(target *ClusterTopology) []string {
+	diffs := make([]string, 0)
+	tikv, tiflash := t.StoreCount()
+	targetTiKV, targetTiFlash := target.StoreCount()
+	if tikv != targetTiKV {
+		diffs = append(diffs, fmt.Sprintf("TiKV stores: %d -> %d", tikv, targetTiKV))
+	}
+	if tiflash > 0 && targetTiFlash == 0 {
+		diffs = append(diffs, fmt.Sprintf("TiFlash stores: %d -> 0", tiflash))
+	}
+	versions, targetVersions := t.versions(), target.versions()
+	if strings.Join(versions, ",") != strings.Join(targetVersions, ",") {
+		diffs = append(diffs, fmt.Sprintf("TiKV versions: %s -> %s",
+			strings.Join(versions, ","), strings.Join(targetVersions, ",")))
+	}
+	keys, targetKeys := t.labelKeys(), target.labelKeys()
+	if strings.Join(keys, ",") != strings.Join(targetKeys, ",") {
+		diffs = append(diffs, fmt.Sprintf("store label keys: [%s] -> [%s]",
+			strings.Join(keys, ","), strings.Join(targetKeys, ",")))
+	}
+	return diffs
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+type testTopologySuite struct{}
+
+var _ = Suite(&testTopologySuite{})
+
+func (s *testTopologySuite) TestClusterTopology(c *C) {
+	ctx := context.Background()
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	topology, err := ReadClusterTopology(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(topology, IsNil)
+
+	zone := func(z string) []*metapb.StoreLabel {
+		return []*metapb.StoreLabel{{Key: "zone", Value: z}}
+	}
+	source := NewClusterTopology([]*metapb.Store{
+		{Id: 3, Address: "tikv3:20160", Version: "v4.0.9", Labels: zone("z3")},
+		{Id: 1, Address: "tikv1:20160", Version: "v4.0.9", Labels: zone("z1")},
+		{Id: 2, Address: "tikv2:20160", Version: "v4.0.9", Labels: zone("z2")},
+		{Id: 4, Address: "tiflash:3930", Version: "v4.0.9", Labels: []*metapb.StoreLabel{{Key: "engine", Value: "tiflash"}}},
+		{Id: 5, State: metapb.StoreState_Tombstone},
+	}, 1024)
+	c.Assert(source.Stores, HasLen, 4)
+	c.Assert(source.Stores[0].ID, Equals, uint64(1))
+	c.Assert(SaveClusterTopology(ctx, store, source), IsNil)
+	topology, err = ReadClusterTopology(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(topology, DeepEquals, source)
+
+	c.Assert(source.Diff(source), HasLen, 0)
+	target := NewClusterTopology([]*metapb.Store{
+		{Id: 1, Address: "tikv1:20160", Version: "v4.0.10"},
+	}, 1)
+	c.Assert(source.Diff(target), DeepEquals, []string{
+		"TiKV stores: 3 -> 1",
+		"TiFlash stores: 1 -> 0",
+		"TiKV versions: v4.0.9 -> v4.0.10",
+		"store label keys: [engine,zone] -> []",
+	})
+}